@@ -0,0 +1,101 @@
+// cmd/tracewrap/analyze.go
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/traceio"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	analyzeTrace       string
+	analyzePasses      []string
+	analyzePassTimeout time.Duration
+	analyzeStatus      string
+)
+
+// analyzeCmd is the parent command for reports computed from a recorded trace. Run directly, it
+// builds a report from the analysis passes selected with --pass.
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze",
+	Short: "Analyze a recorded trace.",
+	Long: `The analyze command serves as a parent for subcommands that read a trace file
+(tracewrap/latest/trace.jsonl) and summarize it, such as SLO violation reports.
+
+Run with --pass, it builds a report from analysis passes instead. Each pass receives the parsed
+trace and contributes sections to the report. Besides the built-in passes, any executable
+named tracewrap-pass-<name> on PATH is run for --pass <name>, or give a path to one. It reads
+{"protocolVersion":1,"trace":...,"metadata":...,"records":[...]} from standard input and writes
+{"sections":[{"title":...,"text":...,"columns":[...],"rows":[[...]]}]} to standard output.
+
+--status ok or --status error restricts this command and every subcommand to the calls with
+that span status, set from the HTTP status codes and gRPC codes of the calls.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(analyzePasses) == 0 {
+			cmd.Help()
+			return
+		}
+		passes := make([]analysis.Pass, 0, len(analyzePasses))
+		for _, name := range analyzePasses {
+			p, err := analysis.FindPass(name)
+			if err != nil {
+				fail("Error: %v", err)
+			}
+			passes = append(passes, p)
+		}
+		records, err := readAnalyzedTrace(analyzeTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		in := analysis.PassInput{Trace: analyzeTrace, Records: records}
+		meta, err := traceio.ReadMetadata(filepath.Join(filepath.Dir(analyzeTrace), "run.json"))
+		if err == nil {
+			in.Metadata = &meta
+		} else if !os.IsNotExist(err) {
+			fail("Error reading run metadata: %v", err)
+		}
+		sections, err := analysis.RunPasses(passes, in, analyzePassTimeout)
+		if err != nil {
+			fail("Error running analysis passes: %v", err)
+		}
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{"trace": analyzeTrace}, Findings: sections})
+			return
+		}
+		if err := analysis.WriteSections(os.Stdout, sections); err != nil {
+			fail("Error writing report: %v", err)
+		}
+	},
+}
+
+// readAnalyzedTrace reads the trace file at path and keeps the records with the span status
+// selected by --status.
+//
+// Parameters:
+//   - path (string): the trace file.
+//
+// Returns:
+//   - []tracer.TraceRecord: the records.
+//   - error: an error if the file cannot be read or --status is invalid.
+func readAnalyzedTrace(path string) ([]tracer.TraceRecord, error) {
+	records, err := tracer.ReadTraceFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return analysis.FilterStatus(records, analyzeStatus)
+}
+
+func init() {
+	rootCmd.AddCommand(analyzeCmd)
+	analyzeCmd.Flags().StringVar(&analyzeTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+	analyzeCmd.Flags().StringArrayVar(&analyzePasses, "pass", nil, "Analysis pass to run; repeat for several, e.g. --pass slo --pass mycompany-sla")
+	analyzeCmd.Flags().DurationVar(&analyzePassTimeout, "pass-timeout", time.Minute, "Time limit for each pass (0 for none)")
+	analyzeCmd.PersistentFlags().StringVar(&analyzeStatus, "status", "", "Only analyze calls with this span status: ok or error")
+}
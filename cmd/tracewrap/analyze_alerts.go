@@ -0,0 +1,114 @@
+// cmd/tracewrap/analyze_alerts.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/alert"
+	"github.com/mwiater/tracewrap/pkg/ghactions"
+	"github.com/mwiater/tracewrap/pkg/notify"
+	"github.com/spf13/cobra"
+)
+
+var (
+	alertsTrace  string
+	alertsConfig string
+	alertsDryRun bool
+)
+
+// alertsCmd is the subcommand under analyze for evaluating the alerting rules on a trace.
+var alertsCmd = &cobra.Command{
+	Use:   "alerts",
+	Short: "Evaluate the alerting rules of the configuration on a trace.",
+	Long: `alerts reads a trace file and evaluates the rules under alerts.rules in --config on it,
+such as
+
+  alerts:
+    webhook: https://hooks.slack.com/services/...
+    rules:
+      - name: divide-by-zero
+        when: function="divide" and returns contains "cannot divide by zero" more than 0 times
+
+Fired alerts are printed and posted to their webhook, unless --dry-run is given, and make the
+command exit with status 5, so it can gate CI pipelines. With --ci, a failed notification stops
+the command with status 1.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		rules, err := loadAlertRules(alertsConfig)
+		if err != nil {
+			failWith(exitConfig, "Error loading alerting rules: %v", err)
+		}
+		records, err := readAnalyzedTrace(alertsTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		alerts := alert.Evaluate(rules, records)
+		for _, a := range alerts {
+			function := ""
+			if len(a.Examples) > 0 {
+				function = a.Examples[0].FunctionName
+			}
+			annotate(ghactions.Error, function, "Alert fired: "+a.Rule, "%s matched %d calls, more than %d: %s", a.Rule, a.Matches, a.Threshold, a.When)
+		}
+		if jsonOutput {
+			res := commandResult{Status: statusOK, Artifacts: map[string]string{"trace": alertsTrace, "config": alertsConfig}, Findings: alerts}
+			if len(alerts) > 0 {
+				res.Status = statusFailed
+			}
+			writeResult(res)
+		} else if len(alerts) == 0 {
+			fmt.Printf("No alerts fired (%d rules, %d records)\n", len(rules), len(records))
+			return
+		} else if err := alert.Write(os.Stdout, alerts); err != nil {
+			fail("Error writing report: %v", err)
+		}
+		if len(alerts) == 0 {
+			return
+		}
+		if !alertsDryRun {
+			for _, a := range alerts {
+				if a.Webhook == "" {
+					continue
+				}
+				if err := notify.Send(nil, a.Webhook, a.Message(alertsTrace)); err != nil && ciMode {
+					fail("Error notifying %s: %v", a.Rule, err)
+				} else if err != nil {
+					fmt.Printf("Error notifying %s: %v\n", a.Rule, err)
+				}
+			}
+		}
+		os.Exit(exitRegression)
+	},
+}
+
+// loadAlertRules compiles the alerting rules of the configuration file at path.
+//
+// Parameters:
+//   - path (string): the configuration file.
+//
+// Returns:
+//   - []*alert.Rule: the rules.
+//   - error: an error if the file cannot be read or is invalid, or it declares no rules.
+func loadAlertRules(path string) ([]*alert.Rule, error) {
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if len(cfg.Alerts.Rules) == 0 {
+		return nil, fmt.Errorf("%s declares no alerts.rules", path)
+	}
+	return alert.Load(cfg.Alerts)
+}
+
+func init() {
+	analyzeCmd.AddCommand(alertsCmd)
+	alertsCmd.Flags().StringVar(&alertsTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+	alertsCmd.Flags().StringVar(&alertsConfig, "config", "tracewrap.yaml", "Path to the configuration file with the alerting rules")
+	alertsCmd.Flags().BoolVar(&alertsDryRun, "dry-run", false, "Print fired alerts without posting them to webhooks")
+}
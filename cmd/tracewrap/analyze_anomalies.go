@@ -0,0 +1,88 @@
+// cmd/tracewrap/analyze_anomalies.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/ghactions"
+	"github.com/mwiater/tracewrap/pkg/store"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	anomalyTrace      string
+	anomalyStore      string
+	anomalyRuns       int
+	anomalyThreshold  float64
+	anomalyMinSamples int
+)
+
+// anomaliesCmd is the subcommand under analyze for flagging calls with outlier durations.
+var anomaliesCmd = &cobra.Command{
+	Use:   "anomalies",
+	Short: "Flag calls that are much slower than in past runs.",
+	Long: `anomalies builds a baseline duration distribution per function from the runs in the store
+(see tracewrap store add) and lists the calls in the trace whose duration is a statistical
+outlier, together with their parameters to help find pathological inputs.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		s, err := store.Open(anomalyStore)
+		if err != nil {
+			fail("%v", err)
+		}
+		runs, err := s.Runs()
+		if err != nil {
+			fail("%v", err)
+		}
+		if anomalyRuns > 0 && len(runs) > anomalyRuns {
+			runs = runs[len(runs)-anomalyRuns:]
+		}
+		var history []tracer.TraceRecord
+		for _, run := range runs {
+			records, err := s.Records(run.ID)
+			if err != nil {
+				fail("Error reading run %s: %v", run.ID, err)
+			}
+			history = append(history, records...)
+		}
+		if len(history) == 0 && !jsonOutput {
+			fmt.Println("No history in", s.Dir(), "- add past traces with tracewrap store add")
+			return
+		}
+		records, err := readAnalyzedTrace(anomalyTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		opts := analysis.AnomalyOptions{Threshold: anomalyThreshold, MinSamples: anomalyMinSamples}
+		anomalies := analysis.DetectAnomalies(analysis.BuildBaseline(history), records, opts)
+		for _, a := range anomalies {
+			annotate(ghactions.Warning, a.Record.FunctionName, "Slow call of "+a.Record.FunctionName, "call %d took %v, %.1f deviations above the baseline median of %v",
+				a.Record.UniqueID, a.Record.Duration, a.Score, a.Median)
+		}
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{"trace": anomalyTrace, "store": s.Dir()}, Findings: anomalies})
+			return
+		}
+		fmt.Printf("Baseline: %d records from %d runs\n", len(history), len(runs))
+		if len(anomalies) == 0 {
+			fmt.Println("No anomalies found.")
+			return
+		}
+		if err := analysis.WriteAnomalies(os.Stdout, anomalies); err != nil {
+			fail("Error writing report: %v", err)
+		}
+	},
+}
+
+func init() {
+	analyzeCmd.AddCommand(anomaliesCmd)
+	anomaliesCmd.Flags().StringVar(&anomalyTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file to check")
+	anomaliesCmd.Flags().StringVar(&anomalyStore, "store", store.DefaultDir, "Path to the store with past runs")
+	anomaliesCmd.Flags().IntVar(&anomalyRuns, "runs", 0, "Use only the most recent N stored runs (0 for all)")
+	anomaliesCmd.Flags().Float64Var(&anomalyThreshold, "threshold", 3.5, "Minimum robust z-score of a flagged call")
+	anomaliesCmd.Flags().IntVar(&anomalyMinSamples, "min-samples", 10, "Minimum number of past calls of a function to check it")
+}
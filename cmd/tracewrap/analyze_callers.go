@@ -0,0 +1,103 @@
+// cmd/tracewrap/analyze_callers.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	neighborsTrace    string
+	neighborsFunction string
+	callersStacks     bool
+)
+
+// callersCmd is the subcommand under analyze for listing the callers of a function.
+var callersCmd = &cobra.Command{
+	Use:   "callers",
+	Short: "Show who called a function, how often, and how long the calls took.",
+	Long: `callers reads a trace file and groups the calls to --function by the function that made
+them, with the count and latency distribution of the calls from each caller. With --stacks, the
+calls are grouped by the stack they were made from instead, for functions listed in
+tracing.entryStacks; the stacks include callers that are not instrumented.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if callersStacks {
+			runEntryStacks()
+			return
+		}
+		runNeighbors("CALLER", "calls to", analysis.Callers)
+	},
+}
+
+// calleesCmd is the subcommand under analyze for listing the functions a function calls.
+var calleesCmd = &cobra.Command{
+	Use:   "callees",
+	Short: "Show what a function called, how often, and how long the calls took.",
+	Long: `callees reads a trace file and groups the direct calls made by --function by the function
+called, with the count and latency distribution of the calls to each callee.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runNeighbors("CALLEE", "calls from", analysis.Callees)
+	},
+}
+
+// runNeighbors reads the trace, applies find to it and prints the result.
+//
+// Parameters:
+//   - heading (string): the first column heading.
+//   - relation (string): "calls to" or "calls from", for the message printed when nothing matches.
+//   - find (func): analysis.Callers or analysis.Callees.
+func runNeighbors(heading, relation string, find func([]tracer.TraceRecord, string) []analysis.Neighbor) {
+	records, err := readAnalyzedTrace(neighborsTrace)
+	if err != nil {
+		fail("Error reading trace file: %v", err)
+	}
+	neighbors := find(records, neighborsFunction)
+	if jsonOutput {
+		writeFindings(neighborsTrace, neighbors)
+		return
+	}
+	if len(neighbors) == 0 {
+		fmt.Printf("No %s %s found in %s\n", relation, neighborsFunction, neighborsTrace)
+		return
+	}
+	if err := analysis.WriteNeighbors(os.Stdout, heading, neighbors); err != nil {
+		fail("Error writing report: %v", err)
+	}
+}
+
+// runEntryStacks reads the trace and prints the entry stacks of the calls to --function.
+func runEntryStacks() {
+	records, err := readAnalyzedTrace(neighborsTrace)
+	if err != nil {
+		fail("Error reading trace file: %v", err)
+	}
+	stacks := analysis.EntryStacks(records, neighborsFunction)
+	if jsonOutput {
+		writeFindings(neighborsTrace, stacks)
+		return
+	}
+	if len(stacks) == 0 {
+		fmt.Printf("No entry stacks of %s found in %s (list it in tracing.entryStacks)\n", neighborsFunction, neighborsTrace)
+		return
+	}
+	if err := analysis.WriteEntryStacks(os.Stdout, stacks); err != nil {
+		fail("Error writing report: %v", err)
+	}
+}
+
+func init() {
+	for _, c := range []*cobra.Command{callersCmd, calleesCmd} {
+		analyzeCmd.AddCommand(c)
+		c.Flags().StringVar(&neighborsTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+		c.Flags().StringVar(&neighborsFunction, "function", "", "Name of the function to inspect")
+		c.MarkFlagRequired("function")
+	}
+	callersCmd.Flags().BoolVar(&callersStacks, "stacks", false, "Group the calls by their entry stack (see tracing.entryStacks)")
+}
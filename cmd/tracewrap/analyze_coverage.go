@@ -0,0 +1,58 @@
+// cmd/tracewrap/analyze_coverage.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/spf13/cobra"
+)
+
+var (
+	coverageTrace     string
+	coverageInventory string
+	coverageUncovered bool
+)
+
+// coverageCmd is the subcommand under analyze for reporting which instrumented functions ran.
+var coverageCmd = &cobra.Command{
+	Use:   "coverage",
+	Short: "Report the share of instrumented functions a traced run executed.",
+	Long: `coverage compares the calls in a trace with the inventory of instrumented functions that
+buildTracedApplication writes to tracewrap/inventory.json, and reports per file and in total how
+many functions were called and how many statements those functions hold. Coverage is counted per
+function, and the statement column is that coverage weighted by function size: the trace does not
+say which statements of a function ran. --uncovered lists the functions that never ran.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		inv, err := instrument.ReadInventory(coverageInventory)
+		if err != nil {
+			fail("Error reading inventory: %v", err)
+		}
+		records, err := readAnalyzedTrace(coverageTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{"trace": coverageTrace, "inventory": coverageInventory}, Findings: analysis.Coverage(inv, records)})
+			return
+		}
+		if len(inv.Functions) == 0 {
+			fmt.Println("No instrumented functions in", coverageInventory)
+			return
+		}
+		if err := analysis.WriteCoverage(os.Stdout, analysis.Coverage(inv, records), coverageUncovered); err != nil {
+			fail("Error writing report: %v", err)
+		}
+	},
+}
+
+func init() {
+	analyzeCmd.AddCommand(coverageCmd)
+	coverageCmd.Flags().StringVar(&coverageTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+	coverageCmd.Flags().StringVar(&coverageInventory, "inventory", "tracewrap/inventory.json", "Path to the inventory written by buildTracedApplication")
+	coverageCmd.Flags().BoolVar(&coverageUncovered, "uncovered", false, "List the functions that were never executed")
+}
@@ -0,0 +1,55 @@
+// cmd/tracewrap/analyze_fdleaks.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/spf13/cobra"
+)
+
+var fdleaksTrace string
+
+// fdleaksCmd is the subcommand under analyze for finding file descriptor leaks.
+var fdleaksCmd = &cobra.Command{
+	Use:   "fdleaks",
+	Short: "List the functions whose calls leave file descriptors open.",
+	Long: `fdleaks reads a trace recorded with tracing.trackFileDescriptors and lists the functions
+whose calls returned with more file descriptors open than they started with, without counting
+the descriptors their callees left open. LEAKING is the number of calls that left descriptors
+open and NET FDS the descriptors opened minus those closed over all calls.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		records, err := readAnalyzedTrace(fdleaksTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		if jsonOutput {
+			writeFindings(fdleaksTrace, analysis.FDLeaks(records))
+			return
+		}
+		tracked := false
+		for _, rec := range records {
+			tracked = tracked || rec.FDDelta != 0
+		}
+		if !tracked {
+			fmt.Println("No file descriptor changes recorded in", fdleaksTrace, "(set tracing.trackFileDescriptors to record them)")
+			return
+		}
+		leaks := analysis.FDLeaks(records)
+		if len(leaks) == 0 {
+			fmt.Println("No function left file descriptors open.")
+			return
+		}
+		if err := analysis.WriteFDLeaks(os.Stdout, leaks); err != nil {
+			fail("Error writing report: %v", err)
+		}
+	},
+}
+
+func init() {
+	analyzeCmd.AddCommand(fdleaksCmd)
+	fdleaksCmd.Flags().StringVar(&fdleaksTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+}
@@ -0,0 +1,105 @@
+// cmd/tracewrap/analyze_heatmap.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/spf13/cobra"
+)
+
+var (
+	heatmapTrace  string
+	heatmapBy     string
+	heatmapBucket time.Duration
+	heatmapTop    int
+	heatmapName   string
+	heatmapHTML   string
+	heatmapConfig string
+)
+
+// heatmapCmd is the subcommand under analyze for drawing latency heatmaps.
+var heatmapCmd = &cobra.Command{
+	Use:   "heatmap",
+	Short: "Draw time-bucketed latency heatmaps per function or route.",
+	Long: `heatmap reads a trace file and draws, for the --top functions with the most calls (or routes
+with --by route), a heatmap of their calls over time: each column is a --bucket of time, by default
+about a sixtieth of the trace, and each row a latency bucket, the slowest on top. Darker cells hold more
+calls. Slow cells recurring at a fixed interval point at periodic slowdowns such as garbage
+collection, cache expiry or cron jobs. --name draws a single function or route. --html writes the
+heatmaps to an HTML page instead, with the calls of each cell in its tooltip, colored by the
+visualization.theme of --config.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		records, err := readAnalyzedTrace(heatmapTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		opts := analysis.HeatmapOptions{By: heatmapBy, Bucket: heatmapBucket, Top: heatmapTop, Name: heatmapName}
+		maps, err := analysis.Heatmaps(records, opts)
+		if err != nil {
+			fail("Error: %v", err)
+		}
+		if jsonOutput && heatmapHTML == "" {
+			writeFindings(heatmapTrace, maps)
+			return
+		}
+		if len(maps) == 0 {
+			fmt.Println("No calls to draw found in", heatmapTrace)
+			return
+		}
+		if heatmapHTML == "" {
+			err = analysis.WriteHeatmaps(os.Stdout, maps)
+		} else {
+			err = writeHeatmapPage(heatmapHTML, maps)
+		}
+		if err != nil {
+			fail("Error writing heatmaps: %v", err)
+		}
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{"trace": heatmapTrace, "html": heatmapHTML}})
+		}
+	},
+}
+
+// writeHeatmapPage writes maps as an HTML page to path.
+//
+// Parameters:
+//   - path (string): the output file.
+//   - maps ([]analysis.Heatmap): the heatmaps.
+//
+// Returns:
+//   - error: an error if the file cannot be written.
+func writeHeatmapPage(path string, maps []analysis.Heatmap) error {
+	palette, err := loadPalette(heatmapConfig)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %v", heatmapConfig, err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := analysis.WriteHeatmapsHTML(file, "Latency heatmaps of "+heatmapTrace, maps, palette); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Println("Heatmaps written to", path)
+	return nil
+}
+
+func init() {
+	analyzeCmd.AddCommand(heatmapCmd)
+	heatmapCmd.Flags().StringVar(&heatmapTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+	heatmapCmd.Flags().StringVar(&heatmapBy, "by", analysis.HeatmapByFunction, "Draw a heatmap per function or route")
+	heatmapCmd.Flags().DurationVar(&heatmapBucket, "bucket", 0, "Width of a time bucket, e.g. 1s (0 splits the trace into about 60 buckets)")
+	heatmapCmd.Flags().IntVar(&heatmapTop, "top", 5, "Number of functions or routes to draw, by call count (0 draws all)")
+	heatmapCmd.Flags().StringVar(&heatmapName, "name", "", "Draw only this function or route")
+	heatmapCmd.Flags().StringVar(&heatmapConfig, "config", "tracewrap.yaml", "Path to the configuration file whose visualization.theme colors the --html page")
+	heatmapCmd.Flags().StringVar(&heatmapHTML, "html", "", "Write the heatmaps to this HTML file instead of the terminal")
+}
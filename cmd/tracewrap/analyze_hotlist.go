@@ -0,0 +1,93 @@
+// cmd/tracewrap/analyze_hotlist.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/blame"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/traceio"
+	"github.com/spf13/cobra"
+)
+
+var (
+	hotlistTrace     string
+	hotlistBy        string
+	hotlistTop       int
+	hotlistBlame     bool
+	hotlistInventory string
+	hotlistProject   string
+)
+
+// hotlistCmd is the subcommand under analyze for ranking the hottest functions of a run.
+var hotlistCmd = &cobra.Command{
+	Use:   "hotlist",
+	Short: "Rank the functions of a run by call count or total time.",
+	Long: `hotlist reads a trace file and lists the --top functions by call count (--by calls) or by
+the total time of their calls (--by time). Call counts come from the execFrequency of the run.json
+next to the trace, which also counts the calls that sampling kept out of the trace; RECORDED is the
+number of calls with a record, which the times are computed from.
+
+--blame annotates each function with the commit that last changed it, its author and date, found
+with git blame over the function's lines in the inventory of buildTracedApplication. --project is
+the project root the inventory's files are relative to.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		records, err := readAnalyzedTrace(hotlistTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		// The call counts of run.json cover every status, so they are left out with --status.
+		var frequency map[string]int
+		meta, err := traceio.ReadMetadata(filepath.Join(filepath.Dir(hotlistTrace), "run.json"))
+		if err == nil && analyzeStatus == "" {
+			frequency = meta.ExecFrequency
+		} else if err != nil && !os.IsNotExist(err) {
+			fail("Error reading run metadata: %v", err)
+		}
+		hot, err := analysis.Hotlist(records, frequency, hotlistBy, hotlistTop)
+		if err != nil {
+			fail("Error: %v", err)
+		}
+		if hotlistBlame && len(hot) > 0 {
+			inv, err := instrument.ReadInventory(hotlistInventory)
+			if err != nil {
+				fail("Error reading inventory: %v", err)
+			}
+			names := make([]string, len(hot))
+			for i, h := range hot {
+				names[i] = h.Name
+			}
+			commits, err := blame.ForFunctions(hotlistProject, inv, names)
+			if err != nil {
+				fail("Error: %v", err)
+			}
+			analysis.AnnotateHotlist(hot, commits)
+		}
+		if jsonOutput {
+			writeFindings(hotlistTrace, hot)
+			return
+		}
+		if len(hot) == 0 {
+			fmt.Println("No calls found in", hotlistTrace)
+			return
+		}
+		if err := analysis.WriteHotlist(os.Stdout, hot); err != nil {
+			fail("Error writing report: %v", err)
+		}
+	},
+}
+
+func init() {
+	analyzeCmd.AddCommand(hotlistCmd)
+	hotlistCmd.Flags().StringVar(&hotlistTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+	hotlistCmd.Flags().StringVar(&hotlistBy, "by", analysis.ByCalls, "Rank by calls or time")
+	hotlistCmd.Flags().IntVar(&hotlistTop, "top", 10, "Number of functions to list (0 lists all)")
+	hotlistCmd.Flags().BoolVar(&hotlistBlame, "blame", false, "Annotate functions with their last commit and author")
+	hotlistCmd.Flags().StringVar(&hotlistInventory, "inventory", "tracewrap/inventory.json", "Path to the inventory written by buildTracedApplication")
+	hotlistCmd.Flags().StringVar(&hotlistProject, "project", ".", "Project root the inventory's files are relative to")
+}
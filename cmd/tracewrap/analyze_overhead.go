@@ -0,0 +1,68 @@
+// cmd/tracewrap/analyze_overhead.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/spf13/cobra"
+)
+
+var (
+	overheadTrace      string
+	overheadTop        int
+	overheadBenchmarks string
+)
+
+// overheadCmd is the subcommand under analyze for reporting the tracer's per-call overhead.
+var overheadCmd = &cobra.Command{
+	Use:   "overhead",
+	Short: "Report the time the tracer's hooks cost per call.",
+	Long: `overhead reads a trace file and reports the time the tracer's hooks spent in each call
+(recorded as tracerOverhead): the mean, 99th percentile and largest per call, the total, and its
+share of the calls' duration, for all calls and for the functions costing the most. --benchmarks
+adds the cost of a traced call the tracer's benchmarks measured, read from the output of
+"go test -bench . ./pkg/tracer" in the tracewrap module, so the recorded hook time can be compared
+with the full cost of the injected code.`,
+	Example: `  go test -run '^$' -bench 'RecordEntryExit|RecordParam|RecordReturn' ./pkg/tracer > bench.txt
+  tracewrap analyze overhead --benchmarks bench.txt`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		records, err := readAnalyzedTrace(overheadTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		summary := analysis.OverheadReport(records, overheadTop)
+		if overheadBenchmarks != "" {
+			file, err := os.Open(overheadBenchmarks)
+			if err != nil {
+				fail("Error reading benchmark results: %v", err)
+			}
+			summary.Benchmarks, err = analysis.ParseBenchmarks(file)
+			file.Close()
+			if err != nil {
+				fail("Error reading benchmark results from %s: %v", overheadBenchmarks, err)
+			}
+		}
+		if jsonOutput {
+			writeFindings(overheadTrace, summary)
+			return
+		}
+		if summary.All.Calls == 0 {
+			fmt.Println("No calls found in", overheadTrace)
+			return
+		}
+		if err := analysis.WriteOverheadReport(os.Stdout, summary); err != nil {
+			fail("Error writing report: %v", err)
+		}
+	},
+}
+
+func init() {
+	analyzeCmd.AddCommand(overheadCmd)
+	overheadCmd.Flags().StringVar(&overheadTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+	overheadCmd.Flags().IntVar(&overheadTop, "top", 10, "Number of functions to list, or 0 for all")
+	overheadCmd.Flags().StringVar(&overheadBenchmarks, "benchmarks", "", "Output of the tracer's benchmarks (go test -bench) to report with the overhead")
+}
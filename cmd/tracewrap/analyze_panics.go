@@ -0,0 +1,64 @@
+// cmd/tracewrap/analyze_panics.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/ghactions"
+	"github.com/mwiater/tracewrap/pkg/notify"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	panicsTrace   string
+	panicsWebhook string
+)
+
+// panicsCmd is the subcommand under analyze for listing the panics in a trace.
+var panicsCmd = &cobra.Command{
+	Use:   "panics",
+	Short: "List the panics recorded in a trace.",
+	Long: `panics reads a trace file and groups the calls that panicked by function and panic value,
+with the stack trace of the first call of each group. With --webhook, each group is also posted
+with its stack and parameters, e.g. to a Slack incoming webhook.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		records, err := readAnalyzedTrace(panicsTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		groups := analysis.Panics(records)
+		for _, g := range groups {
+			annotate(ghactions.Error, g.FunctionName, "Panic in "+g.FunctionName, "%s panicked %d times: %s", g.FunctionName, g.Count, g.Value)
+		}
+		if jsonOutput {
+			writeFindings(panicsTrace, groups)
+		} else if len(groups) == 0 {
+			fmt.Println("No panics found in", panicsTrace)
+			return
+		} else if err := analysis.WritePanics(os.Stdout, groups); err != nil {
+			fail("Error writing report: %v", err)
+		}
+		if len(groups) == 0 || panicsWebhook == "" {
+			return
+		}
+		for _, g := range groups {
+			source := fmt.Sprintf("%d calls in %s", g.Count, panicsTrace)
+			msg := tracer.PanicMessage(g.FunctionName, g.Value, g.First.StackTrace, g.First.Params, source)
+			if err := notify.Send(nil, panicsWebhook, msg); err != nil {
+				fail("Error posting panic in %s: %v", g.FunctionName, err)
+			}
+		}
+		fmt.Printf("Posted %d panics to the webhook\n", len(groups))
+	},
+}
+
+func init() {
+	analyzeCmd.AddCommand(panicsCmd)
+	panicsCmd.Flags().StringVar(&panicsTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+	panicsCmd.Flags().StringVar(&panicsWebhook, "webhook", "", "Webhook URL to post each panic to, e.g. a Slack incoming webhook")
+}
@@ -0,0 +1,81 @@
+// cmd/tracewrap/analyze_pointers.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pointersTrace string
+	pointersTop   int
+	pointersID    string
+)
+
+// pointersCmd is the subcommand under analyze for following objects passed by pointer.
+var pointersCmd = &cobra.Command{
+	Use:   "pointers",
+	Short: "Follow the objects passed by pointer through the calls.",
+	Long: `pointers reads a trace recorded with tracing.capture.pointerIds and lists the objects
+passed by pointer, identified by a hash of their address, with the chain of calls each was passed
+to, the objects passed through the most functions first. --id lists the calls of one object.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		records, err := readAnalyzedTrace(pointersTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		top := pointersTop
+		if pointersID != "" {
+			top = 0
+		}
+		flows := analysis.PointerFlows(records, top)
+		if pointersID != "" {
+			var found []analysis.ObjectFlow
+			for _, flow := range flows {
+				if flow.PointerID == pointersID {
+					found = append(found, flow)
+				}
+			}
+			if len(found) == 0 {
+				fail("No object %s in %s", pointersID, pointersTrace)
+			}
+			flows = found
+		}
+		if jsonOutput {
+			writeFindings(pointersTrace, flows)
+			return
+		}
+		if len(flows) == 0 {
+			fmt.Println("No pointer IDs recorded in", pointersTrace, "(set tracing.capture.pointerIds)")
+			return
+		}
+		if pointersID != "" {
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "ENTRY\tID\tFUNCTION\tPARAM")
+			for _, call := range flows[0].Calls {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", call.EntryTime.Format(time.RFC3339Nano), call.UniqueID, call.Function, call.Param)
+			}
+			if err := tw.Flush(); err != nil {
+				fail("Error writing report: %v", err)
+			}
+			return
+		}
+		if err := analysis.WritePointerFlows(os.Stdout, flows); err != nil {
+			fail("Error writing report: %v", err)
+		}
+	},
+}
+
+func init() {
+	analyzeCmd.AddCommand(pointersCmd)
+	pointersCmd.Flags().StringVar(&pointersTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+	pointersCmd.Flags().IntVar(&pointersTop, "top", 10, "Number of objects to list, or 0 for all")
+	pointersCmd.Flags().StringVar(&pointersID, "id", "", "Pointer ID of one object to list the calls of")
+}
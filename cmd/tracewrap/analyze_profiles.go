@@ -0,0 +1,91 @@
+// cmd/tracewrap/analyze_profiles.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/traceio"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	profilesTrace  string
+	profilesType   string
+	profilesSample string
+	profilesTop    int
+)
+
+// profilesCmd is the subcommand under analyze for aligning pprof profiles with the trace.
+var profilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "Align the pprof hotspots of a run with its hottest traced functions.",
+	Long: `profiles reads the pprof profiles captured during a run with tracing.cpuProfile and
+tracing.heapProfile, listed with their time offsets in the run.json next to the trace, and puts
+the --top functions of each profile, by cumulative cost, next to the --top traced functions, by
+the total time of their calls. For the CPU profile only the calls started while it ran count.
+
+A function near the top of the trace but without profile cost spends its time waiting (on I/O,
+locks or other goroutines); one near the top of the profile but not traced, such as a library
+function, is work tracewrap does not see. --type selects cpu or heap, and --sample a sample type
+other than the default, e.g. alloc_space for heap profiles. The files can also be opened directly
+with go tool pprof.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		meta, err := traceio.ReadMetadata(filepath.Join(filepath.Dir(profilesTrace), "run.json"))
+		if err != nil {
+			fail("Error reading run metadata: %v", err)
+		}
+		records, err := readAnalyzedTrace(profilesTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		// profileFindings are the hotspots of one profile, as printed with --json.
+		type profileFindings struct {
+			Profile  tracer.ProfileRef         `json:"profile"`
+			Hotspots []analysis.ProfileHotspot `json:"hotspots"`
+		}
+		var findings []profileFindings
+		shown := 0
+		for _, ref := range meta.Profiles {
+			if profilesType != "" && ref.Type != profilesType {
+				continue
+			}
+			prof, err := analysis.ReadProfile(filepath.Join(filepath.Dir(profilesTrace), ref.File), profilesSample)
+			if err != nil {
+				fail("Error reading profile: %v", err)
+			}
+			spots := analysis.ProfileHotspots(records, meta.StartedAt, ref, prof, profilesTop)
+			if jsonOutput {
+				findings = append(findings, profileFindings{ref, spots})
+				continue
+			}
+			if shown > 0 {
+				fmt.Println()
+			}
+			if err := analysis.WriteProfileHotspots(os.Stdout, ref, prof, spots); err != nil {
+				fail("Error writing report: %v", err)
+			}
+			shown++
+		}
+		if jsonOutput {
+			writeFindings(profilesTrace, findings)
+			return
+		}
+		if shown == 0 {
+			fmt.Printf("No profiles were captured for %s; set tracing.cpuProfile or tracing.heapProfile.\n", profilesTrace)
+		}
+	},
+}
+
+func init() {
+	analyzeCmd.AddCommand(profilesCmd)
+	profilesCmd.Flags().StringVar(&profilesTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+	profilesCmd.Flags().StringVar(&profilesType, "type", "", "Only this profile: cpu or heap")
+	profilesCmd.Flags().StringVar(&profilesSample, "sample", "", "Sample type to rank by, e.g. alloc_space (default: the profile's default)")
+	profilesCmd.Flags().IntVar(&profilesTop, "top", 10, "Number of functions taken from the trace and from each profile (0 for all)")
+}
@@ -0,0 +1,61 @@
+// cmd/tracewrap/analyze_slo.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/ghactions"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sloTrace string
+	sloWorst int
+)
+
+// sloCmd is the subcommand under analyze for summarizing latency SLO violations.
+var sloCmd = &cobra.Command{
+	Use:   "slo",
+	Short: "Summarize latency SLO violations in a trace.",
+	Long: `slo reads a trace file and reports, for each latency budget declared under tracing.slos,
+how many calls it applied to, how many exceeded it, and the slowest offenders.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		records, err := readAnalyzedTrace(sloTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		summaries := analysis.SLOReport(records, sloWorst)
+		for _, s := range summaries {
+			if s.Violations == 0 {
+				continue
+			}
+			function := ""
+			if len(s.Worst) > 0 {
+				function = s.Worst[0].FunctionName
+			}
+			annotate(ghactions.Warning, function, "SLO violated: "+s.Target, "%d of %d calls of %s exceeded %v (%.1f%%)",
+				s.Violations, s.Calls, s.Target, s.Budget, 100*s.ViolationRate())
+		}
+		if jsonOutput {
+			writeFindings(sloTrace, summaries)
+			return
+		}
+		if len(summaries) == 0 {
+			fmt.Println("No records with an SLO found in", sloTrace, "(declare budgets under tracing.slos)")
+			return
+		}
+		if err := analysis.WriteSLOReport(os.Stdout, summaries); err != nil {
+			fail("Error writing report: %v", err)
+		}
+	},
+}
+
+func init() {
+	analyzeCmd.AddCommand(sloCmd)
+	sloCmd.Flags().StringVar(&sloTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+	sloCmd.Flags().IntVar(&sloWorst, "worst", 5, "Number of worst offenders to list per SLO")
+}
@@ -0,0 +1,47 @@
+// cmd/tracewrap/analyze_status.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/spf13/cobra"
+)
+
+var statusTrace string
+
+// statusCmd is the subcommand under analyze for comparing the latency of successful and failed calls.
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Compare the latency of successful and failed calls in a trace.",
+	Long: `status reads a trace file and, for each function with a span status, compares the
+latency of its ok calls with that of its error calls. The status of a call is set from the HTTP
+status code it served (errors from 500 on) or received (errors from 400 on), or from the gRPC
+code of the status error it returned. CODES counts the calls per code.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		records, err := readAnalyzedTrace(statusTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		report := analysis.StatusReport(records)
+		if jsonOutput {
+			writeFindings(statusTrace, report)
+			return
+		}
+		if len(report) == 0 {
+			fmt.Println("No records with an HTTP or gRPC status found in", statusTrace)
+			return
+		}
+		if err := analysis.WriteStatusReport(os.Stdout, report); err != nil {
+			fail("Error writing report: %v", err)
+		}
+	},
+}
+
+func init() {
+	analyzeCmd.AddCommand(statusCmd)
+	statusCmd.Flags().StringVar(&statusTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+}
@@ -0,0 +1,172 @@
+// cmd/tracewrap/analyze_trend.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/runs"
+	"github.com/mwiater/tracewrap/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	trendFunction string
+	trendLast     string
+	trendBy       string
+	trendStore    string
+	trendDir      string
+)
+
+// trendCmd is the subcommand under analyze for rolling up a function's stats over time.
+var trendCmd = &cobra.Command{
+	Use:   "trend",
+	Short: "Show how a function's latency changed across runs over time.",
+	Long: `trend rolls up the stats of --function in the runs of the store started within --last, such
+as 30d, 12h or 2w, per hour or day (--by), and lists the calls, mean, p95 and max duration of each
+period with the change of its mean since the first, to see whether a function has crept slower.
+
+The store keeps the per-function stats of every run it adds, so the rollup does not read the
+traces again. With --dir, the run directories there are rolled up instead, such as those of a
+collector started with --dir or the local tracewrap directory.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		last, err := parseAge(trendLast)
+		if err != nil {
+			failWith(exitConfig, "Invalid --last: %v", err)
+		}
+		since := time.Now().Add(-last)
+		var stats []analysis.RunStats
+		var artifacts map[string]string
+		if trendDir != "" {
+			stats, err = trendRunDirs(trendDir, since)
+			artifacts = map[string]string{"runs": trendDir}
+		} else {
+			stats, err = trendStoredRuns(trendStore, since)
+			artifacts = map[string]string{"store": trendStore}
+		}
+		if err != nil {
+			fail("%v", err)
+		}
+		points, err := analysis.Trend(stats, trendFunction, trendBy)
+		if err != nil {
+			failWith(exitConfig, "Error: %v", err)
+		}
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: artifacts, Findings: points})
+			return
+		}
+		if len(points) == 0 {
+			fmt.Printf("No calls of %s in the %d runs of the last %s\n", trendFunction, len(stats), trendLast)
+			return
+		}
+		if err := analysis.WriteTrend(os.Stdout, points, trendBy); err != nil {
+			fail("Error writing report: %v", err)
+		}
+	},
+}
+
+// trendStoredRuns returns the stats of the runs of the store in dir started since then. The
+// stats kept by the store are used unless --status filters the calls.
+func trendStoredRuns(dir string, since time.Time) ([]analysis.RunStats, error) {
+	s, err := store.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	list, err := s.Runs()
+	if err != nil {
+		return nil, err
+	}
+	var stats []analysis.RunStats
+	for _, run := range list {
+		started := run.AddedAt
+		if run.Metadata != nil && !run.Metadata.StartedAt.IsZero() {
+			started = run.Metadata.StartedAt
+		}
+		if started.Before(since) {
+			continue
+		}
+		rs := analysis.RunStats{Time: started}
+		if analyzeStatus == "" {
+			rs.Functions, err = s.Stats(run.ID)
+		} else {
+			rs.Functions, err = statsOf(filepath.Join(s.Dir(), run.ID, "trace.jsonl"))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading run %s: %v", run.ID, err)
+		}
+		stats = append(stats, rs)
+	}
+	return stats, nil
+}
+
+// trendRunDirs returns the stats of the run directories in root started since then. Runs
+// without a trace yet are skipped.
+func trendRunDirs(root string, since time.Time) ([]analysis.RunStats, error) {
+	list, err := runs.List(root)
+	if err != nil {
+		return nil, err
+	}
+	var stats []analysis.RunStats
+	for _, run := range list {
+		if run.StartedAt.Before(since) {
+			continue
+		}
+		functions, err := statsOf(filepath.Join(run.Dir, "trace.jsonl"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading run %s: %v", run.ID, err)
+		}
+		stats = append(stats, analysis.RunStats{Time: run.StartedAt, Functions: functions})
+	}
+	return stats, nil
+}
+
+// statsOf aggregates the calls of a trace file that pass the --status filter.
+func statsOf(trace string) ([]analysis.FunctionStats, error) {
+	if _, err := os.Stat(trace); err != nil {
+		return nil, err
+	}
+	records, err := readAnalyzedTrace(trace)
+	if err != nil {
+		return nil, err
+	}
+	return analysis.Aggregate(records), nil
+}
+
+// parseAge parses a duration as time.ParseDuration does, also accepting a number of days or
+// weeks such as "30d" or "2w".
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("%q is not a duration such as 30d, 12h or 2w", s)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%q is not a duration such as 30d, 12h or 2w", s)
+	}
+	return d, nil
+}
+
+func init() {
+	analyzeCmd.AddCommand(trendCmd)
+	trendCmd.Flags().StringVar(&trendFunction, "function", "", "Name of the function to follow")
+	trendCmd.Flags().StringVar(&trendLast, "last", "30d", "How far back to look, e.g. 30d, 12h or 2w")
+	trendCmd.Flags().StringVar(&trendBy, "by", analysis.ByDay, "Period to roll up by: hour or day")
+	trendCmd.Flags().StringVar(&trendStore, "store", store.DefaultDir, "Path to the store with past runs")
+	trendCmd.Flags().StringVar(&trendDir, "dir", "", "Roll up the run directories in this directory, e.g. a collector's, instead of the store")
+	trendCmd.MarkFlagRequired("function")
+}
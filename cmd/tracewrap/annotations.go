@@ -0,0 +1,49 @@
+// cmd/tracewrap/annotations.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mwiater/tracewrap/pkg/ghactions"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// annotationLocator finds the source lines of annotated functions; it is loaded on first use.
+var (
+	annotationLocator *ghactions.Locator
+	locatorLoaded     bool
+)
+
+// annotate reports a finding as a GitHub Actions annotation when running in a workflow, on the
+// source lines of function if the inventory written by buildTracedApplication holds it. Outside
+// GitHub Actions, it does nothing. Annotations go to os.Stdout, which is standard error with
+// --json, so they stay out of the JSON result.
+//
+// Parameters:
+//   - level (string): ghactions.Warning or ghactions.Error.
+//   - function (string): the function the finding concerns, or "" for none.
+//   - title (string): the title of the annotation.
+//   - format (string): the message format, as for fmt.Printf.
+//   - args (...any): the arguments of the format.
+func annotate(level, function, title, format string, args ...any) {
+	if !ghactions.Enabled() {
+		return
+	}
+	if !locatorLoaded {
+		locatorLoaded = true
+		if inv, err := instrument.ReadInventory(filepath.Join(tracer.ArtifactRoot, "inventory.json")); err == nil {
+			annotationLocator = ghactions.NewLocator(inv, os.Getenv("GITHUB_WORKSPACE"))
+		}
+	}
+	a := ghactions.Annotation{Level: level, Title: title, Message: fmt.Sprintf(format, args...)}
+	if function != "" {
+		annotationLocator.Locate(&a, function)
+	}
+	if err := ghactions.Write(os.Stdout, a); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing annotation: %v\n", err)
+	}
+}
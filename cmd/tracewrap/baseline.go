@@ -0,0 +1,30 @@
+// cmd/tracewrap/baseline.go
+
+package cmd
+
+import (
+	"github.com/mwiater/tracewrap/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	baselineStore string
+	baselineName  string
+)
+
+// baselineCmd is the parent command for managing named baselines.
+var baselineCmd = &cobra.Command{
+	Use:   "baseline",
+	Short: "Manage named baselines of per-function stats.",
+	Long: `A baseline is a named snapshot of the per-function stats of a run, or of several stored runs,
+such as the calls, mean and p95 duration of every function in release v1.4. Baselines are kept in
+the store (.tracewrap/store/baselines by default) and are not removed by its retention policy;
+tracewrap diff and tracewrap check compare later runs against them by name. The baseline command
+serves as a parent for subcommands that save, list and delete baselines.`,
+	// No Run functionality; this command exists solely to group subcommands.
+}
+
+func init() {
+	rootCmd.AddCommand(baselineCmd)
+	baselineCmd.PersistentFlags().StringVar(&baselineStore, "store", store.DefaultDir, "Path to the store directory")
+}
@@ -0,0 +1,33 @@
+// cmd/tracewrap/baseline_delete.go
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mwiater/tracewrap/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+// baselineDeleteCmd is the subcommand under baseline for removing a baseline.
+var baselineDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete a baseline from the store.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		s, err := store.Open(baselineStore)
+		if err != nil {
+			fail("%v", err)
+		}
+		if err := s.DeleteBaseline(baselineName); err != nil {
+			fail("Error deleting baseline: %v", err)
+		}
+		fmt.Println("Deleted baseline", baselineName, "from", s.Dir())
+	},
+}
+
+func init() {
+	baselineCmd.AddCommand(baselineDeleteCmd)
+	baselineDeleteCmd.Flags().StringVar(&baselineName, "name", "", "Name of the baseline to delete")
+	baselineDeleteCmd.MarkFlagRequired("name")
+}
@@ -0,0 +1,49 @@
+// cmd/tracewrap/baseline_list.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+// baselineListCmd is the subcommand under baseline for listing the saved baselines.
+var baselineListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the baselines in the store.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		s, err := store.Open(baselineStore)
+		if err != nil {
+			fail("%v", err)
+		}
+		baselines, err := s.Baselines()
+		if err != nil {
+			fail("%v", err)
+		}
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{"store": s.Dir()}, Findings: baselines})
+			return
+		}
+		if len(baselines) == 0 {
+			fmt.Println("No baselines in", s.Dir())
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSAVED\tFUNCTIONS\tRECORDS\tSOURCES")
+		for _, b := range baselines {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", b.Name, b.SavedAt.Local().Format(time.DateTime), len(b.Functions), b.Records, strings.Join(b.Sources, ", "))
+		}
+		tw.Flush()
+	},
+}
+
+func init() {
+	baselineCmd.AddCommand(baselineListCmd)
+}
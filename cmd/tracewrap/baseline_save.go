@@ -0,0 +1,84 @@
+// cmd/tracewrap/baseline_save.go
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/store"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	baselineSaveTrace string
+	baselineSaveRuns  int
+	baselineSaveForce bool
+)
+
+// baselineSaveCmd is the subcommand under baseline for snapshotting the stats of a run.
+var baselineSaveCmd = &cobra.Command{
+	Use:   "save",
+	Short: "Save the per-function stats of a trace as a named baseline.",
+	Long: `save aggregates the records of --trace per function and stores the stats as the baseline
+--name. With --runs N, the stats are computed from the N most recent runs of the store instead,
+which evens out the noise of a single run. An existing baseline is only replaced with --force.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		s, err := store.Open(baselineStore)
+		if err != nil {
+			fail("%v", err)
+		}
+		b := store.Baseline{Name: baselineName, SavedAt: time.Now().UTC()}
+		var records []tracer.TraceRecord
+		if baselineSaveRuns > 0 {
+			runs, err := s.Runs()
+			if err != nil {
+				fail("%v", err)
+			}
+			if len(runs) == 0 {
+				fail("No runs in %s - add traces with tracewrap store add", s.Dir())
+			}
+			if len(runs) > baselineSaveRuns {
+				runs = runs[len(runs)-baselineSaveRuns:]
+			}
+			for _, run := range runs {
+				recs, err := s.Records(run.ID)
+				if err != nil {
+					fail("Error reading run %s: %v", run.ID, err)
+				}
+				records = append(records, recs...)
+				b.Sources = append(b.Sources, run.ID)
+			}
+		} else {
+			if records, err = readAnalyzedTrace(baselineSaveTrace); err != nil {
+				fail("Error reading trace file: %v", err)
+			}
+			b.Sources = []string{baselineSaveTrace}
+		}
+		b.Records = len(records)
+		b.Functions = analysis.Aggregate(records)
+		if err := s.SaveBaseline(b, baselineSaveForce); errors.Is(err, store.ErrBaselineExists) {
+			fail("Baseline %s already exists; use --force to replace it", b.Name)
+		} else if err != nil {
+			failWith(exitConfig, "Error saving baseline: %v", err)
+		}
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{"store": s.Dir()}, Findings: b})
+			return
+		}
+		fmt.Printf("Saved baseline %s (%d functions, %d records) in %s\n", b.Name, len(b.Functions), b.Records, s.Dir())
+	},
+}
+
+func init() {
+	baselineCmd.AddCommand(baselineSaveCmd)
+	baselineSaveCmd.Flags().StringVar(&baselineName, "name", "", "Name of the baseline, e.g. v1.4")
+	baselineSaveCmd.Flags().StringVar(&baselineSaveTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+	baselineSaveCmd.Flags().IntVar(&baselineSaveRuns, "runs", 0, "Compute the stats from the most recent N stored runs instead of --trace")
+	baselineSaveCmd.Flags().BoolVar(&baselineSaveForce, "force", false, "Replace an existing baseline of the same name")
+	baselineSaveCmd.MarkFlagRequired("name")
+}
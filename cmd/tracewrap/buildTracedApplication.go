@@ -3,13 +3,19 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/applog"
+	"github.com/mwiater/tracewrap/pkg/httpauth"
 	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/tracer"
 	"github.com/spf13/cobra"
 )
 
@@ -17,102 +23,331 @@ var (
 	projectDir string
 	configPath string
 	appName    string
+	outputPath string
+	dashboard  bool
+	race       bool
+
+	captureOutput bool
 )
 
+// defaultDashboardAddr is the control endpoint address used by --dashboard when the configuration
+// does not set tracing.control.listen.
+const defaultDashboardAddr = "127.0.0.1:7070"
+
 // buildCmd represents the buildTracedApplication command.
 var buildCmd = &cobra.Command{
 	Use:   "buildTracedApplication",
 	Short: "Build and run an instrumented version of the application",
 	Long: `buildTracedApplication builds an instrumented version of the target Go application.
 It prepares the workspace, loads configuration, instruments the source, builds the binary,
-optionally moves and renames it, and then executes the instrumented binary.`,
+optionally moves and renames it, and then executes the instrumented binary.
+--output sets the binary's path and may use {{.App}} (--name, or the project directory's name),
+{{.GOOS}} and {{.GOARCH}}, e.g. -o "bin/{{.App}}-traced-{{.GOOS}}".
+With --capture-output, the binary's output is also written to tracewrap/latest/app.log, each line
+timestamped and tagged with the ID of the span that was running.
+With --dashboard, the binary's output goes only to tracewrap/latest/app.log and a live dashboard
+(calls/sec, active spans, goroutines, top functions by time) is shown instead.
+With --race, the binary is built with the race detector and records values in the race-safe
+capture mode (tracing.capture.raceSafe), so races reported are the application's own.
+With --json, the progress messages and the binary's output go to standard error, and the result
+lists the workspace, binary, inventory and trace paths as artifacts.
+The command exits with status 2 for an invalid configuration, 4 when the project cannot be
+instrumented and 3 when the instrumented binary does not build.
+Files of packages that no longer build once instrumented, e.g. cgo preambles or assembly whose Go
+declarations the injected code upsets, are restored and built uninstrumented; they are reported,
+listed in tracewrap/inventory.json and, with --json, as the result's findings. Set
+instrumentation.skipBuildFallback to fail the build instead.
+A failed build shows each compiler error at its line in the project's file, with the function and
+the injected construct it falls in (e.g. the return value capture), rather than the raw output of
+"go build", which is saved to tracewrap/build-errors.log.
+A file whose instrumented source does not parse or type-check is kept as it is and reported in the
+same way, and the rest of the project is still instrumented.
+Before the build, the instrumented workspace is type-checked as a whole, so errors are diagnosed
+early, and "go vet" findings in the injected code are reported; set instrumentation.skipVerify to
+skip both.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if dashboard && ciMode {
+			failWith(exitConfig, "--dashboard is interactive and cannot be used with --ci")
+		}
 		if projectDir == "" {
-			fmt.Println("Project directory must be specified using --project")
-			os.Exit(1)
+			failWith(exitConfig, "Project directory must be specified using --project")
 		}
 		absProjectDir, err := filepath.Abs(projectDir)
 		if err != nil {
-			fmt.Printf("Error determining absolute path: %v\n", err)
-			os.Exit(1)
+			failWith(exitConfig, "Error determining absolute path: %v", err)
 		}
 		info, err := os.Stat(absProjectDir)
 		if err != nil || !info.IsDir() {
-			fmt.Printf("Project directory does not exist or is not a directory: %s\n", absProjectDir)
-			os.Exit(1)
+			failWith(exitConfig, "Project directory does not exist or is not a directory: %s", absProjectDir)
 		}
-		fmt.Println("Tracing build initiated for project:", absProjectDir)
+		progress("Tracing build initiated for project:", absProjectDir)
 
 		workspace, err := instrument.PrepareWorkspace(absProjectDir)
 		if err != nil {
-			fmt.Printf("Error preparing workspace: %v\n", err)
-			os.Exit(1)
+			failWith(exitInstrument, "Error preparing workspace: %v", err)
 		}
-		fmt.Println("Workspace prepared at:", workspace)
+		progress("Workspace prepared at:", workspace)
 
 		cfg, err := config.LoadConfig(configPath)
 		if err != nil {
-			fmt.Printf("Error loading configuration: %v\n", err)
-			os.Exit(1)
+			failWith(exitConfig, "Error loading configuration: %v", err)
+		}
+		// Problems the tracer would otherwise work around at run time stop a CI build up front.
+		if ciMode {
+			if err := cfg.Validate(); err != nil {
+				failWith(exitConfig, "Invalid configuration: %v", err)
+			}
+		}
+
+		// The dashboard is fed by the control endpoint, which has to be compiled in.
+		if dashboard && cfg.Tracing.Control.Listen == "" {
+			cfg.Tracing.Control.Listen = defaultDashboardAddr
+			progress("Enabling control endpoint for the dashboard at:", defaultDashboardAddr)
+		}
+
+		// Rendering values the application is still writing would show up as races in the tracer.
+		var buildFlags []string
+		if race {
+			buildFlags = append(buildFlags, "-race")
+			if !cfg.Tracing.Capture.RaceSafe {
+				cfg.Tracing.Capture.RaceSafe = true
+				progress("Enabling race-safe value capture for the race detector")
+			}
 		}
 
 		err = instrument.SetDynamicTracerImport(workspace)
 		if err != nil {
-			fmt.Printf("Error setting tracer import: %v\n", err)
-			os.Exit(1)
+			failWith(exitInstrument, "Error setting tracer import: %v", err)
 		}
-		fmt.Println("Dynamic tracer import set to:", instrument.DynamicTracerImport)
+		progress("Dynamic tracer import set to:", instrument.DynamicTracerImport)
 
 		err = instrument.InstrumentWorkspace(workspace, *cfg)
 		if err != nil {
-			fmt.Printf("Error instrumenting workspace: %v\n", err)
-			os.Exit(1)
+			failWith(exitInstrument, "Error instrumenting workspace: %v", err)
 		}
-		fmt.Println("Instrumentation completed.")
+		progress("Instrumentation completed.")
 
-		// Build the instrumented binary.
-		binaryPath, err := instrument.BuildInstrumentedBinary(workspace)
+		// Build the instrumented binary, leaving the files of packages it breaks uninstrumented.
+		binaryPath, _, err := instrument.BuildWithFallback(workspace, absProjectDir, *cfg, buildFlags...)
 		if err != nil {
-			fmt.Printf("Error building binary: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Println("Binary built at:", binaryPath)
-
-		// If the --name flag is provided, move the binary to the project's bin/ directory
-		// and rename it as <appName>-tracewrap.
-		if appName != "" {
-			binDir := filepath.Join(absProjectDir, "bin")
-			if err := os.MkdirAll(binDir, 0755); err != nil {
-				fmt.Printf("Error creating bin directory: %v\n", err)
-				os.Exit(1)
+			failBuild(err)
+		}
+		progress("Binary built at:", binaryPath)
+		inventoryPath := filepath.Join(tracer.ArtifactRoot, "inventory.json")
+		inv, err := instrument.ReadInventory(filepath.Join(workspace, instrument.InventoryFile))
+		if err != nil {
+			fail("Error reading function inventory: %v", err)
+		}
+		// The files left uninstrumented, when instrumenting or building, are the findings of the
+		// --json result.
+		var findings any
+		for _, f := range inv.Fallbacks {
+			progress("Left uninstrumented:", f.File, "-", f.Reason)
+		}
+		if len(inv.Fallbacks) > 0 {
+			findings = inv.Fallbacks
+		}
+		inv.Root = absProjectDir
+		if err := os.MkdirAll(tracer.ArtifactRoot, 0755); err != nil {
+			fail("Error creating tracewrap directory: %v", err)
+		}
+		if err := instrument.WriteInventory(inventoryPath, inv); err != nil {
+			fail("Error saving function inventory: %v", err)
+		}
+		progress("Function inventory written to:", inventoryPath)
+		symbols, err := tracer.ReadSymbols(filepath.Join(workspace, instrument.SymbolsFile))
+		if err != nil {
+			fail("Error reading symbol table: %v", err)
+		}
+		symbolsPath := filepath.Join(tracer.ArtifactRoot, tracer.SymbolsFile)
+		if err := tracer.WriteSymbols(symbolsPath, symbols); err != nil {
+			fail("Error saving symbol table: %v", err)
+		}
+		progress("Symbol table written to:", symbolsPath)
+		sourceMap, err := instrument.ReadSourceMap(filepath.Join(workspace, instrument.SourceMapFile))
+		if err != nil {
+			fail("Error reading source map: %v", err)
+		}
+		sourceMap.Root = absProjectDir
+		sourceMapPath := filepath.Join(tracer.ArtifactRoot, instrument.BuildSourceMapFile)
+		if err := instrument.WriteSourceMap(sourceMapPath, sourceMap); err != nil {
+			fail("Error saving source map: %v", err)
+		}
+		progress("Source map written to:", sourceMapPath)
+
+		// With --output, move the binary to the expanded path. Otherwise, if the --name flag
+		// is provided, move it to the project's bin/ directory and rename it as <appName>-tracewrap.
+		newBinaryPath := ""
+		switch {
+		case outputPath != "":
+			app := appName
+			if app == "" {
+				app = filepath.Base(absProjectDir)
+			}
+			newBinaryPath, err = instrument.RenderOutputPath(outputPath, instrument.OutputNameData{App: app, GOOS: runtime.GOOS, GOARCH: runtime.GOARCH})
+			if err != nil {
+				failWith(exitConfig, "Error expanding --output: %v", err)
 			}
+		case appName != "":
 			newBinaryName := appName + "-tracewrap"
 			if runtime.GOOS == "windows" {
 				newBinaryName += ".exe"
 			}
-			newBinaryPath := filepath.Join(binDir, newBinaryName)
-			if err := os.Rename(binaryPath, newBinaryPath); err != nil {
-				fmt.Printf("Error moving binary to bin directory: %v\n", err)
-				os.Exit(1)
+			newBinaryPath = filepath.Join(absProjectDir, "bin", newBinaryName)
+		}
+		if newBinaryPath != "" {
+			if err := instrument.MoveFile(binaryPath, newBinaryPath); err != nil {
+				fail("Error moving binary: %v", err)
 			}
-			fmt.Println("Binary moved to:", newBinaryPath)
+			progress("Binary moved to:", newBinaryPath)
 			binaryPath = newBinaryPath
 		}
 
+		if dashboard || captureOutput {
+			// The binary uses this run ID, so its artifacts and the captured output share a directory.
+			runID := tracer.NewRunID(time.Now(), os.Getpid())
+			os.Setenv(tracer.RunIDEnv, runID)
+			runDir := filepath.Join(tracer.ArtifactRoot, runID)
+			if err := os.MkdirAll(runDir, 0755); err != nil {
+				fail("Error creating tracewrap directory: %v", err)
+			}
+			if err := runCaptured(binaryPath, args, dashboardEndpoint(cfg.Tracing.Control), runDir); err != nil {
+				fail("Error running binary: %v", err)
+			}
+			progress("Instrumented binary execution completed.")
+			if jsonOutput {
+				writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{
+					"workspace": workspace,
+					"binary":    binaryPath,
+					"inventory": inventoryPath,
+					"run":       runDir,
+					"trace":     filepath.Join(runDir, "trace.jsonl"),
+					"appLog":    filepath.Join(runDir, "app.log"),
+				}, Findings: findings})
+			}
+			return
+		}
+
 		// Run the instrumented binary, forwarding any extra arguments.
 		err = instrument.RunInstrumentedBinary(binaryPath, args)
 		if err != nil {
-			fmt.Printf("Error running binary: %v\n", err)
-			os.Exit(1)
+			fail("Error running binary: %v", err)
+		}
+		progress("Instrumented binary execution completed.")
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{
+				"workspace": workspace,
+				"binary":    binaryPath,
+				"inventory": inventoryPath,
+				"run":       filepath.Join(tracer.ArtifactRoot, tracer.LatestRun),
+				"trace":     filepath.Join(tracer.ArtifactRoot, tracer.LatestRun, "trace.jsonl"),
+			}, Findings: findings})
 		}
-		fmt.Println("Instrumented binary execution completed.")
 	},
 }
 
+// failBuild reports a failed build of the instrumented binary and exits with exitBuild. The
+// compiler errors are shown mapped back to the project's files and the injected code responsible,
+// and the raw compiler output is saved to tracewrap/build-errors.log; with --json, the diagnoses
+// are the findings of the result.
+//
+// Parameters:
+//   - err (error): the error of instrument.BuildWithFallback.
+func failBuild(err error) {
+	var buildErr *instrument.BuildError
+	if !errors.As(err, &buildErr) || len(buildErr.Diagnoses) == 0 {
+		failWith(exitBuild, "Error building binary: %v", err)
+	}
+	logPath := filepath.Join(tracer.ArtifactRoot, "build-errors.log")
+	if mkErr := os.MkdirAll(tracer.ArtifactRoot, 0755); mkErr != nil {
+		failWith(exitBuild, "Error building binary: %v", err)
+	}
+	if writeErr := os.WriteFile(logPath, buildErr.Output, 0644); writeErr != nil {
+		failWith(exitBuild, "Error building binary: %v", err)
+	}
+	msg := fmt.Sprintf("Error building binary: %v; the compiler output is in %s", buildErr.Err, logPath)
+	if jsonOutput {
+		writeResult(commandResult{Status: statusError, Error: msg, Artifacts: map[string]string{"buildLog": logPath}, Findings: buildErr.Diagnoses})
+		os.Exit(exitBuild)
+	}
+	fmt.Println("The instrumented project does not build:")
+	instrument.WriteDiagnoses(os.Stdout, buildErr.Diagnoses)
+	failWith(exitBuild, "%s", msg)
+}
+
+// dashboardEndpoint returns how the dashboard reaches the control endpoint configured by cfg. A
+// TLS certificate is trusted as its own CA, which covers the usual self-signed certificate.
+//
+// Parameters:
+//   - cfg (config.ControlConfig): the tracing.control configuration.
+//
+// Returns:
+//   - controlEndpoint: the endpoint.
+func dashboardEndpoint(cfg config.ControlConfig) controlEndpoint {
+	control := controlEndpoint{Addr: cfg.Listen, Token: httpauth.ResolveToken(cfg.Token, cfg.TokenEnv)}
+	if cfg.TLSCert != "" {
+		control.Addr = "https://" + cfg.Listen
+		control.CAFile = cfg.TLSCert
+	}
+	return control
+}
+
+// runCaptured runs the instrumented binary with its output captured to app.log in the run's
+// artifact directory, each line timestamped and, once the run's trace is available, tagged with the
+// span that was running. The output is also shown on the console, unless the dashboard is.
+//
+// Parameters:
+//   - binaryPath (string): the path to the instrumented binary.
+//   - args ([]string): additional arguments to pass to the binary.
+//   - control (controlEndpoint): the control endpoint, used by the dashboard.
+//   - runDir (string): the run's artifact directory, e.g. "tracewrap/20240101-120000-4242".
+//
+// Returns:
+//   - error: an error if the binary cannot be run or exits with an error.
+func runCaptured(binaryPath string, args []string, control controlEndpoint, runDir string) error {
+	logPath := filepath.Join(runDir, "app.log")
+	capture, err := applog.NewCapture(logPath)
+	if err != nil {
+		return err
+	}
+	var stdoutPass, stderrPass io.Writer = os.Stdout, os.Stderr
+	if dashboard {
+		stdoutPass, stderrPass = nil, nil
+	}
+	stdout, stderr := capture.Writer("stdout", stdoutPass), capture.Writer("stderr", stderrPass)
+	running, err := instrument.StartInstrumentedBinary(binaryPath, args, stdout, stderr)
+	if err != nil {
+		return err
+	}
+	if dashboard {
+		err = runDashboard(control, running, logPath)
+	} else {
+		err = running.Wait()
+	}
+	stdout.Close()
+	stderr.Close()
+
+	records, traceErr := tracer.ReadTraceFile(filepath.Join(runDir, "trace.jsonl"))
+	if traceErr != nil && ciMode {
+		return fmt.Errorf("no trace records to correlate application output with: %v", traceErr)
+	} else if traceErr != nil {
+		fmt.Println("No trace records to correlate application output with:", traceErr)
+	}
+	if _, finishErr := capture.Finish(records); finishErr != nil {
+		return finishErr
+	}
+	progress("Application output captured to:", logPath)
+	return err
+}
+
 func init() {
 	rootCmd.AddCommand(buildCmd)
 
 	buildCmd.Flags().StringVarP(&projectDir, "project", "p", "", "Path to the target Go project")
 	buildCmd.Flags().StringVarP(&configPath, "config", "c", "tracewrap.yaml", "Path to the configuration YAML file")
 	buildCmd.Flags().StringVar(&appName, "name", "", "Name of the application (binary will be moved as <name>-tracewrap)")
+	buildCmd.Flags().StringVarP(&outputPath, "output", "o", "", "Path for the instrumented binary; may use {{.App}}, {{.GOOS}} and {{.GOARCH}}")
+	buildCmd.Flags().BoolVar(&race, "race", false, "Build with the race detector and race-safe value capture")
+	buildCmd.Flags().BoolVar(&dashboard, "dashboard", false, "Show a live dashboard fed by the control endpoint while the binary runs")
+	buildCmd.Flags().BoolVar(&captureOutput, "capture-output", false, "Also write the binary's output to tracewrap/latest/app.log, timestamped and tagged with span IDs")
 }
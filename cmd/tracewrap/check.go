@@ -0,0 +1,181 @@
+// cmd/tracewrap/check.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/ghactions"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/store"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkTrace       string
+	checkConfigArg   string
+	checkInventory   string
+	checkBaseline    string
+	checkStore       string
+	checkMaxSlowdown float64
+	checkMinCalls    int
+)
+
+// checkFinding is a JSON finding of check: the result of a budget, with kind "budget", or a
+// function slower than in the baseline, with kind "regression".
+type checkFinding struct {
+	Kind string `json:"kind"`
+	*analysis.BudgetResult
+	Baseline string `json:"baseline,omitempty"` // The name of the baseline of a regression.
+	*analysis.FunctionDelta
+}
+
+// checkFindings lists the budget results and the regressions of check as its JSON findings.
+//
+// Parameters:
+//   - budgets ([]analysis.BudgetResult): the results of the budgets.
+//   - baseline (string): the name of the baseline the run was compared with, or "".
+//   - regressions ([]analysis.FunctionDelta): the functions slower than in the baseline.
+//
+// Returns:
+//   - []checkFinding: the budgets, then the regressions.
+func checkFindings(budgets []analysis.BudgetResult, baseline string, regressions []analysis.FunctionDelta) []checkFinding {
+	findings := make([]checkFinding, 0, len(budgets)+len(regressions))
+	for i := range budgets {
+		findings = append(findings, checkFinding{Kind: "budget", BudgetResult: &budgets[i]})
+	}
+	for i := range regressions {
+		findings = append(findings, checkFinding{Kind: "regression", Baseline: baseline, FunctionDelta: &regressions[i]})
+	}
+	return findings
+}
+
+// checkCmd checks a traced run against the performance budgets of the configuration and a
+// named baseline.
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check a traced run against the per-package performance budgets and a baseline.",
+	Long: `check reads a trace file and evaluates the budgets of --config on it, such as
+
+  budgets:
+    - package: internal/shared/...
+      maxTimeShare: 5
+      maxAllocBytes: 67108864
+
+A package is a directory relative to the project root, and a pattern ending in /... includes its
+subdirectories. The time share of the packages is the time spent in their own functions, less the
+calls those make into other packages, as a percentage of the run's time; allocations are the heap
+growth those functions cause, in bytes. Functions are assigned to packages through the inventory
+written by buildTracedApplication.
+
+With --baseline, the run is also compared with that named baseline (see tracewrap baseline save),
+and every function called at least --min-calls times in both whose mean duration grew by more than
+--max-slowdown percent is a regression. A missing --config is then not an error.
+
+The command exits with status 5 if any budget is exceeded or a function regressed, so it can gate
+CI pipelines, and with status 2 for an invalid configuration.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.LoadConfig(checkConfigArg)
+		if os.IsNotExist(err) && checkBaseline != "" {
+			cfg, err = &config.Config{}, nil
+		}
+		if err != nil {
+			failWith(exitConfig, "Error loading configuration: %v", err)
+		}
+		if err := cfg.Validate(); err != nil {
+			failWith(exitConfig, "Invalid configuration: %v", err)
+		}
+		if len(cfg.Budgets) == 0 && checkBaseline == "" {
+			failWith(exitConfig, "%s declares no budgets and no --baseline is given", checkConfigArg)
+		}
+		records, err := tracer.ReadTraceFile(checkTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		var budgets []analysis.BudgetResult
+		var regressions []analysis.FunctionDelta
+		artifacts := map[string]string{"trace": checkTrace}
+		exceeded := 0
+		if len(cfg.Budgets) > 0 {
+			inv, err := instrument.ReadInventory(checkInventory)
+			if err != nil {
+				fail("Error reading inventory: %v", err)
+			}
+			artifacts["inventory"] = checkInventory
+			budgets = analysis.Budgets(cfg.Budgets, inv, records)
+			for _, r := range budgets {
+				if len(r.Exceeded) > 0 {
+					exceeded++
+					annotate(ghactions.Error, "", "Budget exceeded: "+r.Budget.Package,
+						"%s over budget (%s): %.1f%% of the run's time, %d B allocated", r.Budget.Package, strings.Join(r.Exceeded, ", "), r.TimeShare, r.Alloc)
+				}
+			}
+		}
+		if checkBaseline != "" {
+			s, err := store.Open(checkStore)
+			if err != nil {
+				fail("%v", err)
+			}
+			b, err := s.Baseline(checkBaseline)
+			if err != nil {
+				failWith(exitConfig, "%v", err)
+			}
+			artifacts["store"] = s.Dir()
+			regressions = analysis.Regressions(analysis.Compare(b.Functions, analysis.Aggregate(records)), checkMaxSlowdown, checkMinCalls)
+			for _, d := range regressions {
+				annotate(ghactions.Error, d.Name, "Regression: "+d.Name, "%s is %.1f%% slower than in baseline %s: mean %v, was %v",
+					d.Name, d.MeanChange, b.Name, d.Current.Mean, d.Base.Mean)
+			}
+		}
+		failed := exceeded > 0 || len(regressions) > 0
+		if jsonOutput {
+			res := commandResult{Status: statusOK, Artifacts: artifacts, Findings: checkFindings(budgets, checkBaseline, regressions)}
+			if failed {
+				res.Status = statusFailed
+			}
+			writeResult(res)
+		} else {
+			if len(budgets) > 0 {
+				if err := analysis.WriteBudgets(os.Stdout, budgets); err != nil {
+					fail("Error writing report: %v", err)
+				}
+			}
+			if exceeded > 0 {
+				fmt.Printf("%d of %d budgets exceeded\n", exceeded, len(budgets))
+			}
+			if checkBaseline != "" {
+				if len(budgets) > 0 {
+					fmt.Println()
+				}
+				if len(regressions) == 0 {
+					fmt.Printf("No function is more than %.0f%% slower than in baseline %s\n", checkMaxSlowdown, checkBaseline)
+				} else {
+					if err := analysis.WriteComparison(os.Stdout, regressions); err != nil {
+						fail("Error writing report: %v", err)
+					}
+					fmt.Printf("%d functions more than %.0f%% slower than in baseline %s\n", len(regressions), checkMaxSlowdown, checkBaseline)
+				}
+			}
+		}
+		if failed {
+			os.Exit(exitRegression)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+	checkCmd.Flags().StringVar(&checkTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+	checkCmd.Flags().StringVar(&checkConfigArg, "config", "tracewrap.yaml", "Path to the configuration file with the budgets")
+	checkCmd.Flags().StringVar(&checkInventory, "inventory", "tracewrap/inventory.json", "Path to the inventory written by buildTracedApplication")
+	checkCmd.Flags().StringVar(&checkBaseline, "baseline", "", "Name of a baseline in the store to check the run against")
+	checkCmd.Flags().StringVar(&checkStore, "store", store.DefaultDir, "Path to the store with the baseline")
+	checkCmd.Flags().Float64Var(&checkMaxSlowdown, "max-slowdown", 20, "Largest growth of a function's mean duration over the baseline, in percent")
+	checkCmd.Flags().IntVar(&checkMinCalls, "min-calls", 10, "Fewest calls of a function in the baseline and the run to check it")
+}
@@ -0,0 +1,143 @@
+// cmd/tracewrap/collector.go
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/alert"
+	"github.com/mwiater/tracewrap/pkg/collector"
+	"github.com/mwiater/tracewrap/pkg/httpauth"
+	"github.com/mwiater/tracewrap/pkg/theme"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	collectorListen        string
+	collectorDir           string
+	collectorKeep          int
+	collectorMaxAge        time.Duration
+	collectorMaxSize       int64
+	collectorPruneInterval time.Duration
+	collectorToken         string
+	collectorTLSCert       string
+	collectorTLSKey        string
+	collectorConfig        string
+)
+
+// collectorCmd runs the HTTP ingest server instrumented binaries ship their traces to.
+var collectorCmd = &cobra.Command{
+	Use:   "collector",
+	Short: "Receive, keep and browse traces shipped by instrumented binaries over HTTP.",
+	Long: `collector starts an HTTP server that accepts the trace records and run metadata of
+instrumented binaries configured with tracing.collector.endpoint, e.g. services running in
+containers whose local files are lost with the pod. Any number of binaries can upload at once.
+Each run is stored in <dir>/<run id>-<host>/ like a local run, so the runs, analyze, replay and
+store commands work on the collected traces.
+
+--keep, --max-age and --max-size set the retention policy, applied every --prune-interval. The same address
+serves a web UI listing the runs, with the functions of each run by total time.
+
+Traces contain parameter values that may be sensitive. With --token (or the TRACEWRAP_TOKEN
+environment variable), uploads, the API and the UI require the token, which binaries present
+with tracing.collector.token; browsers are asked for it as a password. --tls-cert and --tls-key
+serve over HTTPS. /healthz stays open for liveness probes.
+
+With --config, the alerts.rules of that configuration file are evaluated on each run whenever it
+flushes, and each rule that fires is posted to its webhook once per run. Its
+visualization.traceLinks link the external traces of a run, adopted from the traceparent headers
+of the requests it served, to Jaeger, Tempo or another trace system, and its visualization.theme
+colors the web UI.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		server, err := collector.New(collectorDir)
+		if err != nil {
+			fail("Error starting collector: %v", err)
+		}
+		server.Logf = func(format string, args ...interface{}) {
+			fmt.Printf(format+"\n", args...)
+		}
+		server.Retention = collector.Retention{Keep: collectorKeep, MaxAge: collectorMaxAge, MaxSize: collectorMaxSize}
+		if collectorConfig != "" {
+			if err := configureCollector(server, collectorConfig); err != nil {
+				failWith(exitConfig, "Error loading %s: %v", collectorConfig, err)
+			}
+		}
+		if (collectorKeep > 0 || collectorMaxAge > 0 || collectorMaxSize > 0) && collectorPruneInterval > 0 {
+			go func() {
+				for now := range time.Tick(collectorPruneInterval) {
+					if _, err := server.Prune(now); err != nil {
+						fmt.Printf("Error pruning runs: %v\n", err)
+					}
+				}
+			}()
+		}
+		if err := httpauth.CheckTLSFiles(collectorTLSCert, collectorTLSKey); err != nil {
+			fail("Error starting collector: %v", err)
+		}
+		listener, err := net.Listen("tcp", collectorListen)
+		if err != nil {
+			fail("Error starting collector: %v", err)
+		}
+		scheme := "http"
+		if collectorTLSCert != "" {
+			scheme = "https"
+		}
+		token := httpauth.ResolveToken(collectorToken, httpauth.TokenEnv)
+		if token == "" {
+			fmt.Println("Warning: no --token set; anyone who can reach the collector can read and upload traces.")
+		}
+		fmt.Printf("Collector listening on %s://%s, storing runs in %s\n", scheme, listener.Addr(), server.Dir())
+		handler := httpauth.Require(token, server.Handler(), "/healthz")
+		if err := httpauth.Serve(listener, handler, collectorTLSCert, collectorTLSKey); err != nil {
+			fail("Collector stopped: %v", err)
+		}
+	},
+}
+
+// configureCollector applies the alerting rules, trace links and theme of a configuration file to
+// server.
+//
+// Parameters:
+//   - server (*collector.Server): the collector.
+//   - path (string): the configuration file.
+//
+// Returns:
+//   - error: an error if the file cannot be loaded or is invalid.
+func configureCollector(server *collector.Server, path string) error {
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if len(cfg.Alerts.Rules) > 0 {
+		if server.Alerts, err = alert.Load(cfg.Alerts); err != nil {
+			return err
+		}
+	}
+	for _, link := range cfg.Visualization.TraceLinks {
+		server.TraceLinks = append(server.TraceLinks, collector.TraceLink{Name: link.Name, URL: link.URL})
+	}
+	server.Palette, err = theme.New(cfg.Visualization.Theme.Name, cfg.Visualization.Theme.Palette)
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(collectorCmd)
+	collectorCmd.Flags().StringVar(&collectorListen, "listen", ":4321", "Address to listen on")
+	collectorCmd.Flags().StringVar(&collectorDir, "dir", tracer.ArtifactRoot, "Directory to store the collected runs in")
+	collectorCmd.Flags().IntVar(&collectorKeep, "keep", 0, "Keep only this many of the most recent runs (0 keeps all)")
+	collectorCmd.Flags().DurationVar(&collectorMaxAge, "max-age", 0, "Remove runs that have received no upload for this long, e.g. 168h (0 disables)")
+	collectorCmd.Flags().Int64Var(&collectorMaxSize, "max-size", 0, "Remove the oldest runs while the runs use more than this many bytes (0 disables)")
+	collectorCmd.Flags().DurationVar(&collectorPruneInterval, "prune-interval", time.Minute, "How often the retention policy is applied")
+	collectorCmd.Flags().StringVar(&collectorToken, "token", "", "Token required from clients (default $"+httpauth.TokenEnv+")")
+	collectorCmd.Flags().StringVar(&collectorTLSCert, "tls-cert", "", "PEM certificate file to serve HTTPS with")
+	collectorCmd.Flags().StringVar(&collectorTLSKey, "tls-key", "", "PEM private key file of --tls-cert")
+	collectorCmd.Flags().StringVar(&collectorConfig, "config", "", "Configuration file whose alerts.rules are evaluated on uploaded runs and whose visualization.traceLinks and theme are shown")
+}
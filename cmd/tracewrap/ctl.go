@@ -0,0 +1,165 @@
+// cmd/tracewrap/ctl.go
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/httpauth"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ctlAddr  string
+	ctlToken string
+	ctlCA    string
+)
+
+// controlEndpoint identifies a control endpoint and the credentials used with it.
+type controlEndpoint struct {
+	Addr   string // host:port, or a URL such as "https://10.0.0.5:7070".
+	Token  string // The tracing.control token, or "" when none is required.
+	CAFile string // PEM CA certificates to verify an https endpoint with, or "".
+}
+
+// ctlCmd is the parent command for talking to the control endpoint of a running instrumented binary.
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "Control a running instrumented binary.",
+	Long: `The ctl command talks to the control endpoint embedded in an instrumented binary
+(enabled with tracing.control.listen in tracewrap.yaml) to inspect its status, start or
+stop recording, change the sample rate, or trigger a dump of the call graph and trace file.
+
+Endpoints protected with tracing.control.token need --token (or the TRACEWRAP_TOKEN
+environment variable). For endpoints served over TLS, pass an https:// address and, for a
+self-signed certificate, --ca with the certificate.`,
+	// No Run functionality; this command exists solely to group subcommands.
+}
+
+// ctlStatusCmd prints the tracer status.
+var ctlStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the tracer status of a running instrumented binary.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCtlRequest(http.MethodGet, "/status")
+	},
+}
+
+// ctlStartCmd resumes recording.
+var ctlStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Resume recording in a running instrumented binary.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCtlRequest(http.MethodPost, "/start")
+	},
+}
+
+// ctlStopCmd stops recording.
+var ctlStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop recording in a running instrumented binary.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCtlRequest(http.MethodPost, "/stop")
+	},
+}
+
+// ctlSampleCmd changes the sample rate.
+var ctlSampleCmd = &cobra.Command{
+	Use:   "sample <rate>",
+	Short: "Change the sample rate of a running instrumented binary.",
+	Long:  `Sets the fraction of root calls recorded, e.g. "tracewrap ctl sample 0.1" records one in ten.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCtlRequest(http.MethodPost, "/sample?rate="+url.QueryEscape(args[0]))
+	},
+}
+
+// ctlDumpCmd triggers a dump.
+var ctlDumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Write the call graph and flush the trace file of a running instrumented binary.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCtlRequest(http.MethodPost, "/dump")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ctlCmd)
+	ctlCmd.PersistentFlags().StringVar(&ctlAddr, "addr", "127.0.0.1:7070", "Address of the control endpoint")
+	ctlCmd.PersistentFlags().StringVar(&ctlToken, "token", "", "Token of the control endpoint (default $"+httpauth.TokenEnv+")")
+	ctlCmd.PersistentFlags().StringVar(&ctlCA, "ca", "", "PEM file of CA certificates to verify an https endpoint with")
+	ctlCmd.AddCommand(ctlStatusCmd, ctlStartCmd, ctlStopCmd, ctlSampleCmd, ctlDumpCmd)
+}
+
+// runCtlRequest sends a request to the control endpoint and prints the response body,
+// exiting with a non-zero status on failure.
+func runCtlRequest(method, path string) {
+	body, err := ctlRequest(method, path)
+	if err != nil {
+		fail("Error contacting control endpoint: %v", err)
+	}
+	fmt.Print(string(body))
+}
+
+// ctlRequest sends a request to the control endpoint at ctlAddr, with the --token and --ca
+// flags, and returns the response body.
+//
+// Parameters:
+//   - method (string): the HTTP method.
+//   - path (string): the request path, including any query string.
+//
+// Returns:
+//   - []byte: the response body.
+//   - error: an error if the request fails or the endpoint reports an error.
+func ctlRequest(method, path string) ([]byte, error) {
+	return controlRequest(controlEndpoint{Addr: ctlAddr, Token: httpauth.ResolveToken(ctlToken, httpauth.TokenEnv), CAFile: ctlCA}, method, path)
+}
+
+// controlRequest sends a request to a control endpoint and returns the response body.
+//
+// Parameters:
+//   - control (controlEndpoint): the endpoint; its address may omit the scheme.
+//   - method (string): the HTTP method.
+//   - path (string): the request path, including any query string.
+//
+// Returns:
+//   - []byte: the response body.
+//   - error: an error if the request fails or the endpoint reports an error.
+func controlRequest(control controlEndpoint, method, path string) ([]byte, error) {
+	base := control.Addr
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(base, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpauth.Authorize(req, control.Token)
+	client, err := httpauth.Client(control.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = 5 * time.Second
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
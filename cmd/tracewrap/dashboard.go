@@ -0,0 +1,92 @@
+// cmd/tracewrap/dashboard.go
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// dashboardInterval is how often the dashboard polls the control endpoint.
+const dashboardInterval = time.Second
+
+// runDashboard redraws a live view of the tracer statistics served by control until cmd exits.
+//
+// Parameters:
+//   - control (controlEndpoint): the control endpoint of the instrumented binary.
+//   - cmd (*exec.Cmd): the started instrumented binary.
+//   - logPath (string): where the binary's output is written, shown in the footer.
+//
+// Returns:
+//   - error: the error returned by waiting for the binary, if any.
+func runDashboard(control controlEndpoint, cmd *exec.Cmd, logPath string) error {
+	addr := control.Addr
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	ticker := time.NewTicker(dashboardInterval)
+	defer ticker.Stop()
+	var prev *tracer.Stats
+	prevAt := time.Now()
+	for {
+		select {
+		case err := <-done:
+			fmt.Println("Instrumented binary exited; output was written to", logPath)
+			return err
+		case now := <-ticker.C:
+			body, err := controlRequest(control, http.MethodGet, "/stats?top=10")
+			var frame bytes.Buffer
+			if err != nil {
+				fmt.Fprintf(&frame, "tracewrap dashboard - waiting for control endpoint at %s (%v)\n", addr, err)
+			} else {
+				var stats tracer.Stats
+				if err := json.Unmarshal(body, &stats); err != nil {
+					fmt.Fprintf(&frame, "tracewrap dashboard - unexpected response from %s: %v\n", addr, err)
+				} else {
+					renderDashboard(&frame, addr, stats, prev, now.Sub(prevAt))
+					prev, prevAt = &stats, now
+				}
+			}
+			fmt.Fprintf(&frame, "\nApplication output: %s   (Ctrl+C to stop)\n", logPath)
+			// Move the cursor home and clear the screen before drawing the new frame.
+			os.Stdout.WriteString("\033[H\033[2J")
+			os.Stdout.Write(frame.Bytes())
+		}
+	}
+}
+
+// renderDashboard writes one dashboard frame for stats. Calls per second are computed from the
+// previous frame's total, if there is one.
+func renderDashboard(buf *bytes.Buffer, addr string, stats tracer.Stats, prev *tracer.Stats, elapsed time.Duration) {
+	recording := "on"
+	if !stats.Recording {
+		recording = "off"
+	}
+	rate := 0.0
+	if prev != nil && elapsed > 0 {
+		rate = float64(stats.TotalCalls-prev.TotalCalls) / elapsed.Seconds()
+	}
+	fmt.Fprintf(buf, "\033[1mtracewrap dashboard\033[0m  %s  uptime %v\n\n", addr, stats.Uptime.Round(time.Second))
+	fmt.Fprintf(buf, "calls/sec: %-10.1f total calls: %-10d active spans: %-6d goroutines: %d\n", rate, stats.TotalCalls, stats.ActiveSpans, stats.Goroutines)
+	fmt.Fprintf(buf, "recording: %-10s sample rate: %-9g records: %d in memory, %d spilled\n\n", recording, stats.SampleRate, stats.RecordsInMemory, stats.RecordsSpilled)
+
+	fmt.Fprintln(buf, "\033[1mTop functions by total time\033[0m")
+	if len(stats.TopFunctions) == 0 {
+		fmt.Fprintln(buf, "  (no completed calls yet)")
+		return
+	}
+	tw := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  FUNCTION\tCALLS\tTOTAL\tAVG\t")
+	for _, fn := range stats.TopFunctions {
+		fmt.Fprintf(tw, "  %s\t%d\t%v\t%v\t\n", fn.Name, fn.Calls, fn.Total.Round(time.Microsecond), fn.Avg.Round(time.Microsecond))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,126 @@
+// cmd/tracewrap/diff.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/store"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffTrace    string
+	diffBase     string
+	diffBaseline string
+	diffStore    string
+	diffTop      int
+	diffHTML     string
+	diffConfig   string
+)
+
+// diffCmd compares the per-function stats of a trace with a baseline.
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare the per-function stats of a trace with a baseline.",
+	Long: `diff aggregates the records of --trace per function and compares them with the named baseline
+--baseline from the store (see tracewrap baseline save) or with another trace file, --base. Functions
+in both are listed most slowed down first, by the change of their mean duration, followed by the
+functions new in --trace and those it no longer calls.
+
+With --html the comparison is also written to an HTML page. Against a --base trace the page overlays
+the flame graphs of both traces and lists their call trees side by side, with the change of every
+call path highlighted; a baseline keeps no call trees, so against --baseline it lists the functions only.
+The page is colored by the visualization.theme of --config.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if (diffBase == "") == (diffBaseline == "") {
+			failWith(exitConfig, "Give either --baseline or --base")
+		}
+		artifacts := map[string]string{"trace": diffTrace}
+		var base []analysis.FunctionStats
+		var baseRecords []tracer.TraceRecord
+		baseName := diffBase
+		if diffBaseline != "" {
+			s, err := store.Open(diffStore)
+			if err != nil {
+				fail("%v", err)
+			}
+			b, err := s.Baseline(diffBaseline)
+			if err != nil {
+				failWith(exitConfig, "%v", err)
+			}
+			base = b.Functions
+			baseName = "baseline " + b.Name
+			artifacts["store"] = s.Dir()
+		} else {
+			records, err := readAnalyzedTrace(diffBase)
+			if err != nil {
+				fail("Error reading base trace file: %v", err)
+			}
+			base = analysis.Aggregate(records)
+			baseRecords = records
+			artifacts["base"] = diffBase
+		}
+		records, err := readAnalyzedTrace(diffTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		deltas := analysis.Compare(base, analysis.Aggregate(records))
+		if diffHTML != "" {
+			var tree *analysis.CallNode
+			if baseRecords != nil {
+				tree = analysis.CompareCallTrees(baseRecords, records)
+			}
+			if err := writeComparisonPage(diffHTML, baseName, tree, deltas); err != nil {
+				fail("Error writing HTML report: %v", err)
+			}
+			artifacts["html"] = diffHTML
+		}
+		if diffTop > 0 && len(deltas) > diffTop {
+			deltas = deltas[:diffTop]
+		}
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: artifacts, Findings: deltas})
+			return
+		}
+		if err := analysis.WriteComparison(os.Stdout, deltas); err != nil {
+			fail("Error writing report: %v", err)
+		}
+	},
+}
+
+// writeComparisonPage writes a comparison of --trace with a base to an HTML file.
+func writeComparisonPage(path, base string, tree *analysis.CallNode, deltas []analysis.FunctionDelta) error {
+	palette, err := loadPalette(diffConfig)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %v", diffConfig, err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := analysis.WriteComparisonHTML(file, diffTrace+" vs "+base, base, diffTrace, tree, deltas, palette); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Println("Comparison written to", path)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().StringVar(&diffTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file to compare")
+	diffCmd.Flags().StringVar(&diffBaseline, "baseline", "", "Name of a baseline in the store to compare with")
+	diffCmd.Flags().StringVar(&diffBase, "base", "", "Path to a trace file to compare with, instead of --baseline")
+	diffCmd.Flags().StringVar(&diffStore, "store", store.DefaultDir, "Path to the store with the baseline")
+	diffCmd.Flags().IntVar(&diffTop, "top", 0, "List only the first N functions (0 for all)")
+	diffCmd.Flags().StringVar(&diffConfig, "config", "tracewrap.yaml", "Path to the configuration file whose visualization.theme colors the --html page")
+	diffCmd.Flags().StringVar(&diffHTML, "html", "", "Also write the comparison, with overlaid flame graphs against --base, to this HTML file")
+}
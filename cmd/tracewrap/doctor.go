@@ -0,0 +1,225 @@
+// cmd/tracewrap/doctor.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/shirou/gopsutil/load"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/net"
+	"github.com/shirou/gopsutil/process"
+	"github.com/spf13/cobra"
+)
+
+// minGoVersion is the oldest Go toolchain that can build instrumented binaries; it matches the
+// go directive of the tracewrap module, which instrumented projects depend on, as checked by
+// TestMinGoVersionMatchesGoMod.
+const minGoVersion = "1.25"
+
+var doctorConfigPath string
+
+// doctorCheck is the outcome of one environment check.
+type doctorCheck struct {
+	status string // "OK", "WARN" or "FAIL".
+	name   string
+	detail string
+	fix    string
+}
+
+// doctorCmd checks that the environment can build, run and visualize instrumented binaries.
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the environment and configuration for common problems.",
+	Long: `doctor verifies the Go toolchain version, Graphviz availability, write permissions for
+the workspace and trace artifacts, the system metrics gopsutil can collect on this platform,
+and the validity of tracewrap.yaml. Each problem is printed with a suggested fix, and the command
+exits with a non-zero status if any check fails.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		checks := []doctorCheck{checkGoToolchain(), checkGraphviz()}
+		checks = append(checks, checkWritable("workspace directory", os.TempDir()))
+		if wd, err := os.Getwd(); err == nil {
+			checks = append(checks, checkWritable("artifact directory", wd))
+		}
+		checks = append(checks, checkSystemMetrics()...)
+		checks = append(checks, checkConfig(doctorConfigPath))
+
+		failed := false
+		for _, c := range checks {
+			failed = failed || c.status == "FAIL"
+		}
+		if jsonOutput {
+			res := commandResult{Status: statusOK, Findings: doctorFindings(checks)}
+			if failed {
+				res.Status = statusFailed
+			}
+			writeResult(res)
+		} else {
+			for _, c := range checks {
+				fmt.Printf("[%-4s] %s: %s\n", c.status, c.name, c.detail)
+				if c.fix != "" {
+					fmt.Printf("       fix: %s\n", c.fix)
+				}
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// doctorFinding is a doctorCheck as printed with --json.
+type doctorFinding struct {
+	Status string `json:"status"`
+	Name   string `json:"name"`
+	Detail string `json:"detail"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+// doctorFindings converts checks for the JSON result.
+func doctorFindings(checks []doctorCheck) []doctorFinding {
+	findings := make([]doctorFinding, len(checks))
+	for i, c := range checks {
+		findings[i] = doctorFinding{Status: c.status, Name: c.name, Detail: c.detail, Fix: c.fix}
+	}
+	return findings
+}
+
+// checkGoToolchain verifies that a Go toolchain is on PATH and new enough.
+func checkGoToolchain() doctorCheck {
+	c := doctorCheck{name: "Go toolchain"}
+	out, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		c.status, c.detail = "FAIL", fmt.Sprintf("go not found: %v", err)
+		c.fix = "install Go " + minGoVersion + " or newer from https://go.dev/dl/ and make sure it is on PATH"
+		return c
+	}
+	version := strings.TrimSpace(string(out))
+	if !goVersionAtLeast(strings.TrimPrefix(version, "go"), minGoVersion) {
+		c.status, c.detail = "FAIL", version+" is older than go"+minGoVersion
+		c.fix = "upgrade Go to " + minGoVersion + " or newer"
+		return c
+	}
+	c.status, c.detail = "OK", version
+	return c
+}
+
+// goVersionAtLeast reports whether the dotted version have is at least want, comparing the major
+// and minor components.
+func goVersionAtLeast(have, want string) bool {
+	parse := func(v string) (int, int) {
+		parts := strings.SplitN(v, ".", 3)
+		major, _ := strconv.Atoi(parts[0])
+		minor := 0
+		if len(parts) > 1 {
+			// Strip pre-release suffixes such as "24rc1".
+			digits := strings.TrimRightFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' })
+			minor, _ = strconv.Atoi(digits)
+		}
+		return major, minor
+	}
+	haveMajor, haveMinor := parse(have)
+	wantMajor, wantMinor := parse(want)
+	return haveMajor > wantMajor || (haveMajor == wantMajor && haveMinor >= wantMinor)
+}
+
+// checkGraphviz reports whether the dot tool used by generate callgraphImage is installed.
+func checkGraphviz() doctorCheck {
+	c := doctorCheck{name: "Graphviz"}
+	path, err := exec.LookPath("dot")
+	if err != nil {
+		c.status, c.detail = "WARN", "dot not found; callgraph.dot cannot be rendered to PNG"
+		c.fix = "install Graphviz (e.g. sudo apt-get install graphviz, brew install graphviz)"
+		return c
+	}
+	c.status, c.detail = "OK", path
+	return c
+}
+
+// checkWritable verifies that files can be created in dir.
+func checkWritable(name, dir string) doctorCheck {
+	c := doctorCheck{name: name}
+	file, err := os.CreateTemp(dir, ".tracewrap_doctor_*")
+	if err != nil {
+		c.status, c.detail = "FAIL", fmt.Sprintf("cannot write to %s: %v", dir, err)
+		c.fix = "run tracewrap from a directory you can write to, or fix the permissions of " + dir
+		return c
+	}
+	file.Close()
+	os.Remove(file.Name())
+	c.status, c.detail = "OK", filepath.Clean(dir)
+	return c
+}
+
+// checkSystemMetrics reports which gopsutil collectors work on this platform. Failing collectors
+// make the tracer report zeros for the corresponding fields.
+func checkSystemMetrics() []doctorCheck {
+	probes := []struct {
+		name  string
+		field string
+		probe func() error
+	}{
+		{"load average", "systemCpuLoad", func() error { _, err := load.Avg(); return err }},
+		{"system memory", "systemMemUsage", func() error { _, err := mem.VirtualMemory(); return err }},
+		{"network counters", "netUsageDelta", func() error { _, err := net.IOCounters(false); return err }},
+		{"process CPU time", "CPU time", func() error {
+			proc, err := process.NewProcess(int32(os.Getpid()))
+			if err != nil {
+				return err
+			}
+			_, err = proc.Times()
+			return err
+		}},
+		{"process disk I/O", "diskUsageDelta", func() error {
+			proc, err := process.NewProcess(int32(os.Getpid()))
+			if err != nil {
+				return err
+			}
+			_, err = proc.IOCounters()
+			return err
+		}},
+	}
+	var checks []doctorCheck
+	for _, p := range probes {
+		c := doctorCheck{name: "metrics: " + p.name, status: "OK", detail: "available"}
+		if err := p.probe(); err != nil {
+			c.status, c.detail = "WARN", fmt.Sprintf("unavailable (%v); %s will be reported as 0", err, p.field)
+			c.fix = "expected on some platforms and containers; on Linux make sure /proc is mounted and readable"
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// checkConfig loads and validates the configuration file.
+func checkConfig(path string) doctorCheck {
+	if path == "" {
+		path = "tracewrap.yaml"
+	}
+	c := doctorCheck{name: "configuration"}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		c.status, c.detail = "FAIL", fmt.Sprintf("cannot load %s: %v", path, err)
+		c.fix = "create it from tracewrap.yaml.example or pass --config"
+		return c
+	}
+	if err := cfg.Validate(); err != nil {
+		c.status, c.detail = "FAIL", fmt.Sprintf("%s is invalid:\n         %s", path, strings.ReplaceAll(err.Error(), "\n", "\n         "))
+		c.fix = "correct the keys listed above (see tracewrap.yaml.example)"
+		return c
+	}
+	c.status, c.detail = "OK", path
+	return c
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().StringVar(&doctorConfigPath, "config", "tracewrap.yaml", "Path to the configuration file")
+}
@@ -0,0 +1,28 @@
+package cmd_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	cmd "github.com/mwiater/tracewrap/cmd/tracewrap"
+)
+
+func TestMinGoVersionMatchesGoMod(t *testing.T) {
+	data, err := os.ReadFile("../../go.mod")
+	if err != nil {
+		t.Fatalf("Failed to read go.mod: %v", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		version, ok := strings.CutPrefix(strings.TrimSpace(line), "go ")
+		if !ok {
+			continue
+		}
+		// The go directive may name a patch release, as in "1.25.0"; doctor checks major.minor.
+		if parts := strings.SplitN(version, ".", 3); len(parts) < 2 || parts[0]+"."+parts[1] != cmd.MinGoVersion {
+			t.Errorf("minGoVersion is %q, but go.mod says go %s", cmd.MinGoVersion, version)
+		}
+		return
+	}
+	t.Fatal("go.mod has no go directive")
+}
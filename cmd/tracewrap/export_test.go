@@ -0,0 +1,4 @@
+package cmd
+
+// MinGoVersion exposes minGoVersion to the tests.
+const MinGoVersion = minGoVersion
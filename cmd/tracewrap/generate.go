@@ -3,6 +3,11 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/theme"
 	"github.com/spf13/cobra"
 )
 
@@ -16,6 +21,32 @@ artifacts such as call graphs, configuration templates, and more for tracewrap.`
 	// No Run functionality; this command exists solely to group subcommands.
 }
 
+// loadPalette returns the palette of the visualization.theme key of the configuration file at
+// path, for the graphs and HTML pages generated from its traces. A missing file means the default
+// palette. Only the theme is validated, so settings the instrumenter or tracer would reject do not
+// stop a graph from being drawn.
+//
+// Parameters:
+//   - path (string): the configuration file.
+//
+// Returns:
+//   - theme.Palette: the palette.
+//   - error: an error if the file cannot be read or its theme is invalid.
+func loadPalette(path string) (theme.Palette, error) {
+	cfg, err := config.LoadConfig(path)
+	if os.IsNotExist(err) {
+		return theme.Default(), nil
+	}
+	if err != nil {
+		return theme.Palette{}, err
+	}
+	palette, err := theme.New(cfg.Visualization.Theme.Name, cfg.Visualization.Theme.Palette)
+	if err != nil {
+		return theme.Palette{}, fmt.Errorf("visualization.theme: %v", err)
+	}
+	return palette, nil
+}
+
 func init() {
 	rootCmd.AddCommand(generateCmd)
 }
@@ -5,32 +5,251 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/graph"
 	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/traceio"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/mwiater/tracewrap/pkg/version"
 	"github.com/spf13/cobra"
 )
 
-var logFile string
+var (
+	logFile            string
+	callgraphMode      string
+	callgraphTraces    []string
+	callgraphServices  []string
+	callgraphInventory string
+	callgraphOutput    string
+	callgraphCluster   string
+	callgraphFormat    string
+	callgraphStatus    string
+	callgraphConfig    string
+)
 
 // callgraphCmd is the subcommand under generate for generating a call graph.
 var callgraphCmd = &cobra.Command{
 	Use:   "callgraph",
-	Short: "Generate a call graph from a tracewrap log file.",
-	Long:  `Parses the specified tracewrap.log file and generates a callgraph.dot file in the same directory.`,
+	Short: "Generate a call graph from a tracewrap log file, or a static, dynamic or overlay graph.",
+	Long: `Parses the specified tracewrap.log file and generates a callgraph.dot file in the same directory.
+
+--mode static, dynamic or overlay draws a function-level graph instead. static shows the calls the
+source code makes, from the inventory buildTracedApplication writes to tracewrap/inventory.json;
+dynamic shows the calls recorded in --trace, with their counts; overlay shows both, with the static
+edges that were never exercised dashed and the functions that never ran greyed out, which helps
+hunting dead code. The graph is written to --output, by default callgraph-<mode>.dot next to the
+trace (or in tracewrap/ for static), and the unexercised static edges are listed. --cluster groups
+the functions of each package, or of each file within its package, in a box labelled with their
+calls and the time spent in them. --format graphml or jsongraph writes the graph as GraphML or in
+the JSON Graph Format instead of DOT, for Gephi, Cytoscape and other graph tools. --status ok or
+--status error draws only the calls with that span status, to compare the paths of failed requests
+with those of successful ones. A legend explains the styles the graph uses, and a footer gives
+the binary, date and duration of the run, its number of calls and the tracewrap version, from the
+run.json next to the trace, so a graph shared on its own describes itself. The colors are those of
+the visualization.theme of --config, a light or dark theme with colorblind-safe colors by default.
+
+Repeat --trace to merge the traces of several processes, e.g. the services of a system uploading to
+one collector, in a dynamic or overlay graph. Function names are prefixed with the service name,
+"api:main.handle", each service is drawn in a bold box holding its packages, and the requests one
+service served for another are drawn as bold orange edges between them. Services are named by
+--service, given once per --trace in the same order, or after the binary in the run.json next to
+each trace. Each service's inventory is read from the inventory.json above its run directory
+(tracewrap/inventory.json for tracewrap/latest/trace.jsonl), when there is one.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if callgraphMode != "log" || callgraphFormat != graph.DOT || len(callgraphTraces) > 1 {
+			if err := writeModeCallGraph(); err != nil {
+				fail("Error generating call graph: %v", err)
+			}
+			return
+		}
 		if logFile == "" {
-			fmt.Println("Please specify the path to the tracewrap log file using the --log flag.")
-			os.Exit(1)
+			failWith(exitConfig, "Please specify the path to the tracewrap log file using the --log flag.")
 		}
-		if err := instrument.ParseLogAndGenerateCallGraph(logFile); err != nil {
-			fmt.Printf("Error generating call graph: %v\n", err)
-			os.Exit(1)
+		palette, err := loadPalette(callgraphConfig)
+		if err != nil {
+			fail("Error generating call graph: failed to load %s: %v", callgraphConfig, err)
+		}
+		if err := instrument.ParseLogAndGenerateCallGraph(logFile, palette); err != nil {
+			fail("Error generating call graph: %v", err)
 		}
 		fmt.Println("Call graph generated successfully.")
 	},
 }
 
+// writeModeCallGraph writes the static, dynamic or overlay call graph selected by --mode.
+//
+// Returns:
+//   - error: an error if the inputs cannot be read or the graph cannot be written.
+func writeModeCallGraph() error {
+	if callgraphMode == "log" {
+		if len(callgraphTraces) > 1 {
+			return fmt.Errorf("several --trace need --mode dynamic or overlay")
+		}
+		return fmt.Errorf("--format %s needs --mode static, dynamic or overlay", callgraphFormat)
+	}
+	if callgraphMode != graph.Static && callgraphMode != graph.Dynamic && callgraphMode != graph.Overlay {
+		return fmt.Errorf("unknown mode %q; use log, static, dynamic or overlay", callgraphMode)
+	}
+	ext := map[string]string{graph.DOT: ".dot", graph.GraphML: ".graphml", graph.JSONGraph: ".json"}[callgraphFormat]
+	if ext == "" {
+		return fmt.Errorf("unknown format %q; use dot, graphml or jsongraph", callgraphFormat)
+	}
+	if len(callgraphTraces) == 0 {
+		return fmt.Errorf("no --trace given")
+	}
+	output := callgraphOutput
+	var g graph.Graph
+	if len(callgraphTraces) > 1 || len(callgraphServices) > 0 {
+		if callgraphMode == graph.Static {
+			return fmt.Errorf("--mode static draws one project; merging traces needs --mode dynamic or overlay")
+		}
+		if len(callgraphServices) > 0 && len(callgraphServices) != len(callgraphTraces) {
+			return fmt.Errorf("give one --service per --trace: %d services for %d traces", len(callgraphServices), len(callgraphTraces))
+		}
+		var err error
+		if g, err = buildServicesGraph(); err != nil {
+			return err
+		}
+		if output == "" {
+			output = filepath.Join(tracer.ArtifactRoot, "callgraph-"+callgraphMode+"-services"+ext)
+		}
+	} else {
+		// The dynamic graph only needs the inventory to know the package and file of each function.
+		inv, err := instrument.ReadInventory(callgraphInventory)
+		if err != nil && (callgraphMode != graph.Dynamic || !os.IsNotExist(err)) {
+			return fmt.Errorf("failed to read inventory: %v", err)
+		}
+		var records []tracer.TraceRecord
+		if callgraphMode != graph.Static {
+			trace := callgraphTraces[0]
+			if records, err = tracer.ReadTraceFile(trace); err != nil {
+				return fmt.Errorf("failed to read trace file: %v", err)
+			}
+			if records, err = analysis.FilterStatus(records, callgraphStatus); err != nil {
+				return err
+			}
+			if output == "" {
+				output = filepath.Join(filepath.Dir(trace), "callgraph-"+callgraphMode+ext)
+			}
+		} else if output == "" {
+			output = filepath.Join(tracer.ArtifactRoot, "callgraph-static"+ext)
+		}
+		g = graph.Build(inv, records)
+		if g.Footer, err = callgraphFooter(len(inv.Functions)); err != nil {
+			return err
+		}
+	}
+
+	palette, err := loadPalette(callgraphConfig)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %v", callgraphConfig, err)
+	}
+	g.Palette = palette
+
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := graph.Write(file, g, callgraphMode, callgraphFormat, callgraphCluster); err != nil {
+		return err
+	}
+	fmt.Println("Call graph written to:", output)
+	if callgraphMode == graph.Overlay {
+		unexercised := g.Unexercised()
+		fmt.Printf("%d static edges were never exercised\n", len(unexercised))
+		for _, e := range unexercised {
+			fmt.Printf("  %s -> %s\n", e.Caller, e.Callee)
+		}
+	}
+	return nil
+}
+
+// buildServicesGraph merges the traces of --trace into a graph of services.
+//
+// Returns:
+//   - graph.Graph: the graph.
+//   - error: an error if a trace or inventory cannot be read, or the service names clash.
+func buildServicesGraph() (graph.Graph, error) {
+	services := make([]graph.Service, len(callgraphTraces))
+	var footer []string
+	for i, path := range callgraphTraces {
+		records, err := tracer.ReadTraceFile(path)
+		if err != nil {
+			return graph.Graph{}, fmt.Errorf("failed to read trace file %s: %v", path, err)
+		}
+		if records, err = analysis.FilterStatus(records, callgraphStatus); err != nil {
+			return graph.Graph{}, err
+		}
+		s := graph.Service{Name: filepath.Base(filepath.Dir(path)), Records: records}
+		meta, err := traceio.ReadMetadata(filepath.Join(filepath.Dir(path), "run.json"))
+		if err == nil {
+			s.RunID = meta.RunID
+			if len(meta.Args) > 0 {
+				s.Name = filepath.Base(meta.Args[0])
+			}
+		} else if !os.IsNotExist(err) {
+			return graph.Graph{}, fmt.Errorf("failed to read run metadata of %s: %v", path, err)
+		}
+		if len(callgraphServices) > 0 {
+			s.Name = callgraphServices[i]
+		}
+		if meta.RunID != "" {
+			footer = append(footer, s.Name+": "+meta.Summary())
+		}
+		s.Inventory, err = instrument.ReadInventory(filepath.Join(filepath.Dir(filepath.Dir(path)), "inventory.json"))
+		if err != nil && !os.IsNotExist(err) {
+			return graph.Graph{}, fmt.Errorf("failed to read inventory of %s: %v", path, err)
+		}
+		services[i] = s
+	}
+	g, err := graph.BuildServices(services)
+	if err != nil {
+		return graph.Graph{}, fmt.Errorf("%v; name the services with --service", err)
+	}
+	g.Footer = footer
+	return g, nil
+}
+
+// callgraphFooter returns the footer of the graph of --mode from a single --trace: the summary
+// of its run from the run.json next to it, or for the static graph and traces without one, what
+// the graph shows and when it was drawn.
+//
+// Parameters:
+//   - functions (int): the number of functions in the inventory.
+//
+// Returns:
+//   - []string: the lines of the footer.
+//   - error: an error if the run metadata exists but cannot be read.
+func callgraphFooter(functions int) ([]string, error) {
+	drawn := fmt.Sprintf("drawn %s by tracewrap %s", time.Now().UTC().Format("2006-01-02 15:04:05 MST"), version.Get().Version)
+	if callgraphMode == graph.Static {
+		return []string{fmt.Sprintf("Static call graph of %d functions, %s", functions, drawn)}, nil
+	}
+	trace := callgraphTraces[0]
+	meta, err := traceio.ReadMetadata(filepath.Join(filepath.Dir(trace), "run.json"))
+	if os.IsNotExist(err) {
+		return []string{fmt.Sprintf("Calls of %s, %s", trace, drawn)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run metadata of %s: %v", trace, err)
+	}
+	return []string{meta.Summary()}, nil
+}
+
 func init() {
 	generateCmd.AddCommand(callgraphCmd)
 	callgraphCmd.Flags().StringVar(&logFile, "log", "", "Path to the tracewrap.log file")
+	callgraphCmd.Flags().StringVar(&callgraphMode, "mode", "log", "Graph to draw: log (from --log), static, dynamic or overlay")
+	callgraphCmd.Flags().StringArrayVar(&callgraphTraces, "trace", []string{"tracewrap/latest/trace.jsonl"}, "Path to the trace file, for dynamic and overlay; repeat to merge the traces of several services")
+	callgraphCmd.Flags().StringArrayVar(&callgraphServices, "service", nil, "Name of the service of each --trace, in order (default the binary name from run.json)")
+	callgraphCmd.Flags().StringVar(&callgraphInventory, "inventory", "tracewrap/inventory.json", "Path to the inventory written by buildTracedApplication, for static and overlay")
+	callgraphCmd.Flags().StringVar(&callgraphCluster, "cluster", graph.ClusterPackage, "Group functions for static, dynamic and overlay: none, service, package or file")
+	callgraphCmd.Flags().StringVar(&callgraphFormat, "format", graph.DOT, "Output format for static, dynamic and overlay: dot, graphml or jsongraph")
+	callgraphCmd.Flags().StringVar(&callgraphStatus, "status", "", "Only draw calls with this span status, for dynamic and overlay: ok or error")
+	callgraphCmd.Flags().StringVar(&callgraphConfig, "config", "tracewrap.yaml", "Path to the configuration file whose visualization.theme colors static, dynamic and overlay graphs")
+	callgraphCmd.Flags().StringVarP(&callgraphOutput, "output", "o", "", "Path to write the graph to (default callgraph-<mode>.<dot|graphml|json>)")
 }
@@ -4,7 +4,6 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 	"os/exec"
 	"path/filepath"
 
@@ -23,14 +22,12 @@ It first checks whether Graphviz is installed and then runs the command:
   dot -Tpng -o <directory>/callgraph.png <dotfile>`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if dotFile == "" {
-			fmt.Println("Please specify the path to the callgraph.dot file using the --dotfile flag.")
-			os.Exit(1)
+			failWith(exitConfig, "Please specify the path to the callgraph.dot file using the --dotfile flag.")
 		}
 
 		// Check if Graphviz's dot command is installed.
 		if _, err := exec.LookPath("dot"); err != nil {
-			fmt.Println("Graphviz is not installed. Please install Graphviz to use this command.")
-			os.Exit(1)
+			fail("Graphviz is not installed. Please install Graphviz to use this command.")
 		}
 
 		// Determine the output file path (same directory as the dot file).
@@ -40,10 +37,12 @@ It first checks whether Graphviz is installed and then runs the command:
 		// Run the dot command to generate the PNG image.
 		cmdExec := exec.Command("dot", "-Tpng", "-o", outputFile, dotFile)
 		if err := cmdExec.Run(); err != nil {
-			fmt.Printf("Error generating PNG image: %v\n", err)
-			os.Exit(1)
+			fail("Error generating PNG image: %v", err)
 		}
 		fmt.Printf("PNG image generated successfully at: %s\n", outputFile)
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{"dot": dotFile, "image": outputFile}})
+		}
 	},
 }
 
@@ -0,0 +1,184 @@
+// cmd/tracewrap/output.go
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of tracewrap commands, a stable contract for scripts and CI pipelines.
+const (
+	exitOK         = 0 // The command succeeded.
+	exitError      = 1 // Any other error, e.g. a missing trace file or an application that failed.
+	exitConfig     = 2 // The configuration or the command line is invalid.
+	exitBuild      = 3 // The instrumented binary did not build.
+	exitInstrument = 4 // The project could not be instrumented.
+	exitRegression = 5 // The findings fail a check, such as an exceeded budget or a fired alert.
+)
+
+// resultVersion is the version of the commandResult document, raised when a field changes meaning
+// or is removed.
+const resultVersion = 1
+
+// Statuses of a commandResult.
+const (
+	statusOK     = "ok"     // The command succeeded and found nothing to report as a failure.
+	statusFailed = "failed" // The command ran, and its findings fail a check, such as an exceeded budget.
+	statusError  = "error"  // The command could not run, e.g. for a missing trace file.
+)
+
+var (
+	// jsonOutput is set by the global --json flag.
+	jsonOutput bool
+	// ciMode is set by the global --ci flag.
+	ciMode bool
+	// progressOut receives progress messages: standard output, standard error with --json, and
+	// nowhere with --ci.
+	progressOut io.Writer = os.Stdout
+	// resultOut is where the JSON result goes: the standard output the process started with.
+	// With --json, os.Stdout is pointed at standard error, so that the text messages of commands
+	// and packages and the output of the instrumented application stay out of the result.
+	resultOut io.Writer = os.Stdout
+	// commandPath is the path of the running command, e.g. "tracewrap analyze hotlist".
+	commandPath string
+	// resultWritten is set once the running command has printed its result.
+	resultWritten bool
+)
+
+// startOutput prepares the output of the command about to run.
+//
+// Parameters:
+//   - cmd (*cobra.Command): the command.
+func startOutput(cmd *cobra.Command) {
+	commandPath = cmd.CommandPath()
+	if jsonOutput {
+		os.Stdout = os.Stderr
+		progressOut = os.Stderr
+	}
+	if ciMode {
+		progressOut = io.Discard
+	}
+	instrument.Progress = progressOut
+}
+
+// progress prints a progress message, as fmt.Println does, unless --ci is set.
+//
+// Parameters:
+//   - a (...any): the message.
+func progress(a ...any) {
+	fmt.Fprintln(progressOut, a...)
+}
+
+// finishOutput prints a plain ok result with --json for a command that returned without printing
+// one, so every command prints a JSON document.
+func finishOutput() {
+	if jsonOutput && !resultWritten {
+		writeResult(commandResult{Status: statusOK})
+	}
+}
+
+// commandResult is the document a command prints to standard output with --json, in place of the
+// text output of its results. Every command prints the same document, with camelCase keys.
+type commandResult struct {
+	Version int    `json:"version"` // Set to resultVersion by writeResult.
+	Command string `json:"command"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	// Artifacts are the paths of the files the command wrote or read, by role, e.g. "binary".
+	Artifacts map[string]string `json:"artifacts,omitempty"`
+	// Findings are the command's results, such as the rows of a report. writeResult prints them as
+	// a JSON array: a single result as an array of one, and none as an empty array.
+	Findings any `json:"findings"`
+	// Total is the number of findings before a limit, such as the --limit of query, cut them short.
+	// It is left out for commands without a limit.
+	Total int `json:"total,omitempty"`
+}
+
+// writeResult prints res as indented JSON, with the version and the running command filled in.
+//
+// Parameters:
+//   - res (commandResult): the result.
+func writeResult(res commandResult) {
+	res.Version = resultVersion
+	res.Findings = findingsList(res.Findings)
+	if res.Command == "" {
+		res.Command = commandPath
+	}
+	if res.Command == "" {
+		// The command line failed to parse before a command ran.
+		res.Command = "tracewrap"
+	}
+	resultWritten = true
+	enc := json.NewEncoder(resultOut)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(res); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing result: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// findingsList returns findings as a value that encodes as a JSON array.
+//
+// Parameters:
+//   - findings (any): a slice of findings, a single finding, or nil.
+//
+// Returns:
+//   - any: findings if it is a non-nil slice, an empty slice for nil, or a slice of findings alone.
+func findingsList(findings any) any {
+	if findings == nil {
+		return []any{}
+	}
+	v := reflect.ValueOf(findings)
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return []any{}
+		}
+		return findings
+	case reflect.Array:
+		return findings
+	}
+	return []any{findings}
+}
+
+// writeFindings prints the ok result of a report on a trace file.
+//
+// Parameters:
+//   - trace (string): the trace file, listed as the "trace" artifact.
+//   - findings (any): the rows of the report.
+func writeFindings(trace string, findings any) {
+	writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{"trace": trace}, Findings: findings})
+}
+
+// fail reports an error that stops the command and exits with exitError. With --json, the message
+// is the error of a result with status "error".
+//
+// Parameters:
+//   - format (string): the message format, as for fmt.Printf, without a trailing newline.
+//   - args (...any): the arguments of the format.
+func fail(format string, args ...any) {
+	failWith(exitError, format, args...)
+}
+
+// failWith reports an error that stops the command and exits with code, as fail does.
+//
+// Parameters:
+//   - code (int): the exit code, e.g. exitConfig.
+//   - format (string): the message format, as for fmt.Printf, without a trailing newline.
+//   - args (...any): the arguments of the format.
+func failWith(code int, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if jsonOutput {
+		writeResult(commandResult{Status: statusError, Error: msg})
+	} else {
+		fmt.Println(msg)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,94 @@
+// cmd/tracewrap/query.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mwiater/tracewrap/pkg/query"
+	"github.com/spf13/cobra"
+)
+
+var (
+	queryTrace    string
+	queryParams   []string
+	queryReturns  []string
+	queryFunction string
+	queryLimit    int
+	queryReindex  bool
+)
+
+// queryCmd finds the calls of a trace by their parameter and return values.
+var queryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Find the calls of a trace by parameter or return value.",
+	Long: `query finds the calls in a trace file whose captured values match every condition given:
+--param name=value for a parameter, --return value for any return value and --function for the
+function name. Values are compared with their rendered form in the trace, e.g. --param jobID=7, or
+matched as glob patterns if they contain *, ? or [, e.g. --param user=alice-*. --param name>number,
+and likewise with <, <= and >=, compares the typed value of a numeric parameter, e.g. --param
+"jobID>100". Each call is printed with its parameters and return values; with --json, the findings are the matching records and
+the result's total is their count before --limit.
+
+The first query of a trace indexes it into trace.jsonl.index next to it, so later queries read only
+the matching records. The index is rebuilt when the trace changes, or with --reindex. Values longer
+than 256 bytes are not indexed.`,
+	Example: `  tracewrap query --param jobID=7
+  tracewrap query --param "jobID>100"
+  tracewrap query --function processJob --return "*timeout*"`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		q := query.Query{Function: queryFunction}
+		for _, p := range queryParams {
+			c, err := query.ParseParam(p)
+			if err != nil {
+				failWith(exitConfig, "Error: %v", err)
+			}
+			q.Conditions = append(q.Conditions, c)
+		}
+		for _, r := range queryReturns {
+			q.Conditions = append(q.Conditions, query.Condition{Value: r})
+		}
+		if len(q.Conditions) == 0 && q.Function == "" {
+			failWith(exitConfig, "Please give at least one --param, --return or --function condition.")
+		}
+		idx, err := query.Load(queryTrace, queryReindex)
+		if err != nil {
+			fail("Error indexing trace file: %v", err)
+		}
+		records, total, err := query.Search(queryTrace, idx, q, queryLimit)
+		if err != nil {
+			fail("Error: %v", err)
+		}
+		if jsonOutput {
+			writeResult(commandResult{
+				Status:    statusOK,
+				Artifacts: map[string]string{"trace": queryTrace},
+				Findings:  records,
+				Total:     total,
+			})
+			return
+		}
+		if total == 0 {
+			fmt.Println("No matching calls found in", queryTrace)
+			return
+		}
+		if err := query.WriteResults(os.Stdout, records); err != nil {
+			fail("Error writing results: %v", err)
+		}
+		if total > len(records) {
+			fmt.Printf("... %d more matching calls (raise --limit to see them)\n", total-len(records))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(queryCmd)
+	queryCmd.Flags().StringVar(&queryTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+	queryCmd.Flags().StringArrayVar(&queryParams, "param", nil, "Parameter condition name=value, or name>number with <, <=, > or >=; repeat for several")
+	queryCmd.Flags().StringArrayVar(&queryReturns, "return", nil, "Return value condition; repeat for several")
+	queryCmd.Flags().StringVar(&queryFunction, "function", "", "Name of the function, or a glob pattern")
+	queryCmd.Flags().IntVar(&queryLimit, "limit", 20, "Maximum number of calls printed (0 prints all)")
+	queryCmd.Flags().BoolVar(&queryReindex, "reindex", false, "Rebuild the index even if it is up to date")
+}
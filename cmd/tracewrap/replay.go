@@ -0,0 +1,55 @@
+// cmd/tracewrap/replay.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/replay"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	replayTrace  string
+	replaySpeed  string
+	replayMaxGap time.Duration
+)
+
+// replayCmd replays a recorded trace on the console.
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay a recorded trace in timestamp order.",
+	Long: `replay reads a trace file (tracewrap/latest/trace.jsonl) and prints every function entry and exit
+in timestamp order, indented by call depth, pausing between events as they happened in the
+recorded run. Use --speed to replay faster or slower (e.g. 10x, 0.5x, or max for no pauses)
+and --max-gap to skip over idle periods.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		speed, err := replay.ParseSpeed(replaySpeed)
+		if err != nil {
+			failWith(exitConfig, "%v", err)
+		}
+		records, err := tracer.ReadTraceFile(replayTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+		if len(records) == 0 {
+			fmt.Println("No trace records found in", replayTrace)
+			return
+		}
+		opts := replay.Options{Speed: speed, MaxGap: replayMaxGap}
+		if err := replay.Play(os.Stdout, replay.Events(records), opts); err != nil {
+			fail("Error replaying trace: %v", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+	replayCmd.Flags().StringVar(&replayTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+	replayCmd.Flags().StringVar(&replaySpeed, "speed", "1x", "Playback speed, e.g. 10x, 0.5x, or max")
+	replayCmd.Flags().DurationVar(&replayMaxGap, "max-gap", time.Second, "Longest pause between two events (0 for no limit)")
+}
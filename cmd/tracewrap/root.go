@@ -3,9 +3,6 @@
 package cmd
 
 import (
-	"fmt"
-	"os"
-
 	"github.com/spf13/cobra"
 )
 
@@ -17,22 +14,41 @@ var rootCmd = &cobra.Command{
 	Short: "tracewrap is a tool for building instrumented Go applications.",
 	Long: `tracewrap is a command line tool that automates the process of preparing a workspace,
 instrumenting the code, building an instrumented binary, and executing it.
-It facilitates tracing in Go applications to aid in debugging and performance monitoring.`,
+It facilitates tracing in Go applications to aid in debugging and performance monitoring.
+
+With --json, every command prints a single JSON document to standard output:
+{"version":1,"command":...,"status":...,"error":...,"artifacts":{...},"findings":[...]}. The
+status is "ok", "failed" when the findings fail a check, or "error" when the command could not
+run; findings is always an array. Progress messages, text output and the output of the
+instrumented application go to standard error.
+
+Commands exit with a stable status for scripts and CI pipelines: 0 on success, 2 for an invalid
+configuration or command line, 3 when the instrumented binary does not build, 4 when the project
+cannot be instrumented, 5 when the findings fail a check (check, analyze alerts) and 1 for any
+other error. --ci runs non-interactively: progress messages are left out, the live dashboard is
+refused, and problems that are otherwise only reported, such as a failed notification, stop the
+command.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		startOutput(cmd)
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		finishOutput()
+	},
 }
 
 // Execute executes the root command along with any registered subcommands.
-// If the command execution results in an error, the error is printed and the program exits
-// with a non-zero status code.
+// If the command line is invalid, the error is printed and the program exits with status 2.
 func Execute() {
+	// The commands exit themselves on errors, so the errors left are those of the command line.
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		failWith(exitConfig, "%v", err)
 	}
 }
 
 // init initializes the root command's configuration.
-// This function is reserved for setting up additional top-level flags or configurations.
-// Since subcommands are self-registered in their respective files, no further initialization
-// is required here.
+// It sets up the top-level flags shared by every command; subcommands are self-registered in
+// their respective files.
 func init() {
+	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "Print the result as a JSON document")
+	rootCmd.PersistentFlags().BoolVar(&ciMode, "ci", false, "Run non-interactively: no progress messages or dashboard, and stop at the first problem")
 }
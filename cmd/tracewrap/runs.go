@@ -0,0 +1,25 @@
+// cmd/tracewrap/runs.go
+
+package cmd
+
+import (
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/spf13/cobra"
+)
+
+var runsDir string
+
+// runsCmd is the parent command for managing the per-run artifact directories.
+var runsCmd = &cobra.Command{
+	Use:   "runs",
+	Short: "Manage the artifacts of past runs.",
+	Long: `Every run of an instrumented binary writes its artifacts to tracewrap/<run id>/, and
+tracewrap/latest links to the most recent one. The runs command serves as a parent for
+subcommands that list and prune these directories.`,
+	// No Run functionality; this command exists solely to group subcommands.
+}
+
+func init() {
+	rootCmd.AddCommand(runsCmd)
+	runsCmd.PersistentFlags().StringVar(&runsDir, "dir", tracer.ArtifactRoot, "Path to the directory holding the run directories")
+}
@@ -0,0 +1,52 @@
+// cmd/tracewrap/runs_list.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/runs"
+	"github.com/spf13/cobra"
+)
+
+// runsListCmd is the subcommand under runs for listing run directories.
+var runsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the runs with artifacts on disk, oldest first.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		list, err := runs.List(runsDir)
+		if err != nil {
+			fail("%v", err)
+		}
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{"runs": runsDir}, Findings: list})
+			return
+		}
+		if len(list) == 0 {
+			fmt.Println("No runs in", runsDir)
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tSTARTED\tRECORDS\tVERSION\t")
+		for _, run := range list {
+			records, version := "-", "-"
+			if run.Metadata != nil {
+				records, version = fmt.Sprint(run.Metadata.Records), run.Metadata.TracewrapVersion
+			}
+			latest := ""
+			if run.Latest {
+				latest = "(latest)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", run.ID, run.StartedAt.Local().Format(time.DateTime), records, version, latest)
+		}
+		tw.Flush()
+	},
+}
+
+func init() {
+	runsCmd.AddCommand(runsListCmd)
+}
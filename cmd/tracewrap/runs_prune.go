@@ -0,0 +1,49 @@
+// cmd/tracewrap/runs_prune.go
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/runs"
+	"github.com/spf13/cobra"
+)
+
+var pruneOpts runs.PruneOptions
+
+// runsPruneCmd is the subcommand under runs for removing old run directories.
+var runsPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove the artifacts of old runs.",
+	Long: `prune removes run directories except the --keep most recent ones. With --older-than, only
+runs started longer ago than that are removed. The run tracewrap/latest points at is always kept.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		removed, err := runs.Prune(runsDir, pruneOpts, time.Now())
+		for _, run := range removed {
+			if pruneOpts.DryRun {
+				fmt.Println("Would remove", run.Dir)
+				continue
+			}
+			fmt.Println("Removed", run.Dir)
+		}
+		if err != nil {
+			fail("%v", err)
+		}
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{"runs": runsDir}, Findings: removed})
+			return
+		}
+		if len(removed) == 0 {
+			fmt.Println("No runs to prune in", runsDir)
+		}
+	},
+}
+
+func init() {
+	runsCmd.AddCommand(runsPruneCmd)
+	runsPruneCmd.Flags().IntVar(&pruneOpts.Keep, "keep", 10, "Number of most recent runs to keep")
+	runsPruneCmd.Flags().DurationVar(&pruneOpts.OlderThan, "older-than", 0, "Only remove runs started longer ago than this (e.g. 168h)")
+	runsPruneCmd.Flags().BoolVar(&pruneOpts.DryRun, "dry-run", false, "List the runs that would be removed without removing them")
+}
@@ -0,0 +1,114 @@
+// cmd/tracewrap/scrub.go
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mwiater/tracewrap/pkg/scrub"
+	"github.com/mwiater/tracewrap/pkg/traceio"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	scrubTrace          string
+	scrubRules          string
+	scrubOutput         string
+	scrubMetadataOutput string
+)
+
+// scrubCmd writes a copy of a trace with its sensitive values removed or hashed.
+var scrubCmd = &cobra.Command{
+	Use:   "scrub",
+	Short: "Remove or hash sensitive values in a trace so it can be shared.",
+	Long: `scrub reads a trace file, applies the rules in --rules to it and writes the result to
+--output (standard output by default). Rules choose, per parameter, for return values, panic
+values and error messages, whether to keep, remove, hash or redact them, and scrub host names,
+absolute file paths and custom regular expressions wherever they appear. Hashes are salted and
+stable, so equal values stay equal in the scrubbed trace.
+
+The run.json next to the trace, when there is one, supplies the host name to scrub;
+--metadata-output writes a scrubbed copy of it.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		rules, err := scrub.LoadRules(scrubRules)
+		if err != nil {
+			fail("Error loading scrub rules: %v", err)
+		}
+		scrubber, err := scrub.New(rules)
+		if err != nil {
+			fail("Error in scrub rules:\n%v", err)
+		}
+		records, err := tracer.ReadTraceFile(scrubTrace)
+		if err != nil {
+			fail("Error reading trace file: %v", err)
+		}
+
+		// Scrub the metadata first so its host name is known when the records are scrubbed.
+		var meta *tracer.RunMetadata
+		m, err := traceio.ReadMetadata(filepath.Join(filepath.Dir(scrubTrace), "run.json"))
+		if err == nil {
+			meta = &m
+			scrubber.Metadata(meta)
+		} else if !os.IsNotExist(err) {
+			fail("Error reading run metadata: %v", err)
+		}
+		for i := range records {
+			scrubber.Record(&records[i])
+		}
+
+		if err := writeScrubbed(scrubOutput, records); err != nil {
+			fail("Error writing scrubbed trace: %v", err)
+		}
+		if scrubMetadataOutput != "" {
+			if meta == nil {
+				fail("Error: no run.json next to %s", scrubTrace)
+			}
+			if err := traceio.WriteMetadata(scrubMetadataOutput, *meta); err != nil {
+				fail("Error writing scrubbed run metadata: %v", err)
+			}
+		}
+		if scrubOutput != "" {
+			fmt.Printf("Scrubbed %d records to %s\n", len(records), scrubOutput)
+		}
+	},
+}
+
+// writeScrubbed writes records as JSON Lines to path, or to standard output if path is empty.
+//
+// Parameters:
+//   - path (string): the output file, or "".
+//   - records ([]tracer.TraceRecord): the scrubbed records.
+//
+// Returns:
+//   - error: an error if the output cannot be written.
+func writeScrubbed(path string, records []tracer.TraceRecord) error {
+	var out io.Writer = os.Stdout
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	w := traceio.NewWriter(out)
+	for _, rec := range records {
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(scrubCmd)
+	scrubCmd.Flags().StringVar(&scrubTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file to scrub")
+	scrubCmd.Flags().StringVar(&scrubRules, "rules", "scrub.yaml", "Path to the scrub rules")
+	scrubCmd.Flags().StringVarP(&scrubOutput, "output", "o", "", "Path to write the scrubbed trace to (default standard output)")
+	scrubCmd.Flags().StringVar(&scrubMetadataOutput, "metadata-output", "", "Path to write the scrubbed run.json to")
+}
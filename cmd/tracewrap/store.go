@@ -0,0 +1,56 @@
+// cmd/tracewrap/store.go
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	storeDir       string
+	storeConfigArg string
+)
+
+// storeCmd is the parent command for managing the store of past runs.
+var storeCmd = &cobra.Command{
+	Use:   "store",
+	Short: "Manage the store of past runs.",
+	Long: `The store command serves as a parent for subcommands that add traces to, list and prune the
+on-disk store of past runs (.tracewrap/store by default) used as history by analyses such as
+analyze anomalies. The store key of --config sets the retention policy, which store add applies
+after adding a run and store prune on demand.`,
+	// No Run functionality; this command exists solely to group subcommands.
+}
+
+// loadStoreRetention returns the retention policy of the store key of the configuration file at
+// path. A missing file means no policy.
+//
+// Parameters:
+//   - path (string): the configuration file.
+//
+// Returns:
+//   - store.Retention: the policy.
+//   - error: an error if the file cannot be read or is invalid.
+func loadStoreRetention(path string) (store.Retention, error) {
+	cfg, err := config.LoadConfig(path)
+	if os.IsNotExist(err) {
+		return store.Retention{}, nil
+	}
+	if err != nil {
+		return store.Retention{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return store.Retention{}, err
+	}
+	return store.Retention{MaxAge: cfg.Store.MaxAge, MaxSize: cfg.Store.MaxSizeBytes, KeepLast: cfg.Store.KeepLast}, nil
+}
+
+func init() {
+	rootCmd.AddCommand(storeCmd)
+	storeCmd.PersistentFlags().StringVar(&storeDir, "store", store.DefaultDir, "Path to the store directory")
+	storeCmd.PersistentFlags().StringVar(&storeConfigArg, "config", "tracewrap.yaml", "Path to the configuration file with the store retention policy")
+}
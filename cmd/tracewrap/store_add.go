@@ -0,0 +1,54 @@
+// cmd/tracewrap/store_add.go
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+var storeAddTrace string
+
+// storeAddCmd is the subcommand under store for adding a trace as a new run.
+var storeAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a trace to the store.",
+	Long: `Copies a trace file, and the run.json next to it, into the store as a new run, then removes
+the runs outside the retention policy of the store key of --config, if it sets one.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		retention, err := loadStoreRetention(storeConfigArg)
+		if err != nil {
+			failWith(exitConfig, "Error loading %s: %v", storeConfigArg, err)
+		}
+		s, err := store.Open(storeDir)
+		if err != nil {
+			fail("%v", err)
+		}
+		run, err := s.Add(storeAddTrace)
+		if err != nil {
+			fail("Error adding trace: %v", err)
+		}
+		fmt.Printf("Stored run %s (%d records) in %s\n", run.ID, run.Records, s.Dir())
+		if retention != (store.Retention{}) {
+			removed, err := s.Prune(retention, time.Now(), false)
+			if err != nil {
+				fail("Error pruning store: %v", err)
+			}
+			for _, old := range removed {
+				progress("Removed run", old.ID, "by the retention policy")
+			}
+		}
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{"trace": storeAddTrace, "store": s.Dir()}, Findings: run})
+		}
+	},
+}
+
+func init() {
+	storeCmd.AddCommand(storeAddCmd)
+	storeAddCmd.Flags().StringVar(&storeAddTrace, "trace", "tracewrap/latest/trace.jsonl", "Path to the trace file")
+}
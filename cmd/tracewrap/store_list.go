@@ -0,0 +1,52 @@
+// cmd/tracewrap/store_list.go
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+// storeListCmd is the subcommand under store for listing stored runs.
+var storeListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the runs in the store.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		s, err := store.Open(storeDir)
+		if err != nil {
+			fail("%v", err)
+		}
+		runs, err := s.Runs()
+		if err != nil {
+			fail("%v", err)
+		}
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{"store": s.Dir()}, Findings: runs})
+			return
+		}
+		if len(runs) == 0 {
+			fmt.Println("No runs in", s.Dir())
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tADDED\tRECORDS\tSIZE\tVERSION\tCONFIG")
+		for _, run := range runs {
+			version, hash := "-", "-"
+			if run.Metadata != nil {
+				version, hash = run.Metadata.TracewrapVersion, run.Metadata.ConfigHash
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d B\t%s\t%s\n", run.ID, run.AddedAt.Local().Format(time.DateTime), run.Records, run.Bytes, version, hash)
+		}
+		tw.Flush()
+	},
+}
+
+func init() {
+	storeCmd.AddCommand(storeListCmd)
+}
@@ -0,0 +1,89 @@
+// cmd/tracewrap/store_prune.go
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	storePruneRetention store.Retention
+	storePruneDryRun    bool
+)
+
+// storePruneCmd is the subcommand under store for removing old runs.
+var storePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove the stored runs outside the retention policy.",
+	Long: `prune removes the runs added longer ago than --max-age, then the oldest runs until the store
+uses at most --max-size bytes. The --keep-last most recent runs are always kept, as baselines for
+analyses such as analyze anomalies. Limits not given on the command line are taken from the store
+key of --config, e.g.
+
+  store:
+    maxAge: 720h
+    maxSizeBytes: 1073741824
+    keepLast: 20`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		retention, err := loadStoreRetention(storeConfigArg)
+		if err != nil {
+			failWith(exitConfig, "Error loading %s: %v", storeConfigArg, err)
+		}
+		flags := cmd.Flags()
+		if flags.Changed("max-age") {
+			retention.MaxAge = storePruneRetention.MaxAge
+		}
+		if flags.Changed("max-size") {
+			retention.MaxSize = storePruneRetention.MaxSize
+		}
+		if flags.Changed("keep-last") {
+			retention.KeepLast = storePruneRetention.KeepLast
+		}
+		if retention.MaxAge < 0 || retention.MaxSize < 0 || retention.KeepLast < 0 {
+			failWith(exitConfig, "--max-age, --max-size and --keep-last must not be negative")
+		}
+		s, err := store.Open(storeDir)
+		if err != nil {
+			fail("%v", err)
+		}
+		removed, err := s.Prune(retention, time.Now(), storePruneDryRun)
+		var freed int64
+		for _, run := range removed {
+			freed += run.Bytes
+			if storePruneDryRun {
+				fmt.Println("Would remove", run.ID)
+				continue
+			}
+			fmt.Println("Removed", run.ID)
+		}
+		if err != nil {
+			fail("%v", err)
+		}
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Artifacts: map[string]string{"store": s.Dir()}, Findings: removed})
+			return
+		}
+		if len(removed) == 0 {
+			fmt.Println("No runs to prune in", s.Dir())
+			return
+		}
+		verb := "Freed"
+		if storePruneDryRun {
+			verb = "Would free"
+		}
+		fmt.Printf("%s %d bytes from %d runs\n", verb, freed, len(removed))
+	},
+}
+
+func init() {
+	storeCmd.AddCommand(storePruneCmd)
+	storePruneCmd.Flags().DurationVar(&storePruneRetention.MaxAge, "max-age", 0, "Remove runs added longer ago than this, e.g. 720h (0 disables)")
+	storePruneCmd.Flags().Int64Var(&storePruneRetention.MaxSize, "max-size", 0, "Remove the oldest runs until the store uses at most this many bytes (0 disables)")
+	storePruneCmd.Flags().IntVar(&storePruneRetention.KeepLast, "keep-last", 0, "Number of most recent runs always kept")
+	storePruneCmd.Flags().BoolVar(&storePruneDryRun, "dry-run", false, "List the runs that would be removed without removing them")
+}
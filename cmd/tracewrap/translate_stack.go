@@ -0,0 +1,57 @@
+// cmd/tracewrap/translate_stack.go
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	translateSourceMap string
+	translateInput     string
+)
+
+// translateStackCmd maps the stack traces of an instrumented binary back to the project's lines.
+var translateStackCmd = &cobra.Command{
+	Use:   "translate-stack",
+	Short: "Map the positions in an instrumented binary's stack traces back to the original source.",
+	Long: `translate-stack reads text containing Go stack traces, such as the output of a panic, from
+--input (standard input by default) and prints it with the positions in instrumented files replaced
+by the project's files and original line numbers, using the source map buildTracedApplication wrote.
+Frames in code tracewrap injected point at the line of their function and are marked
+"(injected by tracewrap)".`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		sourceMap, err := instrument.ReadSourceMap(translateSourceMap)
+		if err != nil {
+			fail("Error reading source map: %v", err)
+		}
+		var in io.Reader = os.Stdin
+		if translateInput != "" {
+			file, err := os.Open(translateInput)
+			if err != nil {
+				fail("Error opening input: %v", err)
+			}
+			defer file.Close()
+			in = file
+		}
+		text, err := io.ReadAll(in)
+		if err != nil {
+			fail("Error reading input: %v", err)
+		}
+		fmt.Print(sourceMap.TranslateStack(string(text)))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(translateStackCmd)
+	translateStackCmd.Flags().StringVar(&translateSourceMap, "sourcemap", filepath.Join(tracer.ArtifactRoot, instrument.BuildSourceMapFile), "Path to the source map of the build")
+	translateStackCmd.Flags().StringVarP(&translateInput, "input", "i", "", "Path to the text to translate (default standard input)")
+}
@@ -0,0 +1,35 @@
+// cmd/tracewrap/version.go
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mwiater/tracewrap/pkg/version"
+	"github.com/spf13/cobra"
+)
+
+// versionCmd prints the tracewrap version, the commit it was built from, and the Go version.
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the tracewrap version.",
+	Long: `Prints the tracewrap version, the commit it was built from, and the Go version used to build it.
+Instrumented binaries are stamped with the same version, which is recorded in tracewrap/latest/run.json.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		info := version.Get()
+		if jsonOutput {
+			writeResult(commandResult{Status: statusOK, Findings: info})
+			return
+		}
+		fmt.Println("tracewrap", info.Version)
+		if info.Commit != "" {
+			fmt.Println("commit:", info.Commit)
+		}
+		fmt.Println("go:", info.GoVersion)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+}
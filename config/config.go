@@ -1,48 +1,334 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/mwiater/tracewrap/pkg/theme"
 	"gopkg.in/yaml.v3"
 )
 
 // InstrumentationConfig provides configuration options for instrumentation.
 // It contains a flag to enable instrumentation and lists of strings to specify
-// which items to include or exclude during instrumentation.
+// which items to include or exclude during instrumentation. SkipGenerated leaves
+// files carrying a "Code generated ... DO NOT EDIT." header uninstrumented, and
+// IncludeTests opts *_test.go files into instrumentation (they are skipped by default).
+// InstrumentInit traces package init functions, which are otherwise skipped.
+// SkipMainInjections suppresses the artifact-writing calls injected into func main.
+// SkipExitRewrite leaves os.Exit calls alone; by default a tracer.Shutdown call is inserted before
+// each of them so the trace and call graph are written when the program exits early.
+// SkipCommandRewrite leaves the Run, Output, CombinedOutput, Start and Wait calls of exec.Cmd
+// values alone; by default they go through tracer wrappers that record the child process as span
+// events and add its CPU time and peak memory to the calling span.
+// SkipResponseRewrite leaves http.ResponseWriter parameters alone; by default they are wrapped
+// with tracer.TrackResponse at function entry so the status code written sets the span status.
+// CaptureBasicKindsOnly records only parameters declared with a basic type (bool, string and
+// the numeric types), which are cheap to render; other parameters are not passed to the tracer.
+// CorrelateLogs makes the application's log and log/slog output carry the current span and
+// trace IDs. BridgeOpenTelemetry records the spans the application creates with OpenTelemetry as
+// tracewrap calls: func main installs the bridge of pkg/otelbridge as the global tracer provider,
+// and providers passed to otel.SetTracerProvider are wrapped by it. NameFormat picks the function
+// names traces carry: "short" (the default, Func), "package" (pkg.Func, pkg.Type.Method) or "full"
+// (the import path, example.com/mod/pkg.Func); names shared by several functions are upgraded to
+// the next longer format.
+// SkipBuildFallback makes a build of the instrumented project fail outright; by default the files
+// of packages that no longer build once instrumented, such as cgo or assembly packages, are
+// restored and built uninstrumented, and reported. SkipVerify skips the type check and go vet run
+// over the instrumented workspace before it is built. Injection picks how the injected code is
+// produced: "ast" (the default) builds the syntax nodes directly, "template" renders them from Go
+// source templates; both inject the same code. Metrics lists the resources measured for each call:
+// "time" (its CPU time), "mem" (heap allocation and GC cycles), "goroutines" (goroutines and cgo
+// calls) and "io" (network and disk usage); empty means all of them. Each metric left out is skipped
+// by the snapshots each call takes at entry and exit; the call itself, its duration, parameters and
+// results are recorded either way.
 type InstrumentationConfig struct {
-	Enable  bool     `yaml:"enable"`
-	Include []string `yaml:"include"`
-	Exclude []string `yaml:"exclude"`
+	Enable                bool     `yaml:"enable"`
+	Include               []string `yaml:"include"`
+	Exclude               []string `yaml:"exclude"`
+	SkipGenerated         bool     `yaml:"skipGenerated"`
+	IncludeTests          bool     `yaml:"includeTests"`
+	InstrumentInit        bool     `yaml:"instrumentInit"`
+	SkipMainInjections    bool     `yaml:"skipMainInjections"`
+	SkipExitRewrite       bool     `yaml:"skipExitRewrite"`
+	SkipCommandRewrite    bool     `yaml:"skipCommandRewrite"`
+	SkipResponseRewrite   bool     `yaml:"skipResponseRewrite"`
+	CaptureBasicKindsOnly bool     `yaml:"captureBasicKindsOnly"`
+	CorrelateLogs         bool     `yaml:"correlateLogs"`
+	BridgeOpenTelemetry   bool     `yaml:"bridgeOpenTelemetry"`
+	NameFormat            string   `yaml:"nameFormat"`
+	SkipBuildFallback     bool     `yaml:"skipBuildFallback"`
+	SkipVerify            bool     `yaml:"skipVerify"`
+	Injection             string   `yaml:"injection"`
+	Metrics               []string `yaml:"metrics"`
 }
 
 // LoggingConfig provides configuration options for logging.
 // It includes the log level and the output destination for log messages.
+// MaxLinesPerSecond limits the log lines written about each function; lines beyond it are
+// replaced by a "suppressed N" summary (0 means unlimited). MirrorStdout copies the tracer's log
+// lines to the instrumented application's stdout; by default they only go to the log file.
 type LoggingConfig struct {
-	Level  string `yaml:"level"`
-	Output string `yaml:"output"`
+	Level             string `yaml:"level"`
+	Output            string `yaml:"output"`
+	MaxLinesPerSecond int    `yaml:"maxLinesPerSecond"`
+	MirrorStdout      bool   `yaml:"mirrorStdout"`
 }
 
 // TracingConfig provides configuration options for tracing.
 // It specifies the output format for traces and a flag to determine whether to dump traces on exit.
+// HistogramBuckets sets the upper bounds of the per-function latency histograms.
+// MaxRecordsInMemory caps the number of completed records held in memory; beyond it, records
+// are spilled to the trace file and only aggregates are kept (0 means unlimited).
+// SampleRate is the fraction of root calls recorded (0 means all of them), and Control
+// configures the embedded HTTP control endpoint. RecordOnlyRegions starts with recording
+// stopped and records only while a code region (tracer.StartRegion) is open. MaxOverheadPercent,
+// when positive, is the share of a function's duration its instrumentation may cost before the
+// tracer downgrades capture for it (first skipping values and system metrics, then sampling).
 type TracingConfig struct {
-	OutputFormat string `yaml:"outputFormat"`
-	DumpOnExit   bool   `yaml:"dumpOnExit"`
+	OutputFormat       string          `yaml:"outputFormat"`
+	DumpOnExit         bool            `yaml:"dumpOnExit"`
+	HistogramBuckets   []time.Duration `yaml:"histogramBuckets"`
+	MaxRecordsInMemory int             `yaml:"maxRecordsInMemory"`
+	SampleRate         float64         `yaml:"sampleRate"`
+	Control            ControlConfig   `yaml:"control"`
+	RecordOnlyRegions  bool            `yaml:"recordOnlyRegions"`
+	MaxOverheadPercent float64         `yaml:"maxOverheadPercent"`
+	// Light records calls only in the aggregates: execution counts, latency histograms, the
+	// per-function aggregates and the aggregated call graph. No per-call record, log line, value
+	// or metric is kept, which makes tracing cheap enough for hot code: parameters and results are
+	// not captured by the instrumentation. Callers are the innermost open call of any goroutine,
+	// as no goroutine ID is read.
+	Light bool `yaml:"light"`
+	// BlockProfileRate and MutexProfileFraction enable the runtime block and mutex profiles
+	// (see runtime.SetBlockProfileRate and runtime.SetMutexProfileFraction) and attribute the
+	// blocking and mutex contention observed during each call to its record. 0 leaves them off.
+	BlockProfileRate     int `yaml:"blockProfileRate"`
+	MutexProfileFraction int `yaml:"mutexProfileFraction"`
+	// CPUProfile records a pprof CPU profile from the start of the run to the first flush, and
+	// HeapProfile a heap profile at each flush, as cpu.pprof and heap.pprof in the run directory.
+	// The run metadata references them with their time offsets (see tracewrap analyze profiles).
+	CPUProfile  bool `yaml:"cpuProfile"`
+	HeapProfile bool `yaml:"heapProfile"`
+	// SyscallStats attributes the read and write system calls, their bytes, the CPU time in the
+	// kernel and the time spent waiting for block I/O to each call of an instrumented function. The
+	// goroutine is locked to its thread during the call, and the thread's counters are read at entry
+	// and exit. Linux only.
+	SyscallStats bool `yaml:"syscallStats"`
+	// TrackFileDescriptors records the change in the number of open file descriptors of the
+	// process during each call, for finding descriptor leaks (see tracewrap analyze fdleaks). The
+	// descriptors are counted at the entry and exit of every call, or only of the calls of the
+	// functions FileDescriptorFunctions lists (path.Match globs of their trace names).
+	TrackFileDescriptors    bool     `yaml:"trackFileDescriptors"`
+	FileDescriptorFunctions []string `yaml:"fileDescriptorFunctions"`
+	// CaptureErrorChains records the unwrap chain of returned errors (%w, errors.Join,
+	// github.com/pkg/errors causes and stacks) on the record.
+	CaptureErrorChains bool `yaml:"captureErrorChains"`
+	// Capture controls how parameter and return values are rendered.
+	Capture CaptureConfig `yaml:"capture"`
+	// SLOs declares latency budgets; calls exceeding their budget are marked on the record.
+	SLOs []SLOConfig `yaml:"slos"`
+	// TailSampling keeps only the interesting request traces once they are complete.
+	TailSampling TailSamplingConfig `yaml:"tailSampling"`
+	// Collector ships trace records and run metadata to a remote tracewrap collector.
+	Collector CollectorConfig `yaml:"collector"`
+	// PanicWebhook, when set, is posted a summary of every distinct panic recorded (function,
+	// panic value, stack and parameters), e.g. a Slack incoming webhook URL.
+	PanicWebhook string `yaml:"panicWebhook"`
+	// PanicBundle writes a panic-<time>.json post-mortem bundle to the run directory for every
+	// distinct panic: the stacks of all goroutines, the calls open on each goroutine and the calls
+	// completed last.
+	PanicBundle bool `yaml:"panicBundle"`
+	// PersistInterval, when positive, appends the records completed since the last write to the
+	// trace file, fsyncs it and rewrites run.json at this interval, so the files stay valid and
+	// nearly complete even if the process is killed without flushing. 0 writes them only on flush.
+	PersistInterval time.Duration `yaml:"persistInterval"`
+	// ResourceBuildInfo adds the module path, version and VCS revision of the instrumented binary
+	// to the resource of the OpenTelemetry SDK the bridge wraps, through OTEL_RESOURCE_ATTRIBUTES,
+	// so the APM can tell which build served a request. run.json always records them.
+	ResourceBuildInfo bool `yaml:"resourceBuildInfo"`
+	// EntryStacks lists the functions (path.Match globs of their trace names) whose calls record the
+	// stack they were called from, EntryStackDepth frames deep (default 8), so the callers of a
+	// rarely called function are known even when they are not instrumented.
+	EntryStacks     []string `yaml:"entryStacks"`
+	EntryStackDepth int      `yaml:"entryStackDepth"`
+}
+
+// CollectorConfig sends the trace records and run metadata of instrumented binaries to a
+// tracewrap collector ("tracewrap collector") instead of writing them to local files, which in
+// containers are lost with the pod. Endpoint is the collector's base URL, e.g.
+// "http://tracewrap-collector:4321"; shipping is disabled when it is empty. Timeout bounds each
+// upload (default 10s); uploads run in the background, never under the tracer's lock. Uploads
+// that fail are written to the local files instead. Token, or the environment variable named by
+// TokenEnv at run time, is presented to a collector started with --token, and CAFile names the
+// PEM CA certificates to verify an https endpoint with instead of the system roots.
+type CollectorConfig struct {
+	Endpoint string        `yaml:"endpoint"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Token    string        `yaml:"token"`
+	TokenEnv string        `yaml:"tokenEnv"`
+	CAFile   string        `yaml:"caFile"`
+}
+
+// TailSamplingConfig enables tail-based sampling: the records of each trace (a root call, or the
+// first traced call on a new goroutine such as an HTTP handler, with everything it calls) are
+// buffered until the trace completes and kept only if a call in it returned an error, panicked or
+// violated its SLO, if the trace took at least Latency (0 disables the latency check), or if it
+// matches one of Rules. Once MaxPending records (default 10000) are buffered, the traces still in
+// progress are decided with the records completed so far, as on a flush.
+type TailSamplingConfig struct {
+	Enable     bool          `yaml:"enable"`
+	Latency    time.Duration `yaml:"latency"`
+	Rules      []TailRule    `yaml:"rules"`
+	MaxPending int           `yaml:"maxPending"`
+}
+
+// TailRule keeps traces containing a call to a function matching Function (a path.Match glob)
+// that took at least MinDuration.
+type TailRule struct {
+	Function    string        `yaml:"function"`
+	MinDuration time.Duration `yaml:"minDuration"`
+}
+
+// SLOConfig is the latency budget for the functions matching Function or the HTTP handlers
+// serving routes matching Route (exactly one of them is set). Both are glob patterns in the
+// syntax of path.Match; routes are the http.ServeMux pattern of the request ("GET /orders/{id}")
+// or, without one, its method and path ("GET /orders/42"). The first matching entry applies.
+type SLOConfig struct {
+	Function string        `yaml:"function"`
+	Route    string        `yaml:"route"`
+	Latency  time.Duration `yaml:"latency"`
+}
+
+// CaptureConfig limits and formats the parameter and return values stored on trace records. Depth
+// is the number of nesting levels rendered (structs, pointers, slices, maps), MaxElements the
+// number of slice, array and map elements shown, and MaxStringLength the length at which strings
+// are cut; 0 means no limit for each. Encoding is "text" (the default, like fmt's %+v), "json", or
+// "dump" (type-annotated, in the style of go-spew). Lazy keeps the raw values and renders them when
+// records are flushed or dumped instead of inside the traced call; values reached through pointers,
+// slices or maps then show their state at flush time. RaceSafe renders only values of basic kinds,
+// which are copied when passed to the tracer, and records every other value (pointers, slices,
+// maps, structs, interfaces) by its type name, so the tracer never reads memory the application may
+// be writing concurrently; error chains are not captured either. MaxParams and MaxReturns cap the
+// parameters and return values captured per call, the first ones in declaration order, and
+// MaxValueBytes the length of each rendered value; 0 means no limit for each. The instrumenter only
+// injects the captures within the caps, and passes error results past MaxReturns for the tracer to
+// see without recording them; the tracer enforces the caps again for the values recorded by hand,
+// and renders each value only as far as MaxValueBytes. A variadic parameter counts as one
+// parameter, and MaxVariadicArgs caps the arguments of one that are recorded one by one (0 for the
+// default of 16). Sizes records the length of slice, map and string values, and the capacity of
+// slices, next to the values; SizesOnly records the sizes instead of those values, which are
+// rendered by type and size, e.g. "<[]string len=3 cap=4>". PointerIDs records a hash of the
+// address each pointer parameter holds, so the calls an object is passed to can be followed (see
+// analyze pointers).
+type CaptureConfig struct {
+	Depth           int    `yaml:"depth"`
+	MaxElements     int    `yaml:"maxElements"`
+	MaxStringLength int    `yaml:"maxStringLength"`
+	MaxParams       int    `yaml:"maxParams"`
+	MaxReturns      int    `yaml:"maxReturns"`
+	MaxValueBytes   int    `yaml:"maxValueBytes"`
+	MaxVariadicArgs int    `yaml:"maxVariadicArgs"`
+	Encoding        string `yaml:"encoding"`
+	Lazy            bool   `yaml:"lazy"`
+	RaceSafe        bool   `yaml:"raceSafe"`
+	Sizes           bool   `yaml:"sizes"`
+	SizesOnly       bool   `yaml:"sizesOnly"`
+	PointerIDs      bool   `yaml:"pointerIds"`
+}
+
+// ControlConfig provides configuration options for the control endpoint embedded in
+// instrumented binaries. The endpoint is disabled when Listen is empty. With Token, or the
+// environment variable named by TokenEnv at run time, set, requests must present that token
+// (tracewrap ctl --token). TLSCert and TLSKey, PEM files read at run time, serve it over HTTPS.
+type ControlConfig struct {
+	Listen   string `yaml:"listen"`
+	Token    string `yaml:"token"`
+	TokenEnv string `yaml:"tokenEnv"`
+	TLSCert  string `yaml:"tlsCert"`
+	TLSKey   string `yaml:"tlsKey"`
 }
 
 // VisualizationConfig provides configuration options for visualization.
 // It contains a flag indicating whether to generate a call graph and the output path for the call graph.
+// CollapseRecursion folds recursive frames into the outermost call of their chain in the call graph.
+// TraceLinks turn the external trace IDs of records into links to the APM holding those traces.
 type VisualizationConfig struct {
-	GenerateCallGraph bool   `yaml:"generateCallGraph"`
-	CallGraphOutput   string `yaml:"callGraphOutput"`
+	GenerateCallGraph bool        `yaml:"generateCallGraph"`
+	CallGraphOutput   string      `yaml:"callGraphOutput"`
+	CollapseRecursion bool        `yaml:"collapseRecursion"`
+	TraceLinks        []TraceLink `yaml:"traceLinks"`
+	Theme             ThemeConfig `yaml:"theme"`
+}
+
+// ThemeConfig selects the colors of the call graphs, HTML reports and collector pages: the light
+// or dark theme, with the colors of Palette replacing some of its own, by role, e.g.
+// error: "#b2182b" (see theme.Roles).
+type ThemeConfig struct {
+	Name    string            `yaml:"name"`
+	Palette map[string]string `yaml:"palette"`
+}
+
+// TraceLink is a link to an external trace system such as Jaeger or Grafana Tempo. URL is a
+// template in which {traceId} and {spanId} are replaced by the IDs of the trace and span, e.g.
+// "http://jaeger:16686/trace/{traceId}?uiFind={spanId}".
+type TraceLink struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+}
+
+// AlertsConfig declares alerting rules, evaluated on recorded traces by tracewrap analyze alerts
+// and by the collector. Webhook is the notification URL of rules that do not set their own; a
+// Slack incoming webhook URL gets Slack-formatted messages.
+type AlertsConfig struct {
+	Webhook string      `yaml:"webhook"`
+	Rules   []AlertRule `yaml:"rules"`
+}
+
+// AlertRule fires when the calls matching its When expression occur often enough, e.g.
+// `function="divide" and returns contains "cannot divide by zero" more than 0 times`.
+type AlertRule struct {
+	Name    string `yaml:"name"`
+	When    string `yaml:"when"`
+	Webhook string `yaml:"webhook"`
+}
+
+// BudgetConfig is a performance budget of the packages matching Package, a directory relative
+// to the project root such as "internal/shared/...", checked by tracewrap check. MaxTimeShare is
+// the largest share of a run's time, in percent, the packages may take and MaxAllocBytes the
+// most heap growth they may cause; 0 leaves a limit unchecked.
+type BudgetConfig struct {
+	Package       string  `yaml:"package" json:"package"`
+	MaxTimeShare  float64 `yaml:"maxTimeShare" json:"maxTimeShare,omitempty"`
+	MaxAllocBytes uint64  `yaml:"maxAllocBytes" json:"maxAllocBytes,omitempty"`
+}
+
+// StoreConfig is the retention policy of the store of past runs, applied by tracewrap store add
+// and store prune. Runs added longer ago than MaxAge are removed, then the oldest runs until the
+// store uses at most MaxSizeBytes; the KeepLast most recent runs, the baselines of analyses such
+// as analyze anomalies, are always kept. 0 leaves a limit unchecked.
+type StoreConfig struct {
+	MaxAge       time.Duration `yaml:"maxAge"`
+	MaxSizeBytes int64         `yaml:"maxSizeBytes"`
+	KeepLast     int           `yaml:"keepLast"`
 }
 
 // Config aggregates all configuration settings including instrumentation, logging,
-// tracing, and visualization configurations.
+// tracing, visualization, alerting, budget and store configurations.
 type Config struct {
 	Instrumentation InstrumentationConfig `yaml:"instrumentation"`
 	Logging         LoggingConfig         `yaml:"logging"`
 	Tracing         TracingConfig         `yaml:"tracing"`
 	Visualization   VisualizationConfig   `yaml:"visualization"`
+	Alerts          AlertsConfig          `yaml:"alerts"`
+	Budgets         []BudgetConfig        `yaml:"budgets"`
+	Store           StoreConfig           `yaml:"store"`
 }
 
 // LoadConfig reads a YAML configuration file and unmarshals its contents into a Config struct.
@@ -69,3 +355,194 @@ func LoadConfig(filename string) (*Config, error) {
 	}
 	return &cfg, nil
 }
+
+// Validate checks the configuration for values the instrumenter or tracer cannot use.
+// Each problem is reported with the key it concerns and how to fix it.
+//
+// Returns:
+//   - error: all problems found joined together, or nil if the configuration is valid.
+func (c Config) Validate() error {
+	var problems []error
+	for _, pattern := range c.Instrumentation.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			problems = append(problems, fmt.Errorf("instrumentation.exclude: invalid pattern %q: %v", pattern, err))
+		}
+	}
+	switch c.Instrumentation.NameFormat {
+	case "", "short", "package", "full":
+	default:
+		problems = append(problems, fmt.Errorf("instrumentation.nameFormat: unknown format %q; use short, package, or full", c.Instrumentation.NameFormat))
+	}
+	switch c.Instrumentation.Injection {
+	case "", "ast", "template":
+	default:
+		problems = append(problems, fmt.Errorf("instrumentation.injection: unknown mechanism %q; use ast or template", c.Instrumentation.Injection))
+	}
+	for _, metric := range c.Instrumentation.Metrics {
+		switch metric {
+		case "time", "mem", "goroutines", "io":
+		default:
+			problems = append(problems, fmt.Errorf("instrumentation.metrics: unknown metric %q; use time, mem, goroutines, or io", metric))
+		}
+	}
+	if c.Logging.MaxLinesPerSecond < 0 {
+		problems = append(problems, fmt.Errorf("logging.maxLinesPerSecond: %d is negative; use 0 for no limit", c.Logging.MaxLinesPerSecond))
+	}
+	t := c.Tracing
+	if t.SampleRate < 0 || t.SampleRate > 1 {
+		problems = append(problems, fmt.Errorf("tracing.sampleRate: %v is outside [0, 1]; use e.g. 0.1 to record one in ten root calls", t.SampleRate))
+	}
+	if t.MaxRecordsInMemory < 0 {
+		problems = append(problems, fmt.Errorf("tracing.maxRecordsInMemory: %d is negative; use 0 for no limit", t.MaxRecordsInMemory))
+	}
+	if t.MaxOverheadPercent < 0 {
+		problems = append(problems, fmt.Errorf("tracing.maxOverheadPercent: %v is negative; use 0 to disable the budget", t.MaxOverheadPercent))
+	}
+	if t.BlockProfileRate < 0 {
+		problems = append(problems, fmt.Errorf("tracing.blockProfileRate: %d is negative; use 1 to sample every blocking event or 0 to disable", t.BlockProfileRate))
+	}
+	if t.MutexProfileFraction < 0 {
+		problems = append(problems, fmt.Errorf("tracing.mutexProfileFraction: %d is negative; use 1 to sample every contention event or 0 to disable", t.MutexProfileFraction))
+	}
+	for i, slo := range t.SLOs {
+		if (slo.Function == "") == (slo.Route == "") {
+			problems = append(problems, fmt.Errorf("tracing.slos[%d]: set exactly one of function or route", i))
+		}
+		for _, pattern := range []string{slo.Function, slo.Route} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				problems = append(problems, fmt.Errorf("tracing.slos[%d]: invalid pattern %q: %v", i, pattern, err))
+			}
+		}
+		if slo.Latency <= 0 {
+			problems = append(problems, fmt.Errorf("tracing.slos[%d].latency: must be positive, e.g. 200ms", i))
+		}
+	}
+	if t.TailSampling.Latency < 0 {
+		problems = append(problems, fmt.Errorf("tracing.tailSampling.latency: %v is negative; use 0 to disable the latency check", t.TailSampling.Latency))
+	}
+	if t.TailSampling.MaxPending < 0 {
+		problems = append(problems, fmt.Errorf("tracing.tailSampling.maxPending: %d is negative; use 0 for the default of 10000 records", t.TailSampling.MaxPending))
+	}
+	for i, pattern := range t.EntryStacks {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			problems = append(problems, fmt.Errorf("tracing.entryStacks[%d]: invalid pattern %q", i, pattern))
+		}
+	}
+	for i, pattern := range t.FileDescriptorFunctions {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			problems = append(problems, fmt.Errorf("tracing.fileDescriptorFunctions[%d]: invalid pattern %q", i, pattern))
+		}
+	}
+	if t.EntryStackDepth < 0 {
+		problems = append(problems, fmt.Errorf("tracing.entryStackDepth: %d is negative; use 0 for the default of 8 frames", t.EntryStackDepth))
+	}
+	for i, rule := range t.TailSampling.Rules {
+		if _, err := path.Match(rule.Function, ""); err != nil || rule.Function == "" {
+			problems = append(problems, fmt.Errorf("tracing.tailSampling.rules[%d].function: invalid pattern %q", i, rule.Function))
+		}
+	}
+	switch t.Capture.Encoding {
+	case "", "text", "json", "dump":
+	default:
+		problems = append(problems, fmt.Errorf("tracing.capture.encoding: unknown encoding %q; use text, json, or dump", t.Capture.Encoding))
+	}
+	if t.Capture.Depth < 0 || t.Capture.MaxElements < 0 || t.Capture.MaxStringLength < 0 {
+		problems = append(problems, fmt.Errorf("tracing.capture: depth, maxElements and maxStringLength must not be negative; use 0 for no limit"))
+	}
+	if t.Capture.MaxParams < 0 || t.Capture.MaxReturns < 0 || t.Capture.MaxValueBytes < 0 {
+		problems = append(problems, fmt.Errorf("tracing.capture: maxParams, maxReturns and maxValueBytes must not be negative; use 0 for no limit"))
+	}
+	if t.Capture.MaxVariadicArgs < 0 {
+		problems = append(problems, fmt.Errorf("tracing.capture.maxVariadicArgs: %d is negative; use 0 for the default of 16 arguments", t.Capture.MaxVariadicArgs))
+	}
+	for i, bound := range t.HistogramBuckets {
+		if bound <= 0 {
+			problems = append(problems, fmt.Errorf("tracing.histogramBuckets: bucket %v must be positive", bound))
+		} else if i > 0 && bound <= t.HistogramBuckets[i-1] {
+			problems = append(problems, fmt.Errorf("tracing.histogramBuckets: buckets must be in increasing order (%v follows %v)", bound, t.HistogramBuckets[i-1]))
+		}
+	}
+	if addr := t.Control.Listen; addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			problems = append(problems, fmt.Errorf("tracing.control.listen: %q is not host:port (e.g. \"127.0.0.1:7070\"): %v", addr, err))
+		}
+	}
+	if (t.Control.TLSCert == "") != (t.Control.TLSKey == "") {
+		problems = append(problems, fmt.Errorf("tracing.control: set both tlsCert and tlsKey, or neither"))
+	}
+	if endpoint := t.Collector.Endpoint; endpoint != "" {
+		if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Errorf("tracing.collector.endpoint: %q is not an http or https URL (e.g. \"http://tracewrap-collector:4321\")", endpoint))
+		}
+	}
+	if t.Collector.Timeout < 0 {
+		problems = append(problems, fmt.Errorf("tracing.collector.timeout: %v is negative; use 0 for the default", t.Collector.Timeout))
+	}
+	if t.PersistInterval < 0 {
+		problems = append(problems, fmt.Errorf("tracing.persistInterval: %v is negative; use e.g. 1s, or 0 to write only on flush", t.PersistInterval))
+	}
+	if !isHTTPURL(t.PanicWebhook) {
+		problems = append(problems, fmt.Errorf("tracing.panicWebhook: %q is not an http or https URL", t.PanicWebhook))
+	}
+	if !isHTTPURL(c.Alerts.Webhook) {
+		problems = append(problems, fmt.Errorf("alerts.webhook: %q is not an http or https URL", c.Alerts.Webhook))
+	}
+	names := make(map[string]bool)
+	for i, rule := range c.Alerts.Rules {
+		if rule.Name == "" {
+			problems = append(problems, fmt.Errorf("alerts.rules[%d].name: must be set", i))
+		} else if names[rule.Name] {
+			problems = append(problems, fmt.Errorf("alerts.rules[%d].name: %q is used by an earlier rule", i, rule.Name))
+		}
+		names[rule.Name] = true
+		if rule.When == "" {
+			problems = append(problems, fmt.Errorf("alerts.rules[%d].when: must be set, e.g. 'function=\"divide\" and panicked more than 0 times'", i))
+		}
+		if !isHTTPURL(rule.Webhook) {
+			problems = append(problems, fmt.Errorf("alerts.rules[%d].webhook: %q is not an http or https URL", i, rule.Webhook))
+		}
+	}
+	for i, b := range c.Budgets {
+		if b.Package == "" {
+			problems = append(problems, fmt.Errorf("budgets[%d].package: must be set, e.g. internal/shared/...", i))
+		} else if _, err := path.Match(strings.TrimSuffix(b.Package, "/..."), ""); err != nil {
+			problems = append(problems, fmt.Errorf("budgets[%d].package: invalid pattern %q: %v", i, b.Package, err))
+		}
+		if b.MaxTimeShare < 0 || b.MaxTimeShare > 100 {
+			problems = append(problems, fmt.Errorf("budgets[%d].maxTimeShare: %v is outside [0, 100]; use e.g. 5 for 5%% of the run's time", i, b.MaxTimeShare))
+		}
+		if b.MaxTimeShare == 0 && b.MaxAllocBytes == 0 {
+			problems = append(problems, fmt.Errorf("budgets[%d]: set maxTimeShare, maxAllocBytes or both", i))
+		}
+	}
+	if c.Store.MaxAge < 0 {
+		problems = append(problems, fmt.Errorf("store.maxAge: %v is negative; use e.g. 720h, or 0 to keep runs of any age", c.Store.MaxAge))
+	}
+	if c.Store.MaxSizeBytes < 0 {
+		problems = append(problems, fmt.Errorf("store.maxSizeBytes: %d is negative; use 0 for no limit", c.Store.MaxSizeBytes))
+	}
+	if c.Store.KeepLast < 0 {
+		problems = append(problems, fmt.Errorf("store.keepLast: %d is negative", c.Store.KeepLast))
+	}
+	for i, link := range c.Visualization.TraceLinks {
+		if link.Name == "" {
+			problems = append(problems, fmt.Errorf("visualization.traceLinks[%d].name: must be set, e.g. Jaeger", i))
+		}
+		if !strings.Contains(link.URL, "{traceId}") || link.URL == "" || !isHTTPURL(link.URL) {
+			problems = append(problems, fmt.Errorf("visualization.traceLinks[%d].url: %q is not an http or https URL containing {traceId}", i, link.URL))
+		}
+	}
+	if _, err := theme.New(c.Visualization.Theme.Name, c.Visualization.Theme.Palette); err != nil {
+		problems = append(problems, fmt.Errorf("visualization.theme: %v", err))
+	}
+	return errors.Join(problems...)
+}
+
+// isHTTPURL reports whether v is empty or an http or https URL with a host.
+func isHTTPURL(v string) bool {
+	if v == "" {
+		return true
+	}
+	u, err := url.Parse(v)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
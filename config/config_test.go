@@ -0,0 +1,57 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+)
+
+func TestValidateAcceptsDefaults(t *testing.T) {
+	if err := (config.Config{}).Validate(); err != nil {
+		t.Errorf("Expected the zero configuration to be valid, got: %v", err)
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := config.Config{
+		Instrumentation: config.InstrumentationConfig{Exclude: []string{"["}, NameFormat: "long", Injection: "text", Metrics: []string{"cpu"}},
+		Tracing: config.TracingConfig{
+			SampleRate:              1.5,
+			HistogramBuckets:        []time.Duration{time.Second, time.Millisecond},
+			Control:                 config.ControlConfig{Listen: "7070"},
+			SLOs:                    []config.SLOConfig{{Function: "handle", Route: "GET /", Latency: time.Second}},
+			Collector:               config.CollectorConfig{Endpoint: "collector:4321"},
+			PanicWebhook:            "hooks.slack.com/services/x",
+			Capture:                 config.CaptureConfig{MaxReturns: -1, MaxVariadicArgs: -1},
+			EntryStacks:             []string{"["},
+			EntryStackDepth:         -1,
+			FileDescriptorFunctions: []string{""},
+			TailSampling:            config.TailSamplingConfig{MaxPending: -1},
+		},
+		Visualization: config.VisualizationConfig{TraceLinks: []config.TraceLink{{Name: "Jaeger", URL: "http://jaeger:16686/search"}}, Theme: config.ThemeConfig{Name: "sepia"}},
+		Alerts:        config.AlertsConfig{Rules: []config.AlertRule{{Name: "panics", When: "panicked"}, {Name: "panics"}}},
+		Budgets:       []config.BudgetConfig{{Package: "internal/...", MaxTimeShare: 150}, {Package: "pkg/cache"}},
+		Store:         config.StoreConfig{MaxAge: -time.Hour, KeepLast: -1},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation errors")
+	}
+	for _, key := range []string{"instrumentation.exclude", "instrumentation.nameFormat", "instrumentation.injection", "instrumentation.metrics", "tracing.sampleRate", "tracing.histogramBuckets", "tracing.control.listen", "tracing.slos[0]", "tracing.collector.endpoint", "tracing.panicWebhook", "maxReturns", "tracing.entryStacks[0]", "tracing.entryStackDepth", "tracing.fileDescriptorFunctions[0]", "tracing.tailSampling.maxPending", "tracing.capture.maxVariadicArgs", "visualization.traceLinks[0].url", "visualization.theme", "alerts.rules[1].name", "alerts.rules[1].when", "budgets[0].maxTimeShare", "budgets[1]", "store.maxAge", "store.keepLast"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected a problem for %s; got: %v", key, err)
+		}
+	}
+}
+
+func TestValidateAcceptsTraceLinkTemplates(t *testing.T) {
+	cfg := config.Config{Visualization: config.VisualizationConfig{TraceLinks: []config.TraceLink{
+		{Name: "Jaeger", URL: "http://jaeger:16686/trace/{traceId}?uiFind={spanId}"},
+		{Name: "Tempo", URL: "https://grafana.example.com/explore?left={\"queries\":[{\"query\":\"{traceId}\"}]}"},
+	}}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected the trace link templates to be valid, got: %v", err)
+	}
+}
@@ -0,0 +1,682 @@
+// Package alert evaluates the alerting rules of the alerts configuration section on trace records.
+//
+// A rule's when expression selects calls and says how many are too many:
+//
+//	function="divide" and returns contains "cannot divide by zero" more than 0 times
+//	panicked or (route matches "^POST /orders" and duration > 2s) more than 5 times
+//
+// Conditions compare a field of a record with a value. String fields are function, route, region,
+// status (the span status, ok or error), panic (the panic value), returns (any return value), error
+// (any message of the error chain), params.<name> and labels.<name>; they support =, !=, contains
+// and matches (a regular expression). duration supports =, !=, <, <=, > and >= with a Go duration
+// such as 250ms. panicked and slo_violated are conditions by themselves. Conditions combine with
+// and, or, not and parentheses; "more than N times" defaults to more than 0 times. A leading
+// "alert when" is ignored, so rules can be written as sentences.
+//
+// A params.<name> condition with a number, such as params.jobID > 100, or with true or false
+// compares the typed value of the parameter (see tracer.TraceRecord.TypedParams) instead of its
+// rendered form, and also supports <, <=, > and >= against a number. For = and !=, a parameter
+// without a typed value compares its rendered form with the number or boolean as written.
+package alert
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/notify"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// maxExamples is the number of matching calls kept per fired alert.
+const maxExamples = 5
+
+// Rule is a compiled alerting rule.
+type Rule struct {
+	Name      string
+	When      string
+	Webhook   string // The notification URL, or "" for none.
+	Threshold int    // The rule fires when more than Threshold calls match.
+	match     node
+}
+
+// Alert is a rule that fired.
+type Alert struct {
+	Rule      string    `json:"rule"`
+	When      string    `json:"when"`
+	Matches   int       `json:"matches"`   // Number of matching calls.
+	Threshold int       `json:"threshold"` // The number of calls allowed.
+	Examples  []Example `json:"examples"`  // The first matching calls.
+	Webhook   string    `json:"-"`
+}
+
+// Example is a call that matched a rule.
+type Example struct {
+	UniqueID     int64         `json:"uniqueId"`
+	FunctionName string        `json:"functionName"`
+	Duration     time.Duration `json:"duration"`
+}
+
+// Load compiles the rules of cfg. Rules without a webhook use cfg.Webhook.
+//
+// Parameters:
+//   - cfg (config.AlertsConfig): the alerts configuration section.
+//
+// Returns:
+//   - []*Rule: the rules, in configuration order.
+//   - error: the problems with all rules that do not compile, joined together.
+func Load(cfg config.AlertsConfig) ([]*Rule, error) {
+	var rules []*Rule
+	var problems []error
+	for i, r := range cfg.Rules {
+		rule, err := Compile(r.Name, r.When)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("alerts.rules[%d].when: %v", i, err))
+			continue
+		}
+		rule.Webhook = r.Webhook
+		if rule.Webhook == "" {
+			rule.Webhook = cfg.Webhook
+		}
+		rules = append(rules, rule)
+	}
+	return rules, errors.Join(problems...)
+}
+
+// Compile parses a when expression.
+//
+// Parameters:
+//   - name (string): the name of the rule.
+//   - when (string): the expression.
+//
+// Returns:
+//   - *Rule: the rule, without a webhook.
+//   - error: an error describing the first syntax problem and where it is.
+func Compile(name, when string) (*Rule, error) {
+	tokens, err := lex(when)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if p.peekWord("alert") {
+		p.next()
+		if err := p.expectWord("when"); err != nil {
+			return nil, err
+		}
+	}
+	match, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	rule := &Rule{Name: name, When: when, match: match}
+	if p.peekWord("more") {
+		p.next()
+		if err := p.expectWord("than"); err != nil {
+			return nil, err
+		}
+		t := p.next()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != tokNumber || err != nil || n < 0 {
+			return nil, fmt.Errorf("column %d: expected a number of times, got %s", t.pos, t.describe())
+		}
+		rule.Threshold = n
+		if !p.peekWord("times") && !p.peekWord("time") {
+			return nil, fmt.Errorf("column %d: expected \"times\", got %s", p.peek().pos, p.peek().describe())
+		}
+		p.next()
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("column %d: unexpected %s", t.pos, t.describe())
+	}
+	return rule, nil
+}
+
+// Matches reports whether rec satisfies the rule's condition.
+//
+// Parameters:
+//   - rec (*tracer.TraceRecord): the record.
+//
+// Returns:
+//   - bool: true if the record matches.
+func (r *Rule) Matches(rec *tracer.TraceRecord) bool {
+	return r.match.eval(rec)
+}
+
+// Evaluate applies rules to records.
+//
+// Parameters:
+//   - rules ([]*Rule): the rules.
+//   - records ([]tracer.TraceRecord): the trace records.
+//
+// Returns:
+//   - []Alert: the rules that fired, in rule order.
+func Evaluate(rules []*Rule, records []tracer.TraceRecord) []Alert {
+	return NewTally(rules).Add(records)
+}
+
+// Tally applies rules to a trace read in parts, e.g. the uploads of a collected run, so each part
+// is evaluated once.
+type Tally struct {
+	alerts []Alert // The matches so far, per rule.
+	fired  []bool
+	rules  []*Rule
+}
+
+// NewTally returns a tally of rules without any matches.
+//
+// Parameters:
+//   - rules ([]*Rule): the rules.
+//
+// Returns:
+//   - *Tally: the tally.
+func NewTally(rules []*Rule) *Tally {
+	t := &Tally{alerts: make([]Alert, len(rules)), fired: make([]bool, len(rules)), rules: rules}
+	for i, rule := range rules {
+		t.alerts[i] = Alert{Rule: rule.Name, When: rule.When, Threshold: rule.Threshold, Webhook: rule.Webhook}
+	}
+	return t
+}
+
+// Add applies the rules to the next records of the trace.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the records following those already added.
+//
+// Returns:
+//   - []Alert: the rules that fire with these records and had not fired before, in rule order.
+func (t *Tally) Add(records []tracer.TraceRecord) []Alert {
+	var alerts []Alert
+	for i, rule := range t.rules {
+		a := &t.alerts[i]
+		for j := range records {
+			if !rule.Matches(&records[j]) {
+				continue
+			}
+			a.Matches++
+			if len(a.Examples) < maxExamples {
+				a.Examples = append(a.Examples, Example{UniqueID: records[j].UniqueID, FunctionName: records[j].FunctionName, Duration: records[j].Duration})
+			}
+		}
+		if a.Matches > a.Threshold && !t.fired[i] {
+			t.fired[i] = true
+			fired := *a
+			fired.Examples = append([]Example(nil), a.Examples...)
+			alerts = append(alerts, fired)
+		}
+	}
+	return alerts
+}
+
+// summary describes how often the alert's rule matched.
+func (a Alert) summary() string {
+	return fmt.Sprintf("%d matching calls (more than %d allowed)", a.Matches, a.Threshold)
+}
+
+// Message returns the notification for the alert.
+//
+// Parameters:
+//   - source (string): what was evaluated, e.g. a trace file or a collected run ID.
+//
+// Returns:
+//   - notify.Message: the notification.
+func (a Alert) Message(source string) notify.Message {
+	examples := make([]string, 0, len(a.Examples))
+	for _, e := range a.Examples {
+		examples = append(examples, fmt.Sprintf("ID %d %s (%v)", e.UniqueID, e.FunctionName, e.Duration))
+	}
+	return notify.Message{
+		Title: fmt.Sprintf("tracewrap alert %s fired", a.Rule),
+		Text:  fmt.Sprintf("%s in %s", a.summary(), source),
+		Fields: []notify.Field{
+			{Name: "Rule", Value: a.When},
+			{Name: "Examples", Value: strings.Join(examples, "\n")},
+		},
+	}
+}
+
+// Write prints alerts followed by their example calls.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - alerts ([]Alert): the fired alerts.
+//
+// Returns:
+//   - error: an error if writing fails.
+func Write(w io.Writer, alerts []Alert) error {
+	for _, a := range alerts {
+		if _, err := fmt.Fprintf(w, "ALERT %s: %s\n  when %s\n", a.Rule, a.summary(), a.When); err != nil {
+			return err
+		}
+		for _, e := range a.Examples {
+			if _, err := fmt.Fprintf(w, "  ID %d  %s  %v\n", e.UniqueID, e.FunctionName, e.Duration); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// node is a compiled condition.
+type node interface {
+	eval(rec *tracer.TraceRecord) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(rec *tracer.TraceRecord) bool { return n.left.eval(rec) && n.right.eval(rec) }
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(rec *tracer.TraceRecord) bool { return n.left.eval(rec) || n.right.eval(rec) }
+
+type notNode struct{ operand node }
+
+func (n notNode) eval(rec *tracer.TraceRecord) bool { return !n.operand.eval(rec) }
+
+// flagNode is a boolean field.
+type flagNode func(rec *tracer.TraceRecord) bool
+
+func (n flagNode) eval(rec *tracer.TraceRecord) bool { return n(rec) }
+
+// stringNode compares the values of a string field. It matches if any value matches, except for
+// !=, which matches if no value equals the operand.
+type stringNode struct {
+	values  func(rec *tracer.TraceRecord) []string
+	op      string
+	operand string
+	re      *regexp.Regexp
+}
+
+func (n stringNode) eval(rec *tracer.TraceRecord) bool {
+	values := n.values(rec)
+	if n.op == "!=" {
+		for _, v := range values {
+			if v == n.operand {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range values {
+		switch {
+		case n.op == "contains" && strings.Contains(v, n.operand),
+			n.op == "matches" && n.re.MatchString(v),
+			n.op == "=" && v == n.operand:
+			return true
+		}
+	}
+	return false
+}
+
+// typedParamNode compares the typed value of the parameter key with a number or a boolean.
+type typedParamNode struct {
+	key     string
+	op      string
+	text    string // The operand as written, compared with the rendered value of untyped parameters.
+	number  float64
+	boolean bool
+	isBool  bool
+}
+
+func (n typedParamNode) eval(rec *tracer.TraceRecord) bool {
+	p, ok := rec.TypedParams[n.key]
+	if !ok {
+		if n.op != "=" && n.op != "!=" {
+			return false
+		}
+		rendered := func(rec *tracer.TraceRecord) []string { return present(rec.Params, n.key) }
+		return stringNode{values: rendered, op: n.op, operand: n.text}.eval(rec)
+	}
+	var order int
+	if n.isBool {
+		b, isBool := p.Value.(bool)
+		if !isBool {
+			return n.op == "!="
+		}
+		if b != n.boolean {
+			order = 1
+		}
+	} else {
+		f, isNumber := p.Float64()
+		if !isNumber {
+			return n.op == "!="
+		}
+		order = cmp.Compare(f, n.number)
+	}
+	switch n.op {
+	case "=":
+		return order == 0
+	case "!=":
+		return order != 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	}
+	return order >= 0
+}
+
+// durationNode compares the duration of a call.
+type durationNode struct {
+	op      string
+	operand time.Duration
+}
+
+func (n durationNode) eval(rec *tracer.TraceRecord) bool {
+	d := rec.Duration
+	switch n.op {
+	case "=":
+		return d == n.operand
+	case "!=":
+		return d != n.operand
+	case "<":
+		return d < n.operand
+	case "<=":
+		return d <= n.operand
+	case ">":
+		return d > n.operand
+	}
+	return d >= n.operand
+}
+
+// flags are the boolean fields.
+var flags = map[string]flagNode{
+	"panicked":     func(rec *tracer.TraceRecord) bool { return rec.PanicValue != nil },
+	"slo_violated": func(rec *tracer.TraceRecord) bool { return rec.SLOViolated },
+}
+
+// stringField returns the accessor of the string field name, or nil if there is none.
+func stringField(name string) func(rec *tracer.TraceRecord) []string {
+	if key, ok := strings.CutPrefix(name, "params."); ok && key != "" {
+		return func(rec *tracer.TraceRecord) []string { return present(rec.Params, key) }
+	}
+	if key, ok := strings.CutPrefix(name, "labels."); ok && key != "" {
+		return func(rec *tracer.TraceRecord) []string { return present(rec.Labels, key) }
+	}
+	switch name {
+	case "function":
+		return func(rec *tracer.TraceRecord) []string { return []string{rec.FunctionName} }
+	case "route":
+		return func(rec *tracer.TraceRecord) []string { return []string{rec.Route} }
+	case "region":
+		return func(rec *tracer.TraceRecord) []string { return []string{rec.Region} }
+	case "status":
+		return func(rec *tracer.TraceRecord) []string { return []string{rec.Status} }
+	case "returns":
+		return func(rec *tracer.TraceRecord) []string { return rec.ReturnValues }
+	case "panic":
+		return func(rec *tracer.TraceRecord) []string {
+			if rec.PanicValue == nil {
+				return nil
+			}
+			return []string{fmt.Sprint(rec.PanicValue)}
+		}
+	case "error":
+		return func(rec *tracer.TraceRecord) []string {
+			messages := make([]string, 0, len(rec.ErrorChain))
+			for _, link := range rec.ErrorChain {
+				messages = append(messages, link.Message)
+			}
+			return messages
+		}
+	}
+	return nil
+}
+
+// present returns m[key] as a single value, or no values if key is absent.
+func present(m map[string]string, key string) []string {
+	if v, ok := m[key]; ok {
+		return []string{v}
+	}
+	return nil
+}
+
+// parser is a recursive descent parser over the tokens of an expression.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// peekWord reports whether the next token is the keyword word.
+func (p *parser) peekWord(word string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && strings.EqualFold(t.text, word)
+}
+
+func (p *parser) expectWord(word string) error {
+	if !p.peekWord(word) {
+		return fmt.Errorf("column %d: expected %q, got %s", p.peek().pos, word, p.peek().describe())
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peekWord("or") {
+		p.next()
+		var right node
+		right, err = p.parseAnd()
+		left = orNode{left, right}
+	}
+	return left, err
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.peekWord("and") {
+		p.next()
+		var right node
+		right, err = p.parseUnary()
+		left = andNode{left, right}
+	}
+	return left, err
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peekWord("not") {
+		p.next()
+		operand, err := p.parseUnary()
+		return notNode{operand}, err
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, fmt.Errorf("column %d: expected \")\", got %s", t.pos, t.describe())
+		}
+		return n, nil
+	}
+	return p.parseCondition()
+}
+
+func (p *parser) parseCondition() (node, error) {
+	field := p.next()
+	if field.kind != tokIdent {
+		return nil, fmt.Errorf("column %d: expected a field, got %s", field.pos, field.describe())
+	}
+	name := field.text
+	if flag, ok := flags[name]; ok {
+		return flag, nil
+	}
+	opTok := p.next()
+	op := opTok.text
+	if op == "==" {
+		op = "="
+	}
+	if opTok.kind != tokOp && !(opTok.kind == tokIdent && (op == "contains" || op == "matches")) {
+		return nil, fmt.Errorf("column %d: expected a comparison after %s, got %s", opTok.pos, name, opTok.describe())
+	}
+	value := p.next()
+	if key, ok := strings.CutPrefix(name, "params."); ok && key != "" && value.kind != tokString {
+		return typedParam(key, opTok, value)
+	}
+	if value.kind != tokString && value.kind != tokNumber {
+		return nil, fmt.Errorf("column %d: expected a value, got %s", value.pos, value.describe())
+	}
+
+	if name == "duration" {
+		if op == "contains" || op == "matches" {
+			return nil, fmt.Errorf("column %d: duration cannot be compared with %s", opTok.pos, op)
+		}
+		d, err := time.ParseDuration(value.text)
+		if err != nil {
+			return nil, fmt.Errorf("column %d: %q is not a duration such as 250ms", value.pos, value.text)
+		}
+		return durationNode{op: op, operand: d}, nil
+	}
+	values := stringField(name)
+	if values == nil {
+		return nil, fmt.Errorf("column %d: unknown field %q", field.pos, name)
+	}
+	n := stringNode{values: values, op: op, operand: value.text}
+	switch op {
+	case "=", "!=", "contains":
+	case "matches":
+		re, err := regexp.Compile(value.text)
+		if err != nil {
+			return nil, fmt.Errorf("column %d: invalid regular expression: %v", value.pos, err)
+		}
+		n.re = re
+	default:
+		return nil, fmt.Errorf("column %d: %s cannot be compared with %s", opTok.pos, name, op)
+	}
+	return n, nil
+}
+
+// typedParam compiles a comparison of the parameter key with a number or true or false, the token
+// value. Negative numbers are lexed as words.
+func typedParam(key string, opTok, value token) (node, error) {
+	n := typedParamNode{key: key, op: opTok.text, text: value.text}
+	if n.op == "==" {
+		n.op = "="
+	}
+	switch f, err := strconv.ParseFloat(value.text, 64); {
+	case (value.kind == tokNumber || value.kind == tokIdent) && err == nil:
+		n.number = f
+	case value.kind == tokIdent && (value.text == "true" || value.text == "false"):
+		n.boolean, n.isBool = value.text == "true", true
+	default:
+		return nil, fmt.Errorf("column %d: expected a value, got %s", value.pos, value.describe())
+	}
+	switch {
+	case n.op == "contains" || n.op == "matches":
+		return nil, fmt.Errorf("column %d: params.%s cannot be compared with %s against a number or boolean", opTok.pos, key, n.op)
+	case n.isBool && n.op != "=" && n.op != "!=":
+		return nil, fmt.Errorf("column %d: params.%s cannot be compared with %s against a boolean", opTok.pos, key, n.op)
+	}
+	return n, nil
+}
+
+// Token kinds.
+const (
+	tokEOF = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+// token is a lexical token; pos is its 1-based column.
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+// describe returns the token for error messages.
+func (t token) describe() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of rule"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lex splits an expression into tokens, ending with a tokEOF token.
+func lex(s string) ([]token, error) {
+	var tokens []token
+	isWord := func(c byte) bool {
+		return c == '_' || c == '.' || c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", start + 1})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", start + 1})
+			i++
+		case c == '"':
+			i++
+			for i < len(s) && s[i] != '"' {
+				if s[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("column %d: unterminated string", start+1)
+			}
+			i++
+			v, err := strconv.Unquote(s[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("column %d: invalid string: %v", start+1, err)
+			}
+			tokens = append(tokens, token{tokString, v, start + 1})
+		case strings.ContainsRune("=!<>", rune(c)):
+			i++
+			if i < len(s) && s[i] == '=' {
+				i++
+			}
+			op := s[start:i]
+			if op == "!" {
+				return nil, fmt.Errorf("column %d: unknown operator \"!\"; use != or not", start+1)
+			}
+			tokens = append(tokens, token{tokOp, op, start + 1})
+		case c >= '0' && c <= '9':
+			for i < len(s) && (isWord(s[i]) || s[i] == 0xc2 || s[i] == 0xb5) {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, s[start:i], start + 1})
+		case isWord(c):
+			for i < len(s) && isWord(s[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, s[start:i], start + 1})
+		default:
+			return nil, fmt.Errorf("column %d: unexpected character %q", start+1, c)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(s) + 1}), nil
+}
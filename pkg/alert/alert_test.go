@@ -0,0 +1,151 @@
+package alert_test
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/alert"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+var records = []tracer.TraceRecord{
+	{UniqueID: 1, FunctionName: "main.divide", ReturnValues: []string{"0", "cannot divide by zero"}, Duration: time.Millisecond},
+	{UniqueID: 2, FunctionName: "main.divide", ReturnValues: []string{"2", "<nil>"}, Params: map[string]string{"b": "2"}, Duration: 3 * time.Second},
+	{UniqueID: 3, FunctionName: "main.handle", Route: "POST /orders", PanicValue: "boom", Duration: 10 * time.Millisecond},
+	{UniqueID: 4, FunctionName: "main.handle", Route: "GET /orders", Status: tracer.StatusError, HTTPStatus: 502, ErrorChain: []tracer.ErrorLink{{Message: "lookup: connection refused"}}},
+	{UniqueID: 5, FunctionName: "main.process", Params: map[string]string{"jobID": "120", "retry": "true", "ratio": "0.5"}, TypedParams: map[string]tracer.Primitive{
+		"jobID": {Kind: tracer.KindInt, Value: int64(120)},
+		"retry": {Kind: tracer.KindBool, Value: true},
+		"ratio": {Kind: tracer.KindFloat, Value: 0.5},
+	}},
+	{UniqueID: 6, FunctionName: "main.process", Params: map[string]string{"jobID": "90", "retry": "false"}, TypedParams: map[string]tracer.Primitive{
+		"jobID": {Kind: tracer.KindUint, Value: uint64(90)},
+		"retry": {Kind: tracer.KindBool, Value: false},
+	}},
+}
+
+func TestRulesMatchCalls(t *testing.T) {
+	tests := []struct {
+		when string
+		want []int64
+	}{
+		{`alert when function="main.divide" and returns contains "cannot divide by zero" more than 0 times`, []int64{1}},
+		{`panicked or duration > 2s`, []int64{2, 3}},
+		{`function == "main.handle" and not panicked`, []int64{4}},
+		{`route matches "^(POST|GET) /orders" and (panicked or error contains "refused")`, []int64{3, 4}},
+		{`params.b = "2"`, []int64{2}},
+		{`params.b != "2"`, []int64{1, 3, 4, 5, 6}},
+		{`duration <= 10ms and duration >= 1ms`, []int64{1, 3}},
+		{`panic = "boom"`, []int64{3}},
+		{`status = "error" and route contains "orders"`, []int64{4}},
+		{`params.jobID > 100`, []int64{5}},
+		{`params.jobID <= 90 or params.ratio >= 0.50`, []int64{5, 6}},
+		{`params.jobID > -1 and params.retry = false`, []int64{6}},
+		{`params.retry != true`, []int64{1, 2, 3, 4, 6}},
+		{`params.ratio = 0.5`, []int64{5}},
+		// Parameters without a typed value compare as rendered.
+		{`params.b = 2`, []int64{2}},
+	}
+	for _, tt := range tests {
+		rule, err := alert.Compile("test", tt.when)
+		if err != nil {
+			t.Errorf("Compile(%q) returned error: %v", tt.when, err)
+			continue
+		}
+		var got []int64
+		for i := range records {
+			if rule.Matches(&records[i]) {
+				got = append(got, records[i].UniqueID)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: expected matches %v, got %v", tt.when, tt.want, got)
+		}
+	}
+}
+
+func TestCompileReportsSyntaxErrors(t *testing.T) {
+	tests := map[string]string{
+		`function=`:                      "expected a value",
+		`function "main.divide"`:         "expected a comparison",
+		`size > 3`:                       "unknown field",
+		`duration contains "1s"`:         "cannot be compared",
+		`duration > fast`:                "expected a value",
+		`function < "a"`:                 "cannot be compared",
+		`route matches "("`:              "invalid regular expression",
+		`panicked more than many times`:  "expected a number",
+		`(panicked or slo_violated`:      `expected ")"`,
+		`function = "a" "b"`:             "unexpected",
+		`function = "unterminated`:       "unterminated string",
+		`panicked more than 2 occasions`: `expected "times"`,
+		`params.jobID contains 2`:        "cannot be compared",
+		`params.retry > true`:            "cannot be compared",
+	}
+	for when, want := range tests {
+		if _, err := alert.Compile("test", when); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Compile(%q): expected an error containing %q, got %v", when, want, err)
+		}
+	}
+}
+
+func TestEvaluateAppliesThresholds(t *testing.T) {
+	rules, err := alert.Load(config.AlertsConfig{
+		Webhook: "https://example.com/hook",
+		Rules: []config.AlertRule{
+			{Name: "divide", When: `function="main.divide" more than 1 times`, Webhook: "https://example.com/divide"},
+			{Name: "handle", When: `function="main.handle" more than 2 times`},
+			{Name: "slow", When: `duration > 1s`},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	alerts := alert.Evaluate(rules, records)
+	if len(alerts) != 2 || alerts[0].Rule != "divide" || alerts[1].Rule != "slow" {
+		t.Fatalf("Expected the divide and slow alerts, got %+v", alerts)
+	}
+	if alerts[0].Matches != 2 || alerts[0].Webhook != "https://example.com/divide" || alerts[1].Webhook != "https://example.com/hook" {
+		t.Errorf("Unexpected alerts %+v", alerts)
+	}
+
+	var buf bytes.Buffer
+	if err := alert.Write(&buf, alerts); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "ALERT divide: 2 matching calls (more than 1 allowed)") {
+		t.Errorf("Unexpected report:\n%s", buf.String())
+	}
+	msg := alerts[1].Message("tracewrap/latest/trace.jsonl")
+	if !strings.Contains(msg.Title, "slow") || !strings.Contains(msg.Text, "tracewrap/latest/trace.jsonl") {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+}
+
+func TestTallyFiresOnceAcrossParts(t *testing.T) {
+	rule, err := alert.Compile("divide", `function="main.divide" more than 1 times`)
+	if err != nil {
+		t.Fatalf("Compile returned error: %v", err)
+	}
+	tally := alert.NewTally([]*alert.Rule{rule})
+	if alerts := tally.Add(records[:1]); len(alerts) != 0 {
+		t.Errorf("Expected no alert after one matching call, got %+v", alerts)
+	}
+	alerts := tally.Add(records[1:])
+	if len(alerts) != 1 || alerts[0].Matches != 2 || len(alerts[0].Examples) != 2 || alerts[0].Examples[0].UniqueID != 1 {
+		t.Fatalf("Expected the divide alert with both calls, got %+v", alerts)
+	}
+	if alerts := tally.Add(records[:2]); len(alerts) != 0 {
+		t.Errorf("Expected the alert to fire once, got %+v", alerts)
+	}
+}
+
+func TestLoadReportsEveryInvalidRule(t *testing.T) {
+	_, err := alert.Load(config.AlertsConfig{Rules: []config.AlertRule{{Name: "a", When: "x"}, {Name: "b", When: "panicked"}, {Name: "c", When: "y ="}}})
+	if err == nil || !strings.Contains(err.Error(), "alerts.rules[0].when") || !strings.Contains(err.Error(), "alerts.rules[2].when") {
+		t.Errorf("Expected errors for rules 0 and 2, got %v", err)
+	}
+}
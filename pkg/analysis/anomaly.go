@@ -0,0 +1,153 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// madScale converts the median absolute deviation into an estimate of the standard deviation
+// for normally distributed data.
+const madScale = 1.4826
+
+// DurationStats is the baseline distribution of one function's call durations.
+type DurationStats struct {
+	Count  int
+	Median time.Duration
+	MAD    time.Duration // Median absolute deviation from Median.
+}
+
+// Baseline maps function names to the distribution of their historical durations.
+type Baseline map[string]DurationStats
+
+// BuildBaseline computes per-function duration statistics from historical records.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): records of past runs, e.g. from the store.
+//
+// Returns:
+//   - Baseline: the statistics per function.
+func BuildBaseline(records []tracer.TraceRecord) Baseline {
+	durations := newFunctionTable[[]time.Duration]()
+	newDurations := func(string) *[]time.Duration { return new([]time.Duration) }
+	for i := range records {
+		ds := durations.get(&records[i], newDurations)
+		*ds = append(*ds, records[i].Duration)
+	}
+	baseline := make(Baseline, len(durations.byName))
+	for name, p := range durations.byName {
+		ds := *p
+		median := medianOf(ds)
+		deviations := make([]time.Duration, len(ds))
+		for i, d := range ds {
+			deviations[i] = absDuration(d - median)
+		}
+		baseline[name] = DurationStats{Count: len(ds), Median: median, MAD: medianOf(deviations)}
+	}
+	return baseline
+}
+
+// medianOf returns the median of ds, which it sorts.
+func medianOf(ds []time.Duration) time.Duration {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	n := len(ds)
+	if n%2 == 1 {
+		return ds[n/2]
+	}
+	return (ds[n/2-1] + ds[n/2]) / 2
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Anomaly is a call whose duration is an outlier compared with the baseline of its function.
+type Anomaly struct {
+	Record tracer.TraceRecord `json:"record"`
+	Median time.Duration      `json:"median"` // The function's baseline median.
+	Score  float64            `json:"score"`  // Robust z-score: deviations from the median in estimated standard deviations.
+}
+
+// AnomalyOptions tunes DetectAnomalies.
+type AnomalyOptions struct {
+	Threshold  float64 // Minimum score for a call to be flagged; 3.5 is a common choice.
+	MinSamples int     // Functions with fewer baseline calls are not checked.
+}
+
+// DetectAnomalies flags the records that are slower than their function's baseline by at least
+// opts.Threshold robust standard deviations (median absolute deviation based, so earlier outliers
+// do not mask new ones). Functions whose baseline durations do not vary are skipped. Anomalies are
+// ordered by score, highest first.
+//
+// Parameters:
+//   - baseline (Baseline): the historical statistics, from BuildBaseline.
+//   - records ([]tracer.TraceRecord): the records to check.
+//   - opts (AnomalyOptions): the detection settings.
+//
+// Returns:
+//   - []Anomaly: the flagged calls.
+func DetectAnomalies(baseline Baseline, records []tracer.TraceRecord, opts AnomalyOptions) []Anomaly {
+	var anomalies []Anomaly
+	for _, rec := range records {
+		stats, ok := baseline[rec.FunctionName]
+		if !ok || stats.Count < opts.MinSamples || stats.MAD == 0 {
+			continue
+		}
+		score := float64(rec.Duration-stats.Median) / (madScale * float64(stats.MAD))
+		if score >= opts.Threshold {
+			anomalies = append(anomalies, Anomaly{Record: rec, Median: stats.Median, Score: score})
+		}
+	}
+	sort.Slice(anomalies, func(i, j int) bool {
+		if anomalies[i].Score != anomalies[j].Score {
+			return anomalies[i].Score > anomalies[j].Score
+		}
+		return anomalies[i].Record.UniqueID < anomalies[j].Record.UniqueID
+	})
+	return anomalies
+}
+
+// WriteAnomalies prints anomalies with their parameters, which often point at the pathological input.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - anomalies ([]Anomaly): the anomalies from DetectAnomalies.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteAnomalies(w io.Writer, anomalies []Anomaly) error {
+	for _, a := range anomalies {
+		rec := a.Record
+		ratio := math.Inf(1)
+		if a.Median > 0 {
+			ratio = float64(rec.Duration) / float64(a.Median)
+		}
+		if _, err := fmt.Fprintf(w, "%s (ID %d): %v, %.1fx the median %v (score %.1f)\n", rec.FunctionName, rec.UniqueID, rec.Duration, ratio, a.Median, a.Score); err != nil {
+			return err
+		}
+		if len(rec.Params) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(rec.Params))
+		for name := range rec.Params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		parts := make([]string, len(names))
+		for i, name := range names {
+			parts[i] = name + "=" + rec.Params[name]
+		}
+		if _, err := fmt.Fprintf(w, "  params: %s\n", strings.Join(parts, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package analysis_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestDetectAnomalies(t *testing.T) {
+	var history []tracer.TraceRecord
+	for i := 0; i < 20; i++ {
+		// resize takes 9-11ms; ping always takes 1ms.
+		history = append(history,
+			tracer.TraceRecord{FunctionName: "resize", Duration: time.Duration(9+i%3) * time.Millisecond},
+			tracer.TraceRecord{FunctionName: "ping", Duration: time.Millisecond},
+		)
+	}
+	baseline := analysis.BuildBaseline(history)
+	if got := baseline["resize"]; got.Count != 20 || got.Median != 10*time.Millisecond || got.MAD != time.Millisecond {
+		t.Fatalf("Unexpected baseline: %+v", got)
+	}
+
+	current := []tracer.TraceRecord{
+		{UniqueID: 1, FunctionName: "resize", Duration: 10 * time.Millisecond, Params: map[string]string{"w": "100"}},
+		{UniqueID: 2, FunctionName: "resize", Duration: 80 * time.Millisecond, Params: map[string]string{"w": "100000", "h": "1"}},
+		{UniqueID: 3, FunctionName: "resize", Duration: 20 * time.Millisecond},
+		{UniqueID: 4, FunctionName: "ping", Duration: time.Second},
+		{UniqueID: 5, FunctionName: "unknown", Duration: time.Hour},
+	}
+	anomalies := analysis.DetectAnomalies(baseline, current, analysis.AnomalyOptions{Threshold: 3.5, MinSamples: 10})
+	if len(anomalies) != 2 || anomalies[0].Record.UniqueID != 2 || anomalies[1].Record.UniqueID != 3 {
+		t.Fatalf("Expected calls 2 and 3 flagged, highest score first; got %+v", anomalies)
+	}
+
+	var buf bytes.Buffer
+	if err := analysis.WriteAnomalies(&buf, anomalies); err != nil {
+		t.Fatalf("WriteAnomalies returned error: %v", err)
+	}
+	if out := buf.String(); !strings.Contains(out, "resize (ID 2): 80ms, 8.0x the median 10ms") || !strings.Contains(out, "params: h=1 w=100000") {
+		t.Errorf("Unexpected report:\n%s", out)
+	}
+}
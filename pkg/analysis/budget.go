@@ -0,0 +1,159 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// BudgetResult is the usage of the packages of one budget in a run.
+type BudgetResult struct {
+	Budget    config.BudgetConfig `json:"budget"`
+	Packages  []string            `json:"packages"`  // The package directories matched, in inventory order.
+	Calls     int                 `json:"calls"`     // Recorded calls of the packages' functions.
+	Self      time.Duration       `json:"self"`      // Time spent in the packages' functions, excluding the calls they made.
+	Total     time.Duration       `json:"total"`     // Self time of every call of the run.
+	TimeShare float64             `json:"timeShare"` // Self as a share of Total, in percent.
+	Alloc     uint64              `json:"alloc"`     // Heap growth during the calls, excluding the calls they made, in bytes.
+	// Exceeded names the limits of the budget the run exceeded: "time share" and "allocations".
+	Exceeded []string `json:"exceeded,omitempty"`
+}
+
+// PackageDir returns the package directory of a function of the inventory, relative to the
+// project root, e.g. "internal/cache", or "." for the root package.
+func PackageDir(fn instrument.Function) string {
+	return path.Dir(fn.File)
+}
+
+// MatchPackage reports whether a package directory matches a budget's package pattern. A pattern
+// ending in "/..." matches the directory and its subdirectories, as in go list, and "..." alone
+// every package; other patterns are globs on the directory.
+//
+// Parameters:
+//   - pattern (string): the pattern, e.g. "internal/shared/...".
+//   - dir (string): the directory, from PackageDir.
+//
+// Returns:
+//   - bool: true if the pattern matches.
+func MatchPackage(pattern, dir string) bool {
+	pattern = strings.TrimPrefix(pattern, "./")
+	if pattern == "..." {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/..."); ok {
+		return dir == prefix || strings.HasPrefix(dir, prefix+"/")
+	}
+	matched, _ := path.Match(pattern, dir)
+	return matched
+}
+
+// Budgets evaluates per-package performance budgets on a run. The time of each call is its self
+// time, its duration less that of the recorded calls it made, so a package is not charged for the
+// time it spends in other packages, and the shares of all packages add up to 100%. Allocations
+// are counted the same way from the records' MemDiff. Calls are assigned to packages by function
+// name through the inventory; calls of names it does not hold count towards the total only.
+//
+// Parameters:
+//   - budgets ([]config.BudgetConfig): the budgets, from the budgets key of the configuration.
+//   - inv (instrument.Inventory): the inventory written during instrumentation.
+//   - records ([]tracer.TraceRecord): the trace records of the run.
+//
+// Returns:
+//   - []BudgetResult: one result per budget, in configuration order.
+func Budgets(budgets []config.BudgetConfig, inv instrument.Inventory, records []tracer.TraceRecord) []BudgetResult {
+	dirs := make(map[string]string, len(inv.Functions))
+	for _, fn := range inv.Functions {
+		if _, ok := dirs[fn.Name]; !ok {
+			dirs[fn.Name] = PackageDir(fn)
+		}
+	}
+	childTime := make(map[int64]time.Duration)
+	childAlloc := make(map[int64]uint64)
+	for _, rec := range records {
+		if rec.CallerID != 0 {
+			childTime[rec.CallerID] += rec.Duration
+			childAlloc[rec.CallerID] += rec.MemDiff
+		}
+	}
+	results := make([]BudgetResult, len(budgets))
+	for i, b := range budgets {
+		results[i].Budget = b
+		seen := make(map[string]bool)
+		for _, fn := range inv.Functions {
+			if dir := PackageDir(fn); !seen[dir] && MatchPackage(b.Package, dir) {
+				seen[dir] = true
+				results[i].Packages = append(results[i].Packages, dir)
+			}
+		}
+	}
+	for _, rec := range records {
+		self := rec.Duration - childTime[rec.UniqueID]
+		if self < 0 {
+			self = 0
+		}
+		var alloc uint64
+		if rec.MemDiff > childAlloc[rec.UniqueID] {
+			alloc = rec.MemDiff - childAlloc[rec.UniqueID]
+		}
+		dir, known := dirs[rec.FunctionName]
+		for i := range results {
+			results[i].Total += self
+			if known && MatchPackage(results[i].Budget.Package, dir) {
+				results[i].Calls++
+				results[i].Self += self
+				results[i].Alloc += alloc
+			}
+		}
+	}
+	for i := range results {
+		r := &results[i]
+		if r.Total > 0 {
+			r.TimeShare = 100 * float64(r.Self) / float64(r.Total)
+		}
+		if r.Budget.MaxTimeShare > 0 && r.TimeShare > r.Budget.MaxTimeShare {
+			r.Exceeded = append(r.Exceeded, "time share")
+		}
+		if r.Budget.MaxAllocBytes > 0 && r.Alloc > r.Budget.MaxAllocBytes {
+			r.Exceeded = append(r.Exceeded, "allocations")
+		}
+	}
+	return results
+}
+
+// WriteBudgets prints the budget results as a table, with each limit next to the usage it caps
+// and "-" for limits not set.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - results ([]BudgetResult): the results from Budgets.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteBudgets(w io.Writer, results []BudgetResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tCALLS\tTIME SHARE\tLIMIT\tALLOCATED\tLIMIT\tSTATUS")
+	for _, r := range results {
+		shareLimit, allocLimit := "-", "-"
+		if r.Budget.MaxTimeShare > 0 {
+			shareLimit = fmt.Sprintf("%.1f%%", r.Budget.MaxTimeShare)
+		}
+		if r.Budget.MaxAllocBytes > 0 {
+			allocLimit = fmt.Sprintf("%d B", r.Budget.MaxAllocBytes)
+		}
+		status := "ok"
+		if len(r.Exceeded) > 0 {
+			status = "over budget: " + strings.Join(r.Exceeded, ", ")
+		} else if len(r.Packages) == 0 {
+			status = "no matching package"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%s\t%d B\t%s\t%s\n", r.Budget.Package, r.Calls, r.TimeShare, shareLimit, r.Alloc, allocLimit, status)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,87 @@
+package analysis_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestBudgets(t *testing.T) {
+	ms := time.Millisecond
+	inv := instrument.Inventory{Functions: []instrument.Function{
+		{Name: "main", File: "main.go"},
+		{Name: "lookup", File: "internal/shared/cache/cache.go"},
+		{Name: "encode", File: "internal/shared/codec.go"},
+		{Name: "render", File: "internal/web/render.go"},
+	}}
+	// main (100ms) calls lookup (30ms), which calls encode (10ms), and render (40ms).
+	records := []tracer.TraceRecord{
+		{UniqueID: 1, FunctionName: "main", Duration: 100 * ms, MemDiff: 1000},
+		{UniqueID: 2, CallerID: 1, FunctionName: "lookup", Duration: 30 * ms, MemDiff: 600},
+		{UniqueID: 3, CallerID: 2, FunctionName: "encode", Duration: 10 * ms, MemDiff: 400},
+		{UniqueID: 4, CallerID: 1, FunctionName: "render", Duration: 40 * ms, MemDiff: 100},
+	}
+	budgets := []config.BudgetConfig{
+		{Package: "internal/shared/...", MaxTimeShare: 25, MaxAllocBytes: 500},
+		{Package: "internal/web", MaxTimeShare: 50},
+		{Package: "vendor/..."},
+	}
+	results := analysis.Budgets(budgets, inv, records)
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %+v", results)
+	}
+	shared := results[0]
+	if shared.Calls != 2 || shared.Self != 30*ms || shared.Total != 100*ms || shared.Alloc != 600 {
+		t.Errorf("Unexpected usage of internal/shared/...: %+v", shared)
+	}
+	if len(shared.Packages) != 2 || shared.Packages[0] != "internal/shared/cache" || shared.Packages[1] != "internal/shared" {
+		t.Errorf("Unexpected packages of internal/shared/...: %v", shared.Packages)
+	}
+	if got := strings.Join(shared.Exceeded, ","); got != "time share,allocations" {
+		t.Errorf("Expected both limits exceeded, got %q", got)
+	}
+	if web := results[1]; web.TimeShare != 40 || len(web.Exceeded) != 0 {
+		t.Errorf("Unexpected result of internal/web: %+v", web)
+	}
+	if len(results[2].Packages) != 0 || results[2].Calls != 0 {
+		t.Errorf("Expected no usage of vendor/..., got %+v", results[2])
+	}
+
+	var buf bytes.Buffer
+	if err := analysis.WriteBudgets(&buf, results); err != nil {
+		t.Fatalf("WriteBudgets returned error: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"over budget: time share, allocations", "40.0%", "no matching package"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in the table:\n%s", want, out)
+		}
+	}
+}
+
+func TestMatchPackage(t *testing.T) {
+	cases := []struct {
+		pattern, dir string
+		want         bool
+	}{
+		{"internal/shared/...", "internal/shared", true},
+		{"internal/shared/...", "internal/shared/cache", true},
+		{"internal/shared/...", "internal/sharedx", false},
+		{"./internal/web", "internal/web", true},
+		{"internal/*", "internal/web", true},
+		{"internal/*", "internal/web/api", false},
+		{".", ".", true},
+		{"...", "cmd/app", true},
+	}
+	for _, c := range cases {
+		if got := analysis.MatchPackage(c.pattern, c.dir); got != c.want {
+			t.Errorf("MatchPackage(%q, %q) = %v, want %v", c.pattern, c.dir, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// Caller names used for calls whose caller is not a record in the trace.
+const (
+	RootCaller       = "(root)"       // The call had no traced caller.
+	UnrecordedCaller = "(unrecorded)" // The caller was traced but its record is not in the trace, e.g. main.
+)
+
+// Neighbor summarizes the calls along one caller/callee edge of a function.
+type Neighbor struct {
+	Function string        `json:"function"` // The caller or callee.
+	Calls    int           `json:"calls"`    // Number of calls along the edge.
+	Total    time.Duration `json:"total"`    // Total duration of those calls.
+	P50      time.Duration `json:"p50"`      // Median duration.
+	P90      time.Duration `json:"p90"`      // 90th percentile duration.
+	Max      time.Duration `json:"max"`      // Longest duration.
+}
+
+// Mean returns the average duration of the calls.
+func (n Neighbor) Mean() time.Duration {
+	if n.Calls == 0 {
+		return 0
+	}
+	return n.Total / time.Duration(n.Calls)
+}
+
+// Callers groups the calls to function by the function that made them. The durations summarized
+// are those of the calls to function.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records, e.g. from tracer.ReadTraceFile.
+//   - function (string): the function name.
+//
+// Returns:
+//   - []Neighbor: one entry per caller, most calls first.
+func Callers(records []tracer.TraceRecord, function string) []Neighbor {
+	names := make(map[int64]string, len(records))
+	for _, rec := range records {
+		names[rec.UniqueID] = rec.FunctionName
+	}
+	durations := make(map[string][]time.Duration)
+	for _, rec := range records {
+		if rec.FunctionName != function {
+			continue
+		}
+		caller := RootCaller
+		if rec.CallerID != 0 {
+			caller = UnrecordedCaller
+			if name, ok := names[rec.CallerID]; ok {
+				caller = name
+			}
+		}
+		durations[caller] = append(durations[caller], rec.Duration)
+	}
+	return neighbors(durations)
+}
+
+// EntryStack is one distinct stack the calls of a function were made from.
+type EntryStack struct {
+	Frames []tracer.StackFrame `json:"frames"` // Innermost first.
+	Calls  int                 `json:"calls"`
+}
+
+// EntryStacks groups the calls to function by the stack they were made from, recorded for the
+// functions in tracing.entryStacks. Unlike Callers, the stacks name callers that are not
+// instrumented.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records, e.g. from tracer.ReadTraceFile.
+//   - function (string): the function name.
+//
+// Returns:
+//   - []EntryStack: one entry per distinct stack, most calls first, empty if no call recorded one.
+func EntryStacks(records []tracer.TraceRecord, function string) []EntryStack {
+	byStack := make(map[string]*EntryStack)
+	var keys []string
+	for _, rec := range records {
+		if rec.FunctionName != function || len(rec.EntryStack) == 0 {
+			continue
+		}
+		key := fmt.Sprint(rec.EntryStack)
+		stack, ok := byStack[key]
+		if !ok {
+			stack = &EntryStack{Frames: rec.EntryStack}
+			byStack[key] = stack
+			keys = append(keys, key)
+		}
+		stack.Calls++
+	}
+	stacks := make([]EntryStack, 0, len(keys))
+	for _, key := range keys {
+		stacks = append(stacks, *byStack[key])
+	}
+	sort.SliceStable(stacks, func(i, j int) bool { return stacks[i].Calls > stacks[j].Calls })
+	return stacks
+}
+
+// WriteEntryStacks prints each stack with its call count, one frame per line.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - stacks ([]EntryStack): the result of EntryStacks.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteEntryStacks(w io.Writer, stacks []EntryStack) error {
+	for i, stack := range stacks {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if _, err := fmt.Fprintf(w, "%d calls from:\n", stack.Calls); err != nil {
+			return err
+		}
+		for _, frame := range stack.Frames {
+			if _, err := fmt.Fprintf(w, "  %s\n", frame); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Callees groups the direct calls made by function by the function called. The durations
+// summarized are those of the callees.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records, e.g. from tracer.ReadTraceFile.
+//   - function (string): the function name.
+//
+// Returns:
+//   - []Neighbor: one entry per callee, most calls first.
+func Callees(records []tracer.TraceRecord, function string) []Neighbor {
+	calls := make(map[int64]bool)
+	for _, rec := range records {
+		if rec.FunctionName == function {
+			calls[rec.UniqueID] = true
+		}
+	}
+	durations := make(map[string][]time.Duration)
+	for _, rec := range records {
+		if rec.CallerID != 0 && calls[rec.CallerID] {
+			durations[rec.FunctionName] = append(durations[rec.FunctionName], rec.Duration)
+		}
+	}
+	return neighbors(durations)
+}
+
+// neighbors summarizes the durations recorded per function, ordered by call count, most first,
+// then by name.
+func neighbors(durations map[string][]time.Duration) []Neighbor {
+	out := make([]Neighbor, 0, len(durations))
+	for name, ds := range durations {
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		n := Neighbor{Function: name, Calls: len(ds), P50: percentile(ds, 0.5), P90: percentile(ds, 0.9), Max: ds[len(ds)-1]}
+		for _, d := range ds {
+			n.Total += d
+		}
+		out = append(out, n)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Calls != out[j].Calls {
+			return out[i].Calls > out[j].Calls
+		}
+		return out[i].Function < out[j].Function
+	})
+	return out
+}
+
+// percentile returns the nearest-rank q-th percentile of the sorted durations ds.
+func percentile(ds []time.Duration, q float64) time.Duration {
+	rank := int(q*float64(len(ds))+0.5) - 1
+	return ds[max(0, min(rank, len(ds)-1))]
+}
+
+// WriteNeighbors prints the callers or callees of a function as a table.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - heading (string): the first column heading, e.g. "CALLER".
+//   - neighbors ([]Neighbor): the result of Callers or Callees.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteNeighbors(w io.Writer, heading string, neighbors []Neighbor) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tCALLS\tTOTAL\tMEAN\tP50\tP90\tMAX\n", heading)
+	for _, n := range neighbors {
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%v\t%v\t%v\t%v\n", n.Function, n.Calls, n.Total, n.Mean(), n.P50, n.P90, n.Max)
+	}
+	return tw.Flush()
+}
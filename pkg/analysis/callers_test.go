@@ -0,0 +1,81 @@
+package analysis_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func neighborRecords() []tracer.TraceRecord {
+	ms := time.Millisecond
+	return []tracer.TraceRecord{
+		{UniqueID: 1, FunctionName: "worker", CallerID: 99, Duration: 100 * ms},
+		{UniqueID: 2, FunctionName: "processJob", CallerID: 1, Duration: 10 * ms},
+		{UniqueID: 3, FunctionName: "decode", CallerID: 2, Duration: 2 * ms},
+		{UniqueID: 4, FunctionName: "processJob", CallerID: 1, Duration: 30 * ms},
+		{UniqueID: 5, FunctionName: "decode", CallerID: 4, Duration: 4 * ms},
+		{UniqueID: 6, FunctionName: "save", CallerID: 4, Duration: 20 * ms},
+		{UniqueID: 7, FunctionName: "processJob", Duration: 20 * ms},
+	}
+}
+
+func TestCallers(t *testing.T) {
+	callers := analysis.Callers(neighborRecords(), "processJob")
+	if len(callers) != 2 {
+		t.Fatalf("Expected two callers, got %+v", callers)
+	}
+	w := callers[0]
+	if w.Function != "worker" || w.Calls != 2 || w.Total != 40*time.Millisecond || w.Mean() != 20*time.Millisecond || w.Max != 30*time.Millisecond {
+		t.Errorf("Unexpected worker entry: %+v", w)
+	}
+	if callers[1].Function != analysis.RootCaller || callers[1].Calls != 1 {
+		t.Errorf("Expected the root call second, got %+v", callers[1])
+	}
+	if up := analysis.Callers(neighborRecords(), "worker"); len(up) != 1 || up[0].Function != analysis.UnrecordedCaller {
+		t.Errorf("Expected an unrecorded caller for worker, got %+v", up)
+	}
+}
+
+func TestCallees(t *testing.T) {
+	callees := analysis.Callees(neighborRecords(), "processJob")
+	if len(callees) != 2 || callees[0].Function != "decode" || callees[1].Function != "save" {
+		t.Fatalf("Expected decode then save, got %+v", callees)
+	}
+	if d := callees[0]; d.Calls != 2 || d.P50 != 2*time.Millisecond || d.P90 != 4*time.Millisecond {
+		t.Errorf("Unexpected decode entry: %+v", d)
+	}
+	var buf bytes.Buffer
+	if err := analysis.WriteNeighbors(&buf, "CALLEE", callees); err != nil {
+		t.Fatalf("WriteNeighbors returned error: %v", err)
+	}
+	if out := buf.String(); !strings.HasPrefix(out, "CALLEE") || !strings.Contains(out, "save") {
+		t.Errorf("Unexpected table:\n%s", out)
+	}
+}
+
+func TestEntryStacks(t *testing.T) {
+	fromCron := []tracer.StackFrame{{Function: "main.runCron", File: "/src/cron.go", Line: 12}, {Function: "main.main", File: "/src/main.go", Line: 5}}
+	fromHTTP := []tracer.StackFrame{{Function: "net/http.HandlerFunc.ServeHTTP", File: "/go/src/net/http/server.go", Line: 2220}}
+	records := []tracer.TraceRecord{
+		{FunctionName: "migrate", EntryStack: fromHTTP},
+		{FunctionName: "migrate", EntryStack: fromCron},
+		{FunctionName: "migrate", EntryStack: fromCron},
+		{FunctionName: "migrate"},
+		{FunctionName: "other", EntryStack: fromHTTP},
+	}
+	stacks := analysis.EntryStacks(records, "migrate")
+	if len(stacks) != 2 || stacks[0].Calls != 2 || stacks[0].Frames[0].Function != "main.runCron" || stacks[1].Calls != 1 {
+		t.Fatalf("Expected the cron stack twice, then the HTTP stack, got %+v", stacks)
+	}
+	var buf bytes.Buffer
+	if err := analysis.WriteEntryStacks(&buf, stacks); err != nil {
+		t.Fatalf("WriteEntryStacks returned error: %v", err)
+	}
+	if out := buf.String(); !strings.HasPrefix(out, "2 calls from:\n  main.runCron (/src/cron.go:12)\n  main.main (/src/main.go:5)\n") {
+		t.Errorf("Unexpected report:\n%s", out)
+	}
+}
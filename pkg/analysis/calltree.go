@@ -0,0 +1,339 @@
+package analysis
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/theme"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// CallStats are the calls of one call tree node in one trace.
+type CallStats struct {
+	Calls int           `json:"calls"`
+	Total time.Duration `json:"total"`
+}
+
+// CallNode is a node of the call trees of two traces merged: a function reached through the same
+// chain of callers, with its calls in each trace.
+type CallNode struct {
+	Name     string      `json:"name"`
+	Base     CallStats   `json:"base"`
+	Current  CallStats   `json:"current"`
+	Children []*CallNode `json:"children,omitempty"` // Largest first.
+
+	byName map[string]*CallNode
+}
+
+// Delta returns the change of the node's total time from the base trace to the current one.
+func (n *CallNode) Delta() time.Duration {
+	return n.Current.Total - n.Base.Total
+}
+
+// Change returns Delta in percent of the base trace's total time, or 0 for a node new in the
+// current trace.
+func (n *CallNode) Change() float64 {
+	if n.Base.Total == 0 {
+		return 0
+	}
+	return 100 * float64(n.Delta()) / float64(n.Base.Total)
+}
+
+// child returns the child of n called name, adding it if needed.
+func (n *CallNode) child(name string) *CallNode {
+	if c, ok := n.byName[name]; ok {
+		return c
+	}
+	if n.byName == nil {
+		n.byName = make(map[string]*CallNode)
+	}
+	c := &CallNode{Name: name}
+	n.byName[name] = c
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// CompareCallTrees merges the call trees of two traces, so the time of every call path can be
+// compared. Calls whose caller is not in their trace are placed at the top of the tree.
+//
+// Parameters:
+//   - base ([]tracer.TraceRecord): the records of the trace compared against.
+//   - current ([]tracer.TraceRecord): the records of the later trace.
+//
+// Returns:
+//   - *CallNode: the root, named "all", whose stats are those of the top-level calls.
+func CompareCallTrees(base, current []tracer.TraceRecord) *CallNode {
+	root := &CallNode{Name: "all"}
+	addCallTree(root, base, func(n *CallNode) *CallStats { return &n.Base })
+	addCallTree(root, current, func(n *CallNode) *CallStats { return &n.Current })
+	var sortTree func(n *CallNode)
+	sortTree = func(n *CallNode) {
+		sort.SliceStable(n.Children, func(i, j int) bool {
+			a, b := n.Children[i], n.Children[j]
+			if sa, sb := max(a.Base.Total, a.Current.Total), max(b.Base.Total, b.Current.Total); sa != sb {
+				return sa > sb
+			}
+			return a.Name < b.Name
+		})
+		for _, c := range n.Children {
+			sortTree(c)
+		}
+	}
+	sortTree(root)
+	return root
+}
+
+// addCallTree adds the calls of records to the tree under root, to the stats side selects.
+func addCallTree(root *CallNode, records []tracer.TraceRecord, side func(*CallNode) *CallStats) {
+	byID := make(map[int64]*tracer.TraceRecord, len(records))
+	for i := range records {
+		byID[records[i].UniqueID] = &records[i]
+	}
+	nodes := make(map[int64]*CallNode, len(records))
+	var nodeOf func(rec *tracer.TraceRecord) *CallNode
+	nodeOf = func(rec *tracer.TraceRecord) *CallNode {
+		if n, ok := nodes[rec.UniqueID]; ok {
+			return n
+		}
+		parent := root
+		if caller, ok := byID[rec.CallerID]; ok && rec.CallerID != 0 && caller != rec {
+			parent = nodeOf(caller)
+		}
+		n := parent.child(rec.FunctionName)
+		nodes[rec.UniqueID] = n
+		return n
+	}
+	for i := range records {
+		rec := &records[i]
+		s := side(nodeOf(rec))
+		s.Calls++
+		s.Total += rec.Duration
+		if _, ok := byID[rec.CallerID]; !ok || rec.CallerID == 0 {
+			r := side(root)
+			r.Calls++
+			r.Total += rec.Duration
+		}
+	}
+}
+
+// comparisonBar is a frame of the overlaid flame graph.
+type comparisonBar struct {
+	Name      string
+	Top       int     // Pixels from the top of the graph.
+	Left      float64 // Percent of the graph's width.
+	Width     float64 // Percent of the graph's width: the larger of the node's two totals.
+	BaseWidth float64 // Percent of the bar's width taken by the base trace's total.
+	CurWidth  float64 // Percent of the bar's width taken by the current trace's total.
+	Class     string  // The highlight of the node's change.
+	Title     string
+}
+
+// comparisonRow is a row of the side-by-side call trees.
+type comparisonRow struct {
+	Name    string
+	Indent  float64 // In em.
+	Base    CallStats
+	Current CallStats
+	Change  string
+	Class   string
+}
+
+// barHeight is the height of a frame of the flame graph, in pixels.
+const barHeight = 18
+
+// minFrameShare is the smallest share of the root's time, in percent, of the frames drawn and
+// the rows listed; smaller call paths are left out to keep the page small.
+const minFrameShare = 0.2
+
+// changeClass returns the CSS class highlighting a node's change: "new", "gone", or that of
+// changeLevel.
+func changeClass(n *CallNode) string {
+	switch {
+	case n.Base.Calls == 0 && n.Current.Calls > 0:
+		return "new"
+	case n.Current.Calls == 0 && n.Base.Calls > 0:
+		return "gone"
+	}
+	return changeLevel(n.Change())
+}
+
+// changeLevel returns the CSS class highlighting a change in percent: "slower" or "faster" with
+// a level of 1 to 3 for changes of at least 10%, 50% and 100%, or "" for a smaller change.
+func changeLevel(change float64) string {
+	level := 0
+	for _, threshold := range []float64{10, 50, 100} {
+		if math.Abs(change) >= threshold {
+			level++
+		}
+	}
+	if level == 0 {
+		return ""
+	}
+	if change > 0 {
+		return fmt.Sprintf("slower%d", level)
+	}
+	return fmt.Sprintf("faster%d", level)
+}
+
+// describeChange formats a node's change for its tooltip and row.
+func describeChange(n *CallNode) string {
+	switch changeClass(n) {
+	case "new":
+		return "new"
+	case "gone":
+		return "gone"
+	}
+	sign := "+"
+	if n.Delta() < 0 {
+		sign = "" // The duration has its own minus sign.
+	}
+	return fmt.Sprintf("%s%v (%+.1f%%)", sign, n.Delta(), n.Change())
+}
+
+// layoutComparison lays out the frames and rows of the nodes under root drawn at least
+// minFrameShare percent wide. A frame is as wide as the larger of its two totals, and its children
+// are narrowed in proportion when one grew and another shrank, so they fit under it.
+func layoutComparison(root *CallNode) ([]comparisonBar, []comparisonRow, int) {
+	if max(root.Base.Total, root.Current.Total) == 0 {
+		return nil, nil, 0
+	}
+	var bars []comparisonBar
+	var rows []comparisonRow
+	depth := 0
+	var visit func(n *CallNode, level int, left, width float64)
+	visit = func(n *CallNode, level int, left, width float64) {
+		if width < minFrameShare {
+			return
+		}
+		depth = max(depth, level+1)
+		size := max(n.Base.Total, n.Current.Total)
+		bar := comparisonBar{
+			Name:  n.Name,
+			Top:   level * barHeight,
+			Left:  left,
+			Width: width,
+			Class: changeClass(n),
+			Title: fmt.Sprintf("%s: %d calls, %v before; %d calls, %v after; %s", n.Name, n.Base.Calls, n.Base.Total, n.Current.Calls, n.Current.Total, describeChange(n)),
+		}
+		if size > 0 {
+			bar.BaseWidth = 100 * float64(n.Base.Total) / float64(size)
+			bar.CurWidth = 100 * float64(n.Current.Total) / float64(size)
+		}
+		bars = append(bars, bar)
+		rows = append(rows, comparisonRow{Name: n.Name, Indent: 1.2 * float64(level), Base: n.Base, Current: n.Current, Change: describeChange(n), Class: bar.Class})
+		var children time.Duration
+		for _, c := range n.Children {
+			children += max(c.Base.Total, c.Current.Total)
+		}
+		scale := width / float64(max(size, children))
+		for _, c := range n.Children {
+			w := scale * float64(max(c.Base.Total, c.Current.Total))
+			visit(c, level+1, left, w)
+			left += w
+		}
+	}
+	visit(root, 0, 0, 100)
+	return bars, rows, depth * barHeight
+}
+
+var comparisonTemplate = template.Must(template.New("comparison").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title>
+<style>
+{{.CSS}}
+body { font-family: sans-serif; margin: 2em; background: var(--background); color: var(--text); }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 0.8em; border-bottom: 1px solid var(--grid); text-align: left; white-space: nowrap; }
+td.n { text-align: right; font-variant-numeric: tabular-nums; }
+div.flame { position: relative; width: 100%; margin-bottom: 0.5em; }
+div.bar { position: absolute; height: 16px; overflow: hidden; box-sizing: border-box; border: 1px solid var(--background); font-size: 11px; line-height: 14px; }
+div.bar div.base { position: absolute; top: 0; left: 0; height: 100%; box-sizing: border-box; border: 1px dashed var(--muted); }
+div.bar div.cur { position: absolute; top: 0; left: 0; height: 100%; background: var(--idle); opacity: 0.85; }
+div.bar span { position: relative; padding-left: 3px; }
+.slower1 div.cur, tr.slower1 td, span.slower1 { background: var(--slower1); }
+.slower2 div.cur, tr.slower2 td, span.slower2 { background: var(--slower2); }
+.slower3 div.cur, tr.slower3 td, span.slower3 { background: var(--slower3); }
+.faster1 div.cur, tr.faster1 td, span.faster1 { background: var(--faster1); }
+.faster2 div.cur, tr.faster2 td, span.faster2 { background: var(--faster2); }
+.faster3 div.cur, tr.faster3 td, span.faster3 { background: var(--faster3); }
+.new div.cur, tr.new td, span.new { background: var(--new); }
+tr.gone td { color: var(--muted); }
+p.legend span { padding: 0 0.5em; margin-left: 0.3em; }
+</style></head><body>
+<h1>{{.Title}}</h1>
+<p>Comparing <b>{{.Current}}</b> with <b>{{.Base}}</b>.</p>
+{{if .Bars}}<h2>Flame graph</h2>
+<p class="legend">The width of a frame is the larger of its two total times; the dashed outline is {{.Base}}, the fill {{.Current}}.
+<span class="slower2">slower</span><span class="faster2">faster</span><span class="new">new</span></p>
+<div class="flame" style="height: {{.Height}}px">
+{{range .Bars}}<div class="bar {{.Class}}" style="top: {{.Top}}px; left: {{printf "%.3f" .Left}}%; width: {{printf "%.3f" .Width}}%" title="{{.Title}}"><div class="cur" style="width: {{printf "%.2f" .CurWidth}}%"></div><div class="base" style="width: {{printf "%.2f" .BaseWidth}}%"></div><span>{{.Name}}</span></div>
+{{end}}</div>
+<h2>Call trees</h2>
+<table>
+<tr><th>Call path</th><th>{{.Base}} calls</th><th>{{.Base}} total</th><th>{{.Current}} calls</th><th>{{.Current}} total</th><th>Change</th></tr>
+{{range .Rows}}<tr class="{{.Class}}"><td style="padding-left: {{printf "%.1f" .Indent}}em">{{.Name}}</td><td class="n">{{.Base.Calls}}</td><td class="n">{{.Base.Total}}</td><td class="n">{{.Current.Calls}}</td><td class="n">{{.Current.Total}}</td><td class="n">{{.Change}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Functions}}<h2>Functions</h2>
+<table>
+<tr><th>Function</th><th>{{.Base}} calls</th><th>Calls</th><th>{{.Base}} mean</th><th>Mean</th><th>Change</th><th>{{.Base}} p95</th><th>P95</th></tr>
+{{range .Functions}}<tr class="{{.Class}}"><td>{{.Name}}</td><td class="n">{{.BaseCalls}}</td><td class="n">{{.Calls}}</td><td class="n">{{.BaseMean}}</td><td class="n">{{.Mean}}</td><td class="n">{{.Change}}</td><td class="n">{{.BaseP95}}</td><td class="n">{{.P95}}</td></tr>
+{{end}}</table>{{end}}
+</body></html>
+`))
+
+// comparisonFunction is a row of the functions table of a comparison page.
+type comparisonFunction struct {
+	Name, BaseCalls, Calls, BaseMean, Mean, Change, BaseP95, P95, Class string
+}
+
+// WriteComparisonHTML writes a self-contained HTML page comparing two traces: an overlaid flame
+// graph and side-by-side call trees of tree, with the change of each call path highlighted, and
+// the per-function table of deltas. Either may be missing, e.g. the tree for a comparison with a
+// baseline, which keeps no call tree.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - title (string): the page title.
+//   - base (string): the label of the trace compared against, e.g. its file or baseline name.
+//   - current (string): the label of the later trace.
+//   - tree (*CallNode): the merged call trees from CompareCallTrees, or nil.
+//   - deltas ([]FunctionDelta): the per-function comparison from Compare, or nil.
+//   - palette (theme.Palette): the colors of the page.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteComparisonHTML(w io.Writer, title, base, current string, tree *CallNode, deltas []FunctionDelta, palette theme.Palette) error {
+	page := struct {
+		Title, Base, Current string
+		CSS                  template.CSS
+		Bars                 []comparisonBar
+		Rows                 []comparisonRow
+		Height               int
+		Functions            []comparisonFunction
+	}{Title: title, Base: base, Current: current, CSS: template.CSS(palette.CSS())}
+	if tree != nil {
+		page.Bars, page.Rows, page.Height = layoutComparison(tree)
+	}
+	for _, d := range deltas {
+		f := comparisonFunction{Name: d.Name, BaseCalls: "-", Calls: "-", BaseMean: "-", Mean: "-", BaseP95: "-", P95: "-"}
+		if d.Base != nil {
+			f.BaseCalls, f.BaseMean, f.BaseP95 = fmt.Sprint(d.Base.Calls), d.Base.Mean.String(), d.Base.P95.String()
+		}
+		if d.Current != nil {
+			f.Calls, f.Mean, f.P95 = fmt.Sprint(d.Current.Calls), d.Current.Mean.String(), d.Current.P95.String()
+		}
+		switch {
+		case d.Base == nil:
+			f.Change, f.Class = "new", "new"
+		case d.Current == nil:
+			f.Change, f.Class = "gone", "gone"
+		default:
+			f.Change, f.Class = fmt.Sprintf("%+.1f%%", d.MeanChange), changeLevel(d.MeanChange)
+		}
+		page.Functions = append(page.Functions, f)
+	}
+	return comparisonTemplate.Execute(w, page)
+}
@@ -0,0 +1,85 @@
+package analysis_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/theme"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestCompareCallTrees(t *testing.T) {
+	base := []tracer.TraceRecord{
+		{UniqueID: 1, FunctionName: "main.handle", Duration: 10 * time.Millisecond},
+		{UniqueID: 2, CallerID: 1, FunctionName: "main.query", Duration: 6 * time.Millisecond},
+		{UniqueID: 3, CallerID: 1, FunctionName: "main.render", Duration: 2 * time.Millisecond},
+		{UniqueID: 4, CallerID: 9, FunctionName: "main.orphan", Duration: time.Millisecond}, // Its caller is not in the trace.
+	}
+	current := []tracer.TraceRecord{
+		{UniqueID: 1, FunctionName: "main.handle", Duration: 20 * time.Millisecond},
+		{UniqueID: 2, CallerID: 1, FunctionName: "main.query", Duration: 15 * time.Millisecond},
+		{UniqueID: 3, CallerID: 1, FunctionName: "main.cache", Duration: time.Millisecond},
+		{UniqueID: 4, CallerID: 2, FunctionName: "main.render", Duration: 2 * time.Millisecond}, // Moved under main.query.
+	}
+
+	root := analysis.CompareCallTrees(base, current)
+	if root.Base.Total != 11*time.Millisecond || root.Current.Total != 20*time.Millisecond || len(root.Children) != 2 {
+		t.Fatalf("Unexpected root: %+v", root)
+	}
+	handle := root.Children[0]
+	if handle.Name != "main.handle" || handle.Delta() != 10*time.Millisecond || handle.Change() != 100 {
+		t.Fatalf("Expected main.handle first, 100%% slower, got %+v", handle)
+	}
+	if names := childNames(handle); names != "main.query main.render main.cache" {
+		t.Fatalf("Expected the children largest first, got %s", names)
+	}
+	query, render, cache := handle.Children[0], handle.Children[1], handle.Children[2]
+	if query.Base.Calls != 1 || query.Current.Calls != 1 || query.Change() != 150 {
+		t.Errorf("Unexpected main.query: %+v", query)
+	}
+	if render.Base.Calls != 1 || render.Current.Calls != 0 || cache.Base.Calls != 0 || cache.Change() != 0 {
+		t.Errorf("Expected main.render gone and main.cache new under main.handle, got %+v and %+v", render, cache)
+	}
+	if names := childNames(query); names != "main.render" || query.Children[0].Base.Calls != 0 {
+		t.Errorf("Expected main.render new under main.query, got %s", names)
+	}
+
+	var buf bytes.Buffer
+	deltas := analysis.Compare(analysis.Aggregate(base), analysis.Aggregate(current))
+	if err := analysis.WriteComparisonHTML(&buf, "v2 vs v1", "v1", "v2", root, deltas, theme.Default()); err != nil {
+		t.Fatalf("WriteComparisonHTML returned error: %v", err)
+	}
+	page := buf.String()
+	for _, want := range []string{
+		`<div class="bar slower3" style="top: 18px; left: 0.000%; width: 95.238%"`, // main.handle: 20ms of the root's 21ms.
+		`title="main.query: 1 calls, 6ms before; 1 calls, 15ms after; &#43;9ms (&#43;150.0%)"`,
+		`<tr class="gone"><td style="padding-left: 2.4em">main.render</td>`,
+		`<tr class="new"><td>main.cache</td>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected the page to contain %s:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "ZgotmplZ") {
+		t.Errorf("Expected no value to be filtered from the page:\n%s", page)
+	}
+
+	buf.Reset()
+	if err := analysis.WriteComparisonHTML(&buf, "v2 vs baseline", "baseline", "v2", nil, deltas, theme.Default()); err != nil {
+		t.Fatalf("WriteComparisonHTML returned error: %v", err)
+	}
+	if strings.Contains(buf.String(), "Flame graph") || !strings.Contains(buf.String(), "<h2>Functions</h2>") {
+		t.Errorf("Expected only the functions table without a call tree:\n%s", buf.String())
+	}
+}
+
+func childNames(n *analysis.CallNode) string {
+	var names []string
+	for _, c := range n.Children {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, " ")
+}
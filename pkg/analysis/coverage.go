@@ -0,0 +1,135 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// FunctionCoverage is an instrumented function with the number of calls recorded for it.
+type FunctionCoverage struct {
+	instrument.Function
+	Calls int `json:"calls"`
+}
+
+// FileCoverage sums the coverage of the functions declared in one file. StatementsInExecuted is the
+// number of statements of the functions that were executed, whichever of them ran: the trace
+// records calls, not statements, so it is function coverage weighted by function size.
+type FileCoverage struct {
+	File                 string `json:"file"`
+	Functions            int    `json:"functions"`
+	FunctionsExecuted    int    `json:"functionsExecuted"`
+	Statements           int    `json:"statements"`
+	StatementsInExecuted int    `json:"statementsInExecuted"`
+}
+
+// CoverageReport compares the instrumented functions of a project with the calls of a run. A
+// function counts as executed when the trace holds a call of it. The report is per function: it
+// does not say which statements or branches of an executed function ran.
+type CoverageReport struct {
+	Functions []FunctionCoverage `json:"functions"` // In inventory order.
+	Files     []FileCoverage     `json:"files"`     // By file name.
+	Total     FileCoverage       `json:"total"`     // The project as a whole; File is empty.
+}
+
+// FunctionRate returns the share of functions executed.
+func (c FileCoverage) FunctionRate() float64 {
+	if c.Functions == 0 {
+		return 0
+	}
+	return float64(c.FunctionsExecuted) / float64(c.Functions)
+}
+
+// StatementsInExecutedRate returns the share of statements that belong to executed functions.
+func (c FileCoverage) StatementsInExecutedRate() float64 {
+	if c.Statements == 0 {
+		return 0
+	}
+	return float64(c.StatementsInExecuted) / float64(c.Statements)
+}
+
+// Coverage matches the calls in records to the functions in inv. Records carry function names
+// only, so functions of the same name, such as String methods of different types, are executed
+// together. Calls dropped by sampling are not in the trace, so sampled runs under-report.
+//
+// Parameters:
+//   - inv (instrument.Inventory): the inventory written during instrumentation.
+//   - records ([]tracer.TraceRecord): the trace records of the run.
+//
+// Returns:
+//   - CoverageReport: the report.
+func Coverage(inv instrument.Inventory, records []tracer.TraceRecord) CoverageReport {
+	calls := make(map[string]int)
+	for _, rec := range records {
+		calls[rec.FunctionName]++
+	}
+	var report CoverageReport
+	byFile := make(map[string]*FileCoverage)
+	for _, fn := range inv.Functions {
+		fc := FunctionCoverage{Function: fn, Calls: calls[fn.Name]}
+		report.Functions = append(report.Functions, fc)
+		file, ok := byFile[fn.File]
+		if !ok {
+			file = &FileCoverage{File: fn.File}
+			byFile[fn.File] = file
+		}
+		for _, c := range []*FileCoverage{file, &report.Total} {
+			c.Functions++
+			c.Statements += fn.Statements
+			if fc.Calls > 0 {
+				c.FunctionsExecuted++
+				c.StatementsInExecuted += fn.Statements
+			}
+		}
+	}
+	for _, file := range byFile {
+		report.Files = append(report.Files, *file)
+	}
+	sort.Slice(report.Files, func(i, j int) bool { return report.Files[i].File < report.Files[j].File })
+	return report
+}
+
+// WriteCoverage prints the per-file coverage table and the project total, followed by the
+// functions that were never executed if uncovered is set.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - report (CoverageReport): the report from Coverage.
+//   - uncovered (bool): whether to list the functions never executed.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteCoverage(w io.Writer, report CoverageReport, uncovered bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tFUNCTIONS\tCOVERED\tSTATEMENTS IN EXECUTED FUNCTIONS\tSHARE")
+	for _, c := range append(report.Files, report.Total) {
+		name := c.File
+		if name == "" {
+			name = "total"
+		}
+		fmt.Fprintf(tw, "%s\t%d/%d\t%.1f%%\t%d/%d\t%.1f%%\n", name, c.FunctionsExecuted, c.Functions, 100*c.FunctionRate(),
+			c.StatementsInExecuted, c.Statements, 100*c.StatementsInExecutedRate())
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if !uncovered || report.Total.FunctionsExecuted == report.Total.Functions {
+		return nil
+	}
+	if _, err := fmt.Fprintln(w, "\nNever executed:"); err != nil {
+		return err
+	}
+	for _, fn := range report.Functions {
+		if fn.Calls > 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "  %s:%d  %s (%d statements)\n", fn.File, fn.Line, fn.Name, fn.Statements); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package analysis_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestCoverage(t *testing.T) {
+	inv := instrument.Inventory{Functions: []instrument.Function{
+		{Name: "main", File: "main.go", Line: 5, Statements: 4},
+		{Name: "load", File: "main.go", Line: 12, Statements: 6},
+		{Name: "process", File: "worker/worker.go", Line: 3, Statements: 8},
+		{Name: "retry", File: "worker/worker.go", Line: 20, Statements: 2},
+	}}
+	records := []tracer.TraceRecord{
+		{FunctionName: "main"},
+		{FunctionName: "process"},
+		{FunctionName: "process"},
+		{FunctionName: "notInstrumented"},
+	}
+	report := analysis.Coverage(inv, records)
+	if report.Total.Functions != 4 || report.Total.FunctionsExecuted != 2 || report.Total.FunctionRate() != 0.5 {
+		t.Errorf("Unexpected function totals: %+v", report.Total)
+	}
+	if report.Total.Statements != 20 || report.Total.StatementsInExecuted != 12 || report.Total.StatementsInExecutedRate() != 0.6 {
+		t.Errorf("Unexpected statement totals: %+v", report.Total)
+	}
+	if len(report.Files) != 2 || report.Files[0].File != "main.go" || report.Files[1].StatementsInExecuted != 8 {
+		t.Errorf("Unexpected per-file coverage: %+v", report.Files)
+	}
+	if report.Functions[2].Calls != 2 {
+		t.Errorf("Expected two calls of process, got %+v", report.Functions[2])
+	}
+
+	var buf bytes.Buffer
+	if err := analysis.WriteCoverage(&buf, report, true); err != nil {
+		t.Fatalf("WriteCoverage returned error: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"STATEMENTS IN EXECUTED FUNCTIONS", "total", "2/4", "60.0%", "main.go:12  load (6 statements)", "worker/worker.go:20  retry"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in report:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "process (") {
+		t.Errorf("Executed function listed as never executed:\n%s", out)
+	}
+}
@@ -0,0 +1,91 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// FDLeak sums the file descriptors the calls of one function left open.
+type FDLeak struct {
+	Name    string `json:"name"`
+	Calls   int    `json:"calls"`
+	Leaking int    `json:"leaking"` // Calls that returned with more descriptors open than they started with.
+	Net     int    `json:"net"`     // The descriptors opened minus those closed, over all calls.
+	Max     int    `json:"max"`     // The most descriptors a single call left open.
+}
+
+// FDLeaks finds the functions whose calls leave file descriptors open, from the fdDelta of
+// records traced with tracing.trackFileDescriptors. A call is charged with its own change only:
+// the fdDelta of the direct calls it made and that returned before it did is subtracted, so a
+// leak shows up in the function that opened the descriptor rather than in all of its callers.
+// The descriptors are counted for the whole process at each call's entry and exit, so those opened
+// by other goroutines during a call are counted in it too, and with tracing.fileDescriptorFunctions
+// the leaks of callees that are not counted are charged to their callers. A function leaking in
+// most of its calls is more telling than a single large delta.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records of the run.
+//
+// Returns:
+//   - []FDLeak: the functions with a positive net change, the largest first, ties by name.
+func FDLeaks(records []tracer.TraceRecord) []FDLeak {
+	self := make(map[int64]int, len(records))
+	exits := make(map[int64]int64, len(records))
+	for _, rec := range records {
+		self[rec.UniqueID] = rec.FDDelta
+		exits[rec.UniqueID] = rec.ExitNanos
+	}
+	for _, rec := range records {
+		parentExit, ok := exits[rec.CallerID]
+		if ok && rec.CallerID != 0 && rec.ExitNanos <= parentExit {
+			self[rec.CallerID] -= rec.FDDelta
+		}
+	}
+	functions := newFunctionTable[FDLeak]()
+	newLeak := func(name string) *FDLeak { return &FDLeak{Name: name} }
+	for i := range records {
+		rec := &records[i]
+		l := functions.get(rec, newLeak)
+		delta := self[rec.UniqueID]
+		l.Calls++
+		l.Net += delta
+		if delta > 0 {
+			l.Leaking++
+			l.Max = max(l.Max, delta)
+		}
+	}
+	var leaks []FDLeak
+	for _, l := range functions.byName {
+		if l.Net > 0 {
+			leaks = append(leaks, *l)
+		}
+	}
+	sort.Slice(leaks, func(i, j int) bool {
+		if leaks[i].Net != leaks[j].Net {
+			return leaks[i].Net > leaks[j].Net
+		}
+		return leaks[i].Name < leaks[j].Name
+	})
+	return leaks
+}
+
+// WriteFDLeaks prints a leak report as a table.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - leaks ([]FDLeak): the report from FDLeaks.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteFDLeaks(w io.Writer, leaks []FDLeak) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FUNCTION\tCALLS\tLEAKING\tNET FDS\tMAX")
+	for _, l := range leaks {
+		fmt.Fprintf(tw, "%s\t%d\t%d (%.0f%%)\t%+d\t%d\n", l.Name, l.Calls, l.Leaking, 100*float64(l.Leaking)/float64(l.Calls), l.Net, l.Max)
+	}
+	return tw.Flush()
+}
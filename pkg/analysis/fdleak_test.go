@@ -0,0 +1,35 @@
+package analysis_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestFDLeaksChargesTheFunctionThatOpened(t *testing.T) {
+	records := []tracer.TraceRecord{
+		{UniqueID: 1, FunctionName: "serve", ExitNanos: 100, FDDelta: 2},
+		{UniqueID: 2, FunctionName: "openLog", CallerID: 1, ExitNanos: 10, FDDelta: 1},
+		{UniqueID: 3, FunctionName: "openLog", CallerID: 1, ExitNanos: 20, FDDelta: 1},
+		{UniqueID: 4, FunctionName: "readConfig", CallerID: 1, ExitNanos: 30, FDDelta: 0},
+		{UniqueID: 5, FunctionName: "serve", ExitNanos: 200, FDDelta: -1},
+	}
+	leaks := analysis.FDLeaks(records)
+	if len(leaks) != 1 {
+		t.Fatalf("Expected only openLog to leak, got %+v", leaks)
+	}
+	if l := leaks[0]; l.Name != "openLog" || l.Calls != 2 || l.Leaking != 2 || l.Net != 2 || l.Max != 1 {
+		t.Errorf("Unexpected leak %+v", l)
+	}
+
+	var buf bytes.Buffer
+	if err := analysis.WriteFDLeaks(&buf, leaks); err != nil {
+		t.Fatalf("WriteFDLeaks returned error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "openLog") || !strings.Contains(buf.String(), "2 (100%)") {
+		t.Errorf("Unexpected report:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,49 @@
+package analysis
+
+import "github.com/mwiater/tracewrap/pkg/tracer"
+
+// functionTable holds a value per function of the trace records it is given, e.g. the stats
+// of each function. A record with a function ID finds the value by its ID, without hashing its
+// name; its name is only compared with the one the ID was first seen with, so a span renamed
+// under a function ID is told apart. A record without an ID finds the value by name, and all
+// records of the same name share it.
+type functionTable[T any] struct {
+	byName map[string]*T
+	byID   map[int]idEntry[T]
+}
+
+// idEntry is the value of a function ID, with the name it was first seen with.
+type idEntry[T any] struct {
+	name  string
+	value *T
+}
+
+// newFunctionTable returns an empty functionTable.
+func newFunctionTable[T any]() *functionTable[T] {
+	return &functionTable[T]{byName: make(map[string]*T), byID: make(map[int]idEntry[T])}
+}
+
+// get returns the value of the function of rec.
+//
+// Parameters:
+//   - rec (*tracer.TraceRecord): the record, with its function name resolved.
+//   - newValue (func(name string) *T): creates the value of a function on its first record.
+//
+// Returns:
+//   - *T: the value.
+func (t *functionTable[T]) get(rec *tracer.TraceRecord, newValue func(name string) *T) *T {
+	if rec.FunctionID > 0 {
+		if e, ok := t.byID[rec.FunctionID]; ok && e.name == rec.FunctionName {
+			return e.value
+		}
+	}
+	v, ok := t.byName[rec.FunctionName]
+	if !ok {
+		v = newValue(rec.FunctionName)
+		t.byName[rec.FunctionName] = v
+	}
+	if _, ok := t.byID[rec.FunctionID]; !ok && rec.FunctionID > 0 {
+		t.byID[rec.FunctionID] = idEntry[T]{name: rec.FunctionName, value: v}
+	}
+	return v
+}
@@ -0,0 +1,330 @@
+package analysis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/theme"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// Groupings of Heatmaps.
+const (
+	HeatmapByFunction = "function" // A heatmap per function.
+	HeatmapByRoute    = "route"    // A heatmap per HTTP route, for calls with a route.
+)
+
+// defaultHeatmapColumns is the number of time buckets of a heatmap without HeatmapOptions.Bucket.
+const defaultHeatmapColumns = 60
+
+// maxHeatmapColumns limits the time buckets of a heatmap, as a narrow bucket over a long trace
+// would not fit any screen.
+const maxHeatmapColumns = 1000
+
+// heatmapShades are the cells of a terminal heatmap, from no calls to the most calls.
+var heatmapShades = []string{" ", "░", "▒", "▓", "█"}
+
+// HeatmapOptions configures Heatmaps.
+type HeatmapOptions struct {
+	By     string          // HeatmapByFunction or HeatmapByRoute; "" means HeatmapByFunction.
+	Bucket time.Duration   // The width of a time bucket; 0 splits the trace into about 60 buckets.
+	Bounds []time.Duration // The latency bucket upper bounds; nil means tracer.DefaultHistogramBuckets.
+	Top    int             // The maximum number of heatmaps, by call count; 0 for all.
+	Name   string          // Only the heatmap of this function or route, if set.
+}
+
+// Heatmap counts the calls of a function or route per time bucket and latency bucket. All heatmaps
+// of one call to Heatmaps share their time buckets, so they line up.
+type Heatmap struct {
+	Name   string          `json:"name"`
+	Calls  int             `json:"calls"`
+	Start  time.Time       `json:"start"`  // The start of the first time bucket, the entry of the first call of the trace.
+	Bucket time.Duration   `json:"bucket"` // The width of a time bucket.
+	Bounds []time.Duration `json:"bounds"` // The latency bucket upper bounds; a last row counts the slower calls.
+	Counts [][]int         `json:"counts"` // Counts[column][row] is the number of calls entered in the time bucket column.
+	Max    int             `json:"max"`    // The largest count of a cell.
+}
+
+// rowLabel returns the label of latency row i.
+func (h Heatmap) rowLabel(i int) string {
+	if i == len(h.Bounds) {
+		return "> " + h.Bounds[len(h.Bounds)-1].String()
+	}
+	return "≤ " + h.Bounds[i].String()
+}
+
+// shade returns the cell of count in a terminal heatmap, on a logarithmic scale so single calls
+// stay visible next to bursts.
+func (h Heatmap) shade(count int) int {
+	if count == 0 || h.Max == 0 {
+		return 0
+	}
+	level := int(math.Ceil(float64(len(heatmapShades)-1) * math.Log1p(float64(count)) / math.Log1p(float64(h.Max))))
+	return max(1, min(level, len(heatmapShades)-1))
+}
+
+// Heatmaps buckets the calls of a trace by the time they were entered and by their duration, per
+// function or per route. Vertical stripes in a heatmap are bursts of calls; slow cells recurring
+// at a fixed interval point at periodic work such as garbage collection, cache expiry or cron
+// jobs competing with the traced code.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records, e.g. from tracer.ReadTraceFile.
+//   - opts (HeatmapOptions): the grouping and buckets.
+//
+// Returns:
+//   - []Heatmap: one heatmap per function or route, most calls first, then by name.
+//   - error: an error for an unknown grouping, a negative bucket width or too many buckets.
+func Heatmaps(records []tracer.TraceRecord, opts HeatmapOptions) ([]Heatmap, error) {
+	if opts.By == "" {
+		opts.By = HeatmapByFunction
+	}
+	if opts.By != HeatmapByFunction && opts.By != HeatmapByRoute {
+		return nil, fmt.Errorf("unknown grouping %q; use %s or %s", opts.By, HeatmapByFunction, HeatmapByRoute)
+	}
+	if opts.Bucket < 0 {
+		return nil, fmt.Errorf("invalid bucket width %v", opts.Bucket)
+	}
+	bounds := opts.Bounds
+	if len(bounds) == 0 {
+		bounds = tracer.DefaultHistogramBuckets
+	}
+	bounds = append([]time.Duration(nil), bounds...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+
+	key := func(rec tracer.TraceRecord) string {
+		if opts.By == HeatmapByRoute {
+			return rec.Route
+		}
+		return rec.FunctionName
+	}
+	var start, end time.Time
+	for _, rec := range records {
+		if key(rec) == "" || rec.EntryTime.IsZero() {
+			continue
+		}
+		if start.IsZero() || rec.EntryTime.Before(start) {
+			start = rec.EntryTime
+		}
+		if rec.EntryTime.After(end) {
+			end = rec.EntryTime
+		}
+	}
+	if start.IsZero() {
+		return nil, nil
+	}
+	bucket := opts.Bucket
+	if bucket == 0 {
+		bucket = roundBucket(max(time.Millisecond, (end.Sub(start)+defaultHeatmapColumns-1)/defaultHeatmapColumns))
+	}
+	columns := int(end.Sub(start)/bucket) + 1
+	if columns > maxHeatmapColumns {
+		return nil, fmt.Errorf("a bucket of %v splits the trace into %d columns, more than %d; use a wider bucket", bucket, columns, maxHeatmapColumns)
+	}
+
+	byName := make(map[string]*Heatmap)
+	for _, rec := range records {
+		name := key(rec)
+		if name == "" || rec.EntryTime.IsZero() || opts.Name != "" && name != opts.Name {
+			continue
+		}
+		h, ok := byName[name]
+		if !ok {
+			h = &Heatmap{Name: name, Start: start, Bucket: bucket, Bounds: bounds, Counts: make([][]int, columns)}
+			for i := range h.Counts {
+				h.Counts[i] = make([]int, len(bounds)+1)
+			}
+			byName[name] = h
+		}
+		column := int(rec.EntryTime.Sub(start) / bucket)
+		row := sort.Search(len(bounds), func(i int) bool { return rec.Duration <= bounds[i] })
+		h.Counts[column][row]++
+		h.Max = max(h.Max, h.Counts[column][row])
+		h.Calls++
+	}
+	maps := make([]Heatmap, 0, len(byName))
+	for _, h := range byName {
+		maps = append(maps, *h)
+	}
+	sort.Slice(maps, func(i, j int) bool {
+		if maps[i].Calls != maps[j].Calls {
+			return maps[i].Calls > maps[j].Calls
+		}
+		return maps[i].Name < maps[j].Name
+	})
+	if opts.Top > 0 && len(maps) > opts.Top {
+		maps = maps[:opts.Top]
+	}
+	return maps, nil
+}
+
+// roundBucket rounds d up to 1, 2 or 5 times a power of ten, so the time axis reads 2s rather
+// than 1.999333334s.
+func roundBucket(d time.Duration) time.Duration {
+	for step := time.Duration(1); ; step *= 10 {
+		for _, m := range []time.Duration{1, 2, 5} {
+			if m*step >= d {
+				return m * step
+			}
+		}
+	}
+}
+
+// WriteHeatmaps draws heatmaps with Unicode blocks, the slowest latency bucket on top and time
+// running from left to right. Darker cells hold more calls, on a logarithmic scale.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - maps ([]Heatmap): the heatmaps from Heatmaps.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteHeatmaps(w io.Writer, maps []Heatmap) error {
+	var sb strings.Builder
+	for i, h := range maps {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "%s (%d calls, %v per column, darkest = %d calls)\n", h.Name, h.Calls, h.Bucket, h.Max)
+		width := 0
+		for row := 0; row <= len(h.Bounds); row++ {
+			width = max(width, len([]rune(h.rowLabel(row))))
+		}
+		for row := len(h.Bounds); row >= 0; row-- {
+			label := h.rowLabel(row)
+			fmt.Fprintf(&sb, "%s%s │", strings.Repeat(" ", width-len([]rune(label))), label)
+			for _, column := range h.Counts {
+				sb.WriteString(heatmapShades[h.shade(column[row])])
+			}
+			sb.WriteString("\n")
+		}
+		columns := len(h.Counts)
+		fmt.Fprintf(&sb, "%s └%s\n", strings.Repeat(" ", width), strings.Repeat("─", columns))
+		axisStart, axisEnd := "+0s", "+"+(time.Duration(columns)*h.Bucket).String()
+		gap := max(1, columns-len(axisStart)-len(axisEnd))
+		fmt.Fprintf(&sb, "%s  %s%s%s\n", strings.Repeat(" ", width), axisStart, strings.Repeat(" ", gap), axisEnd)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// heatmapCell is a cell of an HTML heatmap.
+type heatmapCell struct {
+	Count int
+	Color template.CSS // The heat color of the palette, more opaque with more calls.
+	Title string
+}
+
+// heatmapPage is a heatmap as rendered by WriteHeatmapsHTML.
+type heatmapPage struct {
+	Name   string
+	Calls  int
+	Bucket time.Duration
+	Rows   []heatmapRow
+	Start  string
+	End    string
+}
+
+type heatmapRow struct {
+	Label string
+	Cells []heatmapCell
+}
+
+var heatmapTemplate = template.Must(template.New("heatmap").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title>
+<style>
+{{.CSS}}
+body { font-family: sans-serif; margin: 2em; background: var(--background); color: var(--text); }
+table.heatmap { border-collapse: collapse; margin-bottom: 0.3em; }
+table.heatmap th { font-weight: normal; font-size: 0.8em; text-align: right; padding-right: 0.5em; white-space: nowrap; }
+table.heatmap td { width: 0.8em; height: 1.2em; padding: 0; border: 1px solid var(--grid); }
+p.axis { font-size: 0.8em; color: var(--muted); margin-top: 0; }
+</style></head><body>
+<h1>{{.Title}}</h1>
+{{range .Maps}}<h2>{{.Name}}</h2>
+<p>{{.Calls}} calls, {{.Bucket}} per column.</p>
+<table class="heatmap">
+{{range .Rows}}<tr><th>{{.Label}}</th>{{range .Cells}}<td{{if .Count}} style="background: {{.Color}}"{{end}} title="{{.Title}}"></td>{{end}}</tr>
+{{end}}</table>
+<p class="axis">{{.Start}} to {{.End}}</p>
+{{end}}</body></html>
+`))
+
+// WriteHeatmapsHTML writes heatmaps as a self-contained HTML page. The opacity of a cell grows
+// with the logarithm of its calls, and each cell's tooltip gives its time range, latency bucket
+// and calls.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - title (string): the page title, e.g. the trace file.
+//   - maps ([]Heatmap): the heatmaps from Heatmaps.
+//   - palette (theme.Palette): the colors of the page.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteHeatmapsHTML(w io.Writer, title string, maps []Heatmap, palette theme.Palette) error {
+	pages := make([]heatmapPage, 0, len(maps))
+	for _, h := range maps {
+		end := h.Start.Add(time.Duration(len(h.Counts)) * h.Bucket)
+		page := heatmapPage{
+			Name:   h.Name,
+			Calls:  h.Calls,
+			Bucket: h.Bucket,
+			Start:  h.Start.Format("15:04:05.000"),
+			End:    end.Format("15:04:05.000"),
+		}
+		for row := len(h.Bounds); row >= 0; row-- {
+			r := heatmapRow{Label: h.rowLabel(row)}
+			for i, column := range h.Counts {
+				from := h.Start.Add(time.Duration(i) * h.Bucket)
+				cell := heatmapCell{
+					Count: column[row],
+					Title: fmt.Sprintf("%s to %s, %s: %d calls", from.Format("15:04:05.000"), from.Add(h.Bucket).Format("15:04:05.000"), r.Label, column[row]),
+				}
+				if cell.Count > 0 {
+					cell.Color = template.CSS(theme.Tint(palette.Heat, 0.15+0.85*math.Log1p(float64(cell.Count))/math.Log1p(float64(h.Max))))
+				}
+				r.Cells = append(r.Cells, cell)
+			}
+			page.Rows = append(page.Rows, r)
+		}
+		pages = append(pages, page)
+	}
+	return heatmapTemplate.Execute(w, struct {
+		Title string
+		CSS   template.CSS
+		Maps  []heatmapPage
+	}{title, template.CSS(palette.CSS()), pages})
+}
+
+// heatmapPass is the built-in "heatmap" pass: the terminal heatmaps of the ten functions with
+// the most calls.
+type heatmapPass struct{}
+
+func (heatmapPass) Name() string { return "heatmap" }
+
+func (heatmapPass) Run(ctx context.Context, in PassInput) ([]Section, error) {
+	maps, err := Heatmaps(in.Records, HeatmapOptions{Top: 10})
+	if err != nil {
+		return nil, err
+	}
+	if len(maps) == 0 {
+		return []Section{{Title: "Latency Heatmaps", Text: "No records with entry times."}}, nil
+	}
+	var buf bytes.Buffer
+	if err := WriteHeatmaps(&buf, maps); err != nil {
+		return nil, err
+	}
+	return []Section{{Title: "Latency Heatmaps", Text: buf.String()}}, nil
+}
+
+func init() {
+	RegisterPass(heatmapPass{})
+}
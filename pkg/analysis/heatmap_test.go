@@ -0,0 +1,63 @@
+package analysis_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/theme"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestHeatmaps(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var records []tracer.TraceRecord
+	for i := 0; i < 10; i++ {
+		d := 2 * time.Millisecond
+		if i%5 == 4 {
+			d = 2 * time.Second // A periodic slowdown every five seconds.
+		}
+		records = append(records, tracer.TraceRecord{FunctionName: "poll", Route: "GET /poll", EntryTime: start.Add(time.Duration(i) * time.Second), Duration: d})
+	}
+	records = append(records, tracer.TraceRecord{FunctionName: "init", EntryTime: start, Duration: time.Millisecond})
+
+	maps, err := analysis.Heatmaps(records, analysis.HeatmapOptions{Bucket: time.Second})
+	if err != nil {
+		t.Fatalf("Heatmaps returned error: %v", err)
+	}
+	if len(maps) != 2 || maps[0].Name != "poll" || maps[0].Calls != 10 || len(maps[0].Counts) != 10 || len(maps[1].Counts) != 10 {
+		t.Fatalf("Expected aligned heatmaps of poll and init over ten seconds, got %+v", maps)
+	}
+	poll := maps[0]
+	// Rows follow tracer.DefaultHistogramBuckets: 2ms falls under 5ms, 2s under 5s.
+	if poll.Counts[0][2] != 1 || poll.Counts[4][8] != 1 || poll.Counts[4][2] != 0 {
+		t.Errorf("Unexpected cells: %v", poll.Counts)
+	}
+
+	var buf bytes.Buffer
+	if err := analysis.WriteHeatmaps(&buf, maps[:1]); err != nil {
+		t.Fatalf("WriteHeatmaps returned error: %v", err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	if !strings.HasPrefix(lines[0], "poll (10 calls") || !strings.HasSuffix(lines[2], "│    █    █") || !strings.HasSuffix(lines[8], "│████ ████ ") {
+		t.Errorf("Unexpected heatmap:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := analysis.WriteHeatmapsHTML(&buf, "trace", maps, theme.Default()); err != nil {
+		t.Fatalf("WriteHeatmapsHTML returned error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "<h2>poll</h2>") || !strings.Contains(buf.String(), "12:00:04.000 to 12:00:05.000, ≤ 5s: 1 calls") || !strings.Contains(buf.String(), `style="background: rgba(213, 94, 0, `) {
+		t.Errorf("Unexpected HTML heatmap:\n%s", buf.String())
+	}
+
+	byRoute, err := analysis.Heatmaps(records, analysis.HeatmapOptions{By: analysis.HeatmapByRoute})
+	if err != nil || len(byRoute) != 1 || byRoute[0].Name != "GET /poll" {
+		t.Errorf("Expected one heatmap for GET /poll, got %+v (%v)", byRoute, err)
+	}
+	if _, err := analysis.Heatmaps(records, analysis.HeatmapOptions{Bucket: time.Microsecond}); err == nil {
+		t.Error("Expected an error for a bucket that splits the trace into too many columns")
+	}
+}
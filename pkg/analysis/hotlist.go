@@ -0,0 +1,144 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/blame"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// Orders of Hotlist.
+const (
+	ByCalls = "calls" // Most calls first.
+	ByTime  = "time"  // Most total time first.
+)
+
+// HotFunction ranks one function in a hot list.
+type HotFunction struct {
+	Name     string        `json:"name"`
+	Calls    int           `json:"calls"`            // Calls counted by the tracer, or the recorded calls without counts.
+	Recorded int           `json:"recorded"`         // Calls with a record in the trace.
+	Total    time.Duration `json:"total"`            // Total duration of the recorded calls.
+	Commit   *blame.Commit `json:"commit,omitempty"` // The last change of the function, set by AnnotateHotlist.
+}
+
+// Mean returns the average duration of the recorded calls.
+func (h HotFunction) Mean() time.Duration {
+	if h.Recorded == 0 {
+		return 0
+	}
+	return h.Total / time.Duration(h.Recorded)
+}
+
+// Hotlist ranks the functions of a run by call count or total time. Call counts come from
+// frequency, the execFrequency of the run metadata, which also counts the calls sampling dropped;
+// functions missing from it are counted by their records.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records of the run.
+//   - frequency (map[string]int): the calls per function from tracer.RunMetadata, or nil.
+//   - by (string): ByCalls or ByTime.
+//   - top (int): the maximum number of functions returned, or 0 for all.
+//
+// Returns:
+//   - []HotFunction: the hottest functions first, ties by name.
+//   - error: an error for an unknown order.
+func Hotlist(records []tracer.TraceRecord, frequency map[string]int, by string, top int) ([]HotFunction, error) {
+	if by != ByCalls && by != ByTime {
+		return nil, fmt.Errorf("unknown order %q; use %s or %s", by, ByCalls, ByTime)
+	}
+	functions := newFunctionTable[HotFunction]()
+	newHot := func(name string) *HotFunction { return &HotFunction{Name: name} }
+	for i := range records {
+		h := functions.get(&records[i], newHot)
+		h.Recorded++
+		h.Total += records[i].Duration
+	}
+	for name, n := range frequency {
+		h, ok := functions.byName[name]
+		if !ok {
+			h = newHot(name)
+			functions.byName[name] = h
+		}
+		h.Calls = n
+	}
+	hot := make([]HotFunction, 0, len(functions.byName))
+	for _, h := range functions.byName {
+		if h.Calls < h.Recorded {
+			h.Calls = h.Recorded
+		}
+		hot = append(hot, *h)
+	}
+	sort.Slice(hot, func(i, j int) bool {
+		a, b := hot[i], hot[j]
+		if by == ByTime && a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		if a.Calls != b.Calls {
+			return a.Calls > b.Calls
+		}
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Name < b.Name
+	})
+	if top > 0 && len(hot) > top {
+		hot = hot[:top]
+	}
+	return hot, nil
+}
+
+// WriteHotlist prints a hot list as a table. An annotated list has the abbreviated hash, author
+// and date of each function's last commit, with a "+" after the hash when the function has
+// uncommitted changes.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - hot ([]HotFunction): the list from Hotlist.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteHotlist(w io.Writer, hot []HotFunction) error {
+	annotated := false
+	for _, h := range hot {
+		annotated = annotated || h.Commit != nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if annotated {
+		fmt.Fprintln(tw, "RANK\tFUNCTION\tCALLS\tRECORDED\tTOTAL\tMEAN\tCOMMIT\tAUTHOR\tDATE")
+	} else {
+		fmt.Fprintln(tw, "RANK\tFUNCTION\tCALLS\tRECORDED\tTOTAL\tMEAN")
+	}
+	for i, h := range hot {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%v\t%v", i+1, h.Name, h.Calls, h.Recorded, h.Total, h.Mean())
+		switch {
+		case h.Commit != nil:
+			hash := h.Commit.Short()
+			if h.Commit.Uncommitted {
+				hash += "+"
+			}
+			fmt.Fprintf(tw, "\t%s\t%s\t%s", hash, h.Commit.Author, h.Commit.Time.Format("2006-01-02"))
+		case annotated:
+			fmt.Fprint(tw, "\t-\t-\t-")
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// AnnotateHotlist sets the Commit of each function of a hot list that commits holds.
+//
+// Parameters:
+//   - hot ([]HotFunction): the list from Hotlist, annotated in place.
+//   - commits (map[string]blame.Commit): the commits by function name, from blame.ForFunctions.
+func AnnotateHotlist(hot []HotFunction, commits map[string]blame.Commit) {
+	for i := range hot {
+		if c, ok := commits[hot[i].Name]; ok {
+			hot[i].Commit = &c
+		}
+	}
+}
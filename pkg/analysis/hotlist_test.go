@@ -0,0 +1,62 @@
+package analysis_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/blame"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestHotlist(t *testing.T) {
+	ms := time.Millisecond
+	records := []tracer.TraceRecord{
+		{FunctionName: "parse", Duration: ms},
+		{FunctionName: "parse", Duration: ms},
+		{FunctionName: "parse", Duration: ms},
+		{FunctionName: "render", Duration: 50 * ms},
+		{FunctionName: "load", Duration: 20 * ms},
+	}
+	// Sampling kept only some of the calls of load in the trace.
+	frequency := map[string]int{"parse": 3, "render": 1, "load": 40}
+
+	byCalls, err := analysis.Hotlist(records, frequency, analysis.ByCalls, 2)
+	if err != nil {
+		t.Fatalf("Hotlist returned error: %v", err)
+	}
+	if len(byCalls) != 2 || byCalls[0].Name != "load" || byCalls[0].Calls != 40 || byCalls[0].Recorded != 1 || byCalls[1].Name != "parse" {
+		t.Errorf("Unexpected hot list by calls: %+v", byCalls)
+	}
+	byTime, err := analysis.Hotlist(records, nil, analysis.ByTime, 0)
+	if err != nil {
+		t.Fatalf("Hotlist returned error: %v", err)
+	}
+	if len(byTime) != 3 || byTime[0].Name != "render" || byTime[2].Name != "parse" || byTime[2].Calls != 3 || byTime[2].Mean() != ms {
+		t.Errorf("Unexpected hot list by time: %+v", byTime)
+	}
+	if _, err := analysis.Hotlist(records, nil, "memory", 0); err == nil {
+		t.Error("Expected an error for an unknown order")
+	}
+
+	var buf bytes.Buffer
+	if err := analysis.WriteHotlist(&buf, byCalls); err != nil {
+		t.Fatalf("WriteHotlist returned error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "1     load") || strings.Contains(buf.String(), "AUTHOR") {
+		t.Errorf("Unexpected table:\n%s", buf.String())
+	}
+
+	analysis.AnnotateHotlist(byCalls, map[string]blame.Commit{
+		"load": {Hash: "0123456789abcdef", Author: "alice", Time: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Uncommitted: true},
+	})
+	buf.Reset()
+	if err := analysis.WriteHotlist(&buf, byCalls); err != nil {
+		t.Fatalf("WriteHotlist returned error: %v", err)
+	}
+	if out := buf.String(); !strings.Contains(out, "01234567+  alice   2024-03-01") || !strings.Contains(out, "-          -       -") {
+		t.Errorf("Unexpected annotated table:\n%s", out)
+	}
+}
@@ -0,0 +1,195 @@
+package analysis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// FunctionOverhead is the time the tracer's hooks cost the recorded calls of one function.
+type FunctionOverhead struct {
+	Name     string        `json:"name"`
+	Calls    int           `json:"calls"`    // Recorded calls.
+	Total    time.Duration `json:"total"`    // Time spent in the hooks, over all calls.
+	PerCall  time.Duration `json:"perCall"`  // Mean time spent in the hooks per call.
+	P99      time.Duration `json:"p99"`      // 99th percentile of the time per call.
+	Max      time.Duration `json:"max"`      // Largest time of one call.
+	Duration time.Duration `json:"duration"` // Total duration of the calls, hooks included.
+}
+
+// Share returns the part of the calls' duration spent in the hooks, in percent.
+func (f FunctionOverhead) Share() float64 {
+	if f.Duration <= 0 {
+		return 0
+	}
+	return 100 * float64(f.Total) / float64(f.Duration)
+}
+
+// OverheadSummary is the tracer's per-call overhead in a trace.
+type OverheadSummary struct {
+	All       FunctionOverhead   `json:"all"`       // All recorded calls, named "(all)".
+	Functions []FunctionOverhead `json:"functions"` // The functions costing the most in total first, ties by name.
+	// Benchmarks are the costs per call the tracer's hot-path benchmarks measured, if given (see
+	// ParseBenchmarks). They include the injected code around the hooks, which tracerOverhead does
+	// not time.
+	Benchmarks []BenchmarkCost `json:"benchmarks,omitempty"`
+}
+
+// BenchmarkCost is the cost of one traced call measured by a benchmark of the tracer.
+type BenchmarkCost struct {
+	Name    string        `json:"name"` // The benchmark, e.g. "RecordEntryExit/goroutines=8".
+	PerCall time.Duration `json:"perCall"`
+}
+
+// benchmarkProcs matches the GOMAXPROCS suffix go test appends to the name of a benchmark.
+var benchmarkProcs = regexp.MustCompile(`-\d+$`)
+
+// ParseBenchmarks reads the results of "go test -bench" from r, such as those of the tracer's
+// benchmarks in pkg/tracer, and returns the cost per op of each: the cost of one traced call.
+// Lines that are not benchmark results are skipped.
+//
+// Parameters:
+//   - r (io.Reader): the output of go test.
+//
+// Returns:
+//   - []BenchmarkCost: the benchmarks, in the order of the output.
+//   - error: an error if r cannot be read or holds no benchmark results.
+func ParseBenchmarks(r io.Reader) ([]BenchmarkCost, error) {
+	var costs []BenchmarkCost
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// e.g. "BenchmarkRecordEntryExit/goroutines=8-16   1000000   1234 ns/op   0 B/op   0 allocs/op"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") || fields[3] != "ns/op" {
+			continue
+		}
+		ns, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			continue
+		}
+		name := benchmarkProcs.ReplaceAllString(strings.TrimPrefix(fields[0], "Benchmark"), "")
+		costs = append(costs, BenchmarkCost{Name: name, PerCall: time.Duration(ns)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(costs) == 0 {
+		return nil, fmt.Errorf("no benchmark results found")
+	}
+	return costs, nil
+}
+
+// OverheadReport measures the time the tracer's hooks cost per call, from the tracerOverhead of
+// the records. Records written before the tracer measured it count as calls without overhead.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records of the run.
+//   - top (int): the maximum number of functions listed, or 0 for all.
+//
+// Returns:
+//   - OverheadSummary: the overhead of all calls and of the costliest functions.
+func OverheadReport(records []tracer.TraceRecord, top int) OverheadSummary {
+	type function struct {
+		perCall  []time.Duration
+		duration time.Duration
+	}
+	functions := newFunctionTable[function]()
+	newFunction := func(string) *function { return &function{} }
+	var all []time.Duration
+	var total time.Duration
+	for i := range records {
+		rec := &records[i]
+		f := functions.get(rec, newFunction)
+		f.perCall = append(f.perCall, rec.TracerOverhead)
+		f.duration += rec.Duration
+		all = append(all, rec.TracerOverhead)
+		total += rec.Duration
+	}
+	summary := OverheadSummary{All: overheadOf("(all)", all, total)}
+	for name, f := range functions.byName {
+		summary.Functions = append(summary.Functions, overheadOf(name, f.perCall, f.duration))
+	}
+	sort.Slice(summary.Functions, func(i, j int) bool {
+		a, b := summary.Functions[i], summary.Functions[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Name < b.Name
+	})
+	if top > 0 && len(summary.Functions) > top {
+		summary.Functions = summary.Functions[:top]
+	}
+	return summary
+}
+
+// overheadOf summarizes the hook times ds of the calls of name, which took duration in total.
+func overheadOf(name string, ds []time.Duration, duration time.Duration) FunctionOverhead {
+	f := FunctionOverhead{Name: name, Calls: len(ds), Duration: duration}
+	if len(ds) == 0 {
+		return f
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	for _, d := range ds {
+		f.Total += d
+	}
+	f.PerCall = f.Total / time.Duration(len(ds))
+	f.P99 = percentile(ds, 0.99)
+	f.Max = ds[len(ds)-1]
+	return f
+}
+
+// WriteOverheadReport prints the overhead of all calls, then of each function, as a table, followed
+// by the cost per call of each benchmark of the summary.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - summary (OverheadSummary): the report from OverheadReport.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteOverheadReport(w io.Writer, summary OverheadSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FUNCTION\tCALLS\tPER CALL\tP99\tMAX\tTOTAL\tSHARE")
+	for _, f := range append([]FunctionOverhead{summary.All}, summary.Functions...) {
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%v\t%v\t%v\t%.1f%%\n", f.Name, f.Calls, f.PerCall, f.P99, f.Max, f.Total, f.Share())
+	}
+	if len(summary.Benchmarks) > 0 {
+		fmt.Fprintln(tw, "\nBENCHMARK\tPER CALL")
+		for _, b := range summary.Benchmarks {
+			fmt.Fprintf(tw, "%s\t%v\n", b.Name, b.PerCall)
+		}
+	}
+	return tw.Flush()
+}
+
+// overheadPass is the built-in "overhead" pass, the report of analyze overhead for the ten
+// costliest functions.
+type overheadPass struct{}
+
+func (overheadPass) Name() string { return "overhead" }
+
+func (overheadPass) Run(ctx context.Context, in PassInput) ([]Section, error) {
+	summary := OverheadReport(in.Records, 10)
+	if summary.All.Calls == 0 {
+		return []Section{{Title: "Tracer Overhead", Text: "No calls recorded."}}, nil
+	}
+	var buf bytes.Buffer
+	if err := WriteOverheadReport(&buf, summary); err != nil {
+		return nil, err
+	}
+	return []Section{{Title: "Tracer Overhead", Text: buf.String()}}, nil
+}
+
+func init() {
+	RegisterPass(overheadPass{})
+}
@@ -0,0 +1,84 @@
+package analysis_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestOverheadReport(t *testing.T) {
+	us := time.Microsecond
+	records := []tracer.TraceRecord{
+		{FunctionName: "parse", Duration: 100 * us, TracerOverhead: 2 * us},
+		{FunctionName: "parse", Duration: 100 * us, TracerOverhead: 4 * us},
+		{FunctionName: "handle", Duration: 1000 * us, TracerOverhead: 10 * us},
+		{FunctionName: "tiny", Duration: 2 * us, TracerOverhead: 1 * us},
+	}
+	summary := analysis.OverheadReport(records, 2)
+	if all := summary.All; all.Name != "(all)" || all.Calls != 4 || all.Total != 17*us || all.Max != 10*us || all.PerCall != 17*us/4 {
+		t.Errorf("Unexpected overall overhead: %+v", all)
+	}
+	if len(summary.Functions) != 2 {
+		t.Fatalf("Expected the two costliest functions, got %+v", summary.Functions)
+	}
+	if handle := summary.Functions[0]; handle.Name != "handle" || handle.Share() != 1 {
+		t.Errorf("Expected handle first at 1%%, got %+v", handle)
+	}
+	if parse := summary.Functions[1]; parse.Name != "parse" || parse.Calls != 2 || parse.PerCall != 3*us || parse.P99 != 4*us || parse.Share() != 3 {
+		t.Errorf("Unexpected parse overhead: %+v", parse)
+	}
+
+	var buf bytes.Buffer
+	if err := analysis.WriteOverheadReport(&buf, summary); err != nil {
+		t.Fatalf("WriteOverheadReport returned error: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"PER CALL", "(all)", "parse     2      3µs", "3.0%"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected report to contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "BENCHMARK") {
+		t.Errorf("Expected no benchmarks without results:\n%s", out)
+	}
+}
+
+func TestOverheadReportWithBenchmarks(t *testing.T) {
+	output := `goos: linux
+goarch: amd64
+pkg: github.com/mwiater/tracewrap/pkg/tracer
+BenchmarkRecordEntryExit/goroutines=1-16         	 1000000	      1520 ns/op	     312 B/op	       4 allocs/op
+BenchmarkRecordEntryExitLight/goroutines=64-16   	 3637826	     358.6 ns/op	       0 B/op	       0 allocs/op
+PASS
+ok  	github.com/mwiater/tracewrap/pkg/tracer	4.426s
+`
+	benchmarks, err := analysis.ParseBenchmarks(strings.NewReader(output))
+	if err != nil {
+		t.Fatalf("ParseBenchmarks returned error: %v", err)
+	}
+	want := []analysis.BenchmarkCost{
+		{Name: "RecordEntryExit/goroutines=1", PerCall: 1520 * time.Nanosecond},
+		{Name: "RecordEntryExitLight/goroutines=64", PerCall: 358 * time.Nanosecond},
+	}
+	if fmt.Sprint(benchmarks) != fmt.Sprint(want) {
+		t.Errorf("ParseBenchmarks = %+v, want %+v", benchmarks, want)
+	}
+	if _, err := analysis.ParseBenchmarks(strings.NewReader("PASS\n")); err == nil {
+		t.Error("Expected an error for output without benchmark results")
+	}
+
+	summary := analysis.OverheadReport([]tracer.TraceRecord{{FunctionName: "parse", Duration: time.Millisecond, TracerOverhead: time.Microsecond}}, 0)
+	summary.Benchmarks = benchmarks
+	var buf bytes.Buffer
+	if err := analysis.WriteOverheadReport(&buf, summary); err != nil {
+		t.Fatalf("WriteOverheadReport returned error: %v", err)
+	}
+	if out := buf.String(); !strings.Contains(out, "BENCHMARK") || !strings.Contains(out, "RecordEntryExit/goroutines=1") || !strings.Contains(out, "1.52µs") {
+		t.Errorf("Expected the benchmark costs in the report:\n%s", out)
+	}
+}
@@ -0,0 +1,87 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// PanicGroup gathers the calls of one function that panicked with the same value.
+type PanicGroup struct {
+	FunctionName string             `json:"functionName"`
+	Value        string             `json:"value"` // The panic value, formatted with %v.
+	Count        int                `json:"count"` // Number of calls.
+	First        tracer.TraceRecord `json:"first"` // The earliest of the calls, with its stack trace and parameters.
+}
+
+// Panics groups the panicked calls in records by function and panic value. Groups are ordered by
+// count, most first, then by function.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records.
+//
+// Returns:
+//   - []PanicGroup: the groups, empty if no call panicked.
+func Panics(records []tracer.TraceRecord) []PanicGroup {
+	type key struct{ function, value string }
+	byKey := make(map[key]*PanicGroup)
+	var groups []*PanicGroup
+	for _, rec := range records {
+		if rec.PanicValue == nil {
+			continue
+		}
+		k := key{rec.FunctionName, fmt.Sprint(rec.PanicValue)}
+		g, ok := byKey[k]
+		if !ok {
+			g = &PanicGroup{FunctionName: k.function, Value: k.value, First: rec}
+			byKey[k] = g
+			groups = append(groups, g)
+		}
+		g.Count++
+		if rec.EntryNanos < g.First.EntryNanos {
+			g.First = rec
+		}
+	}
+	result := make([]PanicGroup, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, *g)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].FunctionName < result[j].FunctionName
+	})
+	return result
+}
+
+// WritePanics prints groups with the stack trace of their first call.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - groups ([]PanicGroup): the report from Panics.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WritePanics(w io.Writer, groups []PanicGroup) error {
+	for i, g := range groups {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if _, err := fmt.Fprintf(w, "%s: panic %q, %d calls (first ID %d)\n", g.FunctionName, g.Value, g.Count, g.First.UniqueID); err != nil {
+			return err
+		}
+		for _, line := range strings.Split(strings.TrimRight(g.First.StackTrace, "\n"), "\n") {
+			if line == "" {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "  %s\n", line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
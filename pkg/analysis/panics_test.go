@@ -0,0 +1,37 @@
+package analysis_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestPanicsGroupsByFunctionAndValue(t *testing.T) {
+	records := []tracer.TraceRecord{
+		{UniqueID: 1, FunctionName: "divide", EntryNanos: 30, PanicValue: "divide by zero", StackTrace: "goroutine 1:\nmain.divide()\n"},
+		{UniqueID: 2, FunctionName: "divide", EntryNanos: 10, PanicValue: "divide by zero", StackTrace: "goroutine 7:\nmain.divide()\n"},
+		{UniqueID: 3, FunctionName: "parse", EntryNanos: 20, PanicValue: "bad input"},
+		{UniqueID: 4, FunctionName: "parse", EntryNanos: 40},
+	}
+	groups := analysis.Panics(records)
+	if len(groups) != 2 {
+		t.Fatalf("Expected two groups, got %+v", groups)
+	}
+	if g := groups[0]; g.FunctionName != "divide" || g.Count != 2 || g.First.UniqueID != 2 {
+		t.Errorf("Expected the two divide panics with ID 2 first, got %+v", g)
+	}
+	if g := groups[1]; g.FunctionName != "parse" || g.Value != "bad input" || g.Count != 1 {
+		t.Errorf("Unexpected second group %+v", g)
+	}
+
+	var buf bytes.Buffer
+	if err := analysis.WritePanics(&buf, groups[:1]); err != nil {
+		t.Fatalf("WritePanics returned error: %v", err)
+	}
+	want := "divide: panic \"divide by zero\", 2 calls (first ID 2)\n  goroutine 7:\n  main.divide()\n"
+	if buf.String() != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, buf.String())
+	}
+}
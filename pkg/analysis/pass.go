@@ -0,0 +1,248 @@
+package analysis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// PassProtocolVersion is the version of the JSON protocol spoken with external passes, sent in
+// PassInput.ProtocolVersion.
+const PassProtocolVersion = 1
+
+// PassPrefix is the prefix of the executables found on PATH for external passes: the pass
+// "mycompany-sla" runs "tracewrap-pass-mycompany-sla".
+const PassPrefix = "tracewrap-pass-"
+
+// PassInput is what a pass receives: the parsed trace and, when available, its run metadata.
+// External passes read it as one JSON document on standard input.
+type PassInput struct {
+	ProtocolVersion int                  `json:"protocolVersion"`
+	Trace           string               `json:"trace"`              // The path of the trace file.
+	Metadata        *tracer.RunMetadata  `json:"metadata,omitempty"` // Nil when there is no run.json next to the trace.
+	Records         []tracer.TraceRecord `json:"records"`
+}
+
+// Section is one part of a report contributed by a pass: free text, a table, or both. External
+// passes write a PassOutput holding their sections as JSON to standard output.
+type Section struct {
+	Title   string     `json:"title"`
+	Text    string     `json:"text,omitempty"`
+	Columns []string   `json:"columns,omitempty"`
+	Rows    [][]string `json:"rows,omitempty"`
+}
+
+// PassOutput is what an external pass writes to standard output.
+type PassOutput struct {
+	Sections []Section `json:"sections"`
+}
+
+// Pass is an analysis pass over a trace.
+type Pass interface {
+	// Name returns the name the pass is selected by with --pass.
+	Name() string
+	// Run analyzes the trace and returns the sections it contributes to the report.
+	Run(ctx context.Context, in PassInput) ([]Section, error)
+}
+
+// builtinPasses holds the passes shipped with tracewrap, by name.
+var builtinPasses = map[string]Pass{}
+
+// RegisterPass makes a pass available by name. Programs embedding the analysis package can register
+// their own passes; passes in other languages are better written as external passes.
+//
+// Parameters:
+//   - p (Pass): the pass. A pass registered under the same name is replaced.
+func RegisterPass(p Pass) {
+	builtinPasses[p.Name()] = p
+}
+
+// BuiltinPasses returns the names of the registered passes, sorted.
+//
+// Returns:
+//   - []string: the names.
+func BuiltinPasses() []string {
+	names := make([]string, 0, len(builtinPasses))
+	for name := range builtinPasses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FindPass resolves a pass name: a registered pass, a path to an executable, or the executable
+// PassPrefix+name on PATH, in that order.
+//
+// Parameters:
+//   - name (string): the name given with --pass.
+//
+// Returns:
+//   - Pass: the pass.
+//   - error: an error if no pass of that name exists.
+func FindPass(name string) (Pass, error) {
+	if p, ok := builtinPasses[name]; ok {
+		return p, nil
+	}
+	if strings.ContainsAny(name, `/\`) {
+		if _, err := os.Stat(name); err != nil {
+			return nil, fmt.Errorf("pass %s: %v", name, err)
+		}
+		return ExternalPass{Command: name}, nil
+	}
+	path, err := exec.LookPath(PassPrefix + name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown pass %q: not built in (%s) and no %s%s on PATH", name, strings.Join(BuiltinPasses(), ", "), PassPrefix, name)
+	}
+	return ExternalPass{Command: path, PassName: name}, nil
+}
+
+// ExternalPass runs an executable that reads a PassInput from standard input and writes a
+// PassOutput to standard output. Its standard error is passed through, so it can log progress.
+type ExternalPass struct {
+	Command  string   // The executable.
+	Args     []string // Arguments passed to it.
+	PassName string   // The name of the pass; the executable's name if empty.
+}
+
+// Name returns the name of the pass.
+func (p ExternalPass) Name() string {
+	if p.PassName != "" {
+		return p.PassName
+	}
+	return p.Command
+}
+
+// Run runs the executable on in.
+//
+// Parameters:
+//   - ctx (context.Context): kills the executable when done.
+//   - in (PassInput): the trace.
+//
+// Returns:
+//   - []Section: the sections written by the executable.
+//   - error: an error if it fails, exits with a non-zero status or writes invalid output.
+func (p ExternalPass) Run(ctx context.Context, in PassInput) ([]Section, error) {
+	input, err := json.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode pass input: %v", err)
+	}
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Command, p.Args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("pass %s: %v", p.Name(), ctx.Err())
+		}
+		return nil, fmt.Errorf("pass %s: %v", p.Name(), err)
+	}
+	var out PassOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("pass %s: invalid output: %v", p.Name(), err)
+	}
+	return out.Sections, nil
+}
+
+// RunPasses runs passes in order on in, each with at most timeout (0 for no limit).
+//
+// Parameters:
+//   - passes ([]Pass): the passes.
+//   - in (PassInput): the trace.
+//   - timeout (time.Duration): the time limit per pass.
+//
+// Returns:
+//   - []Section: the sections of all passes, in pass order. Untitled sections are titled with the
+//     name of their pass.
+//   - error: the first error of a pass.
+func RunPasses(passes []Pass, in PassInput, timeout time.Duration) ([]Section, error) {
+	in.ProtocolVersion = PassProtocolVersion
+	var sections []Section
+	for _, p := range passes {
+		ctx, cancel := context.Background(), func() {}
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		got, err := p.Run(ctx, in)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range got {
+			if s.Title == "" {
+				s.Title = p.Name()
+			}
+			sections = append(sections, s)
+		}
+	}
+	return sections, nil
+}
+
+// WriteSections prints sections as a plain text report: each title underlined, followed by its
+// text and table.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - sections ([]Section): the sections.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteSections(w io.Writer, sections []Section) error {
+	for i, s := range sections {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s\n%s\n", s.Title, strings.Repeat("=", len(s.Title)))
+		if s.Text != "" {
+			fmt.Fprintln(w, strings.TrimRight(s.Text, "\n"))
+		}
+		if len(s.Columns) == 0 && len(s.Rows) == 0 {
+			continue
+		}
+		if s.Text != "" {
+			fmt.Fprintln(w)
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		if len(s.Columns) > 0 {
+			fmt.Fprintln(tw, strings.Join(s.Columns, "\t"))
+		}
+		for _, row := range s.Rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sloPass is the built-in "slo" pass, the report of analyze slo.
+type sloPass struct{}
+
+func (sloPass) Name() string { return "slo" }
+
+func (sloPass) Run(ctx context.Context, in PassInput) ([]Section, error) {
+	summaries := SLOReport(in.Records, 5)
+	if len(summaries) == 0 {
+		return []Section{{Title: "Latency SLOs", Text: "No records with an SLO (declare budgets under tracing.slos)."}}, nil
+	}
+	var buf bytes.Buffer
+	if err := WriteSLOReport(&buf, summaries); err != nil {
+		return nil, err
+	}
+	return []Section{{Title: "Latency SLOs", Text: buf.String()}}, nil
+}
+
+func init() {
+	RegisterPass(sloPass{})
+}
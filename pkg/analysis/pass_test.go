@@ -0,0 +1,90 @@
+package analysis_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// passEnv makes the test binary act as an external pass that counts the records it receives.
+const passEnv = "TRACEWRAP_TEST_PASS"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(passEnv) {
+	case "count":
+		var in analysis.PassInput
+		if err := json.NewDecoder(os.Stdin).Decode(&in); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		json.NewEncoder(os.Stdout).Encode(analysis.PassOutput{Sections: []analysis.Section{{
+			Text:    fmt.Sprintf("protocol %d, %d records", in.ProtocolVersion, len(in.Records)),
+			Columns: []string{"FUNCTION", "CALLS"},
+			Rows:    [][]string{{in.Records[0].FunctionName, "1"}},
+		}}})
+		os.Exit(0)
+	case "fail":
+		os.Exit(3)
+	case "sleep":
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// testPass returns an external pass running the test binary in the given mode.
+func testPass(t *testing.T, mode string) analysis.Pass {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Failed to find test binary: %v", err)
+	}
+	t.Setenv(passEnv, mode)
+	return analysis.ExternalPass{Command: exe, PassName: "count"}
+}
+
+func TestRunPassesWithExternalPass(t *testing.T) {
+	in := analysis.PassInput{Records: []tracer.TraceRecord{{UniqueID: 1, FunctionName: "main"}}}
+	sections, err := analysis.RunPasses([]analysis.Pass{testPass(t, "count")}, in, time.Minute)
+	if err != nil {
+		t.Fatalf("RunPasses returned error: %v", err)
+	}
+	if len(sections) != 1 || sections[0].Title != "count" || sections[0].Text != "protocol 1, 1 records" {
+		t.Fatalf("Unexpected sections %+v", sections)
+	}
+
+	var buf bytes.Buffer
+	if err := analysis.WriteSections(&buf, sections); err != nil {
+		t.Fatalf("WriteSections returned error: %v", err)
+	}
+	want := "count\n=====\nprotocol 1, 1 records\n\nFUNCTION  CALLS\nmain      1\n"
+	if buf.String() != want {
+		t.Errorf("Expected report\n%s\ngot\n%s", want, buf.String())
+	}
+}
+
+func TestRunPassesReportsFailures(t *testing.T) {
+	if _, err := analysis.RunPasses([]analysis.Pass{testPass(t, "fail")}, analysis.PassInput{}, time.Minute); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("Expected the exit status in the error, got %v", err)
+	}
+	if _, err := analysis.RunPasses([]analysis.Pass{testPass(t, "sleep")}, analysis.PassInput{}, 50*time.Millisecond); err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+}
+
+func TestFindPass(t *testing.T) {
+	if p, err := analysis.FindPass("slo"); err != nil || p.Name() != "slo" {
+		t.Errorf("Expected the built-in slo pass, got %v (%v)", p, err)
+	}
+	t.Setenv("PATH", "")
+	if _, err := analysis.FindPass("mycompany-sla"); err == nil || !strings.Contains(err.Error(), "tracewrap-pass-mycompany-sla") {
+		t.Errorf("Expected an error naming the executable looked for, got %v", err)
+	}
+}
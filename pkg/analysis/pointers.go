@@ -0,0 +1,136 @@
+package analysis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// ObjectCall is one call an object was passed to.
+type ObjectCall struct {
+	Function  string    `json:"function"`
+	Param     string    `json:"param"` // The parameter holding the object.
+	UniqueID  int64     `json:"uniqueId"`
+	EntryTime time.Time `json:"entryTime"`
+}
+
+// ObjectFlow is the calls one object, identified by its pointer ID, was passed to, in the order
+// they were entered. The ID is the hash of an address, which the runtime reuses once an object is
+// collected, so a long flow may join objects that lived one after the other.
+type ObjectFlow struct {
+	PointerID string       `json:"pointerId"`
+	Functions []string     `json:"functions"` // The distinct functions, in the order first called.
+	Calls     []ObjectCall `json:"calls"`
+}
+
+// PointerFlows follows the objects passed by pointer through the calls of a trace, from the
+// pointerIds recorded with tracing.capture.pointerIds.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records of the run.
+//   - top (int): the maximum number of objects listed, or 0 for all.
+//
+// Returns:
+//   - []ObjectFlow: the objects passed through the most functions first, then to the most calls,
+//     ties by the first call.
+func PointerFlows(records []tracer.TraceRecord, top int) []ObjectFlow {
+	byID := make(map[string]*ObjectFlow)
+	for _, rec := range records {
+		for param, id := range rec.PointerIDs {
+			flow := byID[id]
+			if flow == nil {
+				flow = &ObjectFlow{PointerID: id}
+				byID[id] = flow
+			}
+			flow.Calls = append(flow.Calls, ObjectCall{Function: rec.FunctionName, Param: param, UniqueID: rec.UniqueID, EntryTime: rec.EntryTime})
+		}
+	}
+	flows := make([]ObjectFlow, 0, len(byID))
+	for _, flow := range byID {
+		sort.Slice(flow.Calls, func(i, j int) bool {
+			a, b := flow.Calls[i], flow.Calls[j]
+			if !a.EntryTime.Equal(b.EntryTime) {
+				return a.EntryTime.Before(b.EntryTime)
+			}
+			if a.UniqueID != b.UniqueID {
+				return a.UniqueID < b.UniqueID
+			}
+			return a.Param < b.Param
+		})
+		seen := make(map[string]bool)
+		for _, call := range flow.Calls {
+			if !seen[call.Function] {
+				seen[call.Function] = true
+				flow.Functions = append(flow.Functions, call.Function)
+			}
+		}
+		flows = append(flows, *flow)
+	}
+	sort.Slice(flows, func(i, j int) bool {
+		a, b := flows[i], flows[j]
+		if len(a.Functions) != len(b.Functions) {
+			return len(a.Functions) > len(b.Functions)
+		}
+		if len(a.Calls) != len(b.Calls) {
+			return len(a.Calls) > len(b.Calls)
+		}
+		if first, other := a.Calls[0].EntryTime, b.Calls[0].EntryTime; !first.Equal(other) {
+			return first.Before(other)
+		}
+		return a.PointerID < b.PointerID
+	})
+	if top > 0 && len(flows) > top {
+		flows = flows[:top]
+	}
+	return flows
+}
+
+// WritePointerFlows prints each object with the chain of calls it was passed to, such as
+// "handle(req) -> validate(order) -> save(order)".
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - flows ([]ObjectFlow): the objects from PointerFlows.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WritePointerFlows(w io.Writer, flows []ObjectFlow) error {
+	for _, flow := range flows {
+		chain := make([]string, len(flow.Calls))
+		for i, call := range flow.Calls {
+			chain[i] = fmt.Sprintf("%s(%s)", call.Function, call.Param)
+		}
+		if _, err := fmt.Fprintf(w, "%s  %d calls, %d functions\n  %s\n", flow.PointerID, len(flow.Calls), len(flow.Functions), strings.Join(chain, " -> ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pointersPass is the built-in "pointers" pass, the report of analyze pointers for the ten objects
+// passed through the most functions.
+type pointersPass struct{}
+
+func (pointersPass) Name() string { return "pointers" }
+
+func (pointersPass) Run(ctx context.Context, in PassInput) ([]Section, error) {
+	flows := PointerFlows(in.Records, 10)
+	if len(flows) == 0 {
+		return []Section{{Title: "Object Flows", Text: "No pointer IDs recorded (set tracing.capture.pointerIds)."}}, nil
+	}
+	var buf bytes.Buffer
+	if err := WritePointerFlows(&buf, flows); err != nil {
+		return nil, err
+	}
+	return []Section{{Title: "Object Flows", Text: buf.String()}}, nil
+}
+
+func init() {
+	RegisterPass(pointersPass{})
+}
@@ -0,0 +1,53 @@
+package analysis_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestPointerFlows(t *testing.T) {
+	base := time.Unix(0, 0)
+	at := func(ms int) time.Time { return base.Add(time.Duration(ms) * time.Millisecond) }
+	records := []tracer.TraceRecord{
+		{UniqueID: 3, FunctionName: "save", EntryTime: at(3), PointerIDs: map[string]string{"o": "aa"}},
+		{UniqueID: 1, FunctionName: "handle", EntryTime: at(1), PointerIDs: map[string]string{"req": "aa", "log": "bb"}},
+		{UniqueID: 2, FunctionName: "validate", EntryTime: at(2), PointerIDs: map[string]string{"o": "aa"}},
+		{UniqueID: 4, FunctionName: "validate", EntryTime: at(4), PointerIDs: map[string]string{"o": "aa"}},
+		{UniqueID: 5, FunctionName: "log", EntryTime: at(5), PointerIDs: map[string]string{"l": "bb"}},
+		{UniqueID: 6, FunctionName: "plain", EntryTime: at(6)},
+	}
+	flows := analysis.PointerFlows(records, 0)
+	if len(flows) != 2 {
+		t.Fatalf("Expected two objects, got %+v", flows)
+	}
+	order := flows[0]
+	if order.PointerID != "aa" || len(order.Calls) != 4 {
+		t.Fatalf("Expected object aa first with four calls, got %+v", order)
+	}
+	if !reflect.DeepEqual(order.Functions, []string{"handle", "validate", "save"}) {
+		t.Errorf("Expected the functions in the order first called, got %v", order.Functions)
+	}
+	if order.Calls[0].Param != "req" || order.Calls[3].UniqueID != 4 {
+		t.Errorf("Expected the calls in entry order, got %+v", order.Calls)
+	}
+	if top := analysis.PointerFlows(records, 1); len(top) != 1 || top[0].PointerID != "aa" {
+		t.Errorf("Expected top to keep the first object, got %+v", top)
+	}
+
+	var buf bytes.Buffer
+	if err := analysis.WritePointerFlows(&buf, flows); err != nil {
+		t.Fatalf("WritePointerFlows returned error: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"aa  4 calls, 3 functions", "handle(req) -> validate(o) -> save(o) -> validate(o)", "handle(log) -> log(l)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected report to contain %q:\n%s", want, out)
+		}
+	}
+}
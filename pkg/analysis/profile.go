@@ -0,0 +1,339 @@
+package analysis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// ProfileFunction is the cost of one function in a pprof profile, in the unit of the profile.
+type ProfileFunction struct {
+	Name string // As in the profile, e.g. "main.(*Server).handle".
+	Flat int64  // The cost of samples in the function itself.
+	Cum  int64  // The cost of samples with the function anywhere on the stack.
+}
+
+// Profile is the part of a pprof profile the reports use: the cost of each function for one
+// sample type.
+type Profile struct {
+	SampleType string // e.g. "cpu" or "inuse_space".
+	Unit       string // e.g. "nanoseconds" or "bytes".
+	Total      int64
+	Functions  []ProfileFunction // Highest flat cost first.
+}
+
+// Format renders a cost of p in its unit.
+func (p Profile) Format(v int64) string {
+	switch p.Unit {
+	case "nanoseconds":
+		return time.Duration(v).Round(time.Microsecond).String()
+	case "bytes":
+		switch {
+		case v >= 1<<30:
+			return fmt.Sprintf("%.1fGiB", float64(v)/(1<<30))
+		case v >= 1<<20:
+			return fmt.Sprintf("%.1fMiB", float64(v)/(1<<20))
+		case v >= 1<<10:
+			return fmt.Sprintf("%.1fKiB", float64(v)/(1<<10))
+		}
+		return fmt.Sprintf("%dB", v)
+	}
+	return fmt.Sprint(v)
+}
+
+// ReadProfile reads a pprof profile, such as the cpu.pprof and heap.pprof files written with
+// tracing.cpuProfile and tracing.heapProfile, and sums the cost of each function for the last
+// sample type, the one go tool pprof shows by default (cpu time, or in-use memory for heap
+// profiles), or for the sample type named.
+//
+// Parameters:
+//   - path (string): the profile, gzip-compressed or not.
+//   - sampleType (string): the sample type, e.g. "alloc_space", or "" for the default.
+//
+// Returns:
+//   - Profile: the profile.
+//   - error: an error if the file cannot be read, is not a profile or lacks the sample type.
+func ReadProfile(path, sampleType string) (Profile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Profile{}, err
+	}
+	defer file.Close()
+	p, err := profile.Parse(file)
+	if err != nil {
+		return Profile{}, fmt.Errorf("%s: invalid profile: %v", path, err)
+	}
+	prof, err := summarizeProfile(p, sampleType)
+	if err != nil {
+		return Profile{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return prof, nil
+}
+
+// summarizeProfile sums the cost of each function of p for the sample type named, or for the
+// default one if sampleType is "".
+func summarizeProfile(p *profile.Profile, sampleType string) (Profile, error) {
+	if len(p.SampleType) == 0 {
+		return Profile{}, errors.New("invalid profile: no sample types")
+	}
+	name := sampleType
+	if name == "" {
+		name = p.DefaultSampleType
+	}
+	index := len(p.SampleType) - 1
+	if name != "" {
+		found := false
+		for i, vt := range p.SampleType {
+			if vt.Type == name {
+				index, found = i, true
+			}
+		}
+		if !found && sampleType != "" {
+			var names []string
+			for _, vt := range p.SampleType {
+				names = append(names, vt.Type)
+			}
+			return Profile{}, fmt.Errorf("no sample type %q; the profile has %s", sampleType, strings.Join(names, ", "))
+		}
+	}
+	prof := Profile{SampleType: p.SampleType[index].Type, Unit: p.SampleType[index].Unit}
+	costs := make(map[string]*ProfileFunction)
+	for _, s := range p.Sample {
+		if index >= len(s.Value) {
+			continue
+		}
+		v := s.Value[index]
+		prof.Total += v
+		seen := make(map[string]bool)
+		for i, loc := range s.Location {
+			// Inlined functions come first among the lines of a location.
+			for j, line := range loc.Line {
+				if line.Function == nil {
+					continue
+				}
+				c, ok := costs[line.Function.Name]
+				if !ok {
+					c = &ProfileFunction{Name: line.Function.Name}
+					costs[line.Function.Name] = c
+				}
+				if i == 0 && j == 0 {
+					c.Flat += v
+				}
+				if !seen[c.Name] {
+					seen[c.Name] = true
+					c.Cum += v
+				}
+			}
+		}
+	}
+	for _, c := range costs {
+		prof.Functions = append(prof.Functions, *c)
+	}
+	sort.Slice(prof.Functions, func(i, j int) bool {
+		a, b := prof.Functions[i], prof.Functions[j]
+		if a.Flat != b.Flat {
+			return a.Flat > b.Flat
+		}
+		if a.Cum != b.Cum {
+			return a.Cum > b.Cum
+		}
+		return a.Name < b.Name
+	})
+	return prof, nil
+}
+
+// closureSuffix matches the suffixes the compiler gives function literals, e.g. ".func1.2".
+var closureSuffix = regexp.MustCompile(`(\.func\d+)(\.\d+)*$`)
+
+// profileMatches reports whether the function named in a profile is the traced function name,
+// which may be short (Func, Method), package-qualified (pkg.Type.Method) or full
+// (example.com/mod/pkg.Type.Method); see instrumentation.nameFormat. Function literals count
+// towards the function declaring them.
+func profileMatches(profileName, traceName string) bool {
+	name := closureSuffix.ReplaceAllString(profileName, "")
+	name = strings.NewReplacer("(*", "", ")", "").Replace(name)
+	return name == traceName || strings.HasSuffix(name, "/"+traceName) || strings.HasSuffix(name, "."+traceName)
+}
+
+// ProfileHotspot aligns a function of the trace with its cost in a profile.
+type ProfileHotspot struct {
+	Name      string        `json:"name"`      // The traced function's name, or the profile's for untraced functions.
+	Traced    bool          `json:"traced"`    // Whether the function has records in the trace.
+	Calls     int           `json:"calls"`     // The recorded calls within the profile's time window.
+	Total     time.Duration `json:"total"`     // Their total duration.
+	TraceRank int           `json:"traceRank"` // The rank by Total among traced functions, 0 for untraced ones.
+	Flat      int64         `json:"flat"`      // The profile's flat and cumulative cost of the function.
+	Cum       int64         `json:"cum"`
+	Rank      int           `json:"rank"` // The rank by Cum in the profile, 0 if the function has no samples.
+}
+
+// ProfileHotspots aligns the hottest functions of a trace with a profile captured during the run.
+// Traced functions are ranked by the total duration of their calls that started in the
+// profile's time window (ref.Start to ref.End after the run start; every call for heap
+// profiles), and matched by name against the functions of the profile, ranked by cumulative
+// cost. The result holds the top traced functions and the top profile functions, each with its
+// rank on the other side: hot in the trace but cheap in the profile points at waiting, hot in
+// the profile but untraced at code tracewrap does not see.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records of the run.
+//   - startedAt (time.Time): the start of the run, from tracer.RunMetadata.
+//   - ref (tracer.ProfileRef): the profile's reference in the run metadata.
+//   - prof (Profile): the profile, from ReadProfile.
+//   - top (int): the number of functions taken from each side, or 0 for all.
+//
+// Returns:
+//   - []ProfileHotspot: the functions, highest cumulative cost first, then by trace rank.
+func ProfileHotspots(records []tracer.TraceRecord, startedAt time.Time, ref tracer.ProfileRef, prof Profile, top int) []ProfileHotspot {
+	from, to := startedAt.Add(ref.Start), startedAt.Add(ref.End)
+	windowed := records[:0:0]
+	for _, rec := range records {
+		if ref.Type != tracer.ProfileCPU || startedAt.IsZero() || !rec.EntryTime.Before(from) && !rec.EntryTime.After(to) {
+			windowed = append(windowed, rec)
+		}
+	}
+	hot, _ := Hotlist(windowed, nil, ByTime, 0)
+
+	byCum := append([]ProfileFunction(nil), prof.Functions...)
+	sort.SliceStable(byCum, func(i, j int) bool { return byCum[i].Cum > byCum[j].Cum })
+	spots := make(map[string]*ProfileHotspot)
+	var order []string
+	add := func(name string) *ProfileHotspot {
+		s, ok := spots[name]
+		if !ok {
+			s = &ProfileHotspot{Name: name}
+			spots[name] = s
+			order = append(order, name)
+		}
+		return s
+	}
+	for i, h := range hot {
+		if top > 0 && i >= top {
+			break
+		}
+		s := add(h.Name)
+		s.Traced, s.Calls, s.Total, s.TraceRank = true, h.Recorded, h.Total, i+1
+		for rank, fn := range byCum {
+			if profileMatches(fn.Name, h.Name) {
+				s.Flat, s.Cum, s.Rank = fn.Flat, fn.Cum, rank+1
+				break
+			}
+		}
+	}
+	taken := 0
+	for rank, fn := range byCum {
+		if top > 0 && taken >= top || fn.Cum == 0 {
+			break
+		}
+		taken++
+		traced := -1
+		for i, h := range hot {
+			if profileMatches(fn.Name, h.Name) {
+				traced = i
+				break
+			}
+		}
+		if traced < 0 {
+			s := add(fn.Name)
+			s.Flat, s.Cum, s.Rank = fn.Flat, fn.Cum, rank+1
+			continue
+		}
+		h := hot[traced]
+		s := add(h.Name)
+		if s.Rank == 0 {
+			s.Flat, s.Cum, s.Rank = fn.Flat, fn.Cum, rank+1
+		}
+		s.Traced, s.Calls, s.Total, s.TraceRank = true, h.Recorded, h.Total, traced+1
+	}
+	out := make([]ProfileHotspot, 0, len(order))
+	for _, name := range order {
+		out = append(out, *spots[name])
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Cum != b.Cum {
+			return a.Cum > b.Cum
+		}
+		return a.TraceRank != 0 && (b.TraceRank == 0 || a.TraceRank < b.TraceRank)
+	})
+	return out
+}
+
+// WriteProfileHotspots prints the hotspots of a profile as a table.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - ref (tracer.ProfileRef): the profile's reference in the run metadata.
+//   - prof (Profile): the profile.
+//   - spots ([]ProfileHotspot): the hotspots from ProfileHotspots.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteProfileHotspots(w io.Writer, ref tracer.ProfileRef, prof Profile, spots []ProfileHotspot) error {
+	rank := func(r int) string {
+		if r == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("#%d", r)
+	}
+	fmt.Fprintf(w, "%s profile %s (%s, %s total", ref.Type, ref.File, prof.SampleType, prof.Format(prof.Total))
+	if ref.Type == tracer.ProfileCPU {
+		fmt.Fprintf(w, ", +%v to +%v", ref.Start.Round(time.Millisecond), ref.End.Round(time.Millisecond))
+	}
+	fmt.Fprintln(w, ")")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FUNCTION\tTRACE RANK\tCALLS\tTRACE TIME\tPROFILE RANK\tFLAT\tCUM\tCUM %")
+	for _, s := range spots {
+		calls, total := "-", "-"
+		if s.Traced {
+			calls, total = fmt.Sprint(s.Calls), s.Total.String()
+		}
+		share := "-"
+		if prof.Total > 0 && s.Rank > 0 {
+			share = fmt.Sprintf("%.1f%%", 100*float64(s.Cum)/float64(prof.Total))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, rank(s.TraceRank), calls, total, rank(s.Rank), prof.Format(s.Flat), prof.Format(s.Cum), share)
+	}
+	return tw.Flush()
+}
+
+// profilePass aligns the profiles referenced by the run metadata with the trace.
+type profilePass struct{}
+
+func (profilePass) Name() string { return "profiles" }
+
+func (profilePass) Run(ctx context.Context, in PassInput) ([]Section, error) {
+	if in.Metadata == nil || len(in.Metadata.Profiles) == 0 {
+		return []Section{{Title: "Profiles", Text: "No profiles were captured; set tracing.cpuProfile or tracing.heapProfile."}}, nil
+	}
+	var sections []Section
+	for _, ref := range in.Metadata.Profiles {
+		prof, err := ReadProfile(filepath.Join(filepath.Dir(in.Trace), ref.File), "")
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		spots := ProfileHotspots(in.Records, in.Metadata.StartedAt, ref, prof, 10)
+		if err := WriteProfileHotspots(&buf, ref, prof, spots); err != nil {
+			return nil, err
+		}
+		sections = append(sections, Section{Title: "Profile: " + ref.Type, Text: buf.String()})
+	}
+	return sections, nil
+}
+
+func init() {
+	RegisterPass(profilePass{})
+}
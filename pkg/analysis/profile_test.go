@@ -0,0 +1,84 @@
+package analysis_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+var retained [][]byte
+
+//go:noinline
+func allocateBuffers() {
+	for i := 0; i < 64; i++ {
+		retained = append(retained, make([]byte, 64*1024))
+	}
+}
+
+func TestProfileHotspots(t *testing.T) {
+	defer runtime.GC()
+	defer func(rate int) { runtime.MemProfileRate = rate }(runtime.MemProfileRate)
+	runtime.MemProfileRate = 1
+	allocateBuffers()
+	defer func() { retained = nil }()
+	runtime.GC()
+
+	dir, err := os.MkdirTemp("", "profile")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "heap.pprof")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create profile: %v", err)
+	}
+	if err := pprof.Lookup("heap").WriteTo(file, 0); err != nil {
+		t.Fatalf("Failed to write profile: %v", err)
+	}
+	file.Close()
+
+	prof, err := analysis.ReadProfile(path, "")
+	if err != nil {
+		t.Fatalf("ReadProfile returned error: %v", err)
+	}
+	if prof.SampleType != "inuse_space" || prof.Unit != "bytes" || prof.Total < 64*64*1024 {
+		t.Fatalf("Unexpected profile %s/%s with %d total", prof.SampleType, prof.Unit, prof.Total)
+	}
+	if _, err := analysis.ReadProfile(path, "cycles"); err == nil {
+		t.Error("Expected an error for a missing sample type")
+	}
+
+	start := time.Now()
+	records := []tracer.TraceRecord{
+		{FunctionName: "allocateBuffers", EntryTime: start, Duration: time.Millisecond},
+		{FunctionName: "notInProfile", EntryTime: start, Duration: time.Second},
+	}
+	ref := tracer.ProfileRef{Type: tracer.ProfileHeap, File: "heap.pprof"}
+	spots := analysis.ProfileHotspots(records, start, ref, prof, 5)
+	found := map[string]analysis.ProfileHotspot{}
+	for _, s := range spots {
+		found[s.Name] = s
+	}
+	if s := found["allocateBuffers"]; !s.Traced || s.TraceRank != 2 || s.Rank == 0 || s.Flat < 64*64*1024 {
+		t.Errorf("Expected allocateBuffers to be matched with its allocations, got %+v", s)
+	}
+	if s := found["notInProfile"]; !s.Traced || s.TraceRank != 1 || s.Rank != 0 {
+		t.Errorf("Expected notInProfile to be ranked first in the trace without samples, got %+v", s)
+	}
+	var buf bytes.Buffer
+	if err := analysis.WriteProfileHotspots(&buf, ref, prof, spots); err != nil {
+		t.Fatalf("WriteProfileHotspots returned error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "heap profile heap.pprof (inuse_space") || !strings.Contains(buf.String(), "allocateBuffers") {
+		t.Errorf("Unexpected report:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,122 @@
+// Package analysis summarizes recorded trace records.
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// SLOOffender is a call that exceeded its latency budget.
+type SLOOffender struct {
+	UniqueID     int64         `json:"uniqueId"`
+	FunctionName string        `json:"functionName"`
+	Route        string        `json:"route"`
+	Duration     time.Duration `json:"duration"`
+}
+
+// SLOSummary aggregates the calls covered by one tracing.slos entry.
+type SLOSummary struct {
+	Target     string        `json:"target"`     // The function pattern, or "route <pattern>".
+	Budget     time.Duration `json:"budget"`     // The latency budget.
+	Calls      int           `json:"calls"`      // Number of calls the entry applied to.
+	Violations int           `json:"violations"` // Number of calls that exceeded the budget.
+	Worst      []SLOOffender `json:"worst"`      // The slowest violating calls, slowest first.
+}
+
+// ViolationRate returns the share of calls that exceeded the budget.
+func (s SLOSummary) ViolationRate() float64 {
+	if s.Calls == 0 {
+		return 0
+	}
+	return float64(s.Violations) / float64(s.Calls)
+}
+
+// SLOReport groups records by the SLO marked on them by the tracer and counts the violations.
+// Summaries are ordered by violation count, most first, then by target.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records, e.g. from tracer.ReadTraceFile.
+//   - worst (int): how many of the slowest violating calls to keep per summary.
+//
+// Returns:
+//   - []SLOSummary: one summary per SLO target seen in the records.
+func SLOReport(records []tracer.TraceRecord, worst int) []SLOSummary {
+	byTarget := make(map[string]*SLOSummary)
+	for _, rec := range records {
+		if rec.SLOTarget == "" {
+			continue
+		}
+		s, ok := byTarget[rec.SLOTarget]
+		if !ok {
+			s = &SLOSummary{Target: rec.SLOTarget, Budget: rec.SLOBudget}
+			byTarget[rec.SLOTarget] = s
+		}
+		s.Calls++
+		if rec.SLOViolated {
+			s.Violations++
+			s.Worst = append(s.Worst, SLOOffender{UniqueID: rec.UniqueID, FunctionName: rec.FunctionName, Route: rec.Route, Duration: rec.Duration})
+		}
+	}
+	summaries := make([]SLOSummary, 0, len(byTarget))
+	for _, s := range byTarget {
+		sort.Slice(s.Worst, func(i, j int) bool {
+			if s.Worst[i].Duration != s.Worst[j].Duration {
+				return s.Worst[i].Duration > s.Worst[j].Duration
+			}
+			return s.Worst[i].UniqueID < s.Worst[j].UniqueID
+		})
+		if len(s.Worst) > worst {
+			s.Worst = s.Worst[:worst]
+		}
+		summaries = append(summaries, *s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Violations != summaries[j].Violations {
+			return summaries[i].Violations > summaries[j].Violations
+		}
+		return summaries[i].Target < summaries[j].Target
+	})
+	return summaries
+}
+
+// WriteSLOReport prints summaries as a table followed by the worst offenders of each target.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - summaries ([]SLOSummary): the report from SLOReport.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteSLOReport(w io.Writer, summaries []SLOSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tBUDGET\tCALLS\tVIOLATIONS\tRATE")
+	for _, s := range summaries {
+		fmt.Fprintf(tw, "%s\t%v\t%d\t%d\t%.1f%%\n", s.Target, s.Budget, s.Calls, s.Violations, 100*s.ViolationRate())
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, s := range summaries {
+		if len(s.Worst) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "\nWorst offenders for %s:\n", s.Target); err != nil {
+			return err
+		}
+		for _, o := range s.Worst {
+			name := o.FunctionName
+			if o.Route != "" {
+				name += " (" + o.Route + ")"
+			}
+			if _, err := fmt.Fprintf(w, "  ID %d  %s  %v (+%v)\n", o.UniqueID, name, o.Duration, o.Duration-s.Budget); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
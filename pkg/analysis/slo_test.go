@@ -0,0 +1,48 @@
+package analysis_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestSLOReport(t *testing.T) {
+	ms := time.Millisecond
+	records := []tracer.TraceRecord{
+		{UniqueID: 1, FunctionName: "checkout", SLOTarget: "checkout", SLOBudget: 10 * ms, Duration: 5 * ms},
+		{UniqueID: 2, FunctionName: "checkout", SLOTarget: "checkout", SLOBudget: 10 * ms, Duration: 30 * ms, SLOViolated: true},
+		{UniqueID: 3, FunctionName: "handle", Route: "GET /a", SLOTarget: "route GET /*", SLOBudget: 10 * ms, Duration: 20 * ms, SLOViolated: true},
+		{UniqueID: 4, FunctionName: "handle", Route: "GET /b", SLOTarget: "route GET /*", SLOBudget: 10 * ms, Duration: 50 * ms, SLOViolated: true},
+		{UniqueID: 5, FunctionName: "handle", Route: "GET /c", SLOTarget: "route GET /*", SLOBudget: 10 * ms, Duration: 40 * ms, SLOViolated: true},
+		{UniqueID: 6, FunctionName: "helper", Duration: time.Second},
+	}
+	summaries := analysis.SLOReport(records, 2)
+	if len(summaries) != 2 {
+		t.Fatalf("Expected two summaries, got %+v", summaries)
+	}
+	routes := summaries[0]
+	if routes.Target != "route GET /*" || routes.Calls != 3 || routes.Violations != 3 {
+		t.Errorf("Unexpected first summary: %+v", routes)
+	}
+	if len(routes.Worst) != 2 || routes.Worst[0].UniqueID != 4 || routes.Worst[1].UniqueID != 5 {
+		t.Errorf("Expected the two slowest offenders, got %+v", routes.Worst)
+	}
+	if c := summaries[1]; c.Target != "checkout" || c.Calls != 2 || c.Violations != 1 || c.ViolationRate() != 0.5 {
+		t.Errorf("Unexpected second summary: %+v", c)
+	}
+
+	var buf bytes.Buffer
+	if err := analysis.WriteSLOReport(&buf, summaries); err != nil {
+		t.Fatalf("WriteSLOReport returned error: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"route GET /*", "100.0%", "50.0%", "ID 4  handle (GET /b)  50ms (+40ms)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected report to contain %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// FunctionStats aggregates the recorded calls of one function, e.g. in a named baseline.
+type FunctionStats struct {
+	Name   string        `json:"name"`
+	Calls  int           `json:"calls"`
+	Total  time.Duration `json:"total"`
+	Mean   time.Duration `json:"mean"`
+	P50    time.Duration `json:"p50"`
+	P95    time.Duration `json:"p95"`
+	Max    time.Duration `json:"max"`
+	Alloc  uint64        `json:"alloc"`            // Heap growth during the calls, in bytes.
+	Panics int           `json:"panics,omitempty"` // Calls that panicked.
+}
+
+// Aggregate computes the stats of every function in records.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records, of one run or several.
+//
+// Returns:
+//   - []FunctionStats: the stats, by function name.
+func Aggregate(records []tracer.TraceRecord) []FunctionStats {
+	type function struct {
+		FunctionStats
+		durations []time.Duration
+	}
+	functions := newFunctionTable[function]()
+	newFunction := func(name string) *function { return &function{FunctionStats: FunctionStats{Name: name}} }
+	for i := range records {
+		rec := &records[i]
+		s := functions.get(rec, newFunction)
+		s.Calls++
+		s.Total += rec.Duration
+		s.Alloc += rec.MemDiff
+		if rec.Duration > s.Max {
+			s.Max = rec.Duration
+		}
+		if rec.PanicValue != nil {
+			s.Panics++
+		}
+		s.durations = append(s.durations, rec.Duration)
+	}
+	stats := make([]FunctionStats, 0, len(functions.byName))
+	for _, s := range functions.byName {
+		ds := s.durations
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		s.Mean = s.Total / time.Duration(s.Calls)
+		s.P50 = percentile(ds, 0.50)
+		s.P95 = percentile(ds, 0.95)
+		stats = append(stats, s.FunctionStats)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// FunctionDelta compares the stats of a function in a baseline and in a later run.
+type FunctionDelta struct {
+	Name    string         `json:"name"`
+	Base    *FunctionStats `json:"base,omitempty"`    // Nil for a function new in the run.
+	Current *FunctionStats `json:"current,omitempty"` // Nil for a function the run no longer calls.
+	// MeanChange is the change of the mean duration, in percent of the baseline's; positive is slower.
+	MeanChange float64 `json:"meanChange"`
+}
+
+// Compare matches the stats of a baseline and of a run by function name.
+//
+// Parameters:
+//   - base ([]FunctionStats): the baseline's stats.
+//   - current ([]FunctionStats): the run's stats.
+//
+// Returns:
+//   - []FunctionDelta: the functions in both, most slowed down first, then those only in the run
+//     and those only in the baseline, by name.
+func Compare(base, current []FunctionStats) []FunctionDelta {
+	byName := make(map[string]*FunctionDelta, len(base))
+	var deltas []*FunctionDelta
+	for i := range base {
+		d := &FunctionDelta{Name: base[i].Name, Base: &base[i]}
+		byName[d.Name] = d
+		deltas = append(deltas, d)
+	}
+	for i := range current {
+		d, ok := byName[current[i].Name]
+		if !ok {
+			d = &FunctionDelta{Name: current[i].Name}
+			deltas = append(deltas, d)
+		}
+		d.Current = &current[i]
+	}
+	out := make([]FunctionDelta, len(deltas))
+	for i, d := range deltas {
+		if d.Base != nil && d.Current != nil && d.Base.Mean > 0 {
+			d.MeanChange = 100 * float64(d.Current.Mean-d.Base.Mean) / float64(d.Base.Mean)
+		}
+		out[i] = *d
+	}
+	group := func(d FunctionDelta) int {
+		switch {
+		case d.Base != nil && d.Current != nil:
+			return 0
+		case d.Current != nil:
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if gi, gj := group(out[i]), group(out[j]); gi != gj {
+			return gi < gj
+		}
+		if out[i].MeanChange != out[j].MeanChange {
+			return out[i].MeanChange > out[j].MeanChange
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// Regressions returns the functions of deltas whose mean duration grew by more than maxSlowdown
+// percent, among those called at least minCalls times in both the baseline and the run.
+//
+// Parameters:
+//   - deltas ([]FunctionDelta): the comparison, from Compare.
+//   - maxSlowdown (float64): the largest slowdown allowed, in percent, e.g. 20.
+//   - minCalls (int): the fewest calls of a checked function, to skip noisy ones.
+//
+// Returns:
+//   - []FunctionDelta: the regressed functions, most slowed down first.
+func Regressions(deltas []FunctionDelta, maxSlowdown float64, minCalls int) []FunctionDelta {
+	var regressed []FunctionDelta
+	for _, d := range deltas {
+		if d.Base == nil || d.Current == nil || d.Base.Calls < minCalls || d.Current.Calls < minCalls {
+			continue
+		}
+		if d.MeanChange > maxSlowdown {
+			regressed = append(regressed, d)
+		}
+	}
+	return regressed
+}
+
+// WriteComparison prints a comparison as a table, with "-" for the side a function is missing from.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - deltas ([]FunctionDelta): the comparison, from Compare.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteComparison(w io.Writer, deltas []FunctionDelta) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FUNCTION\tBASE CALLS\tCALLS\tBASE MEAN\tMEAN\tCHANGE\tBASE P95\tP95")
+	side := func(s *FunctionStats) (calls, mean, p95 string) {
+		if s == nil {
+			return "-", "-", "-"
+		}
+		return fmt.Sprint(s.Calls), s.Mean.String(), s.P95.String()
+	}
+	for _, d := range deltas {
+		baseCalls, baseMean, baseP95 := side(d.Base)
+		calls, mean, p95 := side(d.Current)
+		change := "new"
+		switch {
+		case d.Current == nil:
+			change = "gone"
+		case d.Base != nil:
+			change = fmt.Sprintf("%+.1f%%", d.MeanChange)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", d.Name, baseCalls, calls, baseMean, mean, change, baseP95, p95)
+	}
+	return tw.Flush()
+}
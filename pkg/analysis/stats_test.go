@@ -0,0 +1,90 @@
+package analysis_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// calls returns one record of name per duration, in milliseconds.
+func calls(name string, ms ...int) []tracer.TraceRecord {
+	var records []tracer.TraceRecord
+	for _, d := range ms {
+		records = append(records, tracer.TraceRecord{FunctionName: name, Duration: time.Duration(d) * time.Millisecond, MemDiff: 10})
+	}
+	return records
+}
+
+func TestAggregate(t *testing.T) {
+	records := append(calls("parse", 1, 2, 3, 4, 10), calls("render", 5)...)
+	records = append(records, tracer.TraceRecord{FunctionName: "render", Duration: 7 * time.Millisecond, PanicValue: "boom"})
+	stats := analysis.Aggregate(records)
+	if len(stats) != 2 || stats[0].Name != "parse" || stats[1].Name != "render" {
+		t.Fatalf("Expected parse and render by name, got %+v", stats)
+	}
+	ms := time.Millisecond
+	if p := stats[0]; p.Calls != 5 || p.Total != 20*ms || p.Mean != 4*ms || p.P50 != 3*ms || p.P95 != 10*ms || p.Max != 10*ms || p.Alloc != 50 {
+		t.Errorf("Unexpected stats of parse: %+v", p)
+	}
+	if r := stats[1]; r.Calls != 2 || r.Panics != 1 || r.Max != 7*ms {
+		t.Errorf("Unexpected stats of render: %+v", r)
+	}
+}
+
+func TestAggregateGroupsByFunctionID(t *testing.T) {
+	records := []tracer.TraceRecord{
+		{FunctionID: 1, FunctionName: "main.handle", Duration: time.Millisecond},
+		{FunctionID: 1, FunctionName: "GET /users", Duration: time.Millisecond}, // A renamed span.
+		{FunctionID: 1, FunctionName: "main.handle", Duration: time.Millisecond},
+		{FunctionName: "main.handle", Duration: time.Millisecond},
+		{FunctionID: 2, FunctionName: "main.load", Duration: time.Millisecond},
+	}
+	var got []string
+	for _, s := range analysis.Aggregate(records) {
+		got = append(got, fmt.Sprintf("%s=%d", s.Name, s.Calls))
+	}
+	if want := "GET /users=1 main.handle=3 main.load=1"; strings.Join(got, " ") != want {
+		t.Errorf("Expected %s, got %v", want, got)
+	}
+}
+
+func TestCompareAndRegressions(t *testing.T) {
+	base := analysis.Aggregate(append(append(calls("parse", 10, 10), calls("render", 20, 20)...), calls("legacy", 1)...))
+	current := analysis.Aggregate(append(append(calls("parse", 15, 15), calls("render", 18, 18)...), calls("cache", 1)...))
+	deltas := analysis.Compare(base, current)
+	var names []string
+	for _, d := range deltas {
+		names = append(names, d.Name)
+	}
+	if got := strings.Join(names, " "); got != "parse render cache legacy" {
+		t.Fatalf("Expected the slowest first, then new and gone functions, got %s", got)
+	}
+	if deltas[0].MeanChange != 50 || deltas[1].MeanChange != -10 {
+		t.Errorf("Unexpected mean changes: %+v", deltas[:2])
+	}
+	if deltas[2].Base != nil || deltas[3].Current != nil {
+		t.Errorf("Expected cache to be new and legacy gone, got %+v", deltas[2:])
+	}
+
+	if r := analysis.Regressions(deltas, 20, 2); len(r) != 1 || r[0].Name != "parse" {
+		t.Errorf("Expected parse to regress, got %+v", r)
+	}
+	if r := analysis.Regressions(deltas, 20, 3); len(r) != 0 {
+		t.Errorf("Expected functions with too few calls to be skipped, got %+v", r)
+	}
+
+	var buf bytes.Buffer
+	if err := analysis.WriteComparison(&buf, deltas); err != nil {
+		t.Fatalf("WriteComparison returned error: %v", err)
+	}
+	for _, want := range []string{"+50.0%", "-10.0%", "new", "gone"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected %q in the comparison:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package analysis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// StatusLatency compares the successful and failed calls of one function.
+type StatusLatency struct {
+	Name     string         `json:"name"`
+	HTTP     map[int]int    `json:"http,omitempty"` // Calls per HTTP status code.
+	GRPC     map[string]int `json:"grpc,omitempty"` // Calls per gRPC code.
+	OK       LatencyStats   `json:"ok"`
+	Error    LatencyStats   `json:"error"`
+	Slowdown float64        `json:"slowdown"` // The error median over the ok median, 0 if either is missing.
+}
+
+// LatencyStats summarizes the durations of a group of calls.
+type LatencyStats struct {
+	Calls int           `json:"calls"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	Max   time.Duration `json:"max"`
+}
+
+// latencyStats summarizes ds, which it sorts.
+func latencyStats(ds []time.Duration) LatencyStats {
+	if len(ds) == 0 {
+		return LatencyStats{}
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return LatencyStats{Calls: len(ds), P50: percentile(ds, 0.5), P90: percentile(ds, 0.9), Max: ds[len(ds)-1]}
+}
+
+// FilterStatus returns the records with the given span status, tracer.StatusOK or
+// tracer.StatusError; "" returns all records.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records.
+//   - status (string): the status to keep, or "".
+//
+// Returns:
+//   - []tracer.TraceRecord: the matching records, in their original order.
+//   - error: an error for an unknown status.
+func FilterStatus(records []tracer.TraceRecord, status string) ([]tracer.TraceRecord, error) {
+	switch status {
+	case "":
+		return records, nil
+	case tracer.StatusOK, tracer.StatusError:
+	default:
+		return nil, fmt.Errorf("unknown status %q; use %s or %s", status, tracer.StatusOK, tracer.StatusError)
+	}
+	var out []tracer.TraceRecord
+	for _, rec := range records {
+		if rec.Status == status {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+// StatusReport compares the latency of the successful and failed calls of each function with a
+// span status, set from HTTP status codes and gRPC codes. A failing request that is faster than a
+// successful one usually fails early; one that is slower usually waits for a timeout.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records, e.g. from tracer.ReadTraceFile.
+//
+// Returns:
+//   - []StatusLatency: one entry per function, most failed calls first, then by name.
+func StatusReport(records []tracer.TraceRecord) []StatusLatency {
+	type function struct {
+		StatusLatency
+		ok, failed []time.Duration
+	}
+	functions := newFunctionTable[function]()
+	newFunction := func(name string) *function {
+		return &function{StatusLatency: StatusLatency{Name: name, HTTP: make(map[int]int), GRPC: make(map[string]int)}}
+	}
+	for i := range records {
+		rec := &records[i]
+		if rec.Status == "" {
+			continue
+		}
+		s := functions.get(rec, newFunction)
+		if rec.HTTPStatus != 0 {
+			s.HTTP[rec.HTTPStatus]++
+		}
+		if rec.GRPCCode != "" {
+			s.GRPC[rec.GRPCCode]++
+		}
+		if rec.Status == tracer.StatusError {
+			s.failed = append(s.failed, rec.Duration)
+		} else {
+			s.ok = append(s.ok, rec.Duration)
+		}
+	}
+	out := make([]StatusLatency, 0, len(functions.byName))
+	for _, s := range functions.byName {
+		s.OK, s.Error = latencyStats(s.ok), latencyStats(s.failed)
+		if s.OK.P50 > 0 && s.Error.Calls > 0 {
+			s.Slowdown = float64(s.Error.P50) / float64(s.OK.P50)
+		}
+		out = append(out, s.StatusLatency)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Error.Calls != out[j].Error.Calls {
+			return out[i].Error.Calls > out[j].Error.Calls
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// codes formats the calls per code, most frequent first, e.g. "200x41 503x3".
+func (s StatusLatency) codes() string {
+	type count struct {
+		code string
+		n    int
+	}
+	var counts []count
+	for code, n := range s.HTTP {
+		counts = append(counts, count{fmt.Sprint(code), n})
+	}
+	for code, n := range s.GRPC {
+		counts = append(counts, count{code, n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].n != counts[j].n {
+			return counts[i].n > counts[j].n
+		}
+		return counts[i].code < counts[j].code
+	})
+	var buf bytes.Buffer
+	for i, c := range counts {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		fmt.Fprintf(&buf, "%sx%d", c.code, c.n)
+	}
+	return buf.String()
+}
+
+// WriteStatusReport prints a status report as a table.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - report ([]StatusLatency): the report from StatusReport.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteStatusReport(w io.Writer, report []StatusLatency) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FUNCTION\tOK\tOK P50\tOK P90\tERRORS\tERROR P50\tERROR P90\tERROR/OK\tCODES")
+	for _, s := range report {
+		slowdown := "-"
+		if s.Slowdown > 0 {
+			slowdown = fmt.Sprintf("%.2fx", s.Slowdown)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%v\t%d\t%v\t%v\t%s\t%s\n", s.Name, s.OK.Calls, s.OK.P50, s.OK.P90,
+			s.Error.Calls, s.Error.P50, s.Error.P90, slowdown, s.codes())
+	}
+	return tw.Flush()
+}
+
+// statusPass is the built-in "status" pass, the report of analyze status.
+type statusPass struct{}
+
+func (statusPass) Name() string { return "status" }
+
+func (statusPass) Run(ctx context.Context, in PassInput) ([]Section, error) {
+	report := StatusReport(in.Records)
+	if len(report) == 0 {
+		return []Section{{Title: "Span Status", Text: "No records with an HTTP or gRPC status."}}, nil
+	}
+	var buf bytes.Buffer
+	if err := WriteStatusReport(&buf, report); err != nil {
+		return nil, err
+	}
+	return []Section{{Title: "Span Status", Text: buf.String()}}, nil
+}
+
+func init() {
+	RegisterPass(statusPass{})
+}
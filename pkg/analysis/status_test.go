@@ -0,0 +1,46 @@
+package analysis_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestStatusReport(t *testing.T) {
+	ms := time.Millisecond
+	records := []tracer.TraceRecord{
+		{FunctionName: "handle", Duration: 10 * ms, Status: tracer.StatusOK, HTTPStatus: 200},
+		{FunctionName: "handle", Duration: 12 * ms, Status: tracer.StatusOK, HTTPStatus: 200},
+		{FunctionName: "handle", Duration: 30 * ms, Status: tracer.StatusError, HTTPStatus: 504},
+		{FunctionName: "fetch", Duration: 5 * ms, Status: tracer.StatusOK, GRPCCode: "OK"},
+		{FunctionName: "parse", Duration: ms},
+	}
+
+	report := analysis.StatusReport(records)
+	if len(report) != 2 || report[0].Name != "handle" || report[1].Name != "fetch" {
+		t.Fatalf("Expected handle, then fetch, got %+v", report)
+	}
+	h := report[0]
+	if h.OK.Calls != 2 || h.OK.P50 != 10*ms || h.Error.Calls != 1 || h.Error.P50 != 30*ms || h.Slowdown != 3 {
+		t.Errorf("Unexpected latencies for handle: %+v", h)
+	}
+	var buf bytes.Buffer
+	if err := analysis.WriteStatusReport(&buf, report); err != nil {
+		t.Fatalf("WriteStatusReport returned error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "3.00x") || !strings.Contains(buf.String(), "200x2 504x1") {
+		t.Errorf("Unexpected report:\n%s", buf.String())
+	}
+
+	failed, err := analysis.FilterStatus(records, tracer.StatusError)
+	if err != nil || len(failed) != 1 || failed[0].HTTPStatus != 504 {
+		t.Errorf("Expected the 504 call, got %+v (%v)", failed, err)
+	}
+	if _, err := analysis.FilterStatus(records, "failed"); err == nil {
+		t.Error("Expected an error for an unknown status")
+	}
+}
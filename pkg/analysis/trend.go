@@ -0,0 +1,119 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Periods of a trend.
+const (
+	ByHour = "hour"
+	ByDay  = "day"
+)
+
+// RunStats are the per-function stats of one run, at the time it started.
+type RunStats struct {
+	Time      time.Time
+	Functions []FunctionStats
+}
+
+// TrendPoint rolls up the calls of a function in the runs started in one period.
+type TrendPoint struct {
+	Start time.Time     `json:"start"` // The start of the period, in UTC.
+	Runs  int           `json:"runs"`  // Runs that called the function.
+	Calls int           `json:"calls"`
+	Mean  time.Duration `json:"mean"`
+	// P95 is the mean of the runs' p95 durations weighted by their calls, an approximation of the
+	// p95 of all the calls.
+	P95 time.Duration `json:"p95"`
+	Max time.Duration `json:"max"`
+}
+
+// Trend rolls up the stats of a function per hour or day, to show whether it crept slower
+// across runs. Periods without a run that called the function are left out.
+//
+// Parameters:
+//   - runs ([]RunStats): the stats of the runs, in any order.
+//   - function (string): the function name.
+//   - by (string): ByHour or ByDay.
+//
+// Returns:
+//   - []TrendPoint: one point per period, oldest first.
+//   - error: an error for an unknown period.
+func Trend(runs []RunStats, function, by string) ([]TrendPoint, error) {
+	var truncate func(time.Time) time.Time
+	switch by {
+	case ByHour:
+		truncate = func(t time.Time) time.Time { return t.UTC().Truncate(time.Hour) }
+	case ByDay:
+		truncate = func(t time.Time) time.Time {
+			y, m, d := t.UTC().Date()
+			return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		}
+	default:
+		return nil, fmt.Errorf("unknown period %q; use %s or %s", by, ByHour, ByDay)
+	}
+	type sums struct {
+		point TrendPoint
+		total time.Duration
+		p95   float64 // Sum of the runs' p95 durations times their calls.
+	}
+	periods := make(map[time.Time]*sums)
+	for _, run := range runs {
+		for _, fn := range run.Functions {
+			if fn.Name != function || fn.Calls == 0 {
+				continue
+			}
+			start := truncate(run.Time)
+			p, ok := periods[start]
+			if !ok {
+				p = &sums{point: TrendPoint{Start: start}}
+				periods[start] = p
+			}
+			p.point.Runs++
+			p.point.Calls += fn.Calls
+			p.total += fn.Total
+			p.p95 += float64(fn.P95) * float64(fn.Calls)
+			if fn.Max > p.point.Max {
+				p.point.Max = fn.Max
+			}
+		}
+	}
+	points := make([]TrendPoint, 0, len(periods))
+	for _, p := range periods {
+		p.point.Mean = p.total / time.Duration(p.point.Calls)
+		p.point.P95 = time.Duration(p.p95 / float64(p.point.Calls))
+		points = append(points, p.point)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Start.Before(points[j].Start) })
+	return points, nil
+}
+
+// WriteTrend prints a trend as a table, with the change of each period's mean from the first's.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - points ([]TrendPoint): the trend, from Trend.
+//   - by (string): the period of the points, ByHour or ByDay.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteTrend(w io.Writer, points []TrendPoint, by string) error {
+	layout := "2006-01-02"
+	if by == ByHour {
+		layout = "2006-01-02 15:00"
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PERIOD\tRUNS\tCALLS\tMEAN\tP95\tMAX\tCHANGE")
+	for i, p := range points {
+		change := "-"
+		if i > 0 && points[0].Mean > 0 {
+			change = fmt.Sprintf("%+.1f%%", 100*float64(p.Mean-points[0].Mean)/float64(points[0].Mean))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%v\t%v\t%v\t%s\n", p.Start.Format(layout), p.Runs, p.Calls, p.Mean, p.P95, p.Max, change)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,59 @@
+package analysis_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+)
+
+func TestTrend(t *testing.T) {
+	ms := time.Millisecond
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	run := func(at time.Time, calls int, mean, p95, max time.Duration) analysis.RunStats {
+		return analysis.RunStats{Time: at, Functions: []analysis.FunctionStats{
+			{Name: "processJob", Calls: calls, Total: time.Duration(calls) * mean, Mean: mean, P95: p95, Max: max},
+			{Name: "other", Calls: 1, Total: ms, Mean: ms},
+		}}
+	}
+	runs := []analysis.RunStats{
+		run(day.Add(26*time.Hour), 30, 15*ms, 20*ms, 40*ms),
+		run(day.Add(2*time.Hour), 10, 10*ms, 12*ms, 20*ms),
+		run(day.Add(5*time.Hour), 30, 10*ms, 16*ms, 30*ms),
+		{Time: day.Add(50 * time.Hour), Functions: []analysis.FunctionStats{{Name: "other", Calls: 1}}},
+	}
+
+	points, err := analysis.Trend(runs, "processJob", analysis.ByDay)
+	if err != nil {
+		t.Fatalf("Trend returned error: %v", err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("Expected 2 days with calls, got %+v", points)
+	}
+	if p := points[0]; !p.Start.Equal(day) || p.Runs != 2 || p.Calls != 40 || p.Mean != 10*ms || p.P95 != 15*ms || p.Max != 30*ms {
+		t.Errorf("Unexpected first day: %+v", p)
+	}
+	if p := points[1]; !p.Start.Equal(day.Add(24*time.Hour)) || p.Runs != 1 || p.Mean != 15*ms {
+		t.Errorf("Unexpected second day: %+v", p)
+	}
+
+	hourly, err := analysis.Trend(runs, "processJob", analysis.ByHour)
+	if err != nil || len(hourly) != 3 || !hourly[1].Start.Equal(day.Add(5*time.Hour)) {
+		t.Errorf("Expected 3 hours with calls, got %+v (%v)", hourly, err)
+	}
+	if _, err := analysis.Trend(runs, "processJob", "week"); err == nil {
+		t.Error("Expected an error for an unknown period")
+	}
+
+	var buf bytes.Buffer
+	if err := analysis.WriteTrend(&buf, points, analysis.ByDay); err != nil {
+		t.Fatalf("WriteTrend returned error: %v", err)
+	}
+	for _, want := range []string{"2024-03-01", "2024-03-02", "+50.0%"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected %q in the trend:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Package applog captures an instrumented application's stdout and stderr line by line with
+// timestamps and correlates each line with the span that was running when it was written.
+//
+// Captured lines are stored one per line as
+//
+//	<RFC 3339 timestamp> <stream> span=<unique ID or -> <text>
+package applog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// Line is one captured output line.
+type Line struct {
+	Time   time.Time
+	Stream string // "stdout" or "stderr".
+	SpanID int64  // Unique ID of the innermost span open at Time, or 0 if none.
+	Text   string
+}
+
+// String formats the line as it is stored in the log file.
+func (l Line) String() string {
+	span := "-"
+	if l.SpanID != 0 {
+		span = strconv.FormatInt(l.SpanID, 10)
+	}
+	return fmt.Sprintf("%s %s span=%s %s", l.Time.UTC().Format(time.RFC3339Nano), l.Stream, span, l.Text)
+}
+
+// ParseLine parses a line in the stored format.
+//
+// Parameters:
+//   - s (string): the stored line, without its newline.
+//
+// Returns:
+//   - Line: the parsed line.
+//   - error: an error if s is not in the stored format.
+func ParseLine(s string) (Line, error) {
+	fields := strings.SplitN(s, " ", 4)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "span=") {
+		return Line{}, fmt.Errorf("malformed application log line: %q", s)
+	}
+	t, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return Line{}, fmt.Errorf("malformed application log timestamp: %v", err)
+	}
+	line := Line{Time: t, Stream: fields[1]}
+	if span := strings.TrimPrefix(fields[2], "span="); span != "-" {
+		if line.SpanID, err = strconv.ParseInt(span, 10, 64); err != nil {
+			return Line{}, fmt.Errorf("malformed application log span: %v", err)
+		}
+	}
+	if len(fields) == 4 {
+		line.Text = fields[3]
+	}
+	return line, nil
+}
+
+// ReadFile reads a captured application log.
+//
+// Parameters:
+//   - path (string): the log file, e.g. "tracewrap/latest/app.log".
+//
+// Returns:
+//   - []Line: the lines in file order.
+//   - error: an error if the file cannot be read or a line is malformed.
+func ReadFile(path string) ([]Line, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines []Line
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line, err := ParseLine(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// Capture collects the lines written by a running application and stores them in a log file as
+// they arrive.
+type Capture struct {
+	path  string
+	mu    sync.Mutex
+	file  *os.File
+	lines []Line
+}
+
+// NewCapture creates (or truncates) the log file at path.
+//
+// Parameters:
+//   - path (string): the log file, e.g. "tracewrap/latest/app.log".
+//
+// Returns:
+//   - *Capture: the capture.
+//   - error: an error if the file cannot be created.
+func NewCapture(path string) (*Capture, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create application log: %v", err)
+	}
+	return &Capture{path: path, file: file}, nil
+}
+
+// Writer returns a writer for one output stream. Every complete line written to it is timestamped
+// on arrival and stored; the raw output is also copied to passthrough unless it is nil. Close the
+// writer once the application has exited to store a final unterminated line.
+//
+// Parameters:
+//   - stream (string): the stream name, "stdout" or "stderr".
+//   - passthrough (io.Writer): where the output is also written, e.g. os.Stdout, or nil.
+//
+// Returns:
+//   - io.WriteCloser: the stream writer.
+func (c *Capture) Writer(stream string, passthrough io.Writer) io.WriteCloser {
+	return &streamWriter{capture: c, stream: stream, passthrough: passthrough}
+}
+
+// add stores one line.
+func (c *Capture) add(stream, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	line := Line{Time: time.Now(), Stream: stream, Text: text}
+	c.lines = append(c.lines, line)
+	fmt.Fprintln(c.file, line.String())
+}
+
+// Finish correlates the captured lines with the spans in records and rewrites the log file with
+// their span IDs.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the run's trace records, or nil if there are none.
+//
+// Returns:
+//   - []Line: the correlated lines.
+//   - error: an error if the log file cannot be rewritten.
+func (c *Capture) Finish(records []tracer.TraceRecord) ([]Line, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	Correlate(c.lines, records)
+	if err := c.file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write application log: %v", err)
+	}
+	var buf bytes.Buffer
+	for _, line := range c.lines {
+		buf.WriteString(line.String())
+		buf.WriteByte('\n')
+	}
+	if err := os.WriteFile(c.path, buf.Bytes(), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write application log: %v", err)
+	}
+	return c.lines, nil
+}
+
+// Correlate sets each line's SpanID to the innermost span open at the line's time: among the
+// records whose entry and exit times enclose it, the one entered last. Lines are timestamped when
+// the runner reads them, slightly after the application wrote them, and spans of concurrent
+// goroutines overlap, so the result is a best-effort attribution.
+//
+// Parameters:
+//   - lines ([]Line): the lines, in time order; their SpanID fields are updated.
+//   - records ([]tracer.TraceRecord): the trace records.
+func Correlate(lines []Line, records []tracer.TraceRecord) {
+	spans := make([]tracer.TraceRecord, len(records))
+	copy(spans, records)
+	sort.Slice(spans, func(i, j int) bool {
+		if !spans[i].EntryTime.Equal(spans[j].EntryTime) {
+			return spans[i].EntryTime.Before(spans[j].EntryTime)
+		}
+		return spans[i].UniqueID < spans[j].UniqueID
+	})
+	var open []tracer.TraceRecord // Entered before the current line, in entry order.
+	next := 0
+	for i := range lines {
+		t := lines[i].Time
+		for next < len(spans) && !spans[next].EntryTime.After(t) {
+			open = append(open, spans[next])
+			next++
+		}
+		// Drop spans that ended before this line; later lines are not earlier.
+		kept := open[:0]
+		for _, span := range open {
+			if !span.ExitTime.Before(t) {
+				kept = append(kept, span)
+			}
+		}
+		open = kept
+		lines[i].SpanID = 0
+		if len(open) > 0 {
+			lines[i].SpanID = open[len(open)-1].UniqueID
+		}
+	}
+}
+
+// streamWriter splits one stream into lines for a Capture.
+type streamWriter struct {
+	capture     *Capture
+	stream      string
+	passthrough io.Writer
+	partial     []byte
+}
+
+// Write stores every complete line in p and keeps the rest for the next call.
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.passthrough != nil {
+		if _, err := w.passthrough.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.capture.add(w.stream, strings.TrimSuffix(string(w.partial[:i]), "\r"))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Close stores a final unterminated line, if any.
+func (w *streamWriter) Close() error {
+	if len(w.partial) > 0 {
+		w.capture.add(w.stream, string(w.partial))
+		w.partial = nil
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package applog_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/applog"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestCorrelateUsesInnermostOpenSpan(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := time.Millisecond
+	records := []tracer.TraceRecord{
+		{UniqueID: 2, FunctionName: "child", EntryTime: t0.Add(10 * ms), ExitTime: t0.Add(20 * ms)},
+		{UniqueID: 1, FunctionName: "main", EntryTime: t0, ExitTime: t0.Add(50 * ms)},
+	}
+	lines := []applog.Line{
+		{Time: t0.Add(-ms), Text: "before"},
+		{Time: t0.Add(5 * ms), Text: "in main"},
+		{Time: t0.Add(15 * ms), Text: "in child"},
+		{Time: t0.Add(30 * ms), Text: "back in main"},
+		{Time: t0.Add(60 * ms), Text: "after"},
+	}
+	applog.Correlate(lines, records)
+	want := []int64{0, 1, 2, 1, 0}
+	for i, line := range lines {
+		if line.SpanID != want[i] {
+			t.Errorf("%q: expected span %d, got %d", line.Text, want[i], line.SpanID)
+		}
+	}
+}
+
+func TestLineRoundTrip(t *testing.T) {
+	line := applog.Line{Time: time.Date(2024, 1, 1, 0, 0, 0, 5, time.UTC), Stream: "stderr", SpanID: 7, Text: "warning: disk almost full"}
+	got, err := applog.ParseLine(line.String())
+	if err != nil {
+		t.Fatalf("ParseLine returned error: %v", err)
+	}
+	if !got.Time.Equal(line.Time) || got.Stream != line.Stream || got.SpanID != line.SpanID || got.Text != line.Text {
+		t.Errorf("Expected %+v, got %+v", line, got)
+	}
+	if _, err := applog.ParseLine("not a captured line"); err == nil {
+		t.Error("Expected an error for a malformed line")
+	}
+}
+
+func TestCaptureSplitsLinesAndPassesOutputThrough(t *testing.T) {
+	dir, err := os.MkdirTemp("", "applogtest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	capture, err := applog.NewCapture(path)
+	if err != nil {
+		t.Fatalf("NewCapture returned error: %v", err)
+	}
+	var console bytes.Buffer
+	stdout := capture.Writer("stdout", &console)
+	stdout.Write([]byte("hello wo"))
+	stdout.Write([]byte("rld\nsecond\npartial"))
+	stdout.Close()
+	if console.String() != "hello world\nsecond\npartial" {
+		t.Errorf("Expected the raw output on the console, got %q", console.String())
+	}
+
+	start := time.Now().Add(-time.Hour)
+	records := []tracer.TraceRecord{{UniqueID: 9, EntryTime: start, ExitTime: time.Now().Add(time.Hour)}}
+	if _, err := capture.Finish(records); err != nil {
+		t.Fatalf("Finish returned error: %v", err)
+	}
+	lines, err := applog.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile returned error: %v", err)
+	}
+	if len(lines) != 3 || lines[0].Text != "hello world" || lines[2].Text != "partial" {
+		t.Fatalf("Unexpected captured lines: %+v", lines)
+	}
+	for _, line := range lines {
+		if line.SpanID != 9 || line.Stream != "stdout" {
+			t.Errorf("Expected stdout lines tagged with span 9, got %+v", line)
+		}
+	}
+}
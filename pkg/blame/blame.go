@@ -0,0 +1,163 @@
+// Package blame finds the commit that last changed a function, so reports can name the owner of
+// a hot or regressed function. It runs the git command line tool, as builds run the go tool,
+// rather than linking a Git implementation.
+package blame
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/instrument"
+)
+
+// uncommitted is the hash git blame reports for lines changed in the working tree.
+const uncommitted = "0000000000000000000000000000000000000000"
+
+// Commit describes the last change of a function.
+type Commit struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Time    time.Time `json:"time"` // The author time.
+	Summary string    `json:"summary"`
+	// Uncommitted reports that some lines of the function have changes in the working tree that
+	// no commit holds yet; the commit is then the last one of the committed lines.
+	Uncommitted bool `json:"uncommitted,omitempty"`
+}
+
+// Short returns the abbreviated hash of the commit.
+func (c Commit) Short() string {
+	if len(c.Hash) > 8 {
+		return c.Hash[:8]
+	}
+	return c.Hash
+}
+
+// LastCommit blames the lines start to end of file and returns the most recent commit among them.
+//
+// Parameters:
+//   - root (string): a directory of the Git work tree.
+//   - file (string): the file, relative to root.
+//   - start (int): the first line.
+//   - end (int): the last line; a value below start blames start alone.
+//
+// Returns:
+//   - Commit: the commit.
+//   - error: an error if git fails, such as for a file outside the repository or not committed.
+func LastCommit(root, file string, start, end int) (Commit, error) {
+	if end < start {
+		end = start
+	}
+	cmd := exec.Command("git", "-C", root, "blame", "--porcelain", "-L", fmt.Sprintf("%d,%d", start, end), "--", file)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return Commit{}, fmt.Errorf("git blame %s: %s", file, msg)
+		}
+		return Commit{}, fmt.Errorf("git blame %s: %v", file, err)
+	}
+	return parsePorcelain(out)
+}
+
+// parsePorcelain returns the most recent commit of git blame --porcelain output. Each commit's
+// headers follow the first line it is blamed for only.
+func parsePorcelain(out []byte) (Commit, error) {
+	commits := make(map[string]*Commit)
+	var current *Commit
+	var last Commit
+	dirty := false
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "\t") {
+			// The content of a blamed line ends its entry.
+			if current != nil {
+				if current.Hash == uncommitted {
+					dirty = true
+				} else if last.Hash == "" || current.Time.After(last.Time) {
+					last = *current
+				}
+			}
+			current = nil
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		if current == nil {
+			c, ok := commits[key]
+			if !ok {
+				c = &Commit{Hash: key}
+				commits[key] = c
+			}
+			current = c
+			continue
+		}
+		switch key {
+		case "author":
+			current.Author = value
+		case "author-mail":
+			current.Email = strings.Trim(value, "<>")
+		case "author-time":
+			if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+				current.Time = time.Unix(sec, 0).UTC()
+			}
+		case "summary":
+			current.Summary = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Commit{}, fmt.Errorf("failed to read git blame output: %v", err)
+	}
+	if last.Hash == "" && !dirty {
+		return Commit{}, fmt.Errorf("git blame reported no lines")
+	}
+	last.Uncommitted = dirty
+	return last, nil
+}
+
+// ForFunctions returns the last commit of each named function of the inventory. A name the
+// inventory holds more than once, such as a method name of the short name format, is blamed at
+// its first function. Names missing from the inventory, or that git cannot blame, are left out.
+//
+// Parameters:
+//   - root (string): the project root the inventory's files are relative to.
+//   - inv (instrument.Inventory): the inventory written by buildTracedApplication.
+//   - names ([]string): the function names, as trace records carry them.
+//
+// Returns:
+//   - map[string]Commit: the commits by function name.
+//   - error: an error if git is not installed or root is not in a Git work tree.
+func ForFunctions(root string, inv instrument.Inventory, names []string) (map[string]Commit, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("git is not installed: %v", err)
+	}
+	if out, err := exec.Command("git", "-C", root, "rev-parse", "--is-inside-work-tree").Output(); err != nil || strings.TrimSpace(string(out)) != "true" {
+		return nil, fmt.Errorf("%s is not in a Git work tree", root)
+	}
+	functions := make(map[string]instrument.Function, len(inv.Functions))
+	for _, fn := range inv.Functions {
+		if _, ok := functions[fn.Name]; !ok {
+			functions[fn.Name] = fn
+		}
+	}
+	commits := make(map[string]Commit, len(names))
+	for _, name := range names {
+		fn, ok := functions[name]
+		if !ok {
+			continue
+		}
+		c, err := LastCommit(root, fn.File, fn.Line, fn.EndLine)
+		if err != nil {
+			continue
+		}
+		commits[name] = c
+	}
+	return commits, nil
+}
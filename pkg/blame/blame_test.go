@@ -0,0 +1,82 @@
+package blame_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/mwiater/tracewrap/pkg/blame"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+)
+
+// commitFile writes main.go in dir and commits it as author at date, in Git's internal format.
+func commitFile(t *testing.T, dir, content, author, date, message string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	for _, args := range [][]string{{"add", "main.go"}, {"commit", "-q", "-m", message}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME="+author, "GIT_AUTHOR_EMAIL="+author+"@example.com", "GIT_AUTHOR_DATE="+date,
+			"GIT_COMMITTER_NAME="+author, "GIT_COMMITTER_EMAIL="+author+"@example.com", "GIT_COMMITTER_DATE="+date)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+}
+
+func TestForFunctions(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := os.MkdirTemp("", "tracewrap-blame-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if out, err := exec.Command("git", "init", "-q", dir).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v\n%s", err, out)
+	}
+
+	commitFile(t, dir, "package main\n\nfunc parse() {\n\tprintln(1)\n}\n\nfunc render() {\n\tprintln(2)\n}\n",
+		"alice", "1700000000 +0000", "Add parse and render")
+	commitFile(t, dir, "package main\n\nfunc parse() {\n\tprintln(1)\n}\n\nfunc render() {\n\tprintln(3)\n}\n",
+		"bob", "1700086400 +0000", "Change render")
+
+	inv := instrument.Inventory{Functions: []instrument.Function{
+		{Name: "parse", File: "main.go", Line: 3, EndLine: 5},
+		{Name: "render", File: "main.go", Line: 7, EndLine: 9},
+	}}
+	commits, err := blame.ForFunctions(dir, inv, []string{"parse", "render", "missing"})
+	if err != nil {
+		t.Fatalf("ForFunctions returned error: %v", err)
+	}
+	if len(commits) != 2 {
+		t.Fatalf("Expected commits for 2 functions, got %+v", commits)
+	}
+	if c := commits["parse"]; c.Author != "alice" || c.Email != "alice@example.com" || c.Summary != "Add parse and render" || c.Time.Unix() != 1700000000 || len(c.Hash) != 40 {
+		t.Errorf("Unexpected commit of parse: %+v", c)
+	}
+	if c := commits["render"]; c.Author != "bob" || c.Summary != "Change render" || c.Uncommitted {
+		t.Errorf("Unexpected commit of render: %+v", c)
+	}
+
+	// A change in the working tree is reported on top of the last commit.
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc parse() {\n\tprintln(4)\n}\n\nfunc render() {\n\tprintln(3)\n}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	c, err := blame.LastCommit(dir, "main.go", 3, 5)
+	if err != nil {
+		t.Fatalf("LastCommit returned error: %v", err)
+	}
+	if c.Author != "alice" || !c.Uncommitted {
+		t.Errorf("Expected alice's commit with uncommitted changes, got %+v", c)
+	}
+
+	if _, err := blame.ForFunctions(os.TempDir(), inv, []string{"parse"}); err == nil {
+		t.Error("Expected an error outside a Git work tree")
+	}
+}
@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"path/filepath"
+
+	"github.com/mwiater/tracewrap/pkg/alert"
+	"github.com/mwiater/tracewrap/pkg/notify"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// checkAlerts adds records, just appended to the trace of the run stored in dir, to the run's
+// tally of s.Alerts and notifies the alerts that fire for the first time in the run. A run without
+// a tally, e.g. one uploaded before the collector restarted, is tallied from its whole trace file
+// instead. Callers must hold the run's lock.
+func (s *Server) checkAlerts(dir string, records []tracer.TraceRecord) {
+	if len(s.Alerts) == 0 {
+		return
+	}
+	id := filepath.Base(dir)
+	s.locksMu.Lock()
+	tally := s.tallies[id]
+	s.locksMu.Unlock()
+	if tally == nil {
+		var err error
+		if records, err = tracer.ReadTraceFile(filepath.Join(dir, "trace.jsonl")); err != nil {
+			s.logf("Run %s: failed to read trace for alerting: %v", id, err)
+			return
+		}
+		tally = alert.NewTally(s.Alerts)
+		s.locksMu.Lock()
+		s.tallies[id] = tally
+		s.locksMu.Unlock()
+	}
+	for _, a := range tally.Add(records) {
+		s.logf("Run %s: alert %s fired (%d matching calls)", id, a.Rule, a.Matches)
+		go s.notify(id, a)
+	}
+}
+
+// notify delivers a fired alert with s.Notify, or to the webhook of its rule.
+func (s *Server) notify(run string, a alert.Alert) {
+	if s.Notify != nil {
+		s.Notify(run, a)
+		return
+	}
+	if a.Webhook == "" {
+		return
+	}
+	if err := notify.Send(nil, a.Webhook, a.Message("run "+run)); err != nil {
+		s.logf("Run %s: failed to notify alert %s: %v", run, a.Rule, err)
+	}
+}
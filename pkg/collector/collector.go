@@ -0,0 +1,332 @@
+// Package collector implements the HTTP server started by "tracewrap collector". Instrumented
+// binaries configured with tracing.collector.endpoint upload their trace records and run metadata
+// to it instead of writing local files, and the collector files them the way a local run would:
+//
+//	<dir>/<run id>/trace.jsonl  the run's trace records, appended as they arrive
+//	<dir>/<run id>/run.json     the run metadata
+//	<dir>/latest                a symbolic link to the run that most recently started uploading
+//
+// Runs uploaded with a host name (tracer.CollectorHostHeader) are stored as <run id>-<host>, so
+// binaries on different hosts can upload concurrently without their runs colliding. The same
+// server prunes old runs according to its Retention, serves a web UI for browsing them and
+// evaluates alerting rules on them as they arrive.
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/alert"
+	"github.com/mwiater/tracewrap/pkg/runs"
+	"github.com/mwiater/tracewrap/pkg/theme"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// MaxUploadBytes is the largest request body accepted.
+const MaxUploadBytes = 256 << 20
+
+// runIDPattern matches the run IDs accepted, which become directory names.
+var runIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// hostUnsafe matches the characters of a host name that are replaced in directory names.
+var hostUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Retention limits the runs a collector keeps. A zero value keeps every run.
+type Retention struct {
+	Keep   int           // When positive, only the most recently started Keep runs are kept.
+	MaxAge time.Duration // When positive, runs that have received no upload for longer than this are removed.
+	// MaxSize, when positive, removes the oldest runs until the runs use at most this many bytes.
+	MaxSize int64
+}
+
+// Server stores the runs uploaded by instrumented binaries in a directory.
+type Server struct {
+	dir string
+	// mu is held for reading by uploads and page views and for writing by Prune, so runs are not
+	// removed while they are used.
+	mu      sync.RWMutex
+	locksMu sync.Mutex              // Guards locks, tallies and the creation of run directories.
+	locks   map[string]*sync.Mutex  // Serializes the uploads to each run.
+	tallies map[string]*alert.Tally // The matches of Alerts so far, per run.
+
+	// Retention selects the runs removed by Prune.
+	Retention Retention
+	// Logf, when set, reports every accepted upload and removed run.
+	Logf func(format string, args ...interface{})
+	// Alerts are evaluated on the records of each upload to a run, which it makes on every flush,
+	// adding to the matches of its earlier uploads. Each rule fires at most once per run.
+	Alerts []*alert.Rule
+	// Notify is called, in its own goroutine, for every fired alert. When nil, alerts are posted to
+	// the webhook of their rule.
+	Notify func(run string, a alert.Alert)
+	// TraceLinks are rendered next to the external traces on a run's page.
+	TraceLinks []TraceLink
+	// Palette colors the web UI; the zero Palette means theme.Default.
+	Palette theme.Palette
+}
+
+// New returns a server storing runs in dir, creating the directory if needed.
+//
+// Parameters:
+//   - dir (string): the directory the run directories are created in.
+//
+// Returns:
+//   - *Server: the server.
+//   - error: an error if the directory cannot be created.
+func New(dir string) (*Server, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create collector directory: %v", err)
+	}
+	return &Server{dir: dir, locks: make(map[string]*sync.Mutex), tallies: make(map[string]*alert.Tally)}, nil
+}
+
+// Dir returns the directory the runs are stored in.
+func (s *Server) Dir() string {
+	return s.dir
+}
+
+// Handler returns the HTTP handler serving the collector API:
+//
+//	POST /v1/runs/{id}/records   append JSON Lines trace records (tracer.CollectorRecordsPath)
+//	PUT  /v1/runs/{id}/metadata  replace the run metadata (tracer.CollectorMetadataPath)
+//	GET  /v1/runs                the stored runs as JSON, newest first
+//	GET  /healthz                liveness check
+//
+// and the web UI:
+//
+//	GET  /                       the stored runs
+//	GET  /runs/{id}/             a run's metadata and its functions by total time
+//	GET  /runs/{id}/trace.jsonl  the run's trace file (also run.json)
+//	GET  /compare?base=&current= two runs compared: overlaid flame graphs and call trees
+//	GET  /theme.css              the colors of the web UI, from Palette
+//
+// Returns:
+//   - http.Handler: the collector handler.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+tracer.CollectorRecordsPath, s.handleRecords)
+	mux.HandleFunc("PUT "+tracer.CollectorMetadataPath, s.handleMetadata)
+	mux.HandleFunc("GET /v1/runs", s.handleRunList)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /{$}", s.handleIndex)
+	mux.HandleFunc("GET /runs/{id}/{$}", s.handleRun)
+	mux.HandleFunc("GET /runs/{id}/{file}", s.handleRunFile)
+	mux.HandleFunc("GET /compare", s.handleCompare)
+	mux.HandleFunc("GET /theme.css", s.handleTheme)
+	return mux
+}
+
+// handleRecords appends the uploaded records to the run's trace file and evaluates the alerts on
+// them.
+func (s *Server) handleRecords(w http.ResponseWriter, r *http.Request) {
+	id, body, ok := readUpload(w, r)
+	if !ok {
+		return
+	}
+	records, err := decodeRecords(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dir, unlock, err := s.lockRun(storedID(id, r.Header.Get(tracer.CollectorHostHeader)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer unlock()
+	file, err := os.OpenFile(filepath.Join(dir, "trace.jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to open trace file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(body); err != nil {
+		http.Error(w, fmt.Sprintf("failed to write trace file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	s.logf("Run %s: received %d trace records", filepath.Base(dir), len(records))
+	s.checkAlerts(dir, records)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMetadata stores the uploaded run metadata.
+func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	id, body, ok := readUpload(w, r)
+	if !ok {
+		return
+	}
+	meta, err := tracer.DecodeRunMetadata(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid run metadata: %v", err), http.StatusBadRequest)
+		return
+	}
+	if meta.RunID != "" && meta.RunID != id {
+		http.Error(w, fmt.Sprintf("run metadata is for run %s, not %s", meta.RunID, id), http.StatusBadRequest)
+		return
+	}
+	dir, unlock, err := s.lockRun(storedID(id, r.Header.Get(tracer.CollectorHostHeader)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer unlock()
+	if err := os.WriteFile(filepath.Join(dir, "run.json"), body, 0644); err != nil {
+		http.Error(w, fmt.Sprintf("failed to write run metadata: %v", err), http.StatusInternalServerError)
+		return
+	}
+	s.logf("Run %s: received run metadata (%d records)", filepath.Base(dir), meta.Records)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readUpload validates the run ID of r and reads its body, responding with an error if either is
+// unusable.
+//
+// Returns:
+//   - string: the run ID.
+//   - []byte: the body.
+//   - bool: false if an error response was written.
+func readUpload(w http.ResponseWriter, r *http.Request) (string, []byte, bool) {
+	id := r.PathValue("id")
+	if !runIDPattern.MatchString(id) || id == tracer.LatestRun {
+		http.Error(w, fmt.Sprintf("invalid run ID %q", id), http.StatusBadRequest)
+		return "", nil, false
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxUploadBytes))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read upload: %v", err), http.StatusRequestEntityTooLarge)
+		return "", nil, false
+	}
+	return id, body, true
+}
+
+// decodeRecords checks that body holds JSON Lines trace records and returns them.
+func decodeRecords(body []byte) ([]tracer.TraceRecord, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxUploadBytes)
+	var records []tracer.TraceRecord
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		rec, err := tracer.DecodeRecord(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid trace record: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read records: %v", err)
+	}
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		return nil, fmt.Errorf("records must end with a newline")
+	}
+	return records, nil
+}
+
+// storedID returns the name of the directory the run id uploaded from host is stored in.
+func storedID(id, host string) string {
+	if host = hostUnsafe.ReplaceAllString(host, "-"); host == "" {
+		return id
+	}
+	return id + "-" + host
+}
+
+// lockRun locks the run stored as id against other uploads and against pruning, creating its
+// directory and pointing the latest link at it when the run is new.
+//
+// Parameters:
+//   - id (string): the stored run ID.
+//
+// Returns:
+//   - string: the run directory.
+//   - func(): releases the locks.
+//   - error: an error if the directory cannot be created.
+func (s *Server) lockRun(id string) (string, func(), error) {
+	s.mu.RLock()
+	dir := filepath.Join(s.dir, id)
+	s.locksMu.Lock()
+	lock, ok := s.locks[id]
+	if !ok {
+		if _, err := os.Stat(dir); err != nil {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				s.locksMu.Unlock()
+				s.mu.RUnlock()
+				return "", nil, fmt.Errorf("failed to create run directory: %v", err)
+			}
+			if err := tracer.UpdateLatestLink(s.dir, id); err != nil {
+				s.logf("Failed to update the latest link: %v", err)
+			}
+		}
+		lock = &sync.Mutex{}
+		s.locks[id] = lock
+	}
+	s.locksMu.Unlock()
+	lock.Lock()
+	return dir, func() {
+		lock.Unlock()
+		s.mu.RUnlock()
+	}, nil
+}
+
+// Prune removes the runs outside s.Retention: the runs beyond the Keep most recently started, those
+// that have received no upload for longer than MaxAge, and the oldest of the rest while the runs
+// use more than MaxSize bytes. The run the latest link points at is never removed.
+//
+// Parameters:
+//   - now (time.Time): the current time, for Retention.MaxAge.
+//
+// Returns:
+//   - []runs.Run: the removed runs, oldest first.
+//   - error: an error if the directory cannot be read or a run cannot be removed.
+func (s *Server) Prune(now time.Time) ([]runs.Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, err := runs.List(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	keep, maxAge, maxSize := s.Retention.Keep, s.Retention.MaxAge, s.Retention.MaxSize
+	sizes := make([]int64, len(list))
+	var size int64
+	if maxSize > 0 {
+		for i, run := range list {
+			sizes[i] = runs.DirSize(run.Dir)
+			size += sizes[i]
+		}
+	}
+	var removed []runs.Run
+	for i, run := range list {
+		excess := keep > 0 && len(list)-i > keep
+		expired := maxAge > 0 && now.Sub(run.UpdatedAt) > maxAge
+		oversize := maxSize > 0 && size > maxSize
+		if run.Latest || !(excess || expired || oversize) {
+			continue
+		}
+		if err := os.RemoveAll(run.Dir); err != nil {
+			return removed, fmt.Errorf("failed to remove run %s: %v", run.ID, err)
+		}
+		size -= sizes[i]
+		delete(s.locks, run.ID)
+		delete(s.tallies, run.ID)
+		s.logf("Run %s: removed by the retention policy", run.ID)
+		removed = append(removed, run)
+	}
+	return removed, nil
+}
+
+// logf calls s.Logf if it is set.
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
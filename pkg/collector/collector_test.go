@@ -0,0 +1,397 @@
+package collector_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/alert"
+	"github.com/mwiater/tracewrap/pkg/collector"
+	"github.com/mwiater/tracewrap/pkg/runs"
+	"github.com/mwiater/tracewrap/pkg/theme"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// newServer starts a collector storing runs in a new temporary directory.
+func newServer(t *testing.T) (*collector.Server, *httptest.Server) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "collectortest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	server, err := collector.New(dir)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+	return server, ts
+}
+
+// upload sends body to the collector API path of run id and returns the response status.
+func upload(t *testing.T, ts *httptest.Server, method, path, id, body string) int {
+	t.Helper()
+	return uploadFrom(t, ts, "", method, path, id, body)
+}
+
+// uploadFrom is upload for a binary running on host.
+func uploadFrom(t *testing.T, ts *httptest.Server, host, method, path, id, body string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, tracer.CollectorURL(ts.URL, path, id), strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if host != "" {
+		req.Header.Set(tracer.CollectorHostHeader, host)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestCollectorStoresUploadedRuns(t *testing.T) {
+	server, ts := newServer(t)
+	batches := []string{
+		`{"uniqueId":1,"functionName":"main.work"}` + "\n",
+		`{"uniqueId":2,"functionName":"main.main"}` + "\n",
+	}
+	for _, batch := range batches {
+		if status := upload(t, ts, http.MethodPost, tracer.CollectorRecordsPath, "run-1", batch); status != http.StatusNoContent {
+			t.Fatalf("Expected records to be accepted, got status %d", status)
+		}
+	}
+	if status := upload(t, ts, http.MethodPut, tracer.CollectorMetadataPath, "run-1", `{"runId":"run-1","records":2}`); status != http.StatusNoContent {
+		t.Fatalf("Expected metadata to be accepted, got status %d", status)
+	}
+
+	records, err := tracer.ReadTraceFile(filepath.Join(server.Dir(), "run-1", "trace.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to read collected trace: %v", err)
+	}
+	if len(records) != 2 || records[0].FunctionName != "main.work" || records[1].FunctionName != "main.main" {
+		t.Errorf("Expected both batches in upload order, got %+v", records)
+	}
+	if _, err := os.Stat(filepath.Join(server.Dir(), "run-1", "run.json")); err != nil {
+		t.Errorf("Expected run metadata to be stored: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(server.Dir(), tracer.LatestRun)); err != nil || target != "run-1" {
+		t.Errorf("Expected latest to link to run-1, got %q (%v)", target, err)
+	}
+}
+
+func TestCollectorRejectsInvalidUploads(t *testing.T) {
+	server, ts := newServer(t)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		id     string
+		body   string
+	}{
+		{"run ID with path separator", http.MethodPost, tracer.CollectorRecordsPath, "../escape", "{}\n"},
+		{"latest run ID", http.MethodPost, tracer.CollectorRecordsPath, tracer.LatestRun, "{}\n"},
+		{"malformed record", http.MethodPost, tracer.CollectorRecordsPath, "run-1", "not json\n"},
+		{"metadata for another run", http.MethodPut, tracer.CollectorMetadataPath, "run-1", `{"runId":"run-2"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := upload(t, ts, tt.method, tt.path, tt.id, tt.body); status/100 != 4 {
+				t.Errorf("Expected a client error, got status %d", status)
+			}
+		})
+	}
+	if entries, _ := os.ReadDir(server.Dir()); len(entries) != 0 {
+		t.Errorf("Expected nothing to be stored, found %d entries", len(entries))
+	}
+}
+
+func TestCollectorKeepsRunsOfDifferentHostsApart(t *testing.T) {
+	server, ts := newServer(t)
+	record := `{"uniqueId":1,"functionName":"main.main"}` + "\n"
+	for _, host := range []string{"pod-a", "pod/b"} {
+		if status := uploadFrom(t, ts, host, http.MethodPost, tracer.CollectorRecordsPath, "20240101-120000-1", record); status != http.StatusNoContent {
+			t.Fatalf("Expected records from %s to be accepted, got status %d", host, status)
+		}
+	}
+	list, err := runs.List(server.Dir())
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	var ids []string
+	for _, run := range list {
+		ids = append(ids, run.ID)
+	}
+	sort.Strings(ids)
+	if strings.Join(ids, " ") != "20240101-120000-1-pod-a 20240101-120000-1-pod-b" {
+		t.Errorf("Expected one run per host, got %v", ids)
+	}
+}
+
+func TestCollectorPrunesByRetention(t *testing.T) {
+	server, ts := newServer(t)
+	now := time.Now()
+	for i, id := range []string{"run-1", "run-2", "run-3", "run-4"} {
+		upload(t, ts, http.MethodPost, tracer.CollectorRecordsPath, id, `{"uniqueId":1}`+"\n")
+		// Date the runs a day apart, run-4 being the latest.
+		at := now.Add(time.Duration(i-3) * 24 * time.Hour)
+		dir := filepath.Join(server.Dir(), id)
+		os.Chtimes(filepath.Join(dir, "trace.jsonl"), at, at)
+		os.Chtimes(dir, at, at)
+	}
+
+	server.Retention = collector.Retention{MaxAge: 36 * time.Hour}
+	removed, err := server.Prune(now)
+	if err != nil {
+		t.Fatalf("Prune returned error: %v", err)
+	}
+	if len(removed) != 2 || removed[0].ID != "run-1" || removed[1].ID != "run-2" {
+		t.Fatalf("Expected the runs idle for more than 36h to be removed, got %+v", removed)
+	}
+
+	server.Retention = collector.Retention{Keep: 1}
+	removed, err = server.Prune(now)
+	if err != nil {
+		t.Fatalf("Prune returned error: %v", err)
+	}
+	if len(removed) != 1 || removed[0].ID != "run-3" {
+		t.Fatalf("Expected only the latest run to be kept, got %+v removed", removed)
+	}
+	if _, err := os.Stat(filepath.Join(server.Dir(), "run-4", "trace.jsonl")); err != nil {
+		t.Errorf("Expected the latest run to survive: %v", err)
+	}
+
+	// The latest run is kept even when it alone exceeds the size limit.
+	server.Retention = collector.Retention{MaxSize: 1}
+	if removed, err := server.Prune(now); err != nil || len(removed) != 0 {
+		t.Errorf("Expected the latest run to be kept over the size limit, got %+v removed (%v)", removed, err)
+	}
+	// Uploads to a pruned run start it afresh.
+	if status := upload(t, ts, http.MethodPost, tracer.CollectorRecordsPath, "run-1", `{"uniqueId":2}`+"\n"); status != http.StatusNoContent {
+		t.Errorf("Expected an upload to a pruned run to be accepted, got status %d", status)
+	}
+}
+
+func TestCollectorServesRunPages(t *testing.T) {
+	_, ts := newServer(t)
+	upload(t, ts, http.MethodPost, tracer.CollectorRecordsPath, "run-1",
+		`{"uniqueId":1,"functionName":"main.slow","duration":3000000}`+"\n"+`{"uniqueId":2,"functionName":"main.fast","duration":1000}`+"\n")
+	upload(t, ts, http.MethodPut, tracer.CollectorMetadataPath, "run-1", `{"runId":"run-1","hostname":"pod-a","records":2}`)
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, body := get("/"); status != http.StatusOK || !strings.Contains(body, `href="/runs/run-1/"`) || !strings.Contains(body, "pod-a") {
+		t.Errorf("Expected the index to link to run-1, got %d: %s", status, body)
+	}
+	status, body := get("/runs/run-1/")
+	if status != http.StatusOK || !strings.Contains(body, "main.slow") || strings.Index(body, "main.slow") > strings.Index(body, "main.fast") {
+		t.Errorf("Expected the run page to list main.slow before main.fast, got %d: %s", status, body)
+	}
+	if status, body := get("/runs/run-1/trace.jsonl"); status != http.StatusOK || strings.Count(body, "\n") != 2 {
+		t.Errorf("Expected the trace file, got %d: %s", status, body)
+	}
+	if status, _ := get("/runs/missing/"); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown run, got %d", status)
+	}
+	if status, _ := get("/runs/run-1/secret.txt"); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for other files, got %d", status)
+	}
+
+	_, body = get("/v1/runs")
+	var list []runs.Run
+	if err := json.Unmarshal([]byte(body), &list); err != nil || len(list) != 1 || list[0].Metadata == nil || list[0].Metadata.Records != 2 {
+		t.Errorf("Expected run-1 with its metadata from /v1/runs, got %s (%v)", body, err)
+	}
+}
+
+func TestCollectorComparesRuns(t *testing.T) {
+	_, ts := newServer(t)
+	upload(t, ts, http.MethodPost, tracer.CollectorRecordsPath, "run-1", `{"uniqueId":1,"functionName":"main.handle","duration":1000000}`+"\n")
+	upload(t, ts, http.MethodPost, tracer.CollectorRecordsPath, "run-2",
+		`{"uniqueId":1,"functionName":"main.handle","duration":3000000}`+"\n"+`{"uniqueId":2,"callerId":1,"functionName":"main.query","duration":2000000}`+"\n")
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if _, body := get("/"); !strings.Contains(body, `<form action="/compare">`) {
+		t.Errorf("Expected the index to offer a comparison of the runs: %s", body)
+	}
+	status, body := get("/compare?base=run-1&current=run-2")
+	if status != http.StatusOK || !strings.Contains(body, "<h2>Flame graph</h2>") || !strings.Contains(body, `<tr class="new"><td style="padding-left: 2.4em">main.query</td>`) {
+		t.Errorf("Expected the call trees of both runs with main.query new, got %d: %s", status, body)
+	}
+	if status, _ := get("/compare?base=missing&current=run-2"); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown run, got %d", status)
+	}
+}
+
+func TestCollectorLinksExternalTraces(t *testing.T) {
+	server, ts := newServer(t)
+	server.TraceLinks = []collector.TraceLink{{Name: "Jaeger", URL: "http://jaeger:16686/trace/{traceId}?uiFind={spanId}"}}
+	trace := "4bf92f3577b34da6a3ce929d0e0e4736"
+	upload(t, ts, http.MethodPost, tracer.CollectorRecordsPath, "run-1",
+		`{"uniqueId":1,"functionName":"handle","route":"GET /orders","duration":2000,"externalTraceId":"`+trace+`","externalSpanId":"00f067aa0ba902b7"}`+"\n"+
+			`{"uniqueId":2,"functionName":"load","callerId":1,"duration":1000,"externalTraceId":"`+trace+`","externalSpanId":"00f067aa0ba902b7"}`+"\n")
+
+	resp, err := http.Get(ts.URL + "/runs/run-1/")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	body := string(data)
+	link := `href="http://jaeger:16686/trace/` + trace + `?uiFind=00f067aa0ba902b7">Jaeger</a>`
+	if !strings.Contains(body, link) || strings.Count(body, "<code>"+trace+"</code>") != 1 {
+		t.Errorf("Expected one external trace linked to Jaeger, got: %s", body)
+	}
+}
+
+func TestCollectorServesThemeStylesheet(t *testing.T) {
+	server, ts := newServer(t)
+	resp, err := http.Get(ts.URL + "/theme.css")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/css") || !strings.Contains(string(data), "--background: #ffffff;") {
+		t.Errorf("Expected the default palette as CSS, got %q: %s", resp.Header.Get("Content-Type"), data)
+	}
+
+	if server.Palette, err = theme.New(theme.Dark, map[string]string{"error": "#aa0000"}); err != nil {
+		t.Fatalf("theme.New returned error: %v", err)
+	}
+	resp, err = http.Get(ts.URL + "/theme.css")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	data, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(data), "--background: #1e1e1e;") || !strings.Contains(string(data), "--error: #aa0000;") {
+		t.Errorf("Expected the dark palette with its override, got: %s", data)
+	}
+	resp, err = http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	data, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(data), `<link rel="stylesheet" href="/theme.css">`) {
+		t.Errorf("Expected the pages to load the theme, got: %s", data)
+	}
+}
+
+func TestCollectorFiltersRunPagesByStatus(t *testing.T) {
+	_, ts := newServer(t)
+	upload(t, ts, http.MethodPost, tracer.CollectorRecordsPath, "run-1",
+		`{"uniqueId":1,"functionName":"main.handle","duration":2000,"status":"ok","httpStatus":200}`+"\n"+
+			`{"uniqueId":2,"functionName":"main.handle","duration":9000,"status":"error","httpStatus":503}`+"\n"+
+			`{"uniqueId":3,"functionName":"main.helper","duration":1000}`+"\n")
+
+	get := func(path string) string {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	body := get("/runs/run-1/")
+	row := `<tr class="error"><td>main.handle</td><td class="n">2</td><td class="n">11µs</td><td class="n">5.5µs</td><td class="n">9µs</td><td class="n">0</td><td class="n">0</td><td class="n">1</td><td class="n">2µs</td><td class="n">9µs</td></tr>`
+	if !strings.Contains(body, row) || !strings.Contains(body, "main.helper") {
+		t.Errorf("Expected main.handle with one error and the means per status, got: %s", body)
+	}
+	body = get("/runs/run-1/?status=error")
+	if !strings.Contains(body, "1 records") || strings.Contains(body, "main.helper") {
+		t.Errorf("Expected only the failed call with ?status=error, got: %s", body)
+	}
+}
+
+func TestCollectorNotifiesAlertsOncePerRun(t *testing.T) {
+	server, ts := newServer(t)
+	rule, err := alert.Compile("panics", "panicked more than 1 times")
+	if err != nil {
+		t.Fatalf("Compile returned error: %v", err)
+	}
+	server.Alerts = []*alert.Rule{rule}
+	fired := make(chan string, 10)
+	server.Notify = func(run string, a alert.Alert) { fired <- fmt.Sprintf("%s %s %d", run, a.Rule, a.Matches) }
+	expectAlert := func(want string) {
+		t.Helper()
+		select {
+		case got := <-fired:
+			if got != want {
+				t.Errorf("Expected alert %q, got %q", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected alert %q to be notified", want)
+		}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case got := <-fired:
+			t.Errorf("Expected no notification, got %q", got)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	panicked := func(id int) string {
+		return fmt.Sprintf(`{"uniqueId":%d,"functionName":"main.work","panicValue":"boom"}`, id) + "\n"
+	}
+
+	// The matches add up across the uploads of a run.
+	upload(t, ts, http.MethodPost, tracer.CollectorRecordsPath, "run-1", panicked(1))
+	expectNone()
+	upload(t, ts, http.MethodPost, tracer.CollectorRecordsPath, "run-1", panicked(2))
+	expectAlert("run-1 panics 2")
+	upload(t, ts, http.MethodPost, tracer.CollectorRecordsPath, "run-1", panicked(3))
+	if status := upload(t, ts, http.MethodPut, tracer.CollectorMetadataPath, "run-1", `{"runId":"run-1","records":3}`); status != http.StatusNoContent {
+		t.Fatalf("Expected metadata to be accepted, got status %d", status)
+	}
+	expectNone()
+
+	// A pruned run is tallied afresh.
+	upload(t, ts, http.MethodPost, tracer.CollectorRecordsPath, "run-2", `{"uniqueId":1}`+"\n")
+	old := time.Now().Add(-48 * time.Hour)
+	dir := filepath.Join(server.Dir(), "run-1")
+	for _, file := range []string{"trace.jsonl", "run.json", ""} {
+		os.Chtimes(filepath.Join(dir, file), old, old)
+	}
+	server.Retention = collector.Retention{MaxAge: 24 * time.Hour}
+	if removed, err := server.Prune(time.Now()); err != nil || len(removed) != 1 {
+		t.Fatalf("Expected run-1 to be pruned, got %+v removed (%v)", removed, err)
+	}
+	upload(t, ts, http.MethodPost, tracer.CollectorRecordsPath, "run-1", panicked(1)+panicked(2))
+	expectAlert("run-1 panics 2")
+}
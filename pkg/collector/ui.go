@@ -0,0 +1,403 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/runs"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// topFunctions is the number of functions listed on a run's page.
+const topFunctions = 25
+
+// topExternalTraces is the number of external traces listed on a run's page.
+const topExternalTraces = 25
+
+// TraceLink links the external traces of records to a trace system such as Jaeger or Grafana
+// Tempo. URL is a template in which {traceId} and {spanId} are replaced by the IDs.
+type TraceLink struct {
+	Name string
+	URL  string
+}
+
+// Expand returns the URL of the trace and span.
+func (l TraceLink) Expand(traceID, spanID string) string {
+	return strings.NewReplacer("{traceId}", traceID, "{spanId}", spanID).Replace(l.URL)
+}
+
+// externalTrace is one line of the external traces table on a run's page: the outermost call
+// that served a request of the trace.
+type externalTrace struct {
+	TraceID  string
+	SpanID   string
+	Function string
+	Route    string
+	Started  time.Time
+	Duration time.Duration
+	Links    []externalLink
+}
+
+type externalLink struct {
+	Name string
+	URL  string
+}
+
+// functionRow is one line of the functions table on a run's page.
+type functionRow struct {
+	Name    string
+	Calls   int
+	Total   time.Duration
+	Mean    time.Duration
+	Max     time.Duration
+	Panics  int
+	SLOMiss int
+	// Calls with a span status and their mean durations, to compare failing with succeeding calls.
+	OK        int
+	Errors    int
+	OKMean    time.Duration
+	ErrorMean time.Duration
+}
+
+var pageTemplates = template.Must(template.New("").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.}} - tracewrap collector</title>
+<link rel="stylesheet" href="/theme.css">
+<style>
+body { font-family: sans-serif; margin: 2em; background: var(--background); color: var(--text); }
+a { color: var(--dynamic); }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 0.8em; border-bottom: 1px solid var(--grid); text-align: left; }
+td.n { text-align: right; font-variant-numeric: tabular-nums; }
+tr.error td { background: var(--error); }
+</style></head><body>
+{{end}}
+{{define "index"}}{{template "header" "Runs"}}
+<h1>Runs</h1>
+{{if .}}<table>
+<tr><th>Run</th><th>Host</th><th>Started</th><th>Last upload</th><th>Records</th><th>Command</th></tr>
+{{range .}}<tr>
+<td><a href="/runs/{{.ID}}/">{{.ID}}</a>{{if .Latest}} (latest){{end}}</td>
+<td>{{with .Metadata}}{{.Hostname}}{{end}}</td>
+<td>{{.StartedAt.Format "2006-01-02 15:04:05"}}</td>
+<td>{{.UpdatedAt.Format "2006-01-02 15:04:05"}}</td>
+<td class="n">{{with .Metadata}}{{.Records}}{{end}}</td>
+<td>{{with .Metadata}}{{range .Args}}{{.}} {{end}}{{end}}</td>
+</tr>{{end}}
+</table>
+{{if gt (len .) 1}}<form action="/compare"><p>Compare
+<select name="current">{{range .}}<option>{{.ID}}</option>{{end}}</select> with
+<select name="base">{{range $i, $run := .}}<option{{if eq $i 1}} selected{{end}}>{{$run.ID}}</option>{{end}}</select>
+<button>Compare</button></p></form>{{end}}
+{{else}}<p>No runs have been uploaded yet.</p>{{end}}
+</body></html>
+{{end}}
+{{define "run"}}{{template "header" .Run.ID}}
+<p><a href="/">All runs</a></p>
+<h1>{{.Run.ID}}</h1>
+{{with .Run.Metadata}}<p>
+{{if .Hostname}}Host {{.Hostname}}, {{end}}PID {{.PID}}, {{.GoVersion}}, tracewrap {{.TracewrapVersion}}<br>
+Started {{.StartedAt.Format "2006-01-02 15:04:05.000"}}, ended {{.EndedAt.Format "2006-01-02 15:04:05.000"}}
+{{with .Build}}<br>Build {{.Module}}{{if .Version}} {{.Version}}{{end}}{{with .Revision}}, revision <code>{{.}}</code>{{end}}{{if .Modified}} (modified){{end}}{{end}}
+</p>{{else}}<p>The run has not uploaded its metadata yet.</p>{{end}}
+<p>{{.Records}} records: <a href="trace.jsonl">trace.jsonl</a>{{if .Run.Metadata}}, <a href="run.json">run.json</a>{{end}}</p>
+<p>Status: {{if .Status}}<a href="?">all</a>{{else}}all{{end}}{{range .Statuses}} | {{if eq . $.Status}}{{.}}{{else}}<a href="?status={{.}}">{{.}}</a>{{end}}{{end}}</p>
+{{if .Functions}}<h2>Functions by total time</h2>
+<table>
+<tr><th>Function</th><th>Calls</th><th>Total</th><th>Mean</th><th>Max</th><th>Panics</th><th>SLO misses</th><th>Errors</th><th>OK mean</th><th>Error mean</th></tr>
+{{range .Functions}}<tr{{if .Errors}} class="error"{{end}}><td>{{.Name}}</td><td class="n">{{.Calls}}</td><td class="n">{{.Total}}</td><td class="n">{{.Mean}}</td><td class="n">{{.Max}}</td><td class="n">{{.Panics}}</td><td class="n">{{.SLOMiss}}</td><td class="n">{{if or .OK .Errors}}{{.Errors}}{{end}}</td><td class="n">{{if .OK}}{{.OKMean}}{{end}}</td><td class="n">{{if .Errors}}{{.ErrorMean}}{{end}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Traces}}<h2>External traces by duration</h2>
+<table>
+<tr><th>Trace</th><th>Function</th><th>Route</th><th>Started</th><th>Duration</th><th>Links</th></tr>
+{{range .Traces}}<tr><td><code>{{.TraceID}}</code></td><td>{{.Function}}</td><td>{{.Route}}</td><td>{{.Started.Format "15:04:05.000"}}</td><td class="n">{{.Duration}}</td><td>{{range .Links}}<a href="{{.URL}}">{{.Name}}</a> {{end}}</td></tr>
+{{end}}</table>{{end}}
+</body></html>
+{{end}}
+`))
+
+// listRuns returns the stored runs, newest first.
+func (s *Server) listRuns() ([]runs.Run, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list, err := runs.List(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list, nil
+}
+
+// handleRunList serves the stored runs as JSON.
+func (s *Server) handleRunList(w http.ResponseWriter, r *http.Request) {
+	list, err := s.listRuns()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []runs.Run{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// handleIndex serves the page listing the stored runs.
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	list, err := s.listRuns()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderPage(w, "index", list)
+}
+
+// handleRun serves the page of one run.
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	var page struct {
+		Run       runs.Run
+		Records   int
+		Functions []functionRow
+		Traces    []externalTrace
+		Status    string   // The status filter from the query, "" for all calls.
+		Statuses  []string // The statuses to filter by.
+	}
+	page.Status = r.URL.Query().Get("status")
+	page.Statuses = []string{tracer.StatusOK, tracer.StatusError}
+	err := s.viewRun(w, r, func(dir string) error {
+		list, err := runs.List(s.dir)
+		if err != nil {
+			return err
+		}
+		for _, run := range list {
+			if run.Dir == dir {
+				page.Run = run
+			}
+		}
+		records, err := tracer.ReadTraceFile(filepath.Join(dir, "trace.jsonl"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if records, err = analysis.FilterStatus(records, page.Status); err != nil {
+			return fmt.Errorf("invalid status filter: %v", err)
+		}
+		page.Records = len(records)
+		page.Functions = summarizeFunctions(records, topFunctions)
+		page.Traces = externalTraces(records, s.TraceLinks, topExternalTraces)
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if page.Run.ID != "" {
+		renderPage(w, "run", page)
+	}
+}
+
+// handleRunFile serves a run's trace.jsonl or run.json.
+func (s *Server) handleRunFile(w http.ResponseWriter, r *http.Request) {
+	file := r.PathValue("file")
+	if file != "trace.jsonl" && file != "run.json" {
+		http.NotFound(w, r)
+		return
+	}
+	err := s.viewRun(w, r, func(dir string) error {
+		http.ServeFile(w, r, filepath.Join(dir, file))
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleCompare serves the page comparing the runs named by the base and current parameters.
+func (s *Server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	records := make(map[string][]tracer.TraceRecord, 2)
+	for _, param := range []string{"base", "current"} {
+		id := r.URL.Query().Get(param)
+		found, err := s.withRun(id, func(dir string) error {
+			recs, err := tracer.ReadTraceFile(filepath.Join(dir, "trace.jsonl"))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			records[param] = recs
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, fmt.Sprintf("unknown %s run %q", param, id), http.StatusNotFound)
+			return
+		}
+	}
+	base, current := r.URL.Query().Get("base"), r.URL.Query().Get("current")
+	tree := analysis.CompareCallTrees(records["base"], records["current"])
+	deltas := analysis.Compare(analysis.Aggregate(records["base"]), analysis.Aggregate(records["current"]))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := analysis.WriteComparisonHTML(w, current+" vs "+base+" - tracewrap collector", base, current, tree, deltas, s.Palette.OrDefault()); err != nil {
+		fmt.Fprintf(w, "<p>Error rendering page: %v</p>", template.HTMLEscapeString(err.Error()))
+	}
+}
+
+// handleTheme serves the palette of the web UI as CSS custom properties.
+func (s *Server) handleTheme(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	fmt.Fprintln(w, s.Palette.OrDefault().CSS())
+}
+
+// viewRun calls fn with the directory of the run named in r while no upload to it is in progress.
+// It responds with 404 for unknown runs, without calling fn.
+func (s *Server) viewRun(w http.ResponseWriter, r *http.Request, fn func(dir string) error) error {
+	found, err := s.withRun(r.PathValue("id"), fn)
+	if !found {
+		http.NotFound(w, r)
+	}
+	return err
+}
+
+// withRun calls fn with the directory of the run id while no upload to it is in progress.
+//
+// Parameters:
+//   - id (string): the run ID.
+//   - fn (func(dir string) error): the function to call.
+//
+// Returns:
+//   - bool: false for unknown runs, for which fn is not called.
+//   - error: the error fn returned.
+func (s *Server) withRun(id string, fn func(dir string) error) (bool, error) {
+	if !runIDPattern.MatchString(id) || id == tracer.LatestRun {
+		return false, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dir := filepath.Join(s.dir, id)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return false, nil
+	}
+	s.locksMu.Lock()
+	lock := s.locks[id]
+	s.locksMu.Unlock()
+	if lock != nil {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+	return true, fn(dir)
+}
+
+// summarizeFunctions aggregates records per function.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records.
+//   - top (int): the maximum number of functions returned.
+//
+// Returns:
+//   - []functionRow: the functions with the highest total time, highest first.
+func summarizeFunctions(records []tracer.TraceRecord, top int) []functionRow {
+	byName := make(map[string]*functionRow)
+	for _, rec := range records {
+		row, ok := byName[rec.FunctionName]
+		if !ok {
+			row = &functionRow{Name: rec.FunctionName}
+			byName[rec.FunctionName] = row
+		}
+		row.Calls++
+		row.Total += rec.Duration
+		row.Max = max(row.Max, rec.Duration)
+		if rec.PanicValue != nil {
+			row.Panics++
+		}
+		if rec.SLOViolated {
+			row.SLOMiss++
+		}
+		switch rec.Status {
+		case tracer.StatusOK:
+			row.OK++
+			row.OKMean += rec.Duration
+		case tracer.StatusError:
+			row.Errors++
+			row.ErrorMean += rec.Duration
+		}
+	}
+	rows := make([]functionRow, 0, len(byName))
+	for _, row := range byName {
+		row.Mean = row.Total / time.Duration(row.Calls)
+		if row.OK > 0 {
+			row.OKMean /= time.Duration(row.OK)
+		}
+		if row.Errors > 0 {
+			row.ErrorMean /= time.Duration(row.Errors)
+		}
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Total != rows[j].Total {
+			return rows[i].Total > rows[j].Total
+		}
+		return rows[i].Name < rows[j].Name
+	})
+	if len(rows) > top {
+		rows = rows[:top]
+	}
+	return rows
+}
+
+// externalTraces lists the calls that adopted an external trace from a request's traceparent
+// header: the records carrying an external trace ID their caller does not.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the trace records.
+//   - links ([]TraceLink): the links rendered for each trace.
+//   - top (int): the maximum number of traces returned.
+//
+// Returns:
+//   - []externalTrace: the longest calls first.
+func externalTraces(records []tracer.TraceRecord, links []TraceLink, top int) []externalTrace {
+	traceOf := make(map[int64]string, len(records))
+	for _, rec := range records {
+		traceOf[rec.UniqueID] = rec.ExternalTraceID
+	}
+	var traces []externalTrace
+	for _, rec := range records {
+		if rec.ExternalTraceID == "" || traceOf[rec.CallerID] == rec.ExternalTraceID {
+			continue
+		}
+		t := externalTrace{
+			TraceID:  rec.ExternalTraceID,
+			SpanID:   rec.ExternalSpanID,
+			Function: rec.FunctionName,
+			Route:    rec.Route,
+			Started:  rec.EntryTime,
+			Duration: rec.Duration,
+		}
+		for _, link := range links {
+			t.Links = append(t.Links, externalLink{Name: link.Name, URL: link.Expand(t.TraceID, t.SpanID)})
+		}
+		traces = append(traces, t)
+	}
+	sort.SliceStable(traces, func(i, j int) bool { return traces[i].Duration > traces[j].Duration })
+	if len(traces) > top {
+		traces = traces[:top]
+	}
+	return traces
+}
+
+// renderPage writes the named page template.
+func renderPage(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pageTemplates.ExecuteTemplate(w, name, data); err != nil {
+		fmt.Fprintf(w, "<p>Error rendering page: %v</p>", template.HTMLEscapeString(err.Error()))
+	}
+}
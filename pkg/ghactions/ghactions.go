@@ -0,0 +1,136 @@
+// Package ghactions writes GitHub Actions workflow commands, so findings of tracewrap commands
+// run in a workflow show up as annotations on the lines of the pull request they concern.
+package ghactions
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mwiater/tracewrap/pkg/instrument"
+)
+
+// Levels of an Annotation.
+const (
+	Notice  = "notice"
+	Warning = "warning"
+	Error   = "error"
+)
+
+// Enabled reports whether the process runs in a GitHub Actions workflow, which sets
+// GITHUB_ACTIONS to "true".
+func Enabled() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+// Annotation is a finding shown by GitHub on a line of a file, or on the workflow run without one.
+type Annotation struct {
+	Level   string // Notice, Warning or Error.
+	File    string // Relative to the repository root, or empty.
+	Line    int
+	EndLine int
+	Title   string
+	Message string
+}
+
+// Write writes a as a workflow command line, such as "::error file=main.go,line=3,title=...::msg".
+//
+// Parameters:
+//   - w (io.Writer): the destination, the standard output or error of the step.
+//   - a (Annotation): the annotation; an empty level means Warning.
+//
+// Returns:
+//   - error: an error if writing fails.
+func Write(w io.Writer, a Annotation) error {
+	level := a.Level
+	if level == "" {
+		level = Warning
+	}
+	var props []string
+	if a.File != "" {
+		props = append(props, "file="+escapeProperty(a.File))
+		if a.Line > 0 {
+			props = append(props, fmt.Sprintf("line=%d", a.Line))
+		}
+		if a.EndLine > a.Line {
+			props = append(props, fmt.Sprintf("endLine=%d", a.EndLine))
+		}
+	}
+	if a.Title != "" {
+		props = append(props, "title="+escapeProperty(a.Title))
+	}
+	cmd := "::" + level
+	if len(props) > 0 {
+		cmd += " " + strings.Join(props, ",")
+	}
+	_, err := fmt.Fprintf(w, "%s::%s\n", cmd, escapeData(a.Message))
+	return err
+}
+
+// escapeData escapes the message of a workflow command, which ends at a line break.
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property value, which also ends at a comma or colon.
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// Locator finds the source lines of traced functions through the inventory of instrumentation.
+type Locator struct {
+	functions map[string]instrument.Function
+	root      string // The directory the inventory's files are relative to.
+	workspace string // The repository root annotation files are relative to.
+}
+
+// NewLocator returns a locator of the functions of inv. Files are made relative to workspace, the
+// repository root; GitHub Actions sets GITHUB_WORKSPACE to it.
+//
+// Parameters:
+//   - inv (instrument.Inventory): the inventory; one without a root is taken to be relative to
+//     the current directory.
+//   - workspace (string): the repository root, or "" for the current directory.
+//
+// Returns:
+//   - *Locator: the locator.
+func NewLocator(inv instrument.Inventory, workspace string) *Locator {
+	l := &Locator{functions: make(map[string]instrument.Function, len(inv.Functions)), root: inv.Root, workspace: workspace}
+	for _, fn := range inv.Functions {
+		if _, ok := l.functions[fn.Name]; !ok {
+			l.functions[fn.Name] = fn
+		}
+	}
+	return l
+}
+
+// Locate sets the file and lines of a to those of the function name. A nil locator, a function
+// missing from the inventory or a file outside the workspace leave a unchanged.
+//
+// Parameters:
+//   - a (*Annotation): the annotation.
+//   - name (string): the function name, as trace records carry it.
+func (l *Locator) Locate(a *Annotation, name string) {
+	if l == nil {
+		return
+	}
+	fn, ok := l.functions[name]
+	if !ok {
+		return
+	}
+	path, err := filepath.Abs(filepath.Join(l.root, filepath.FromSlash(fn.File)))
+	if err != nil {
+		return
+	}
+	workspace, err := filepath.Abs(l.workspace)
+	if err != nil {
+		return
+	}
+	rel, err := filepath.Rel(workspace, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return
+	}
+	a.File, a.Line, a.EndLine = filepath.ToSlash(rel), fn.Line, fn.EndLine
+}
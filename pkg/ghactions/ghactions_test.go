@@ -0,0 +1,76 @@
+package ghactions_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/pkg/ghactions"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+)
+
+func TestWrite(t *testing.T) {
+	var b strings.Builder
+	err := ghactions.Write(&b, ghactions.Annotation{
+		Level:   ghactions.Error,
+		File:    "internal/a,b.go",
+		Line:    3,
+		EndLine: 9,
+		Title:   "Panic: main.parse",
+		Message: "100% broken\nsee trace",
+	})
+	if err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	want := "::error file=internal/a%2Cb.go,line=3,endLine=9,title=Panic%3A main.parse::100%25 broken%0Asee trace\n"
+	if b.String() != want {
+		t.Errorf("Expected %q, got %q", want, b.String())
+	}
+
+	b.Reset()
+	if err := ghactions.Write(&b, ghactions.Annotation{Message: "budget exceeded"}); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if want := "::warning::budget exceeded\n"; b.String() != want {
+		t.Errorf("Expected %q, got %q", want, b.String())
+	}
+}
+
+func TestLocate(t *testing.T) {
+	dir, err := os.MkdirTemp("", "tracewrap-ghactions-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	inv := instrument.Inventory{
+		Root: filepath.Join(dir, "services", "api"),
+		Functions: []instrument.Function{
+			{Name: "handle", File: "internal/http/handler.go", Line: 12, EndLine: 30},
+		},
+	}
+	l := ghactions.NewLocator(inv, dir)
+
+	var a ghactions.Annotation
+	l.Locate(&a, "handle")
+	if a.File != "services/api/internal/http/handler.go" || a.Line != 12 || a.EndLine != 30 {
+		t.Errorf("Unexpected location: %+v", a)
+	}
+
+	a = ghactions.Annotation{}
+	l.Locate(&a, "missing")
+	if a.File != "" || a.Line != 0 {
+		t.Errorf("Expected no location for a function missing from the inventory, got %+v", a)
+	}
+
+	// Files outside the workspace cannot be annotated.
+	a = ghactions.Annotation{}
+	ghactions.NewLocator(inv, filepath.Join(dir, "other")).Locate(&a, "handle")
+	if a.File != "" {
+		t.Errorf("Expected no location outside the workspace, got %+v", a)
+	}
+
+	var nilLocator *ghactions.Locator
+	nilLocator.Locate(&a, "handle")
+}
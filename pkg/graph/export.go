@@ -0,0 +1,206 @@
+package graph
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Formats of Write.
+const (
+	DOT       = "dot"       // Graphviz, see WriteDOT.
+	GraphML   = "graphml"   // GraphML, for Gephi, Cytoscape and yEd, see WriteGraphML.
+	JSONGraph = "jsongraph" // JSON Graph Format, see WriteJSONGraph.
+)
+
+// Kinds of edges, as exported by WriteGraphML and WriteJSONGraph.
+const (
+	KindExercised   = "exercised"   // A static call the run took.
+	KindUnexercised = "unexercised" // A static call the run never took.
+	KindDynamic     = "dynamic"     // A call the run took without a static call, e.g. through an interface.
+	KindRemote      = "remote"      // A call from one service to another, see BuildServices.
+)
+
+// Kind returns KindExercised, KindUnexercised, KindDynamic or KindRemote for e.
+func (e Edge) Kind() string {
+	switch {
+	case e.Remote:
+		return KindRemote
+	case !e.Static:
+		return KindDynamic
+	case e.Calls == 0:
+		return KindUnexercised
+	}
+	return KindExercised
+}
+
+// Write writes the part of g drawn in mode in the given format. Clusters apply to DOT only; the
+// other formats carry each function's service, package and file as attributes to group by instead.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - g (Graph): the graph from Build.
+//   - mode (string): Static, Dynamic or Overlay.
+//   - format (string): DOT, GraphML or JSONGraph; "" means DOT.
+//   - clusters (string): the clustering of WriteDOT.
+//
+// Returns:
+//   - error: an error for an unknown mode or format, or if writing fails.
+func Write(w io.Writer, g Graph, mode, format, clusters string) error {
+	switch format {
+	case "", DOT:
+		return WriteDOT(w, g, mode, clusters)
+	case GraphML:
+		return WriteGraphML(w, g, mode)
+	case JSONGraph:
+		return WriteJSONGraph(w, g, mode)
+	}
+	return fmt.Errorf("unknown format %q; use %s, %s or %s", format, DOT, GraphML, JSONGraph)
+}
+
+// graphMLKeys declares the attributes of the GraphML nodes and edges.
+const graphMLKeys = `  <key id="footer" for="graph" attr.name="footer" attr.type="string"/>
+  <key id="label" for="node" attr.name="label" attr.type="string"/>
+  <key id="service" for="node" attr.name="service" attr.type="string"/>
+  <key id="package" for="node" attr.name="package" attr.type="string"/>
+  <key id="file" for="node" attr.name="file" attr.type="string"/>
+  <key id="ncalls" for="node" attr.name="calls" attr.type="int"/>
+  <key id="ntotal" for="node" attr.name="total_ns" attr.type="long"/>
+  <key id="kind" for="edge" attr.name="kind" attr.type="string"/>
+  <key id="static" for="edge" attr.name="static" attr.type="boolean"/>
+  <key id="ecalls" for="edge" attr.name="calls" attr.type="int"/>
+  <key id="etotal" for="edge" attr.name="total_ns" attr.type="long"/>
+  <key id="uninstrumented" for="edge" attr.name="uninstrumented" attr.type="int"/>
+`
+
+// WriteGraphML writes the part of g drawn in mode as a directed GraphML graph, with g.Footer as its
+// footer attribute, lines separated by newlines. Nodes carry their
+// label, service, package, file, calls and total time in nanoseconds; edges their kind (see Edge.Kind),
+// whether they are static, their calls, total time and calls made through uninstrumented code.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - g (Graph): the graph from Build.
+//   - mode (string): Static, Dynamic or Overlay.
+//
+// Returns:
+//   - error: an error for an unknown mode or if writing fails.
+func WriteGraphML(w io.Writer, g Graph, mode string) error {
+	if err := checkMode(mode); err != nil {
+		return err
+	}
+	g = g.view(mode)
+	escape := func(s string) string {
+		var sb strings.Builder
+		xml.EscapeText(&sb, []byte(s))
+		return sb.String()
+	}
+	var sb strings.Builder
+	sb.WriteString(xml.Header)
+	sb.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	sb.WriteString(graphMLKeys)
+	fmt.Fprintf(&sb, "  <graph id=%q edgedefault=\"directed\">\n", "callgraph-"+mode)
+	if len(g.Footer) > 0 {
+		fmt.Fprintf(&sb, "    <data key=\"footer\">%s</data>\n", escape(strings.Join(g.Footer, "\n")))
+	}
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&sb, "    <node id=\"%s\">\n", escape(n.Name))
+		fmt.Fprintf(&sb, "      <data key=\"label\">%s</data>\n", escape(n.Name))
+		if n.Service != "" {
+			fmt.Fprintf(&sb, "      <data key=\"service\">%s</data>\n", escape(n.Service))
+		}
+		if n.Package != "" {
+			fmt.Fprintf(&sb, "      <data key=\"package\">%s</data>\n", escape(n.Package))
+			fmt.Fprintf(&sb, "      <data key=\"file\">%s</data>\n", escape(n.File))
+		}
+		fmt.Fprintf(&sb, "      <data key=\"ncalls\">%d</data>\n", n.Calls)
+		fmt.Fprintf(&sb, "      <data key=\"ntotal\">%d</data>\n", n.Total.Nanoseconds())
+		sb.WriteString("    </node>\n")
+	}
+	for i, e := range g.Edges {
+		fmt.Fprintf(&sb, "    <edge id=\"e%d\" source=\"%s\" target=\"%s\">\n", i, escape(e.Caller), escape(e.Callee))
+		fmt.Fprintf(&sb, "      <data key=\"kind\">%s</data>\n", e.Kind())
+		fmt.Fprintf(&sb, "      <data key=\"static\">%t</data>\n", e.Static)
+		fmt.Fprintf(&sb, "      <data key=\"ecalls\">%d</data>\n", e.Calls)
+		fmt.Fprintf(&sb, "      <data key=\"etotal\">%d</data>\n", e.Total.Nanoseconds())
+		if e.Uninstrumented > 0 {
+			fmt.Fprintf(&sb, "      <data key=\"uninstrumented\">%d</data>\n", e.Uninstrumented)
+		}
+		sb.WriteString("    </edge>\n")
+	}
+	sb.WriteString("  </graph>\n</graphml>\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// jsonGraph is a document of the JSON Graph Format, version 2 (https://jsongraphformat.info).
+type jsonGraph struct {
+	Graph struct {
+		ID       string                   `json:"id"`
+		Directed bool                     `json:"directed"`
+		Metadata map[string]string        `json:"metadata"`
+		Nodes    map[string]jsonGraphNode `json:"nodes"`
+		Edges    []jsonGraphEdge          `json:"edges"`
+	} `json:"graph"`
+}
+
+type jsonGraphNode struct {
+	Label    string         `json:"label"`
+	Metadata map[string]any `json:"metadata"`
+}
+
+type jsonGraphEdge struct {
+	Source   string         `json:"source"`
+	Target   string         `json:"target"`
+	Relation string         `json:"relation"`
+	Metadata map[string]any `json:"metadata"`
+}
+
+// WriteJSONGraph writes the part of g drawn in mode in the JSON Graph Format, with the same
+// attributes as WriteGraphML in the metadata of the graph, nodes and edges. The relation of an edge
+// is its kind.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - g (Graph): the graph from Build.
+//   - mode (string): Static, Dynamic or Overlay.
+//
+// Returns:
+//   - error: an error for an unknown mode or if writing fails.
+func WriteJSONGraph(w io.Writer, g Graph, mode string) error {
+	if err := checkMode(mode); err != nil {
+		return err
+	}
+	g = g.view(mode)
+	var doc jsonGraph
+	doc.Graph.ID = "callgraph-" + mode
+	doc.Graph.Directed = true
+	doc.Graph.Metadata = map[string]string{"mode": mode}
+	if len(g.Footer) > 0 {
+		doc.Graph.Metadata["footer"] = strings.Join(g.Footer, "\n")
+	}
+	doc.Graph.Nodes = make(map[string]jsonGraphNode, len(g.Nodes))
+	doc.Graph.Edges = []jsonGraphEdge{}
+	for _, n := range g.Nodes {
+		meta := map[string]any{"calls": n.Calls, "total_ns": n.Total.Nanoseconds()}
+		if n.Service != "" {
+			meta["service"] = n.Service
+		}
+		if n.Package != "" {
+			meta["package"], meta["file"] = n.Package, n.File
+		}
+		doc.Graph.Nodes[n.Name] = jsonGraphNode{Label: n.Name, Metadata: meta}
+	}
+	for _, e := range g.Edges {
+		meta := map[string]any{"static": e.Static, "calls": e.Calls, "total_ns": e.Total.Nanoseconds()}
+		if e.Uninstrumented > 0 {
+			meta["uninstrumented"] = e.Uninstrumented
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, jsonGraphEdge{Source: e.Caller, Target: e.Callee, Relation: e.Kind(), Metadata: meta})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,430 @@
+// Package graph compares the static call graph of a project, the calls its source code can make
+// as recorded in the instrumentation inventory, with the dynamic call graph of a traced run.
+// Static edges that were never exercised point at dead or untested code paths.
+package graph
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/theme"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// Modes of WriteDOT.
+const (
+	Static  = "static"  // The edges the source code can take.
+	Dynamic = "dynamic" // The edges the run took.
+	Overlay = "overlay" // Both, styled by whether they were taken.
+)
+
+// Clusterings of WriteDOT.
+const (
+	ClusterNone    = "none"    // No clusters.
+	ClusterPackage = "package" // A cluster per package.
+	ClusterFile    = "file"    // A cluster per file, nested in the cluster of its package.
+	ClusterService = "service" // A cluster per service of a graph from BuildServices.
+)
+
+// Edge is a caller/callee relationship between two functions.
+type Edge struct {
+	Caller string
+	Callee string
+	Static bool          // The caller's source calls the callee.
+	Remote bool          // The call crossed services, see BuildServices.
+	Calls  int           // The number of calls made along the edge in the run.
+	Total  time.Duration // The total duration of those calls.
+	// Uninstrumented is the number of those calls made through code that is not instrumented,
+	// such as a library calling back into the project (see tracer.TraceRecord.ViaUninstrumented).
+	Uninstrumented int
+}
+
+// Node is a function of the graph.
+type Node struct {
+	Name    string
+	Service string        // The service of a graph from BuildServices, "" otherwise.
+	Package string        // The package name from the inventory, "" if unknown.
+	File    string        // The file from the inventory, relative to the project root.
+	Calls   int           // The number of calls of the function in the run.
+	Total   time.Duration // The total duration of those calls.
+}
+
+// Graph holds the static and dynamic edges between the functions of a project.
+type Graph struct {
+	Nodes []Node // By name.
+	Edges []Edge // By caller, then callee.
+	// Footer describes where the graph comes from, such as the tracer.RunMetadata.Summary of the
+	// runs it shows, a line each. Writers put it under the graph or in its attributes.
+	Footer []string
+	// Palette colors the graph WriteDOT draws; the zero Palette means theme.Default.
+	Palette theme.Palette
+}
+
+// Build merges the static calls of the functions in inv with the calls in records. Static calls
+// to names that are not instrumented functions, such as fmt.Println, are dropped. Functions are
+// identified by name, as in trace records, so functions sharing a name share a node. Static calls
+// are matched by declared name, so a call of Run is an edge to every instrumented Run, whichever
+// package or type declares it.
+//
+// Parameters:
+//   - inv (instrument.Inventory): the inventory written during instrumentation.
+//   - records ([]tracer.TraceRecord): the trace records of the run, or nil for the static graph.
+//
+// Returns:
+//   - Graph: the merged graph.
+func Build(inv instrument.Inventory, records []tracer.TraceRecord) Graph {
+	nodes := make(map[string]*Node)
+	node := func(name string) *Node {
+		n, ok := nodes[name]
+		if !ok {
+			n = &Node{Name: name}
+			nodes[name] = n
+		}
+		return n
+	}
+	type key struct{ caller, callee string }
+	edges := make(map[key]*Edge)
+	edge := func(caller, callee string) *Edge {
+		e, ok := edges[key{caller, callee}]
+		if !ok {
+			e = &Edge{Caller: caller, Callee: callee}
+			edges[key{caller, callee}] = e
+		}
+		return e
+	}
+
+	declared := make(map[string][]string)
+	for _, fn := range inv.Functions {
+		if n := node(fn.Name); n.File == "" {
+			n.Package, n.File = fn.Package, fn.File
+		}
+		decl := fn.Func
+		if decl == "" {
+			decl = fn.Name
+		}
+		declared[decl] = append(declared[decl], fn.Name)
+	}
+	for _, fn := range inv.Functions {
+		for _, callee := range fn.Calls {
+			for _, name := range declared[callee] {
+				edge(fn.Name, name).Static = true
+			}
+		}
+	}
+	names := make(map[int64]string, len(records))
+	for _, rec := range records {
+		names[rec.UniqueID] = rec.FunctionName
+	}
+	for _, rec := range records {
+		n := node(rec.FunctionName)
+		n.Calls++
+		n.Total += rec.Duration
+		if caller, ok := names[rec.CallerID]; ok && rec.CallerID != 0 {
+			e := edge(caller, rec.FunctionName)
+			e.Calls++
+			e.Total += rec.Duration
+			if rec.ViaUninstrumented {
+				e.Uninstrumented++
+			}
+		}
+	}
+
+	var g Graph
+	for _, n := range nodes {
+		g.Nodes = append(g.Nodes, *n)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].Name < g.Nodes[j].Name })
+	for _, e := range edges {
+		g.Edges = append(g.Edges, *e)
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].Caller != g.Edges[j].Caller {
+			return g.Edges[i].Caller < g.Edges[j].Caller
+		}
+		return g.Edges[i].Callee < g.Edges[j].Callee
+	})
+	return g
+}
+
+// Unexercised returns the static edges no call was recorded along.
+//
+// Returns:
+//   - []Edge: the edges, by caller, then callee.
+func (g Graph) Unexercised() []Edge {
+	var out []Edge
+	for _, e := range g.Edges {
+		if e.Static && e.Calls == 0 {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// checkMode returns an error unless mode is Static, Dynamic or Overlay.
+func checkMode(mode string) error {
+	if mode != Static && mode != Dynamic && mode != Overlay {
+		return fmt.Errorf("unknown mode %q; use %s, %s or %s", mode, Static, Dynamic, Overlay)
+	}
+	return nil
+}
+
+// view returns the part of g drawn in mode: the static edges in static mode, and in dynamic mode
+// the edges taken and the functions that ran or are at either end of them.
+func (g Graph) view(mode string) Graph {
+	v := Graph{Footer: g.Footer, Palette: g.Palette}
+	used := make(map[string]bool)
+	for _, e := range g.Edges {
+		if mode == Static && !e.Static || mode == Dynamic && e.Calls == 0 {
+			continue
+		}
+		used[e.Caller], used[e.Callee] = true, true
+		v.Edges = append(v.Edges, e)
+	}
+	for _, n := range g.Nodes {
+		if mode == Dynamic && n.Calls == 0 && !used[n.Name] {
+			continue
+		}
+		v.Nodes = append(v.Nodes, n)
+	}
+	return v
+}
+
+// cluster is a DOT subgraph cluster of the nodes of one service, package or file.
+type cluster struct {
+	key      string
+	label    string
+	service  bool     // Drawn bold, as the boundary of a service.
+	nodes    []string // Node statements.
+	parent   *cluster
+	children []*cluster
+	members  map[string]bool
+}
+
+// clusterKeys returns the package and file cluster keys of n, "" for nodes without a package.
+// Packages are keyed by directory, as packages of the same name may live in several directories.
+func clusterKeys(n Node) (pkg, file string) {
+	if n.Package == "" {
+		return "", ""
+	}
+	return path.Dir(n.File), n.File
+}
+
+// packageLabel names the package cluster of the nodes in dir, adding the directory when the
+// package name does not follow from it, as for main packages under cmd/.
+func packageLabel(pkg, dir string) string {
+	if dir == "." || path.Base(dir) == pkg {
+		return pkg
+	}
+	return pkg + " (" + dir + ")"
+}
+
+// clusterTime returns the time spent in the functions of members: the duration of the calls
+// entering them from outside, so calls between members are not counted twice.
+func clusterTime(g Graph, members map[string]bool) (time.Duration, int) {
+	var total time.Duration
+	calls := 0
+	for _, n := range g.Nodes {
+		if members[n.Name] {
+			total += n.Total
+			calls += n.Calls
+		}
+	}
+	for _, e := range g.Edges {
+		if members[e.Caller] && members[e.Callee] {
+			total -= e.Total
+		}
+	}
+	return total, calls
+}
+
+// writeCluster writes c and its children with the aggregate calls and time of their functions.
+func writeCluster(sb *strings.Builder, g Graph, c *cluster, mode, indent string) {
+	fmt.Fprintf(sb, "%ssubgraph %q {\n", indent, "cluster_"+c.key)
+	label := c.label
+	if mode != Static {
+		total, calls := clusterTime(g, c.members)
+		label = fmt.Sprintf("%s\\n%d functions, %d calls, %v", c.label, len(c.members), calls, total)
+	}
+	fmt.Fprintf(sb, "%s  label=\"%s\";\n", indent, strings.ReplaceAll(label, "\"", "\\\""))
+	if c.service {
+		fmt.Fprintf(sb, "%s  style=\"rounded,bold\";\n", indent)
+	} else {
+		fmt.Fprintf(sb, "%s  style=rounded;\n", indent)
+	}
+	sort.Slice(c.children, func(i, j int) bool { return c.children[i].key < c.children[j].key })
+	for _, child := range c.children {
+		writeCluster(sb, g, child, mode, indent+"  ")
+	}
+	for _, stmt := range c.nodes {
+		sb.WriteString(indent + stmt)
+	}
+	fmt.Fprintf(sb, "%s}\n", indent)
+}
+
+// WriteDOT writes g as a Graphviz DOT graph. Static mode draws the static edges, dynamic mode the
+// edges taken in the run, labelled with their call counts, and overlay mode all of them: taken
+// static edges solid, static edges never taken dashed, and edges taken without a static call, such
+// as calls through interfaces or function values, dotted. Calls between services are bold. Edges
+// whose calls were all made through code that is not instrumented are dashed and labelled "via
+// uninstrumented code", so a boundary of the include and exclude rules shows as such rather than
+// as a direct call; edges with some such calls count them in their label. Outside static mode,
+// functions that never ran are greyed out. Each kind of edge and node has its color of g.Palette.
+// A legend at the bottom explains the styles used, and g.Footer is written under the graph.
+//
+// With ClusterPackage or ClusterFile, functions known to the inventory are grouped in a subgraph
+// cluster per package, and per file within it, labelled outside static mode with the number of
+// functions, their calls and the time spent in them. That time counts the calls entering the
+// cluster, so a call between two of its functions is counted once. The functions of a graph from
+// BuildServices are grouped in a bold cluster per service first, which holds their package
+// clusters; ClusterService draws the service clusters only.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - g (Graph): the graph from Build or BuildServices.
+//   - mode (string): Static, Dynamic or Overlay.
+//   - clusters (string): ClusterNone, ClusterService, ClusterPackage or ClusterFile; "" means
+//     ClusterNone.
+//
+// Returns:
+//   - error: an error for an unknown mode or clustering, or if writing fails.
+func WriteDOT(w io.Writer, g Graph, mode, clusters string) error {
+	if err := checkMode(mode); err != nil {
+		return err
+	}
+	if clusters == "" {
+		clusters = ClusterNone
+	}
+	if clusters != ClusterNone && clusters != ClusterService && clusters != ClusterPackage && clusters != ClusterFile {
+		return fmt.Errorf("unknown clustering %q; use %s, %s, %s or %s", clusters, ClusterNone, ClusterService, ClusterPackage, ClusterFile)
+	}
+	var sb strings.Builder
+	sb.WriteString("digraph CallGraph {\n")
+	g = g.view(mode)
+	palette := g.Palette.OrDefault()
+	sb.WriteString(palette.DOTDefaults())
+	var tops []*cluster
+	byKey := make(map[string]*cluster)
+	// clusterFor returns the cluster of key inside parent, or at the top for a nil parent, adding it
+	// if needed.
+	clusterFor := func(parent *cluster, key, label string) *cluster {
+		c, ok := byKey[key]
+		if !ok {
+			c = &cluster{key: key, label: label, parent: parent, members: make(map[string]bool)}
+			byKey[key] = c
+			if parent == nil {
+				tops = append(tops, c)
+			} else {
+				parent.children = append(parent.children, c)
+			}
+		}
+		return c
+	}
+	for _, n := range g.Nodes {
+		attrs := ""
+		if mode != Static {
+			attrs = fmt.Sprintf(", tooltip=\"%d calls\"", n.Calls)
+			if n.Calls == 0 {
+				attrs += fmt.Sprintf(", color=%q, fontcolor=%q", palette.Idle, palette.Muted)
+			}
+		}
+		stmt := fmt.Sprintf("  %q [label=%q%s];\n", n.Name, n.Name, attrs)
+		var c *cluster
+		prefix := ""
+		if n.Service != "" && clusters != ClusterNone {
+			c = clusterFor(nil, n.Service, n.Service)
+			c.service = true
+			prefix = n.Service + ServiceSeparator
+		}
+		if dir, file := clusterKeys(n); dir != "" && (clusters == ClusterPackage || clusters == ClusterFile) {
+			c = clusterFor(c, prefix+dir, packageLabel(n.Package, dir))
+			if clusters == ClusterFile {
+				c = clusterFor(c, prefix+file, path.Base(file))
+			}
+		}
+		if c == nil {
+			sb.WriteString(stmt)
+			continue
+		}
+		c.nodes = append(c.nodes, stmt)
+		for ; c != nil; c = c.parent {
+			c.members[n.Name] = true
+		}
+	}
+	sort.Slice(tops, func(i, j int) bool { return tops[i].key < tops[j].key })
+	for _, c := range tops {
+		writeCluster(&sb, g, c, mode, "  ")
+	}
+	for _, e := range g.Edges {
+		var attrs string
+		switch {
+		case mode == Static:
+		case e.Calls == 0:
+			attrs = fmt.Sprintf(" [style=dashed, color=%q]", palette.Unexercised)
+		case e.Remote:
+			attrs = fmt.Sprintf(" [label=\"%d\", style=bold, color=%q]", e.Calls, palette.Remote)
+		case e.Uninstrumented == e.Calls:
+			attrs = fmt.Sprintf(" [label=\"%d via uninstrumented code\", style=dashed, color=%q]", e.Calls, palette.Uninstrumented)
+		case e.Uninstrumented > 0:
+			attrs = fmt.Sprintf(" [label=\"%d (%d via uninstrumented code)\"]", e.Calls, e.Uninstrumented)
+		case !e.Static && mode == Overlay:
+			attrs = fmt.Sprintf(" [label=\"%d\", style=dotted, color=%q]", e.Calls, palette.Dynamic)
+		default:
+			attrs = fmt.Sprintf(" [label=\"%d\"]", e.Calls)
+		}
+		fmt.Fprintf(&sb, "  %q -> %q%s;\n", e.Caller, e.Callee, attrs)
+	}
+	sb.WriteString(tracer.DOTLegend(legend(g, mode, palette)))
+	sb.WriteString(tracer.DOTFooter(g.Footer))
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// legend returns the legend of the styles WriteDOT draws g with in mode, for those it uses.
+func legend(g Graph, mode string, palette theme.Palette) []tracer.LegendEntry {
+	if mode == Static {
+		return []tracer.LegendEntry{{Symbol: tracer.LegendSolid, Text: "static call"}}
+	}
+	var exercised, unexercised, dynamic, remote, uninstrumented, idle bool
+	for _, e := range g.Edges {
+		switch {
+		case e.Calls == 0:
+			unexercised = true
+		case e.Remote:
+			remote = true
+		case e.Uninstrumented == e.Calls:
+			uninstrumented = true
+		case e.Uninstrumented > 0:
+			exercised = true
+		case !e.Static && mode == Overlay:
+			dynamic = true
+		default:
+			exercised = true
+		}
+	}
+	for _, n := range g.Nodes {
+		idle = idle || n.Calls == 0
+	}
+	var entries []tracer.LegendEntry
+	add := func(used bool, e tracer.LegendEntry) {
+		if used {
+			entries = append(entries, e)
+		}
+	}
+	text := "call, labelled with the number of calls"
+	if mode == Overlay {
+		text = "static call the run took, labelled with the number of calls"
+	}
+	add(exercised, tracer.LegendEntry{Symbol: tracer.LegendSolid, Text: text})
+	add(unexercised, tracer.LegendEntry{Symbol: tracer.LegendDashed, Color: palette.Unexercised, Text: "static call the run never took"})
+	add(dynamic, tracer.LegendEntry{Symbol: tracer.LegendDotted, Color: palette.Dynamic, Text: "call without a static call, e.g. through an interface"})
+	add(uninstrumented, tracer.LegendEntry{Symbol: tracer.LegendDashed, Color: palette.Uninstrumented, Text: "calls via uninstrumented code"})
+	add(remote, tracer.LegendEntry{Symbol: tracer.LegendBold, Color: palette.Remote, Text: "request to another service"})
+	add(idle, tracer.LegendEntry{Symbol: tracer.LegendFill, Color: palette.Idle, Text: "function that never ran"})
+	return entries
+}
@@ -0,0 +1,407 @@
+package graph_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/graph"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/theme"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func sampleGraph() graph.Graph {
+	inv := instrument.Inventory{Functions: []instrument.Function{
+		{Name: "main", Calls: []string{"Println", "load", "report"}},
+		{Name: "load", Calls: []string{"parse", "retry"}},
+		{Name: "parse"},
+		{Name: "retry"},
+		{Name: "report"},
+		{Name: "Handle"},
+	}}
+	records := []tracer.TraceRecord{
+		{UniqueID: 1, FunctionName: "main"},
+		{UniqueID: 2, FunctionName: "load", CallerID: 1},
+		{UniqueID: 3, FunctionName: "parse", CallerID: 2},
+		{UniqueID: 4, FunctionName: "parse", CallerID: 2},
+		{UniqueID: 5, FunctionName: "report", CallerID: 1},
+		{UniqueID: 6, FunctionName: "Handle", CallerID: 5}, // Through an interface.
+	}
+	return graph.Build(inv, records)
+}
+
+func TestBuildMergesStaticAndDynamicEdges(t *testing.T) {
+	g := sampleGraph()
+	want := []graph.Edge{
+		{Caller: "load", Callee: "parse", Static: true, Calls: 2},
+		{Caller: "load", Callee: "retry", Static: true},
+		{Caller: "main", Callee: "load", Static: true, Calls: 1},
+		{Caller: "main", Callee: "report", Static: true, Calls: 1},
+		{Caller: "report", Callee: "Handle", Calls: 1},
+	}
+	if len(g.Edges) != len(want) {
+		t.Fatalf("Expected %d edges, got %+v", len(want), g.Edges)
+	}
+	for i := range want {
+		if g.Edges[i] != want[i] {
+			t.Errorf("Edge %d: expected %+v, got %+v", i, want[i], g.Edges[i])
+		}
+	}
+	if un := g.Unexercised(); len(un) != 1 || un[0].Callee != "retry" {
+		t.Errorf("Expected load -> retry to be the only unexercised edge, got %+v", un)
+	}
+	if len(g.Nodes) != 6 {
+		t.Errorf("Expected the six inventory functions as nodes, got %+v", g.Nodes)
+	}
+}
+
+func TestBuildMatchesStaticCallsByDeclaredName(t *testing.T) {
+	inv := instrument.Inventory{Functions: []instrument.Function{
+		{Name: "main.main", Func: "main", Calls: []string{"Run"}},
+		{Name: "main.Run", Func: "Run"},
+		{Name: "worker.Run", Func: "Run"},
+	}}
+	g := graph.Build(inv, nil)
+	want := []graph.Edge{
+		{Caller: "main.main", Callee: "main.Run", Static: true},
+		{Caller: "main.main", Callee: "worker.Run", Static: true},
+	}
+	if len(g.Edges) != len(want) {
+		t.Fatalf("Expected %d edges, got %+v", len(want), g.Edges)
+	}
+	for i := range want {
+		if g.Edges[i] != want[i] {
+			t.Errorf("Edge %d: expected %+v, got %+v", i, want[i], g.Edges[i])
+		}
+	}
+}
+
+func TestWriteDOTModes(t *testing.T) {
+	g := sampleGraph()
+	render := func(mode string) string {
+		var buf bytes.Buffer
+		if err := graph.WriteDOT(&buf, g, mode, graph.ClusterNone); err != nil {
+			t.Fatalf("WriteDOT(%s) returned error: %v", mode, err)
+		}
+		return buf.String()
+	}
+
+	static := render(graph.Static)
+	if !strings.Contains(static, `"load" -> "retry";`) || strings.Contains(static, `"report" -> "Handle"`) {
+		t.Errorf("Unexpected static graph:\n%s", static)
+	}
+	dynamic := render(graph.Dynamic)
+	if !strings.Contains(dynamic, `"load" -> "parse" [label="2"];`) || strings.Contains(dynamic, `"retry"`) {
+		t.Errorf("Unexpected dynamic graph:\n%s", dynamic)
+	}
+	overlay := render(graph.Overlay)
+	for _, want := range []string{
+		`"load" -> "retry" [style=dashed, color="#999999"];`,
+		`"report" -> "Handle" [label="1", style=dotted, color="#0072b2"];`,
+		`"retry" [label="retry", tooltip="0 calls", color="#e5e5e5", fontcolor="#666666"];`,
+	} {
+		if !strings.Contains(overlay, want) {
+			t.Errorf("Expected %s in overlay graph:\n%s", want, overlay)
+		}
+	}
+	if err := graph.WriteDOT(&bytes.Buffer{}, g, "bogus", ""); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+	if err := graph.WriteDOT(&bytes.Buffer{}, g, graph.Static, "bogus"); err == nil {
+		t.Error("Expected an error for an unknown clustering")
+	}
+}
+
+func TestWriteDOTMarksCallsThroughUninstrumentedCode(t *testing.T) {
+	inv := instrument.Inventory{Functions: []instrument.Function{
+		{Name: "main", Calls: []string{"Walk", "load"}},
+		{Name: "load"},
+		{Name: "visit"},
+	}}
+	records := []tracer.TraceRecord{
+		{UniqueID: 1, FunctionName: "main"},
+		{UniqueID: 2, FunctionName: "visit", CallerID: 1, ViaUninstrumented: true}, // A callback of filepath.Walk.
+		{UniqueID: 3, FunctionName: "visit", CallerID: 1, ViaUninstrumented: true},
+		{UniqueID: 4, FunctionName: "load", CallerID: 1},
+		{UniqueID: 5, FunctionName: "load", CallerID: 1, ViaUninstrumented: true},
+	}
+	g := graph.Build(inv, records)
+	var buf bytes.Buffer
+	if err := graph.WriteDOT(&buf, g, graph.Overlay, graph.ClusterNone); err != nil {
+		t.Fatalf("WriteDOT returned error: %v", err)
+	}
+	for _, want := range []string{
+		`"main" -> "visit" [label="2 via uninstrumented code", style=dashed, color="#cc79a7"];`,
+		`"main" -> "load" [label="2 (1 via uninstrumented code)"];`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected %s in graph:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestWriteDOTLegendAndFooter(t *testing.T) {
+	g := sampleGraph()
+	g.Footer = []string{"server, run of 2026-10-14 09:30:00 UTC, 1.25s, 6 calls, tracewrap v1.2.3"}
+	render := func(mode string) string {
+		var buf bytes.Buffer
+		if err := graph.WriteDOT(&buf, g, mode, graph.ClusterPackage); err != nil {
+			t.Fatalf("WriteDOT(%s) returned error: %v", mode, err)
+		}
+		return buf.String()
+	}
+	overlay := render(graph.Overlay)
+	for _, want := range []string{
+		"static call the run never took",
+		"call without a static call, e.g. through an interface",
+		"function that never ran",
+		`label="server, run of 2026-10-14 09:30:00 UTC, 1.25s, 6 calls, tracewrap v1.2.3\l";`,
+	} {
+		if !strings.Contains(overlay, want) {
+			t.Errorf("Expected %s in overlay graph:\n%s", want, overlay)
+		}
+	}
+	if strings.Contains(overlay, "request to another service") || strings.Contains(overlay, "via uninstrumented code") {
+		t.Errorf("Expected the legend to leave out styles the graph does not use:\n%s", overlay)
+	}
+	if static := render(graph.Static); !strings.Contains(static, "static call</td>") || strings.Contains(static, "never ran") {
+		t.Errorf("Unexpected legend of the static graph:\n%s", static)
+	}
+}
+
+func TestWriteDOTUsesPalette(t *testing.T) {
+	g := sampleGraph()
+	var err error
+	if g.Palette, err = theme.New(theme.Dark, map[string]string{"unexercised": "#ff00ff"}); err != nil {
+		t.Fatalf("theme.New returned error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := graph.WriteDOT(&buf, g, graph.Overlay, graph.ClusterNone); err != nil {
+		t.Fatalf("WriteDOT returned error: %v", err)
+	}
+	for _, want := range []string{
+		`graph [bgcolor="#1e1e1e", fontcolor="#e6e6e6"`,
+		`style=dashed, color="#ff00ff"`,
+		`"retry" [label="retry", tooltip="0 calls", color="#333333"`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected %s in graph:\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), `"#999999"`) {
+		t.Errorf("Expected no colors of the default palette:\n%s", buf.String())
+	}
+}
+
+func TestWriteDOTClusters(t *testing.T) {
+	inv := instrument.Inventory{Functions: []instrument.Function{
+		{Name: "main", Package: "main", File: "main.go"},
+		{Name: "load", Package: "store", File: "store/load.go"},
+		{Name: "parse", Package: "store", File: "store/parse.go"},
+	}}
+	records := []tracer.TraceRecord{
+		{UniqueID: 1, FunctionName: "main", Duration: 100 * time.Millisecond},
+		{UniqueID: 2, FunctionName: "load", CallerID: 1, Duration: 60 * time.Millisecond},
+		{UniqueID: 3, FunctionName: "parse", CallerID: 2, Duration: 40 * time.Millisecond},
+		{UniqueID: 4, FunctionName: "parse", CallerID: 1, Duration: 10 * time.Millisecond},
+	}
+	g := graph.Build(inv, records)
+	render := func(clusters string) string {
+		var buf bytes.Buffer
+		if err := graph.WriteDOT(&buf, g, graph.Dynamic, clusters); err != nil {
+			t.Fatalf("WriteDOT(%s) returned error: %v", clusters, err)
+		}
+		return buf.String()
+	}
+
+	// load's 60ms includes the 40ms parse call it made, which is not counted again.
+	byPackage := render(graph.ClusterPackage)
+	for _, want := range []string{
+		`subgraph "cluster_store" {`,
+		`label="store\n2 functions, 3 calls, 70ms";`,
+		`label="main\n1 functions, 1 calls, 100ms";`,
+	} {
+		if !strings.Contains(byPackage, want) {
+			t.Errorf("Expected %s in package clusters:\n%s", want, byPackage)
+		}
+	}
+	byFile := render(graph.ClusterFile)
+	for _, want := range []string{
+		`subgraph "cluster_store/load.go" {`,
+		`label="parse.go\n1 functions, 2 calls, 50ms";`,
+	} {
+		if !strings.Contains(byFile, want) {
+			t.Errorf("Expected %s in file clusters:\n%s", want, byFile)
+		}
+	}
+	if none := render(graph.ClusterNone); strings.Contains(none, "subgraph") {
+		t.Errorf("Expected no clusters:\n%s", none)
+	}
+}
+
+func TestBuildServicesJoinsRemoteCalls(t *testing.T) {
+	api := graph.Service{
+		Name:      "api",
+		RunID:     "run-api",
+		Inventory: instrument.Inventory{Functions: []instrument.Function{{Name: "handle", Package: "main", File: "main.go"}}},
+		Records: []tracer.TraceRecord{
+			{UniqueID: 1, FunctionName: "handle", Duration: 50 * time.Millisecond},
+			{UniqueID: 2, FunctionName: "fetchUser", CallerID: 1, Duration: 30 * time.Millisecond},
+		},
+	}
+	// users served fetchUser's request, whose traceparent carried the IDs of api's call 2.
+	traceID, spanID := tracer.DerivedTraceID("run-api", 1), tracer.SpanIDOf(2)
+	users := graph.Service{
+		Name: "users",
+		Records: []tracer.TraceRecord{
+			{UniqueID: 1, FunctionName: "handle", Duration: 20 * time.Millisecond, ExternalTraceID: traceID, ExternalSpanID: spanID},
+			{UniqueID: 2, FunctionName: "query", CallerID: 1, Duration: 15 * time.Millisecond, ExternalTraceID: traceID, ExternalSpanID: spanID},
+		},
+	}
+	g, err := graph.BuildServices([]graph.Service{api, users})
+	if err != nil {
+		t.Fatalf("BuildServices returned error: %v", err)
+	}
+	want := []graph.Edge{
+		{Caller: "api:fetchUser", Callee: "users:handle", Remote: true, Calls: 1, Total: 20 * time.Millisecond},
+		{Caller: "api:handle", Callee: "api:fetchUser", Calls: 1, Total: 30 * time.Millisecond},
+		{Caller: "users:handle", Callee: "users:query", Calls: 1, Total: 15 * time.Millisecond},
+	}
+	if len(g.Edges) != len(want) {
+		t.Fatalf("Expected %d edges, got %+v", len(want), g.Edges)
+	}
+	for i := range want {
+		if g.Edges[i] != want[i] {
+			t.Errorf("Edge %d: expected %+v, got %+v", i, want[i], g.Edges[i])
+		}
+	}
+	if len(g.Nodes) != 4 || g.Nodes[1].Name != "api:handle" || g.Nodes[1].Service != "api" || g.Nodes[1].Package != "main" {
+		t.Errorf("Expected the four functions prefixed with their services, got %+v", g.Nodes)
+	}
+	if kind := g.Edges[0].Kind(); kind != graph.KindRemote {
+		t.Errorf("Expected a remote edge, got %s", kind)
+	}
+
+	var buf bytes.Buffer
+	if err := graph.WriteDOT(&buf, g, graph.Dynamic, graph.ClusterPackage); err != nil {
+		t.Fatalf("WriteDOT returned error: %v", err)
+	}
+	dot := buf.String()
+	for _, want := range []string{
+		"  subgraph \"cluster_api\" {\n    label=\"api\\n2 functions, 2 calls, 50ms\";\n    style=\"rounded,bold\";\n    subgraph \"cluster_api:.\" {",
+		`subgraph "cluster_users" {`,
+		`"api:fetchUser" -> "users:handle" [label="1", style=bold, color="#e69f00"];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("Expected %s in the services graph:\n%s", want, dot)
+		}
+	}
+	buf.Reset()
+	if err := graph.WriteDOT(&buf, g, graph.Dynamic, graph.ClusterService); err != nil || strings.Contains(buf.String(), "cluster_api:.") {
+		t.Errorf("Expected service clusters only, got %v:\n%s", err, buf.String())
+	}
+
+	if _, err := graph.BuildServices([]graph.Service{api, api}); err == nil {
+		t.Error("Expected an error for duplicate service names")
+	}
+	if _, err := graph.BuildServices([]graph.Service{{Name: "a:b"}}); err == nil {
+		t.Error("Expected an error for a name containing the separator")
+	}
+}
+
+func TestBuildServicesMatchesExportedSpanIDs(t *testing.T) {
+	// api ran with a wrapped SDK, which exported fetchUser's call with its own span ID.
+	const traceID, exported = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	api := graph.Service{
+		Name: "api",
+		Records: []tracer.TraceRecord{
+			{UniqueID: 1, FunctionName: "handle", Duration: 50 * time.Millisecond, ExternalTraceID: traceID, ExportedSpanID: "b7ad6b7169203331"},
+			{UniqueID: 2, FunctionName: "fetchUser", CallerID: 1, Duration: 30 * time.Millisecond, ExternalTraceID: traceID, ExportedSpanID: exported},
+		},
+	}
+	users := graph.Service{
+		Name: "users",
+		Records: []tracer.TraceRecord{
+			{UniqueID: 1, FunctionName: "handle", Duration: 20 * time.Millisecond, ExternalTraceID: traceID, ExternalSpanID: exported},
+		},
+	}
+	g, err := graph.BuildServices([]graph.Service{api, users})
+	if err != nil {
+		t.Fatalf("BuildServices returned error: %v", err)
+	}
+	want := graph.Edge{Caller: "api:fetchUser", Callee: "users:handle", Remote: true, Calls: 1, Total: 20 * time.Millisecond}
+	if len(g.Edges) != 2 || g.Edges[0] != want {
+		t.Errorf("Expected %+v first, got %+v", want, g.Edges)
+	}
+}
+
+func TestWriteGraphMLAndJSONGraph(t *testing.T) {
+	g := sampleGraph()
+	g.Footer = []string{"server, run of 2026-10-14 09:30:00 UTC, 1.25s, 6 calls, tracewrap v1.2.3"}
+	var buf bytes.Buffer
+	if err := graph.Write(&buf, g, graph.Overlay, graph.GraphML, ""); err != nil {
+		t.Fatalf("Write(graphml) returned error: %v", err)
+	}
+	var doc struct {
+		Graph struct {
+			Nodes []struct {
+				ID string `xml:"id,attr"`
+			} `xml:"node"`
+			Edges []struct {
+				Source string `xml:"source,attr"`
+				Target string `xml:"target,attr"`
+				Data   []struct {
+					Key   string `xml:"key,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"data"`
+			} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Invalid GraphML: %v\n%s", err, buf.String())
+	}
+	if len(doc.Graph.Nodes) != 6 || len(doc.Graph.Edges) != 5 {
+		t.Errorf("Expected 6 nodes and 5 edges, got %d and %d", len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+	for _, e := range doc.Graph.Edges {
+		if e.Source == "load" && e.Target == "retry" && (len(e.Data) == 0 || e.Data[0].Value != graph.KindUnexercised) {
+			t.Errorf("Expected load -> retry to be unexercised, got %+v", e.Data)
+		}
+	}
+
+	buf.Reset()
+	if err := graph.Write(&buf, g, graph.Dynamic, graph.JSONGraph, ""); err != nil {
+		t.Fatalf("Write(jsongraph) returned error: %v", err)
+	}
+	var jg struct {
+		Graph struct {
+			Directed bool                       `json:"directed"`
+			Metadata map[string]string          `json:"metadata"`
+			Nodes    map[string]json.RawMessage `json:"nodes"`
+			Edges    []struct {
+				Source   string `json:"source"`
+				Target   string `json:"target"`
+				Relation string `json:"relation"`
+			} `json:"edges"`
+		} `json:"graph"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &jg); err != nil {
+		t.Fatalf("Invalid JSON graph: %v", err)
+	}
+	if !jg.Graph.Directed || len(jg.Graph.Edges) != 4 {
+		t.Errorf("Expected a directed graph with the 4 dynamic edges, got %+v", jg.Graph)
+	}
+	if jg.Graph.Metadata["footer"] != g.Footer[0] {
+		t.Errorf("Expected the footer in the graph's metadata, got %v", jg.Graph.Metadata)
+	}
+	if _, ok := jg.Graph.Nodes["retry"]; ok {
+		t.Error("Expected the dynamic graph to leave out retry, which never ran")
+	}
+	if err := graph.Write(&bytes.Buffer{}, g, graph.Static, "svg", ""); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
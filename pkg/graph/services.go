@@ -0,0 +1,145 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// ServiceSeparator joins a service name and a function name in the nodes of BuildServices, as in
+// "api:main.handle".
+const ServiceSeparator = ":"
+
+// Service is the trace of one process of a graph spanning several, e.g. the binaries of a system
+// whose runs were uploaded to one collector.
+type Service struct {
+	Name      string               // The service or binary name, e.g. "api".
+	RunID     string               // The run ID from run.json, to match the trace IDs the run derived.
+	Inventory instrument.Inventory // The service's inventory, empty if unknown.
+	Records   []tracer.TraceRecord
+}
+
+// spanKey identifies a call across services by its W3C trace and span IDs.
+type spanKey struct{ trace, span string }
+
+// BuildServices builds the graph of each service as Build does and merges them, prefixing the
+// function names with the service name and ServiceSeparator, so the functions of different
+// services never share a node. Calls served by one service on behalf of another are joined by a
+// remote edge: a request's first call in the callee service carries the trace and parent span
+// IDs of its traceparent header, and the caller is the call of another service whose span has
+// those IDs: the IDs a wrapped OpenTelemetry SDK exported it with (ExportedSpanID), or else the
+// ones the bridge derives (see tracer.SpanIDOf and tracer.DerivedTraceID). Calls whose caller is
+// not in the traces, or is ambiguous, get no edge.
+//
+// Parameters:
+//   - services ([]Service): the services, with distinct names.
+//
+// Returns:
+//   - Graph: the merged graph.
+//   - error: an error for an empty, duplicate or invalid service name.
+func BuildServices(services []Service) (Graph, error) {
+	var g Graph
+	seen := make(map[string]bool, len(services))
+	type caller struct{ service, node string }
+	callers := make(map[spanKey][]caller)
+	for _, s := range services {
+		if s.Name == "" || strings.Contains(s.Name, ServiceSeparator) {
+			return Graph{}, fmt.Errorf("invalid service name %q; it must not be empty or contain %q", s.Name, ServiceSeparator)
+		}
+		if seen[s.Name] {
+			return Graph{}, fmt.Errorf("duplicate service name %q", s.Name)
+		}
+		seen[s.Name] = true
+		prefix := s.Name + ServiceSeparator
+		sg := Build(s.Inventory, s.Records)
+		for _, n := range sg.Nodes {
+			n.Name, n.Service = prefix+n.Name, s.Name
+			g.Nodes = append(g.Nodes, n)
+		}
+		for _, e := range sg.Edges {
+			e.Caller, e.Callee = prefix+e.Caller, prefix+e.Callee
+			g.Edges = append(g.Edges, e)
+		}
+		byID := recordsByID(s.Records)
+		for _, rec := range s.Records {
+			traceID := rec.ExternalTraceID
+			if traceID == "" {
+				traceID = tracer.DerivedTraceID(s.RunID, rootOf(byID, rec))
+			}
+			spanID := rec.ExportedSpanID
+			if spanID == "" {
+				spanID = tracer.SpanIDOf(rec.UniqueID)
+			}
+			key := spanKey{traceID, spanID}
+			callers[key] = append(callers[key], caller{s.Name, prefix + rec.FunctionName})
+		}
+	}
+
+	type key struct{ caller, callee string }
+	remote := make(map[key]*Edge)
+	for _, s := range services {
+		byID := recordsByID(s.Records)
+		for _, rec := range s.Records {
+			if rec.ExternalSpanID == "" {
+				continue
+			}
+			// Callees inherit the trace context, so only the first call of the request is matched.
+			if parent, ok := byID[rec.CallerID]; ok && rec.CallerID != 0 && parent.ExternalSpanID == rec.ExternalSpanID {
+				continue
+			}
+			var found []caller
+			for _, c := range callers[spanKey{rec.ExternalTraceID, rec.ExternalSpanID}] {
+				if c.service != s.Name {
+					found = append(found, c)
+				}
+			}
+			if len(found) != 1 {
+				continue
+			}
+			k := key{found[0].node, s.Name + ServiceSeparator + rec.FunctionName}
+			e, ok := remote[k]
+			if !ok {
+				e = &Edge{Caller: k.caller, Callee: k.callee, Remote: true}
+				remote[k] = e
+			}
+			e.Calls++
+			e.Total += rec.Duration
+		}
+	}
+	for _, e := range remote {
+		g.Edges = append(g.Edges, *e)
+	}
+
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].Name < g.Nodes[j].Name })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].Caller != g.Edges[j].Caller {
+			return g.Edges[i].Caller < g.Edges[j].Caller
+		}
+		return g.Edges[i].Callee < g.Edges[j].Callee
+	})
+	return g, nil
+}
+
+// recordsByID indexes records by unique ID.
+func recordsByID(records []tracer.TraceRecord) map[int64]tracer.TraceRecord {
+	byID := make(map[int64]tracer.TraceRecord, len(records))
+	for _, rec := range records {
+		byID[rec.UniqueID] = rec
+	}
+	return byID
+}
+
+// rootOf returns the unique ID of the outermost recorded caller of rec, the root of its trace.
+func rootOf(byID map[int64]tracer.TraceRecord, rec tracer.TraceRecord) int64 {
+	for depth := 0; depth < len(byID); depth++ {
+		parent, ok := byID[rec.CallerID]
+		if !ok || rec.CallerID == 0 || parent.UniqueID == rec.UniqueID {
+			break
+		}
+		rec = parent
+	}
+	return rec.UniqueID
+}
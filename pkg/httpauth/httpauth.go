@@ -0,0 +1,149 @@
+// Package httpauth protects the HTTP endpoints of tracewrap (the control endpoint embedded in
+// instrumented binaries, and the collector with its web UI) with a shared token and optional TLS.
+// Traces contain parameter and return values that may be sensitive, so neither should be exposed
+// beyond localhost without them.
+//
+// Clients present the token as "Authorization: Bearer <token>". Browsers, which cannot set that
+// header, are asked for HTTP basic credentials instead; any user name is accepted with the token
+// as the password.
+package httpauth
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TokenEnv names the environment variable the tracewrap commands read the token from when no
+// --token flag is given.
+const TokenEnv = "TRACEWRAP_TOKEN"
+
+// ResolveToken returns token, or the value of the environment variable env when token is empty.
+//
+// Parameters:
+//   - token (string): the configured token.
+//   - env (string): the environment variable to fall back to, or "" for none.
+//
+// Returns:
+//   - string: the token, or "" if neither is set.
+func ResolveToken(token, env string) string {
+	if token == "" && env != "" {
+		return os.Getenv(env)
+	}
+	return token
+}
+
+// Require wraps next so that requests must carry token. An empty token disables the check.
+//
+// Parameters:
+//   - token (string): the token requests must present.
+//   - next (http.Handler): the protected handler.
+//   - public (...string): paths served without a token, e.g. "/healthz" for liveness probes.
+//
+// Returns:
+//   - http.Handler: the protected handler.
+func Require(token string, next http.Handler, public ...string) http.Handler {
+	if token == "" {
+		return next
+	}
+	open := make(map[string]bool, len(public))
+	for _, p := range public {
+		open[p] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if open[r.URL.Path] || presented(r, token) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="tracewrap"`)
+		http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+	})
+}
+
+// presented reports whether r carries token as a bearer token or basic password.
+func presented(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, got, ok = r.BasicAuth()
+	}
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// Authorize adds token to req as a bearer token. An empty token leaves req unchanged.
+//
+// Parameters:
+//   - req (*http.Request): the request.
+//   - token (string): the token to present.
+func Authorize(req *http.Request, token string) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// Serve serves handler on listener, over TLS when certFile and keyFile are set.
+//
+// Parameters:
+//   - listener (net.Listener): the listener.
+//   - handler (http.Handler): the handler.
+//   - certFile (string): the PEM certificate (chain) file, or "" for plain HTTP.
+//   - keyFile (string): the PEM private key file, or "" for plain HTTP.
+//
+// Returns:
+//   - error: the error that stopped the server.
+func Serve(listener net.Listener, handler http.Handler, certFile, keyFile string) error {
+	if err := CheckTLSFiles(certFile, keyFile); err != nil {
+		return err
+	}
+	if certFile == "" {
+		return http.Serve(listener, handler)
+	}
+	return http.ServeTLS(listener, handler, certFile, keyFile)
+}
+
+// CheckTLSFiles reports an error unless certFile and keyFile are either both set or both empty.
+//
+// Parameters:
+//   - certFile (string): the certificate file.
+//   - keyFile (string): the private key file.
+//
+// Returns:
+//   - error: an error if only one of them is set.
+func CheckTLSFiles(certFile, keyFile string) error {
+	if (certFile == "") != (keyFile == "") {
+		return errors.New("a TLS certificate and key must be given together")
+	}
+	return nil
+}
+
+// Client returns an HTTP client for a tracewrap endpoint. Without caFile, servers are verified
+// against the system roots.
+//
+// Parameters:
+//   - caFile (string): a PEM file of CA certificates to trust instead, e.g. for a self-signed
+//     collector certificate, or "".
+//
+// Returns:
+//   - *http.Client: the client.
+//   - error: an error if caFile cannot be read or holds no certificates.
+func Client(caFile string) (*http.Client, error) {
+	if caFile == "" {
+		return &http.Client{}, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,141 @@
+package httpauth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/httpauth"
+)
+
+func TestRequireChecksToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewServer(httpauth.Require("secret", ok, "/healthz"))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		setup    func(*http.Request)
+		expected int
+	}{
+		{"bearer token", "/", func(r *http.Request) { httpauth.Authorize(r, "secret") }, http.StatusOK},
+		{"basic password", "/", func(r *http.Request) { r.SetBasicAuth("anyone", "secret") }, http.StatusOK},
+		{"no token", "/", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"wrong token", "/", func(r *http.Request) { httpauth.Authorize(r, "guess") }, http.StatusUnauthorized},
+		{"public path", "/healthz", func(r *http.Request) {}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, server.URL+tt.path, nil)
+			tt.setup(req)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, resp.StatusCode)
+			}
+			if resp.StatusCode == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Error("Expected browsers to be asked for credentials")
+			}
+		})
+	}
+}
+
+func TestRequireWithoutTokenIsOpen(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	rec := httptest.NewRecorder()
+	httpauth.Require("", ok).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected an empty token to disable the check, got status %d", rec.Code)
+	}
+}
+
+func TestResolveTokenFallsBackToEnvironment(t *testing.T) {
+	t.Setenv("TRACEWRAP_TEST_TOKEN", "from-env")
+	if got := httpauth.ResolveToken("", "TRACEWRAP_TEST_TOKEN"); got != "from-env" {
+		t.Errorf("Expected the environment token, got %q", got)
+	}
+	if got := httpauth.ResolveToken("configured", "TRACEWRAP_TEST_TOKEN"); got != "configured" {
+		t.Errorf("Expected the configured token to win, got %q", got)
+	}
+}
+
+// writeSelfSigned writes a self-signed certificate for 127.0.0.1 and its key to dir.
+func writeSelfSigned(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tracewrap test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestServeTLSWithCAFile(t *testing.T) {
+	dir, err := os.MkdirTemp("", "httpauthtest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	certFile, keyFile := writeSelfSigned(t, dir)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go httpauth.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), certFile, keyFile)
+	url := "https://" + listener.Addr().String() + "/"
+
+	client, err := httpauth.Client(certFile)
+	if err != nil {
+		t.Fatalf("Client returned error: %v", err)
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Expected the CA file to verify the server: %v", err)
+	}
+	resp.Body.Close()
+
+	system, _ := httpauth.Client("")
+	if resp, err := system.Get(url); err == nil {
+		resp.Body.Close()
+		t.Error("Expected the self-signed certificate to be rejected without the CA file")
+	}
+	if err := httpauth.Serve(listener, nil, certFile, ""); err == nil {
+		t.Error("Expected an error for a certificate without a key")
+	}
+}
@@ -1,21 +1,34 @@
 package instrument
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"go/ast"
+	"go/build"
 	"go/parser"
 	"go/printer"
 	"go/token"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
 )
 
-// InstrumentWorkspace traverses all files within the workspace directory and instruments
-// each Go source file according to the provided configuration. Files matching any exclude
-// patterns from cfg.Instrumentation.Exclude or located within the "tracer" directory are skipped.
+// InstrumentWorkspace traverses all files within the workspace directory and instruments each Go
+// source file according to the provided configuration. Files matching any exclude patterns from
+// cfg.Instrumentation.Exclude or located within the "tracer" directory are skipped. Files whose
+// build constraints do not match the target platform (GOOS/GOARCH and build tags as seen by
+// go/build) are left untouched, as are generated files when cfg.Instrumentation.SkipGenerated is
+// set. Test files (*_test.go) are skipped unless cfg.Instrumentation.IncludeTests is set. The
+// instrumented functions are listed in the InventoryFile written to the workspace root, named in
+// cfg.Instrumentation.NameFormat (see assignNames), and their names numbered in the SymbolsFile
+// written next to it. The SourceMapFile maps the lines of the instrumented files back to the
+// originals. A file whose instrumented source does not parse or type-check (see verifyOutput) is
+// kept as it is and listed in the inventory's Fallbacks, and the other files are instrumented.
 //
 // Parameters:
 //   - workspace (string): the path to the workspace directory.
@@ -24,7 +37,8 @@ import (
 // Returns:
 //   - error: an error object if any file fails to be instrumented.
 func InstrumentWorkspace(workspace string, cfg config.Config) error {
-	return filepath.Walk(workspace, func(path string, info os.FileInfo, err error) error {
+	var files []string
+	err := filepath.Walk(workspace, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -39,811 +53,580 @@ func InstrumentWorkspace(workspace string, cfg config.Config) error {
 			return nil
 		}
 		if !info.IsDir() && filepath.Ext(path) == ".go" {
+			if strings.HasSuffix(path, "_test.go") && !cfg.Instrumentation.IncludeTests {
+				fmt.Fprintf(Progress, "Skipping file (test file): %s\n", rel)
+				return nil
+			}
 			for _, pattern := range cfg.Instrumentation.Exclude {
 				matched, err := filepath.Match(pattern, rel)
 				if err != nil {
 					return fmt.Errorf("error matching pattern %s: %v", pattern, err)
 				}
 				if matched {
-					fmt.Printf("Skipping file (matches exclude pattern '%s'): %s\n", pattern, rel)
+					fmt.Fprintf(Progress, "Skipping file (matches exclude pattern '%s'): %s\n", pattern, rel)
 					return nil
 				}
 			}
-			fmt.Printf("Instrumenting file: %s\n", path)
-			if err := instrumentFile(path); err != nil {
-				return fmt.Errorf("failed to instrument file %s: %v", path, err)
+			matched, err := build.Default.MatchFile(filepath.Dir(path), filepath.Base(path))
+			if err != nil {
+				return fmt.Errorf("error evaluating build constraints for %s: %v", rel, err)
+			}
+			if !matched {
+				fmt.Fprintf(Progress, "Skipping file (build constraints exclude %s/%s): %s\n", build.Default.GOOS, build.Default.GOARCH, rel)
+				return nil
+			}
+			if cfg.Instrumentation.SkipGenerated {
+				generated, err := isGeneratedFile(path)
+				if err != nil {
+					return fmt.Errorf("failed to inspect file %s: %v", path, err)
+				}
+				if generated {
+					fmt.Fprintf(Progress, "Skipping file (generated code): %s\n", rel)
+					return nil
+				}
 			}
+			files = append(files, rel)
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	names, err := assignNames(workspace, files, cfg.Instrumentation.NameFormat)
+	if err != nil {
+		return err
+	}
+	symbols, ids := names.symbols()
+	if err := tracer.WriteSymbols(filepath.Join(workspace, SymbolsFile), symbols); err != nil {
+		return fmt.Errorf("failed to write symbol table: %v", err)
+	}
+	var inventory Inventory
+	absWorkspace, err := filepath.Abs(workspace)
+	if err != nil {
+		return err
+	}
+	sourceMap := SourceMap{Workspace: absWorkspace, Files: make(map[string]FileLines)}
+	loader := newPackageLoader()
+	for _, rel := range files {
+		path := filepath.Join(workspace, rel)
+		fmt.Fprintf(Progress, "Instrumenting file: %s\n", path)
+		functions, lines, err := instrumentFile(path, rel, cfg, names, ids, loader)
+		var invalid *invalidOutputError
+		if errors.As(err, &invalid) {
+			// One file the instrumentation cannot handle does not stop the rest being traced.
+			fmt.Fprintf(Progress, "Keeping file uninstrumented (%s): %s\n", invalid.reason, rel)
+			inventory.Fallbacks = append(inventory.Fallbacks, Fallback{File: filepath.ToSlash(rel), Reason: invalid.reason})
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to instrument file %s: %v", path, err)
+		}
+		inventory.Functions = append(inventory.Functions, functions...)
+		sourceMap.Files[filepath.ToSlash(rel)] = lines
+	}
+	if err := WriteSourceMap(filepath.Join(workspace, SourceMapFile), sourceMap); err != nil {
+		return fmt.Errorf("failed to write source map: %v", err)
+	}
+	return WriteInventory(filepath.Join(workspace, InventoryFile), inventory)
+}
+
+// isGeneratedFile reports whether the Go source file at filePath carries the standard
+// "Code generated ... DO NOT EDIT." header. Only the package clause and the comments
+// preceding it are parsed.
+//
+// Parameters:
+//   - filePath (string): the path to the Go source file.
+//
+// Returns:
+//   - bool: true if the file is marked as generated.
+//   - error: an error object if the file cannot be parsed.
+func isGeneratedFile(filePath string) (bool, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filePath, nil, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return false, fmt.Errorf("parsing error: %v", err)
+	}
+	return ast.IsGenerated(f), nil
 }
 
 // instrumentFile parses and instruments a single Go source file located at filePath.
 // It modifies the AST of the file to inject instrumentation code and then writes
-// the modified AST back to the file.
+// the modified AST back to the file. init functions are only instrumented when
+// cfg.Instrumentation.InstrumentInit is set; otherwise they are reported and skipped.
+// Region and counter marker comments are turned into tracer calls before parsing (see expandRegionMarkers).
 //
 // Parameters:
 //   - filePath (string): the path to the Go source file to instrument.
+//   - rel (string): filePath relative to the workspace, as recorded in the inventory.
+//   - cfg (config.Config): the configuration settings used for instrumentation.
+//   - names (functionNames): the names the functions' records carry, from assignNames.
+//   - ids (map[string]int): the ID of each name in the symbol table, from functionNames.symbols.
+//   - loader (*packageLoader): the parsed and type-checked packages of the workspace.
 //
 // Returns:
+//   - []Function: the functions instrumented.
+//   - FileLines: the original lines of the instrumented file's lines.
 //   - error: an error object if parsing, instrumentation, or file writing fails.
-func instrumentFile(filePath string) error {
-	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, filePath, nil, parser.ParseComments)
+func instrumentFile(filePath, rel string, cfg config.Config, names functionNames, ids map[string]int, loader *packageLoader) ([]Function, FileLines, error) {
+	file, err := loader.file(filePath)
 	if err != nil {
-		return fmt.Errorf("parsing error: %v", err)
+		return nil, FileLines{}, err
 	}
-
-	ensureImport := func(pkg string) {
-		found := false
-		for _, imp := range f.Imports {
-			if imp.Path != nil && imp.Path.Value == "\""+pkg+"\"" {
-				found = true
-				break
-			}
-		}
-		if !found {
-			newImport := &ast.ImportSpec{
-				Path: &ast.BasicLit{
-					Kind:  token.STRING,
-					Value: "\"" + pkg + "\"",
-				},
-			}
-			for _, decl := range f.Decls {
-				if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.IMPORT {
-					genDecl.Specs = append(genDecl.Specs, newImport)
-					return
-				}
-			}
-			importDecl := &ast.GenDecl{
-				Tok:   token.IMPORT,
-				Specs: []ast.Spec{newImport},
-			}
-			f.Decls = append([]ast.Decl{importDecl}, f.Decls...)
-		}
+	if file.err != nil {
+		return nil, FileLines{}, fmt.Errorf("parsing error: %v", file.err)
 	}
-	ensureImport("time")
-	ensureImport("fmt")
-	ensureImport("runtime/debug")
-	ensureImport("runtime")
-	tracerPkg := strings.Trim(DynamicTracerImport, "\"")
-	ensureImport(tracerPkg)
+	fset, f, markers := loader.fset, file.expanded, file.markers
+	typeInfo := loader.check(file)
 
 	for _, imp := range f.Imports {
 		if imp.Path != nil && strings.Contains(imp.Path.Value, "ghost/tracer") {
-			fmt.Printf("DEBUG: Replacing import %s with %s in file %s\n", imp.Path.Value, DynamicTracerImport, filePath)
+			fmt.Fprintf(Progress, "DEBUG: Replacing import %s with %s in file %s\n", imp.Path.Value, DynamicTracerImport, filePath)
 			imp.Path.Value = DynamicTracerImport
 		}
 	}
 
+	isMainPackage := f.Name.Name == "main"
+	instrumented := false
+	bridgeInstalls := 0 // otelbridge calls injected; see instrumentation.bridgeOpenTelemetry.
+	var functions []Function
+
 	for _, decl := range f.Decls {
 		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil {
-			if fn.Name.Name == "init" {
+			if fn.Name.Name == "init" && fn.Recv == nil && !cfg.Instrumentation.InstrumentInit {
+				fmt.Fprintf(Progress, "Skipping init function in %s (set instrumentation.instrumentInit to trace it)\n", filePath)
 				continue
 			}
 
-			fnNameLit := "\"" + fn.Name.Name + "\""
-
-			recoverStmt := &ast.DeferStmt{
-				Call: &ast.CallExpr{
-					Fun: &ast.FuncLit{
-						Type: &ast.FuncType{
-							Params: &ast.FieldList{},
-						},
-						Body: &ast.BlockStmt{
-							List: []ast.Stmt{
-								&ast.AssignStmt{
-									Lhs: []ast.Expr{&ast.Ident{Name: "r"}},
-									Tok: token.DEFINE,
-									Rhs: []ast.Expr{
-										&ast.CallExpr{
-											Fun:  &ast.Ident{Name: "recover"},
-											Args: []ast.Expr{},
-										},
-									},
-								},
-								&ast.IfStmt{
-									Cond: &ast.BinaryExpr{
-										X:  &ast.Ident{Name: "r"},
-										Op: token.NEQ,
-										Y:  &ast.Ident{Name: "nil"},
-									},
-									Body: &ast.BlockStmt{
-										List: []ast.Stmt{
-											&ast.ExprStmt{
-												X: &ast.CallExpr{
-													Fun: &ast.SelectorExpr{
-														X:   &ast.Ident{Name: "tracer"},
-														Sel: &ast.Ident{Name: "RecordPanic"},
-													},
-													Args: []ast.Expr{
-														&ast.BasicLit{Kind: token.STRING, Value: fnNameLit},
-														&ast.Ident{Name: "r"},
-														&ast.CallExpr{
-															Fun: ast.NewIdent("string"),
-															Args: []ast.Expr{
-																&ast.CallExpr{
-																	Fun: &ast.SelectorExpr{
-																		X:   &ast.Ident{Name: "debug"},
-																		Sel: &ast.Ident{Name: "Stack"},
-																	},
-																	Args: []ast.Expr{},
-																},
-															},
-														},
-													},
-												},
-											},
-											&ast.ExprStmt{
-												X: &ast.CallExpr{
-													Fun:  &ast.Ident{Name: "panic"},
-													Args: []ast.Expr{&ast.Ident{Name: "r"}},
-												},
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-					Args: []ast.Expr{},
-				},
+			traceName := names.lookup(rel, fn)
+			functions = append(functions, Function{
+				Name:       traceName,
+				ID:         ids[traceName],
+				Func:       fn.Name.Name,
+				Package:    f.Name.Name,
+				File:       filepath.ToSlash(rel),
+				Line:       fset.Position(fn.Pos()).Line,
+				EndLine:    fset.Position(fn.End()).Line,
+				Statements: countStatements(fn.Body),
+				Calls:      staticCalls(fn.Body),
+			})
+			installs, err := instrumentFunc(fn, traceName, ids[traceName], isMainPackage && fn.Name.Name == "main" && fn.Recv == nil, typeInfo, cfg)
+			if err != nil {
+				return nil, FileLines{}, err
 			}
+			bridgeInstalls += installs
+			instrumented = true
+		}
+	}
 
-			startTimeDecl := &ast.AssignStmt{
-				Lhs: []ast.Expr{&ast.Ident{Name: "__tracewrap_startTime"}},
-				Tok: token.DEFINE,
-				Rhs: []ast.Expr{
-					&ast.CallExpr{
-						Fun: &ast.SelectorExpr{
-							X:   &ast.Ident{Name: "time"},
-							Sel: &ast.Ident{Name: "Now"},
-						},
-						Args: []ast.Expr{},
-					},
-				},
-			}
+	logWrappers := 0
+	if cfg.Instrumentation.CorrelateLogs {
+		logWrappers = wrapLogOutputs(f)
+	}
+	if cfg.Instrumentation.BridgeOpenTelemetry {
+		bridgeInstalls += wrapTracerProviders(f)
+	}
+	exitRewrites := 0
+	if !cfg.Instrumentation.SkipExitRewrite {
+		exitRewrites = rewriteExits(f)
+	}
+	commandRewrites := 0
+	if !cfg.Instrumentation.SkipCommandRewrite {
+		commandRewrites = rewriteCommands(f, typeInfo)
+	}
 
-			startCPUTimeDecl := &ast.AssignStmt{
-				Lhs: []ast.Expr{&ast.Ident{Name: "__tracewrap_startCPUTime"}},
-				Tok: token.DEFINE,
-				Rhs: []ast.Expr{
-					&ast.CallExpr{
-						Fun: &ast.SelectorExpr{
-							X:   &ast.Ident{Name: "tracer"},
-							Sel: &ast.Ident{Name: "GetProcessCPUTime"},
-						},
-						Args: []ast.Expr{},
-					},
-				},
-			}
+	// Only add the imports the injected code actually references, so files without
+	// instrumented functions still compile.
+	if markers > 0 || logWrappers > 0 || exitRewrites > 0 || commandRewrites > 0 {
+		ensureImport(f, strings.Trim(DynamicTracerImport, "\""))
+	}
+	if bridgeInstalls > 0 {
+		ensureImport(f, otelBridgePackagePath)
+	}
+	if instrumented {
+		ensureImport(f, "runtime/debug")
+		ensureImport(f, strings.Trim(DynamicTracerImport, "\""))
+	}
 
-			deferExit := &ast.DeferStmt{
-				Call: &ast.CallExpr{
-					Fun: &ast.SelectorExpr{
-						X:   &ast.Ident{Name: "tracer"},
-						Sel: &ast.Ident{Name: "RecordExit"},
-					},
-					Args: []ast.Expr{
-						&ast.BasicLit{Kind: token.STRING, Value: fnNameLit},
-						&ast.Ident{Name: "__tracewrap_startTime"},
-					},
-				},
-			}
+	var out bytes.Buffer
+	if err := printer.Fprint(&out, fset, f); err != nil {
+		return nil, FileLines{}, &invalidOutputError{reason: fmt.Sprintf("error printing file: %v", err)}
+	}
+	if err := verifyOutput(fset, filePath, f.Name.Name, out.Bytes(), typeInfo); err != nil {
+		return nil, FileLines{}, err
+	}
+	lines, err := mapLines(fset, f, out.Bytes())
+	if err != nil {
+		return nil, FileLines{}, &invalidOutputError{reason: fmt.Sprintf("error mapping lines: %v", err)}
+	}
+	if err := os.WriteFile(filePath, out.Bytes(), 0644); err != nil {
+		return nil, FileLines{}, err
+	}
+	return functions, lines, nil
+}
 
-			recordEntryCall := &ast.ExprStmt{
-				X: &ast.CallExpr{
-					Fun: &ast.SelectorExpr{
-						X:   &ast.Ident{Name: "tracer"},
-						Sel: &ast.Ident{Name: "RecordEntry"},
-					},
-					Args: []ast.Expr{
-						&ast.BasicLit{Kind: token.STRING, Value: fnNameLit},
-					},
-				},
-			}
+// isPureExpr reports whether evaluating expr twice is equivalent to evaluating it once: identifiers,
+// literals, selectors, and unary (other than receive) and binary operations on such expressions.
+func isPureExpr(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.Ident, *ast.BasicLit:
+		return true
+	case *ast.ParenExpr:
+		return isPureExpr(e.X)
+	case *ast.SelectorExpr:
+		return isPureExpr(e.X)
+	case *ast.UnaryExpr:
+		return e.Op != token.ARROW && e.Op != token.AND && isPureExpr(e.X)
+	case *ast.BinaryExpr:
+		return isPureExpr(e.X) && isPureExpr(e.Y)
+	default:
+		return false
+	}
+}
 
-			var paramLogs []ast.Stmt
-			if fn.Type.Params != nil {
-				for _, field := range fn.Type.Params.List {
-					for _, name := range field.Names {
-						logCall := &ast.ExprStmt{
-							X: &ast.CallExpr{
-								Fun: &ast.SelectorExpr{
-									X:   &ast.Ident{Name: "tracer"},
-									Sel: &ast.Ident{Name: "RecordParam"},
-								},
-								Args: []ast.Expr{
-									&ast.BasicLit{Kind: token.STRING, Value: "\"" + name.Name + "\""},
-									&ast.CallExpr{
-										Fun: &ast.Ident{Name: "fmt.Sprintf"},
-										Args: []ast.Expr{
-											&ast.BasicLit{Kind: token.STRING, Value: "\"%+v\""},
-											&ast.Ident{Name: name.Name},
-										},
-									},
-								},
-							},
-						}
-						paramLogs = append(paramLogs, logCall)
-					}
-				}
-			}
+// isContextType reports whether expr is the type context.Context, so that the pprof labels of a
+// context parameter can be recorded.
+func isContextType(expr ast.Expr) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Context" {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	return ok && pkg.Name == "context"
+}
 
-			if fn.Name.Name == "main" && fn.Recv == nil {
-				dumpCallGraphStmt := &ast.ExprStmt{
-					X: &ast.CallExpr{
-						Fun: &ast.SelectorExpr{
-							X:   ast.NewIdent("tracer"),
-							Sel: ast.NewIdent("DumpCallGraphDOT"),
-						},
-						Args: []ast.Expr{
-							&ast.BasicLit{
-								Kind:  token.STRING,
-								Value: "\"tracewrap/callgraph.dot\"",
-							},
-						},
-					},
-				}
-				fn.Body.List = append(fn.Body.List, dumpCallGraphStmt)
-			}
+// isResponseWriterType reports whether expr is the type expression http.ResponseWriter.
+func isResponseWriterType(expr ast.Expr) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "ResponseWriter" {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	return ok && pkg.Name == "http"
+}
 
-			startGoroutinesDecl := &ast.AssignStmt{
-				Lhs: []ast.Expr{&ast.Ident{Name: "__tracewrap_startGoroutines"}},
-				Tok: token.DEFINE,
-				Rhs: []ast.Expr{
-					&ast.CallExpr{
-						Fun: &ast.SelectorExpr{
-							X:   ast.NewIdent("runtime"),
-							Sel: ast.NewIdent("NumGoroutine"),
-						},
-						Args: []ast.Expr{},
-					},
-				},
-			}
+// basicTypes are the predeclared types kept by instrumentation.captureBasicKindsOnly.
+var basicTypes = map[string]bool{
+	"bool": true, "string": true, "byte": true, "rune": true, "uintptr": true,
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true,
+	"float32": true, "float64": true, "complex64": true, "complex128": true,
+}
 
-			startThreadsDecl := &ast.AssignStmt{
-				Lhs: []ast.Expr{&ast.Ident{Name: "__tracewrap_startThreads"}},
-				Tok: token.DEFINE,
-				Rhs: []ast.Expr{
-					&ast.CallExpr{
-						Fun: &ast.SelectorExpr{
-							X:   ast.NewIdent("runtime"),
-							Sel: ast.NewIdent("NumCgoCall"),
-						},
-						Args: []ast.Expr{},
-					},
-				},
-			}
+// isBasicType reports whether expr names a predeclared basic type such as int or string.
+// The check is syntactic, so named types with a basic underlying type are not included.
+func isBasicType(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && basicTypes[ident.Name]
+}
 
-			memStatsBeforeDecl := &ast.DeclStmt{
-				Decl: &ast.GenDecl{
-					Tok: token.VAR,
-					Specs: []ast.Spec{
-						&ast.ValueSpec{
-							Names: []*ast.Ident{ast.NewIdent("__tracewrap_memStatsBefore")},
-							Type: &ast.SelectorExpr{
-								X:   ast.NewIdent("runtime"),
-								Sel: ast.NewIdent("MemStats"),
-							},
-						},
-					},
-				},
-			}
+// logOutputWrappers maps the log setup calls rewritten by instrumentation.correlateLogs, keyed by
+// import path and function, to the tracer function wrapping their first argument.
+var logOutputWrappers = map[[2]string]string{
+	{"log/slog", "New"}:  "NewLogHandler",
+	{"log", "SetOutput"}: "NewLogWriter",
+	{"log", "New"}:       "NewLogWriter",
+}
 
-			readMemStatsBefore := &ast.ExprStmt{
-				X: &ast.CallExpr{
-					Fun: &ast.SelectorExpr{
-						X:   ast.NewIdent("runtime"),
-						Sel: ast.NewIdent("ReadMemStats"),
-					},
-					Args: []ast.Expr{
-						&ast.UnaryExpr{
-							Op: token.AND,
-							X:  ast.NewIdent("__tracewrap_memStatsBefore"),
-						},
-					},
-				},
-			}
+// wrapLogOutputs rewrites slog.New(h), log.New(w, ...) and log.SetOutput(w) so that h and w are
+// wrapped by tracer.NewLogHandler and tracer.NewLogWriter, which add span and trace IDs to the
+// application's log lines.
+//
+// Parameters:
+//   - f (*ast.File): the file to rewrite.
+//
+// Returns:
+//   - int: the number of calls rewritten.
+func wrapLogOutputs(f *ast.File) int {
+	localNames := make(map[string]string) // Local package name to import path.
+	for _, imp := range f.Imports {
+		path := strings.Trim(imp.Path.Value, "\"")
+		if path != "log" && path != "log/slog" {
+			continue
+		}
+		name := path[strings.LastIndex(path, "/")+1:]
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		if name != "_" && name != "." {
+			localNames[name] = path
+		}
+	}
+	if len(localNames) == 0 {
+		return 0
+	}
+	rewritten := 0
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		pkg, ok := sel.X.(*ast.Ident)
+		if !ok || pkg.Obj != nil {
+			// Not a package qualifier (for example a local variable named log).
+			return true
+		}
+		wrapper, ok := logOutputWrappers[[2]string{localNames[pkg.Name], sel.Sel.Name}]
+		if !ok {
+			return true
+		}
+		call.Args[0] = &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent("tracer"),
+				Sel: ast.NewIdent(wrapper),
+			},
+			Args: []ast.Expr{call.Args[0]},
+		}
+		rewritten++
+		return true
+	})
+	return rewritten
+}
 
-			startNetUsageDecl := &ast.AssignStmt{
-				Lhs: []ast.Expr{&ast.Ident{Name: "__tracewrap_startNetUsage"}},
-				Tok: token.DEFINE,
-				Rhs: []ast.Expr{
-					&ast.CallExpr{
+// rewriteExits inserts a tracer.Shutdown call before every os.Exit statement, which would
+// otherwise end the program without running the deferred exit hooks or the artifact writes at the
+// end of main.
+//
+// Parameters:
+//   - f (*ast.File): the file to rewrite.
+//
+// Returns:
+//   - int: the number of os.Exit calls rewritten.
+func rewriteExits(f *ast.File) int {
+	osName := ""
+	for _, imp := range f.Imports {
+		if imp.Path.Value != "\"os\"" {
+			continue
+		}
+		osName = "os"
+		if imp.Name != nil {
+			osName = imp.Name.Name
+		}
+	}
+	if osName == "" || osName == "_" || osName == "." {
+		return 0
+	}
+	isExit := func(stmt ast.Stmt) bool {
+		expr, ok := stmt.(*ast.ExprStmt)
+		if !ok {
+			return false
+		}
+		call, ok := expr.X.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "Exit" {
+			return false
+		}
+		pkg, ok := sel.X.(*ast.Ident)
+		// A resolved identifier is a local variable, not the package.
+		return ok && pkg.Name == osName && pkg.Obj == nil
+	}
+	rewritten := 0
+	rewrite := func(list []ast.Stmt) []ast.Stmt {
+		var out []ast.Stmt
+		for _, stmt := range list {
+			if isExit(stmt) {
+				out = append(out, &ast.ExprStmt{
+					X: &ast.CallExpr{
 						Fun: &ast.SelectorExpr{
 							X:   ast.NewIdent("tracer"),
-							Sel: ast.NewIdent("GetNetworkUsage"),
+							Sel: ast.NewIdent("Shutdown"),
 						},
-						Args: []ast.Expr{},
 					},
-				},
+				})
+				rewritten++
 			}
+			out = append(out, stmt)
+		}
+		return out
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch block := n.(type) {
+		case *ast.BlockStmt:
+			block.List = rewrite(block.List)
+		case *ast.CaseClause:
+			block.Body = rewrite(block.Body)
+		case *ast.CommClause:
+			block.Body = rewrite(block.Body)
+		}
+		return true
+	})
+	return rewritten
+}
 
-			startDiskUsageDecl := &ast.AssignStmt{
-				Lhs: []ast.Expr{&ast.Ident{Name: "__tracewrap_startDiskUsage"}},
-				Tok: token.DEFINE,
-				Rhs: []ast.Expr{
-					&ast.CallExpr{
-						Fun: &ast.SelectorExpr{
-							X:   ast.NewIdent("tracer"),
-							Sel: ast.NewIdent("GetDiskUsage"),
-						},
-						Args: []ast.Expr{},
-					},
-				},
-			}
+// regionMarker matches `//tracewrap:region start name="checkout"` and `//tracewrap:region end`
+// comments on a line of their own.
+var regionMarker = regexp.MustCompile(`^(\s*)//tracewrap:region\s+(start|end)(?:\s+name="([^"]*)")?\s*$`)
 
-			newDefer := &ast.DeferStmt{
-				Call: &ast.CallExpr{
-					Fun: &ast.FuncLit{
-						Type: &ast.FuncType{
-							Params: &ast.FieldList{},
-						},
-						Body: &ast.BlockStmt{
-							List: []ast.Stmt{
-								&ast.DeclStmt{
-									Decl: &ast.GenDecl{
-										Tok: token.VAR,
-										Specs: []ast.Spec{
-											&ast.ValueSpec{
-												Names: []*ast.Ident{ast.NewIdent("__tracewrap_endCPUTime")},
-												Type: &ast.SelectorExpr{
-													X:   ast.NewIdent("time"),
-													Sel: ast.NewIdent("Duration"),
-												},
-												Values: []ast.Expr{
-													&ast.BasicLit{Kind: token.INT, Value: "0"},
-												},
-											},
-											&ast.ValueSpec{
-												Names: []*ast.Ident{ast.NewIdent("__tracewrap_cpuTimeDiff")},
-												Type: &ast.SelectorExpr{
-													X:   ast.NewIdent("time"),
-													Sel: ast.NewIdent("Duration"),
-												},
-												Values: []ast.Expr{
-													&ast.BasicLit{Kind: token.INT, Value: "0"},
-												},
-											},
-											&ast.ValueSpec{
-												Names: []*ast.Ident{ast.NewIdent("__tracewrap_memStatsAfter")},
-												Type: &ast.SelectorExpr{
-													X:   ast.NewIdent("runtime"),
-													Sel: ast.NewIdent("MemStats"),
-												},
-												Values: []ast.Expr{
-													&ast.CompositeLit{
-														Type: &ast.SelectorExpr{
-															X:   ast.NewIdent("runtime"),
-															Sel: ast.NewIdent("MemStats"),
-														},
-													},
-												},
-											},
-											&ast.ValueSpec{
-												Names: []*ast.Ident{ast.NewIdent("__tracewrap_endGoroutines")},
-												Type:  ast.NewIdent("int"),
-												Values: []ast.Expr{
-													&ast.BasicLit{Kind: token.INT, Value: "0"},
-												},
-											},
-											&ast.ValueSpec{
-												Names: []*ast.Ident{ast.NewIdent("__tracewrap_endThreads")},
-												Type:  ast.NewIdent("int64"),
-												Values: []ast.Expr{
-													&ast.BasicLit{Kind: token.INT, Value: "0"},
-												},
-											},
-											&ast.ValueSpec{
-												Names: []*ast.Ident{ast.NewIdent("__tracewrap_endNetUsage")},
-												Type:  ast.NewIdent("int64"),
-												Values: []ast.Expr{
-													&ast.BasicLit{Kind: token.INT, Value: "0"},
-												},
-											},
-											&ast.ValueSpec{
-												Names: []*ast.Ident{ast.NewIdent("__tracewrap_endDiskUsage")},
-												Type:  ast.NewIdent("int64"),
-												Values: []ast.Expr{
-													&ast.BasicLit{Kind: token.INT, Value: "0"},
-												},
-											},
-										},
-									},
-								},
-								&ast.AssignStmt{
-									Lhs: []ast.Expr{&ast.Ident{Name: "__tracewrap_endCPUTime"}},
-									Tok: token.ASSIGN,
-									Rhs: []ast.Expr{
-										&ast.CallExpr{
-											Fun: &ast.SelectorExpr{
-												X:   ast.NewIdent("tracer"),
-												Sel: ast.NewIdent("GetProcessCPUTime"),
-											},
-											Args: []ast.Expr{},
-										},
-									},
-								},
-								&ast.AssignStmt{
-									Lhs: []ast.Expr{&ast.Ident{Name: "__tracewrap_cpuTimeDiff"}},
-									Tok: token.ASSIGN,
-									Rhs: []ast.Expr{
-										&ast.BinaryExpr{
-											X:  &ast.Ident{Name: "__tracewrap_endCPUTime"},
-											Op: token.SUB,
-											Y:  &ast.Ident{Name: "__tracewrap_startCPUTime"},
-										},
-									},
-								},
-								&ast.ExprStmt{
-									X: &ast.CallExpr{
-										Fun: &ast.SelectorExpr{
-											X:   ast.NewIdent("runtime"),
-											Sel: ast.NewIdent("ReadMemStats"),
-										},
-										Args: []ast.Expr{
-											&ast.UnaryExpr{
-												Op: token.AND,
-												X:  ast.NewIdent("__tracewrap_memStatsAfter"),
-											},
-										},
-									},
-								},
-								&ast.ExprStmt{
-									X: &ast.CallExpr{
-										Fun: &ast.SelectorExpr{
-											X:   ast.NewIdent("tracer"),
-											Sel: ast.NewIdent("RecordResourceUsage"),
-										},
-										Args: []ast.Expr{
-											&ast.BasicLit{
-												Kind:  token.STRING,
-												Value: fnNameLit,
-											},
-											&ast.Ident{Name: "__tracewrap_cpuTimeDiff"},
-											&ast.BinaryExpr{
-												X: &ast.CallExpr{
-													Fun: ast.NewIdent("int64"),
-													Args: []ast.Expr{
-														&ast.SelectorExpr{
-															X:   ast.NewIdent("__tracewrap_memStatsAfter"),
-															Sel: ast.NewIdent("HeapAlloc"),
-														},
-													},
-												},
-												Op: token.SUB,
-												Y: &ast.CallExpr{
-													Fun: ast.NewIdent("int64"),
-													Args: []ast.Expr{
-														&ast.SelectorExpr{
-															X:   ast.NewIdent("__tracewrap_memStatsBefore"),
-															Sel: ast.NewIdent("HeapAlloc"),
-														},
-													},
-												},
-											},
-										},
-									},
-								},
-								&ast.AssignStmt{
-									Lhs: []ast.Expr{&ast.Ident{Name: "__tracewrap_endGoroutines"}},
-									Tok: token.ASSIGN,
-									Rhs: []ast.Expr{
-										&ast.CallExpr{
-											Fun: &ast.SelectorExpr{
-												X:   ast.NewIdent("runtime"),
-												Sel: ast.NewIdent("NumGoroutine"),
-											},
-											Args: []ast.Expr{},
-										},
-									},
-								},
-								&ast.ExprStmt{
-									X: &ast.CallExpr{
-										Fun: &ast.SelectorExpr{
-											X:   ast.NewIdent("tracer"),
-											Sel: ast.NewIdent("RecordGoroutineUsage"),
-										},
-										Args: []ast.Expr{
-											&ast.BasicLit{
-												Kind:  token.STRING,
-												Value: fnNameLit,
-											},
-											&ast.BinaryExpr{
-												X:  ast.NewIdent("__tracewrap_endGoroutines"),
-												Op: token.SUB,
-												Y:  ast.NewIdent("__tracewrap_startGoroutines"),
-											},
-										},
-									},
-								},
-								&ast.AssignStmt{
-									Lhs: []ast.Expr{&ast.Ident{Name: "__tracewrap_endThreads"}},
-									Tok: token.ASSIGN,
-									Rhs: []ast.Expr{
-										&ast.CallExpr{
-											Fun: &ast.SelectorExpr{
-												X:   ast.NewIdent("runtime"),
-												Sel: ast.NewIdent("NumCgoCall"),
-											},
-											Args: []ast.Expr{},
-										},
-									},
-								},
-								&ast.ExprStmt{
-									X: &ast.CallExpr{
-										Fun: &ast.SelectorExpr{
-											X:   ast.NewIdent("tracer"),
-											Sel: ast.NewIdent("RecordThreadUsage"),
-										},
-										Args: []ast.Expr{
-											&ast.BasicLit{
-												Kind:  token.STRING,
-												Value: fnNameLit,
-											},
-											&ast.BinaryExpr{
-												X:  ast.NewIdent("__tracewrap_endThreads"),
-												Op: token.SUB,
-												Y:  ast.NewIdent("__tracewrap_startThreads"),
-											},
-										},
-									},
-								},
-								&ast.AssignStmt{
-									Lhs: []ast.Expr{&ast.Ident{Name: "__tracewrap_memStatsAfter"}},
-									Tok: token.ASSIGN,
-									Rhs: []ast.Expr{
-										&ast.CompositeLit{
-											Type: ast.NewIdent("runtime.MemStats"),
-										},
-									},
-								},
-								&ast.ExprStmt{
-									X: &ast.CallExpr{
-										Fun: &ast.SelectorExpr{
-											X:   ast.NewIdent("runtime"),
-											Sel: ast.NewIdent("ReadMemStats"),
-										},
-										Args: []ast.Expr{
-											&ast.UnaryExpr{
-												Op: token.AND,
-												X:  ast.NewIdent("__tracewrap_memStatsAfter"),
-											},
-										},
-									},
-								},
-								&ast.ExprStmt{
-									X: &ast.CallExpr{
-										Fun: &ast.SelectorExpr{
-											X:   ast.NewIdent("tracer"),
-											Sel: ast.NewIdent("RecordGCActivity"),
-										},
-										Args: []ast.Expr{
-											&ast.BasicLit{
-												Kind:  token.STRING,
-												Value: fnNameLit,
-											},
-											&ast.BinaryExpr{
-												X: &ast.SelectorExpr{
-													X:   ast.NewIdent("__tracewrap_memStatsAfter"),
-													Sel: ast.NewIdent("NumGC"),
-												},
-												Op: token.SUB,
-												Y: &ast.SelectorExpr{
-													X:   ast.NewIdent("__tracewrap_memStatsBefore"),
-													Sel: ast.NewIdent("NumGC"),
-												},
-											},
-										},
-									},
-								},
-								&ast.ExprStmt{
-									X: &ast.CallExpr{
-										Fun: &ast.SelectorExpr{
-											X:   ast.NewIdent("tracer"),
-											Sel: ast.NewIdent("RecordHeapUsage"),
-										},
-										Args: []ast.Expr{
-											&ast.BasicLit{
-												Kind:  token.STRING,
-												Value: fnNameLit,
-											},
-											&ast.BinaryExpr{
-												X: &ast.CallExpr{
-													Fun: ast.NewIdent("int64"),
-													Args: []ast.Expr{
-														&ast.SelectorExpr{
-															X:   ast.NewIdent("__tracewrap_memStatsAfter"),
-															Sel: ast.NewIdent("HeapAlloc"),
-														},
-													},
-												},
-												Op: token.SUB,
-												Y: &ast.CallExpr{
-													Fun: ast.NewIdent("int64"),
-													Args: []ast.Expr{
-														&ast.SelectorExpr{
-															X:   ast.NewIdent("__tracewrap_memStatsBefore"),
-															Sel: ast.NewIdent("HeapAlloc"),
-														},
-													},
-												},
-											},
-											&ast.BasicLit{Kind: token.INT, Value: "0"},
-										},
-									},
-								},
-								&ast.AssignStmt{
-									Lhs: []ast.Expr{&ast.Ident{Name: "__tracewrap_endNetUsage"}},
-									Tok: token.ASSIGN,
-									Rhs: []ast.Expr{
-										&ast.CallExpr{
-											Fun: &ast.SelectorExpr{
-												X:   ast.NewIdent("tracer"),
-												Sel: ast.NewIdent("GetNetworkUsage"),
-											},
-											Args: []ast.Expr{},
-										},
-									},
-								},
-								&ast.AssignStmt{
-									Lhs: []ast.Expr{&ast.Ident{Name: "__tracewrap_endDiskUsage"}},
-									Tok: token.ASSIGN,
-									Rhs: []ast.Expr{
-										&ast.CallExpr{
-											Fun: &ast.SelectorExpr{
-												X:   ast.NewIdent("tracer"),
-												Sel: ast.NewIdent("GetDiskUsage"),
-											},
-											Args: []ast.Expr{},
-										},
-									},
-								},
-								&ast.ExprStmt{
-									X: &ast.CallExpr{
-										Fun: &ast.SelectorExpr{
-											X:   ast.NewIdent("tracer"),
-											Sel: ast.NewIdent("RecordIOUsage"),
-										},
-										Args: []ast.Expr{
-											&ast.BasicLit{
-												Kind:  token.STRING,
-												Value: fnNameLit,
-											},
-											&ast.BinaryExpr{
-												X:  ast.NewIdent("__tracewrap_endNetUsage"),
-												Op: token.SUB,
-												Y:  ast.NewIdent("__tracewrap_startNetUsage"),
-											},
-											&ast.BinaryExpr{
-												X:  ast.NewIdent("__tracewrap_endDiskUsage"),
-												Op: token.SUB,
-												Y:  ast.NewIdent("__tracewrap_startDiskUsage"),
-											},
-										},
-									},
-								},
-								&ast.ExprStmt{
-									X: &ast.CallExpr{
-										Fun: &ast.SelectorExpr{
-											X:   ast.NewIdent("tracer"),
-											Sel: ast.NewIdent("RecordExecutionFrequency"),
-										},
-										Args: []ast.Expr{
-											&ast.BasicLit{
-												Kind:  token.STRING,
-												Value: fnNameLit,
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-					Args: []ast.Expr{},
-				},
-			}
+// countMarker matches `//tracewrap:count name="jobs.processed"` comments on a line of their own,
+// optionally followed by `by=N` for increments other than one.
+var countMarker = regexp.MustCompile(`^(\s*)//tracewrap:count\s+name="([^"]*)"(?:\s+by=(-?\d+))?\s*$`)
 
-			newStmts := []ast.Stmt{
-				recoverStmt,
-				startTimeDecl,
-				startCPUTimeDecl,
-				startGoroutinesDecl,
-				startThreadsDecl,
-				memStatsBeforeDecl,
-				readMemStatsBefore,
-				startNetUsageDecl,
-				startDiskUsageDecl,
-				deferExit,
-				newDefer,
-				recordEntryCall,
+// expandRegionMarkers replaces region marker comments with tracer.StartRegion/tracer.EndRegion
+// calls and counter marker comments with tracer.Count calls. The replacement happens line by line
+// so line numbers of the remaining code are preserved.
+//
+// Parameters:
+//   - src ([]byte): the original source.
+//
+// Returns:
+//   - []byte: the source with markers replaced.
+//   - int: the number of markers replaced.
+func expandRegionMarkers(src []byte) ([]byte, int) {
+	lines := strings.Split(string(src), "\n")
+	count := 0
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if m := countMarker.FindStringSubmatch(line); m != nil {
+			by := m[3]
+			if by == "" {
+				by = "1"
 			}
-			newStmts = append(newStmts, paramLogs...)
-			fn.Body.List = append(newStmts, fn.Body.List...)
-			fn.Body = transformReturnsInBlock(fn.Body, fn.Name.Name)
-		}
-	}
-	if strings.HasSuffix(filePath, "main.go") {
-		dummyDecl := &ast.GenDecl{
-			Tok: token.VAR,
-			Specs: []ast.Spec{
-				&ast.ValueSpec{
-					Names: []*ast.Ident{ast.NewIdent("_dummy")},
-					Values: []ast.Expr{&ast.CallExpr{
-						Fun: &ast.SelectorExpr{
-							X:   ast.NewIdent("fmt"),
-							Sel: ast.NewIdent("Sprintf"),
-						},
-						Args: []ast.Expr{
-							&ast.BasicLit{Kind: token.STRING, Value: "\"\""},
-						},
-					}},
-				},
-			},
+			lines[i] = fmt.Sprintf("%stracer.Count(%q, %s)", m[1], m[2], by)
+			count++
+			continue
 		}
-		f.Decls = append(f.Decls, dummyDecl)
+		m := regionMarker.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		call := "StartRegion"
+		if m[2] == "end" {
+			call = "EndRegion"
+		}
+		lines[i] = fmt.Sprintf("%stracer.%s(%q)", m[1], call, m[3])
+		count++
+	}
+	if count == 0 {
+		return src, 0
 	}
+	return []byte(strings.Join(lines, "\n")), count
+}
 
-	outFile, err := os.Create(filePath)
-	if err != nil {
-		return err
+// ensureImport adds an import of pkg to the file unless it is already imported under its
+// default name. Imports using an alias, dot, or blank name do not satisfy references like
+// "tracer.RecordEntry" in injected code, so a plain import is added alongside them.
+//
+// Parameters:
+//   - f (*ast.File): the file to update.
+//   - pkg (string): the import path to ensure.
+func ensureImport(f *ast.File, pkg string) {
+	quoted := "\"" + pkg + "\""
+	defaultName := pkg[strings.LastIndex(pkg, "/")+1:]
+	for _, imp := range f.Imports {
+		if imp.Path == nil || imp.Path.Value != quoted {
+			continue
+		}
+		if imp.Name == nil || imp.Name.Name == defaultName {
+			return
+		}
+	}
+	newImport := &ast.ImportSpec{
+		Path: &ast.BasicLit{
+			Kind:  token.STRING,
+			Value: quoted,
+		},
+	}
+	f.Imports = append(f.Imports, newImport)
+	for _, decl := range f.Decls {
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.IMPORT {
+			genDecl.Specs = append(genDecl.Specs, newImport)
+			return
+		}
 	}
-	defer outFile.Close()
-	if err := printer.Fprint(outFile, fset, f); err != nil {
-		return fmt.Errorf("error printing file: %v", err)
+	importDecl := &ast.GenDecl{
+		Tok:   token.IMPORT,
+		Specs: []ast.Spec{newImport},
 	}
-	return nil
+	f.Decls = append([]ast.Decl{importDecl}, f.Decls...)
 }
 
-// transformReturnsInBlock recursively processes all statements within a block to transform return statements.
+// resultCapture is how the instrumentation passes the results of a function to RecordReturn.
+type resultCapture struct {
+	opaque []string // The results recorded by type only (see typeChecker.opaqueResults).
+	errors []bool   // The results of error types (see typeChecker.errorResults).
+	max    int      // The number of results recorded, from tracing.capture.maxReturns, or 0 for all.
+}
+
+// wrapReturns recursively processes all statements within a block to transform return statements.
 // It updates return statements by inserting instrumentation code that records return values.
 //
 // Parameters:
 //   - block (*ast.BlockStmt): pointer to the AST block statement.
-//   - functionName (string): the name of the function containing the block.
+//   - results (resultCapture): how the function's results are recorded.
 //
 // Returns:
 //   - *ast.BlockStmt: the transformed block statement.
-func transformReturnsInBlock(block *ast.BlockStmt, functionName string) *ast.BlockStmt {
+func wrapReturns(block *ast.BlockStmt, results resultCapture) *ast.BlockStmt {
 	for i, stmt := range block.List {
-		block.List[i] = transformReturnsInStmt(stmt, functionName)
+		block.List[i] = transformReturnsInStmt(stmt, results)
 	}
 	return block
 }
 
 // transformReturnsInStmt recursively processes an AST statement to transform return statements
-// by wrapping them with instrumentation for recording return values.
+// by wrapping them with instrumentation for recording return values. It descends into blocks,
+// if/for/range statements, switch, type switch and select clauses, and labeled statements, but
+// not into function literals, whose returns belong to the closure.
 //
 // Parameters:
 //   - stmt (ast.Stmt): the statement to process.
-//   - functionName (string): the name of the function containing the statement.
+//   - results (resultCapture): how the function's results are recorded.
 //
 // Returns:
 //   - ast.Stmt: the transformed statement.
-func transformReturnsInStmt(stmt ast.Stmt, functionName string) ast.Stmt {
+func transformReturnsInStmt(stmt ast.Stmt, results resultCapture) ast.Stmt {
 	switch s := stmt.(type) {
 	case *ast.BlockStmt:
-		return transformReturnsInBlock(s, functionName)
+		return wrapReturns(s, results)
 	case *ast.IfStmt:
-		s.Body = transformReturnsInBlock(s.Body, functionName)
+		s.Body = wrapReturns(s.Body, results)
 		if s.Else != nil {
-			s.Else = transformReturnsInStmt(s.Else, functionName)
+			s.Else = transformReturnsInStmt(s.Else, results)
 		}
 		return s
 	case *ast.ForStmt:
-		s.Body = transformReturnsInBlock(s.Body, functionName)
+		s.Body = wrapReturns(s.Body, results)
+		return s
+	case *ast.RangeStmt:
+		s.Body = wrapReturns(s.Body, results)
+		return s
+	case *ast.SwitchStmt:
+		s.Body = wrapReturns(s.Body, results)
+		return s
+	case *ast.TypeSwitchStmt:
+		s.Body = wrapReturns(s.Body, results)
+		return s
+	case *ast.SelectStmt:
+		s.Body = wrapReturns(s.Body, results)
+		return s
+	case *ast.CaseClause:
+		for i, bodyStmt := range s.Body {
+			s.Body[i] = transformReturnsInStmt(bodyStmt, results)
+		}
+		return s
+	case *ast.CommClause:
+		for i, bodyStmt := range s.Body {
+			s.Body[i] = transformReturnsInStmt(bodyStmt, results)
+		}
+		return s
+	case *ast.LabeledStmt:
+		s.Stmt = transformReturnsInStmt(s.Stmt, results)
 		return s
 	case *ast.ReturnStmt:
-		for _, expr := range s.Results {
-			if _, ok := expr.(*ast.CallExpr); ok {
-				return s
-			}
-			if ident, ok := expr.(*ast.Ident); ok && ident.Name == "nil" {
+		// A lone call may return several values ("return f()"), which cannot be assigned
+		// to a single temporary.
+		if len(s.Results) == 1 {
+			if _, ok := s.Results[0].(*ast.CallExpr); ok {
 				return s
 			}
 		}
-		return transformReturnStmt(s, functionName)
+		return transformReturnStmt(s, results)
 	default:
 		return s
 	}
@@ -851,18 +634,27 @@ func transformReturnsInStmt(stmt ast.Stmt, functionName string) ast.Stmt {
 
 // transformReturnStmt transforms a return statement by assigning its return values
 // to temporary variables, recording these values with the tracer, and then returning the variables.
-// This ensures that return values are logged before the function exits.
+// This ensures that return values are logged before the function exits. Values without side
+// effects (see isPureExpr) are recorded and returned directly instead of through a temporary.
+// Results past results.max are returned but not recorded, except for errors other than a literal
+// nil, which are passed so that the tracer marks the call as failed.
 //
 // Parameters:
 //   - ret (*ast.ReturnStmt): pointer to the original return statement.
-//   - functionName (string): the name of the function containing the return.
+//   - results (resultCapture): how the function's results are recorded.
 //
 // Returns:
 //   - ast.Stmt: a new block statement containing assignments, tracer recording, and the new return.
-func transformReturnStmt(ret *ast.ReturnStmt, functionName string) ast.Stmt {
+func transformReturnStmt(ret *ast.ReturnStmt, results resultCapture) ast.Stmt {
 	var assignments []ast.Stmt
 	var newIdents []ast.Expr
 	for i, expr := range ret.Results {
+		// Side-effect-free expressions are used as they are: assigning nil or an untyped
+		// constant to a temporary would fail to compile or change its type.
+		if isPureExpr(expr) {
+			newIdents = append(newIdents, expr)
+			continue
+		}
 		varName := fmt.Sprintf("_ret%d", i)
 		assignStmt := &ast.AssignStmt{
 			Lhs: []ast.Expr{&ast.Ident{Name: varName}},
@@ -872,15 +664,32 @@ func transformReturnStmt(ret *ast.ReturnStmt, functionName string) ast.Stmt {
 		assignments = append(assignments, assignStmt)
 		newIdents = append(newIdents, &ast.Ident{Name: varName})
 	}
+	recorded := append([]ast.Expr(nil), newIdents...)
+	for i, typeName := range results.opaque {
+		if typeName != "" && i < len(recorded) {
+			recorded[i] = opaqueValue(typeName)
+		}
+	}
+	if results.max > 0 && len(recorded) > results.max {
+		// Errors past the cap are still passed, so they mark the call as failed.
+		kept := recorded[:results.max:results.max]
+		for i := results.max; i < len(recorded); i++ {
+			if nilIdent, ok := recorded[i].(*ast.Ident); ok && nilIdent.Name == "nil" {
+				continue
+			}
+			if i < len(results.errors) && results.errors[i] {
+				kept = append(kept, recorded[i])
+			}
+		}
+		recorded = kept
+	}
 	recordCall := &ast.ExprStmt{
 		X: &ast.CallExpr{
 			Fun: &ast.SelectorExpr{
-				X:   &ast.Ident{Name: "tracer"},
+				X:   &ast.Ident{Name: spanVar},
 				Sel: &ast.Ident{Name: "RecordReturn"},
 			},
-			Args: append([]ast.Expr{
-				&ast.BasicLit{Kind: token.STRING, Value: "\"" + functionName + "\""},
-			}, newIdents...),
+			Args: recorded,
 		},
 	}
 	newReturn := &ast.ReturnStmt{
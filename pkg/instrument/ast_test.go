@@ -3,11 +3,13 @@ package instrument_test
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/mwiater/tracewrap/config"
 	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/tracer"
 )
 
 func TestASTInstrumentation(t *testing.T) {
@@ -58,8 +60,718 @@ func Hello() string {
 	}
 	content := string(data)
 
-	// Verify that a tracer call (e.g., RecordEntry) is present in the file.
-	if !strings.Contains(content, "RecordEntry(") {
-		t.Errorf("Instrumented file does not contain tracer call 'RecordEntry'; content: %s", content)
+	// Verify that a tracer call (e.g., RecordEntryID) is present in the file.
+	if !strings.Contains(content, "RecordEntryID(") {
+		t.Errorf("Instrumented file does not contain tracer call 'RecordEntryID'; content: %s", content)
+	}
+}
+
+func TestInstrumentationSkipsConstrainedAndGeneratedFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "asttest-skip")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A file excluded by its build constraint on every platform.
+	constrainedSrc := `//go:build ignore
+
+package main
+
+func Constrained() string {
+	return "constrained"
+}
+`
+	// A file carrying the standard generated-code header.
+	generatedSrc := `// Code generated by stringer; DO NOT EDIT.
+
+package main
+
+func Generated() string {
+	return "generated"
+}
+`
+	files := map[string]string{
+		"constrained.go": constrainedSrc,
+		"generated.go":   generatedSrc,
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(src), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	if err := instrument.SetDynamicTracerImport(tempDir); err != nil {
+		t.Fatalf("SetDynamicTracerImport failed: %v", err)
+	}
+	dummyConfig := config.Config{
+		Instrumentation: config.InstrumentationConfig{
+			Enable:        true,
+			SkipGenerated: true,
+		},
+	}
+	if err := instrument.InstrumentWorkspace(tempDir, dummyConfig); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+
+	for name, src := range files {
+		data, err := os.ReadFile(filepath.Join(tempDir, name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if string(data) != src {
+			t.Errorf("Expected %s to be left untouched; content: %s", name, string(data))
+		}
+	}
+}
+
+func TestInstrumentationSkipsTestFilesByDefault(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "asttest-tests")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	testSrc := `package main
+
+import "testing"
+
+func TestHello(t *testing.T) {
+	helper()
+}
+
+func helper() {
+}
+`
+	testFile := filepath.Join(tempDir, "hello_test.go")
+	if err := os.WriteFile(testFile, []byte(testSrc), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	if err := instrument.SetDynamicTracerImport(tempDir); err != nil {
+		t.Fatalf("SetDynamicTracerImport failed: %v", err)
+	}
+
+	// Without IncludeTests the file must be left as-is.
+	if err := instrument.InstrumentWorkspace(tempDir, config.Config{}); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+	data, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+	if string(data) != testSrc {
+		t.Errorf("Expected test file to be left untouched; content: %s", string(data))
+	}
+
+	// With IncludeTests the file is instrumented like any other.
+	includeConfig := config.Config{
+		Instrumentation: config.InstrumentationConfig{IncludeTests: true},
+	}
+	if err := instrument.InstrumentWorkspace(tempDir, includeConfig); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+	data, err = os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+	if !strings.Contains(string(data), "RecordEntryID(") {
+		t.Errorf("Expected test file to be instrumented when IncludeTests is set; content: %s", string(data))
+	}
+}
+
+func TestInitFunctionInstrumentationIsOptIn(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "asttest-init")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	initSrc := `package main
+
+func init() {
+	setup()
+}
+
+func setup() {
+}
+`
+	initFile := filepath.Join(tempDir, "init.go")
+	if err := os.WriteFile(initFile, []byte(initSrc), 0644); err != nil {
+		t.Fatalf("Failed to write init file: %v", err)
+	}
+	if err := instrument.SetDynamicTracerImport(tempDir); err != nil {
+		t.Fatalf("SetDynamicTracerImport failed: %v", err)
+	}
+
+	initConfig := config.Config{
+		Instrumentation: config.InstrumentationConfig{InstrumentInit: true},
+	}
+	if err := instrument.InstrumentWorkspace(tempDir, initConfig); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+	data, err := os.ReadFile(initFile)
+	if err != nil {
+		t.Fatalf("Failed to read init file: %v", err)
+	}
+	if !strings.Contains(string(data), `, "init")`) {
+		t.Errorf("Expected init to be instrumented when InstrumentInit is set; content: %s", string(data))
+	}
+}
+
+func TestMainInjectionsAreGatedByPackage(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "asttest-main")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		// A func literally named main outside package main is an ordinary function.
+		"lib/main.go": `package lib
+
+func main() {
+}
+`,
+		// A file without functions must not gain (unused) imports.
+		"types.go": `package main
+
+type Point struct {
+	X, Y int
+}
+`,
+		"main.go": `package main
+
+func main() {
+}
+`,
+	}
+	for name, src := range files {
+		path := filepath.Join(tempDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := instrument.SetDynamicTracerImport(tempDir); err != nil {
+		t.Fatalf("SetDynamicTracerImport failed: %v", err)
+	}
+	if err := instrument.InstrumentWorkspace(tempDir, config.Config{}); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(tempDir, name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		return string(data)
+	}
+	if content := read("lib/main.go"); strings.Contains(content, "DumpCallGraphDOT") {
+		t.Errorf("Expected no call graph injection outside package main; content: %s", content)
+	}
+	if content := read("types.go"); content != files["types.go"] {
+		t.Errorf("Expected file without functions to be left untouched; content: %s", content)
+	}
+	content := read("main.go")
+	if !strings.Contains(content, "DumpCallGraphDOT") {
+		t.Errorf("Expected call graph injection in package main; content: %s", content)
+	}
+	if strings.Contains(content, "_dummy") || strings.Contains(content, `"fmt"`) {
+		t.Errorf("Expected no dummy variable or unused fmt import; content: %s", content)
+	}
+}
+
+func TestRegionMarkersBecomeTracerCalls(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "asttest-region")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := `package main
+
+func checkout() {
+	//tracewrap:region start name="checkout"
+	pay()
+	//tracewrap:count name="orders.placed"
+	//tracewrap:count name="stock.reserved" by=3
+	//tracewrap:region end
+}
+
+func pay() {
+}
+`
+	file := filepath.Join(tempDir, "checkout.go")
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := instrument.SetDynamicTracerImport(tempDir); err != nil {
+		t.Fatalf("SetDynamicTracerImport failed: %v", err)
+	}
+	if err := instrument.InstrumentWorkspace(tempDir, config.Config{}); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	content := string(data)
+	if !strings.Contains(content, `tracer.StartRegion("checkout")`) || !strings.Contains(content, `tracer.EndRegion("")`) {
+		t.Errorf("Expected region markers to be replaced by tracer calls; content: %s", content)
+	}
+	if !strings.Contains(content, `tracer.Count("orders.placed", 1)`) || !strings.Contains(content, `tracer.Count("stock.reserved", 3)`) {
+		t.Errorf("Expected counter markers to be replaced by tracer calls; content: %s", content)
+	}
+}
+
+func TestCaptureBasicKindsOnlySkipsCompositeParams(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "asttest-basic")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := `package main
+
+type order struct{ items []string }
+
+func place(id int, name string, o *order, tags map[string]bool) {
+}
+`
+	file := filepath.Join(tempDir, "place.go")
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := instrument.SetDynamicTracerImport(tempDir); err != nil {
+		t.Fatalf("SetDynamicTracerImport failed: %v", err)
+	}
+	cfg := config.Config{Instrumentation: config.InstrumentationConfig{CaptureBasicKindsOnly: true}}
+	if err := instrument.InstrumentWorkspace(tempDir, cfg); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	content := string(data)
+	if !strings.Contains(content, `__tracewrap_span.SetAttribute("id", id)`) || !strings.Contains(content, `__tracewrap_span.SetAttribute("name", name)`) {
+		t.Errorf("Expected basic parameters to be recorded; content: %s", content)
+	}
+	if strings.Contains(content, `SetAttribute("o"`) || strings.Contains(content, `SetAttribute("tags"`) {
+		t.Errorf("Expected composite parameters to be skipped; content: %s", content)
+	}
+}
+
+func TestCorrelateLogsWrapsLogOutputs(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "asttest-logs")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := `package main
+
+import (
+	"log"
+	"log/slog"
+	"os"
+)
+
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+func main() {
+	log.SetOutput(os.Stderr)
+	logger.Info("started")
+}
+`
+	file := filepath.Join(tempDir, "main.go")
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := instrument.SetDynamicTracerImport(tempDir); err != nil {
+		t.Fatalf("SetDynamicTracerImport failed: %v", err)
+	}
+	cfg := config.Config{Instrumentation: config.InstrumentationConfig{CorrelateLogs: true, SkipMainInjections: true}}
+	if err := instrument.InstrumentWorkspace(tempDir, cfg); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	content := string(data)
+	for _, want := range []string{
+		"slog.New(tracer.NewLogHandler(slog.NewJSONHandler(os.Stdout, nil)))",
+		"log.SetOutput(tracer.NewLogWriter(os.Stderr))",
+		"tracer.CorrelateLogs()",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("Expected %q in instrumented source; content: %s", want, content)
+		}
+	}
+	// The logger's own method calls are left alone.
+	if strings.Contains(content, "tracer.NewLogHandler(\"started\")") || strings.Contains(content, "NewLogWriter(\"started\")") {
+		t.Errorf("Unexpected rewrite of logger.Info; content: %s", content)
+	}
+}
+
+func TestBridgeOpenTelemetryWrapsTracerProviders(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "asttest-otel")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := `package main
+
+import (
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func main() {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	otel.Tracer("app")
+}
+`
+	file := filepath.Join(tempDir, "main.go")
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := instrument.SetDynamicTracerImport(tempDir); err != nil {
+		t.Fatalf("SetDynamicTracerImport failed: %v", err)
+	}
+	cfg := config.Config{Instrumentation: config.InstrumentationConfig{BridgeOpenTelemetry: true, SkipMainInjections: true}}
+	if err := instrument.InstrumentWorkspace(tempDir, cfg); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	content := string(data)
+	for _, want := range []string{
+		`"github.com/mwiater/tracewrap/pkg/otelbridge"`,
+		"otelbridge.Install()",
+		"otel.SetTracerProvider(otelbridge.Wrap(sdktrace.NewTracerProvider()))",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("Expected %q in instrumented source; content: %s", want, content)
+		}
+	}
+	if strings.Contains(content, `otelbridge.Wrap("app")`) {
+		t.Errorf("Unexpected rewrite of otel.Tracer; content: %s", content)
+	}
+}
+
+func TestSkipExitRewriteLeavesOsExitAlone(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "asttest-exit")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := `package main
+
+import sys "os"
+
+func main() {
+	sys.Exit(3)
+}
+`
+	file := filepath.Join(tempDir, "main.go")
+	for _, tc := range []struct {
+		skip bool
+		want bool
+	}{{false, true}, {true, false}} {
+		if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		cfg := config.Config{Instrumentation: config.InstrumentationConfig{SkipExitRewrite: tc.skip}}
+		if err := instrument.InstrumentWorkspace(tempDir, cfg); err != nil {
+			t.Fatalf("InstrumentWorkspace returned error: %v", err)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read file: %v", err)
+		}
+		if got := strings.Contains(string(data), "tracer.Shutdown()\n\tsys.Exit(3)"); got != tc.want {
+			t.Errorf("skipExitRewrite=%v: expected rewrite %v, got %v; content: %s", tc.skip, tc.want, got, data)
+		}
+	}
+}
+
+func TestInstrumentWorkspaceWritesInventory(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "asttest-inventory")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := `package main
+
+func main() {
+	if true {
+		helper()
+	}
+}
+
+func helper() {
+	x := 1
+	_ = x
+}
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "main.go"), []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := instrument.InstrumentWorkspace(tempDir, config.Config{}); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+	inv, err := instrument.ReadInventory(filepath.Join(tempDir, instrument.InventoryFile))
+	if err != nil {
+		t.Fatalf("ReadInventory returned error: %v", err)
+	}
+	want := []instrument.Function{
+		{Name: "main", ID: 2, Func: "main", Package: "main", File: "main.go", Line: 3, EndLine: 7, Statements: 2, Calls: []string{"helper"}},
+		{Name: "helper", ID: 1, Func: "helper", Package: "main", File: "main.go", Line: 9, EndLine: 12, Statements: 2},
+	}
+	if len(inv.Functions) != len(want) {
+		t.Fatalf("Expected %d functions, got %+v", len(want), inv.Functions)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(inv.Functions[i], want[i]) {
+			t.Errorf("Function %d: expected %+v, got %+v", i, want[i], inv.Functions[i])
+		}
+	}
+
+	symbols, err := tracer.ReadSymbols(filepath.Join(tempDir, instrument.SymbolsFile))
+	if err != nil {
+		t.Fatalf("ReadSymbols returned error: %v", err)
+	}
+	if !reflect.DeepEqual(symbols.Names, []string{"helper", "main"}) {
+		t.Errorf("Expected the symbol table to number helper and main, got %v", symbols.Names)
+	}
+}
+
+func TestNameFormatUpgradesAmbiguousNames(t *testing.T) {
+	files := map[string]string{
+		"go.mod": "module example.com/app\n",
+		"main.go": `package main
+
+type A struct{}
+type B struct{}
+
+func (A) String() string { return "a" }
+func (*B) String() string { return "b" }
+
+func Run() {}
+
+func main() { Run() }
+`,
+		"worker/worker.go": `package worker
+
+func Run() {}
+
+func Stop() {}
+`,
+	}
+	cases := []struct {
+		format string
+		want   map[string][]string
+	}{
+		{"", map[string][]string{
+			"main.go":          {`"main.A.String"`, `"main.B.String"`, `"main.Run"`, `"main"`},
+			"worker/worker.go": {`"worker.Run"`, `"Stop"`},
+		}},
+		{"package", map[string][]string{
+			"main.go":          {`"main.A.String"`, `"main.Run"`, `"main.main"`},
+			"worker/worker.go": {`"worker.Run"`, `"worker.Stop"`},
+		}},
+		{"full", map[string][]string{
+			"main.go":          {`"example.com/app.B.String"`, `"example.com/app.main"`},
+			"worker/worker.go": {`"example.com/app/worker.Run"`},
+		}},
+	}
+	for _, tc := range cases {
+		t.Run("format="+tc.format, func(t *testing.T) {
+			tempDir, err := os.MkdirTemp("", "asttest-names")
+			if err != nil {
+				t.Fatalf("Failed to create temp directory: %v", err)
+			}
+			defer os.RemoveAll(tempDir)
+			for name, src := range files {
+				path := filepath.Join(tempDir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatalf("Failed to create directory: %v", err)
+				}
+				if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+					t.Fatalf("Failed to write file: %v", err)
+				}
+			}
+			cfg := config.Config{Instrumentation: config.InstrumentationConfig{NameFormat: tc.format}}
+			if err := instrument.InstrumentWorkspace(tempDir, cfg); err != nil {
+				t.Fatalf("InstrumentWorkspace returned error: %v", err)
+			}
+			for name, wants := range tc.want {
+				data, err := os.ReadFile(filepath.Join(tempDir, name))
+				if err != nil {
+					t.Fatalf("Failed to read %s: %v", name, err)
+				}
+				for _, want := range wants {
+					if !strings.Contains(string(data), ", "+want+")") {
+						t.Errorf("Expected %s to record %s:\n%s", name, want, data)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestInstrumentWorkspaceKeepsInvalidOutputUninstrumented(t *testing.T) {
+	tempDir := t.TempDir()
+	// The package-level debug variable clashes with the runtime/debug import the instrumentation adds.
+	clashSrc := `package lib
+
+var debug = 3
+
+func level() int {
+	return debug
+}
+`
+	okSrc := `package main
+
+func main() {
+	println("ok")
+}
+`
+	clashFile := filepath.Join(tempDir, "lib", "clash.go")
+	if err := os.MkdirAll(filepath.Dir(clashFile), 0755); err != nil {
+		t.Fatalf("Failed to create lib: %v", err)
+	}
+	if err := os.WriteFile(clashFile, []byte(clashSrc), 0644); err != nil {
+		t.Fatalf("Failed to write clash.go: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "main.go"), []byte(okSrc), 0644); err != nil {
+		t.Fatalf("Failed to write main.go: %v", err)
+	}
+	if err := instrument.SetDynamicTracerImport(tempDir); err != nil {
+		t.Fatalf("SetDynamicTracerImport failed: %v", err)
+	}
+
+	if err := instrument.InstrumentWorkspace(tempDir, config.Config{}); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+	data, err := os.ReadFile(clashFile)
+	if err != nil {
+		t.Fatalf("Failed to read clash.go: %v", err)
+	}
+	if string(data) != clashSrc {
+		t.Errorf("Expected clash.go to keep its original source; content: %s", string(data))
+	}
+	data, err = os.ReadFile(filepath.Join(tempDir, "main.go"))
+	if err != nil {
+		t.Fatalf("Failed to read main.go: %v", err)
+	}
+	if !strings.Contains(string(data), "RecordEntryID(") {
+		t.Errorf("Expected main.go to be instrumented; content: %s", string(data))
+	}
+
+	inv, err := instrument.ReadInventory(filepath.Join(tempDir, instrument.InventoryFile))
+	if err != nil {
+		t.Fatalf("ReadInventory failed: %v", err)
+	}
+	if len(inv.Fallbacks) != 1 || inv.Fallbacks[0].File != "lib/clash.go" || !strings.Contains(inv.Fallbacks[0].Reason, "debug") {
+		t.Errorf("Expected lib/clash.go to be listed as a fallback, got %+v", inv.Fallbacks)
+	}
+	for _, fn := range inv.Functions {
+		if fn.File == "lib/clash.go" {
+			t.Errorf("Expected no functions of lib/clash.go in the inventory, got %+v", fn)
+		}
+	}
+}
+
+func TestCaptureCapsLimitInjectedCaptures(t *testing.T) {
+	tempDir := t.TempDir()
+	src := `package main
+
+import "errors"
+
+func place(a, b, c int, name string) (int, string, error) {
+	if name == "" {
+		return 0, name, errEmpty
+	}
+	return a + b, name, nil
+}
+
+var errEmpty = errors.New("empty name")
+`
+	file := filepath.Join(tempDir, "place.go")
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := instrument.SetDynamicTracerImport(tempDir); err != nil {
+		t.Fatalf("SetDynamicTracerImport failed: %v", err)
+	}
+	var cfg config.Config
+	cfg.Tracing.Capture = config.CaptureConfig{MaxParams: 2, MaxReturns: 1}
+	if err := instrument.InstrumentWorkspace(tempDir, cfg); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	content := string(data)
+	if !strings.Contains(content, `SetAttribute("a", a)`) || !strings.Contains(content, `SetAttribute("b", b)`) {
+		t.Errorf("Expected the first two parameters to be recorded; content: %s", content)
+	}
+	if strings.Contains(content, `SetAttribute("c"`) || strings.Contains(content, `SetAttribute("name"`) {
+		t.Errorf("Expected the parameters past the cap to be skipped; content: %s", content)
+	}
+	if !strings.Contains(content, "__tracewrap_span.RecordReturn(a + b)\n") || !strings.Contains(content, "return a + b, name, nil") {
+		t.Errorf("Expected only the first result to be recorded, and all to be returned; content: %s", content)
+	}
+	if !strings.Contains(content, "__tracewrap_span.RecordReturn(0, errEmpty)\n") {
+		t.Errorf("Expected the error past the cap to be passed; content: %s", content)
+	}
+}
+
+func TestLightModeInjectsNoCaptures(t *testing.T) {
+	src := `package main
+
+func parse(s string, n int) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return n, nil
+}
+
+func main() { parse("1", 1) }
+`
+	for _, injection := range []string{instrument.InjectAST, instrument.InjectTemplate} {
+		dir := t.TempDir()
+		file := filepath.Join(dir, "main.go")
+		if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg := config.Config{Instrumentation: config.InstrumentationConfig{Enable: true, Injection: injection}}
+		cfg.Tracing.Light = true
+		if err := instrument.InstrumentWorkspace(dir, cfg); err != nil {
+			t.Fatalf("%s: InstrumentWorkspace returned error: %v", injection, err)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := string(data)
+		if !strings.Contains(content, "__tracewrap_span.RecordAll(") {
+			t.Errorf("%s: expected the exit to be injected, got:\n%s", injection, content)
+		}
+		if strings.Contains(content, "SetAttribute(") || strings.Contains(content, "RecordReturn(") {
+			t.Errorf("%s: expected no captures in light mode, got:\n%s", injection, content)
+		}
 	}
 }
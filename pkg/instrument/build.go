@@ -1,59 +1,142 @@
 package instrument
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"github.com/mwiater/tracewrap/pkg/version"
 )
 
 // BuildInstrumentedBinary runs the necessary Go commands ("go mod tidy", "go get", and "go build")
 // in the workspace directory to build the instrumented binary. It preserves environment variables.
-// It returns the path to the built binary and an error if any command fails.
+// The configuration is embedded into the binary through linker flags so the tracer can read it
+// at runtime. It returns the path to the built binary and an error if any command fails; a failed
+// build is a *BuildError.
 //
 // Parameters:
 //   - workspace (string): the path to the workspace directory.
+//   - cfg (config.Config): the configuration to embed into the instrumented binary.
+//   - buildFlags (...string): extra flags for "go build", e.g. "-race".
 //
 // Returns:
 //   - string: the path to the built instrumented binary.
 //   - error: an error object if any step in the build process fails.
-func BuildInstrumentedBinary(workspace string) (string, error) {
-	fmt.Println("Running 'go mod tidy' in workspace:", workspace)
+func BuildInstrumentedBinary(workspace string, cfg config.Config, buildFlags ...string) (string, error) {
+	if err := prepareModule(workspace); err != nil {
+		return "", err
+	}
+	binaryPath, out, err := buildBinary(workspace, "", cfg, buildFlags)
+	if err != nil {
+		return "", &BuildError{Err: err, Output: out}
+	}
+	fmt.Fprintln(Progress, "Binary built successfully at:", binaryPath)
+	return binaryPath, nil
+}
+
+// prepareModule runs "go mod tidy" and "go get" in the workspace so the instrumented sources
+// resolve the tracer package.
+//
+// Parameters:
+//   - workspace (string): the path to the workspace directory.
+//
+// Returns:
+//   - error: an error object if either command fails.
+func prepareModule(workspace string) error {
+	fmt.Fprintln(Progress, "Running 'go mod tidy' in workspace:", workspace)
 	cmdTidy := exec.Command("go", "mod", "tidy")
 	cmdTidy.Dir = workspace
 	cmdTidy.Env = os.Environ()
 	out, err := cmdTidy.CombinedOutput()
 	if err != nil {
-		return "", fmt.Errorf("go mod tidy failed: %v, output: %s", err, string(out))
+		return fmt.Errorf("go mod tidy failed: %v, output: %s", err, string(out))
 	}
-	fmt.Println("go mod tidy completed successfully.")
+	fmt.Fprintln(Progress, "go mod tidy completed successfully.")
 
-	fmt.Println("Running 'go get github.com/mwiater/tracewrap@latest' in workspace:", workspace)
+	fmt.Fprintln(Progress, "Running 'go get github.com/mwiater/tracewrap@latest' in workspace:", workspace)
 	cmdGet := exec.Command("go", "get", "github.com/mwiater/tracewrap@latest")
 	cmdGet.Dir = workspace
 	cmdGet.Env = os.Environ()
 	out, err = cmdGet.CombinedOutput()
 	if err != nil {
-		return "", fmt.Errorf("failed to get tracewrap repository: %v, output: %s", err, string(out))
+		return fmt.Errorf("failed to get tracewrap repository: %v, output: %s", err, string(out))
 	}
-	fmt.Println("Tracewrap repository acquired successfully.")
+	fmt.Fprintln(Progress, "Tracewrap repository acquired successfully.")
+	return nil
+}
 
+// buildBinary runs "go build" in the workspace, with the configuration and the git state of the
+// project stamped in by linkerFlags. The go command's own VCS stamping is turned off, since it
+// would describe the workspace rather than the project.
+//
+// Parameters:
+//   - workspace (string): the path to the workspace directory.
+//   - projectDir (string): the project the workspace was prepared from, or "" if unknown.
+//   - cfg (config.Config): the configuration to embed into the instrumented binary.
+//   - buildFlags ([]string): extra flags for "go build".
+//
+// Returns:
+//   - string: the path to the built binary.
+//   - []byte: the output of "go build", holding the compiler errors when it fails.
+//   - error: an error object if the build fails.
+func buildBinary(workspace, projectDir string, cfg config.Config, buildFlags []string) (string, []byte, error) {
 	binaryName := "tracedApp"
 	if runtime.GOOS == "windows" {
 		binaryName += ".exe"
 	}
 	binaryPath := filepath.Join(workspace, binaryName)
-	fmt.Println("Building instrumented binary:", binaryPath)
-	cmdBuild := exec.Command("go", "build", "-o", binaryPath)
+	var vcs *vcsState
+	if projectDir != "" {
+		if state, ok := projectVCS(projectDir); ok {
+			vcs = &state
+		}
+	}
+	ldflags, err := linkerFlags(cfg, vcs)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to prepare linker flags: %v", err)
+	}
+	fmt.Fprintln(Progress, "Building instrumented binary:", binaryPath)
+	buildArgs := append([]string{"build", "-buildvcs=false"}, buildFlags...)
+	cmdBuild := exec.Command("go", append(buildArgs, "-ldflags", ldflags, "-o", binaryPath)...)
 	cmdBuild.Dir = workspace
 	cmdBuild.Env = os.Environ()
-	out, err = cmdBuild.CombinedOutput()
+	out, err := cmdBuild.CombinedOutput()
 	if err != nil {
-		return "", fmt.Errorf("build failed: %v, output: %s", err, string(out))
+		return "", out, err
 	}
-	fmt.Println("Binary built successfully at:", binaryPath)
-	return binaryPath, nil
+	return binaryPath, out, nil
+}
+
+// linkerFlags returns the -ldflags value that stamps the configuration, the tracewrap version,
+// a hash of the configuration and the git state of the project into the tracer package of the
+// instrumented binary.
+//
+// Parameters:
+//   - cfg (config.Config): the configuration to embed.
+//   - vcs (*vcsState): the git state of the project, or nil if it is not known.
+//
+// Returns:
+//   - string: the linker flags.
+//   - error: an error object if the configuration cannot be encoded.
+func linkerFlags(cfg config.Config, vcs *vcsState) (string, error) {
+	encoded, err := tracer.EncodeConfig(cfg)
+	if err != nil {
+		return "", err
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(encoded)))[:12]
+	flags := fmt.Sprintf("-X %[1]s.embeddedConfig=%[2]s -X %[1]s.tracewrapVersion=%[3]s -X %[1]s.configHash=%[4]s",
+		tracerPackagePath, encoded, version.Get().Version, hash)
+	if vcs != nil {
+		flags += fmt.Sprintf(" -X %[1]s.vcsRevision=%[2]s -X %[1]s.vcsTime=%[3]s -X %[1]s.vcsModified=%[4]t",
+			tracerPackagePath, vcs.Revision, vcs.Time, vcs.Modified)
+	}
+	return flags, nil
 }
 
 // RunInstrumentedBinary executes the built binary located at binaryPath with any additional command-line arguments.
@@ -66,10 +149,35 @@ func BuildInstrumentedBinary(workspace string) (string, error) {
 // Returns:
 //   - error: an error object if the binary execution fails.
 func RunInstrumentedBinary(binaryPath string, args []string) error {
-	fmt.Println("Running instrumented binary:", binaryPath, "with args:", args)
+	fmt.Fprintln(Progress, "Running instrumented binary:", binaryPath, "with args:", args)
 	cmd := exec.Command(binaryPath, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Env = os.Environ()
 	return cmd.Run()
 }
+
+// StartInstrumentedBinary starts the built binary with the given arguments without waiting for it
+// to finish, sending its standard output and error to stdout and stderr. The caller must call Wait
+// on the returned command.
+//
+// Parameters:
+//   - binaryPath (string): the path to the instrumented binary.
+//   - args ([]string): additional arguments to pass to the binary.
+//   - stdout (io.Writer): where the binary's standard output is written.
+//   - stderr (io.Writer): where the binary's standard error is written.
+//
+// Returns:
+//   - *exec.Cmd: the running command.
+//   - error: an error object if the binary cannot be started.
+func StartInstrumentedBinary(binaryPath string, args []string, stdout, stderr io.Writer) (*exec.Cmd, error) {
+	fmt.Fprintln(Progress, "Starting instrumented binary:", binaryPath, "with args:", args)
+	cmd := exec.Command(binaryPath, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = os.Environ()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
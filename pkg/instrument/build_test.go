@@ -1,3 +1,141 @@
 package instrument_test
 
-// TO DO
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/pkg/instrument"
+)
+
+func TestRenderOutputPath(t *testing.T) {
+	data := instrument.OutputNameData{App: "simple", GOOS: "linux", GOARCH: "amd64"}
+	tests := []struct {
+		name     string
+		tmpl     string
+		data     instrument.OutputNameData
+		expected string
+		wantErr  bool
+	}{
+		{name: "plain path", tmpl: "bin/app", data: data, expected: "bin/app"},
+		{name: "template", tmpl: "dist/{{.App}}-traced-{{.GOOS}}-{{.GOARCH}}", data: data, expected: "dist/simple-traced-linux-amd64"},
+		{name: "windows adds exe", tmpl: "{{.App}}", data: instrument.OutputNameData{App: "simple", GOOS: "windows"}, expected: "simple.exe"},
+		{name: "windows keeps extension", tmpl: "{{.App}}.bin", data: instrument.OutputNameData{App: "simple", GOOS: "windows"}, expected: "simple.bin"},
+		{name: "unknown field", tmpl: "{{.Version}}", data: data, wantErr: true},
+		{name: "invalid template", tmpl: "{{.App", data: data, wantErr: true},
+		{name: "empty result", tmpl: "{{if false}}x{{end}}", data: data, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := instrument.RenderOutputPath(tt.tmpl, tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RenderOutputPath failed: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMoveFileCreatesDirectoryAndRemovesSource(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "movefile")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	src := filepath.Join(tmpDir, "app")
+	if err := os.WriteFile(src, []byte("binary"), 0755); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	dst := filepath.Join(tmpDir, "dist", "nested", "app-traced")
+	if err := instrument.MoveFile(src, dst); err != nil {
+		t.Fatalf("MoveFile failed: %v", err)
+	}
+
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("failed to read destination: %v", err)
+	}
+	if string(data) != "binary" {
+		t.Errorf("expected destination content %q, got %q", "binary", data)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatalf("failed to stat destination: %v", err)
+	}
+	if info.Mode().Perm()&0100 == 0 {
+		t.Errorf("expected destination to stay executable, got mode %v", info.Mode())
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("expected source to be removed, stat returned %v", err)
+	}
+}
+
+func TestMoveFileMissingSource(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "movefile")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	if err := instrument.MoveFile(filepath.Join(tmpDir, "missing"), filepath.Join(tmpDir, "out")); err == nil {
+		t.Error("expected an error for a missing source")
+	}
+}
+
+func TestProjectVCSReadsTheProjectRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "services", "api")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@example.com", "GIT_COMMITTER_NAME=a",
+			"GIT_COMMITTER_EMAIL=a@example.com", "GIT_COMMITTER_DATE=2024-05-01T10:00:00Z")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git %v failed: %v", args, err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if _, ok := instrument.ProjectVCS(sub); ok {
+		t.Fatal("Expected no state outside a repository")
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "Add api")
+	head := git("rev-parse", "HEAD")
+
+	// A project in a subdirectory has the state of its repository; untracked files are no change.
+	if err := os.WriteFile(filepath.Join(sub, "trace.jsonl"), nil, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	state, ok := instrument.ProjectVCS(sub)
+	if !ok || state.Revision != head || state.Time != "2024-05-01T10:00:00Z" || state.Modified {
+		t.Errorf("Expected revision %s of 2024-05-01T10:00:00Z, unmodified, got %+v (%v)", head, state, ok)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if state, _ := instrument.ProjectVCS(sub); !state.Modified {
+		t.Errorf("Expected a change to a tracked file to count, got %+v", state)
+	}
+}
@@ -0,0 +1,377 @@
+package instrument
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+
+	"github.com/mwiater/tracewrap/config"
+)
+
+// The builders below return the statements instrumentFile injects into each function, in order:
+//
+//	__tracewrap_snapshot := tracer.TakeSnapshot(tracer.MeasureAll) // buildStartStmts
+//	__tracewrap_span := tracer.RecordEntryID(...)
+//	defer __tracewrap_span.RecordAll(__tracewrap_snapshot)          // buildExitDefer
+//	defer func() { ... __tracewrap_span.RecordPanic ... }()         // buildPanicDefer
+//	__tracewrap_span.SetAttribute(...) ...                          // buildParamStmts
+//
+// and wrapReturns records the results at each return. The hooks are called on the span
+// tracer.RecordEntry returns rather than on the innermost open call, so they reach the function's
+// record whatever runs in between. A new capture feature adds a builder, or a statement to one,
+// instead of growing instrumentFile.
+
+// spanVar is the variable holding the *tracer.Span of the instrumented call.
+const spanVar = "__tracewrap_span"
+
+// selectorCall returns the call pkg.name(args...).
+func selectorCall(pkg, name string, args ...ast.Expr) *ast.CallExpr {
+	return &ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: ast.NewIdent(pkg), Sel: ast.NewIdent(name)},
+		Args: args,
+	}
+}
+
+// spanCall returns the call __tracewrap_span.name(args...).
+func spanCall(name string, args ...ast.Expr) *ast.CallExpr {
+	return selectorCall(spanVar, name, args...)
+}
+
+// stringLit returns the string literal of s.
+func stringLit(s string) *ast.BasicLit {
+	return &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(s)}
+}
+
+// intLit returns the integer literal of n.
+func intLit(n int) *ast.BasicLit {
+	return &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(n)}
+}
+
+// define returns the statement name := value.
+func define(name string, value ast.Expr) *ast.AssignStmt {
+	return &ast.AssignStmt{Lhs: []ast.Expr{ast.NewIdent(name)}, Tok: token.DEFINE, Rhs: []ast.Expr{value}}
+}
+
+// assign returns the statement name = value.
+func assign(name string, value ast.Expr) *ast.AssignStmt {
+	return &ast.AssignStmt{Lhs: []ast.Expr{ast.NewIdent(name)}, Tok: token.ASSIGN, Rhs: []ast.Expr{value}}
+}
+
+// deferFunc returns the statement defer func() { body }().
+func deferFunc(body ...ast.Stmt) *ast.DeferStmt {
+	return &ast.DeferStmt{
+		Call: &ast.CallExpr{
+			Fun: &ast.FuncLit{
+				Type: &ast.FuncType{Params: &ast.FieldList{}},
+				Body: &ast.BlockStmt{List: body},
+			},
+		},
+	}
+}
+
+// buildPanicDefer returns the deferred function that records a panic of the function with its
+// stack and re-panics, so the panic still unwinds as it would without instrumentation. Deferred
+// after the exit defer, it runs before it, while the call is still open:
+//
+//	defer func() {
+//		r := recover()
+//		if r != nil {
+//			__tracewrap_span.RecordPanic(r, string(debug.Stack()))
+//			panic(r)
+//		}
+//	}()
+//
+// Returns:
+//   - *ast.DeferStmt: the defer statement.
+func buildPanicDefer() *ast.DeferStmt {
+	return deferFunc(
+		define("r", &ast.CallExpr{Fun: ast.NewIdent("recover")}),
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{X: ast.NewIdent("r"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+			Body: &ast.BlockStmt{List: []ast.Stmt{
+				&ast.ExprStmt{X: spanCall("RecordPanic",
+					ast.NewIdent("r"),
+					&ast.CallExpr{Fun: ast.NewIdent("string"), Args: []ast.Expr{selectorCall("debug", "Stack")}},
+				)},
+				&ast.ExprStmt{X: &ast.CallExpr{Fun: ast.NewIdent("panic"), Args: []ast.Expr{ast.NewIdent("r")}}},
+			}},
+		},
+	)
+}
+
+// Metrics of InstrumentationConfig.Metrics.
+const (
+	MetricTime       = "time"       // Process CPU time.
+	MetricMem        = "mem"        // Heap allocation and GC cycles, from runtime.ReadMemStats.
+	MetricGoroutines = "goroutines" // Goroutines started and cgo calls made.
+	MetricIO         = "io"         // Network and disk usage.
+)
+
+// metricSet is the set of metrics the injected code measures each call for.
+type metricSet struct {
+	Time, Mem, Goroutines, IO bool
+}
+
+// injectedMetrics returns the metrics listed in instrumentation.metrics; all of them if the list
+// is empty.
+func injectedMetrics(cfg config.Config) metricSet {
+	if len(cfg.Instrumentation.Metrics) == 0 {
+		return metricSet{Time: true, Mem: true, Goroutines: true, IO: true}
+	}
+	var m metricSet
+	for _, name := range cfg.Instrumentation.Metrics {
+		switch name {
+		case MetricTime:
+			m.Time = true
+		case MetricMem:
+			m.Mem = true
+		case MetricGoroutines:
+			m.Goroutines = true
+		case MetricIO:
+			m.IO = true
+		}
+	}
+	return m
+}
+
+// flags returns the expression of the tracer.Measure* flags of m, e.g.
+// tracer.MeasureTime | tracer.MeasureIO.
+func (m metricSet) flags() ast.Expr {
+	if m.Time && m.Mem && m.Goroutines && m.IO {
+		return &ast.SelectorExpr{X: ast.NewIdent("tracer"), Sel: ast.NewIdent("MeasureAll")}
+	}
+	var expr ast.Expr
+	for _, metric := range []struct {
+		on   bool
+		flag string
+	}{{m.Time, "MeasureTime"}, {m.Mem, "MeasureMem"}, {m.Goroutines, "MeasureGoroutines"}, {m.IO, "MeasureIO"}} {
+		if !metric.on {
+			continue
+		}
+		flag := &ast.SelectorExpr{X: ast.NewIdent("tracer"), Sel: ast.NewIdent(metric.flag)}
+		if expr == nil {
+			expr = flag
+		} else {
+			expr = &ast.BinaryExpr{X: expr, Op: token.OR, Y: flag}
+		}
+	}
+	if expr == nil {
+		return intLit(0)
+	}
+	return expr
+}
+
+// buildStartStmts returns the statements that open the call: the baseline the exit defer
+// measures the call against, for the metrics of m, and the entry of the call, whose span the
+// hooks that follow are called on:
+//
+//	__tracewrap_snapshot := tracer.TakeSnapshot(tracer.MeasureAll)
+//	__tracewrap_span := tracer.RecordEntryID(id, "name")
+//
+// A function without an ID enters with tracer.RecordEntry("name").
+//
+// Parameters:
+//   - traceName (string): the name the function's records carry.
+//   - traceID (int): the ID of the name in the symbol table, or 0.
+//   - m (metricSet): the metrics measured.
+//
+// Returns:
+//   - []ast.Stmt: the statements.
+func buildStartStmts(traceName string, traceID int, m metricSet) []ast.Stmt {
+	entry := selectorCall("tracer", "RecordEntry", stringLit(traceName))
+	if traceID > 0 {
+		entry = selectorCall("tracer", "RecordEntryID", intLit(traceID), stringLit(traceName))
+	}
+	return []ast.Stmt{
+		define("__tracewrap_snapshot", selectorCall("tracer", "TakeSnapshot", m.flags())),
+		define(spanVar, entry),
+	}
+}
+
+// buildExitDefer returns the deferred statement that closes the call, recording the resources it
+// used against the baseline of buildStartStmts, counting it and recording its duration:
+//
+//	defer __tracewrap_span.RecordAll(__tracewrap_snapshot)
+//
+// Returns:
+//   - []ast.Stmt: the defer statement.
+func buildExitDefer() []ast.Stmt {
+	return []ast.Stmt{&ast.DeferStmt{Call: spanCall("RecordAll", ast.NewIdent("__tracewrap_snapshot"))}}
+}
+
+// entryParam is a named parameter of a function and what its entry statements capture of it.
+type entryParam struct {
+	Name     string
+	Record   bool   // Passed to Span.SetAttribute, or Span.SetVariadic if Variadic.
+	Variadic bool   // A variadic parameter, whose arguments are recorded one by one.
+	Opaque   string // The type name recorded in place of the value, "" to record the value.
+	Labels   bool   // A context.Context whose pprof labels are recorded.
+	Track    bool   // An http.ResponseWriter wrapped with tracer.TrackResponse.
+}
+
+// entryParams returns the named parameters of params, with what the entry statements capture of
+// each: its value unless cfg.Instrumentation.CaptureBasicKindsOnly leaves out its type or it comes
+// after the first cfg.Tracing.Capture.MaxParams values recorded, the pprof labels of a
+// context.Context and the status code written to an http.ResponseWriter unless
+// cfg.Instrumentation.SkipResponseRewrite is set. Unnamed and blank (_) parameters have no value
+// to capture and are left out, and with cfg.Tracing.Light set none is returned, as light mode
+// records no values.
+//
+// Parameters:
+//   - params (*ast.FieldList): the function's parameters, or nil.
+//   - typeInfo (*typeChecker): the type information of the function's package, for the
+//     parameters recorded by type only (see typeChecker.opaqueTypeName).
+//   - cfg (config.Config): the configuration settings used for instrumentation.
+//
+// Returns:
+//   - []entryParam: the parameters, in order.
+func entryParams(params *ast.FieldList, typeInfo *typeChecker, cfg config.Config) []entryParam {
+	if params == nil || cfg.Tracing.Light {
+		return nil
+	}
+	var entries []entryParam
+	recorded := 0
+	for _, field := range params.List {
+		// The arguments of a variadic parameter are recorded one by one, so its element type
+		// decides what is recorded; the tracer records opaque elements by type name itself.
+		ellipsis, variadic := field.Type.(*ast.Ellipsis)
+		recordValue := !cfg.Instrumentation.CaptureBasicKindsOnly || isBasicType(field.Type) || variadic && isBasicType(ellipsis.Elt)
+		opaque := ""
+		if !variadic {
+			opaque = typeInfo.opaqueTypeName(field.Type)
+		}
+		for _, name := range field.Names {
+			if name.Name == "_" {
+				continue
+			}
+			record := recordValue && (cfg.Tracing.Capture.MaxParams <= 0 || recorded < cfg.Tracing.Capture.MaxParams)
+			if record {
+				recorded++
+			}
+			entries = append(entries, entryParam{
+				Name:     name.Name,
+				Record:   record,
+				Variadic: variadic,
+				Opaque:   opaque,
+				Labels:   isContextType(field.Type),
+				Track:    isResponseWriterType(field.Type) && !cfg.Instrumentation.SkipResponseRewrite,
+			})
+		}
+	}
+	return entries
+}
+
+// buildParamStmts returns the captures of the parameters entryParams selects.
+//
+// Parameters:
+//   - params (*ast.FieldList): the function's parameters, or nil.
+//   - typeInfo (*typeChecker): the type information of the function's package.
+//   - cfg (config.Config): the configuration settings used for instrumentation.
+//
+// Returns:
+//   - []ast.Stmt: the statements.
+func buildParamStmts(params *ast.FieldList, typeInfo *typeChecker, cfg config.Config) []ast.Stmt {
+	var stmts []ast.Stmt
+	for _, param := range entryParams(params, typeInfo, cfg) {
+		if param.Record && param.Variadic {
+			stmts = append(stmts, &ast.ExprStmt{X: spanCall("SetVariadic", stringLit(param.Name), ast.NewIdent(param.Name))})
+		} else if param.Record {
+			// The raw value is passed so the tracer can apply tracing.capture; functions,
+			// channels and unsafe pointers pass their type name.
+			var value ast.Expr = ast.NewIdent(param.Name)
+			if param.Opaque != "" {
+				value = opaqueValue(param.Opaque)
+			}
+			stmts = append(stmts, &ast.ExprStmt{X: spanCall("SetAttribute", stringLit(param.Name), value)})
+		}
+		if param.Labels {
+			stmts = append(stmts, &ast.ExprStmt{X: spanCall("RecordLabels", ast.NewIdent(param.Name))})
+		}
+		if param.Track {
+			// w = tracer.TrackResponse(w) records the status code the call writes.
+			stmts = append(stmts, assign(param.Name, selectorCall("tracer", "TrackResponse", ast.NewIdent(param.Name))))
+		}
+	}
+	return stmts
+}
+
+// buildMainExitStmts returns the statements appended to func main that write the run's artifacts
+// when it returns: the call graph, a flush of the trace and, with cfg.Tracing.DumpOnExit, a dump
+// of the trace records.
+//
+// Parameters:
+//   - cfg (config.Config): the configuration settings used for instrumentation.
+//
+// Returns:
+//   - []ast.Stmt: the statements.
+func buildMainExitStmts(cfg config.Config) []ast.Stmt {
+	stmts := []ast.Stmt{
+		&ast.ExprStmt{X: selectorCall("tracer", "DumpCallGraphDOT", selectorCall("tracer", "ArtifactPath", stringLit("callgraph.dot")))},
+		&ast.ExprStmt{X: selectorCall("tracer", "Flush")},
+	}
+	if cfg.Tracing.DumpOnExit {
+		stmts = append(stmts, &ast.ExprStmt{X: selectorCall("tracer", "DumpTrace")})
+	}
+	return stmts
+}
+
+// instrumentFunc injects the tracing statements into the body of fn: the baseline and the entry,
+// the exit and panic defers and the parameter captures at the top, the artifact output at the end
+// of func main, and the result capture at each return. With cfg.Instrumentation.Injection set to
+// InjectTemplate, the statements are rendered from the templates of templates.go instead of built.
+// With cfg.Tracing.Light set, no parameter or result is captured: light mode records no values,
+// and passing them would box them on every call.
+//
+// Parameters:
+//   - fn (*ast.FuncDecl): the function, with a body.
+//   - traceName (string): the name the function's records carry.
+//   - traceID (int): the ID of the name in the symbol table, or 0.
+//   - isMain (bool): whether fn is func main of package main.
+//   - typeInfo (*typeChecker): the type information of the function's package.
+//   - cfg (config.Config): the configuration settings used for instrumentation.
+//
+// Returns:
+//   - int: the number of OpenTelemetry bridge installs injected (see installOTelBridge).
+//   - error: an error object if a template does not render.
+func instrumentFunc(fn *ast.FuncDecl, traceName string, traceID int, isMain bool, typeInfo *typeChecker, cfg config.Config) (int, error) {
+	templated := cfg.Instrumentation.Injection == InjectTemplate
+	if isMain && !cfg.Instrumentation.SkipMainInjections {
+		mainExit := buildMainExitStmts(cfg)
+		if templated {
+			var err error
+			if mainExit, err = renderMainExit(cfg); err != nil {
+				return 0, err
+			}
+		}
+		fn.Body.List = append(fn.Body.List, mainExit...)
+	}
+	var stmts []ast.Stmt
+	if templated {
+		var err error
+		if stmts, err = renderPrologue(traceName, traceID, fn.Type.Params, typeInfo, cfg); err != nil {
+			return 0, err
+		}
+	} else {
+		stmts = buildStartStmts(traceName, traceID, injectedMetrics(cfg))
+		stmts = append(stmts, buildExitDefer()...)
+		stmts = append(stmts, buildPanicDefer())
+		stmts = append(stmts, buildParamStmts(fn.Type.Params, typeInfo, cfg)...)
+	}
+	bridgeInstalls := 0
+	if isMain && cfg.Instrumentation.CorrelateLogs {
+		stmts = append(stmts, &ast.ExprStmt{X: selectorCall("tracer", "CorrelateLogs")})
+	}
+	if isMain && cfg.Instrumentation.BridgeOpenTelemetry {
+		stmts = append(stmts, installOTelBridge())
+		bridgeInstalls++
+	}
+	fn.Body.List = append(stmts, fn.Body.List...)
+	if !cfg.Tracing.Light {
+		fn.Body = wrapReturns(fn.Body, resultCapture{
+			opaque: typeInfo.opaqueResults(fn.Type.Results),
+			errors: typeInfo.errorResults(fn.Type.Results),
+			max:    cfg.Tracing.Capture.MaxReturns,
+		})
+	}
+	return bridgeInstalls, nil
+}
@@ -0,0 +1,181 @@
+package instrument_test
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+)
+
+// render prints the statements as gofmt would, one per line.
+func render(t *testing.T, stmts ...ast.Stmt) string {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, stmt := range stmts {
+		if err := format.Node(&buf, token.NewFileSet(), stmt); err != nil {
+			t.Fatalf("failed to print %T: %v", stmt, err)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+func expectContains(t *testing.T, got string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		if !strings.Contains(got, w) {
+			t.Errorf("expected %q in:\n%s", w, got)
+		}
+	}
+}
+
+func TestBuildPanicDefer(t *testing.T) {
+	got := render(t, instrument.BuildPanicDefer())
+	expectContains(t, got,
+		"defer func() {",
+		"r := recover()",
+		"__tracewrap_span.RecordPanic(r, string(debug.Stack()))",
+		"panic(r)",
+	)
+}
+
+func TestBuildStartAndExitStmts(t *testing.T) {
+	got := render(t, append(instrument.BuildStartStmts("pkg.Run", 0, instrument.InjectedMetrics(config.Config{})), instrument.BuildExitDefer()...)...)
+	want := "__tracewrap_snapshot := tracer.TakeSnapshot(tracer.MeasureAll)\n__tracewrap_span := tracer.RecordEntry(\"pkg.Run\")\ndefer __tracewrap_span.RecordAll(__tracewrap_snapshot)\n"
+	if got != want {
+		t.Errorf("unexpected start and exit statements:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, tc := range []struct {
+		metrics []string
+		flags   string
+	}{
+		{[]string{instrument.MetricIO}, "tracer.MeasureIO"},
+		{[]string{instrument.MetricMem, instrument.MetricTime}, "tracer.MeasureTime | tracer.MeasureMem"},
+		{[]string{instrument.MetricGoroutines, instrument.MetricTime, instrument.MetricIO, instrument.MetricMem}, "tracer.MeasureAll"},
+	} {
+		var cfg config.Config
+		cfg.Instrumentation.Metrics = tc.metrics
+		want := "__tracewrap_snapshot := tracer.TakeSnapshot(" + tc.flags + ")\n__tracewrap_span := tracer.RecordEntry(\"pkg.Run\")\n"
+		if got := render(t, instrument.BuildStartStmts("pkg.Run", 0, instrument.InjectedMetrics(cfg))...); got != want {
+			t.Errorf("metrics %v: got %q, want %q", tc.metrics, got, want)
+		}
+	}
+
+	want = "__tracewrap_snapshot := tracer.TakeSnapshot(tracer.MeasureAll)\n__tracewrap_span := tracer.RecordEntryID(3, \"pkg.Run\")\n"
+	if got := render(t, instrument.BuildStartStmts("pkg.Run", 3, instrument.InjectedMetrics(config.Config{}))...); got != want {
+		t.Errorf("function ID 3: got %q, want %q", got, want)
+	}
+}
+
+func TestBuildParamStmts(t *testing.T) {
+	src := `package p
+
+import (
+	"context"
+	"net/http"
+)
+
+func Handle(ctx context.Context, w http.ResponseWriter, n int, f func(), _ string, _ context.Context) {}
+`
+	stmts, err := instrument.BuildParamStmts(src, config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := render(t, stmts...)
+	want := `__tracewrap_span.SetAttribute("ctx", ctx)
+__tracewrap_span.RecordLabels(ctx)
+__tracewrap_span.SetAttribute("w", w)
+w = tracer.TrackResponse(w)
+__tracewrap_span.SetAttribute("n", n)
+__tracewrap_span.SetAttribute("f", tracer.Opaque("func()"))
+`
+	if got != want {
+		t.Errorf("unexpected parameter statements:\n%s\nwant:\n%s", got, want)
+	}
+
+	var cfg config.Config
+	cfg.Instrumentation.CaptureBasicKindsOnly = true
+	cfg.Instrumentation.SkipResponseRewrite = true
+	stmts, err = instrument.BuildParamStmts(src, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = render(t, stmts...)
+	want = `__tracewrap_span.RecordLabels(ctx)
+__tracewrap_span.SetAttribute("n", n)
+`
+	if got != want {
+		t.Errorf("unexpected parameter statements with basic kinds only:\n%s\nwant:\n%s", got, want)
+	}
+
+	stmts, err = instrument.BuildParamStmts("package p\n\nfunc Unnamed(int, string) {}\n", config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := render(t, stmts...); got != "" {
+		t.Errorf("expected no statements for unnamed parameters, got:\n%s", got)
+	}
+}
+
+func TestBuildParamStmtsVariadic(t *testing.T) {
+	var basicOnly config.Config
+	basicOnly.Instrumentation.CaptureBasicKindsOnly = true
+	for _, tc := range []struct {
+		src  string
+		cfg  config.Config
+		want string
+	}{
+		{"func Log(format string, args ...any) {}", config.Config{}, "__tracewrap_span.SetAttribute(\"format\", format)\n__tracewrap_span.SetVariadic(\"args\", args)\n"},
+		{"func Log(format string, args ...any) {}", basicOnly, "__tracewrap_span.SetAttribute(\"format\", format)\n"},
+		{"func Sum(values ...int) {}", basicOnly, "__tracewrap_span.SetVariadic(\"values\", values)\n"},
+	} {
+		stmts, err := instrument.BuildParamStmts("package p\n\n"+tc.src+"\n", tc.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := render(t, stmts...); got != tc.want {
+			t.Errorf("%s (basic kinds only %v): got %q, want %q", tc.src, tc.cfg.Instrumentation.CaptureBasicKindsOnly, got, tc.want)
+		}
+	}
+}
+
+func TestBuildMainExitStmts(t *testing.T) {
+	got := render(t, instrument.BuildMainExitStmts(config.Config{})...)
+	if want := "tracer.DumpCallGraphDOT(tracer.ArtifactPath(\"callgraph.dot\"))\ntracer.Flush()\n"; got != want {
+		t.Errorf("unexpected main exit statements:\n%s\nwant:\n%s", got, want)
+	}
+	var cfg config.Config
+	cfg.Tracing.DumpOnExit = true
+	expectContains(t, render(t, instrument.BuildMainExitStmts(cfg)...), "tracer.DumpTrace()\n")
+}
+
+func TestWrapReturns(t *testing.T) {
+	src := `package p
+
+func Div(a, b int) (int, error) {
+	if b == 0 {
+		return 0, nil
+	}
+	return quo(a, b), nil
+}
+
+func quo(a, b int) int { return a / b }
+`
+	body, err := instrument.WrapReturns(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := render(t, body)
+	expectContains(t, got,
+		"__tracewrap_span.RecordReturn(0, nil)",
+		"_ret0 := quo(a, b)",
+		"__tracewrap_span.RecordReturn(_ret0, nil)",
+		"return _ret0, nil",
+	)
+}
@@ -0,0 +1,316 @@
+package instrument
+
+import (
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// typesImporter resolves the standard library imports of the files type-checked on their own, as
+// the tests do; the workspace's packages are checked with the importer of their packageLoader.
+var typesImporter = importer.Default()
+
+// typeChecker holds the type information of the package a file belongs to.
+type typeChecker struct {
+	pkg  *types.Package
+	info *types.Info
+	imp  types.Importer
+	// others are the other files of the package and errs the messages of the type errors found,
+	// which verifyOutput compares the instrumented file's against.
+	others []*ast.File
+	errs   []string
+}
+
+// packageLoader parses and type-checks the packages of a workspace for instrumentFile, sharing
+// one file set and one importer. Each directory is parsed once, and each package in it is
+// type-checked once, when the first of its files is instrumented, so instrumenting a package of n
+// files reads and checks it once rather than n times.
+type packageLoader struct {
+	fset     *token.FileSet
+	imp      types.Importer
+	dirs     map[string][]*sourceFile
+	packages map[packageKey]*typeChecker
+}
+
+// packageKey identifies the files of a directory type-checked together: those of the package
+// name, with its _test.go files when tests is set.
+type packageKey struct {
+	dir   string
+	name  string
+	tests bool
+}
+
+// sourceFile is a Go file of a directory loaded by a packageLoader, parsed twice: with its marker
+// comments expanded (see expandRegionMarkers) for instrumentFile to rewrite, and as it is on disk
+// for verifyOutput to check the instrumented files of its package against.
+type sourceFile struct {
+	path     string
+	markers  int
+	expanded *ast.File
+	original *ast.File
+	err      error // The parse error of the expanded source, if any.
+}
+
+// newPackageLoader returns a packageLoader with nothing loaded.
+func newPackageLoader() *packageLoader {
+	fset := token.NewFileSet()
+	return &packageLoader{
+		fset:     fset,
+		imp:      importer.ForCompiler(fset, runtime.Compiler, nil),
+		dirs:     make(map[string][]*sourceFile),
+		packages: make(map[packageKey]*typeChecker),
+	}
+}
+
+// file returns the loaded file at filePath, parsing its directory on first use. Files whose build
+// constraints do not match are not loaded.
+//
+// Parameters:
+//   - filePath (string): the path of a Go file.
+//
+// Returns:
+//   - *sourceFile: the file.
+//   - error: an error object if the file cannot be read.
+func (l *packageLoader) file(filePath string) (*sourceFile, error) {
+	filePath = filepath.Clean(filePath)
+	dir := filepath.Dir(filePath)
+	files, ok := l.dirs[dir]
+	if !ok {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || filepath.Ext(name) != ".go" {
+				continue
+			}
+			if matched, err := build.Default.MatchFile(dir, name); err != nil || !matched {
+				continue
+			}
+			if file, err := l.parse(filepath.Join(dir, name)); err == nil {
+				files = append(files, file)
+			}
+		}
+		l.dirs[dir] = files
+	}
+	for _, file := range files {
+		if file.path == filePath {
+			return file, nil
+		}
+	}
+	file, err := l.parse(filePath)
+	if err != nil {
+		return nil, err
+	}
+	l.dirs[dir] = append(files, file)
+	return file, nil
+}
+
+// parse reads and parses the file at path.
+func (l *packageLoader) parse(path string) (*sourceFile, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &sourceFile{path: path}
+	file.original, _ = parser.ParseFile(l.fset, path, src, parser.SkipObjectResolution)
+	src, file.markers = expandRegionMarkers(src)
+	file.expanded, file.err = parser.ParseFile(l.fset, path, src, parser.ParseComments)
+	return file, nil
+}
+
+// check returns the type information of the package the parsed file f belongs to. Type errors do
+// not stop the check: imports outside the standard library do not resolve, so the types they
+// declare are unknown and opaqueTypeName falls back to the syntax. Their messages are kept for
+// verifyOutput.
+//
+// Parameters:
+//   - file (*sourceFile): the file being instrumented, from file, before any changes.
+//
+// Returns:
+//   - *typeChecker: the type information.
+func (l *packageLoader) check(file *sourceFile) *typeChecker {
+	dir := filepath.Dir(file.path)
+	name := file.expanded.Name.Name
+	key := packageKey{dir: dir, name: name, tests: strings.HasSuffix(file.path, "_test.go")}
+	pkg, ok := l.packages[key]
+	if !ok {
+		// The files instrumented with this check are checked from their expanded source, and the
+		// other files of the package, e.g. the non-test files of a test package, as they are.
+		var files []*ast.File
+		for _, other := range l.dirs[dir] {
+			test := strings.HasSuffix(other.path, "_test.go")
+			if other.err != nil || other.expanded.Name.Name != name || test && !key.tests {
+				continue
+			}
+			if test == key.tests {
+				files = append(files, other.expanded)
+			} else if other.original != nil {
+				files = append(files, other.original)
+			}
+		}
+		pkg = checkPackage(l.fset, l.imp, name, files)
+		l.packages[key] = pkg
+	}
+	c := *pkg
+	c.others = nil
+	for _, other := range l.dirs[dir] {
+		test := strings.HasSuffix(other.path, "_test.go")
+		if other == file || other.original == nil || other.original.Name.Name != name || test && !key.tests {
+			continue
+		}
+		c.others = append(c.others, other.original)
+	}
+	return &c
+}
+
+// checkPackage type-checks files as package name.
+//
+// Parameters:
+//   - fset (*token.FileSet): the file set the files were parsed with.
+//   - imp (types.Importer): resolves the imports.
+//   - name (string): the package name.
+//   - files ([]*ast.File): the files of the package.
+//
+// Returns:
+//   - *typeChecker: the type information, with no others.
+func checkPackage(fset *token.FileSet, imp types.Importer, name string, files []*ast.File) *typeChecker {
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	c := &typeChecker{info: info, imp: imp}
+	c.pkg, c.errs = checkFiles(fset, imp, name, files, info)
+	return c
+}
+
+// checkFiles type-checks files as package name, returning the messages of the type errors, without
+// positions, so those of two versions of a file can be compared.
+func checkFiles(fset *token.FileSet, imp types.Importer, name string, files []*ast.File, info *types.Info) (*types.Package, []string) {
+	var errs []string
+	conf := types.Config{Importer: imp, Error: func(err error) {
+		if terr, ok := err.(types.Error); ok {
+			errs = append(errs, terr.Msg)
+		}
+	}}
+	pkg, _ := conf.Check(name, fset, files, info)
+	return pkg, errs
+}
+
+// opaqueTypeName returns the type name recorded instead of the values of the parameter or result
+// type expr, or "" if the values themselves are recorded. Functions, channels and unsafe pointers
+// are recorded by type only: their formatted form is an address at best, and formatting values
+// reachable from them can race with the goroutines using them.
+//
+// Parameters:
+//   - expr (ast.Expr): the type expression of a parameter or result.
+//
+// Returns:
+//   - string: the type name, e.g. "func(int) error", or "".
+func (c *typeChecker) opaqueTypeName(expr ast.Expr) string {
+	if t := c.info.TypeOf(expr); t != nil && t != types.Typ[types.Invalid] {
+		qualifier := func(p *types.Package) string {
+			if p == c.pkg {
+				return ""
+			}
+			return p.Name()
+		}
+		switch u := t.Underlying().(type) {
+		case *types.Signature, *types.Chan:
+			return types.TypeString(t, qualifier)
+		case *types.Basic:
+			if u.Kind() == types.UnsafePointer {
+				return types.TypeString(t, qualifier)
+			}
+		}
+		return ""
+	}
+	// Without type information only literal function and channel types are recognized.
+	switch e := expr.(type) {
+	case *ast.FuncType, *ast.ChanType:
+		return types.ExprString(expr)
+	case *ast.SelectorExpr:
+		if pkg, ok := e.X.(*ast.Ident); ok && pkg.Name == "unsafe" && e.Sel.Name == "Pointer" {
+			return "unsafe.Pointer"
+		}
+	}
+	return ""
+}
+
+// opaqueResults returns the opaqueTypeName of each result of a function, or nil if all of its
+// results are recorded by value.
+//
+// Parameters:
+//   - results (*ast.FieldList): the function's results, or nil.
+//
+// Returns:
+//   - []string: the type names by result position, "" for results recorded by value.
+func (c *typeChecker) opaqueResults(results *ast.FieldList) []string {
+	if results == nil {
+		return nil
+	}
+	var names []string
+	found := false
+	for _, field := range results.List {
+		name := c.opaqueTypeName(field.Type)
+		found = found || name != ""
+		for i := 0; i < max(1, len(field.Names)); i++ {
+			names = append(names, name)
+		}
+	}
+	if !found {
+		return nil
+	}
+	return names
+}
+
+// errorResults reports which results of a function are of a type that implements error, or
+// returns nil if none is. Without type information only results declared as error are.
+//
+// Parameters:
+//   - results (*ast.FieldList): the function's results, or nil.
+//
+// Returns:
+//   - []bool: whether each result, by position, is an error.
+func (c *typeChecker) errorResults(results *ast.FieldList) []bool {
+	if results == nil {
+		return nil
+	}
+	errorType := types.Universe.Lookup("error").Type().Underlying().(*types.Interface)
+	var errs []bool
+	found := false
+	for _, field := range results.List {
+		isError := false
+		if t := c.info.TypeOf(field.Type); t != nil && t != types.Typ[types.Invalid] {
+			isError = types.Implements(t, errorType)
+		} else if ident, ok := field.Type.(*ast.Ident); ok {
+			isError = ident.Name == "error"
+		}
+		found = found || isError
+		for i := 0; i < max(1, len(field.Names)); i++ {
+			errs = append(errs, isError)
+		}
+	}
+	if !found {
+		return nil
+	}
+	return errs
+}
+
+// opaqueValue returns the expression tracer.Opaque("<typeName>") passed to the tracer in place
+// of a value recorded by type only.
+func opaqueValue(typeName string) ast.Expr {
+	return &ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   ast.NewIdent("tracer"),
+			Sel: ast.NewIdent("Opaque"),
+		},
+		Args: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(typeName)}},
+	}
+}
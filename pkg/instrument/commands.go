@@ -0,0 +1,83 @@
+package instrument
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// commandWrappers maps the methods of *exec.Cmd rewritten by rewriteCommands to the tracer
+// function taking the command as its argument instead.
+var commandWrappers = map[string]string{
+	"Run":            "RunCommand",
+	"Output":         "CommandOutput",
+	"CombinedOutput": "CommandCombinedOutput",
+	"Start":          "StartCommand",
+	"Wait":           "WaitCommand",
+}
+
+// execCmd reports whether expr is an exec.Cmd or a pointer to one, and which.
+//
+// Parameters:
+//   - expr (ast.Expr): the receiver of a method call.
+//
+// Returns:
+//   - pointer (bool): true for *exec.Cmd.
+//   - ok (bool): true for exec.Cmd and *exec.Cmd.
+func (c *typeChecker) execCmd(expr ast.Expr) (pointer, ok bool) {
+	t := c.info.TypeOf(expr)
+	if t == nil {
+		return false, false
+	}
+	if ptr, isPtr := t.(*types.Pointer); isPtr {
+		t, pointer = ptr.Elem(), true
+	}
+	named, isNamed := t.(*types.Named)
+	if !isNamed || named.Obj().Pkg() == nil {
+		return false, false
+	}
+	return pointer, named.Obj().Pkg().Path() == "os/exec" && named.Obj().Name() == "Cmd"
+}
+
+// rewriteCommands rewrites cmd.Run(), cmd.Output(), cmd.CombinedOutput(), cmd.Start() and
+// cmd.Wait() on exec.Cmd values into the tracer wrappers of commandWrappers, such as
+// tracer.RunCommand(cmd), which account for the child process in the calling span. Receivers are
+// recognized by their type, so commands whose type cannot be determined are left alone, as are
+// method values such as f := cmd.Run.
+//
+// Parameters:
+//   - f (*ast.File): the file to rewrite.
+//   - typeInfo (*typeChecker): the type information of f's package.
+//
+// Returns:
+//   - int: the number of calls rewritten.
+func rewriteCommands(f *ast.File, typeInfo *typeChecker) int {
+	rewritten := 0
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) != 0 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		wrapper, ok := commandWrappers[sel.Sel.Name]
+		if !ok {
+			return true
+		}
+		pointer, ok := typeInfo.execCmd(sel.X)
+		if !ok {
+			return true
+		}
+		arg := sel.X
+		if !pointer {
+			arg = &ast.UnaryExpr{Op: token.AND, X: arg}
+		}
+		call.Fun = &ast.SelectorExpr{X: ast.NewIdent("tracer"), Sel: ast.NewIdent(wrapper)}
+		call.Args = []ast.Expr{arg}
+		rewritten++
+		return true
+	})
+	return rewritten
+}
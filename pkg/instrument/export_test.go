@@ -0,0 +1,65 @@
+package instrument
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+
+	"github.com/mwiater/tracewrap/config"
+)
+
+// RestoreFailedFiles exposes restoreFailedFiles to the tests.
+var RestoreFailedFiles = restoreFailedFiles
+
+// ProjectVCS exposes projectVCS to the tests.
+var ProjectVCS = projectVCS
+
+// VerifyWorkspace exposes verifyWorkspace to the tests.
+var VerifyWorkspace = verifyWorkspace
+
+// BuildPanicDefer exposes buildPanicDefer to the tests.
+var BuildPanicDefer = buildPanicDefer
+
+// InjectedMetrics exposes injectedMetrics to the tests.
+var InjectedMetrics = injectedMetrics
+
+// BuildStartStmts exposes buildStartStmts to the tests.
+var BuildStartStmts = buildStartStmts
+
+// BuildExitDefer exposes buildExitDefer to the tests.
+var BuildExitDefer = buildExitDefer
+
+// BuildMainExitStmts exposes buildMainExitStmts to the tests.
+var BuildMainExitStmts = buildMainExitStmts
+
+// BuildParamStmts runs buildParamStmts for the first function of the file src, type-checked on
+// its own.
+func BuildParamStmts(src string, cfg config.Config) ([]ast.Stmt, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "src.go", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			return buildParamStmts(fn.Type.Params, checkPackage(fset, typesImporter, f.Name.Name, []*ast.File{f}), cfg), nil
+		}
+	}
+	return nil, nil
+}
+
+// WrapReturns runs wrapReturns over the body of the first function of the file src, type-checked
+// on its own.
+func WrapReturns(src string) (*ast.BlockStmt, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "src.go", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			return wrapReturns(fn.Body, resultCapture{opaque: checkPackage(fset, typesImporter, f.Name.Name, []*ast.File{f}).opaqueResults(fn.Type.Results)}), nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,281 @@
+package instrument
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mwiater/tracewrap/config"
+)
+
+// Fallback is a file left uninstrumented because its instrumented source was not valid Go (see
+// verifyOutput) or its instrumented package did not build, e.g. a cgo preamble the injected code
+// upsets or assembly whose Go declarations no longer match.
+type Fallback struct {
+	File   string `json:"file"`   // The file, relative to the project root, slash-separated.
+	Reason string `json:"reason"` // The compiler error that caused the fallback.
+}
+
+// buildErrorPosition splits the positioned lines of "go build" output into file, line, column and
+// message, e.g. "pkg/asm.go:12:3: undefined: x" or "asm_amd64.s:7: unexpected EOF".
+var buildErrorPosition = regexp.MustCompile(`^(\S+\.(?:go|s)):(\d+)(?::(\d+))?: (.*)$`)
+
+// BuildWithFallback builds the instrumented binary as BuildInstrumentedBinary does, after a
+// verification pass that type-checks the workspace (see verifyWorkspace), so problems are found
+// before "go build" runs, and reports the "go vet" findings in injected code. When the type check
+// or the build fails, it restores the files the errors point at from the project, so they are
+// built uninstrumented, and tries again. An error in a file that was not instrumented, such as an
+// assembly file, a cgo file generated by the go command or a link error of the package, restores
+// every instrumented file of the package. The functions of restored files are dropped from the
+// workspace inventory and the files are listed in its Fallbacks. The build fails when the output
+// points at nothing left to restore, or when cfg.Instrumentation.SkipBuildFallback is set; the
+// error is then a *BuildError whose Diagnoses map the errors back to the project (see
+// DiagnoseBuild). cfg.Instrumentation.SkipVerify skips the type check and "go vet".
+//
+// Parameters:
+//   - workspace (string): the path to the workspace directory.
+//   - projectDir (string): the project the workspace was prepared from, holding the original files.
+//   - cfg (config.Config): the configuration to embed into the instrumented binary.
+//   - buildFlags (...string): extra flags for "go build", e.g. "-race".
+//
+// Returns:
+//   - string: the path to the built instrumented binary.
+//   - []Fallback: the files restored, in the order they were.
+//   - error: an error object if the binary could not be built.
+func BuildWithFallback(workspace, projectDir string, cfg config.Config, buildFlags ...string) (string, []Fallback, error) {
+	if err := prepareModule(workspace); err != nil {
+		return "", nil, err
+	}
+	var fallbacks []Fallback
+	vetted := cfg.Instrumentation.SkipVerify
+	for {
+		var binaryPath string
+		var out []byte
+		var err error
+		if !cfg.Instrumentation.SkipVerify {
+			fmt.Fprintln(Progress, "Type-checking instrumented workspace:", workspace)
+			out, err = verifyWorkspace(workspace, buildFlags)
+			if err != nil && !errors.Is(err, errTypeCheck) {
+				return "", fallbacks, err
+			}
+		}
+		// go vet findings, such as a lock copied into a parameter capture, do not stop the build.
+		if err == nil && !vetted {
+			vetted = true
+			if findings := vetWorkspace(workspace, projectDir); len(findings) > 0 {
+				fmt.Fprintln(Progress, "go vet findings in injected code:")
+				WriteDiagnoses(Progress, findings)
+			}
+		}
+		if err == nil {
+			binaryPath, out, err = buildBinary(workspace, projectDir, cfg, buildFlags)
+		}
+		if err == nil {
+			fmt.Fprintln(Progress, "Binary built successfully at:", binaryPath)
+			return binaryPath, fallbacks, nil
+		}
+		diagnoses := DiagnoseBuild(workspace, projectDir, out)
+		if cfg.Instrumentation.SkipBuildFallback {
+			return "", nil, &BuildError{Err: err, Output: out, Diagnoses: diagnoses}
+		}
+		restored, restoreErr := restoreFailedFiles(workspace, projectDir, out)
+		if restoreErr != nil {
+			return "", fallbacks, restoreErr
+		}
+		if len(restored) == 0 {
+			return "", fallbacks, &BuildError{Err: err, Output: out, Diagnoses: diagnoses}
+		}
+		fmt.Fprintln(Progress, "Instrumented build failed:")
+		WriteDiagnoses(Progress, diagnoses)
+		for _, f := range restored {
+			fmt.Fprintf(Progress, "Building without instrumentation: %s (%s)\n", f.File, f.Reason)
+		}
+		fallbacks = append(fallbacks, restored...)
+		if err := dropFallbacks(workspace, restored); err != nil {
+			return "", fallbacks, err
+		}
+	}
+}
+
+// restoreFailedFiles restores the instrumented files the "go build" output out blames from the
+// project.
+//
+// Parameters:
+//   - workspace (string): the path to the workspace directory.
+//   - projectDir (string): the project directory.
+//   - out ([]byte): the output of the failed build.
+//
+// Returns:
+//   - []Fallback: the files restored, none if the output blames no instrumented file.
+//   - error: an error object if a file cannot be restored.
+func restoreFailedFiles(workspace, projectDir string, out []byte) ([]Fallback, error) {
+	module := modulePath(workspace)
+	files := make(map[string]string)    // Blamed file to reason.
+	packages := make(map[string]string) // Package directory blamed as a whole to reason.
+	pkgDir := ""
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "vet: ")
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "# ") {
+			// "# example.com/mod/pkg" or "# example.com/mod/pkg [example.com/mod/pkg.test]".
+			pkgDir = packageDir(module, strings.Fields(line)[1])
+			continue
+		}
+		m := buildErrorPosition.FindStringSubmatch(line)
+		if m == nil {
+			// Unpositioned errors, e.g. from the linker, blame the package being built.
+			if pkgDir != "" && packages[pkgDir] == "" {
+				packages[pkgDir] = line
+			}
+			continue
+		}
+		rel, ok := workspaceRel(workspace, m[1])
+		if !ok {
+			if pkgDir != "" && packages[pkgDir] == "" {
+				packages[pkgDir] = m[4]
+			}
+			continue
+		}
+		if filepath.Ext(rel) == ".go" {
+			instrumented, err := isInstrumented(workspace, projectDir, rel)
+			if err != nil {
+				return nil, err
+			}
+			if instrumented {
+				if files[rel] == "" {
+					files[rel] = m[4]
+				}
+				continue
+			}
+		}
+		if dir := filepath.Dir(rel); packages[dir] == "" {
+			packages[dir] = m[4]
+		}
+	}
+	for dir, reason := range packages {
+		entries, err := os.ReadDir(filepath.Join(workspace, dir))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != ".go" {
+				continue
+			}
+			rel := filepath.Join(dir, entry.Name())
+			instrumented, err := isInstrumented(workspace, projectDir, rel)
+			if err != nil {
+				return nil, err
+			}
+			if instrumented && files[rel] == "" {
+				files[rel] = reason
+			}
+		}
+	}
+
+	restored := make([]Fallback, 0, len(files))
+	for rel, reason := range files {
+		info, err := os.Stat(filepath.Join(projectDir, rel))
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %v", rel, err)
+		}
+		if err := copyFile(filepath.Join(projectDir, rel), filepath.Join(workspace, rel), info); err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %v", rel, err)
+		}
+		restored = append(restored, Fallback{File: filepath.ToSlash(rel), Reason: reason})
+	}
+	sort.Slice(restored, func(i, j int) bool { return restored[i].File < restored[j].File })
+	return restored, nil
+}
+
+// packageDir returns the directory of the package with import path importPath relative to the
+// root of module, or "" for a package outside the module.
+func packageDir(module, importPath string) string {
+	switch {
+	case module == "":
+		return ""
+	case importPath == module, importPath == "command-line-arguments":
+		return "."
+	case strings.HasPrefix(importPath, module+"/"):
+		return filepath.FromSlash(strings.TrimPrefix(importPath, module+"/"))
+	}
+	return ""
+}
+
+// workspaceRel returns path, as printed by the go command run in the workspace, relative to the
+// workspace, and false for a path outside it, such as a file generated by cgo.
+func workspaceRel(workspace, path string) (string, bool) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspace, path)
+	}
+	rel, err := filepath.Rel(workspace, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return rel, true
+}
+
+// isInstrumented reports whether the workspace file rel differs from the project's, i.e. whether
+// instrumentation rewrote it. Files the project does not have are not instrumented.
+func isInstrumented(workspace, projectDir, rel string) (bool, error) {
+	original, err := os.ReadFile(filepath.Join(projectDir, rel))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read %s: %v", rel, err)
+	}
+	current, err := os.ReadFile(filepath.Join(workspace, rel))
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %v", rel, err)
+	}
+	return !bytes.Equal(original, current), nil
+}
+
+// dropFallbacks rewrites the workspace inventory without the functions of the restored files,
+// which are no longer instrumented, and with the files added to its Fallbacks. The files are
+// dropped from the source map too, as their lines are the original ones again.
+func dropFallbacks(workspace string, restored []Fallback) error {
+	path := filepath.Join(workspace, InventoryFile)
+	inv, err := ReadInventory(path)
+	if err != nil {
+		return fmt.Errorf("failed to read inventory: %v", err)
+	}
+	files := make(map[string]bool, len(restored))
+	for _, f := range restored {
+		files[f.File] = true
+	}
+	functions := inv.Functions[:0]
+	for _, fn := range inv.Functions {
+		if !files[fn.File] {
+			functions = append(functions, fn)
+		}
+	}
+	inv.Functions = functions
+	inv.Fallbacks = append(inv.Fallbacks, restored...)
+	if err := WriteInventory(path, inv); err != nil {
+		return err
+	}
+	mapPath := filepath.Join(workspace, SourceMapFile)
+	sourceMap, err := ReadSourceMap(mapPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read source map: %v", err)
+	}
+	for file := range files {
+		delete(sourceMap.Files, file)
+	}
+	return WriteSourceMap(mapPath, sourceMap)
+}
@@ -0,0 +1,82 @@
+package instrument_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/pkg/instrument"
+)
+
+// writeFiles writes files, by slash-separated path relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestRestoreFailedFilesRestoresBlamedFile(t *testing.T) {
+	project, workspace := t.TempDir(), t.TempDir()
+	original := map[string]string{
+		"go.mod":          "module example.com/app\n\ngo 1.21\n",
+		"main.go":         "package main\n\nfunc main() { helper() }\n",
+		"helper.go":       "package main\n\nfunc helper() {}\n",
+		"lib/lib.go":      "package lib\n\nfunc Lib() {}\n",
+		"lib/lib_more.go": "package lib\n\nfunc More() {}\n",
+	}
+	writeFiles(t, project, original)
+	writeFiles(t, workspace, original)
+	// An instrumented file the compiler rejects, next to one that builds.
+	writeFiles(t, workspace, map[string]string{
+		"helper.go": "package main\n\nfunc helper() { undefinedTracerCall() }\n",
+		"main.go":   "package main\n\nfunc main() { helper(); _ = 1 }\n",
+	})
+
+	build := exec.Command("go", "build", "-o", os.DevNull, ".")
+	build.Dir = workspace
+	out, err := build.CombinedOutput()
+	if err == nil {
+		t.Fatal("expected the instrumented workspace to fail to build")
+	}
+	restored, err := instrument.RestoreFailedFiles(workspace, project, out)
+	if err != nil {
+		t.Fatalf("RestoreFailedFiles failed: %v", err)
+	}
+	if len(restored) != 1 || restored[0].File != "helper.go" || !strings.Contains(restored[0].Reason, "undefinedTracerCall") {
+		t.Fatalf("expected helper.go to be restored, got %+v", restored)
+	}
+	data, err := os.ReadFile(filepath.Join(workspace, "helper.go"))
+	if err != nil || string(data) != original["helper.go"] {
+		t.Errorf("expected helper.go to hold the original source, got %q (%v)", data, err)
+	}
+	build = exec.Command("go", "build", "-o", os.DevNull, ".")
+	build.Dir = workspace
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Errorf("expected the workspace to build after the fallback: %v, output: %s", err, out)
+	}
+
+	// An unpositioned error, as the linker reports, or one in a file that was not instrumented,
+	// such as assembly, restores the instrumented files of the whole package.
+	writeFiles(t, workspace, map[string]string{"lib/lib.go": "package lib\n\nfunc Lib() { _ = 1 }\n"})
+	restored, err = instrument.RestoreFailedFiles(workspace, project, []byte("# example.com/app/lib\nlib/lib_amd64.s:3: unexpected EOF\n"))
+	if err != nil {
+		t.Fatalf("RestoreFailedFiles failed: %v", err)
+	}
+	if len(restored) != 1 || restored[0].File != "lib/lib.go" {
+		t.Fatalf("expected lib/lib.go to be restored, got %+v", restored)
+	}
+
+	restored, err = instrument.RestoreFailedFiles(workspace, project, []byte("# example.com/app/lib\nlink: duplicated definition of symbol\n"))
+	if err != nil || len(restored) != 0 {
+		t.Errorf("expected nothing left to restore, got %+v (%v)", restored, err)
+	}
+}
@@ -0,0 +1,135 @@
+package instrument_test
+
+import (
+	"flag"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden and the generated benchmark calls")
+
+// TestGoldenFiles instruments each testdata/golden/<name>.input file as main.go of a scratch
+// workspace and compares the result with <name>.golden. Run `go test ./pkg/instrument -update`
+// to regenerate the golden files after an intended change to the generated code.
+func TestGoldenFiles(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "golden", "*.input"))
+	if err != nil {
+		t.Fatalf("Failed to list golden inputs: %v", err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("No golden inputs found")
+	}
+	if err := instrument.SetDynamicTracerImport(""); err != nil {
+		t.Fatalf("SetDynamicTracerImport failed: %v", err)
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".input")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatalf("Failed to read input: %v", err)
+			}
+			got := instrumentSource(t, src)
+
+			// Whatever the transformation produced has to be valid Go.
+			if _, err := parser.ParseFile(token.NewFileSet(), name+".go", got, 0); err != nil {
+				t.Fatalf("Instrumented output does not parse: %v\n%s", err, got)
+			}
+
+			goldenPath := strings.TrimSuffix(input, ".input") + ".golden"
+			if *update {
+				if err := os.WriteFile(goldenPath, got, 0644); err != nil {
+					t.Fatalf("Failed to write golden file: %v", err)
+				}
+				return
+			}
+			want, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("Failed to read golden file (run with -update to create it): %v", err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("Instrumented output differs from %s (run with -update to accept):\n%s", goldenPath, got)
+			}
+		})
+	}
+}
+
+// benchmarkCalls is the file of the tracer's benchmarks that TestBenchmarkCalls generates.
+var benchmarkCalls = filepath.Join("..", "tracer", "injected_calls_test.go")
+
+// TestBenchmarkCalls instruments testdata/benchmark/injected_calls.input with the prologue
+// template, as buildTracedApplication would, and compares the result with the calls the tracer's
+// hot-path benchmarks make, so the benchmarks measure the code that is injected. Run
+// `go test ./pkg/instrument -update` to regenerate them after a change to the injected code.
+func TestBenchmarkCalls(t *testing.T) {
+	if err := instrument.SetDynamicTracerImport(""); err != nil {
+		t.Fatalf("SetDynamicTracerImport failed: %v", err)
+	}
+	src, err := os.ReadFile(filepath.Join("testdata", "benchmark", "injected_calls.input"))
+	if err != nil {
+		t.Fatalf("Failed to read input: %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), src, 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	cfg := config.Config{Instrumentation: config.InstrumentationConfig{Enable: true, Injection: instrument.InjectTemplate}}
+	if err := instrument.InstrumentWorkspace(dir, cfg); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+	instrumented, err := os.ReadFile(filepath.Join(dir, "main.go"))
+	if err != nil {
+		t.Fatalf("Failed to read instrumented file: %v", err)
+	}
+	header := "// Code generated by TestBenchmarkCalls in pkg/instrument from\n" +
+		"// testdata/benchmark/injected_calls.input; DO NOT EDIT.\n\n"
+	got, err := format.Source(append([]byte(header), instrumented...))
+	if err != nil {
+		t.Fatalf("Instrumented output does not parse: %v\n%s", err, instrumented)
+	}
+	if *update {
+		if err := os.WriteFile(benchmarkCalls, got, 0644); err != nil {
+			t.Fatalf("Failed to write benchmark calls: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(benchmarkCalls)
+	if err != nil {
+		t.Fatalf("Failed to read benchmark calls (run with -update to create them): %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("The code injected differs from %s (run with -update to accept):\n%s", benchmarkCalls, got)
+	}
+}
+
+// instrumentSource instruments src as the only file of a temporary workspace and returns the result.
+func instrumentSource(t *testing.T, src []byte) []byte {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "goldentest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(file, src, 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	cfg := config.Config{Instrumentation: config.InstrumentationConfig{Enable: true}}
+	if err := instrument.InstrumentWorkspace(dir, cfg); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read instrumented file: %v", err)
+	}
+	return got
+}
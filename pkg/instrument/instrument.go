@@ -2,8 +2,17 @@ package instrument
 
 import (
 	"fmt"
+	"io"
+	"os"
 )
 
+// tracerPackagePath is the import path of the tracer package injected into instrumented code.
+const tracerPackagePath = "github.com/mwiater/tracewrap/pkg/tracer"
+
+// Progress receives the progress messages of instrumenting, building and running a project, such
+// as the files skipped. It is standard output unless the caller sets it, e.g. to io.Discard.
+var Progress io.Writer = os.Stdout
+
 // DynamicTracerImport holds the dynamic tracer import string set by SetDynamicTracerImport.
 // It is used to dynamically specify the tracer package import.
 var DynamicTracerImport string
@@ -17,7 +26,7 @@ var DynamicTracerImport string
 // Returns:
 //   - error: an error object if setting the tracer import fails (currently always nil).
 func SetDynamicTracerImport(workspace string) error {
-	DynamicTracerImport = "\"github.com/mwiater/tracewrap/pkg/tracer\""
-	fmt.Println("DEBUG: SetDynamicTracerImport set to", DynamicTracerImport)
+	DynamicTracerImport = "\"" + tracerPackagePath + "\""
+	fmt.Fprintln(Progress, "DEBUG: SetDynamicTracerImport set to", DynamicTracerImport)
 	return nil
 }
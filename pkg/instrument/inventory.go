@@ -0,0 +1,125 @@
+package instrument
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"os"
+	"sort"
+)
+
+// InventoryFile is the name of the function inventory InstrumentWorkspace writes to the root of
+// the workspace. It lists every function that was instrumented, for coverage reports.
+const InventoryFile = "tracewrap-inventory.json"
+
+// Function describes an instrumented function.
+type Function struct {
+	Name       string `json:"name"`       // The name trace records carry, e.g. "processJob".
+	ID         int    `json:"id"`         // The ID of Name in the SymbolsFile.
+	Func       string `json:"func"`       // The declared name; Name extends it in longer name formats.
+	Package    string `json:"package"`    // The package name, e.g. "main".
+	File       string `json:"file"`       // The file, relative to the project root.
+	Line       int    `json:"line"`       // The line of the func keyword.
+	EndLine    int    `json:"endLine"`    // The line of the closing brace.
+	Statements int    `json:"statements"` // The number of statements in the body.
+	// Calls names the functions and methods the body calls, including from function literals,
+	// sorted and without duplicates. Names are unqualified, like those in trace records.
+	Calls []string `json:"calls,omitempty"`
+}
+
+// Inventory lists the functions instrumented in a project, in file and line order.
+type Inventory struct {
+	// Root is the absolute path of the project directory the files are relative to, set by
+	// buildTracedApplication.
+	Root      string     `json:"root,omitempty"`
+	Functions []Function `json:"functions"`
+	// Fallbacks lists the files left uninstrumented because their instrumented source was invalid
+	// (see InstrumentWorkspace) or their instrumented package did not build (see
+	// BuildWithFallback); their functions are not in Functions.
+	Fallbacks []Fallback `json:"fallbacks,omitempty"`
+}
+
+// countStatements returns the number of statements in body, including those of nested blocks and
+// function literals; blocks themselves are not counted.
+func countStatements(body *ast.BlockStmt) int {
+	n := 0
+	ast.Inspect(body, func(node ast.Node) bool {
+		if stmt, ok := node.(ast.Stmt); ok {
+			if _, block := stmt.(*ast.BlockStmt); !block {
+				n++
+			}
+		}
+		return true
+	})
+	return n
+}
+
+// staticCalls returns the sorted, distinct names of the functions and methods called in body.
+func staticCalls(body *ast.BlockStmt) []string {
+	seen := make(map[string]bool)
+	ast.Inspect(body, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		switch fun := call.Fun.(type) {
+		case *ast.Ident:
+			seen[fun.Name] = true
+		case *ast.SelectorExpr:
+			seen[fun.Sel.Name] = true
+		case *ast.IndexExpr: // Instantiated generic functions, e.g. Map[int](xs).
+			if id, ok := fun.X.(*ast.Ident); ok {
+				seen[id.Name] = true
+			}
+		}
+		return true
+	})
+	if len(seen) == 0 {
+		return nil
+	}
+	calls := make([]string, 0, len(seen))
+	for name := range seen {
+		calls = append(calls, name)
+	}
+	sort.Strings(calls)
+	return calls
+}
+
+// WriteInventory writes inv as JSON to path.
+//
+// Parameters:
+//   - path (string): the output file.
+//   - inv (Inventory): the inventory.
+//
+// Returns:
+//   - error: an error if the file cannot be written.
+func WriteInventory(path string, inv Inventory) error {
+	if inv.Functions == nil {
+		inv.Functions = []Function{}
+	}
+	data, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ReadInventory reads an inventory written by WriteInventory, such as "tracewrap/inventory.json".
+//
+// Parameters:
+//   - path (string): the inventory file.
+//
+// Returns:
+//   - Inventory: the inventory.
+//   - error: an error if the file cannot be read or parsed.
+func ReadInventory(path string) (Inventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Inventory{}, err
+	}
+	var inv Inventory
+	if err := json.Unmarshal(data, &inv); err != nil {
+		return Inventory{}, fmt.Errorf("invalid inventory %s: %v", path, err)
+	}
+	return inv, nil
+}
@@ -0,0 +1,223 @@
+package instrument
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// Formats of InstrumentationConfig.NameFormat, from the shortest to the longest.
+const (
+	NameShort   = "short"   // Func, or Method for methods.
+	NamePackage = "package" // pkg.Func, or pkg.Type.Method.
+	NameFull    = "full"    // example.com/mod/pkg.Func, or example.com/mod/pkg.Type.Method.
+)
+
+var nameFormats = []string{NameShort, NamePackage, NameFull}
+
+// funcKey identifies a function declaration of the workspace.
+type funcKey struct {
+	file string // Relative to the workspace, slash-separated.
+	recv string // The receiver's type name, "" for functions.
+	name string
+}
+
+// functionNames maps the functions of a workspace to the names their trace records carry.
+type functionNames map[funcKey]string
+
+// lookup returns the name assigned to fn, declared in the file rel, or its declared name if none
+// was assigned.
+func (n functionNames) lookup(rel string, fn *ast.FuncDecl) string {
+	if name, ok := n[funcKey{filepath.ToSlash(rel), receiverName(fn), fn.Name.Name}]; ok {
+		return name
+	}
+	return fn.Name.Name
+}
+
+// SymbolsFile is the name of the symbol table InstrumentWorkspace writes to the root of the
+// workspace. It assigns each function name an ID, which the injected code passes to
+// tracer.RecordEntryID so trace files store the ID instead of the name.
+const SymbolsFile = "tracewrap-symbols.json"
+
+// symbols returns the symbol table of the names in n: the distinct names, sorted, numbered from 1
+// in that order.
+//
+// Returns:
+//   - tracer.SymbolTable: the table.
+//   - map[string]int: the ID of each name.
+func (n functionNames) symbols() (tracer.SymbolTable, map[string]int) {
+	ids := make(map[string]int, len(n))
+	for _, name := range n {
+		ids[name] = 0
+	}
+	table := tracer.SymbolTable{Names: make([]string, 0, len(ids))}
+	for name := range ids {
+		table.Names = append(table.Names, name)
+	}
+	sort.Strings(table.Names)
+	for i, name := range table.Names {
+		ids[name] = i + 1
+	}
+	return table, ids
+}
+
+// receiverName returns the type name of fn's receiver without pointer or type parameters, or ""
+// for a function.
+func receiverName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return ""
+	}
+	expr := fn.Recv.List[0].Type
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// declaredFunc is a function declaration found by assignNames.
+type declaredFunc struct {
+	key        funcKey
+	pkg        string // The package name.
+	importPath string
+	level      int // The index of its current format in nameFormats.
+}
+
+// formatted returns the name of d in its current format.
+func (d declaredFunc) formatted() string {
+	name := d.key.name
+	if nameFormats[d.level] == NameShort {
+		return name
+	}
+	if d.key.recv != "" {
+		name = d.key.recv + "." + name
+	}
+	if nameFormats[d.level] == NamePackage {
+		return d.pkg + "." + name
+	}
+	return d.importPath + "." + name
+}
+
+// identity distinguishes functions sharing a name in every format, such as the init functions of
+// one package, from functions whose names merely collide.
+func (d declaredFunc) identity() string {
+	return fmt.Sprintf("%s.%s.%s", d.importPath, d.key.recv, d.key.name)
+}
+
+// assignNames names the functions declared in files in the given format. Whenever a name is
+// shared by different functions, such as String methods of two types or Run functions of two
+// packages, those functions are upgraded to the next longer format until every name is unique
+// or the full format is reached. The import path of a package is the module path from
+// workspace/go.mod joined with its directory, or the directory alone without a go.mod.
+//
+// Parameters:
+//   - workspace (string): the workspace directory.
+//   - files ([]string): the files to be instrumented, relative to workspace.
+//   - format (string): NameShort, NamePackage or NameFull; "" means NameShort.
+//
+// Returns:
+//   - functionNames: the name of each function declared in files.
+//   - error: an error for an unknown format or a file that cannot be parsed.
+func assignNames(workspace string, files []string, format string) (functionNames, error) {
+	level := -1
+	for i, f := range nameFormats {
+		if f == format || format == "" && f == NameShort {
+			level = i
+		}
+	}
+	if level < 0 {
+		return nil, fmt.Errorf("unknown name format %q; use %s, %s or %s", format, NameShort, NamePackage, NameFull)
+	}
+	module := modulePath(workspace)
+	var funcs []*declaredFunc
+	for _, rel := range files {
+		f, err := parser.ParseFile(token.NewFileSet(), filepath.Join(workspace, rel), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, fmt.Errorf("parsing error: %v", err)
+		}
+		dir := path.Dir(filepath.ToSlash(rel))
+		importPath := path.Join(module, dir)
+		if module == "" && dir == "." {
+			importPath = f.Name.Name
+		}
+		if strings.HasSuffix(f.Name.Name, "_test") {
+			importPath += "_test"
+		}
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil {
+				funcs = append(funcs, &declaredFunc{
+					key:        funcKey{filepath.ToSlash(rel), receiverName(fn), fn.Name.Name},
+					pkg:        f.Name.Name,
+					importPath: importPath,
+					level:      level,
+				})
+			}
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		byName := make(map[string][]*declaredFunc)
+		for _, d := range funcs {
+			byName[d.formatted()] = append(byName[d.formatted()], d)
+		}
+		for _, group := range byName {
+			ambiguous := false
+			for _, d := range group[1:] {
+				ambiguous = ambiguous || d.identity() != group[0].identity()
+			}
+			if !ambiguous {
+				continue
+			}
+			for _, d := range group {
+				if d.level < len(nameFormats)-1 {
+					d.level++
+					changed = true
+				}
+			}
+		}
+	}
+
+	names := make(functionNames, len(funcs))
+	for _, d := range funcs {
+		names[d.key] = d.formatted()
+	}
+	return names, nil
+}
+
+// modulePath returns the module path declared in workspace/go.mod, or "" if there is none.
+func modulePath(workspace string) string {
+	file, err := os.Open(filepath.Join(workspace, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], "\"`")
+		}
+	}
+	return ""
+}
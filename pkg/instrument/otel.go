@@ -0,0 +1,78 @@
+package instrument
+
+import (
+	"go/ast"
+	"strings"
+)
+
+// otelBridgePackagePath is the import path of the OpenTelemetry bridge injected with
+// instrumentation.bridgeOpenTelemetry.
+const otelBridgePackagePath = "github.com/mwiater/tracewrap/pkg/otelbridge"
+
+// otelPackagePath is the import path of the OpenTelemetry API holding the global tracer provider.
+const otelPackagePath = "go.opentelemetry.io/otel"
+
+// installOTelBridge returns the otelbridge.Install() statement injected into func main.
+func installOTelBridge() ast.Stmt {
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent("otelbridge"),
+				Sel: ast.NewIdent("Install"),
+			},
+		},
+	}
+}
+
+// wrapTracerProviders rewrites otel.SetTracerProvider(tp) into
+// otel.SetTracerProvider(otelbridge.Wrap(tp)), so the spans the application creates with
+// OpenTelemetry are recorded by tracewrap as well as by its own provider.
+//
+// Parameters:
+//   - f (*ast.File): the file to rewrite.
+//
+// Returns:
+//   - int: the number of calls rewritten.
+func wrapTracerProviders(f *ast.File) int {
+	localNames := make(map[string]bool)
+	for _, imp := range f.Imports {
+		if strings.Trim(imp.Path.Value, "\"") != otelPackagePath {
+			continue
+		}
+		name := "otel"
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		if name != "_" && name != "." {
+			localNames[name] = true
+		}
+	}
+	if len(localNames) == 0 {
+		return 0
+	}
+	rewritten := 0
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) != 1 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "SetTracerProvider" {
+			return true
+		}
+		pkg, ok := sel.X.(*ast.Ident)
+		if !ok || pkg.Obj != nil || !localNames[pkg.Name] {
+			return true
+		}
+		call.Args[0] = &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent("otelbridge"),
+				Sel: ast.NewIdent("Wrap"),
+			},
+			Args: []ast.Expr{call.Args[0]},
+		}
+		rewritten++
+		return true
+	})
+	return rewritten
+}
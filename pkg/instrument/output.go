@@ -0,0 +1,74 @@
+package instrument
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// OutputNameData is the data available to the --output template of buildTracedApplication,
+// e.g. "bin/{{.App}}-traced-{{.GOOS}}".
+type OutputNameData struct {
+	App    string // The application name: --name, or the project directory's base name.
+	GOOS   string
+	GOARCH string
+}
+
+// RenderOutputPath expands the output path template tmpl. On Windows, ".exe" is appended to paths
+// without an extension.
+//
+// Parameters:
+//   - tmpl (string): the path template, in text/template syntax.
+//   - data (OutputNameData): the values available to the template.
+//
+// Returns:
+//   - string: the expanded path.
+//   - error: an error if the template is invalid or expands to an empty path.
+func RenderOutputPath(tmpl string, data OutputNameData) (string, error) {
+	t, err := template.New("output").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid output template: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("invalid output template: %v", err)
+	}
+	path := buf.String()
+	if path == "" {
+		return "", fmt.Errorf("output template %q expands to an empty path", tmpl)
+	}
+	if data.GOOS == "windows" && filepath.Ext(path) == "" {
+		path += ".exe"
+	}
+	return path, nil
+}
+
+// MoveFile moves the file at src to dst, creating dst's directory if needed. os.Rename cannot move
+// files between filesystems, such as from a workspace in a tmpfs /tmp, so the file is copied and
+// the original removed when renaming fails.
+//
+// Parameters:
+//   - src (string): the file to move.
+//   - dst (string): the destination path.
+//
+// Returns:
+//   - error: an error if the file can be neither renamed nor copied.
+func MoveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+	renameErr := os.Rename(src, dst)
+	if renameErr == nil {
+		return nil
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return renameErr
+	}
+	if err := copyFile(src, dst, info); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %v (copy failed: %v)", src, dst, renameErr, err)
+	}
+	return os.Remove(src)
+}
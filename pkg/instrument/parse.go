@@ -8,7 +8,12 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/theme"
 )
 
 // TraceRecord holds parsed information from the tracewrap log.
@@ -21,6 +26,36 @@ type TraceRecord struct {
 	SysMem   string
 	Params   []string
 	Returns  []string
+	Entered  time.Time // Timestamp of the Entering line, zero if it had none.
+	Exited   time.Time // Timestamp of the Exiting line, zero if it had none.
+}
+
+// legacyLogTimeLayout is the log.LstdFlags layout used by tracewrap.log before timestamps were
+// written with nanosecond resolution (tracer.LogTimeLayout).
+const legacyLogTimeLayout = "2006/01/02 15:04:05"
+
+// splitLogTime separates the timestamp at the start of a tracewrap.log line from the message.
+// Both the current RFC3339Nano timestamps and the older second-resolution ones are recognized;
+// the older ones are interpreted in the local time zone, as log.LstdFlags wrote them.
+//
+// Parameters:
+//   - line (string): a line of the log.
+//
+// Returns:
+//   - time.Time: the timestamp, or the zero time if the line has none.
+//   - string: the rest of the line.
+func splitLogTime(line string) (time.Time, string) {
+	if first, rest, ok := strings.Cut(line, " "); ok {
+		if ts, err := time.Parse(time.RFC3339Nano, first); err == nil {
+			return ts, rest
+		}
+	}
+	if len(line) > len(legacyLogTimeLayout) {
+		if ts, err := time.ParseInLocation(legacyLogTimeLayout, line[:len(legacyLogTimeLayout)], time.Local); err == nil {
+			return ts, strings.TrimPrefix(line[len(legacyLogTimeLayout):], " ")
+		}
+	}
+	return time.Time{}, line
 }
 
 var (
@@ -31,11 +66,19 @@ var (
 	reReturning = regexp.MustCompile(`Function (\S+) returning \[(.*?)\](.*)`)
 )
 
-// ParseLogAndGenerateCallGraph parses the provided tracewrap log file and generates a callgraph.dot file.
-func ParseLogAndGenerateCallGraph(logPath string) error {
+// ParseLog reads the function entries and exits recorded in a tracewrap log file, in ascending
+// ID order. Each record carries the timestamps of its Entering and Exiting lines.
+//
+// Parameters:
+//   - logPath (string): the path to tracewrap.log.
+//
+// Returns:
+//   - []*TraceRecord: the parsed records.
+//   - error: an error if the file cannot be read.
+func ParseLog(logPath string) ([]*TraceRecord, error) {
 	file, err := os.Open(logPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer file.Close()
 
@@ -44,7 +87,7 @@ func ParseLogAndGenerateCallGraph(logPath string) error {
 	var currentRecord *TraceRecord
 
 	for scanner.Scan() {
-		line := scanner.Text()
+		ts, line := splitLogTime(scanner.Text())
 
 		if strings.Contains(line, "Entering") {
 			matches := reEntering.FindStringSubmatch(line)
@@ -52,6 +95,7 @@ func ParseLogAndGenerateCallGraph(logPath string) error {
 				rec := &TraceRecord{
 					FuncName: matches[1],
 					ID:       matches[2],
+					Entered:  ts,
 				}
 				records = append(records, rec)
 				currentRecord = rec
@@ -76,6 +120,7 @@ func ParseLogAndGenerateCallGraph(logPath string) error {
 					if rec.ID == matches[2] {
 						rec.Duration = matches[3]
 						rec.MemDiff = matches[4]
+						rec.Exited = ts
 						break
 					}
 				}
@@ -90,6 +135,30 @@ func ParseLogAndGenerateCallGraph(logPath string) error {
 	}
 
 	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Order records by ID so that interleaved log lines produce the same graph.
+	sort.SliceStable(records, func(i, j int) bool {
+		a, _ := strconv.Atoi(records[i].ID)
+		b, _ := strconv.Atoi(records[j].ID)
+		return a < b
+	})
+	return records, nil
+}
+
+// ParseLogAndGenerateCallGraph parses the provided tracewrap log file and generates a callgraph.dot file.
+// Nodes are written in ascending ID order, so the output does not depend on how log lines interleave.
+//
+// Parameters:
+//   - logPath (string): the log file; the graph is written next to it.
+//   - palette (theme.Palette): the colors of the graph, from visualization.theme.
+//
+// Returns:
+//   - error: an error if the log cannot be read or the graph cannot be written.
+func ParseLogAndGenerateCallGraph(logPath string, palette theme.Palette) error {
+	records, err := ParseLog(logPath)
+	if err != nil {
 		return err
 	}
 
@@ -103,7 +172,7 @@ func ParseLogAndGenerateCallGraph(logPath string) error {
 
 	// Write DOT file header.
 	fmt.Fprintln(outFile, "digraph CallGraph {")
-	fmt.Fprintln(outFile, `  node [shape=box, style=filled, color="lightblue"];`)
+	fmt.Fprint(outFile, palette.OrDefault().DOTDefaults())
 
 	// Assume that the record for "main" is the parent. Do not create a node for main.
 	var mainID string
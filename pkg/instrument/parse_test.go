@@ -0,0 +1,75 @@
+package instrument_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/instrument"
+	"github.com/mwiater/tracewrap/pkg/theme"
+)
+
+func TestParseLogReadsTimestamps(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "parsetest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	log := `2024-01-01T12:00:00.000000100Z [TRACEWRAP] Entering main ID: 1
+2024-01-01T12:00:00.000000200Z [TRACEWRAP] Entering work ID: 2
+2024-01-01T12:00:00.000000250Z [TRACEWRAP] Parameter n = 3
+2024-01-01T12:00:00.000000300Z [TRACEWRAP] Exiting work, ID: 2, Duration: 100ns, MemDiff: 0 bytes
+2024/01/01 12:00:01 [TRACEWRAP] Entering legacy ID: 3
+`
+	logPath := filepath.Join(tempDir, "tracewrap.log")
+	if err := os.WriteFile(logPath, []byte(log), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	records, err := instrument.ParseLog(logPath)
+	if err != nil {
+		t.Fatalf("ParseLog returned error: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected three records, got %d", len(records))
+	}
+	work := records[1]
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if !work.Entered.Equal(t0.Add(200)) || !work.Exited.Equal(t0.Add(300)) || work.Duration != "100ns" {
+		t.Errorf("Unexpected work record: %+v", work)
+	}
+	if len(work.Params) != 1 || work.Params[0] != "n = 3" {
+		t.Errorf("Expected the parameter to be parsed, got %v", work.Params)
+	}
+	want := time.Date(2024, 1, 1, 12, 0, 1, 0, time.Local)
+	if legacy := records[2]; legacy.FuncName != "legacy" || !legacy.Entered.Equal(want) {
+		t.Errorf("Expected the legacy timestamp to be parsed, got %+v", legacy)
+	}
+}
+
+func TestParseLogAndGenerateCallGraphUsesPalette(t *testing.T) {
+	tempDir := t.TempDir()
+	log := `2024-01-01T12:00:00.000000100Z [TRACEWRAP] Entering main ID: 1
+2024-01-01T12:00:00.000000200Z [TRACEWRAP] Entering work ID: 2
+`
+	logPath := filepath.Join(tempDir, "tracewrap.log")
+	if err := os.WriteFile(logPath, []byte(log), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	dark, err := theme.New(theme.Dark, nil)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	if err := instrument.ParseLogAndGenerateCallGraph(logPath, dark); err != nil {
+		t.Fatalf("ParseLogAndGenerateCallGraph returned error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(tempDir, "callgraph.dot"))
+	if err != nil {
+		t.Fatalf("Failed to read DOT file: %v", err)
+	}
+	if dot := string(data); !strings.Contains(dot, dark.DOTDefaults()) || strings.Contains(dot, "lightblue") {
+		t.Errorf("Expected the graph in the colors of the dark theme, got:\n%s", dot)
+	}
+}
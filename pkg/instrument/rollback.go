@@ -0,0 +1,58 @@
+package instrument
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// invalidOutputError reports instrumentation output that is not valid Go. InstrumentWorkspace
+// keeps the original file instead and lists it in the inventory's Fallbacks.
+type invalidOutputError struct {
+	reason string
+}
+
+// Error returns the reason the output was rejected.
+func (e *invalidOutputError) Error() string {
+	return e.reason
+}
+
+// verifyOutput checks the instrumented source of a file before it replaces the original: it must
+// parse, and type-checking it with the other files of its package must not find errors the original
+// did not have. Errors about imports that do not resolve, such as the tracer's, are not counted,
+// since the packageLoader cannot resolve imports outside the standard library; the types declared there
+// are unknown, so the code using them is not checked.
+//
+// Parameters:
+//   - fset (*token.FileSet): the file set the package was parsed with.
+//   - filePath (string): the path of the file.
+//   - name (string): the package name.
+//   - out ([]byte): the instrumented source.
+//   - original (*typeChecker): the type check of the original file, from packageLoader.check.
+//
+// Returns:
+//   - error: an *invalidOutputError if the output is not valid, or nil.
+func verifyOutput(fset *token.FileSet, filePath, name string, out []byte, original *typeChecker) error {
+	f, err := parser.ParseFile(fset, filePath, out, parser.SkipObjectResolution)
+	if err != nil {
+		return &invalidOutputError{reason: fmt.Sprintf("instrumented source does not parse: %v", err)}
+	}
+	_, errs := checkFiles(fset, original.imp, name, append([]*ast.File{f}, original.others...), nil)
+	known := make(map[string]int, len(original.errs))
+	for _, msg := range original.errs {
+		known[msg]++
+	}
+	for _, msg := range errs {
+		if known[msg] > 0 {
+			known[msg]--
+			continue
+		}
+		if strings.HasPrefix(msg, "could not import ") {
+			continue
+		}
+		return &invalidOutputError{reason: fmt.Sprintf("instrumented source does not type-check: %s", msg)}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package instrument
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SourceMapFile is the name of the source map InstrumentWorkspace writes to the root of the
+// workspace. Injected statements shift the lines of the instrumented files, so the positions in
+// the stack traces of an instrumented binary are translated back with it (see TranslateStack).
+const SourceMapFile = "tracewrap-sourcemap.json"
+
+// BuildSourceMapFile is the name of the copy of the source map buildTracedApplication writes to
+// the artifact root, with its Root set, for translate-stack.
+const BuildSourceMapFile = "sourcemap.json"
+
+// SourceMap relates the lines of the instrumented files of a workspace to the project's.
+type SourceMap struct {
+	Workspace string `json:"workspace"` // The absolute path of the workspace the binary was built in.
+	// Root is the absolute path of the project directory, set by buildTracedApplication.
+	// Translated positions are made absolute against it.
+	Root  string               `json:"root,omitempty"`
+	Files map[string]FileLines `json:"files"` // The instrumented files, by slash-separated path relative to the workspace.
+}
+
+// FileLines maps the lines of one instrumented file to the original.
+type FileLines struct {
+	// Lines holds the original line of each instrumented line, Lines[0] for line 1. Injected lines
+	// have the line of the function they were injected into, or 0 outside functions.
+	Lines []int `json:"lines"`
+	// Injected lists the instrumented lines holding only injected code, ascending.
+	Injected []int `json:"injected,omitempty"`
+}
+
+// Line returns the original line of an instrumented line, and whether the line holds only
+// injected code. Lines the map does not relate to an original line, such as code injected outside
+// functions, are returned as they are.
+//
+// Parameters:
+//   - line (int): the line in the instrumented file.
+//
+// Returns:
+//   - int: the line in the project's file, or line if it is not mapped.
+//   - bool: true if the line was injected.
+//   - bool: false if the line is not mapped.
+func (fl FileLines) Line(line int) (int, bool, bool) {
+	if line < 1 || line > len(fl.Lines) {
+		return line, false, false
+	}
+	i := sort.SearchInts(fl.Injected, line)
+	injected := i < len(fl.Injected) && fl.Injected[i] == line
+	if fl.Lines[line-1] == 0 {
+		return line, injected, false
+	}
+	return fl.Lines[line-1], injected, true
+}
+
+// mapLines relates the lines of out, the printed source of f, to the lines of the nodes of f that
+// came from the parsed file. The nodes of f and of out parsed again are visited in the same order,
+// so those at the same index are the same. A line is mapped by the node starting on it or, for
+// lines such as a closing brace, ending on it; the nodes injected have no position, and the lines
+// holding only them are attributed to the enclosing function.
+func mapLines(fset *token.FileSet, f *ast.File, out []byte) (FileLines, error) {
+	outSet := token.NewFileSet()
+	printed, err := parser.ParseFile(outSet, "instrumented.go", out, parser.SkipObjectResolution)
+	if err != nil {
+		return FileLines{}, err
+	}
+	lines := make([]int, outSet.File(printed.Pos()).LineCount())
+	mapped := make([]bool, len(lines))
+	mapLine := func(at token.Pos, orig token.Pos) {
+		if i := outSet.Position(at).Line - 1; !mapped[i] {
+			lines[i], mapped[i] = fset.Position(orig).Line, true
+		}
+	}
+	var pairs [][2]ast.Node
+	before, after := syntaxNodes(f), syntaxNodes(printed)
+	for i := 0; i < len(before) && i < len(after); i++ {
+		if reflect.TypeOf(before[i]) != reflect.TypeOf(after[i]) {
+			// The printed tree differs in shape from then on; the rest of the file stays unmapped.
+			break
+		}
+		if before[i].Pos().IsValid() {
+			pairs = append(pairs, [2]ast.Node{before[i], after[i]})
+		}
+	}
+	for _, p := range pairs {
+		mapLine(p[1].Pos(), p[0].Pos())
+	}
+	for _, p := range pairs {
+		// The end of a node whose last part was injected has no position in the file.
+		if end := p[0].End(); end > p[0].Pos() {
+			mapLine(p[1].End()-1, end-1)
+		}
+	}
+	fl := FileLines{Lines: lines}
+	for _, p := range pairs {
+		if _, ok := p[1].(*ast.FuncDecl); !ok {
+			continue
+		}
+		line := fset.Position(p[0].Pos()).Line
+		for at := outSet.Position(p[1].Pos()).Line; at <= outSet.Position(p[1].End()).Line && at <= len(lines); at++ {
+			if !mapped[at-1] && strings.TrimSpace(lineText(out, outSet, printed, at)) != "" {
+				lines[at-1], mapped[at-1] = line, true
+				fl.Injected = append(fl.Injected, at)
+			}
+		}
+	}
+	sort.Ints(fl.Injected)
+	return fl, nil
+}
+
+// syntaxNodes returns the nodes of f in the order ast.Inspect visits them, without comments:
+// the printer may attach them to other nodes than the parser did.
+func syntaxNodes(f *ast.File) []ast.Node {
+	var nodes []ast.Node
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n.(type) {
+		case nil:
+			return false
+		case *ast.CommentGroup, *ast.Comment:
+			return false
+		}
+		nodes = append(nodes, n)
+		return true
+	})
+	return nodes
+}
+
+// lineText returns the text of line of the file f parsed from src.
+func lineText(src []byte, fset *token.FileSet, f *ast.File, line int) string {
+	tf := fset.File(f.Pos())
+	start := tf.Offset(tf.LineStart(line))
+	end := len(src)
+	if line < tf.LineCount() {
+		end = tf.Offset(tf.LineStart(line + 1))
+	}
+	return string(src[start:end])
+}
+
+// stackPosition matches the file positions in a Go stack trace, such as "/tmp/ws/main.go:42" in
+// "\t/tmp/ws/main.go:42 +0x1d", or in "main.handle (/tmp/ws/main.go:42)".
+var stackPosition = regexp.MustCompile(`[^\s()]+\.go:(\d+)`)
+
+// TranslateStack rewrites the file positions in a stack trace printed by an instrumented binary,
+// from the workspace files to the project's, with the original line numbers. Frames in code
+// tracewrap injected carry the line of their function and are marked "(injected by tracewrap)".
+// Positions outside the workspace, such as the standard library's, are kept. Frames built with
+// -trimpath are matched by the longest file path they end with.
+//
+// Parameters:
+//   - stack (string): the stack trace, or any text containing one, such as a panic's output.
+//
+// Returns:
+//   - string: the translated text.
+func (m SourceMap) TranslateStack(stack string) string {
+	lines := strings.Split(stack, "\n")
+	for i, line := range lines {
+		injected := false
+		lines[i] = stackPosition.ReplaceAllStringFunc(line, func(pos string) string {
+			colon := strings.LastIndex(pos, ":")
+			rel, ok := m.rel(pos[:colon])
+			if !ok {
+				return pos
+			}
+			n, _ := strconv.Atoi(pos[colon+1:])
+			if fl, ok := m.Files[rel]; ok {
+				var inInjected bool
+				n, inInjected, _ = fl.Line(n)
+				injected = injected || inInjected
+			}
+			path := rel
+			if m.Root != "" {
+				path = filepath.Join(m.Root, filepath.FromSlash(rel))
+			}
+			return fmt.Sprintf("%s:%d", path, n)
+		})
+		if injected {
+			lines[i] += " (injected by tracewrap)"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// rel returns the slash-separated path relative to the workspace of a file in a stack trace, and
+// whether the file is in the workspace.
+func (m SourceMap) rel(path string) (string, bool) {
+	slashed := filepath.ToSlash(path)
+	if m.Workspace != "" {
+		if rel, ok := strings.CutPrefix(slashed, filepath.ToSlash(m.Workspace)+"/"); ok {
+			return rel, true
+		}
+	}
+	best := ""
+	for rel := range m.Files {
+		if (slashed == rel || strings.HasSuffix(slashed, "/"+rel)) && len(rel) > len(best) {
+			best = rel
+		}
+	}
+	return best, best != ""
+}
+
+// WriteSourceMap writes m as JSON to path.
+//
+// Parameters:
+//   - path (string): the output file.
+//   - m (SourceMap): the source map.
+//
+// Returns:
+//   - error: an error if the file cannot be written.
+func WriteSourceMap(path string, m SourceMap) error {
+	if m.Files == nil {
+		m.Files = map[string]FileLines{}
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ReadSourceMap reads a source map written by WriteSourceMap, such as "tracewrap/sourcemap.json".
+//
+// Parameters:
+//   - path (string): the source map file.
+//
+// Returns:
+//   - SourceMap: the source map.
+//   - error: an error if the file cannot be read or parsed.
+func ReadSourceMap(path string) (SourceMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SourceMap{}, err
+	}
+	var m SourceMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return SourceMap{}, fmt.Errorf("invalid source map %s: %v", path, err)
+	}
+	return m, nil
+}
@@ -0,0 +1,109 @@
+package instrument_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+)
+
+func TestInstrumentWorkspaceWritesSourceMap(t *testing.T) {
+	tempDir := t.TempDir()
+	src := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println(divide(4, 2))
+}
+
+func divide(a, b int) (int, error) {
+	if b == 0 {
+		return 0, fmt.Errorf("zero")
+	}
+	q := a / b
+	return q, nil
+}
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "main.go"), []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := instrument.SetDynamicTracerImport(tempDir); err != nil {
+		t.Fatalf("SetDynamicTracerImport failed: %v", err)
+	}
+	if err := instrument.InstrumentWorkspace(tempDir, config.Config{}); err != nil {
+		t.Fatalf("InstrumentWorkspace returned error: %v", err)
+	}
+	sourceMap, err := instrument.ReadSourceMap(filepath.Join(tempDir, instrument.SourceMapFile))
+	if err != nil {
+		t.Fatalf("ReadSourceMap returned error: %v", err)
+	}
+	fl, ok := sourceMap.Files["main.go"]
+	if !ok {
+		t.Fatalf("Expected main.go in the source map, got %+v", sourceMap.Files)
+	}
+	out, err := os.ReadFile(filepath.Join(tempDir, "main.go"))
+	if err != nil {
+		t.Fatalf("Failed to read instrumented file: %v", err)
+	}
+	instrumented := strings.Split(string(out), "\n")
+	lineOf := func(text string) int {
+		for i, line := range instrumented {
+			if strings.TrimSpace(line) == text {
+				return i + 1
+			}
+		}
+		t.Fatalf("Expected %q in the instrumented file:\n%s", text, out)
+		return 0
+	}
+	for text, want := range map[string]int{
+		"q := a / b":                           13,
+		"if b == 0 {":                          10,
+		"func main() {":                        5,
+		"func divide(a, b int) (int, error) {": 9,
+	} {
+		at := lineOf(text)
+		if got, injected, ok := fl.Line(at); got != want || injected || !ok {
+			t.Errorf("Instrumented line %d (%s): expected original line %d, got %d (injected %v)", at, text, want, got, injected)
+		}
+	}
+	injectedAt := lineOf("defer __tracewrap_span.RecordAll(__tracewrap_snapshot)")
+	if got, injected, _ := fl.Line(injectedAt); !injected || (got != 5 && got != 9) {
+		t.Errorf("Expected the injected exit defer to map to its function, got %d (injected %v)", got, injected)
+	}
+
+	sourceMap.Root = "/src/app"
+	frame := fmt.Sprintf("main.divide(...)\n\t%s:%d +0x1d\nruntime.main()\n\t/usr/local/go/src/runtime/proc.go:271 +0x29", filepath.Join(sourceMap.Workspace, "main.go"), lineOf("q := a / b"))
+	want := "main.divide(...)\n\t" + filepath.Join("/src/app", "main.go") + ":13 +0x1d\nruntime.main()\n\t/usr/local/go/src/runtime/proc.go:271 +0x29"
+	if got := sourceMap.TranslateStack(frame); got != want {
+		t.Errorf("TranslateStack:\ngot  %q\nwant %q", got, want)
+	}
+	entry := fmt.Sprintf("  main.divide (%s:%d)", filepath.Join(sourceMap.Workspace, "main.go"), lineOf("q := a / b"))
+	if got := sourceMap.TranslateStack(entry); got != "  main.divide ("+filepath.Join("/src/app", "main.go")+":13)" {
+		t.Errorf("Expected a position inside a line to be translated, got %q", got)
+	}
+	trimmed := fmt.Sprintf("\texample.com/app/main.go:%d +0x1d", injectedAt)
+	if got := sourceMap.TranslateStack(trimmed); !strings.HasSuffix(got, "(injected by tracewrap)") {
+		t.Errorf("Expected a -trimpath frame in injected code to be matched and marked, got %q", got)
+	}
+}
+
+func TestFileLinesKeepsUnmappedLines(t *testing.T) {
+	fl := instrument.FileLines{Lines: []int{0, 3}, Injected: []int{1}}
+	for _, tc := range []struct {
+		line, want   int
+		injected, ok bool
+	}{
+		{1, 1, true, false},
+		{2, 3, false, true},
+		{7, 7, false, false},
+	} {
+		if got, injected, ok := fl.Line(tc.line); got != tc.want || injected != tc.injected || ok != tc.ok {
+			t.Errorf("Line(%d): got %d, %v, %v; want %d, %v, %v", tc.line, got, injected, ok, tc.want, tc.injected, tc.ok)
+		}
+	}
+}
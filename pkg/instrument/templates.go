@@ -0,0 +1,137 @@
+package instrument
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"text/template"
+
+	"github.com/mwiater/tracewrap/config"
+)
+
+// Injection mechanisms of InstrumentationConfig.Injection.
+const (
+	InjectAST      = "ast"      // The builders of builders.go construct the injected nodes.
+	InjectTemplate = "template" // The templates below are rendered to Go source and parsed.
+)
+
+// prologueTemplate is the Go source of the statements injected at the top of each function, the
+// template form of buildStartStmts, buildExitDefer, buildPanicDefer and buildParamStmts. A new
+// capture is added by writing the Go code that makes it.
+var prologueTemplate = template.Must(template.New("prologue").Parse(`
+__tracewrap_snapshot := tracer.TakeSnapshot({{.Measure}})
+__tracewrap_span := {{if .ID}}tracer.RecordEntryID({{.ID}}, {{printf "%q" .Name}}){{else}}tracer.RecordEntry({{printf "%q" .Name}}){{end}}
+defer __tracewrap_span.RecordAll(__tracewrap_snapshot)
+defer func() {
+	r := recover()
+	if r != nil {
+		__tracewrap_span.RecordPanic(r, string(debug.Stack()))
+		panic(r)
+	}
+}()
+{{- range .Params}}
+{{- if and .Record .Variadic}}
+__tracewrap_span.SetVariadic({{printf "%q" .Name}}, {{.Name}})
+{{- else if .Record}}
+__tracewrap_span.SetAttribute({{printf "%q" .Name}}, {{if .Opaque}}tracer.Opaque({{printf "%q" .Opaque}}){{else}}{{.Name}}{{end}})
+{{- end}}
+{{- if .Labels}}
+__tracewrap_span.RecordLabels({{.Name}})
+{{- end}}
+{{- if .Track}}
+{{.Name}} = tracer.TrackResponse({{.Name}})
+{{- end}}
+{{- end}}
+`))
+
+// mainExitTemplate is the Go source of the statements appended to func main, the template form of
+// buildMainExitStmts.
+var mainExitTemplate = template.Must(template.New("mainExit").Parse(`
+tracer.DumpCallGraphDOT(tracer.ArtifactPath("callgraph.dot"))
+tracer.Flush()
+{{- if .DumpOnExit}}
+tracer.DumpTrace()
+{{- end}}
+`))
+
+// injectionData is the data the injection templates are rendered with.
+type injectionData struct {
+	Name       string       // The name the function's records carry.
+	ID         int          // The ID of Name in the symbol table, or 0.
+	Params     []entryParam // The parameters captured at entry (see entryParams).
+	Measure    string       // The tracer.Measure* flags of the metrics measured (see injectedMetrics).
+	DumpOnExit bool         // tracing.dumpOnExit.
+}
+
+// renderPrologue renders prologueTemplate for a function into the statements injected at its
+// top, the same statements the builders construct.
+//
+// Parameters:
+//   - traceName (string): the name the function's records carry.
+//   - traceID (int): the ID of the name in the symbol table, or 0.
+//   - params (*ast.FieldList): the function's parameters, or nil.
+//   - typeInfo (*typeChecker): the type information of the function's package.
+//   - cfg (config.Config): the configuration settings used for instrumentation.
+//
+// Returns:
+//   - []ast.Stmt: the statements.
+//   - error: an error object if the rendered source does not parse.
+func renderPrologue(traceName string, traceID int, params *ast.FieldList, typeInfo *typeChecker, cfg config.Config) ([]ast.Stmt, error) {
+	return renderStmts(prologueTemplate, injectionData{Name: traceName, ID: traceID, Params: entryParams(params, typeInfo, cfg), Measure: types.ExprString(injectedMetrics(cfg).flags())})
+}
+
+// renderMainExit renders mainExitTemplate into the statements appended to func main.
+//
+// Parameters:
+//   - cfg (config.Config): the configuration settings used for instrumentation.
+//
+// Returns:
+//   - []ast.Stmt: the statements.
+//   - error: an error object if the rendered source does not parse.
+func renderMainExit(cfg config.Config) ([]ast.Stmt, error) {
+	return renderStmts(mainExitTemplate, injectionData{DumpOnExit: cfg.Tracing.DumpOnExit})
+}
+
+// renderStmts renders tmpl with data and parses the result as the body of a function. The
+// positions of the parsed nodes belong to a file of their own, so they are cleared: printed into
+// the instrumented file, the statements are laid out as the built ones are.
+func renderStmts(tmpl *template.Template, data injectionData) ([]ast.Stmt, error) {
+	var src bytes.Buffer
+	src.WriteString("package p\n\nfunc _() {")
+	if err := tmpl.Execute(&src, data); err != nil {
+		return nil, fmt.Errorf("failed to render the %s template: %v", tmpl.Name(), err)
+	}
+	src.WriteString("\n}\n")
+	f, err := parser.ParseFile(token.NewFileSet(), tmpl.Name()+".go", src.Bytes(), parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("the %s template renders invalid Go: %v", tmpl.Name(), err)
+	}
+	body := f.Decls[0].(*ast.FuncDecl).Body
+	ast.Inspect(body, clearPositions)
+	return body.List, nil
+}
+
+// posType is the type of the position fields cleared by clearPositions.
+var posType = reflect.TypeOf(token.NoPos)
+
+// clearPositions sets the token.Pos fields of n to token.NoPos; it is an ast.Inspect visitor.
+func clearPositions(n ast.Node) bool {
+	if n == nil {
+		return false
+	}
+	v := reflect.ValueOf(n)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return true
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if field := v.Field(i); field.Type() == posType && field.CanSet() {
+			field.SetInt(int64(token.NoPos))
+		}
+	}
+	return true
+}
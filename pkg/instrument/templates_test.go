@@ -0,0 +1,74 @@
+package instrument_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+)
+
+func TestTemplateInjectionMatchesASTInjection(t *testing.T) {
+	files := map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"main.go": `package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type server struct{}
+
+func (s *server) handle(ctx context.Context, w http.ResponseWriter, r *http.Request, done func()) (int, error) {
+	if r == nil {
+		return 0, fmt.Errorf("no request")
+	}
+	return fmt.Fprintln(w, ctx.Value("user"))
+}
+
+func main() {
+	fmt.Println(len("hello"))
+}
+`,
+	}
+	instrumented := func(injection string, captureBasicKindsOnly bool, metrics ...string) string {
+		dir := t.TempDir()
+		writeFiles(t, dir, files)
+		if err := instrument.SetDynamicTracerImport(dir); err != nil {
+			t.Fatalf("SetDynamicTracerImport failed: %v", err)
+		}
+		var cfg config.Config
+		cfg.Instrumentation.Injection = injection
+		cfg.Instrumentation.CaptureBasicKindsOnly = captureBasicKindsOnly
+		cfg.Instrumentation.Metrics = metrics
+		cfg.Tracing.DumpOnExit = true
+		if err := instrument.InstrumentWorkspace(dir, cfg); err != nil {
+			t.Fatalf("InstrumentWorkspace returned error: %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "main.go"))
+		if err != nil {
+			t.Fatalf("Failed to read main.go: %v", err)
+		}
+		return string(data)
+	}
+	for _, basicOnly := range []bool{false, true} {
+		built := instrumented(instrument.InjectAST, basicOnly)
+		if rendered := instrumented(instrument.InjectTemplate, basicOnly); rendered != built {
+			t.Errorf("Expected the template injection to match the AST injection (captureBasicKindsOnly %v)\ntemplate:\n%s\nast:\n%s", basicOnly, rendered, built)
+		}
+	}
+	for _, metrics := range [][]string{{instrument.MetricTime}, {instrument.MetricMem, instrument.MetricIO}, {instrument.MetricGoroutines}} {
+		built := instrumented(instrument.InjectAST, false, metrics...)
+		// Kept uninstrumented, the file would match trivially.
+		if !strings.Contains(built, "tracer.RecordEntryID(") {
+			t.Fatalf("Expected main.go to be instrumented with metrics %v:\n%s", metrics, built)
+		}
+		if rendered := instrumented(instrument.InjectTemplate, false, metrics...); rendered != built {
+			t.Errorf("Expected the template injection to match the AST injection (metrics %v)\ntemplate:\n%s\nast:\n%s", metrics, rendered, built)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package instrument
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BuildError is the error of a failed "go build" of the instrumented workspace. It carries the
+// compiler output and, from BuildWithFallback, its diagnosis.
+type BuildError struct {
+	Err       error
+	Output    []byte
+	Diagnoses []Diagnosis
+}
+
+// Error returns the build error with the raw compiler output.
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("build failed: %v, output: %s", e.Err, string(e.Output))
+}
+
+// Unwrap returns the error of the go command.
+func (e *BuildError) Unwrap() error { return e.Err }
+
+// Diagnosis explains one compiler error of an instrumented build in terms of the project: the
+// position in the original file and, when the error is in code tracewrap injected, the construct
+// responsible.
+type Diagnosis struct {
+	File string `json:"file,omitempty"` // The file, relative to the project root and slash-separated; empty without a position.
+	// Line is the line in the project's file, 0 when the line was injected or rewritten.
+	Line             int    `json:"line,omitempty"`
+	InstrumentedLine int    `json:"instrumentedLine,omitempty"` // The line in the instrumented file.
+	Column           int    `json:"column,omitempty"`
+	Function         string `json:"function,omitempty"`     // The enclosing function, e.g. "Server.handle".
+	FunctionLine     int    `json:"functionLine,omitempty"` // The line of the function in the project's file.
+	// Construct names the injected code the error is in, e.g. "return value capture
+	// (__tracewrap_span.RecordReturn)", or is empty for the project's own code.
+	Construct string `json:"construct,omitempty"`
+	Source    string `json:"source,omitempty"` // The instrumented line, trimmed.
+	Message   string `json:"message"`
+}
+
+// constructs describes the code injected around each tracer call, and around the calls on the
+// span of the instrumented call.
+var constructs = map[string]string{
+	"RecordEntry":           "function entry",
+	"RecordEntryID":         "function entry",
+	"RecordParam":           "parameter capture",
+	"SetAttribute":          "parameter capture",
+	"SetVariadic":           "parameter capture",
+	"Opaque":                "parameter capture",
+	"RecordLabels":          "context label capture",
+	"RecordReturn":          "return value capture",
+	"RecordAll":             "exit defer",
+	"RecordPanic":           "panic recovery defer",
+	"TakeSnapshot":          "resource measurement",
+	"Shutdown":              "os.Exit rewrite",
+	"DumpCallGraphDOT":      "artifact output in func main",
+	"Flush":                 "artifact output in func main",
+	"DumpTrace":             "artifact output in func main",
+	"ArtifactPath":          "artifact output in func main",
+	"CorrelateLogs":         "log correlation in func main",
+	"TrackResponse":         "http.ResponseWriter wrapping",
+	"RunCommand":            "exec.Cmd rewrite",
+	"OutputCommand":         "exec.Cmd rewrite",
+	"CommandOutput":         "exec.Cmd rewrite",
+	"CombinedOutputCommand": "exec.Cmd rewrite",
+	"StartCommand":          "exec.Cmd rewrite",
+	"WaitCommand":           "exec.Cmd rewrite",
+	"StartRegion":           "region marker",
+	"EndRegion":             "region marker",
+	"Count":                 "counter marker",
+}
+
+// injectedIdent matches the variables instrumentation declares.
+var injectedIdent = regexp.MustCompile(`^(__tracewrap_\w+|_ret\d+)$`)
+
+// DiagnoseBuild maps the compiler errors of a failed instrumented build back to the project. Each
+// positioned error in a workspace file is located in the original file with the workspace's
+// SourceMapFile, as TranslateStack locates the frames of a stack trace, and the injected construct
+// it falls in, if any, is named. Files the source map does not list, such as those left
+// uninstrumented, are the project's own and keep their lines. Errors in other files and
+// unpositioned errors, such as the linker's, are kept with their message only.
+//
+// Parameters:
+//   - workspace (string): the path to the workspace directory the build ran in.
+//   - projectDir (string): the project the workspace was prepared from.
+//   - out ([]byte): the output of "go build".
+//
+// Returns:
+//   - []Diagnosis: one diagnosis per error, in the order of the output.
+func DiagnoseBuild(workspace, projectDir string, out []byte) []Diagnosis {
+	var diagnoses []Diagnosis
+	// Without a source map, as for a workspace that was not instrumented, every file keeps its lines.
+	sourceMap, _ := ReadSourceMap(filepath.Join(workspace, SourceMapFile))
+	files := make(map[string]*fileMap)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// go vet prefixes the type errors it reports with "vet: ".
+		line := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "vet: ")
+		if line == "" || strings.HasPrefix(line, "# ") {
+			continue
+		}
+		m := buildErrorPosition.FindStringSubmatch(line)
+		if m == nil {
+			diagnoses = append(diagnoses, Diagnosis{Message: line})
+			continue
+		}
+		d := Diagnosis{File: m[1], Message: m[4]}
+		d.InstrumentedLine, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		rel, ok := workspaceRel(workspace, m[1])
+		if !ok || filepath.Ext(rel) != ".go" {
+			diagnoses = append(diagnoses, d)
+			continue
+		}
+		d.File = filepath.ToSlash(rel)
+		fm, ok := files[rel]
+		if !ok {
+			fm = newFileMap(filepath.Join(workspace, rel), filepath.Join(projectDir, rel))
+			fm.lineMap, fm.instrumentedFile = sourceMap.Files[d.File]
+			files[rel] = fm
+		}
+		fm.diagnose(&d)
+		diagnoses = append(diagnoses, d)
+	}
+	return diagnoses
+}
+
+// fileMap relates an instrumented file to the project's original.
+type fileMap struct {
+	fset         *token.FileSet
+	instrumented *ast.File // Nil if the file does not parse.
+	lines        []string  // The instrumented file's lines, trimmed.
+	original     *ast.File // Nil if the project has no such file, or it does not parse.
+	// lineMap maps the lines of the file if instrumentedFile is set; other files are the project's.
+	lineMap          FileLines
+	instrumentedFile bool
+}
+
+// newFileMap parses the instrumented and original versions of a file; either may be missing.
+func newFileMap(instrumentedPath, originalPath string) *fileMap {
+	fm := &fileMap{fset: token.NewFileSet()}
+	if src, err := os.ReadFile(instrumentedPath); err == nil {
+		fm.lines = trimmedLines(src)
+		fm.instrumented, _ = parser.ParseFile(fm.fset, instrumentedPath, src, parser.SkipObjectResolution)
+	}
+	if src, err := os.ReadFile(originalPath); err == nil {
+		fm.original, _ = parser.ParseFile(token.NewFileSet(), originalPath, src, parser.ImportsOnly)
+	}
+	return fm
+}
+
+// originalLine returns the line in the project's file of a line of the instrumented file, or 0 if
+// the line was injected or is not mapped.
+func (fm *fileMap) originalLine(line int) int {
+	if !fm.instrumentedFile {
+		return line
+	}
+	orig, injected, mapped := fm.lineMap.Line(line)
+	if injected || !mapped {
+		return 0
+	}
+	return orig
+}
+
+// trimmedLines splits src into lines without surrounding white space.
+func trimmedLines(src []byte) []string {
+	lines := strings.Split(string(src), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return lines
+}
+
+// diagnose fills in the function, original line, construct and source of d from its
+// instrumented position.
+func (fm *fileMap) diagnose(d *Diagnosis) {
+	if d.InstrumentedLine >= 1 && d.InstrumentedLine <= len(fm.lines) {
+		d.Source = fm.lines[d.InstrumentedLine-1]
+	}
+	if fm.instrumented == nil {
+		return
+	}
+	tf := fm.fset.File(fm.instrumented.Pos())
+	if d.InstrumentedLine < 1 || d.InstrumentedLine > tf.LineCount() {
+		return
+	}
+	pos := tf.LineStart(d.InstrumentedLine)
+	if d.Column > 1 {
+		pos += token.Pos(d.Column - 1)
+	}
+	path := enclosingNodes(fm.instrumented, pos)
+	d.Construct = fm.construct(path)
+	for _, n := range path {
+		if fn, ok := n.(*ast.FuncDecl); ok {
+			d.Function = funcDeclName(fn)
+			d.FunctionLine = fm.originalLine(fm.fset.Position(fn.Pos()).Line)
+			break
+		}
+	}
+	// Lines rewritten by the instrumentation, such as an os.Exit call, are blamed on the construct.
+	if d.Construct == "" {
+		d.Line = fm.originalLine(d.InstrumentedLine)
+	}
+}
+
+// construct names the injected construct of the innermost statement or import in path, or
+// returns "" for the project's own code.
+func (fm *fileMap) construct(path []ast.Node) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		switch n := path[i].(type) {
+		case *ast.ImportSpec:
+			if !fm.originalImports(n.Path.Value) {
+				return "import " + n.Path.Value
+			}
+			return ""
+		case *ast.BlockStmt:
+			continue
+		case ast.Stmt:
+			return injectedConstruct(n)
+		}
+	}
+	return ""
+}
+
+// originalImports reports whether the original file imports the quoted path.
+func (fm *fileMap) originalImports(path string) bool {
+	if fm.original == nil {
+		return false
+	}
+	for _, spec := range fm.original.Imports {
+		if spec.Path.Value == path {
+			return true
+		}
+	}
+	return false
+}
+
+// injectedConstruct names the construct stmt belongs to when it calls the tracer or declares
+// an injected variable. Nested blocks are not searched, so the project's own statements that
+// contain injected code, such as an if whose returns were rewritten, are not blamed.
+func injectedConstruct(stmt ast.Stmt) string {
+	found := ""
+	ast.Inspect(stmt, func(n ast.Node) bool {
+		if found != "" {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			return n == stmt
+		case *ast.CallExpr:
+			sel, ok := n.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			x, ok := sel.X.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			switch x.Name {
+			case "tracer":
+				if label, ok := constructs[sel.Sel.Name]; ok {
+					found = fmt.Sprintf("%s (tracer.%s)", label, sel.Sel.Name)
+				} else {
+					found = fmt.Sprintf("injected call (tracer.%s)", sel.Sel.Name)
+				}
+			case "otelbridge":
+				found = fmt.Sprintf("OpenTelemetry bridge (otelbridge.%s)", sel.Sel.Name)
+			case spanVar:
+				if label, ok := constructs[sel.Sel.Name]; ok {
+					found = fmt.Sprintf("%s (%s.%s)", label, spanVar, sel.Sel.Name)
+				}
+			}
+		case *ast.Ident:
+			if injectedIdent.MatchString(n.Name) {
+				found = fmt.Sprintf("injected variable %s", n.Name)
+				if rest, ok := strings.CutPrefix(n.Name, "_ret"); ok && rest != "" {
+					found = "return value capture (" + n.Name + ")"
+				}
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// enclosingNodes returns the nodes of f enclosing pos, outermost first.
+func enclosingNodes(f *ast.File, pos token.Pos) []ast.Node {
+	var path []ast.Node
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil || pos < n.Pos() || pos >= n.End() {
+			return false
+		}
+		path = append(path, n)
+		return true
+	})
+	return path
+}
+
+// funcDeclName returns the name of fn, qualified by its receiver type for a method, as in
+// "Server.handle".
+func funcDeclName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return fn.Name.Name
+	}
+	t := fn.Recv.List[0].Type
+	for {
+		switch x := t.(type) {
+		case *ast.StarExpr:
+			t = x.X
+			continue
+		case *ast.IndexExpr:
+			t = x.X
+			continue
+		case *ast.IndexListExpr:
+			t = x.X
+			continue
+		case *ast.Ident:
+			return x.Name + "." + fn.Name.Name
+		}
+		return fn.Name.Name
+	}
+}
+
+// WriteDiagnoses prints a diagnosis of a failed instrumented build, one error per paragraph:
+// the position in the project's file, the message and, for errors in injected code, the
+// construct responsible and the instrumented line.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - diagnoses ([]Diagnosis): the diagnoses, from DiagnoseBuild.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteDiagnoses(w io.Writer, diagnoses []Diagnosis) error {
+	for _, d := range diagnoses {
+		var err error
+		switch {
+		case d.File == "":
+			_, err = fmt.Fprintf(w, "%s\n", d.Message)
+		case d.Line > 0:
+			_, err = fmt.Fprintf(w, "%s:%d: %s\n", d.File, d.Line, d.Message)
+		case d.FunctionLine > 0:
+			_, err = fmt.Fprintf(w, "%s:%d: %s\n", d.File, d.FunctionLine, d.Message)
+		default:
+			_, err = fmt.Fprintf(w, "%s (instrumented line %d): %s\n", d.File, d.InstrumentedLine, d.Message)
+		}
+		if err != nil {
+			return err
+		}
+		if d.File == "" {
+			continue
+		}
+		where := "in the project's own code"
+		if d.Construct != "" {
+			where = "in the injected " + d.Construct
+		}
+		if d.Function != "" {
+			where += " of " + d.Function
+		}
+		fmt.Fprintf(w, "    %s\n", where)
+		if d.Construct != "" && d.Source != "" {
+			fmt.Fprintf(w, "    instrumented line %d: %s\n", d.InstrumentedLine, d.Source)
+		}
+	}
+	return nil
+}
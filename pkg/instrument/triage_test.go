@@ -0,0 +1,102 @@
+package instrument_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/instrument"
+)
+
+// lineOf returns the 1-based line of the first line of path containing substr.
+func lineOf(t *testing.T, path, substr string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.Contains(line, substr) {
+			return i + 1
+		}
+	}
+	t.Fatalf("%q not found in %s", substr, path)
+	return 0
+}
+
+func TestDiagnoseBuild(t *testing.T) {
+	project, workspace := t.TempDir(), t.TempDir()
+	src := `package main
+
+type Server struct{}
+
+func (s *Server) handle(n int) (int, error) {
+	if n < 0 {
+		return 0, nil
+	}
+	total := n * 2
+	return total, nil
+}
+`
+	writeFiles(t, project, map[string]string{"server.go": src})
+	writeFiles(t, workspace, map[string]string{"server.go": src})
+	if err := instrument.SetDynamicTracerImport(workspace); err != nil {
+		t.Fatalf("SetDynamicTracerImport failed: %v", err)
+	}
+	if err := instrument.InstrumentWorkspace(workspace, config.Config{Instrumentation: config.InstrumentationConfig{Enable: true}}); err != nil {
+		t.Fatalf("InstrumentWorkspace failed: %v", err)
+	}
+
+	instrumented := filepath.Join(workspace, "server.go")
+	returnLine := lineOf(t, instrumented, "__tracewrap_span.RecordReturn")
+	ownLine := lineOf(t, instrumented, "total := n * 2")
+	importLine := lineOf(t, instrumented, `"github.com/mwiater/tracewrap/pkg/tracer"`)
+	out := fmt.Sprintf("# example.com/app\n./server.go:%d:4: __tracewrap_span.RecordReturn undefined (type *tracer.Span has no field or method RecordReturn)\n./server.go:%d:2: declared and not used: total\n./server.go:%d:2: \"github.com/mwiater/tracewrap/pkg/tracer\" imported and not used\nlink: missing symbol\n",
+		returnLine, ownLine, importLine)
+
+	diagnoses := instrument.DiagnoseBuild(workspace, project, []byte(out))
+	if len(diagnoses) != 4 {
+		t.Fatalf("expected 4 diagnoses, got %+v", diagnoses)
+	}
+	injected := diagnoses[0]
+	if injected.File != "server.go" || injected.Function != "Server.handle" || injected.FunctionLine != 5 || injected.Line != 0 ||
+		injected.Construct != "return value capture (__tracewrap_span.RecordReturn)" || injected.InstrumentedLine != returnLine {
+		t.Errorf("unexpected diagnosis of the injected return capture: %+v", injected)
+	}
+	own := diagnoses[1]
+	if own.Line != 9 || own.Construct != "" || own.Function != "Server.handle" {
+		t.Errorf("expected the project's own line 9, got %+v", own)
+	}
+	if diagnoses[2].Construct != `import "github.com/mwiater/tracewrap/pkg/tracer"` {
+		t.Errorf("expected the injected import to be blamed, got %+v", diagnoses[2])
+	}
+	if diagnoses[3].File != "" || diagnoses[3].Message != "link: missing symbol" {
+		t.Errorf("expected the unpositioned error to be kept, got %+v", diagnoses[3])
+	}
+
+	// A file the source map does not list, such as one left uninstrumented, keeps its lines.
+	writeFiles(t, workspace, map[string]string{"util.go": "package main\n\nfunc util() {\n\tx := 1\n}\n"})
+	plain := instrument.DiagnoseBuild(workspace, project, []byte("./util.go:4:2: declared and not used: x\n"))
+	if len(plain) != 1 || plain[0].Line != 4 || plain[0].Function != "util" || plain[0].FunctionLine != 3 {
+		t.Errorf("expected the uninstrumented file's own line 4, got %+v", plain)
+	}
+
+	var buf bytes.Buffer
+	if err := instrument.WriteDiagnoses(&buf, diagnoses); err != nil {
+		t.Fatalf("WriteDiagnoses failed: %v", err)
+	}
+	for _, want := range []string{
+		"server.go:5: __tracewrap_span.RecordReturn undefined (type *tracer.Span has no field or method RecordReturn)",
+		"in the injected return value capture (__tracewrap_span.RecordReturn) of Server.handle",
+		"server.go:9: declared and not used: total",
+		"in the project's own code of Server.handle",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package instrument
+
+import (
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// vcsState is the git state of the project an instrumented binary is built from, which the
+// workspace it is built in does not have: the copy holds no repository of a project in a
+// subdirectory, and differs from the commit at the root of one.
+type vcsState struct {
+	Revision string // The commit hash of HEAD.
+	Time     string // The commit time of HEAD, RFC 3339 in UTC as the go command stamps it.
+	Modified bool   // Tracked files have uncommitted changes.
+}
+
+// projectVCS reads the git state of projectDir with the git command line tool. Untracked files,
+// such as the run directories tracewrap writes into the project, do not count as changes.
+//
+// Parameters:
+//   - projectDir (string): the project directory.
+//
+// Returns:
+//   - vcsState: the state.
+//   - bool: false if projectDir is not in a git work tree with a commit, or git is not installed.
+func projectVCS(projectDir string) (vcsState, bool) {
+	git := func(args ...string) (string, bool) {
+		out, err := exec.Command("git", append([]string{"-C", projectDir}, args...)...).Output()
+		return strings.TrimSpace(string(out)), err == nil
+	}
+	head, ok := git("show", "-s", "--format=%H %cI", "HEAD")
+	if !ok {
+		return vcsState{}, false
+	}
+	revision, commitTime, _ := strings.Cut(head, " ")
+	if t, err := time.Parse(time.RFC3339, commitTime); err == nil {
+		commitTime = t.UTC().Format(time.RFC3339)
+	}
+	status, ok := git("status", "--porcelain", "--untracked-files=no")
+	if !ok {
+		return vcsState{}, false
+	}
+	return vcsState{Revision: revision, Time: commitTime, Modified: status != ""}, true
+}
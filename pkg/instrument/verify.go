@@ -0,0 +1,235 @@
+package instrument
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// errTypeCheck is the error of a BuildError whose output is that of verifyWorkspace.
+var errTypeCheck = errors.New("the instrumented workspace does not type-check")
+
+// listedPackage is the part of the "go list -json" output verifyWorkspace uses.
+type listedPackage struct {
+	ImportPath string
+	Dir        string
+	Export     string // The export data file, with -export.
+	GoFiles    []string
+	CgoFiles   []string
+	Imports    []string
+	ImportMap  map[string]string // Import paths in the source to those of Imports, e.g. for vendoring.
+	Error      *struct {
+		Pos string
+		Err string
+	}
+}
+
+// importerFunc is a types.Importer calling itself.
+type importerFunc func(path string) (*types.Package, error)
+
+// Import imports the package at path.
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return f(path)
+}
+
+// goList runs "go list -e -json" with buildFlags in workspace and returns the packages listed.
+//
+// Parameters:
+//   - workspace (string): the directory to run in.
+//   - buildFlags ([]string): build flags, e.g. "-race", so the files listed are those built.
+//   - args (...string): more flags and the packages, e.g. "./...".
+//
+// Returns:
+//   - []listedPackage: the packages, in the order go list writes them.
+//   - error: an error object if go list fails or writes invalid output.
+func goList(workspace string, buildFlags []string, args ...string) ([]listedPackage, error) {
+	listArgs := append(append([]string{"list", "-e", "-json"}, buildFlags...), args...)
+	cmdList := exec.Command("go", listArgs...)
+	cmdList.Dir = workspace
+	cmdList.Env = os.Environ()
+	listed, err := cmdList.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list failed: %v", err)
+	}
+	var pkgs []listedPackage
+	dec := json.NewDecoder(bytes.NewReader(listed))
+	for {
+		var pkg listedPackage
+		if err := dec.Decode(&pkg); err == io.EOF {
+			return pkgs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid go list output: %v", err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+}
+
+// verifyWorkspace loads and type-checks the packages of the instrumented workspace before it is
+// built, so a problem the instrumentation caused is reported as a type error in the file it is in
+// rather than as the output of a failed build. The packages are those "go build" builds with the
+// same build flags: their files are listed with them, and their dependencies are imported from the
+// export data the go command compiles with them. Packages that do not load, e.g. for a missing
+// import, are reported too. Code using cgo is checked without resolving the names of package C.
+//
+// Parameters:
+//   - workspace (string): the path to the workspace directory, after prepareModule.
+//   - buildFlags ([]string): the extra flags of "go build", e.g. "-race" or "-tags=integration".
+//
+// Returns:
+//   - []byte: the errors, in the format of "go build" output, one package header each.
+//   - error: errTypeCheck if there are errors, or an error object if the packages cannot be listed.
+func verifyWorkspace(workspace string, buildFlags []string) ([]byte, error) {
+	pkgs, err := goList(workspace, buildFlags, "./...")
+	if err != nil {
+		return nil, err
+	}
+	local := make(map[string]*listedPackage, len(pkgs))
+	for i := range pkgs {
+		local[pkgs[i].ImportPath] = &pkgs[i]
+	}
+	var deps []string
+	seen := make(map[string]bool)
+	for _, pkg := range pkgs {
+		for _, path := range pkg.Imports {
+			if local[path] == nil && path != "C" && path != "unsafe" && !seen[path] {
+				seen[path] = true
+				deps = append(deps, path)
+			}
+		}
+	}
+	exports := make(map[string]string)
+	if len(deps) > 0 {
+		listed, err := goList(workspace, buildFlags, append([]string{"-export", "-deps"}, deps...)...)
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range listed {
+			exports[dep.ImportPath] = dep.Export
+		}
+	}
+	fset := token.NewFileSet()
+	// A dependency that does not compile has no export data and is left to the build.
+	imp := importer.ForCompiler(fset, "gc", func(path string) (io.ReadCloser, error) {
+		if exports[path] == "" {
+			return nil, fmt.Errorf("no export data for %s", path)
+		}
+		return os.Open(exports[path])
+	})
+	checked := make(map[string]*types.Package)
+	errsOf := make(map[string][]string)
+	visited := make(map[string]bool)
+	var check func(pkg *listedPackage) *types.Package
+	check = func(pkg *listedPackage) *types.Package {
+		// Marked before the imports are checked, so an import cycle ends here.
+		if visited[pkg.ImportPath] {
+			return checked[pkg.ImportPath]
+		}
+		visited[pkg.ImportPath] = true
+		var errs []string
+		if pkg.Error != nil {
+			errs = append(errs, listError(pkg.Error.Pos, pkg.Error.Err))
+		}
+		var files []*ast.File
+		for _, name := range append(pkg.GoFiles, pkg.CgoFiles...) {
+			f, err := parser.ParseFile(fset, filepath.Join(pkg.Dir, name), nil, parser.SkipObjectResolution)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			files = append(files, f)
+		}
+		if len(files) == 0 || len(errs) > 0 {
+			errsOf[pkg.ImportPath] = errs
+			return nil
+		}
+		// Packages of the workspace are checked from source, and the others imported.
+		imports := importerFunc(func(path string) (*types.Package, error) {
+			if mapped, ok := pkg.ImportMap[path]; ok {
+				path = mapped
+			}
+			if dep := local[path]; dep != nil {
+				if checked := check(dep); checked != nil {
+					return checked, nil
+				}
+				return nil, fmt.Errorf("%s does not type-check", path)
+			}
+			return imp.Import(path)
+		})
+		conf := types.Config{Importer: imports, FakeImportC: true, Error: func(err error) {
+			terr, ok := err.(types.Error)
+			if !ok {
+				errs = append(errs, err.Error())
+				return
+			}
+			// A local package that does not check reports its own errors, and a dependency that
+			// cannot be checked from source is left to the build.
+			if strings.HasPrefix(terr.Msg, "could not import ") {
+				return
+			}
+			errs = append(errs, fmt.Sprintf("%s: %s", fset.Position(terr.Pos), terr.Msg))
+		}}
+		checkedPkg, _ := conf.Check(pkg.ImportPath, fset, files, nil)
+		if len(errs) > 0 {
+			errsOf[pkg.ImportPath] = errs
+			return nil
+		}
+		checked[pkg.ImportPath] = checkedPkg
+		return checkedPkg
+	}
+	var out bytes.Buffer
+	for i := range pkgs {
+		check(&pkgs[i])
+		if errs := errsOf[pkgs[i].ImportPath]; len(errs) > 0 {
+			fmt.Fprintf(&out, "# %s\n%s\n", pkgs[i].ImportPath, strings.Join(errs, "\n"))
+		}
+	}
+	if out.Len() > 0 {
+		return out.Bytes(), errTypeCheck
+	}
+	return nil, nil
+}
+
+// listError formats an error of "go list" like a compiler error, at its position if it has one.
+func listError(pos, msg string) string {
+	if pos == "" {
+		return msg
+	}
+	return pos + ": " + msg
+}
+
+// vetWorkspace runs "go vet" over the instrumented workspace and returns the findings in code
+// tracewrap injected; findings in the project's own code are its own business.
+//
+// Parameters:
+//   - workspace (string): the path to the workspace directory.
+//   - projectDir (string): the project the workspace was prepared from.
+//
+// Returns:
+//   - []Diagnosis: the findings in injected code.
+func vetWorkspace(workspace, projectDir string) []Diagnosis {
+	cmdVet := exec.Command("go", "vet", "./...")
+	cmdVet.Dir = workspace
+	cmdVet.Env = os.Environ()
+	out, err := cmdVet.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	var injected []Diagnosis
+	for _, d := range DiagnoseBuild(workspace, projectDir, out) {
+		if d.Construct != "" {
+			injected = append(injected, d)
+		}
+	}
+	return injected
+}
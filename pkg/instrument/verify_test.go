@@ -0,0 +1,59 @@
+package instrument_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/pkg/instrument"
+)
+
+func TestVerifyWorkspace(t *testing.T) {
+	workspace := t.TempDir()
+	writeFiles(t, workspace, map[string]string{
+		"go.mod":     "module example.com/app\n\ngo 1.21\n",
+		"main.go":    "package main\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/app/lib\"\n)\n\nfunc main() { fmt.Println(lib.Lib()) }\n",
+		"lib/lib.go": "package lib\n\nimport \"strings\"\n\nfunc Lib() string { return strings.ToUpper(\"ok\") }\n",
+	})
+	if out, err := instrument.VerifyWorkspace(workspace, nil); err != nil {
+		t.Fatalf("expected a valid workspace to verify, got %v: %s", err, out)
+	}
+
+	writeFiles(t, workspace, map[string]string{
+		"lib/lib.go": "package lib\n\nfunc Lib() string {\n\tn := 1\n\treturn undefinedHelper()\n}\n",
+	})
+	out, err := instrument.VerifyWorkspace(workspace, nil)
+	if err == nil {
+		t.Fatal("expected type errors")
+	}
+	got := string(out)
+	for _, want := range []string{"# example.com/app/lib\n", "lib.go:4:2: declared and not used: n", "lib.go:5:9: undefined: undefinedHelper"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in:\n%s", want, got)
+		}
+	}
+	// The importer of the broken package reports nothing of its own.
+	if strings.Contains(got, "# example.com/app\n") {
+		t.Errorf("expected no errors for package main, got:\n%s", got)
+	}
+	diagnoses := instrument.DiagnoseBuild(workspace, workspace, out)
+	if len(diagnoses) != 2 || diagnoses[0].File != "lib/lib.go" || diagnoses[0].Function != "Lib" {
+		t.Errorf("expected the errors to be diagnosed in lib/lib.go, got %+v", diagnoses)
+	}
+}
+
+func TestVerifyWorkspaceChecksTheFilesOfTheBuildFlags(t *testing.T) {
+	workspace := t.TempDir()
+	writeFiles(t, workspace, map[string]string{
+		"go.mod":         "module example.com/app\n\ngo 1.21\n",
+		"main.go":        "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println(mode()) }\n",
+		"mode.go":        "//go:build !integration\n\npackage main\n\nfunc mode() string { return \"unit\" }\n",
+		"integration.go": "//go:build integration\n\npackage main\n\nfunc mode() string { return undefinedMode }\n",
+	})
+	if out, err := instrument.VerifyWorkspace(workspace, nil); err != nil {
+		t.Fatalf("expected the default build to verify, got %v: %s", err, out)
+	}
+	out, err := instrument.VerifyWorkspace(workspace, []string{"-tags=integration"})
+	if err == nil || !strings.Contains(string(out), "integration.go:5:29: undefined: undefinedMode") {
+		t.Errorf("expected the type error of the file built with the tag, got %v: %s", err, out)
+	}
+}
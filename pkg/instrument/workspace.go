@@ -73,7 +73,7 @@ func copyFile(srcFile, dstFile string, info os.FileInfo) error {
 		return err
 	}
 	defer srcF.Close()
-	dstF, err := os.OpenFile(dstFile, os.O_CREATE|os.O_WRONLY, info.Mode())
+	dstF, err := os.OpenFile(dstFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,96 @@
+// Package notify posts notifications, such as fired alerts and captured panics, to webhooks. Slack
+// incoming webhooks (https://hooks.slack.com/...) receive a Slack message; any other URL receives
+// the Message as JSON:
+//
+//	{"title": "...", "text": "...", "fields": [{"name": "...", "value": "..."}]}
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds a notification sent without an explicit client.
+const DefaultTimeout = 10 * time.Second
+
+// Field is a named detail of a Message.
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Message is a notification.
+type Message struct {
+	Title  string  `json:"title"`
+	Text   string  `json:"text,omitempty"`
+	Fields []Field `json:"fields,omitempty"`
+}
+
+// IsSlack reports whether webhook is a Slack incoming webhook URL.
+//
+// Parameters:
+//   - webhook (string): the URL.
+//
+// Returns:
+//   - bool: true for hooks.slack.com URLs.
+func IsSlack(webhook string) bool {
+	u, err := url.Parse(webhook)
+	return err == nil && strings.EqualFold(u.Hostname(), "hooks.slack.com")
+}
+
+// slackPayload renders msg as a Slack message: the title in bold, the text, then the fields, with
+// multi-line values such as stack traces in code blocks.
+func slackPayload(msg Message) map[string]string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s*", msg.Title)
+	if msg.Text != "" {
+		fmt.Fprintf(&b, "\n%s", msg.Text)
+	}
+	for _, f := range msg.Fields {
+		if strings.Contains(f.Value, "\n") {
+			fmt.Fprintf(&b, "\n*%s:*\n```%s```", f.Name, strings.TrimRight(f.Value, "\n"))
+		} else {
+			fmt.Fprintf(&b, "\n*%s:* %s", f.Name, f.Value)
+		}
+	}
+	return map[string]string{"text": b.String()}
+}
+
+// Send posts msg to webhook.
+//
+// Parameters:
+//   - client (*http.Client): the client to send with, or nil for one with DefaultTimeout.
+//   - webhook (string): the webhook URL.
+//   - msg (Message): the notification.
+//
+// Returns:
+//   - error: an error if the request fails or the webhook does not answer with a 2xx status.
+func Send(client *http.Client, webhook string, msg Message) error {
+	var payload interface{} = msg
+	if IsSlack(webhook) {
+		payload = slackPayload(msg)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %v", err)
+	}
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook answered %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package notify_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/pkg/notify"
+)
+
+func TestSendPostsMessage(t *testing.T) {
+	var got notify.Message
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer ts.Close()
+
+	msg := notify.Message{Title: "panic in main.divide", Fields: []notify.Field{{Name: "Value", Value: "boom"}}}
+	if err := notify.Send(nil, ts.URL, msg); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+	if got.Title != msg.Title || len(got.Fields) != 1 || got.Fields[0].Value != "boom" {
+		t.Errorf("Unexpected message received: %+v", got)
+	}
+}
+
+func TestSendReportsRejectedNotifications(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer ts.Close()
+	if err := notify.Send(nil, ts.URL, notify.Message{Title: "x"}); err == nil || !strings.Contains(err.Error(), "invalid_token") {
+		t.Errorf("Expected the webhook's answer in the error, got %v", err)
+	}
+}
+
+// roundTripper answers requests with fn instead of sending them.
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (fn roundTripper) RoundTrip(r *http.Request) (*http.Response, error) { return fn(r) }
+
+func TestSendFormatsSlackMessages(t *testing.T) {
+	var payload map[string]string
+	client := &http.Client{Transport: roundTripper(func(r *http.Request) (*http.Response, error) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})}
+	msg := notify.Message{Title: "panic in main.divide", Text: "in run 20240501-100000-42", Fields: []notify.Field{
+		{Name: "Value", Value: "boom"},
+		{Name: "Stack", Value: "goroutine 1:\nmain.divide()\n"},
+	}}
+	if err := notify.Send(client, "https://hooks.slack.com/services/T0/B0/XXX", msg); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+	want := "*panic in main.divide*\nin run 20240501-100000-42\n*Value:* boom\n*Stack:*\n```goroutine 1:\nmain.divide()```"
+	if payload["text"] != want {
+		t.Errorf("Expected Slack text\n%s\ngot\n%s", want, payload["text"])
+	}
+}
+
+func TestIsSlack(t *testing.T) {
+	if !notify.IsSlack("https://hooks.slack.com/services/T0/B0/XXX") || notify.IsSlack("https://example.com/hooks.slack.com") {
+		t.Error("Expected only hooks.slack.com URLs to be Slack webhooks")
+	}
+}
@@ -0,0 +1,4 @@
+package otelbridge
+
+// WithBuildAttributes exposes withBuildAttributes to the tests.
+var WithBuildAttributes = withBuildAttributes
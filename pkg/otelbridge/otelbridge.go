@@ -0,0 +1,343 @@
+// Package otelbridge implements the OpenTelemetry tracing API on top of the tracewrap tracer, so
+// spans an application creates by hand with OpenTelemetry are recorded in the same trace as its
+// instrumented functions: a span started in an instrumented function is a call of it, and the
+// instrumented functions it calls are its children.
+//
+// Install the bridge as the global tracer provider, or wrap the provider of an OpenTelemetry SDK
+// to keep exporting spans while tracewrap records them too:
+//
+//	otel.SetTracerProvider(otelbridge.Wrap(sdkProvider))
+//
+// Instrumentation with instrumentation.bridgeOpenTelemetry does both in the instrumented copy.
+package otelbridge
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+)
+
+// TracerProvider records the spans of its tracers as tracewrap calls, and passes them on to the
+// provider it wraps, if any.
+type TracerProvider struct {
+	embedded.TracerProvider
+	next trace.TracerProvider
+}
+
+// NewTracerProvider returns a provider recording spans in tracewrap only.
+//
+// Returns:
+//   - *TracerProvider: the provider.
+func NewTracerProvider() *TracerProvider {
+	return &TracerProvider{}
+}
+
+// Wrap returns a provider recording spans in tracewrap and starting each of them on next too, so
+// an SDK keeps exporting them. The spans then carry next's trace and span IDs, which tracewrap
+// records as their external trace context. A provider that is already a bridge is returned as it
+// is.
+//
+// Parameters:
+//   - next (trace.TracerProvider): the application's provider, or nil.
+//
+// Returns:
+//   - trace.TracerProvider: the bridge.
+func Wrap(next trace.TracerProvider) trace.TracerProvider {
+	if tp, ok := next.(*TracerProvider); ok {
+		return tp
+	}
+	return &TracerProvider{next: next}
+}
+
+// resourceAttributesEnv is the variable the OpenTelemetry SDK reads resource attributes from, in
+// resource.Default and resource.WithFromEnv, as comma-separated key=value pairs.
+const resourceAttributesEnv = "OTEL_RESOURCE_ATTRIBUTES"
+
+// init adds BuildAttributes to resourceAttributesEnv with tracing.resourceBuildInfo, before the
+// application's init functions and main create its SDK provider.
+func init() {
+	if tracer.ResourceBuildInfo() {
+		os.Setenv(resourceAttributesEnv, withBuildAttributes(os.Getenv(resourceAttributesEnv)))
+	}
+}
+
+// withBuildAttributes returns the value env of resourceAttributesEnv with the BuildAttributes it
+// does not set appended, their values percent-encoded as the variable requires. Attributes env
+// sets keep their value.
+func withBuildAttributes(env string) string {
+	var fields []string
+	set := make(map[string]bool)
+	if strings.TrimSpace(env) != "" {
+		fields = append(fields, env)
+		for _, field := range strings.Split(env, ",") {
+			if key, _, ok := strings.Cut(field, "="); ok {
+				set[strings.TrimSpace(key)] = true
+			}
+		}
+	}
+	for _, kv := range BuildAttributes() {
+		if !set[string(kv.Key)] {
+			fields = append(fields, string(kv.Key)+"="+url.PathEscape(kv.Value.Emit()))
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// BuildAttributes returns the module path, version and VCS revision of the running binary as
+// OpenTelemetry attributes (see tracer.BuildInfo.Attributes), for the resource of an SDK provider
+// built without resource.Default or resource.WithFromEnv, which tracing.resourceBuildInfo covers:
+//
+//	res, _ := resource.Merge(resource.Default(), resource.NewSchemaless(otelbridge.BuildAttributes()...))
+//
+// Returns:
+//   - []attribute.KeyValue: the attributes, by key, or nil without build info.
+func BuildAttributes() []attribute.KeyValue {
+	attrs := tracer.CurrentBuild().Attributes()
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var kvs []attribute.KeyValue
+	for _, k := range keys {
+		if k == tracer.AttrModified {
+			kvs = append(kvs, attribute.Bool(k, attrs[k] == "true"))
+		} else {
+			kvs = append(kvs, attribute.String(k, attrs[k]))
+		}
+	}
+	return kvs
+}
+
+// Install makes a bridge the global tracer provider, for applications that never set one. It must
+// run before the application sets its own provider, which instrumentation wraps with Wrap.
+func Install() {
+	if _, ok := otel.GetTracerProvider().(*TracerProvider); !ok {
+		otel.SetTracerProvider(NewTracerProvider())
+	}
+}
+
+// Tracer returns a tracer of the provider.
+//
+// Parameters:
+//   - name (string): the instrumentation scope, passed on to the wrapped provider.
+//   - options (...trace.TracerOption): options passed on to the wrapped provider.
+//
+// Returns:
+//   - trace.Tracer: the tracer.
+func (p *TracerProvider) Tracer(name string, options ...trace.TracerOption) trace.Tracer {
+	t := &bridgeTracer{provider: p}
+	if p.next != nil {
+		t.next = p.next.Tracer(name, options...)
+	}
+	return t
+}
+
+// bridgeTracer starts bridged spans.
+type bridgeTracer struct {
+	embedded.Tracer
+	provider *TracerProvider
+	next     trace.Tracer
+}
+
+// Start opens a tracewrap call named spanName as a child of the bridged span in ctx, or of the
+// innermost open call. The start attributes become parameters of the call, and a remote parent in
+// ctx, such as one extracted from a traceparent header, its external trace context. With a wrapped
+// provider, the call takes the trace ID of its span and records the span's ID as ExportedSpanID.
+func (t *bridgeTracer) Start(ctx context.Context, spanName string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	var parent *tracer.Span
+	var parentNext trace.Span
+	if ps, ok := trace.SpanFromContext(ctx).(*bridgeSpan); ok {
+		parent, parentNext = ps.span, ps.next
+	}
+	s := &bridgeSpan{provider: t.provider, span: tracer.StartSpan(parent, spanName)}
+	cfg := trace.NewSpanStartConfig(opts...)
+	if t.next != nil {
+		// The wrapped tracer sees the same parent span it would without the bridge.
+		nextCtx := ctx
+		if parentNext != nil {
+			nextCtx = trace.ContextWithSpan(ctx, parentNext)
+		}
+		_, s.next = t.next.Start(nextCtx, spanName, opts...)
+		if sc := s.next.SpanContext(); sc.IsValid() {
+			// The parent span ID stays the remote caller's, or the one the call inherited.
+			_, parentID := s.span.TraceContext()
+			if rc := trace.SpanContextFromContext(ctx); rc.IsValid() && rc.IsRemote() && !cfg.NewRoot() {
+				parentID = rc.SpanID().String()
+			}
+			s.span.SetTraceContext(sc.TraceID().String(), parentID)
+			s.span.SetExportedSpanID(sc.SpanID().String())
+		}
+	} else if sc := trace.SpanContextFromContext(ctx); sc.IsValid() && sc.IsRemote() && !cfg.NewRoot() {
+		s.span.SetTraceContext(sc.TraceID().String(), sc.SpanID().String())
+	}
+	if kind := cfg.SpanKind(); kind != trace.SpanKindUnspecified && kind != trace.SpanKindInternal {
+		s.span.SetAttribute("span.kind", kind.String())
+	}
+	for _, kv := range cfg.Attributes() {
+		s.span.SetAttribute(string(kv.Key), kv.Value.AsInterface())
+	}
+	for _, link := range cfg.Links() {
+		s.addLink(link)
+	}
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+// bridgeSpan is an OpenTelemetry span recorded as a tracewrap call.
+type bridgeSpan struct {
+	embedded.Span
+	provider *TracerProvider
+	span     *tracer.Span
+	next     trace.Span // The span of the wrapped provider, or nil.
+}
+
+// spanIDs derives the OpenTelemetry IDs of a span without a wrapped SDK: the span ID is the
+// record's unique ID, and the trace ID joins a hash of the run ID with the unique ID of the
+// trace's root record, so IDs are distinct across runs. Spans with an external trace context
+// keep its trace ID.
+func (s *bridgeSpan) spanIDs() (trace.TraceID, trace.SpanID) {
+	id, root := s.span.ID()
+	spanID, _ := trace.SpanIDFromHex(tracer.SpanIDOf(id))
+	if external, _ := s.span.TraceContext(); external != "" {
+		if parsed, err := trace.TraceIDFromHex(external); err == nil {
+			return parsed, spanID
+		}
+	}
+	traceID, _ := trace.TraceIDFromHex(tracer.DerivedTraceID(tracer.RunID(), root))
+	return traceID, spanID
+}
+
+// SpanContext returns the wrapped provider's span context, or one derived from the record.
+func (s *bridgeSpan) SpanContext() trace.SpanContext {
+	if s.next != nil {
+		return s.next.SpanContext()
+	}
+	traceID, spanID := s.spanIDs()
+	var flags trace.TraceFlags
+	if s.span.Recording() {
+		flags = trace.FlagsSampled
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: flags})
+}
+
+// End completes the call. End timestamps are passed on but not used; the call ends now.
+func (s *bridgeSpan) End(options ...trace.SpanEndOption) {
+	s.span.End()
+	if s.next != nil {
+		s.next.End(options...)
+	}
+}
+
+// AddEvent adds a span event to the call.
+func (s *bridgeSpan) AddEvent(name string, options ...trace.EventOption) {
+	cfg := trace.NewEventConfig(options...)
+	s.span.Event(name, cfg.Timestamp(), attributeMap(cfg.Attributes()))
+	if s.next != nil {
+		s.next.AddEvent(name, options...)
+	}
+}
+
+// AddLink records the link as a "link" span event carrying the linked trace and span IDs.
+func (s *bridgeSpan) AddLink(link trace.Link) {
+	s.addLink(link)
+	if s.next != nil {
+		s.next.AddLink(link)
+	}
+}
+
+func (s *bridgeSpan) addLink(link trace.Link) {
+	attrs := attributeMap(link.Attributes)
+	if attrs == nil {
+		attrs = make(map[string]any, 2)
+	}
+	attrs["trace_id"] = link.SpanContext.TraceID().String()
+	attrs["span_id"] = link.SpanContext.SpanID().String()
+	s.span.Event("link", time.Time{}, attrs)
+}
+
+// IsRecording reports whether tracewrap records the span.
+func (s *bridgeSpan) IsRecording() bool {
+	return s.span.Recording() || s.next != nil && s.next.IsRecording()
+}
+
+// RecordError adds an "exception" span event following the OpenTelemetry semantic conventions.
+// As in OpenTelemetry, it does not change the span status.
+func (s *bridgeSpan) RecordError(err error, options ...trace.EventOption) {
+	if err == nil {
+		return
+	}
+	cfg := trace.NewEventConfig(options...)
+	attrs := attributeMap(cfg.Attributes())
+	if attrs == nil {
+		attrs = make(map[string]any, 2)
+	}
+	attrs["exception.type"] = fmt.Sprintf("%T", err)
+	attrs["exception.message"] = err.Error()
+	s.span.Event("exception", cfg.Timestamp(), attrs)
+	if s.next != nil {
+		s.next.RecordError(err, options...)
+	}
+}
+
+// SetStatus sets the status of the call: codes.Error and codes.Ok map to tracer.StatusError and
+// tracer.StatusOK. The description of an error is recorded as the status.description parameter.
+func (s *bridgeSpan) SetStatus(code codes.Code, description string) {
+	switch code {
+	case codes.Error:
+		s.span.SetStatus(tracer.StatusError)
+		if description != "" {
+			s.span.SetAttribute("status.description", description)
+		}
+	case codes.Ok:
+		s.span.SetStatus(tracer.StatusOK)
+	}
+	if s.next != nil {
+		s.next.SetStatus(code, description)
+	}
+}
+
+// SetName renames the call.
+func (s *bridgeSpan) SetName(name string) {
+	s.span.SetName(name)
+	if s.next != nil {
+		s.next.SetName(name)
+	}
+}
+
+// SetAttributes records the attributes as parameters of the call.
+func (s *bridgeSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, a := range kv {
+		s.span.SetAttribute(string(a.Key), a.Value.AsInterface())
+	}
+	if s.next != nil {
+		s.next.SetAttributes(kv...)
+	}
+}
+
+// TracerProvider returns the bridge the span was started by.
+func (s *bridgeSpan) TracerProvider() trace.TracerProvider {
+	return s.provider
+}
+
+// attributeMap converts OpenTelemetry attributes into span event attributes, or nil for none.
+func attributeMap(kv []attribute.KeyValue) map[string]any {
+	if len(kv) == 0 {
+		return nil
+	}
+	attrs := make(map[string]any, len(kv))
+	for _, a := range kv {
+		attrs[string(a.Key)] = a.Value.AsInterface()
+	}
+	return attrs
+}
@@ -0,0 +1,171 @@
+package otelbridge_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/mwiater/tracewrap/pkg/otelbridge"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TestMain runs the tests in a temporary directory, which the run directory the tracer creates on
+// its first flush stays in.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "otelbridge")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestBridgedSpansAreRecorded(t *testing.T) {
+	tp := otelbridge.NewTracerProvider()
+	if otelbridge.Wrap(tp) != trace.TracerProvider(tp) {
+		t.Error("Expected Wrap to return a bridge unchanged")
+	}
+	tr := tp.Tracer("test")
+	remote := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  trace.SpanID{0, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		Remote:  true,
+	})
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), remote)
+	ctx, root := tr.Start(ctx, "handle", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attribute.String("user", "alice")))
+	_, child := tr.Start(ctx, "query")
+	child.SetAttributes(attribute.Int("rows", 3))
+	child.AddEvent("cache.miss", trace.WithAttributes(attribute.String("key", "orders")))
+	child.RecordError(errors.New("timeout"))
+	child.SetStatus(codes.Error, "query failed")
+	if !child.IsRecording() || child.SpanContext().TraceID() != remote.TraceID() || child.SpanContext().SpanID() == root.SpanContext().SpanID() {
+		t.Errorf("Unexpected span contexts: root %v, child %v", root.SpanContext(), child.SpanContext())
+	}
+	// Spans may end in any order.
+	root.End()
+	child.End()
+	child.End()
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("Failed to read trace file: %v", err)
+	}
+	byName := make(map[string]tracer.TraceRecord)
+	for _, rec := range records {
+		byName[rec.FunctionName] = rec
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %+v", records)
+	}
+	h, q := byName["handle"], byName["query"]
+	if h.Params["user"] != "alice" || h.Params["span.kind"] != "server" || h.ExternalTraceID != remote.TraceID().String() {
+		t.Errorf("Unexpected root record: %+v", h)
+	}
+	if q.CallerID != h.UniqueID || q.Params["rows"] != "3" || q.Params["status.description"] != "query failed" || q.Status != tracer.StatusError {
+		t.Errorf("Unexpected child record: %+v", q)
+	}
+	if len(q.Events) != 2 || q.Events[0].Name != "cache.miss" || q.Events[1].Name != "exception" || q.Events[1].Attrs["exception.message"] != "timeout" {
+		t.Errorf("Unexpected events: %+v", q.Events)
+	}
+	if q.ExitTime.Before(h.ExitTime) {
+		t.Errorf("Expected the child to end after its parent")
+	}
+}
+
+// sdkProvider stands in for an OpenTelemetry SDK: its spans get sequential span IDs in the trace of
+// their parent, or a fixed one.
+type sdkProvider struct {
+	embedded.TracerProvider
+	next byte
+}
+
+func (p *sdkProvider) Tracer(string, ...trace.TracerOption) trace.Tracer { return sdkTracer{p: p} }
+
+type sdkTracer struct {
+	embedded.Tracer
+	p *sdkProvider
+}
+
+func (t sdkTracer) Start(ctx context.Context, _ string, _ ...trace.SpanStartOption) (context.Context, trace.Span) {
+	traceID := trace.SpanContextFromContext(ctx).TraceID()
+	if !traceID.IsValid() {
+		traceID = trace.TraceID{1}
+	}
+	t.p.next++
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: trace.SpanID{0xaa, t.p.next}})
+	s := sdkSpan{sc: sc}
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+type sdkSpan struct {
+	noop.Span
+	sc trace.SpanContext
+}
+
+func (s sdkSpan) SpanContext() trace.SpanContext { return s.sc }
+
+func TestWrappedSDKSpansKeepTheirParent(t *testing.T) {
+	tr := otelbridge.Wrap(&sdkProvider{}).Tracer("test")
+	remote := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  trace.SpanID{0, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		Remote:  true,
+	})
+	ctx, root := tr.Start(trace.ContextWithRemoteSpanContext(context.Background(), remote), "serve")
+	_, child := tr.Start(ctx, "lookup")
+	child.End()
+	root.End()
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("Failed to read trace file: %v", err)
+	}
+	byName := make(map[string]tracer.TraceRecord)
+	for _, rec := range records {
+		byName[rec.FunctionName] = rec
+	}
+	s, l := byName["serve"], byName["lookup"]
+	traceID, parentID := remote.TraceID().String(), remote.SpanID().String()
+	if s.ExternalTraceID != traceID || s.ExternalSpanID != parentID || s.ExportedSpanID != "aa01000000000000" {
+		t.Errorf("Unexpected root record: %+v", s)
+	}
+	if l.ExternalTraceID != traceID || l.ExternalSpanID != parentID || l.ExportedSpanID != "aa02000000000000" {
+		t.Errorf("Unexpected child record: %+v", l)
+	}
+}
+
+func TestBuildAttributes(t *testing.T) {
+	attrs := otelbridge.BuildAttributes()
+	if len(attrs) == 0 || attrs[0].Key != tracer.AttrModule || attrs[0].Value.AsString() != "github.com/mwiater/tracewrap" {
+		t.Errorf("Expected the module of the test binary first, got %v", attrs)
+	}
+}
+
+func TestWithBuildAttributesKeepsTheEnvironment(t *testing.T) {
+	if got, want := otelbridge.WithBuildAttributes(""), "go.module.path=github.com%2Fmwiater%2Ftracewrap"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	env := "service.name=api, go.module.path=example.com/app"
+	if got := otelbridge.WithBuildAttributes(env); got != env {
+		t.Errorf("Expected the attributes set to be kept, got %q", got)
+	}
+	if got, want := otelbridge.WithBuildAttributes("service.name=api"), "service.name=api,go.module.path=github.com%2Fmwiater%2Ftracewrap"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,440 @@
+// Package query finds the calls of a trace by the values of their parameters and return values.
+// An index of trace.jsonl maps every captured value to the records holding it and their byte
+// offsets in the file, so a search reads only the matching lines. Numeric parameters are also
+// indexed by their typed value, so they can be compared with <, <=, > and >=. The index is written
+// next to the trace, as trace.jsonl.index, and rebuilt whenever the trace changes.
+package query
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// IndexSuffix is appended to the path of a trace file to name its index.
+const IndexSuffix = ".index"
+
+// indexVersion is the version of the index format; indexes of other versions are rebuilt.
+const indexVersion = 2
+
+// maxIndexedValue is the longest value indexed, in bytes. Longer values, such as rendered
+// structs, are rarely searched for by their exact text and would bloat the index.
+const maxIndexedValue = 256
+
+// maxRecordBytes is the longest record read, as in traceio.
+const maxRecordBytes = 16 * 1024 * 1024
+
+// Index maps the parameter values, return values and function names of a trace to its records.
+// Records are numbered by their position in the trace file.
+type Index struct {
+	Version   int                         `json:"version"`
+	Size      int64                       `json:"size"`    // The size of the trace file when indexed.
+	ModTime   time.Time                   `json:"modTime"` // Its modification time.
+	Offsets   []int64                     `json:"offsets"` // The byte offset of each record.
+	Functions map[string][]int            `json:"functions"`
+	Params    map[string]map[string][]int `json:"params"` // Parameter name, then value.
+	Returns   map[string][]int            `json:"returns"`
+	Numbers   map[string][]NumberPosting  `json:"numbers"` // Numeric parameters, sorted by value.
+}
+
+// NumberPosting is a record holding a numeric value of a parameter, from its typed value (see
+// tracer.TraceRecord.TypedParams).
+type NumberPosting struct {
+	Value  float64 `json:"value"`
+	Record int     `json:"record"`
+}
+
+// Build indexes the trace file at tracePath.
+//
+// Parameters:
+//   - tracePath (string): the trace file, e.g. "tracewrap/latest/trace.jsonl".
+//
+// Returns:
+//   - *Index: the index.
+//   - error: an error if the file cannot be read or holds an invalid record.
+func Build(tracePath string) (*Index, error) {
+	file, err := os.Open(tracePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	idx := &Index{
+		Version:   indexVersion,
+		Size:      info.Size(),
+		ModTime:   info.ModTime(),
+		Functions: make(map[string][]int),
+		Params:    make(map[string]map[string][]int),
+		Returns:   make(map[string][]int),
+		Numbers:   make(map[string][]NumberPosting),
+	}
+	symbols := tracer.SymbolsFor(tracePath)
+	reader := bufio.NewReaderSize(file, 64*1024)
+	var offset int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read trace file: %v", err)
+		}
+		start := offset
+		offset += int64(len(data))
+		if len(strings.TrimSpace(string(data))) > 0 {
+			if len(data) > maxRecordBytes {
+				return nil, fmt.Errorf("%s:%d: record longer than %d bytes", tracePath, line, maxRecordBytes)
+			}
+			rec, decodeErr := tracer.DecodeRecord(data)
+			if decodeErr != nil {
+				return nil, fmt.Errorf("%s:%d: invalid trace record: %v", tracePath, line, decodeErr)
+			}
+			symbols.Resolve(&rec)
+			idx.add(rec, start)
+		}
+		if err == io.EOF {
+			for _, postings := range idx.Numbers {
+				sort.SliceStable(postings, func(i, j int) bool { return postings[i].Value < postings[j].Value })
+			}
+			return idx, nil
+		}
+	}
+}
+
+// add indexes rec, found at offset.
+func (idx *Index) add(rec tracer.TraceRecord, offset int64) {
+	n := len(idx.Offsets)
+	idx.Offsets = append(idx.Offsets, offset)
+	idx.Functions[rec.FunctionName] = append(idx.Functions[rec.FunctionName], n)
+	for name, value := range rec.Params {
+		if len(value) > maxIndexedValue {
+			continue
+		}
+		values, ok := idx.Params[name]
+		if !ok {
+			values = make(map[string][]int)
+			idx.Params[name] = values
+		}
+		values[value] = append(values[value], n)
+	}
+	for name, p := range rec.TypedParams {
+		if value, ok := p.Float64(); ok {
+			idx.Numbers[name] = append(idx.Numbers[name], NumberPosting{Value: value, Record: n})
+		}
+	}
+	seen := make(map[string]bool, len(rec.ReturnValues))
+	for _, value := range rec.ReturnValues {
+		if len(value) <= maxIndexedValue && !seen[value] {
+			seen[value] = true
+			idx.Returns[value] = append(idx.Returns[value], n)
+		}
+	}
+}
+
+// Load returns the index of the trace file at tracePath, reading it from the index file if that
+// is up to date and building and saving it otherwise. An index that cannot be saved, for example
+// in a read-only directory, is still returned.
+//
+// Parameters:
+//   - tracePath (string): the trace file.
+//   - rebuild (bool): build the index even if an up-to-date one exists.
+//
+// Returns:
+//   - *Index: the index.
+//   - error: an error if the trace cannot be indexed.
+func Load(tracePath string, rebuild bool) (*Index, error) {
+	info, err := os.Stat(tracePath)
+	if err != nil {
+		return nil, err
+	}
+	if !rebuild {
+		if data, err := os.ReadFile(tracePath + IndexSuffix); err == nil {
+			var idx Index
+			if json.Unmarshal(data, &idx) == nil && idx.Version == indexVersion && idx.Size == info.Size() && idx.ModTime.Equal(info.ModTime()) {
+				return &idx, nil
+			}
+		}
+	}
+	idx, err := Build(tracePath)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(idx); err == nil {
+		os.WriteFile(tracePath+IndexSuffix, data, 0644)
+	}
+	return idx, nil
+}
+
+// Condition matches a parameter or return value. With Op "=" or "", Value is compared exactly
+// unless it contains the glob metacharacters *, ? or [, in which case it is matched as a
+// path.Match pattern, so "user-*" finds every user ID. With Op "<", "<=", ">" or ">=", the typed
+// value of the parameter is compared with Number, and parameters without a numeric typed value do
+// not match.
+type Condition struct {
+	Param  string // The parameter name, or "" for a return value.
+	Op     string
+	Value  string
+	Number float64 // The value of an ordered comparison.
+}
+
+// isOrdered reports whether c compares a typed number rather than a rendered value.
+func (c Condition) isOrdered() bool {
+	return c.Op != "" && c.Op != "="
+}
+
+// ParseParam parses a --param argument of the form name=value, or name<value, name<=value,
+// name>value or name>=value with a number, split at the first of these operators.
+//
+// Parameters:
+//   - s (string): the argument, e.g. "jobID=7" or "jobID>100".
+//
+// Returns:
+//   - Condition: the condition on the parameter.
+//   - error: an error if s has no name or no operator, or compares a value that is not a number
+//     with an ordered operator.
+func ParseParam(s string) (Condition, error) {
+	i := strings.IndexAny(s, "<>=")
+	if i <= 0 {
+		return Condition{}, fmt.Errorf("invalid parameter condition %q; use name=value or name>number", s)
+	}
+	op := s[i : i+1]
+	if op != "=" && strings.HasPrefix(s[i+1:], "=") {
+		op += "="
+	}
+	c := Condition{Param: s[:i], Op: op, Value: s[i+len(op):]}
+	if c.isOrdered() {
+		number, err := strconv.ParseFloat(c.Value, 64)
+		if err != nil {
+			return Condition{}, fmt.Errorf("invalid parameter condition %q; %s compares with a number", s, op)
+		}
+		c.Number = number
+	}
+	return c, nil
+}
+
+// Query selects the records matching all of its conditions.
+type Query struct {
+	Function   string // The function name, or "" for any; a glob pattern as for Condition.
+	Conditions []Condition
+}
+
+// isPattern reports whether value is matched as a glob pattern.
+func isPattern(value string) bool {
+	return strings.ContainsAny(value, "*?[")
+}
+
+// lookup returns the records under the keys of postings matching value, sorted.
+func lookup(postings map[string][]int, value string) ([]int, error) {
+	if !isPattern(value) {
+		return postings[value], nil
+	}
+	var out []int
+	for key, ids := range postings {
+		ok, err := path.Match(value, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", value, err)
+		}
+		if ok {
+			out = append(out, ids...)
+		}
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+// compare returns the records of postings, sorted by value, whose value compares with number as op
+// says, sorted by record.
+func compare(postings []NumberPosting, op string, number float64) []int {
+	from, to := 0, len(postings)
+	above := func(strict bool) int {
+		return sort.Search(len(postings), func(i int) bool {
+			return postings[i].Value > number || !strict && postings[i].Value == number
+		})
+	}
+	switch op {
+	case "<":
+		to = above(false)
+	case "<=":
+		to = above(true)
+	case ">":
+		from = above(true)
+	case ">=":
+		from = above(false)
+	}
+	out := make([]int, 0, to-from)
+	for _, posting := range postings[from:to] {
+		out = append(out, posting.Record)
+	}
+	sort.Ints(out)
+	return out
+}
+
+// intersect returns the records in both sorted lists.
+func intersect(a, b []int) []int {
+	var out []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i, j = i+1, j+1
+		}
+	}
+	return out
+}
+
+// Match returns the numbers of the records matching q, in trace order.
+//
+// Parameters:
+//   - q (Query): the query; an empty query matches every record.
+//
+// Returns:
+//   - []int: the record numbers.
+//   - error: an error for an invalid pattern.
+func (idx *Index) Match(q Query) ([]int, error) {
+	var matches []int
+	first := true
+	narrow := func(ids []int) {
+		if first {
+			matches, first = ids, false
+			return
+		}
+		matches = intersect(matches, ids)
+	}
+	if q.Function != "" {
+		ids, err := lookup(idx.Functions, q.Function)
+		if err != nil {
+			return nil, err
+		}
+		narrow(ids)
+	}
+	for _, c := range q.Conditions {
+		if c.isOrdered() {
+			narrow(compare(idx.Numbers[c.Param], c.Op, c.Number))
+			continue
+		}
+		postings := idx.Returns
+		if c.Param != "" {
+			postings = idx.Params[c.Param]
+		}
+		ids, err := lookup(postings, c.Value)
+		if err != nil {
+			return nil, err
+		}
+		narrow(ids)
+	}
+	if first {
+		matches = make([]int, len(idx.Offsets))
+		for i := range matches {
+			matches[i] = i
+		}
+	}
+	return matches, nil
+}
+
+// Search returns the records of the trace file at tracePath matching q, reading only their lines.
+//
+// Parameters:
+//   - tracePath (string): the trace file idx was built from.
+//   - idx (*Index): its index, from Load.
+//   - q (Query): the query.
+//   - limit (int): the maximum number of records returned, or 0 for all.
+//
+// Returns:
+//   - []tracer.TraceRecord: the matching records, in trace order.
+//   - int: the number of matching records, including those beyond limit.
+//   - error: an error for an invalid pattern or if a record cannot be read.
+func Search(tracePath string, idx *Index, q Query, limit int) ([]tracer.TraceRecord, int, error) {
+	matches, err := idx.Match(q)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := len(matches)
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	if len(matches) == 0 {
+		return nil, total, nil
+	}
+	file, err := os.Open(tracePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	symbols := tracer.SymbolsFor(tracePath)
+	records := make([]tracer.TraceRecord, 0, len(matches))
+	for _, n := range matches {
+		if _, err := file.Seek(idx.Offsets[n], io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		reader := bufio.NewReader(io.LimitReader(file, maxRecordBytes))
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, 0, fmt.Errorf("failed to read trace file: %v", err)
+		}
+		rec, err := tracer.DecodeRecord(data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: invalid trace record at offset %d: %v (the index may be stale)", tracePath, idx.Offsets[n], err)
+		}
+		symbols.Resolve(&rec)
+		records = append(records, rec)
+	}
+	return records, total, nil
+}
+
+// WriteResults prints records found by Search, each with its parameters and return values.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//   - records ([]tracer.TraceRecord): the records.
+//
+// Returns:
+//   - error: an error if writing fails.
+func WriteResults(w io.Writer, records []tracer.TraceRecord) error {
+	var sb strings.Builder
+	for _, rec := range records {
+		fmt.Fprintf(&sb, "ID %d  %s  %s  %v", rec.UniqueID, rec.FunctionName, rec.EntryTime.Format("2006-01-02 15:04:05.000"), rec.Duration)
+		if rec.CallerID != 0 {
+			fmt.Fprintf(&sb, "  caller %d", rec.CallerID)
+		}
+		if rec.GoroutineID != 0 {
+			fmt.Fprintf(&sb, "  goroutine %d", rec.GoroutineID)
+		}
+		sb.WriteString("\n")
+		if len(rec.Params) > 0 {
+			names := make([]string, 0, len(rec.Params))
+			for name := range rec.Params {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			sb.WriteString("  params: ")
+			for i, name := range names {
+				if i > 0 {
+					sb.WriteString(", ")
+				}
+				fmt.Fprintf(&sb, "%s=%s", name, rec.Params[name])
+			}
+			sb.WriteString("\n")
+		}
+		if len(rec.ReturnValues) > 0 {
+			fmt.Fprintf(&sb, "  returns: %s\n", strings.Join(rec.ReturnValues, ", "))
+		}
+		if rec.PanicValue != nil {
+			fmt.Fprintf(&sb, "  panic: %v\n", rec.PanicValue)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
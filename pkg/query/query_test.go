@@ -0,0 +1,132 @@
+package query_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mwiater/tracewrap/pkg/query"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// writeTrace writes records as a trace file in dir and returns its path.
+func writeTrace(t *testing.T, dir string, records []tracer.TraceRecord) string {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, rec := range records {
+		rec.SchemaVersion = tracer.SchemaVersion
+		data, err := json.Marshal(rec)
+		if err != nil {
+			t.Fatalf("Failed to marshal record: %v", err)
+		}
+		buf.Write(append(data, '\n'))
+	}
+	tracePath := filepath.Join(dir, "trace.jsonl")
+	if err := os.WriteFile(tracePath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write trace file: %v", err)
+	}
+	return tracePath
+}
+
+// jobID returns the typed parameters of a call with the given jobID.
+func jobID(id int64) map[string]tracer.Primitive {
+	return map[string]tracer.Primitive{"jobID": {Kind: tracer.KindInt, Value: id}}
+}
+
+func TestSearch(t *testing.T) {
+	dir, err := os.MkdirTemp("", "query")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	tracePath := writeTrace(t, dir, []tracer.TraceRecord{
+		{UniqueID: 1, FunctionName: "processJob", Params: map[string]string{"jobID": "6", "user": "alice-1"}, TypedParams: jobID(6)},
+		{UniqueID: 2, FunctionName: "processJob", Params: map[string]string{"jobID": "7", "user": "bob"}, TypedParams: jobID(7), ReturnValues: []string{"timeout"}},
+		{UniqueID: 3, FunctionName: "notify", Params: map[string]string{"jobID": "7", "user": "alice-2"}, TypedParams: jobID(7)},
+		{UniqueID: 4, FunctionName: "processJob", Params: map[string]string{"jobID": "8", "user": "alice-3"}, TypedParams: jobID(8), ReturnValues: []string{"ok"}},
+		{UniqueID: 5, FunctionName: "processJob", Params: map[string]string{"jobID": "100"}},
+	})
+
+	idx, err := query.Load(tracePath, false)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if _, err := os.Stat(tracePath + query.IndexSuffix); err != nil {
+		t.Fatalf("Expected the index to be saved: %v", err)
+	}
+	ids := func(q query.Query, limit int) ([]int64, int) {
+		t.Helper()
+		records, total, err := query.Search(tracePath, idx, q, limit)
+		if err != nil {
+			t.Fatalf("Search(%+v) returned error: %v", q, err)
+		}
+		var out []int64
+		for _, rec := range records {
+			out = append(out, rec.UniqueID)
+		}
+		return out, total
+	}
+	jobID7 := query.Condition{Param: "jobID", Value: "7"}
+	tests := []struct {
+		q    query.Query
+		want string
+	}{
+		{query.Query{Conditions: []query.Condition{jobID7}}, "[2 3]"},
+		{query.Query{Function: "processJob", Conditions: []query.Condition{jobID7}}, "[2]"},
+		{query.Query{Conditions: []query.Condition{{Param: "user", Value: "alice-*"}}}, "[1 3 4]"},
+		{query.Query{Conditions: []query.Condition{{Value: "time*"}}}, "[2]"},
+		{query.Query{Conditions: []query.Condition{{Param: "missing", Value: "7"}}}, "[]"},
+		// Ordered comparisons use the typed values; call 5 has none.
+		{query.Query{Conditions: []query.Condition{{Param: "jobID", Op: ">", Number: 6}}}, "[2 3 4]"},
+		{query.Query{Conditions: []query.Condition{{Param: "jobID", Op: ">=", Number: 8}}}, "[4]"},
+		{query.Query{Conditions: []query.Condition{{Param: "jobID", Op: "<", Number: 7}}}, "[1]"},
+		{query.Query{Function: "processJob", Conditions: []query.Condition{{Param: "jobID", Op: "<=", Number: 7}}}, "[1 2]"},
+		{query.Query{Conditions: []query.Condition{{Param: "jobID", Op: ">", Number: 50}}}, "[]"},
+	}
+	for _, tt := range tests {
+		got, total := ids(tt.q, 0)
+		if fmt.Sprint(got) != tt.want || total != len(got) {
+			t.Errorf("Search(%+v) = %v (total %d), want %s", tt.q, got, total, tt.want)
+		}
+	}
+	if got, total := ids(query.Query{Function: "processJob"}, 2); len(got) != 2 || total != 4 {
+		t.Errorf("Expected 2 of 4 calls with a limit, got %v of %d", got, total)
+	}
+	if _, _, err := query.Search(tracePath, idx, query.Query{Function: "[bad"}, 0); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+
+	// A changed trace is indexed again.
+	tracePath = writeTrace(t, dir, []tracer.TraceRecord{
+		{UniqueID: 9, FunctionName: "processJob", Params: map[string]string{"jobID": "7"}},
+	})
+	if idx, err = query.Load(tracePath, false); err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if got, _ := ids(query.Query{Conditions: []query.Condition{jobID7}}, 0); len(got) != 1 || got[0] != 9 {
+		t.Errorf("Expected the rebuilt index to find call 9, got %v", got)
+	}
+}
+
+func TestParseParam(t *testing.T) {
+	c, err := query.ParseParam("url=http://x/?a=b")
+	if err != nil || c.Param != "url" || c.Value != "http://x/?a=b" {
+		t.Errorf("Unexpected condition %+v (%v)", c, err)
+	}
+	c, err = query.ParseParam("jobID>=7.5")
+	if err != nil || c.Param != "jobID" || c.Op != ">=" || c.Number != 7.5 {
+		t.Errorf("Unexpected condition %+v (%v)", c, err)
+	}
+	c, err = query.ParseParam("jobID<-1")
+	if err != nil || c.Op != "<" || c.Number != -1 {
+		t.Errorf("Unexpected condition %+v (%v)", c, err)
+	}
+	for _, s := range []string{"jobID", "=7", ">7", "jobID>seven", "jobID<="} {
+		if _, err := query.ParseParam(s); err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+}
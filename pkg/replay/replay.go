@@ -0,0 +1,214 @@
+// Package replay plays back recorded trace records as a timeline of function entries and exits.
+package replay
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// Kind distinguishes function entries, exits and span event markers.
+type Kind int
+
+const (
+	Enter Kind = iota
+	Exit
+	Mark // A span event added with tracer.Event.
+)
+
+// Event is one entry, exit or span event marker in the replayed timeline.
+type Event struct {
+	Kind   Kind
+	Time   time.Time
+	Depth  int // Nesting depth, derived from caller IDs present in the trace.
+	Record *tracer.TraceRecord
+	Span   *tracer.SpanEvent // The span event of a Mark.
+}
+
+// Events turns records into entry, exit and span event markers in timestamp order. Among events
+// with the same timestamp, a call enters before its markers and its markers come before its exit;
+// exits come before markers and entries of other calls, entries are ordered by unique ID and exits
+// by reverse unique ID.
+//
+// Parameters:
+//   - records ([]tracer.TraceRecord): the records to replay.
+//
+// Returns:
+//   - []Event: the events in replay order.
+func Events(records []tracer.TraceRecord) []Event {
+	byID := make(map[int64]*tracer.TraceRecord, len(records))
+	for i := range records {
+		byID[records[i].UniqueID] = &records[i]
+	}
+	depths := make(map[int64]int, len(records))
+	var depth func(rec *tracer.TraceRecord) int
+	depth = func(rec *tracer.TraceRecord) int {
+		if d, ok := depths[rec.UniqueID]; ok {
+			return d
+		}
+		d := 0
+		// Guard against malformed traces where a record names itself as caller.
+		if parent, ok := byID[rec.CallerID]; ok && parent != rec {
+			d = depth(parent) + 1
+		}
+		depths[rec.UniqueID] = d
+		return d
+	}
+
+	events := make([]Event, 0, 2*len(records))
+	for i := range records {
+		rec := &records[i]
+		d := depth(rec)
+		events = append(events, Event{Kind: Enter, Time: rec.EntryTime, Depth: d, Record: rec})
+		events = append(events, Event{Kind: Exit, Time: rec.ExitTime, Depth: d, Record: rec})
+		for j := range rec.Events {
+			events = append(events, Event{Kind: Mark, Time: rec.Events[j].Time, Depth: d + 1, Record: rec, Span: &rec.Events[j]})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		if a.Record == b.Record {
+			return a.Kind == Enter || (a.Kind == Mark && b.Kind == Exit)
+		}
+		if a.Kind != b.Kind {
+			// At the same instant, finishing calls exit before new calls enter.
+			return sameInstantRank[a.Kind] < sameInstantRank[b.Kind]
+		}
+		if a.Kind != Exit {
+			return a.Record.UniqueID < b.Record.UniqueID
+		}
+		return a.Record.UniqueID > b.Record.UniqueID
+	})
+	return events
+}
+
+// sameInstantRank orders events of different calls that share a timestamp.
+var sameInstantRank = map[Kind]int{Exit: 0, Mark: 1, Enter: 2}
+
+// Options control playback.
+type Options struct {
+	// Speed is the playback speed factor: 1 replays in real time, 10 ten times faster.
+	// Zero or less replays without pauses.
+	Speed float64
+	// MaxGap caps the pause between two events, so idle periods do not stall the replay.
+	// Zero means no cap.
+	MaxGap time.Duration
+	// Sleep is used to pause between events; it defaults to time.Sleep.
+	Sleep func(time.Duration)
+}
+
+// Play writes the events to w, pausing between them according to the recorded timestamps and
+// opts.
+//
+// Parameters:
+//   - w (io.Writer): where the timeline is written.
+//   - events ([]Event): the events, as returned by Events.
+//   - opts (Options): the playback options.
+//
+// Returns:
+//   - error: an error if writing fails.
+func Play(w io.Writer, events []Event, opts Options) error {
+	if len(events) == 0 {
+		return nil
+	}
+	sleep := opts.Sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	start := events[0].Time
+	prev := start
+	for _, ev := range events {
+		if opts.Speed > 0 {
+			gap := time.Duration(float64(ev.Time.Sub(prev)) / opts.Speed)
+			if opts.MaxGap > 0 && gap > opts.MaxGap {
+				gap = opts.MaxGap
+			}
+			if gap > 0 {
+				sleep(gap)
+			}
+		}
+		prev = ev.Time
+		if _, err := fmt.Fprintln(w, formatEvent(ev, ev.Time.Sub(start))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatEvent renders one event as a timeline line.
+func formatEvent(ev Event, offset time.Duration) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[+%12s] %s", offset.Round(time.Microsecond), strings.Repeat("  ", ev.Depth))
+	rec := ev.Record
+	if ev.Kind == Mark {
+		fmt.Fprintf(&sb, "* %s", ev.Span.Name)
+		if len(ev.Span.Attrs) > 0 {
+			keys := make([]string, 0, len(ev.Span.Attrs))
+			for k := range ev.Span.Attrs {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			attrs := make([]string, 0, len(keys))
+			for _, k := range keys {
+				attrs = append(attrs, fmt.Sprintf("%s=%v", k, ev.Span.Attrs[k]))
+			}
+			fmt.Fprintf(&sb, " (%s)", strings.Join(attrs, ", "))
+		}
+		return sb.String()
+	}
+	if ev.Kind == Enter {
+		fmt.Fprintf(&sb, "-> %s #%d", rec.FunctionName, rec.UniqueID)
+		if rec.GoroutineID != 0 {
+			fmt.Fprintf(&sb, " [g%d]", rec.GoroutineID)
+		}
+		if len(rec.Params) > 0 {
+			keys := make([]string, 0, len(rec.Params))
+			for k := range rec.Params {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			params := make([]string, 0, len(keys))
+			for _, k := range keys {
+				params = append(params, k+"="+rec.Params[k])
+			}
+			fmt.Fprintf(&sb, " (%s)", strings.Join(params, ", "))
+		}
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "<- %s #%d %v", rec.FunctionName, rec.UniqueID, rec.Duration)
+	if len(rec.ReturnValues) > 0 {
+		fmt.Fprintf(&sb, " returned [%s]", strings.Join(rec.ReturnValues, ", "))
+	}
+	if rec.PanicValue != nil {
+		fmt.Fprintf(&sb, " panicked: %v", rec.PanicValue)
+	}
+	return sb.String()
+}
+
+// ParseSpeed parses a playback speed such as "10x", "0.5x" or "2". "max" replays without pauses.
+//
+// Parameters:
+//   - s (string): the speed to parse.
+//
+// Returns:
+//   - float64: the speed factor, or 0 for "max".
+//   - error: an error if s is not a positive number.
+func ParseSpeed(s string) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "max" {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("invalid speed %q: use a positive factor such as 10x, or max", s)
+	}
+	return speed, nil
+}
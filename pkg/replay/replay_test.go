@@ -0,0 +1,94 @@
+package replay_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/replay"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func sampleRecords() []tracer.TraceRecord {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Records are stored in exit order, as the tracer writes them.
+	return []tracer.TraceRecord{
+		{UniqueID: 2, CallerID: 1, FunctionName: "child", EntryTime: t0.Add(10 * time.Millisecond), ExitTime: t0.Add(30 * time.Millisecond), Duration: 20 * time.Millisecond, Params: map[string]string{"n": "3"}},
+		{UniqueID: 1, FunctionName: "parent", EntryTime: t0, ExitTime: t0.Add(40 * time.Millisecond), Duration: 40 * time.Millisecond},
+	}
+}
+
+func TestEventsAreInTimestampOrder(t *testing.T) {
+	events := replay.Events(sampleRecords())
+	var got []string
+	for _, ev := range events {
+		kind := "enter"
+		if ev.Kind == replay.Exit {
+			kind = "exit"
+		}
+		got = append(got, kind+" "+ev.Record.FunctionName)
+	}
+	want := "enter parent,enter child,exit child,exit parent"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Expected %s, got %s", want, strings.Join(got, ","))
+	}
+	if events[1].Depth != 1 {
+		t.Errorf("Expected child at depth 1, got %d", events[1].Depth)
+	}
+}
+
+func TestPlayScalesPauses(t *testing.T) {
+	var pauses []time.Duration
+	var out bytes.Buffer
+	opts := replay.Options{Speed: 10, MaxGap: 1500 * time.Microsecond, Sleep: func(d time.Duration) { pauses = append(pauses, d) }}
+	if err := replay.Play(&out, replay.Events(sampleRecords()), opts); err != nil {
+		t.Fatalf("Play returned error: %v", err)
+	}
+	// Gaps of 10ms, 20ms and 10ms at 10x become 1ms, 2ms (capped to 1.5ms) and 1ms.
+	want := []time.Duration{time.Millisecond, 1500 * time.Microsecond, time.Millisecond}
+	if len(pauses) != len(want) {
+		t.Fatalf("Expected pauses %v, got %v", want, pauses)
+	}
+	for i := range want {
+		if pauses[i] != want[i] {
+			t.Errorf("Expected pauses %v, got %v", want, pauses)
+			break
+		}
+	}
+	if !strings.Contains(out.String(), "-> child #2 (n=3)") {
+		t.Errorf("Unexpected timeline:\n%s", out.String())
+	}
+}
+
+func TestParseSpeed(t *testing.T) {
+	for input, want := range map[string]float64{"10x": 10, "0.5x": 0.5, "2": 2, "max": 0} {
+		got, err := replay.ParseSpeed(input)
+		if err != nil || got != want {
+			t.Errorf("ParseSpeed(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	if _, err := replay.ParseSpeed("fast"); err == nil {
+		t.Error("Expected an error for an invalid speed")
+	}
+}
+
+func TestSpanEventsAreReplayedAsMarkers(t *testing.T) {
+	records := sampleRecords()
+	records[0].Events = []tracer.SpanEvent{{Name: "cache.miss", Time: records[0].EntryTime.Add(5 * time.Millisecond), Attrs: map[string]interface{}{"key": "user:1"}}}
+	events := replay.Events(records)
+	if len(events) != 5 {
+		t.Fatalf("Expected 5 events, got %d", len(events))
+	}
+	mark := events[2]
+	if mark.Kind != replay.Mark || mark.Span.Name != "cache.miss" || mark.Depth != 2 {
+		t.Fatalf("Expected the marker between child entry and exit at depth 2, got %+v", mark)
+	}
+	var out bytes.Buffer
+	if err := replay.Play(&out, events, replay.Options{}); err != nil {
+		t.Fatalf("Play returned error: %v", err)
+	}
+	if !strings.Contains(out.String(), "* cache.miss (key=user:1)") {
+		t.Errorf("Expected the marker in the timeline:\n%s", out.String())
+	}
+}
@@ -0,0 +1,146 @@
+// Package runs manages the artifact directories written by instrumented binaries, one per run:
+//
+//	tracewrap/<run id>/   the run's tracewrap.log, trace.jsonl, run.json and callgraph.dot
+//	tracewrap/latest      a symbolic link to the most recent run's directory
+package runs
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// Run describes one run's artifact directory.
+type Run struct {
+	ID        string              `json:"id"`
+	Dir       string              `json:"dir"`
+	StartedAt time.Time           `json:"startedAt"`          // From run.json, or the directory's modification time without it.
+	UpdatedAt time.Time           `json:"updatedAt"`          // When a file of the run was last written.
+	Metadata  *tracer.RunMetadata `json:"metadata,omitempty"` // Nil when the run has not written run.json, e.g. while it is running.
+	Latest    bool                `json:"latest"`             // True for the run the latest link points at.
+}
+
+// List returns the runs in root, oldest first.
+//
+// Parameters:
+//   - root (string): the artifact root, usually tracer.ArtifactRoot.
+//
+// Returns:
+//   - []Run: the runs.
+//   - error: an error if root cannot be read.
+func List(root string) ([]Run, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run directory: %v", err)
+	}
+	latest, _ := os.Readlink(filepath.Join(root, tracer.LatestRun))
+	var runs []Run
+	for _, e := range entries {
+		// ReadDir does not follow symbolic links, so this also skips the latest link.
+		if !e.IsDir() {
+			continue
+		}
+		run := Run{ID: e.Name(), Dir: filepath.Join(root, e.Name()), Latest: e.Name() == filepath.Base(latest)}
+		if data, err := os.ReadFile(filepath.Join(run.Dir, "run.json")); err == nil {
+			if m, err := tracer.DecodeRunMetadata(data); err == nil {
+				run.Metadata = &m
+				run.StartedAt = m.StartedAt
+			}
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read run directory: %v", err)
+		}
+		if run.StartedAt.IsZero() {
+			run.StartedAt = info.ModTime()
+		}
+		run.UpdatedAt = lastWrite(run.Dir, info.ModTime())
+		runs = append(runs, run)
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		if !runs[i].StartedAt.Equal(runs[j].StartedAt) {
+			return runs[i].StartedAt.Before(runs[j].StartedAt)
+		}
+		return runs[i].ID < runs[j].ID
+	})
+	return runs, nil
+}
+
+// DirSize returns the total size of the files in dir and its subdirectories, e.g. a run directory.
+//
+// Parameters:
+//   - dir (string): the directory.
+//
+// Returns:
+//   - int64: the size in bytes; files that cannot be read are not counted.
+func DirSize(dir string) int64 {
+	var n int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			n += info.Size()
+		}
+		return nil
+	})
+	return n
+}
+
+// lastWrite returns the latest modification time of the files in dir, or dirTime if it has none.
+func lastWrite(dir string, dirTime time.Time) time.Time {
+	latest := dirTime
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// PruneOptions select the runs removed by Prune.
+type PruneOptions struct {
+	Keep      int           // Number of most recent runs always kept.
+	OlderThan time.Duration // When positive, only runs started longer ago than this are removed.
+	DryRun    bool          // Report the runs that would be removed without removing them.
+}
+
+// Prune removes the artifact directories of old runs. The run the latest link points at is never
+// removed.
+//
+// Parameters:
+//   - root (string): the artifact root, usually tracer.ArtifactRoot.
+//   - opts (PruneOptions): which runs to remove.
+//   - now (time.Time): the current time, for opts.OlderThan.
+//
+// Returns:
+//   - []Run: the removed runs (or, with opts.DryRun, those that would be), oldest first.
+//   - error: an error if root cannot be read or a directory cannot be removed.
+func Prune(root string, opts PruneOptions, now time.Time) ([]Run, error) {
+	runs, err := List(root)
+	if err != nil {
+		return nil, err
+	}
+	var removed []Run
+	for i, run := range runs {
+		if len(runs)-i <= opts.Keep || run.Latest {
+			continue
+		}
+		if opts.OlderThan > 0 && now.Sub(run.StartedAt) <= opts.OlderThan {
+			continue
+		}
+		if !opts.DryRun {
+			if err := os.RemoveAll(run.Dir); err != nil {
+				return removed, fmt.Errorf("failed to remove run %s: %v", run.ID, err)
+			}
+		}
+		removed = append(removed, run)
+	}
+	return removed, nil
+}
@@ -0,0 +1,73 @@
+package runs_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/runs"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// makeRuns creates one run directory per start time in a new artifact root, pointing the latest
+// link at the last one, and returns the root.
+func makeRuns(t *testing.T, starts ...time.Time) string {
+	t.Helper()
+	root, err := os.MkdirTemp("", "runstest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	var id string
+	for i, start := range starts {
+		id = tracer.NewRunID(start, 100+i)
+		dir := filepath.Join(root, id)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create run directory: %v", err)
+		}
+		meta, _ := json.Marshal(tracer.RunMetadata{RunID: id, StartedAt: start, Records: i})
+		if err := os.WriteFile(filepath.Join(dir, "run.json"), meta, 0644); err != nil {
+			t.Fatalf("Failed to write run metadata: %v", err)
+		}
+	}
+	if err := tracer.UpdateLatestLink(root, id); err != nil {
+		t.Fatalf("UpdateLatestLink returned error: %v", err)
+	}
+	return root
+}
+
+func TestListAndPrune(t *testing.T) {
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	root := makeRuns(t, now.Add(-5*day), now.Add(-4*day), now.Add(-3*day), now.Add(-time.Hour))
+
+	list, err := runs.List(root)
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(list) != 4 || list[0].Metadata.Records != 0 || !list[3].Latest || list[2].Latest {
+		t.Fatalf("Expected four runs oldest first with the last one latest, got %+v", list)
+	}
+
+	removed, err := runs.Prune(root, runs.PruneOptions{Keep: 1, OlderThan: 4*day + time.Hour}, now)
+	if err != nil {
+		t.Fatalf("Prune returned error: %v", err)
+	}
+	if len(removed) != 1 || removed[0].ID != list[0].ID {
+		t.Fatalf("Expected only the five day old run to be removed, got %+v", removed)
+	}
+
+	// Keep: 0 removes everything except the run latest points at.
+	removed, err = runs.Prune(root, runs.PruneOptions{DryRun: true}, now)
+	if err != nil || len(removed) != 2 {
+		t.Fatalf("Expected two runs to be reported, got %+v (%v)", removed, err)
+	}
+	if list, _ := runs.List(root); len(list) != 3 {
+		t.Errorf("Expected a dry run to remove nothing, got %d runs", len(list))
+	}
+	if _, err := os.Stat(filepath.Join(root, tracer.LatestRun, "run.json")); err != nil {
+		t.Errorf("Expected the latest link to resolve: %v", err)
+	}
+}
@@ -0,0 +1,335 @@
+// Package scrub removes or hashes the sensitive parts of traces, such as parameter and return
+// values, host names and file paths, so they can be shared outside the team or attached to bug
+// reports. Hashed values stay equal where the originals were, so calls with the same input can
+// still be matched up.
+package scrub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"gopkg.in/yaml.v3"
+)
+
+// Actions applied to scrubbed values.
+const (
+	Keep   = "keep"   // Leave the value alone (the default).
+	Remove = "remove" // Drop the value.
+	Hash   = "hash"   // Replace the value with a salted hash, e.g. "h:3fa9c2e1b0d4".
+	Redact = "redact" // Replace the value with "[redacted]".
+)
+
+// Redacted replaces values scrubbed with Redact.
+const Redacted = "[redacted]"
+
+// Rules select what a Scrubber changes. Parameter values are matched by Params, first match
+// first; every other action applies to the whole trace. After those, the text rules (Patterns,
+// Hostnames and Paths) are applied to the values that remain and to panic values, stack traces,
+// error chains, labels and event attributes.
+type Rules struct {
+	Salt     string      `yaml:"salt"`     // Secret mixed into hashes so short values cannot be guessed.
+	Params   []ParamRule `yaml:"params"`   // Actions for parameter values.
+	Returns  string      `yaml:"returns"`  // Action for return values.
+	Panics   string      `yaml:"panics"`   // Action for panic values.
+	Errors   string      `yaml:"errors"`   // Action for error chain messages.
+	Patterns []Pattern   `yaml:"patterns"` // Regular expressions whose matches are scrubbed.
+	// Hostnames is the action for the hosts of URLs, IPv4 addresses, the names listed in Hosts
+	// and the host name recorded in run metadata.
+	Hostnames string   `yaml:"hostnames"`
+	Hosts     []string `yaml:"hosts"`
+	// Paths is the action for the directories of absolute file paths; the file name is kept, so
+	// "/home/ana/app/main.go:42" becomes "h:1c2d3e4f5a6b/main.go:42" with Hash, "[redacted]/main.go:42"
+	// with Redact and "main.go:42" with Remove.
+	Paths string `yaml:"paths"`
+}
+
+// ParamRule applies Action to the parameters named Name (a path.Match glob) of the functions
+// matching Function (a path.Match glob; empty matches every function).
+type ParamRule struct {
+	Function string `yaml:"function"`
+	Name     string `yaml:"name"`
+	Action   string `yaml:"action"`
+}
+
+// Pattern applies Action to the matches of the regular expression Regex.
+type Pattern struct {
+	Regex  string `yaml:"regex"`
+	Action string `yaml:"action"`
+}
+
+// LoadRules reads scrub rules from a YAML file.
+//
+// Parameters:
+//   - filename (string): the path to the rules file, e.g. "scrub.yaml".
+//
+// Returns:
+//   - Rules: the rules.
+//   - error: an error if the file cannot be read or parsed.
+func LoadRules(filename string) (Rules, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Rules{}, err
+	}
+	var rules Rules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return Rules{}, fmt.Errorf("invalid scrub rules: %v", err)
+	}
+	return rules, nil
+}
+
+// textRule is a compiled text replacement.
+type textRule struct {
+	re     *regexp.Regexp
+	action string
+}
+
+// Scrubber applies Rules to trace records and run metadata.
+type Scrubber struct {
+	rules    Rules
+	patterns []textRule
+	hosts    []*regexp.Regexp
+}
+
+// Regular expressions for the text rules.
+var (
+	urlHost = regexp.MustCompile(`(?i)(\b[a-z][a-z0-9+.-]*://(?:[^/\s@]+@)?)([^/\s:?#\[\]]+)`)
+	ipv4    = regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}\b`)
+	// filePath matches absolute paths at the start of the text or after a separator, capturing
+	// the separator, the directory and the file name.
+	filePath = regexp.MustCompile(`(^|[\s"'=(,\[])((?:[A-Za-z]:)?(?:[/\\][\w.@+~-]+)+)[/\\]([\w.@+~-]+)`)
+)
+
+// New returns a Scrubber for rules.
+//
+// Parameters:
+//   - rules (Rules): the rules.
+//
+// Returns:
+//   - *Scrubber: the scrubber.
+//   - error: all problems with the rules, such as unknown actions and invalid patterns.
+func New(rules Rules) (*Scrubber, error) {
+	var problems []error
+	checkAction := func(key, action string) {
+		switch action {
+		case "", Keep, Remove, Hash, Redact:
+		default:
+			problems = append(problems, fmt.Errorf("%s: unknown action %q; use keep, remove, hash or redact", key, action))
+		}
+	}
+	checkAction("returns", rules.Returns)
+	checkAction("panics", rules.Panics)
+	checkAction("errors", rules.Errors)
+	checkAction("hostnames", rules.Hostnames)
+	checkAction("paths", rules.Paths)
+	for i, rule := range rules.Params {
+		checkAction(fmt.Sprintf("params[%d].action", i), rule.Action)
+		for _, pattern := range []string{rule.Function, rule.Name} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				problems = append(problems, fmt.Errorf("params[%d]: invalid pattern %q: %v", i, pattern, err))
+			}
+		}
+	}
+	s := &Scrubber{rules: rules}
+	for i, p := range rules.Patterns {
+		checkAction(fmt.Sprintf("patterns[%d].action", i), p.Action)
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("patterns[%d].regex: %v", i, err))
+			continue
+		}
+		s.patterns = append(s.patterns, textRule{re: re, action: p.Action})
+	}
+	for _, host := range rules.Hosts {
+		s.addHost(host)
+	}
+	return s, errors.Join(problems...)
+}
+
+// addHost adds a host name scrubbed with the Hostnames action.
+func (s *Scrubber) addHost(host string) {
+	if host != "" {
+		s.hosts = append(s.hosts, regexp.MustCompile(`\b`+regexp.QuoteMeta(host)+`\b`))
+	}
+}
+
+// hash returns the salted hash of v.
+func (s *Scrubber) hash(v string) string {
+	mac := hmac.New(sha256.New, []byte(s.rules.Salt))
+	mac.Write([]byte(v))
+	return "h:" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+// apply returns v after action, and false if v is to be removed.
+func (s *Scrubber) apply(action, v string) (string, bool) {
+	switch action {
+	case Remove:
+		return "", false
+	case Hash:
+		return s.hash(v), true
+	case Redact:
+		return Redacted, true
+	}
+	return s.Text(v), true
+}
+
+// replace returns the replacement of a text match under action.
+func (s *Scrubber) replace(action, match string) string {
+	switch action {
+	case Remove:
+		return ""
+	case Hash:
+		return s.hash(match)
+	case Redact:
+		return Redacted
+	}
+	return match
+}
+
+// Text applies the text rules (Patterns, Hostnames and Paths) to v.
+//
+// Parameters:
+//   - v (string): the text.
+//
+// Returns:
+//   - string: the scrubbed text.
+func (s *Scrubber) Text(v string) string {
+	for _, p := range s.patterns {
+		v = p.re.ReplaceAllStringFunc(v, func(m string) string { return s.replace(p.action, m) })
+	}
+	if action := s.rules.Hostnames; action != "" && action != Keep {
+		v = urlHost.ReplaceAllStringFunc(v, func(m string) string {
+			parts := urlHost.FindStringSubmatch(m)
+			return parts[1] + s.replace(action, parts[2])
+		})
+		for _, re := range append([]*regexp.Regexp{ipv4}, s.hosts...) {
+			v = re.ReplaceAllStringFunc(v, func(m string) string { return s.replace(action, m) })
+		}
+	}
+	if action := s.rules.Paths; action != "" && action != Keep {
+		v = filePath.ReplaceAllStringFunc(v, func(m string) string {
+			parts := filePath.FindStringSubmatch(m)
+			if action == Remove {
+				return parts[1] + parts[3]
+			}
+			return parts[1] + s.replace(action, parts[2]) + "/" + parts[3]
+		})
+	}
+	return v
+}
+
+// paramAction returns the action for parameter name of function.
+func (s *Scrubber) paramAction(function, name string) string {
+	for _, rule := range s.rules.Params {
+		if ok, _ := path.Match(rule.Name, name); !ok {
+			continue
+		}
+		if ok, _ := path.Match(rule.Function, function); rule.Function != "" && !ok {
+			continue
+		}
+		return rule.Action
+	}
+	return Keep
+}
+
+// typed returns the typed value to store for p under action: string values are replaced by
+// their scrubbed form, values of other kinds are only kept if action keeps them. Removed values
+// are handled by the caller.
+func (s *Scrubber) typed(p tracer.Primitive, action string) (tracer.Primitive, bool) {
+	if p.Kind == tracer.KindString {
+		v, _ := s.apply(action, fmt.Sprint(p.Value))
+		return tracer.Primitive{Kind: p.Kind, Value: v}, true
+	}
+	return p, action == "" || action == Keep
+}
+
+// Record scrubs rec in place.
+//
+// Parameters:
+//   - rec (*tracer.TraceRecord): the record.
+func (s *Scrubber) Record(rec *tracer.TraceRecord) {
+	for name, v := range rec.Params {
+		if scrubbed, ok := s.apply(s.paramAction(rec.FunctionName, name), v); ok {
+			rec.Params[name] = scrubbed
+		} else {
+			delete(rec.Params, name)
+		}
+	}
+	for name, p := range rec.TypedParams {
+		action := s.paramAction(rec.FunctionName, name)
+		if t, ok := s.typed(p, action); ok && action != Remove {
+			rec.TypedParams[name] = t
+		} else {
+			delete(rec.TypedParams, name)
+		}
+	}
+	if s.rules.Returns == Remove {
+		rec.ReturnValues, rec.TypedReturns = nil, nil
+	}
+	for i, v := range rec.ReturnValues {
+		rec.ReturnValues[i], _ = s.apply(s.rules.Returns, v)
+	}
+	for i, p := range rec.TypedReturns {
+		if p == nil {
+			continue
+		}
+		if t, ok := s.typed(*p, s.rules.Returns); ok {
+			rec.TypedReturns[i] = &t
+		} else {
+			rec.TypedReturns[i] = nil
+		}
+	}
+	// The panic value marks the call as panicked, so removing it redacts it instead.
+	if rec.PanicValue != nil {
+		v, ok := s.apply(s.rules.Panics, fmt.Sprint(rec.PanicValue))
+		if !ok {
+			v = Redacted
+		}
+		rec.PanicValue = v
+	}
+	rec.StackTrace = s.Text(rec.StackTrace)
+	for i := range rec.EntryStack {
+		rec.EntryStack[i].File = s.Text(rec.EntryStack[i].File)
+	}
+	for i := range rec.ErrorChain {
+		link := &rec.ErrorChain[i]
+		if msg, ok := s.apply(s.rules.Errors, link.Message); ok {
+			link.Message = msg
+		} else {
+			link.Message = ""
+		}
+		link.Stack = s.Text(link.Stack)
+	}
+	for k, v := range rec.Labels {
+		rec.Labels[k] = s.Text(v)
+	}
+	for i := range rec.Events {
+		for k, v := range rec.Events[i].Attrs {
+			if str, ok := v.(string); ok {
+				rec.Events[i].Attrs[k] = s.Text(str)
+			}
+		}
+	}
+}
+
+// Metadata scrubs run metadata in place: the host name with the Hostnames action, and the
+// command line arguments with the text rules. The host name is also scrubbed wherever it appears
+// in records scrubbed afterwards.
+//
+// Parameters:
+//   - m (*tracer.RunMetadata): the metadata.
+func (s *Scrubber) Metadata(m *tracer.RunMetadata) {
+	if m.Hostname != "" && s.rules.Hostnames != "" && s.rules.Hostnames != Keep {
+		s.addHost(m.Hostname)
+		m.Hostname = s.replace(s.rules.Hostnames, m.Hostname)
+	}
+	for i, arg := range m.Args {
+		m.Args[i] = s.Text(arg)
+	}
+}
@@ -0,0 +1,150 @@
+package scrub_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/pkg/scrub"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func newScrubber(t *testing.T, rules scrub.Rules) *scrub.Scrubber {
+	t.Helper()
+	s, err := scrub.New(rules)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	return s
+}
+
+func TestRecordAppliesParameterAndReturnRules(t *testing.T) {
+	s := newScrubber(t, scrub.Rules{
+		Salt: "pepper",
+		Params: []scrub.ParamRule{
+			{Name: "password", Action: scrub.Remove},
+			{Function: "main.lookup", Name: "*", Action: scrub.Hash},
+		},
+		Returns: scrub.Redact,
+	})
+	login := func() tracer.TraceRecord {
+		return tracer.TraceRecord{
+			FunctionName: "main.login",
+			Params:       map[string]string{"password": "hunter2", "user": "ana"},
+			TypedParams:  map[string]tracer.Primitive{"password": {Kind: tracer.KindString, Value: "hunter2"}},
+			ReturnValues: []string{"token-123"},
+			TypedReturns: []*tracer.Primitive{{Kind: tracer.KindInt, Value: int64(7)}},
+		}
+	}
+	rec := login()
+	s.Record(&rec)
+	if _, ok := rec.Params["password"]; ok || len(rec.TypedParams) != 0 {
+		t.Errorf("Expected the password to be removed, got %+v %+v", rec.Params, rec.TypedParams)
+	}
+	if rec.Params["user"] != "ana" {
+		t.Errorf("Expected other parameters to be kept, got %+v", rec.Params)
+	}
+	if len(rec.ReturnValues) != 1 || rec.ReturnValues[0] != scrub.Redacted || rec.TypedReturns[0] != nil {
+		t.Errorf("Expected return values to be redacted, got %v %v", rec.ReturnValues, rec.TypedReturns)
+	}
+
+	a := tracer.TraceRecord{FunctionName: "main.lookup", Params: map[string]string{"key": "acct-42"}}
+	b := tracer.TraceRecord{FunctionName: "main.lookup", Params: map[string]string{"key": "acct-42"}}
+	c := tracer.TraceRecord{FunctionName: "main.lookup", Params: map[string]string{"key": "acct-43"}}
+	for _, rec := range []*tracer.TraceRecord{&a, &b, &c} {
+		s.Record(rec)
+	}
+	if !strings.HasPrefix(a.Params["key"], "h:") || strings.Contains(a.Params["key"], "acct") {
+		t.Errorf("Expected a hashed value, got %q", a.Params["key"])
+	}
+	if a.Params["key"] != b.Params["key"] || a.Params["key"] == c.Params["key"] {
+		t.Errorf("Expected equal values to hash equally and different ones not to: %q %q %q", a.Params["key"], b.Params["key"], c.Params["key"])
+	}
+	other := newScrubber(t, scrub.Rules{Salt: "salt", Params: []scrub.ParamRule{{Name: "*", Action: scrub.Hash}}})
+	d := tracer.TraceRecord{FunctionName: "main.lookup", Params: map[string]string{"key": "acct-42"}}
+	other.Record(&d)
+	if d.Params["key"] == a.Params["key"] {
+		t.Error("Expected the salt to change the hash")
+	}
+}
+
+func TestTextRulesScrubHostsPathsAndPatterns(t *testing.T) {
+	s := newScrubber(t, scrub.Rules{
+		Hostnames: scrub.Redact,
+		Hosts:     []string{"db-prod-1"},
+		Paths:     scrub.Remove,
+		Patterns:  []scrub.Pattern{{Regex: `[a-z]+@example\.com`, Action: scrub.Redact}},
+	})
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{"GET https://api.internal.acme.io/v1/users", "GET https://[redacted]/v1/users"},
+		{"dial tcp 10.1.2.3:5432: connection refused", "dial tcp [redacted]:5432: connection refused"},
+		{"connecting to db-prod-1", "connecting to [redacted]"},
+		{"main.main()\n\t/home/ana/src/app/main.go:42 +0x1d", "main.main()\n\tmain.go:42 +0x1d"},
+		{"open C:\\Users\\ana\\secrets.txt", "open secrets.txt"},
+		{"relative/path/stays", "relative/path/stays"},
+		{"mail ana@example.com", "mail [redacted]"},
+	}
+	for _, tt := range tests {
+		if got := s.Text(tt.in); got != tt.expected {
+			t.Errorf("Text(%q) = %q, expected %q", tt.in, got, tt.expected)
+		}
+	}
+
+	rec := tracer.TraceRecord{
+		PanicValue: "cannot reach db-prod-1",
+		StackTrace: "\t/home/ana/src/app/db.go:10",
+		EntryStack: []tracer.StackFrame{{Function: "main.main", File: "/home/ana/src/app/main.go", Line: 42}},
+		ErrorChain: []tracer.ErrorLink{{Message: "query 10.0.0.9 failed"}},
+		Labels:     map[string]string{"peer": "http://db-prod-1:8080"},
+	}
+	s.Record(&rec)
+	if rec.PanicValue != "cannot reach [redacted]" || rec.StackTrace != "\tdb.go:10" || rec.EntryStack[0].File != "main.go" ||
+		rec.ErrorChain[0].Message != "query [redacted] failed" || rec.Labels["peer"] != "http://[redacted]:8080" {
+		t.Errorf("Expected text rules to apply throughout the record, got %+v", rec)
+	}
+}
+
+func TestMetadataScrubsHostnameEverywhere(t *testing.T) {
+	s := newScrubber(t, scrub.Rules{Hostnames: scrub.Hash, Paths: scrub.Redact})
+	meta := tracer.RunMetadata{Hostname: "ana-laptop", Args: []string{"/home/ana/bin/app", "--verbose"}}
+	s.Metadata(&meta)
+	if !strings.HasPrefix(meta.Hostname, "h:") {
+		t.Errorf("Expected the host name to be hashed, got %q", meta.Hostname)
+	}
+	if meta.Args[0] != scrub.Redacted+"/app" || meta.Args[1] != "--verbose" {
+		t.Errorf("Expected the binary's directory to be redacted, got %v", meta.Args)
+	}
+	rec := tracer.TraceRecord{Params: map[string]string{"origin": "ana-laptop"}}
+	s.Record(&rec)
+	if rec.Params["origin"] != meta.Hostname {
+		t.Errorf("Expected the host name to be scrubbed in records too, got %q", rec.Params["origin"])
+	}
+}
+
+func TestLoadRulesAndValidation(t *testing.T) {
+	dir, err := os.MkdirTemp("", "scrubtest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "scrub.yaml")
+	data := "salt: s\nparams:\n  - name: password\n    action: remove\nreturns: obliterate\npatterns:\n  - regex: \"[\"\n    action: hash\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+	rules, err := scrub.LoadRules(path)
+	if err != nil {
+		t.Fatalf("LoadRules returned error: %v", err)
+	}
+	if rules.Salt != "s" || len(rules.Params) != 1 || rules.Params[0].Action != scrub.Remove {
+		t.Errorf("Unexpected rules: %+v", rules)
+	}
+	_, err = scrub.New(rules)
+	if err == nil || !strings.Contains(err.Error(), "returns") || !strings.Contains(err.Error(), "patterns[0].regex") {
+		t.Errorf("Expected problems for returns and patterns[0], got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+)
+
+// ErrBaselineExists is returned by SaveBaseline for a name already in use.
+var ErrBaselineExists = errors.New("baseline already exists")
+
+// baselineName matches the names accepted for baselines, which become file names.
+var baselineName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Baseline is a named snapshot of per-function stats, e.g. of a release, that later runs are
+// compared against. Baselines are stored in <dir>/baselines/<name>.json and are not affected by
+// the retention policy of the runs.
+type Baseline struct {
+	Name      string                   `json:"name"`
+	SavedAt   time.Time                `json:"savedAt"`
+	Sources   []string                 `json:"sources"` // The trace files or stored run IDs the stats were computed from.
+	Records   int                      `json:"records"`
+	Functions []analysis.FunctionStats `json:"functions"`
+}
+
+// baselineFile returns the path of a baseline's file, or an error for an invalid name.
+func (s *Store) baselineFile(name string) (string, error) {
+	if !baselineName.MatchString(name) {
+		return "", fmt.Errorf("invalid baseline name %q; use letters, digits and . _ -, e.g. v1.4", name)
+	}
+	return filepath.Join(s.dir, "baselines", name+".json"), nil
+}
+
+// SaveBaseline writes b to the store.
+//
+// Parameters:
+//   - b (Baseline): the baseline.
+//   - replace (bool): overwrite a baseline of the same name instead of failing.
+//
+// Returns:
+//   - error: ErrBaselineExists, wrapped, if the name is in use and replace is false, or an error
+//     for an invalid name or if the file cannot be written.
+func (s *Store) SaveBaseline(b Baseline, replace bool) error {
+	path, err := s.baselineFile(b.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create baseline directory: %v", err)
+	}
+	if _, err := os.Stat(path); err == nil && !replace {
+		return fmt.Errorf("%w: %s", ErrBaselineExists, b.Name)
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode baseline: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to store baseline: %v", err)
+	}
+	return nil
+}
+
+// Baseline reads the baseline called name.
+//
+// Parameters:
+//   - name (string): the baseline name, e.g. "v1.4".
+//
+// Returns:
+//   - Baseline: the baseline.
+//   - error: an error if there is no such baseline or it cannot be read.
+func (s *Store) Baseline(name string) (Baseline, error) {
+	path, err := s.baselineFile(name)
+	if err != nil {
+		return Baseline{}, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Baseline{}, fmt.Errorf("no baseline %q in %s", name, s.dir)
+	}
+	if err != nil {
+		return Baseline{}, fmt.Errorf("failed to read baseline: %v", err)
+	}
+	var b Baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return Baseline{}, fmt.Errorf("invalid baseline %s: %v", name, err)
+	}
+	return b, nil
+}
+
+// Baselines lists the baselines in the store, by name.
+//
+// Returns:
+//   - []Baseline: the baselines.
+//   - error: an error if a baseline cannot be read.
+func (s *Store) Baselines() ([]Baseline, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, "baselines"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baselines: %v", err)
+	}
+	var baselines []Baseline
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if e.IsDir() || !ok {
+			continue
+		}
+		b, err := s.Baseline(name)
+		if err != nil {
+			return nil, err
+		}
+		baselines = append(baselines, b)
+	}
+	sort.Slice(baselines, func(i, j int) bool { return baselines[i].Name < baselines[j].Name })
+	return baselines, nil
+}
+
+// DeleteBaseline removes the baseline called name.
+//
+// Parameters:
+//   - name (string): the baseline name.
+//
+// Returns:
+//   - error: an error if there is no such baseline or it cannot be removed.
+func (s *Store) DeleteBaseline(name string) error {
+	path, err := s.baselineFile(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); os.IsNotExist(err) {
+		return fmt.Errorf("no baseline %q in %s", name, s.dir)
+	} else if err != nil {
+		return fmt.Errorf("failed to remove baseline: %v", err)
+	}
+	return nil
+}
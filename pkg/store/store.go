@@ -0,0 +1,280 @@
+// Package store keeps the traces of past runs on disk so later analyses can compare against them.
+//
+// A store is a directory with one subdirectory per run:
+//
+//	<dir>/<run id>/trace.jsonl  the run's trace records
+//	<dir>/<run id>/run.json     the run metadata written by the tracer, if it was available
+//	<dir>/<run id>/symbols.json the symbol table of the function IDs, if the run wrote one
+//	<dir>/<run id>/entry.json   the store's own bookkeeping (Run)
+//	<dir>/<run id>/stats.json   the run's per-function stats, rolled up by analyze trend
+//	<dir>/baselines/<name>.json the named baselines (Baseline)
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/runs"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// DefaultDir is the store location used by the tracewrap commands.
+const DefaultDir = ".tracewrap/store"
+
+// Store is a directory of stored runs.
+type Store struct {
+	dir string
+}
+
+// Run describes a stored run.
+type Run struct {
+	ID       string              `json:"id"`
+	AddedAt  time.Time           `json:"addedAt"`
+	Source   string              `json:"source"`
+	Records  int                 `json:"records"`
+	Metadata *tracer.RunMetadata `json:"metadata,omitempty"`
+	Bytes    int64               `json:"bytes,omitempty"` // Disk usage of the run, set by Runs.
+}
+
+// Retention limits the runs a store keeps. A zero value keeps every run.
+type Retention struct {
+	MaxAge   time.Duration // When positive, runs added longer ago than this are removed.
+	MaxSize  int64         // When positive, the oldest runs are removed until the store uses at most this many bytes.
+	KeepLast int           // The most recently added KeepLast runs are always kept, e.g. as baselines.
+}
+
+// Open opens the store in dir, creating the directory if needed.
+//
+// Parameters:
+//   - dir (string): the store directory.
+//
+// Returns:
+//   - *Store: the store.
+//   - error: an error if the directory cannot be created.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %v", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir returns the store directory.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Add copies a trace file, and the run.json and symbols.json next to it if there are, into the
+// store as a new run.
+//
+// Parameters:
+//   - traceFile (string): the trace file to add, e.g. "tracewrap/latest/trace.jsonl".
+//
+// Returns:
+//   - Run: the stored run.
+//   - error: an error if the trace cannot be read or the run cannot be written.
+func (s *Store) Add(traceFile string) (Run, error) {
+	data, err := os.ReadFile(traceFile)
+	if err != nil {
+		return Run{}, fmt.Errorf("failed to read trace file: %v", err)
+	}
+	records, err := tracer.ReadTraceFile(traceFile)
+	if err != nil {
+		return Run{}, err
+	}
+	run := Run{AddedAt: time.Now().UTC(), Source: traceFile, Records: len(records)}
+	var meta []byte
+	if meta, err = os.ReadFile(filepath.Join(filepath.Dir(traceFile), "run.json")); err == nil {
+		if m, err := tracer.DecodeRunMetadata(meta); err == nil {
+			run.Metadata = &m
+		}
+	}
+
+	run.ID, err = s.newRunDir(run.AddedAt)
+	if err != nil {
+		return Run{}, err
+	}
+	runDir := filepath.Join(s.dir, run.ID)
+	if err := os.WriteFile(filepath.Join(runDir, "trace.jsonl"), data, 0644); err != nil {
+		return Run{}, fmt.Errorf("failed to store trace: %v", err)
+	}
+	if run.Metadata != nil {
+		if err := os.WriteFile(filepath.Join(runDir, "run.json"), meta, 0644); err != nil {
+			return Run{}, fmt.Errorf("failed to store run metadata: %v", err)
+		}
+	}
+	if symbols, err := os.ReadFile(filepath.Join(filepath.Dir(traceFile), tracer.SymbolsFile)); err == nil {
+		if err := os.WriteFile(filepath.Join(runDir, tracer.SymbolsFile), symbols, 0644); err != nil {
+			return Run{}, fmt.Errorf("failed to store symbol table: %v", err)
+		}
+	}
+	if err := writeStats(filepath.Join(runDir, "stats.json"), analysis.Aggregate(records)); err != nil {
+		return Run{}, err
+	}
+	entry, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return Run{}, fmt.Errorf("failed to encode run entry: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(runDir, "entry.json"), entry, 0644); err != nil {
+		return Run{}, fmt.Errorf("failed to store run entry: %v", err)
+	}
+	return run, nil
+}
+
+// newRunDir creates the directory for a run added at t and returns its ID. IDs sort in the order
+// runs were added; a numeric suffix separates runs added within the same second.
+func (s *Store) newRunDir(t time.Time) (string, error) {
+	base := t.Format("20060102-150405")
+	for i := 0; ; i++ {
+		id := base
+		if i > 0 {
+			id = fmt.Sprintf("%s-%d", base, i)
+		}
+		err := os.Mkdir(filepath.Join(s.dir, id), 0755)
+		if err == nil {
+			return id, nil
+		}
+		if !os.IsExist(err) {
+			return "", fmt.Errorf("failed to create run directory: %v", err)
+		}
+	}
+}
+
+// Runs lists the stored runs, oldest first.
+//
+// Returns:
+//   - []Run: the runs.
+//   - error: an error if the store cannot be read.
+func (s *Store) Runs() ([]Run, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store: %v", err)
+	}
+	var stored []Run
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, e.Name(), "entry.json"))
+		if err != nil {
+			// Not a run directory (or one still being written).
+			continue
+		}
+		var run Run
+		if err := json.Unmarshal(data, &run); err != nil {
+			return nil, fmt.Errorf("invalid run entry %s: %v", e.Name(), err)
+		}
+		run.Bytes = runs.DirSize(filepath.Join(s.dir, e.Name()))
+		stored = append(stored, run)
+	}
+	sort.Slice(stored, func(i, j int) bool {
+		if !stored[i].AddedAt.Equal(stored[j].AddedAt) {
+			return stored[i].AddedAt.Before(stored[j].AddedAt)
+		}
+		return stored[i].ID < stored[j].ID
+	})
+	return stored, nil
+}
+
+// Records reads the trace records of a stored run.
+//
+// Parameters:
+//   - id (string): the run ID.
+//
+// Returns:
+//   - []tracer.TraceRecord: the run's records.
+//   - error: an error if the run does not exist or its trace cannot be read.
+func (s *Store) Records(id string) ([]tracer.TraceRecord, error) {
+	return tracer.ReadTraceFile(filepath.Join(s.dir, id, "trace.jsonl"))
+}
+
+// Stats returns the per-function stats of a stored run. Runs stored before the store kept stats
+// have them computed from their trace, and saved for the next call.
+//
+// Parameters:
+//   - id (string): the run ID.
+//
+// Returns:
+//   - []analysis.FunctionStats: the stats, by function name.
+//   - error: an error if the run does not exist or its stats and trace cannot be read.
+func (s *Store) Stats(id string) ([]analysis.FunctionStats, error) {
+	path := filepath.Join(s.dir, id, "stats.json")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var stats []analysis.FunctionStats
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return nil, fmt.Errorf("invalid stats of run %s: %v", id, err)
+		}
+		return stats, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read stats of run %s: %v", id, err)
+	}
+	records, err := s.Records(id)
+	if err != nil {
+		return nil, err
+	}
+	stats := analysis.Aggregate(records)
+	if err := writeStats(path, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// writeStats writes the per-function stats of a run to path.
+func writeStats(path string, stats []analysis.FunctionStats) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("failed to encode run stats: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to store run stats: %v", err)
+	}
+	return nil
+}
+
+// Prune removes the runs outside r: those added longer ago than r.MaxAge, then the oldest of the
+// rest until the store fits in r.MaxSize. The r.KeepLast most recent runs are never removed, so
+// the store may stay above MaxSize.
+//
+// Parameters:
+//   - r (Retention): the retention policy.
+//   - now (time.Time): the current time, for r.MaxAge.
+//   - dryRun (bool): report the runs that would be removed without removing them.
+//
+// Returns:
+//   - []Run: the removed runs (or, with dryRun, those that would be), oldest first.
+//   - error: an error if the store cannot be read or a run cannot be removed.
+func (s *Store) Prune(r Retention, now time.Time, dryRun bool) ([]Run, error) {
+	runs, err := s.Runs()
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	for _, run := range runs {
+		size += run.Bytes
+	}
+	var removed []Run
+	for i, run := range runs {
+		if len(runs)-i <= r.KeepLast {
+			break
+		}
+		expired := r.MaxAge > 0 && now.Sub(run.AddedAt) > r.MaxAge
+		oversize := r.MaxSize > 0 && size > r.MaxSize
+		if !expired && !oversize {
+			continue
+		}
+		if !dryRun {
+			if err := os.RemoveAll(filepath.Join(s.dir, run.ID)); err != nil {
+				return removed, fmt.Errorf("failed to remove run %s: %v", run.ID, err)
+			}
+		}
+		size -= run.Bytes
+		removed = append(removed, run)
+	}
+	return removed, nil
+}
@@ -0,0 +1,188 @@
+package store_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/store"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// tempDir creates a temporary directory removed when the test ends.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "storetest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// writeTrace writes records as a trace file with a run.json next to it in a new directory.
+func writeTrace(t *testing.T, records ...tracer.TraceRecord) string {
+	t.Helper()
+	dir := tempDir(t)
+	file, err := os.Create(filepath.Join(dir, "trace.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create trace file: %v", err)
+	}
+	enc := json.NewEncoder(file)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			t.Fatalf("Failed to encode record: %v", err)
+		}
+	}
+	file.Close()
+	meta, _ := json.Marshal(tracer.RunMetadata{TracewrapVersion: "v1.2.3", ConfigHash: "abc"})
+	if err := os.WriteFile(filepath.Join(dir, "run.json"), meta, 0644); err != nil {
+		t.Fatalf("Failed to write run.json: %v", err)
+	}
+	return filepath.Join(dir, "trace.jsonl")
+}
+
+func TestAddAndListRuns(t *testing.T) {
+	s, err := store.Open(filepath.Join(tempDir(t), "store"))
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	first, err := s.Add(writeTrace(t, tracer.TraceRecord{UniqueID: 1, FunctionName: "a"}))
+	if err != nil {
+		t.Fatalf("Add returned error: %v", err)
+	}
+	second, err := s.Add(writeTrace(t, tracer.TraceRecord{UniqueID: 1, FunctionName: "b"}, tracer.TraceRecord{UniqueID: 2, FunctionName: "c"}))
+	if err != nil {
+		t.Fatalf("Add returned error: %v", err)
+	}
+	if first.ID == second.ID {
+		t.Fatalf("Expected distinct run IDs, got %s twice", first.ID)
+	}
+
+	runs, err := s.Runs()
+	if err != nil {
+		t.Fatalf("Runs returned error: %v", err)
+	}
+	if len(runs) != 2 || runs[0].ID != first.ID || runs[1].ID != second.ID {
+		t.Fatalf("Expected both runs oldest first, got %+v", runs)
+	}
+	if runs[1].Records != 2 || runs[1].Metadata == nil || runs[1].Metadata.TracewrapVersion != "v1.2.3" {
+		t.Errorf("Unexpected run entry: %+v", runs[1])
+	}
+	records, err := s.Records(second.ID)
+	if err != nil || len(records) != 2 || records[1].FunctionName != "c" {
+		t.Errorf("Expected the stored records back, got %v (%v)", records, err)
+	}
+
+	stats, err := s.Stats(second.ID)
+	if err != nil || len(stats) != 2 || stats[0].Name != "b" || stats[1].Calls != 1 {
+		t.Errorf("Expected the stats of the stored records, got %+v (%v)", stats, err)
+	}
+	// Runs stored without stats have them computed from their trace.
+	if err := os.Remove(filepath.Join(s.Dir(), first.ID, "stats.json")); err != nil {
+		t.Fatalf("Failed to remove stats: %v", err)
+	}
+	if stats, err := s.Stats(first.ID); err != nil || len(stats) != 1 || stats[0].Name != "a" {
+		t.Errorf("Expected the stats computed from the trace, got %+v (%v)", stats, err)
+	}
+	if _, err := os.Stat(filepath.Join(s.Dir(), first.ID, "stats.json")); err != nil {
+		t.Errorf("Expected the computed stats to be saved: %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	s, err := store.Open(filepath.Join(tempDir(t), "store"))
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	now := time.Now().UTC()
+	var ids []string
+	for i := 0; i < 4; i++ {
+		run, err := s.Add(writeTrace(t, tracer.TraceRecord{UniqueID: 1, FunctionName: "a"}))
+		if err != nil {
+			t.Fatalf("Add returned error: %v", err)
+		}
+		// Date the runs a day apart, the last one added now.
+		run.AddedAt = now.Add(time.Duration(i-3) * 24 * time.Hour)
+		entry, _ := json.Marshal(run)
+		if err := os.WriteFile(filepath.Join(s.Dir(), run.ID, "entry.json"), entry, 0644); err != nil {
+			t.Fatalf("Failed to rewrite run entry: %v", err)
+		}
+		ids = append(ids, run.ID)
+	}
+	runs, err := s.Runs()
+	if err != nil || len(runs) != 4 || runs[0].Bytes == 0 {
+		t.Fatalf("Expected 4 runs with their sizes, got %+v (%v)", runs, err)
+	}
+
+	removed, err := s.Prune(store.Retention{MaxAge: 36 * time.Hour}, now, true)
+	if err != nil || len(removed) != 2 || removed[0].ID != ids[0] || removed[1].ID != ids[1] {
+		t.Fatalf("Expected the runs older than 36h to be listed, got %+v (%v)", removed, err)
+	}
+	if runs, _ := s.Runs(); len(runs) != 4 {
+		t.Fatalf("Expected a dry run to keep every run, got %d", len(runs))
+	}
+
+	// Keep-last protects the baselines from the age limit.
+	removed, err = s.Prune(store.Retention{MaxAge: 36 * time.Hour, KeepLast: 3}, now, false)
+	if err != nil || len(removed) != 1 || removed[0].ID != ids[0] {
+		t.Fatalf("Expected only the oldest run to be removed, got %+v (%v)", removed, err)
+	}
+
+	runs, _ = s.Runs()
+	removed, err = s.Prune(store.Retention{MaxSize: runs[1].Bytes + runs[2].Bytes}, now, false)
+	if err != nil || len(removed) != 1 || removed[0].ID != ids[1] {
+		t.Fatalf("Expected the oldest run to be removed to fit in two runs, got %+v (%v)", removed, err)
+	}
+	runs, err = s.Runs()
+	if err != nil || len(runs) != 2 || runs[0].ID != ids[2] || runs[1].ID != ids[3] {
+		t.Errorf("Expected the two most recent runs to remain, got %+v (%v)", runs, err)
+	}
+}
+
+func TestBaselines(t *testing.T) {
+	s, err := store.Open(filepath.Join(tempDir(t), "store"))
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	b := store.Baseline{Name: "v1.4", Records: 2, Functions: []analysis.FunctionStats{{Name: "parse", Calls: 2, Mean: time.Millisecond}}}
+	if err := s.SaveBaseline(b, false); err != nil {
+		t.Fatalf("SaveBaseline returned error: %v", err)
+	}
+	if err := s.SaveBaseline(b, false); !errors.Is(err, store.ErrBaselineExists) {
+		t.Errorf("Expected ErrBaselineExists for a second save, got %v", err)
+	}
+	b.Records = 3
+	if err := s.SaveBaseline(b, true); err != nil {
+		t.Fatalf("SaveBaseline with replace returned error: %v", err)
+	}
+	if err := s.SaveBaseline(store.Baseline{Name: "../escape"}, false); err == nil {
+		t.Error("Expected an error for a name with a path separator")
+	}
+
+	got, err := s.Baseline("v1.4")
+	if err != nil || got.Records != 3 || len(got.Functions) != 1 || got.Functions[0].Mean != time.Millisecond {
+		t.Errorf("Expected the replaced baseline back, got %+v (%v)", got, err)
+	}
+	// Baselines are not runs.
+	if runs, err := s.Runs(); err != nil || len(runs) != 0 {
+		t.Errorf("Expected no runs, got %+v (%v)", runs, err)
+	}
+	if list, err := s.Baselines(); err != nil || len(list) != 1 || list[0].Name != "v1.4" {
+		t.Errorf("Expected one baseline listed, got %+v (%v)", list, err)
+	}
+
+	if err := s.DeleteBaseline("v1.4"); err != nil {
+		t.Fatalf("DeleteBaseline returned error: %v", err)
+	}
+	if _, err := s.Baseline("v1.4"); err == nil {
+		t.Error("Expected an error for a deleted baseline")
+	}
+	if err := s.DeleteBaseline("v1.4"); err == nil {
+		t.Error("Expected an error deleting a missing baseline")
+	}
+}
@@ -0,0 +1,221 @@
+// Package theme holds the colors of the visuals tracewrap generates: the DOT call graphs, the HTML
+// reports and the collector's web UI. Both themes build on the Okabe-Ito palette, whose colors stay
+// apart under the common forms of color blindness, so the meaning of a color does not depend on
+// telling red from green. The colors of a theme can be replaced one by one, e.g. to match a
+// company's style guide.
+package theme
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Names of the themes.
+const (
+	Light = "light" // Dark text on white, the default.
+	Dark  = "dark"  // Light text on a dark background.
+)
+
+// Palette gives the color of each role in the generated visuals, as "#rrggbb".
+type Palette struct {
+	Background     string // The page or graph background.
+	Text           string
+	Muted          string // Secondary text, such as axis labels and calls that disappeared.
+	Grid           string // Table rules and cell borders.
+	Node           string // The fill of function nodes.
+	Edge           string // Calls, and the static calls of static graphs.
+	Idle           string // The fill of functions that never ran.
+	Unexercised    string // Static calls the run never took.
+	Dynamic        string // Calls without a static call, e.g. through an interface.
+	Remote         string // Requests from one service to another.
+	Uninstrumented string // Calls made through code that is not instrumented.
+	Error          string // The fill of failed calls.
+	SLO            string // The fill of calls over their SLO budget.
+	Slower         string // Call paths and functions that got slower, in comparisons.
+	Faster         string // Call paths and functions that got faster, in comparisons.
+	New            string // Call paths that are new, in comparisons.
+	Heat           string // The cells of latency heatmaps, more opaque with more calls.
+}
+
+// light is the light theme, built on the Okabe-Ito colors: blue #0072B2, sky blue #56B4E9, orange
+// #E69F00, vermillion #D55E00, reddish purple #CC79A7 and yellow #F0E442. Fills are tints, so that
+// dark text stays readable on them.
+var light = Palette{
+	Background:     "#ffffff",
+	Text:           "#000000",
+	Muted:          "#666666",
+	Grid:           "#dddddd",
+	Node:           "#cce6f6",
+	Edge:           "#000000",
+	Idle:           "#e5e5e5",
+	Unexercised:    "#999999",
+	Dynamic:        "#0072b2",
+	Remote:         "#e69f00",
+	Uninstrumented: "#cc79a7",
+	Error:          "#f2bf99",
+	SLO:            "#f7f0a1",
+	Slower:         "#d55e00",
+	Faster:         "#0072b2",
+	New:            "#e69f00",
+	Heat:           "#d55e00",
+}
+
+// dark is the dark theme, with the same colors as light for lines and shades of them for fills.
+var dark = Palette{
+	Background:     "#1e1e1e",
+	Text:           "#e6e6e6",
+	Muted:          "#9a9a9a",
+	Grid:           "#3c3c3c",
+	Node:           "#1d4a66",
+	Edge:           "#d0d0d0",
+	Idle:           "#333333",
+	Unexercised:    "#6e6e6e",
+	Dynamic:        "#56b4e9",
+	Remote:         "#e69f00",
+	Uninstrumented: "#cc79a7",
+	Error:          "#7a3609",
+	SLO:            "#6b6514",
+	Slower:         "#d55e00",
+	Faster:         "#56b4e9",
+	New:            "#e69f00",
+	Heat:           "#e69f00",
+}
+
+// Default returns the palette of the light theme.
+func Default() Palette {
+	return light
+}
+
+// OrDefault returns p, or Default if p is the zero Palette, as for a graph or server whose palette
+// was not set.
+func (p Palette) OrDefault() Palette {
+	if p == (Palette{}) {
+		return Default()
+	}
+	return p
+}
+
+// hexColor matches the colors of a palette.
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// New returns the palette of the theme called name, with the colors of overrides replacing its
+// own. The keys of overrides are the roles of Palette in lower camel case, e.g. "background" or
+// "uninstrumented".
+//
+// Parameters:
+//   - name (string): Light or Dark; "" means Light.
+//   - overrides (map[string]string): colors by role, as "#rrggbb", or nil.
+//
+// Returns:
+//   - Palette: the palette.
+//   - error: an error for an unknown theme or role, or a color that is not "#rrggbb".
+func New(name string, overrides map[string]string) (Palette, error) {
+	var p Palette
+	switch name {
+	case "", Light:
+		p = light
+	case Dark:
+		p = dark
+	default:
+		return Palette{}, fmt.Errorf("unknown theme %q; use %s or %s", name, Light, Dark)
+	}
+	roles := p.roles()
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		color, ok := roles[key]
+		if !ok {
+			return Palette{}, fmt.Errorf("unknown palette color %q; use %s", key, strings.Join(Roles(), ", "))
+		}
+		if !hexColor.MatchString(overrides[key]) {
+			return Palette{}, fmt.Errorf("palette color %s: %q is not a color like \"#0072b2\"", key, overrides[key])
+		}
+		*color = strings.ToLower(overrides[key])
+	}
+	return p, nil
+}
+
+// roles returns the colors of p by the name of their role.
+func (p *Palette) roles() map[string]*string {
+	return map[string]*string{
+		"background":     &p.Background,
+		"text":           &p.Text,
+		"muted":          &p.Muted,
+		"grid":           &p.Grid,
+		"node":           &p.Node,
+		"edge":           &p.Edge,
+		"idle":           &p.Idle,
+		"unexercised":    &p.Unexercised,
+		"dynamic":        &p.Dynamic,
+		"remote":         &p.Remote,
+		"uninstrumented": &p.Uninstrumented,
+		"error":          &p.Error,
+		"slo":            &p.SLO,
+		"slower":         &p.Slower,
+		"faster":         &p.Faster,
+		"new":            &p.New,
+		"heat":           &p.Heat,
+	}
+}
+
+// Roles returns the names of the roles of a palette, sorted, as New accepts them.
+func Roles() []string {
+	var p Palette
+	names := make([]string, 0, 17)
+	for name := range p.roles() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Tint returns color at the given opacity as a CSS "rgba(r, g, b, a)" color, e.g. for the shades
+// of a heatmap.
+//
+// Parameters:
+//   - color (string): a color of a palette, "#rrggbb".
+//   - opacity (float64): from 0, transparent, to 1.
+//
+// Returns:
+//   - string: the CSS color.
+func Tint(color string, opacity float64) string {
+	rgb, _ := strconv.ParseUint(strings.TrimPrefix(color, "#"), 16, 32)
+	return fmt.Sprintf("rgba(%d, %d, %d, %.2f)", rgb>>16&0xff, rgb>>8&0xff, rgb&0xff, opacity)
+}
+
+// CSS returns the palette as CSS custom properties of the page, "--background", "--text" and so
+// on for each role, for the stylesheets of the HTML reports and the web UI to use with var().
+// The comparison shades "--slower1" to "--slower3" and "--faster1" to "--faster3" grow stronger
+// with the change.
+//
+// Returns:
+//   - string: the ":root" rule.
+func (p Palette) CSS() string {
+	var sb strings.Builder
+	sb.WriteString(":root {")
+	roles := p.roles()
+	for _, name := range Roles() {
+		fmt.Fprintf(&sb, " --%s: %s;", name, *roles[name])
+	}
+	for i, opacity := range []float64{0.25, 0.55, 0.9} {
+		fmt.Fprintf(&sb, " --slower%d: %s; --faster%d: %s;", i+1, Tint(p.Slower, opacity), i+1, Tint(p.Faster, opacity))
+	}
+	sb.WriteString(" }")
+	return sb.String()
+}
+
+// DOTDefaults returns the DOT statements that give a graph the palette's background, text, node
+// and edge colors, and its clusters muted outlines, for the start of the graph.
+//
+// Returns:
+//   - string: the statements, indented for the top level of the graph.
+func (p Palette) DOTDefaults() string {
+	return fmt.Sprintf("  graph [bgcolor=%q, fontcolor=%q, color=%q];\n  node [shape=box, style=filled, color=%q, fontcolor=%q];\n  edge [color=%q, fontcolor=%q];\n",
+		p.Background, p.Text, p.Muted, p.Node, p.Text, p.Edge, p.Text)
+}
@@ -0,0 +1,68 @@
+package theme_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/pkg/theme"
+)
+
+func TestNewAppliesOverrides(t *testing.T) {
+	p, err := theme.New(theme.Dark, map[string]string{"slo": "#AABBCC", "background": "#000000"})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	if p.SLO != "#aabbcc" || p.Background != "#000000" {
+		t.Errorf("Expected the overrides to replace the dark colors, got %+v", p)
+	}
+	if p.Text == theme.Default().Text {
+		t.Errorf("Expected the dark theme's text color, got %s", p.Text)
+	}
+	if p, err := theme.New("", nil); err != nil || p != theme.Default() {
+		t.Errorf("Expected the light theme by default, got %+v, %v", p, err)
+	}
+}
+
+func TestNewRejectsInvalidThemes(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		overrides map[string]string
+		want      string
+	}{
+		{"sepia", nil, `unknown theme "sepia"`},
+		{theme.Light, map[string]string{"accent": "#000000"}, `unknown palette color "accent"`},
+		{theme.Light, map[string]string{"error": "red"}, `palette color error: "red" is not a color`},
+	} {
+		if _, err := theme.New(tc.name, tc.overrides); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("New(%q, %v): expected an error containing %q, got %v", tc.name, tc.overrides, tc.want, err)
+		}
+	}
+}
+
+func TestPaletteCSSAndTint(t *testing.T) {
+	if got := theme.Tint("#d55e00", 0.5); got != "rgba(213, 94, 0, 0.50)" {
+		t.Errorf("Tint: got %q", got)
+	}
+	css := theme.Default().CSS()
+	for _, role := range theme.Roles() {
+		if !strings.Contains(css, "--"+role+": #") {
+			t.Errorf("Expected --%s in %s", role, css)
+		}
+	}
+	if !strings.Contains(css, "--slower3: rgba(213, 94, 0, 0.90);") {
+		t.Errorf("Expected the comparison shades in %s", css)
+	}
+}
+
+func TestOrDefault(t *testing.T) {
+	if got := (theme.Palette{}).OrDefault(); got != theme.Default() {
+		t.Errorf("Expected the default palette for the zero palette, got %+v", got)
+	}
+	dark, err := theme.New(theme.Dark, nil)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	if got := dark.OrDefault(); got != dark {
+		t.Errorf("Expected a set palette to be kept, got %+v", got)
+	}
+}
@@ -0,0 +1,267 @@
+// Package traceio reads and writes the files tracewrap produces, for programs that analyse traces
+// themselves:
+//
+//	trace.jsonl   one JSON tracer.TraceRecord per line
+//	run.json      the tracer.RunMetadata of the run
+//	symbols.json  the tracer.SymbolTable resolving the function IDs of the records
+//
+// Records and metadata written by older tracewrap versions are migrated to the current
+// tracer.SchemaVersion as they are read, and Open resolves function IDs to names. A typical
+// program reads a trace with:
+//
+//	r, err := traceio.Open("tracewrap/latest/trace.jsonl")
+//	if err != nil {
+//		return err
+//	}
+//	defer r.Close()
+//	for rec, err := range r.All() {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(rec.FunctionName, rec.Duration)
+//	}
+package traceio
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// MaxRecordBytes is the longest record, in bytes, a Reader accepts. Records with captured stack
+// traces can exceed the 64 KiB default of bufio.Scanner.
+const MaxRecordBytes = 16 * 1024 * 1024
+
+// Reader reads trace records from a JSON Lines stream one at a time, so traces larger than memory
+// can be processed.
+type Reader struct {
+	scanner *bufio.Scanner
+	name    string
+	line    int
+	rec     tracer.TraceRecord
+	err     error
+	closer  io.Closer
+	symbols tracer.SymbolTable
+}
+
+// NewReader returns a Reader for the records in r. The records are returned as written: records
+// that carry a function ID instead of their name have an empty FunctionName until the reader is
+// given the symbol table of their run with SetSymbols.
+//
+// Parameters:
+//   - r (io.Reader): the JSON Lines stream, e.g. an open trace.jsonl or a collector upload.
+//
+// Returns:
+//   - *Reader: the reader.
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxRecordBytes)
+	return &Reader{scanner: scanner, name: "line"}
+}
+
+// Open opens the trace file at path for reading, resolving function IDs with the symbols.json
+// next to it. The caller must Close the reader.
+//
+// Parameters:
+//   - path (string): the path to the trace file, e.g. "tracewrap/latest/trace.jsonl".
+//
+// Returns:
+//   - *Reader: the reader.
+//   - error: an error if the file cannot be opened.
+func Open(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := NewReader(file)
+	r.name, r.closer = path, file
+	r.SetSymbols(tracer.SymbolsFor(path))
+	return r, nil
+}
+
+// SetSymbols makes the reader resolve the function IDs of the records it reads next with symbols,
+// e.g. the symbols.json of their run read with tracer.ReadSymbols.
+//
+// Parameters:
+//   - symbols (tracer.SymbolTable): the symbol table.
+func (r *Reader) SetSymbols(symbols tracer.SymbolTable) {
+	r.symbols = symbols
+}
+
+// Next advances to the next record, skipping blank lines.
+//
+// Returns:
+//   - bool: false at the end of the stream or on an error, which Err then reports.
+func (r *Reader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	for r.scanner.Scan() {
+		r.line++
+		if len(r.scanner.Bytes()) == 0 {
+			continue
+		}
+		rec, err := tracer.DecodeRecord(r.scanner.Bytes())
+		if err != nil {
+			r.err = fmt.Errorf("%s:%d: invalid trace record: %v", r.name, r.line, err)
+			return false
+		}
+		r.symbols.Resolve(&rec)
+		r.rec = rec
+		return true
+	}
+	if err := r.scanner.Err(); err != nil {
+		r.err = fmt.Errorf("failed to read trace: %v", err)
+	}
+	return false
+}
+
+// Record returns the record read by the last call to Next.
+//
+// Returns:
+//   - tracer.TraceRecord: the record.
+func (r *Reader) Record() tracer.TraceRecord {
+	return r.rec
+}
+
+// Err returns the error that stopped Next, if any.
+//
+// Returns:
+//   - error: the error, or nil at the end of a valid stream.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// All returns an iterator over the remaining records. An error ends the iteration after being
+// yielded with a zero record.
+//
+// Returns:
+//   - iter.Seq2[tracer.TraceRecord, error]: the iterator.
+func (r *Reader) All() iter.Seq2[tracer.TraceRecord, error] {
+	return func(yield func(tracer.TraceRecord, error) bool) {
+		for r.Next() {
+			if !yield(r.rec, nil) {
+				return
+			}
+		}
+		if r.err != nil {
+			yield(tracer.TraceRecord{}, r.err)
+		}
+	}
+}
+
+// Close closes the file opened by Open. It does nothing for readers created with NewReader.
+//
+// Returns:
+//   - error: an error if the file cannot be closed.
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// ReadFile reads all records of the trace file at path.
+//
+// Parameters:
+//   - path (string): the path to the trace file.
+//
+// Returns:
+//   - []tracer.TraceRecord: the records in file order.
+//   - error: an error if the file cannot be read or a line is not a valid record.
+func ReadFile(path string) ([]tracer.TraceRecord, error) {
+	r, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var records []tracer.TraceRecord
+	for r.Next() {
+		records = append(records, r.Record())
+	}
+	return records, r.Err()
+}
+
+// ReadMetadata reads the run metadata file at path.
+//
+// Parameters:
+//   - path (string): the path to the file, e.g. "tracewrap/latest/run.json".
+//
+// Returns:
+//   - tracer.RunMetadata: the metadata.
+//   - error: an error if the file cannot be read or is not valid metadata.
+func ReadMetadata(path string) (tracer.RunMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return tracer.RunMetadata{}, err
+	}
+	meta, err := tracer.DecodeRunMetadata(data)
+	if err != nil {
+		return meta, fmt.Errorf("%s: invalid run metadata: %v", path, err)
+	}
+	return meta, nil
+}
+
+// Writer writes trace records as JSON Lines in the format the tracer writes, so its output can be
+// read by the tracewrap commands.
+type Writer struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewWriter returns a Writer writing to w. Call Flush when done.
+//
+// Parameters:
+//   - w (io.Writer): the destination.
+//
+// Returns:
+//   - *Writer: the writer.
+func NewWriter(w io.Writer) *Writer {
+	buf := bufio.NewWriter(w)
+	return &Writer{w: buf, enc: json.NewEncoder(buf)}
+}
+
+// Write writes rec, stamped with the current tracer.SchemaVersion.
+//
+// Parameters:
+//   - rec (tracer.TraceRecord): the record.
+//
+// Returns:
+//   - error: an error if the record cannot be encoded or written.
+func (w *Writer) Write(rec tracer.TraceRecord) error {
+	rec.SchemaVersion = tracer.SchemaVersion
+	if err := w.enc.Encode(&rec); err != nil {
+		return fmt.Errorf("failed to write trace record %d: %v", rec.UniqueID, err)
+	}
+	return nil
+}
+
+// Flush writes any buffered records to the destination.
+//
+// Returns:
+//   - error: an error if the destination cannot be written.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// WriteMetadata writes run metadata to path in the format of run.json.
+//
+// Parameters:
+//   - path (string): the destination file.
+//   - meta (tracer.RunMetadata): the metadata.
+//
+// Returns:
+//   - error: an error if the file cannot be written.
+func WriteMetadata(path string, meta tracer.RunMetadata) error {
+	meta.SchemaVersion = tracer.SchemaVersion
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,99 @@
+package traceio_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/traceio"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestWriterOutputReadsBack(t *testing.T) {
+	var buf bytes.Buffer
+	w := traceio.NewWriter(&buf)
+	for i, name := range []string{"main", "work"} {
+		rec := tracer.TraceRecord{UniqueID: int64(i + 1), FunctionName: name, Duration: time.Millisecond}
+		if err := w.Write(rec); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+
+	var names []string
+	for rec, err := range traceio.NewReader(&buf).All() {
+		if err != nil {
+			t.Fatalf("Reading returned error: %v", err)
+		}
+		if rec.SchemaVersion != tracer.SchemaVersion || rec.Duration != time.Millisecond {
+			t.Errorf("Unexpected record %+v", rec)
+		}
+		names = append(names, rec.FunctionName)
+	}
+	if strings.Join(names, ",") != "main,work" {
+		t.Errorf("Expected main,work, got %v", names)
+	}
+}
+
+func TestReaderResolvesIDsWithSymbols(t *testing.T) {
+	input := `{"uniqueId":1,"functionId":2}` + "\n" + `{"uniqueId":2,"functionId":2}` + "\n"
+	r := traceio.NewReader(strings.NewReader(input))
+	if !r.Next() || r.Record().FunctionName != "" {
+		t.Fatalf("Expected the record as written, got %+v, %v", r.Record(), r.Err())
+	}
+	r.SetSymbols(tracer.SymbolTable{Names: []string{"main", "work"}})
+	if !r.Next() || r.Record().FunctionName != "work" {
+		t.Errorf("Expected the ID resolved to work, got %+v, %v", r.Record(), r.Err())
+	}
+}
+
+func TestReaderMigratesAndReportsLine(t *testing.T) {
+	input := `{"uniqueId":1,"functionName":"main","entryTime":"2024-05-01T10:00:00Z","duration":5}
+
+not json
+`
+	r := traceio.NewReader(strings.NewReader(input))
+	if !r.Next() {
+		t.Fatalf("Expected a record, got error %v", r.Err())
+	}
+	if rec := r.Record(); rec.ExitNanos-rec.EntryNanos != 5 {
+		t.Errorf("Expected the version 1 record to be migrated, got %+v", rec)
+	}
+	if r.Next() {
+		t.Fatalf("Expected the invalid line to stop the reader")
+	}
+	if err := r.Err(); err == nil || !strings.HasPrefix(err.Error(), "line:3:") {
+		t.Errorf("Expected an error for line 3, got %v", err)
+	}
+}
+
+func TestReadFileAndMetadata(t *testing.T) {
+	dir, err := os.MkdirTemp("", "traceiotest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	trace := filepath.Join(dir, "trace.jsonl")
+	if err := os.WriteFile(trace, []byte(`{"schemaVersion":2,"uniqueId":7,"functionName":"work"}`+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write trace: %v", err)
+	}
+	records, err := traceio.ReadFile(trace)
+	if err != nil || len(records) != 1 || records[0].UniqueID != 7 {
+		t.Fatalf("Expected record 7, got %+v (%v)", records, err)
+	}
+
+	meta := filepath.Join(dir, "run.json")
+	if err := traceio.WriteMetadata(meta, tracer.RunMetadata{RunID: "20240501-100000-42", Records: 1}); err != nil {
+		t.Fatalf("WriteMetadata returned error: %v", err)
+	}
+	got, err := traceio.ReadMetadata(meta)
+	if err != nil || got.RunID != "20240501-100000-42" || got.SchemaVersion != tracer.SchemaVersion {
+		t.Errorf("Unexpected metadata %+v (%v)", got, err)
+	}
+}
@@ -0,0 +1,108 @@
+package tracer
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Layout of the artifacts written by instrumented binaries: every run writes its log, trace,
+// metadata and call graph to ArtifactRoot/<run ID>/, and ArtifactRoot/LatestRun links to the
+// directory of the most recent run.
+const (
+	ArtifactRoot = "tracewrap"
+	LatestRun    = "latest"
+	// RunIDEnv names the environment variable that, when set, gives the run ID to use instead of
+	// generating one. tracewrap sets it for the binaries it starts so it knows where they write.
+	RunIDEnv = "TRACEWRAP_RUN_ID"
+)
+
+// runID and runDir identify the current run's artifact directory; set by initRunDirectory.
+var (
+	runID  string
+	runDir = ArtifactRoot
+)
+
+// NewRunID returns the run ID for a run started at t by process pid, e.g. "20240101-120000-4242".
+// IDs of runs started in different seconds sort in start order.
+//
+// Parameters:
+//   - t (time.Time): the start time of the run.
+//   - pid (int): the process ID.
+//
+// Returns:
+//   - string: the run ID.
+func NewRunID(t time.Time, pid int) string {
+	return t.UTC().Format("20060102-150405") + "-" + strconv.Itoa(pid)
+}
+
+// RunID returns the ID of the current run, which names its artifact directory.
+func RunID() string {
+	ensureInitialized()
+	return runID
+}
+
+// ArtifactPath returns the path of the named artifact, such as "callgraph.dot", in the current
+// run's directory.
+//
+// Parameters:
+//   - name (string): the file name.
+//
+// Returns:
+//   - string: the path, e.g. "tracewrap/20240101-120000-4242/callgraph.dot".
+func ArtifactPath(name string) string {
+	ensureInitialized()
+	return artifactPath(name)
+}
+
+// artifactPath is ArtifactPath for callers inside the tracer that already ran initialization.
+func artifactPath(name string) string {
+	return filepath.Join(runDir, name)
+}
+
+// initRunDirectory picks the run ID, creates the run's artifact directory and points the latest
+// link at it.
+//
+// Returns:
+//   - error: an error if the directory cannot be created. Failing to update the link only
+//     affects convenience, so it is not reported here.
+func initRunDirectory() error {
+	runID = os.Getenv(RunIDEnv)
+	if runID == "" {
+		runID = NewRunID(startedAt, os.Getpid())
+	}
+	runDir = filepath.Join(ArtifactRoot, runID)
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		return err
+	}
+	_ = UpdateLatestLink(ArtifactRoot, runID)
+	return nil
+}
+
+// UpdateLatestLink points root/LatestRun at the run directory id inside root. An existing link
+// is replaced; a regular file or directory of that name is left alone.
+//
+// Parameters:
+//   - root (string): the artifact root, usually ArtifactRoot.
+//   - id (string): the run ID.
+//
+// Returns:
+//   - error: an error if the link cannot be created, e.g. where symlinks are not permitted.
+func UpdateLatestLink(root, id string) error {
+	link := filepath.Join(root, LatestRun)
+	info, err := os.Lstat(link)
+	switch {
+	case err == nil && info.Mode()&fs.ModeSymlink == 0:
+		return errors.New(link + " exists and is not a symbolic link")
+	case err == nil:
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	return os.Symlink(id, link)
+}
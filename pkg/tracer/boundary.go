@@ -0,0 +1,77 @@
+package tracer
+
+import (
+	"runtime"
+	"strings"
+)
+
+// siteDepth is the number of frames callBoundary reads of a call without a caller on its
+// goroutine: enough for the tracer's own frames, the called function's and the one calling it.
+const siteDepth = 8
+
+// boundaryDepth is the number of frames callBoundary reads at first of a call with a caller on its
+// goroutine: enough for a caller that calls the function directly or from a function literal.
+const boundaryDepth = 16
+
+// maxBoundaryDepth is the number of frames callBoundary reads at most, the tracer's own included,
+// when the caller's frame is not among the first boundaryDepth. A call made further below its
+// caller, through that much code that is not instrumented, is not marked.
+const maxBoundaryDepth = 64
+
+// tracerPackage is the prefix of the runtime names of the tracer's functions, e.g.
+// "github.com/mwiater/tracewrap/pkg/tracer.".
+var tracerPackage = packagePrefix()
+
+// packagePrefix returns the prefix of the runtime name of this function up to its package.
+func packagePrefix() string {
+	pc, _, _, _ := runtime.Caller(0)
+	name := runtime.FuncForPC(pc).Name()
+	return name[:strings.LastIndex(name, ".")+1]
+}
+
+// callBoundary reads the stack of the call being entered, without the tracer's frames, and returns
+// the called function, named as the runtime names it, e.g. "main.(*Server).handle", and whether
+// the call was made through code that is not instrumented:
+//   - with caller, the function of the open caller on the same goroutine, whether a function other
+//     than the caller lies between them on the stack. Function literals of the caller are its own
+//     code. Only the frames up to the caller's are read, and at most maxBoundaryDepth of them: a
+//     call further below its caller, or whose caller is not on the stack, is not marked.
+//   - with root set instead, for a call without a caller, whether the function calling it is
+//     anything but the runtime starting the program or a goroutine: a call of an instrumented
+//     function from code that is not.
+//
+// The frames are read without allocating. Called by enterRecord before it takes mu.
+func callBoundary(caller string, root bool) (function string, via bool) {
+	var pcs [maxBoundaryDepth]uintptr
+	depth := boundaryDepth
+	if caller == "" {
+		depth = siteDepth
+	}
+	for ; ; depth = len(pcs) {
+		n := runtime.Callers(2, pcs[:depth])
+		between := false
+		function = ""
+		for _, pc := range pcs[:n] {
+			// Named as the innermost function at pc, which may be inlined into the function of its frame.
+			name := runtime.FuncForPC(pc - 1).Name()
+			switch {
+			case function == "":
+				if !strings.HasPrefix(name, tracerPackage) {
+					function = name
+					if caller == "" && !root {
+						return function, false
+					}
+				}
+			case caller == "":
+				return function, !strings.HasPrefix(name, "runtime.")
+			case name == caller:
+				return function, between
+			case !strings.HasPrefix(name, caller+"."):
+				between = true
+			}
+		}
+		if n < depth || depth == len(pcs) {
+			return function, false
+		}
+	}
+}
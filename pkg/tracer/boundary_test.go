@@ -0,0 +1,142 @@
+package tracer_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// The boundary functions stand for instrumented functions, entering their calls like the
+// injected code does; untraced stands for code that is not instrumented, such as a library
+// taking a callback.
+func boundaryHandler() {
+	start := tracer.TakeSnapshot(tracer.MeasureTime)
+	span := tracer.RecordEntry("handler")
+	defer span.RecordAll(start)
+	boundaryLeaf("direct")
+	func() { boundaryLeaf("inLiteral") }()
+	untraced(boundaryCallback)
+}
+
+func boundaryCallback() { boundaryLeaf("viaLibrary") }
+
+func boundaryLeaf(name string) {
+	start := tracer.TakeSnapshot(tracer.MeasureTime)
+	span := tracer.RecordEntry(name)
+	defer span.RecordAll(start)
+}
+
+func untraced(f func()) { f() }
+
+// boundaryWorker stands for an instrumented function run as a goroutine of its own.
+func boundaryWorker(done chan struct{}) {
+	defer close(done)
+	start := tracer.TakeSnapshot(tracer.MeasureTime)
+	span := tracer.RecordEntry("worker")
+	defer span.RecordAll(start)
+}
+
+func TestCallsThroughUninstrumentedCodeAreMarked(t *testing.T) {
+	withTracer(t, config.Config{})
+	boundaryHandler()
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("ReadTraceFile returned error: %v", err)
+	}
+	byName := make(map[string]tracer.TraceRecord)
+	for _, rec := range records {
+		byName[rec.FunctionName] = rec
+	}
+	handler := byName["handler"]
+	for name, via := range map[string]bool{"direct": false, "inLiteral": false, "viaLibrary": true} {
+		if rec := byName[name]; rec.CallerID != handler.UniqueID || rec.ViaUninstrumented != via {
+			t.Errorf("Expected %s to be called by handler with viaUninstrumented %v, got caller %d and %v", name, via, rec.CallerID, rec.ViaUninstrumented)
+		}
+	}
+	if !handler.ViaUninstrumented {
+		t.Errorf("Expected the root call, made by the test function, to be marked")
+	}
+}
+
+// nested calls f below depth frames of its own.
+func nested(depth int, f func()) {
+	if depth == 0 {
+		f()
+		return
+	}
+	nested(depth-1, f)
+}
+
+func TestDeepCallsThroughUninstrumentedCodeAreMarked(t *testing.T) {
+	withTracer(t, config.Config{})
+	for _, depth := range []int{40, 100} {
+		start := tracer.TakeSnapshot(tracer.MeasureTime)
+		span := tracer.RecordEntry("handler")
+		nested(depth, boundaryCallback)
+		span.RecordAll(start)
+	}
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("ReadTraceFile returned error: %v", err)
+	}
+	var marks []bool
+	for _, rec := range records {
+		if rec.FunctionName == "viaLibrary" {
+			marks = append(marks, rec.ViaUninstrumented)
+		}
+	}
+	// Calls further than tracer's search depth below their caller are not marked.
+	if len(marks) != 2 || !marks[0] || marks[1] {
+		t.Errorf("Expected the call 40 frames below its caller to be marked and the one 100 below not, got %v", marks)
+	}
+}
+
+func TestGoroutineRootsAreNotMarked(t *testing.T) {
+	withTracer(t, config.Config{})
+	done := make(chan struct{})
+	go boundaryWorker(done)
+	<-done
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("ReadTraceFile returned error: %v", err)
+	}
+	if len(records) != 1 || records[0].ViaUninstrumented {
+		t.Errorf("Expected the call of the goroutine's function not to be marked, got %+v", records)
+	}
+}
+
+func TestAggregatedCallGraphMarksCallsThroughUninstrumentedCode(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{MaxRecordsInMemory: 1}})
+	boundaryHandler()
+	if err := tracer.DumpCallGraphDOT("callgraph.dot"); err != nil {
+		t.Fatalf("DumpCallGraphDOT returned error: %v", err)
+	}
+	data, err := os.ReadFile("callgraph.dot")
+	if err != nil {
+		t.Fatalf("Failed to read DOT file: %v", err)
+	}
+	dot := string(data)
+	for _, want := range []string{
+		`0 [label="uninstrumented code", style=dashed`,
+		`0 -> 2 [label="1 via uninstrumented code", style=dashed`, // The root call of handler.
+		`2 -> 4 [label="1 via uninstrumented code", style=dashed`, // handler -> viaLibrary.
+		`2 -> 1 [label="1 calls"]`,                                // handler -> direct.
+		"calls via uninstrumented code",
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("Expected %q in the aggregated call graph:\n%s", want, dot)
+		}
+	}
+}
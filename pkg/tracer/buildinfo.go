@@ -0,0 +1,106 @@
+package tracer
+
+import (
+	"runtime/debug"
+	"strconv"
+	"sync"
+)
+
+// BuildInfo identifies the build of the instrumented binary, from the module information the go
+// command stamps into it (see debug.ReadBuildInfo) and the git state of the project the
+// instrumenter stamps in (see vcsRevision). The VCS fields are empty when the project is not in a
+// git repository; a binary built by other means has those of the go command.
+type BuildInfo struct {
+	Module   string `json:"module"`             // The main module path, e.g. "example.com/app".
+	Version  string `json:"version,omitempty"`  // The main module version, "(devel)" for a local build.
+	Revision string `json:"revision,omitempty"` // The VCS revision, e.g. a git commit hash.
+	Time     string `json:"time,omitempty"`     // The time of the revision, RFC 3339.
+	// Modified is set when tracked files of the project had uncommitted changes.
+	Modified bool `json:"modified,omitempty"`
+}
+
+// Attribute names of BuildInfo.Attributes, following the OpenTelemetry semantic conventions
+// where they have one.
+const (
+	AttrModule   = "go.module.path"
+	AttrVersion  = "service.version"
+	AttrRevision = "vcs.ref.head.revision"
+	AttrModified = "vcs.modified"
+)
+
+// vcsRevision, vcsTime and vcsModified ("true" or "false") are the git state of the project,
+// which the instrumenter reads from the project directory and stamps into instrumented binaries
+// alongside embeddedConfig. The go command's VCS stamping would describe the workspace copy the
+// binary is built in, which is not the project's repository.
+var (
+	vcsRevision string
+	vcsTime     string
+	vcsModified string
+)
+
+var (
+	buildInfoOnce sync.Once
+	buildInfo     *BuildInfo
+)
+
+// CurrentBuild returns the build info of the running binary, read once.
+//
+// Returns:
+//   - *BuildInfo: the build info, or nil for a binary built without module support.
+func CurrentBuild() *BuildInfo {
+	buildInfoOnce.Do(func() {
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			buildInfo = buildInfoOf(bi)
+		}
+	})
+	return buildInfo
+}
+
+// buildInfoOf extracts the BuildInfo of bi, with the stamped git state of the project instead of
+// the VCS settings of bi if the instrumenter stamped one.
+func buildInfoOf(bi *debug.BuildInfo) *BuildInfo {
+	b := &BuildInfo{Module: bi.Main.Path, Version: bi.Main.Version}
+	if vcsRevision != "" {
+		b.Revision, b.Time, b.Modified = vcsRevision, vcsTime, vcsModified == "true"
+		return b
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.time":
+			b.Time = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+}
+
+// Attributes returns the build info as resource or span attributes for an APM: the module path,
+// the version unless it is "(devel)", the revision and whether the tree was modified, when known.
+//
+// Returns:
+//   - map[string]string: the attributes, by AttrModule, AttrVersion, AttrRevision and AttrModified.
+func (b *BuildInfo) Attributes() map[string]string {
+	if b == nil {
+		return nil
+	}
+	attrs := map[string]string{AttrModule: b.Module}
+	if b.Version != "" && b.Version != "(devel)" {
+		attrs[AttrVersion] = b.Version
+	}
+	if b.Revision != "" {
+		attrs[AttrRevision] = b.Revision
+		attrs[AttrModified] = strconv.FormatBool(b.Modified)
+	}
+	return attrs
+}
+
+// ResourceBuildInfo reports whether tracing.resourceBuildInfo asks for the build info attributes
+// on the resource of the OpenTelemetry SDK the bridge wraps. It decodes the embedded configuration
+// without initializing the tracer, so package init functions can call it.
+func ResourceBuildInfo() bool {
+	cfg, _ := decodeEmbeddedConfig()
+	return cfg.Tracing.ResourceBuildInfo
+}
@@ -0,0 +1,73 @@
+package tracer_test
+
+import (
+	"encoding/json"
+	"os"
+	"runtime/debug"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestBuildInfoAttributes(t *testing.T) {
+	b := tracer.BuildInfoOf(&debug.BuildInfo{
+		Main: debug.Module{Path: "example.com/app", Version: "v1.4.0"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123abcd"},
+			{Key: "vcs.time", Value: "2024-05-01T10:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	})
+	want := tracer.BuildInfo{Module: "example.com/app", Version: "v1.4.0", Revision: "0123abcd", Time: "2024-05-01T10:00:00Z", Modified: true}
+	if *b != want {
+		t.Fatalf("Expected %+v, got %+v", want, *b)
+	}
+	attrs := b.Attributes()
+	if len(attrs) != 4 || attrs[tracer.AttrModule] != "example.com/app" || attrs[tracer.AttrVersion] != "v1.4.0" ||
+		attrs[tracer.AttrRevision] != "0123abcd" || attrs[tracer.AttrModified] != "true" {
+		t.Errorf("Unexpected attributes: %v", attrs)
+	}
+
+	local := tracer.BuildInfoOf(&debug.BuildInfo{Main: debug.Module{Path: "example.com/app", Version: "(devel)"}})
+	if attrs := local.Attributes(); len(attrs) != 1 || attrs[tracer.AttrModule] != "example.com/app" {
+		t.Errorf("Expected only the module of a local build outside VCS, got %v", attrs)
+	}
+	var none *tracer.BuildInfo
+	if none.Attributes() != nil {
+		t.Error("Expected no attributes without build info")
+	}
+}
+
+func TestStampedVCSReplacesTheGoCommands(t *testing.T) {
+	defer tracer.StampVCS("89abcdef", "2024-06-01T09:00:00Z", "false")()
+	// The go command stamped the workspace the binary was built in.
+	b := tracer.BuildInfoOf(&debug.BuildInfo{
+		Main:     debug.Module{Path: "example.com/app", Version: "(devel)"},
+		Settings: []debug.BuildSetting{{Key: "vcs.revision", Value: "0123abcd"}, {Key: "vcs.modified", Value: "true"}},
+	})
+	want := tracer.BuildInfo{Module: "example.com/app", Version: "(devel)", Revision: "89abcdef", Time: "2024-06-01T09:00:00Z"}
+	if *b != want {
+		t.Errorf("Expected %+v, got %+v", want, *b)
+	}
+}
+
+func TestRunMetadataRecordsBuildInfo(t *testing.T) {
+	withTracer(t, config.Config{})
+	call("worker", func() {})
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	data, err := os.ReadFile("tracewrap/latest/run.json")
+	if err != nil {
+		t.Fatalf("Failed to read run metadata: %v", err)
+	}
+	var meta tracer.RunMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("Failed to parse run metadata: %v", err)
+	}
+	// Test binaries carry the build info of the module under test.
+	if meta.Build == nil || meta.Build.Module != "github.com/mwiater/tracewrap" {
+		t.Errorf("Expected the build info of the test binary, got %+v", meta.Build)
+	}
+}
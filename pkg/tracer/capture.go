@@ -0,0 +1,705 @@
+package tracer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mwiater/tracewrap/config"
+)
+
+// Capture encodings accepted by tracing.capture.encoding.
+const (
+	EncodingText = "text" // Like fmt's %+v, the default.
+	EncodingJSON = "json" // JSON, with values beyond the limits replaced by "...".
+	EncodingDump = "dump" // Type-annotated, in the style of go-spew.
+)
+
+// maxCaptureDepth bounds the nesting followed when no depth is configured, which protects
+// against cyclic data structures.
+const maxCaptureDepth = 16
+
+// Opaque stands in for a parameter or return value recorded by its type name only, such as
+// "func(int) error". The instrumentation passes it for functions, channels and unsafe pointers,
+// whose formatted form is an address at best and whose contents may be in use by other
+// goroutines. It is rendered as "<func(int) error>".
+type Opaque string
+
+// formatValue renders a parameter or return value according to tracing.capture, cut at
+// tracing.capture.maxValueBytes. With no limits and the text encoding it is exactly
+// fmt.Sprintf("%+v", v). With maxValueBytes, values other than those of basic kinds are rendered
+// into a boundedWriter, only as far as the cut.
+func formatValue(v interface{}) string {
+	return limitValue(renderValue(v), &activeConfig.Tracing.Capture)
+}
+
+// renderValue is formatValue without the cut at tracing.capture.maxValueBytes, past which it may
+// stop rendering.
+func renderValue(v interface{}) string {
+	c := activeConfig.Tracing.Capture
+	if o, ok := v.(Opaque); ok {
+		if c.Encoding == EncodingJSON {
+			return strconv.Quote("<" + string(o) + ">")
+		}
+		return "<" + string(o) + ">"
+	}
+	if s, ok := sizeOnlyValue(v); ok {
+		return s
+	}
+	if c.RaceSafe && v != nil {
+		// Only the copy made when v was passed is read; methods such as String are not called.
+		p := primitiveOf(v)
+		if p == nil {
+			return renderValue(Opaque(reflect.TypeOf(v).String()))
+		}
+		v = p.Value
+	}
+	w := &boundedWriter{limit: c.MaxValueBytes}
+	switch c.Encoding {
+	case EncodingJSON:
+		e := jsonEncoder{w: w, cfg: &c}
+		e.encode(reflect.ValueOf(v), 0)
+		if e.err != nil {
+			return fmt.Sprintf("<json error: %v>", e.err)
+		}
+		return w.String()
+	case EncodingDump:
+		e := valueEncoder{sb: w, cfg: &c, dump: true, seen: make(map[uintptr]bool)}
+		e.encode(reflect.ValueOf(v), 0)
+		return w.String()
+	default:
+		if c.Depth <= 0 && c.MaxElements <= 0 && c.MaxStringLength <= 0 {
+			// Basic kinds skip fmt's reflection-based formatting; the output is identical.
+			switch x := v.(type) {
+			case string:
+				return x
+			case int:
+				return strconv.Itoa(x)
+			case int64:
+				return strconv.FormatInt(x, 10)
+			case bool:
+				return strconv.FormatBool(x)
+			case float64:
+				return strconv.FormatFloat(x, 'g', -1, 64)
+			}
+			if c.MaxValueBytes <= 0 {
+				return fmt.Sprintf("%+v", v)
+			}
+		}
+		e := valueEncoder{sb: w, cfg: &c, seen: make(map[uintptr]bool)}
+		e.encode(reflect.ValueOf(v), 0)
+		return w.String()
+	}
+}
+
+// boundedWriter collects a rendered value up to limit bytes and one more, which tells limitValue
+// that the value is longer, and discards the rest, so the encoders stop rendering once it is full.
+// A limit of 0 keeps everything.
+type boundedWriter struct {
+	sb    strings.Builder
+	limit int
+}
+
+// Write implements io.Writer, for fmt.Fprintf.
+func (w *boundedWriter) Write(p []byte) (int, error) {
+	if room := w.room(); room < len(p) {
+		w.sb.Write(p[:room])
+	} else {
+		w.sb.Write(p)
+	}
+	return len(p), nil
+}
+
+// WriteString writes s, or as much of it as the limit keeps.
+func (w *boundedWriter) WriteString(s string) (int, error) {
+	if room := w.room(); room < len(s) {
+		w.sb.WriteString(s[:room])
+	} else {
+		w.sb.WriteString(s)
+	}
+	return len(s), nil
+}
+
+// room returns the number of bytes w still keeps.
+func (w *boundedWriter) room() int {
+	if w.limit <= 0 {
+		return math.MaxInt
+	}
+	return max(w.limit+1-w.sb.Len(), 0)
+}
+
+// full reports whether w keeps no more bytes.
+func (w *boundedWriter) full() bool {
+	return w.room() == 0
+}
+
+// String returns the bytes kept.
+func (w *boundedWriter) String() string {
+	return w.sb.String()
+}
+
+// materializeRecords renders the values kept by tracing.capture.lazy into Params and
+// ReturnValues. Records without pending values are left untouched. Callers must hold mu.
+func materializeRecords(records []*TraceRecord) {
+	for _, rec := range records {
+		for name, v := range rec.rawParams {
+			if rec.Params == nil {
+				rec.Params = make(map[string]string)
+			}
+			rec.Params[name] = formatValue(v)
+			recordTypedParam(rec, name, v)
+		}
+		for _, v := range rec.rawReturns {
+			rec.ReturnValues = append(rec.ReturnValues, formatValue(v))
+		}
+		if len(rec.rawReturns) > 0 {
+			recordTypedReturns(rec, rec.rawReturns)
+		}
+		rec.rawParams = nil
+		rec.rawReturns = nil
+	}
+}
+
+// depthLimit returns the effective depth limit for c.
+func depthLimit(c *config.CaptureConfig) int {
+	if c.Depth <= 0 || c.Depth > maxCaptureDepth {
+		return maxCaptureDepth
+	}
+	return c.Depth
+}
+
+// truncate shortens s to the configured maximum string length.
+func truncate(s string, c *config.CaptureConfig) string {
+	if c.MaxStringLength > 0 && len(s) > c.MaxStringLength {
+		return s[:c.MaxStringLength] + "..."
+	}
+	return s
+}
+
+// limitValue cuts the rendered value s to c.MaxValueBytes, on a character boundary, and marks
+// the cut with "...". With the JSON encoding the cut value is recorded as a JSON string, so it
+// stays valid JSON.
+func limitValue(s string, c *config.CaptureConfig) string {
+	if c.MaxValueBytes <= 0 || len(s) <= c.MaxValueBytes {
+		return s
+	}
+	cut := c.MaxValueBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	if c.Encoding == EncodingJSON {
+		return strconv.Quote(s[:cut] + "...")
+	}
+	return s[:cut] + "..."
+}
+
+// paramCapped reports whether recording the parameter name on rec would exceed
+// tracing.capture.maxParams, as rec holds that many other parameters already. Callers must hold
+// mu.
+func paramCapped(rec *TraceRecord, name string) bool {
+	limit := activeConfig.Tracing.Capture.MaxParams
+	if limit <= 0 {
+		return false
+	}
+	if _, ok := rec.Variadic[name]; ok || hasParam(rec, name) {
+		return false
+	}
+	return paramCount(rec) >= limit
+}
+
+// hasParam reports whether rec holds a value of the parameter name. Callers must hold mu.
+func hasParam(rec *TraceRecord, name string) bool {
+	if _, ok := rec.Params[name]; ok {
+		return true
+	}
+	_, ok := rec.rawParams[name]
+	return ok
+}
+
+// paramCount returns the number of parameters recorded on rec, in which a variadic parameter
+// counts once, however many of its arguments are recorded. Callers must hold mu.
+func paramCount(rec *TraceRecord) int {
+	n := len(rec.Params) + len(rec.rawParams) - rec.variadicArgs
+	for name := range rec.Variadic {
+		if !hasParam(rec, name) {
+			n++
+		}
+	}
+	return n
+}
+
+// defaultMaxVariadicArgs is the number of arguments of a variadic parameter recorded one by one
+// when tracing.capture.maxVariadicArgs is not set.
+const defaultMaxVariadicArgs = 16
+
+// variadicArgLimit returns how many of the n arguments of a variadic parameter are recorded.
+func variadicArgLimit(n int, c *config.CaptureConfig) int {
+	limit := c.MaxVariadicArgs
+	if limit <= 0 {
+		limit = defaultMaxVariadicArgs
+	}
+	return min(n, limit)
+}
+
+// cappedReturns returns the first of returns that rec can still record within
+// tracing.capture.maxReturns. Callers must hold mu.
+func cappedReturns(rec *TraceRecord, returns []interface{}) []interface{} {
+	limit := activeConfig.Tracing.Capture.MaxReturns
+	if limit <= 0 {
+		return returns
+	}
+	room := max(limit-len(rec.ReturnValues)-len(rec.rawReturns), 0)
+	return returns[:min(len(returns), room)]
+}
+
+// elementLimit returns how many of n elements are rendered.
+func elementLimit(n int, c *config.CaptureConfig) int {
+	if c.MaxElements > 0 && n > c.MaxElements {
+		return c.MaxElements
+	}
+	return n
+}
+
+// valueEncoder renders values in the text (%+v-like) or dump encoding.
+type valueEncoder struct {
+	sb   *boundedWriter
+	cfg  *config.CaptureConfig
+	dump bool
+	seen map[uintptr]bool // Pointers on the current path, to detect cycles.
+}
+
+func (e *valueEncoder) encode(v reflect.Value, depth int) {
+	if e.sb.full() {
+		return
+	}
+	if !v.IsValid() {
+		e.sb.WriteString("<nil>")
+		return
+	}
+	if e.dump {
+		fmt.Fprintf(e.sb, "(%s) ", v.Type())
+	}
+	if s, ok := stringMethod(v); ok {
+		if e.dump {
+			e.sb.WriteString(strconv.Quote(truncate(s, e.cfg)))
+		} else {
+			e.sb.WriteString(truncate(s, e.cfg))
+		}
+		return
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			e.sb.WriteString("<nil>")
+			return
+		}
+		if e.seen[v.Pointer()] {
+			e.sb.WriteString("<cycle>")
+			return
+		}
+		e.seen[v.Pointer()] = true
+		defer delete(e.seen, v.Pointer())
+		if !e.dump {
+			e.sb.WriteString("&")
+		}
+		e.encode(v.Elem(), depth)
+	case reflect.Interface:
+		e.encode(v.Elem(), depth)
+	case reflect.Struct:
+		if depth >= depthLimit(e.cfg) {
+			e.sb.WriteString("{...}")
+			return
+		}
+		e.sb.WriteString("{")
+		for i := 0; i < v.NumField(); i++ {
+			if i > 0 {
+				e.sb.WriteString(e.separator())
+			}
+			e.sb.WriteString(v.Type().Field(i).Name)
+			e.sb.WriteString(":")
+			if e.dump {
+				e.sb.WriteString(" ")
+			}
+			e.encode(v.Field(i), depth+1)
+		}
+		e.sb.WriteString("}")
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() && e.dump {
+			e.sb.WriteString("<nil>")
+			return
+		}
+		if depth >= depthLimit(e.cfg) {
+			e.sb.WriteString("[...]")
+			return
+		}
+		e.sb.WriteString("[")
+		n := elementLimit(v.Len(), e.cfg)
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				e.sb.WriteString(e.separator())
+			}
+			e.encode(v.Index(i), depth+1)
+		}
+		if n < v.Len() {
+			fmt.Fprintf(e.sb, "%s...+%d more", e.separator(), v.Len()-n)
+		}
+		e.sb.WriteString("]")
+	case reflect.Map:
+		if v.IsNil() && e.dump {
+			e.sb.WriteString("<nil>")
+			return
+		}
+		if depth >= depthLimit(e.cfg) {
+			e.sb.WriteString("map[...]")
+			return
+		}
+		keys := v.MapKeys()
+		// fmt sorts map keys too; sorting by rendered key keeps the output deterministic.
+		rendered := make([]string, len(keys))
+		for i, k := range keys {
+			var kb boundedWriter
+			(&valueEncoder{sb: &kb, cfg: e.cfg, seen: e.seen}).encode(k, depth+1)
+			rendered[i] = kb.String()
+		}
+		order := make([]int, len(keys))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(a, b int) bool { return rendered[order[a]] < rendered[order[b]] })
+		e.sb.WriteString("map[")
+		n := elementLimit(len(keys), e.cfg)
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				e.sb.WriteString(e.separator())
+			}
+			e.sb.WriteString(rendered[order[i]])
+			e.sb.WriteString(":")
+			if e.dump {
+				e.sb.WriteString(" ")
+			}
+			e.encode(v.MapIndex(keys[order[i]]), depth+1)
+		}
+		if n < len(keys) {
+			fmt.Fprintf(e.sb, "%s...+%d more", e.separator(), len(keys)-n)
+		}
+		e.sb.WriteString("]")
+	case reflect.String:
+		if e.dump {
+			e.sb.WriteString(strconv.Quote(truncate(v.String(), e.cfg)))
+		} else {
+			e.sb.WriteString(truncate(v.String(), e.cfg))
+		}
+	case reflect.Bool:
+		e.sb.WriteString(strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.sb.WriteString(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.sb.WriteString(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		e.sb.WriteString(strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()))
+	case reflect.Complex64, reflect.Complex128:
+		e.sb.WriteString(strconv.FormatComplex(v.Complex(), 'g', -1, v.Type().Bits()))
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		if v.IsNil() {
+			e.sb.WriteString("<nil>")
+		} else {
+			fmt.Fprintf(e.sb, "%#x", v.Pointer())
+		}
+	default:
+		e.sb.WriteString(v.String())
+	}
+}
+
+// separator returns the element separator of the encoding.
+func (e *valueEncoder) separator() string {
+	if e.dump {
+		return ", "
+	}
+	return " "
+}
+
+// stringMethod returns the result of the value's Error or String method, as fmt would use it.
+// Methods are only called on values that can be accessed through reflection (exported fields).
+func stringMethod(v reflect.Value) (string, bool) {
+	if !v.CanInterface() {
+		return "", false
+	}
+	if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+		return "", false
+	}
+	switch x := v.Interface().(type) {
+	case error:
+		return x.Error(), true
+	case fmt.Stringer:
+		return x.String(), true
+	}
+	return "", false
+}
+
+// jsonValue converts v into a value json.Marshal can encode within the configured limits.
+// Unexported struct fields are included, and anything beyond the limits becomes "...". The
+// jsonEncoder writes the same JSON as json.Marshal of it; jsonValue names map keys for both.
+func jsonValue(v reflect.Value, depth int, c *config.CaptureConfig) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.CanInterface() {
+		if m, ok := v.Interface().(json.Marshaler); ok && !((v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil()) {
+			if data, err := m.MarshalJSON(); err == nil {
+				return json.RawMessage(data)
+			}
+		}
+		if err, ok := v.Interface().(error); ok && !((v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil()) {
+			return truncate(err.Error(), c)
+		}
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if depth >= depthLimit(c) {
+			return "..."
+		}
+		return jsonValue(v.Elem(), depth+1, c)
+	case reflect.Struct:
+		if depth >= depthLimit(c) {
+			return "..."
+		}
+		out := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			out[v.Type().Field(i).Name] = jsonValue(v.Field(i), depth+1, c)
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if depth >= depthLimit(c) {
+			return "..."
+		}
+		n := elementLimit(v.Len(), c)
+		out := make([]interface{}, 0, n+1)
+		for i := 0; i < n; i++ {
+			out = append(out, jsonValue(v.Index(i), depth+1, c))
+		}
+		if n < v.Len() {
+			out = append(out, fmt.Sprintf("...+%d more", v.Len()-n))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		if depth >= depthLimit(c) {
+			return "..."
+		}
+		keys := v.MapKeys()
+		names := make([]string, len(keys))
+		for i, k := range keys {
+			names[i] = fmt.Sprint(jsonValue(k, depth+1, c))
+		}
+		order := make([]int, len(keys))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(a, b int) bool { return names[order[a]] < names[order[b]] })
+		n := elementLimit(len(keys), c)
+		out := make(map[string]interface{}, n+1)
+		for _, i := range order[:n] {
+			out[names[i]] = jsonValue(v.MapIndex(keys[i]), depth+1, c)
+		}
+		if n < len(keys) {
+			out["..."] = fmt.Sprintf("+%d more", len(keys)-n)
+		}
+		return out
+	case reflect.String:
+		return truncate(v.String(), c)
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	default:
+		return v.String()
+	}
+}
+
+// jsonEncoder writes values in the JSON encoding into a boundedWriter, as json.Marshal writes
+// their jsonValue, with struct fields and map keys sorted, and stops once the writer is full.
+type jsonEncoder struct {
+	w   *boundedWriter
+	cfg *config.CaptureConfig
+	err error // The first value json.Marshal would fail to encode, such as NaN.
+}
+
+func (e *jsonEncoder) encode(v reflect.Value, depth int) {
+	if e.w.full() || e.err != nil {
+		return
+	}
+	if !v.IsValid() {
+		e.w.WriteString("null")
+		return
+	}
+	if v.CanInterface() && !((v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil()) {
+		if m, ok := v.Interface().(json.Marshaler); ok {
+			if data, err := m.MarshalJSON(); err == nil {
+				var buf bytes.Buffer
+				if e.err = json.Compact(&buf, data); e.err == nil {
+					e.w.Write(buf.Bytes())
+				}
+				return
+			}
+		}
+		if err, ok := v.Interface().(error); ok {
+			e.leaf(truncate(err.Error(), e.cfg))
+			return
+		}
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			e.w.WriteString("null")
+			return
+		}
+		if depth >= depthLimit(e.cfg) {
+			e.leaf("...")
+			return
+		}
+		e.encode(v.Elem(), depth+1)
+	case reflect.Struct:
+		if depth >= depthLimit(e.cfg) {
+			e.leaf("...")
+			return
+		}
+		order := make([]int, v.NumField())
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(a, b int) bool { return v.Type().Field(order[a]).Name < v.Type().Field(order[b]).Name })
+		e.w.WriteString("{")
+		for n, i := range order {
+			if n > 0 {
+				e.w.WriteString(",")
+			}
+			e.leaf(v.Type().Field(i).Name)
+			e.w.WriteString(":")
+			e.encode(v.Field(i), depth+1)
+		}
+		e.w.WriteString("}")
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.w.WriteString("null")
+			return
+		}
+		if depth >= depthLimit(e.cfg) {
+			e.leaf("...")
+			return
+		}
+		n := elementLimit(v.Len(), e.cfg)
+		e.w.WriteString("[")
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				e.w.WriteString(",")
+			}
+			e.encode(v.Index(i), depth+1)
+		}
+		if n < v.Len() {
+			if n > 0 {
+				e.w.WriteString(",")
+			}
+			e.leaf(fmt.Sprintf("...+%d more", v.Len()-n))
+		}
+		e.w.WriteString("]")
+	case reflect.Map:
+		e.encodeMap(v, depth)
+	case reflect.String:
+		e.leaf(truncate(v.String(), e.cfg))
+	case reflect.Bool:
+		e.leaf(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.leaf(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.leaf(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.leaf(v.Float())
+	default:
+		e.leaf(v.String())
+	}
+}
+
+// encodeMap writes the map v, its keys named by their jsonValue and sorted. As in the map
+// jsonValue returns, the first of the keys with the same name is kept, and the keys past the
+// element limit are counted under the key "...".
+func (e *jsonEncoder) encodeMap(v reflect.Value, depth int) {
+	if v.IsNil() {
+		e.w.WriteString("null")
+		return
+	}
+	if depth >= depthLimit(e.cfg) {
+		e.leaf("...")
+		return
+	}
+	keys := v.MapKeys()
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = fmt.Sprint(jsonValue(k, depth+1, e.cfg))
+	}
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return names[order[a]] < names[order[b]] })
+	n := elementLimit(len(keys), e.cfg)
+	more := n < len(keys)
+	first := true
+	entry := func(name string) {
+		if !first {
+			e.w.WriteString(",")
+		}
+		first = false
+		e.leaf(name)
+		e.w.WriteString(":")
+	}
+	e.w.WriteString("{")
+	for j, i := range order[:n] {
+		name := names[i]
+		if j > 0 && name == names[order[j-1]] || more && name == "..." {
+			continue
+		}
+		if more && name > "..." {
+			entry("...")
+			e.leaf(fmt.Sprintf("+%d more", len(keys)-n))
+			more = false
+		}
+		entry(name)
+		e.encode(v.MapIndex(keys[i]), depth+1)
+	}
+	if more {
+		entry("...")
+		e.leaf(fmt.Sprintf("+%d more", len(keys)-n))
+	}
+	e.w.WriteString("}")
+}
+
+// leaf writes x, a string, bool or number, as json.Marshal does.
+func (e *jsonEncoder) leaf(x interface{}) {
+	if e.w.full() || e.err != nil {
+		return
+	}
+	data, err := json.Marshal(x)
+	if err != nil {
+		e.err = err
+		return
+	}
+	e.w.Write(data)
+}
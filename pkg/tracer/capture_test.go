@@ -0,0 +1,400 @@
+package tracer_test
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+type address struct {
+	City string
+	Zip  string
+}
+
+type customer struct {
+	Name    string
+	Tags    []string
+	Address *address
+	scores  map[string]int
+}
+
+// captureParam records value as a parameter under capture and returns its stored rendering.
+func captureParam(t *testing.T, capture config.CaptureConfig, value interface{}) string {
+	t.Helper()
+	withTracer(t, config.Config{Tracing: config.TracingConfig{Capture: capture}})
+	start := time.Now()
+	tracer.RecordEntry("handle")
+	tracer.RecordParam("v", value)
+	tracer.RecordExit("handle", start)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Expected one record, got %d (%v)", len(records), err)
+	}
+	return records[0].Params["v"]
+}
+
+func sampleCustomer() customer {
+	return customer{
+		Name:    "Ada",
+		Tags:    []string{"a", "b", "c", "d"},
+		Address: &address{City: "London", Zip: "NW1"},
+		scores:  map[string]int{"x": 1, "y": 2},
+	}
+}
+
+func TestCaptureEncodings(t *testing.T) {
+	tests := []struct {
+		name    string
+		capture config.CaptureConfig
+		want    string
+		prefix  bool // want is only the start of the rendering, which goes on with a pointer.
+	}{
+		{"default matches %+v", config.CaptureConfig{}, "{Name:Ada Tags:[a b c d] Address:0x", true},
+		{"text with limits", config.CaptureConfig{Depth: 1, MaxElements: 2}, "{Name:Ada Tags:[...] Address:&{...} scores:map[...]}", false},
+		{"text with element limit", config.CaptureConfig{MaxElements: 2, MaxStringLength: 2}, "{Name:Ad... Tags:[a b ...+2 more] Address:&{City:Lo... Zip:NW...} scores:map[x:1 y:2]}", false},
+		{"json", config.CaptureConfig{Encoding: "json", MaxElements: 3}, `{"Address":{"City":"London","Zip":"NW1"},"Name":"Ada","Tags":["a","b","c","...+1 more"],"scores":{"x":1,"y":2}}`, false},
+		{"dump", config.CaptureConfig{Encoding: "dump", Depth: 1}, `(tracer_test.customer) {Name: (string) "Ada", Tags: ([]string) [...], Address: (*tracer_test.address) (tracer_test.address) {...}, scores: (map[string]int) map[...]}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := captureParam(t, tt.capture, sampleCustomer())
+			if tt.prefix {
+				if !strings.HasPrefix(got, tt.want) {
+					t.Errorf("Expected a rendering starting with %q, got %q", tt.want, got)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Expected %s\n     got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCaptureHandlesCycles(t *testing.T) {
+	type node struct {
+		Name string
+		Next *node
+	}
+	n := &node{Name: "loop"}
+	n.Next = n
+	got := captureParam(t, config.CaptureConfig{Encoding: "text", MaxElements: 5}, n)
+	if got != "&{Name:loop Next:<cycle>}" {
+		t.Errorf("Unexpected rendering of a cyclic value: %s", got)
+	}
+}
+
+func TestLazyCaptureRendersAtFlush(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{Capture: config.CaptureConfig{Lazy: true}}})
+	tags := []string{"before"}
+	start := time.Now()
+	tracer.RecordEntry("handle")
+	tracer.RecordParam("tags", tags)
+	tracer.RecordParam("n", 42)
+	tracer.RecordReturn("handle", tags, nil)
+	tracer.RecordExit("handle", start)
+	// Mutations after the call are visible because rendering is deferred until the flush.
+	tags[0] = "after"
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Expected one record, got %d (%v)", len(records), err)
+	}
+	rec := records[0]
+	if rec.Params["tags"] != "[after]" || rec.Params["n"] != "42" {
+		t.Errorf("Unexpected lazily rendered params: %v", rec.Params)
+	}
+	if len(rec.ReturnValues) != 2 || rec.ReturnValues[0] != "[after]" || rec.ReturnValues[1] != "<nil>" {
+		t.Errorf("Unexpected lazily rendered returns: %v", rec.ReturnValues)
+	}
+}
+
+func TestOpaqueValuesRecordTypeName(t *testing.T) {
+	for _, capture := range []config.CaptureConfig{{}, {Encoding: tracer.EncodingJSON}} {
+		want := "<func(int) error>"
+		if capture.Encoding == tracer.EncodingJSON {
+			want = `"<func(int) error>"`
+		}
+		if got := captureParam(t, capture, tracer.Opaque("func(int) error")); got != want {
+			t.Errorf("Encoding %q: expected %s, got %s", capture.Encoding, want, got)
+		}
+	}
+}
+
+type level int
+
+func (l level) String() string { return "level-" + strconv.Itoa(int(l)) }
+
+func TestRaceSafeCaptureReadsOnlyBasicValues(t *testing.T) {
+	capture := config.CaptureConfig{RaceSafe: true}
+	cust := sampleCustomer()
+	for _, tc := range []struct {
+		value interface{}
+		want  string
+	}{
+		{42, "42"},
+		{"Ada", "Ada"},
+		{level(3), "3"}, // String is not called.
+		{&cust, "<*tracer_test.customer>"},
+		{cust, "<tracer_test.customer>"},
+		{[]string{"a"}, "<[]string>"},
+		{nil, "<nil>"},
+	} {
+		if got := captureParam(t, capture, tc.value); got != tc.want {
+			t.Errorf("Expected %v to be captured as %s, got %s", tc.value, tc.want, got)
+		}
+	}
+}
+
+// TestRaceSafeCaptureWithConcurrentWrites records a pointer the application keeps writing to.
+// Every record must carry the pointer's type name only; under `go test -race` the test also fails
+// if the capture reads the pointed-to value.
+func TestRaceSafeCaptureWithConcurrentWrites(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{Capture: config.CaptureConfig{RaceSafe: true}}})
+	shared := &address{City: "London"}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			shared.Zip = strconv.Itoa(i)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		start := time.Now()
+		tracer.RecordEntry("handle")
+		tracer.RecordParam("addr", shared)
+		tracer.RecordExit("handle", start)
+	}
+	<-done
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 100 {
+		t.Fatalf("Expected 100 records, got %d (%v)", len(records), err)
+	}
+	for _, rec := range records {
+		if got := rec.Params["addr"]; got != "<*tracer_test.address>" {
+			t.Fatalf("Expected the pointer to be captured by type name only, got %s", got)
+		}
+	}
+}
+
+func TestCaptureCapsParamsReturnsAndValueBytes(t *testing.T) {
+	capture := config.CaptureConfig{MaxParams: 2, MaxReturns: 1, MaxValueBytes: 4}
+	withTracer(t, config.Config{Tracing: config.TracingConfig{Capture: capture, CaptureErrorChains: true}})
+	start := time.Now()
+	tracer.RecordEntry("handle")
+	tracer.RecordParam("a", "abcdef")
+	tracer.RecordParam("b", 12)
+	tracer.RecordParam("c", 3)
+	tracer.RecordParam("a", "xyz")
+	tracer.RecordReturn("handle", 7, errors.New("failed"))
+	tracer.RecordExit("handle", start)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Expected one record, got %d (%v)", len(records), err)
+	}
+	rec := records[0]
+	if len(rec.Params) != 2 || rec.Params["a"] != "xyz" || rec.Params["b"] != "12" {
+		t.Errorf("Expected the first two parameters, got %v", rec.Params)
+	}
+	if len(rec.ReturnValues) != 1 || rec.ReturnValues[0] != "7" {
+		t.Errorf("Expected the first return value, got %v", rec.ReturnValues)
+	}
+	if len(rec.ErrorChain) == 0 {
+		t.Errorf("Expected the error past the cap to be seen, got no error chain")
+	}
+
+	if got := captureParam(t, config.CaptureConfig{MaxValueBytes: 4}, "abcdef"); got != "abcd..." {
+		t.Errorf("Expected the value cut at 4 bytes, got %q", got)
+	}
+	if got := captureParam(t, config.CaptureConfig{MaxValueBytes: 4}, "hééé"); got != "hé..." {
+		t.Errorf("Expected the value cut on a character boundary, got %q", got)
+	}
+	if got := captureParam(t, config.CaptureConfig{MaxValueBytes: 4, Encoding: "json"}, []int{1, 2, 3}); got != `"[1,2..."` {
+		t.Errorf("Expected the cut JSON value to be a JSON string, got %q", got)
+	}
+}
+
+// renderCounter counts the times it is rendered.
+type renderCounter struct{ n *int }
+
+func (c renderCounter) String() string {
+	*c.n++
+	return "item"
+}
+
+func TestCaptureStopsRenderingAtValueBytes(t *testing.T) {
+	for _, encoding := range []string{tracer.EncodingText, tracer.EncodingDump, tracer.EncodingJSON} {
+		rendered := 0
+		items := make([]renderCounter, 1000)
+		for i := range items {
+			items[i] = renderCounter{&rendered}
+		}
+		got := captureParam(t, config.CaptureConfig{MaxValueBytes: 16, Encoding: encoding}, items)
+		if !strings.HasSuffix(got, `..."`) && !strings.HasSuffix(got, "...") {
+			t.Errorf("%s: expected the value cut at 16 bytes, got %q", encoding, got)
+		}
+		if rendered > 10 {
+			t.Errorf("%s: expected rendering to stop at the cut, rendered %d of %d items", encoding, rendered, len(items))
+		}
+	}
+}
+
+func TestSetVariadicRecordsEachArgument(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{Capture: config.CaptureConfig{MaxVariadicArgs: 2}}})
+	start := tracer.TakeSnapshot(tracer.MeasureTime)
+	span := tracer.RecordEntry("logf")
+	span.SetVariadic("args", []interface{}{42, "job", nil})
+	span.SetVariadic("fns", []func(){func() {}})
+	span.RecordAll(start)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Expected one record, got %d (%v)", len(records), err)
+	}
+	rec := records[0]
+	if rec.Variadic["args"] != 3 || rec.Variadic["fns"] != 1 {
+		t.Errorf("Expected the argument counts, got %v", rec.Variadic)
+	}
+	want := map[string]string{"args[0]": "42", "args[1]": "job", "fns[0]": "<func()>"}
+	if len(rec.Params) != len(want) {
+		t.Errorf("Expected the first two arguments of args and fns, got %v", rec.Params)
+	}
+	for name, value := range want {
+		if rec.Params[name] != value {
+			t.Errorf("Expected %s = %q, got %q", name, value, rec.Params[name])
+		}
+	}
+	if p := rec.TypedParams["args[0]"]; p.Kind != tracer.KindInt {
+		t.Errorf("Expected args[0] to be typed, got %+v", p)
+	}
+}
+
+func TestVariadicParamCountsOnceAgainstMaxParams(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{Capture: config.CaptureConfig{MaxParams: 2}}})
+	args := make([]interface{}, 20)
+	for i := range args {
+		args[i] = i
+	}
+	start := tracer.TakeSnapshot(tracer.MeasureTime)
+	span := tracer.RecordEntry("logf")
+	span.SetVariadic("args", args)
+	span.SetAttribute("format", "%d")
+	span.SetAttribute("extra", 1)
+	span.RecordAll(start)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Expected one record, got %d (%v)", len(records), err)
+	}
+	rec := records[0]
+	if rec.Params["format"] != "%d" || rec.Params["extra"] != "" {
+		t.Errorf("Expected the variadic parameter to count once, leaving room for format only, got %v", rec.Params)
+	}
+	if rec.Params["args[15]"] != "15" || rec.Params["args[16]"] != "" || rec.Variadic["args"] != 20 {
+		t.Errorf("Expected the first 16 arguments by default, got %v (%v)", rec.Params, rec.Variadic)
+	}
+}
+
+func TestCaptureSizes(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		capture   config.CaptureConfig
+		items     string
+		typedName bool
+	}{
+		{"with values", config.CaptureConfig{Sizes: true}, "[a b]", true},
+		{"sizes only", config.CaptureConfig{SizesOnly: true}, "<[]string len=2 cap=4>", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withTracer(t, config.Config{Tracing: config.TracingConfig{Capture: tc.capture}})
+			start := tracer.TakeSnapshot(tracer.MeasureTime)
+			span := tracer.RecordEntry("handle")
+			items := make([]string, 2, 4)
+			items[0], items[1] = "a", "b"
+			span.SetAttribute("items", items)
+			span.SetAttribute("name", "resize")
+			span.SetAttribute("n", 3)
+			span.RecordReturn(7, map[string]int{"x": 1})
+			span.RecordAll(start)
+			if err := tracer.Flush(); err != nil {
+				t.Fatalf("Flush returned error: %v", err)
+			}
+			records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+			if err != nil || len(records) != 1 {
+				t.Fatalf("Expected one record, got %d (%v)", len(records), err)
+			}
+			rec := records[0]
+			if rec.ParamSizes["items"] != (tracer.Size{Len: 2, Cap: 4}) || rec.ParamSizes["name"] != (tracer.Size{Len: 6}) {
+				t.Errorf("Expected the sizes of items and name, got %v", rec.ParamSizes)
+			}
+			if _, ok := rec.ParamSizes["n"]; ok {
+				t.Errorf("Expected no size for an int, got %v", rec.ParamSizes)
+			}
+			if len(rec.ReturnSizes) != 2 || rec.ReturnSizes[0] != nil || rec.ReturnSizes[1] == nil || rec.ReturnSizes[1].Len != 1 {
+				t.Errorf("Expected the size of the map returned, aligned with the returns, got %v", rec.ReturnSizes)
+			}
+			if rec.Params["items"] != tc.items {
+				t.Errorf("Expected items = %q, got %q", tc.items, rec.Params["items"])
+			}
+			if _, ok := rec.TypedParams["name"]; ok != tc.typedName {
+				t.Errorf("Expected the typed string to be kept: %v, got %v", tc.typedName, rec.TypedParams)
+			}
+		})
+	}
+}
+
+func TestCapturePointerIDs(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{Capture: config.CaptureConfig{PointerIDs: true}}})
+	order, other := &customer{Name: "a"}, &customer{Name: "a"}
+	start := tracer.TakeSnapshot(tracer.MeasureTime)
+	for _, fn := range []string{"handle", "save"} {
+		span := tracer.RecordEntry(fn)
+		span.SetAttribute("order", order)
+		span.SetAttribute("n", 3)
+		span.RecordAll(start)
+	}
+	span := tracer.RecordEntry("audit")
+	span.SetAttribute("order", other)
+	span.SetAttribute("missing", (*customer)(nil))
+	span.RecordAll(start)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Expected three records, got %d (%v)", len(records), err)
+	}
+	id := records[0].PointerIDs["order"]
+	if id == "" || records[1].PointerIDs["order"] != id {
+		t.Errorf("Expected the same object to get the same ID, got %v and %v", records[0].PointerIDs, records[1].PointerIDs)
+	}
+	if records[2].PointerIDs["order"] == id {
+		t.Errorf("Expected an equal but distinct object to get another ID, got %v", records[2].PointerIDs)
+	}
+	if _, ok := records[0].PointerIDs["n"]; ok {
+		t.Errorf("Expected no ID for an int, got %v", records[0].PointerIDs)
+	}
+	if _, ok := records[2].PointerIDs["missing"]; ok {
+		t.Errorf("Expected no ID for a nil pointer, got %v", records[2].PointerIDs)
+	}
+}
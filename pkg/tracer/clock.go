@@ -0,0 +1,89 @@
+package tracer
+
+import (
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the time source used for trace records. Now gives the wall time shown in records and
+// logs; Nanotime gives a monotonic reading in nanoseconds from which durations are computed, so
+// wall clock adjustments such as NTP corrections do not distort them. Only differences between
+// Nanotime readings are meaningful.
+type Clock interface {
+	Now() time.Time
+	Nanotime() int64
+}
+
+// systemClock reads the operating system clocks.
+type systemClock struct{}
+
+// processEpoch anchors systemClock's monotonic readings.
+var processEpoch = time.Now()
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Nanotime uses the monotonic reading carried by time.Now, which time.Since compares against.
+func (systemClock) Nanotime() int64 { return int64(time.Since(processEpoch)) }
+
+// clockSource holds the active Clock.
+type clockSource struct{ Clock }
+
+var activeClock atomic.Value
+
+func init() {
+	activeClock.Store(clockSource{systemClock{}})
+}
+
+// SetClock replaces the tracer's time source, e.g. with a fake clock that makes timestamps and
+// durations deterministic in tests. Passing nil restores the system clock. The tracer's own hook
+// overhead (see tracing.maxOverheadPercent) is always measured with the system clock.
+//
+// Parameters:
+//   - c (Clock): the new time source, or nil.
+func SetClock(c Clock) {
+	if c == nil {
+		c = systemClock{}
+	}
+	activeClock.Store(clockSource{c})
+}
+
+// clockNow returns the wall time and monotonic reading of the active clock.
+func clockNow() (time.Time, int64) {
+	c := activeClock.Load().(clockSource)
+	return c.Now(), c.Nanotime()
+}
+
+// clockNanos returns the monotonic reading of the active clock only, which is cheaper than
+// reading the wall time too.
+func clockNanos() int64 {
+	return activeClock.Load().(clockSource).Nanotime()
+}
+
+// LogTimeLayout is the layout of the timestamp that starts every line of tracewrap.log.
+const LogTimeLayout = time.RFC3339Nano
+
+// timestampWriter prefixes every log entry with the active clock's time in UTC, formatted with
+// LogTimeLayout. The standard log flags only resolve to microseconds and omit the zone, which
+// makes ordering lines from different sources ambiguous.
+type timestampWriter struct {
+	w io.Writer
+}
+
+// Write writes p, one log entry from log.Logger, after its timestamp.
+func (t timestampWriter) Write(p []byte) (int, error) {
+	now := activeClock.Load().(clockSource).Now()
+	line := make([]byte, 0, len(LogTimeLayout)+1+len(p))
+	line = now.UTC().AppendFormat(line, LogTimeLayout)
+	line = append(line, ' ')
+	if _, err := t.w.Write(append(line, p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// newLogger returns a tracer logger writing timestamped lines to w.
+func newLogger(w io.Writer) *log.Logger {
+	return log.New(timestampWriter{w: w}, "", 0)
+}
@@ -0,0 +1,73 @@
+package tracer_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// fakeClock advances its monotonic reading by step on every read. Its wall time can be moved
+// independently to simulate clock adjustments.
+type fakeClock struct {
+	wall time.Time
+	mono int64
+	step time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.wall }
+
+func (c *fakeClock) Nanotime() int64 {
+	c.mono += int64(c.step)
+	return c.mono
+}
+
+func TestDurationsUseTheMonotonicClock(t *testing.T) {
+	withTracer(t, config.Config{})
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{wall: t0, step: time.Millisecond}
+	tracer.SetClock(clock)
+	defer tracer.SetClock(nil)
+
+	tracer.RecordEntry("outer")
+	call("inner", nil)
+	// An NTP correction moves the wall clock back before outer exits.
+	clock.wall = t0.Add(-time.Hour)
+	tracer.RecordExit("outer", t0)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Expected two records, got %d (%v)", len(records), err)
+	}
+	inner, outer := records[0], records[1]
+	if inner.Duration != time.Millisecond || !inner.EntryTime.Equal(t0) {
+		t.Errorf("Expected inner to take exactly 1ms from %v, got %v from %v", t0, inner.Duration, inner.EntryTime)
+	}
+	if outer.Duration != 3*time.Millisecond || outer.ExitNanos-outer.EntryNanos != int64(3*time.Millisecond) {
+		t.Errorf("Expected outer to take 3ms despite the wall clock jump, got %v", outer.Duration)
+	}
+	if !outer.ExitTime.Equal(t0.Add(-time.Hour)) {
+		t.Errorf("Expected the adjusted wall time on exit, got %v", outer.ExitTime)
+	}
+}
+
+func TestLogLinesHaveNanosecondTimestamps(t *testing.T) {
+	withTracer(t, config.Config{})
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 123456789, time.UTC)
+	tracer.SetClock(&fakeClock{wall: t0, step: time.Millisecond})
+	defer tracer.SetClock(nil)
+	call("work", nil)
+	data, err := os.ReadFile("tracewrap/latest/tracewrap.log")
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	want := "2024-01-01T12:00:00.123456789Z [TRACEWRAP] Entering work ID: 1\n"
+	if !strings.Contains(string(data), want) {
+		t.Errorf("Expected log line %q, got:\n%s", want, data)
+	}
+}
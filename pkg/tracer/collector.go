@@ -0,0 +1,190 @@
+package tracer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/httpauth"
+)
+
+// Collector API paths, relative to tracing.collector.endpoint. {id} stands for the run ID.
+const (
+	CollectorRecordsPath  = "/v1/runs/{id}/records"  // POST: append JSON Lines trace records to the run.
+	CollectorMetadataPath = "/v1/runs/{id}/metadata" // PUT: replace the run's metadata (RunMetadata as JSON).
+)
+
+// CollectorHostHeader carries the host name of the uploading binary. Run IDs only identify runs
+// within one host (in containers, every process may have PID 1), so the collector stores the runs
+// of different hosts apart.
+const CollectorHostHeader = "X-Tracewrap-Host"
+
+// defaultCollectorTimeout bounds uploads when tracing.collector.timeout is not set.
+const defaultCollectorTimeout = 10 * time.Second
+
+// collectorQueueSize bounds the uploads waiting for the collector. When the queue is full, the
+// payload is written to the artifact directory instead.
+const collectorQueueSize = 64
+
+// collectorUpload is a payload waiting to be shipped to the collector by the uploader.
+type collectorUpload struct {
+	cfg         config.CollectorConfig
+	runID       string
+	method      string
+	path        string
+	contentType string
+	body        []byte
+	what        string       // What the payload is, for the log.
+	fallback    func() error // Writes the payload locally when the collector does not accept it. Run with mu held.
+}
+
+// Upload queue state. The queue is created, and the uploader started, with the first upload.
+var (
+	collectorQueue  chan collectorUpload
+	collectorOnce   sync.Once
+	uploadMu        sync.Mutex
+	uploadDone      = sync.NewCond(&uploadMu)
+	uploadsInFlight int // Uploads queued or running. Guarded by uploadMu.
+)
+
+// collectorEnabled reports whether artifacts are shipped to a collector.
+func collectorEnabled() bool {
+	return activeConfig.Tracing.Collector.Endpoint != ""
+}
+
+// CollectorURL returns the URL of a collector API path for a run.
+//
+// Parameters:
+//   - endpoint (string): the collector's base URL, e.g. "http://tracewrap-collector:4321".
+//   - path (string): CollectorRecordsPath or CollectorMetadataPath.
+//   - id (string): the run ID.
+//
+// Returns:
+//   - string: the URL.
+func CollectorURL(endpoint, path, id string) string {
+	return strings.TrimSuffix(endpoint, "/") + strings.Replace(path, "{id}", url.PathEscape(id), 1)
+}
+
+// queueUpload hands a payload for the collector API path of the current run to the uploader,
+// which ships it without holding the tracer's lock, so calls are not held up by the network. If
+// the queue is full, or the collector does not accept the payload, fallback writes it locally.
+// Callers must hold mu.
+//
+// Parameters:
+//   - method (string): the HTTP method.
+//   - path (string): CollectorRecordsPath or CollectorMetadataPath.
+//   - contentType (string): the content type of body.
+//   - body ([]byte): the payload.
+//   - what (string): what the payload is, for the log.
+//   - fallback (func() error): writes the payload to the artifact directory.
+func queueUpload(method, path, contentType string, body []byte, what string, fallback func() error) {
+	collectorOnce.Do(func() {
+		collectorQueue = make(chan collectorUpload, collectorQueueSize)
+		go runUploader()
+	})
+	up := collectorUpload{
+		cfg:         activeConfig.Tracing.Collector,
+		runID:       runID,
+		method:      method,
+		path:        path,
+		contentType: contentType,
+		body:        body,
+		what:        what,
+		fallback:    fallback,
+	}
+	uploadMu.Lock()
+	uploadsInFlight++
+	uploadMu.Unlock()
+	select {
+	case collectorQueue <- up:
+	default:
+		logger.Printf("[TRACEWRAP] Collector upload queue full, writing %s locally", what)
+		if err := fallback(); err != nil {
+			logger.Printf("[TRACEWRAP] Error writing %s: %v", what, err)
+		}
+		uploadFinished()
+	}
+}
+
+// runUploader ships the queued uploads one at a time, in the order they were queued.
+func runUploader() {
+	for up := range collectorQueue {
+		if err := shipToCollector(up); err != nil {
+			logger.Printf("[TRACEWRAP] Error shipping %s to the collector, writing it locally: %v", up.what, err)
+			mu.Lock()
+			err = up.fallback()
+			mu.Unlock()
+			if err != nil {
+				logger.Printf("[TRACEWRAP] Error writing %s: %v", up.what, err)
+			}
+		}
+		uploadFinished()
+	}
+}
+
+// uploadFinished counts an upload as done.
+func uploadFinished() {
+	uploadMu.Lock()
+	uploadsInFlight--
+	if uploadsInFlight == 0 {
+		uploadDone.Broadcast()
+	}
+	uploadMu.Unlock()
+}
+
+// waitForUploads blocks until every queued upload has been shipped or written locally. Callers
+// must not hold mu, which the uploader takes to write a rejected payload.
+func waitForUploads() {
+	uploadMu.Lock()
+	for uploadsInFlight > 0 {
+		uploadDone.Wait()
+	}
+	uploadMu.Unlock()
+}
+
+// shipToCollector sends an upload to its collector, with the configured token and CA
+// certificates, bounded by tracing.collector.timeout.
+//
+// Parameters:
+//   - up (collectorUpload): the upload.
+//
+// Returns:
+//   - error: an error if the request fails or the collector does not accept it.
+func shipToCollector(up collectorUpload) error {
+	cfg := up.cfg
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultCollectorTimeout
+	}
+	req, err := http.NewRequest(up.method, CollectorURL(cfg.Endpoint, up.path, up.runID), bytes.NewReader(up.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", up.contentType)
+	if host, err := os.Hostname(); err == nil {
+		req.Header.Set(CollectorHostHeader, host)
+	}
+	httpauth.Authorize(req, httpauth.ResolveToken(cfg.Token, cfg.TokenEnv))
+	client, err := httpauth.Client(cfg.CAFile)
+	if err != nil {
+		return err
+	}
+	client.Timeout = timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package tracer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/collector"
+	"github.com/mwiater/tracewrap/pkg/httpauth"
+	"github.com/mwiater/tracewrap/pkg/runs"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestFlushShipsToCollector(t *testing.T) {
+	dir := withTracer(t, config.Config{})
+	server, err := collector.New(filepath.Join(dir, "collected"))
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	tracer.ResetForTest(config.Config{Tracing: config.TracingConfig{Collector: config.CollectorConfig{Endpoint: ts.URL}}})
+
+	call("first", nil)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	call("second", nil)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+
+	collected, err := runs.List(server.Dir())
+	if err != nil || len(collected) != 1 || !strings.HasPrefix(collected[0].ID, tracer.RunID()) {
+		t.Fatalf("Expected one collected run for %s, got %+v (%v)", tracer.RunID(), collected, err)
+	}
+	runDir := collected[0].Dir
+	if host, _ := os.Hostname(); collected[0].Metadata == nil || collected[0].Metadata.Hostname != host {
+		t.Errorf("Expected the run metadata to name host %q, got %+v", host, collected[0].Metadata)
+	}
+	records, err := tracer.ReadTraceFile(filepath.Join(runDir, "trace.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to read collected trace: %v", err)
+	}
+	if len(records) != 2 || records[0].FunctionName != "first" || records[1].FunctionName != "second" {
+		t.Errorf("Expected each record to be shipped once, got %+v", records)
+	}
+	if _, err := os.Stat(filepath.Join("tracewrap", "latest", "trace.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("Expected no local trace file, stat returned %v", err)
+	}
+}
+
+func TestFlushWritesLocallyWhenCollectorIsUnreachable(t *testing.T) {
+	ts := httptest.NewServer(nil)
+	endpoint := ts.URL
+	ts.Close()
+	withTracer(t, config.Config{Tracing: config.TracingConfig{Collector: config.CollectorConfig{Endpoint: endpoint}}})
+
+	call("work", nil)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile(filepath.Join("tracewrap", "latest", "trace.jsonl"))
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Expected the record in the local trace file, got %d records (%v)", len(records), err)
+	}
+	if _, err := os.Stat(filepath.Join("tracewrap", "latest", "run.json")); err != nil {
+		t.Errorf("Expected local run metadata: %v", err)
+	}
+}
+
+func TestFlushPresentsCollectorToken(t *testing.T) {
+	dir := withTracer(t, config.Config{})
+	server, err := collector.New(filepath.Join(dir, "collected"))
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+	ts := httptest.NewServer(httpauth.Require("secret", server.Handler()))
+	defer ts.Close()
+	t.Setenv("TRACEWRAP_TEST_TOKEN", "secret")
+	tracer.ResetForTest(config.Config{Tracing: config.TracingConfig{Collector: config.CollectorConfig{Endpoint: ts.URL, TokenEnv: "TRACEWRAP_TEST_TOKEN"}}})
+
+	call("work", nil)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if collected, err := runs.List(server.Dir()); err != nil || len(collected) != 1 {
+		t.Errorf("Expected the upload to be accepted with the token, got %+v (%v)", collected, err)
+	}
+	if _, err := os.Stat(filepath.Join("tracewrap", "latest", "trace.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("Expected no local fallback, stat returned %v", err)
+	}
+}
+
+func TestUploadsDoNotBlockTracedCalls(t *testing.T) {
+	dir := withTracer(t, config.Config{})
+	server, err := collector.New(filepath.Join(dir, "collected"))
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		server.Handler().ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	tracer.ResetForTest(config.Config{Tracing: config.TracingConfig{
+		MaxRecordsInMemory: 1,
+		Collector:          config.CollectorConfig{Endpoint: ts.URL},
+	}})
+
+	done := make(chan struct{})
+	go func() {
+		// Each exit past the first spills the records, queueing an upload the collector holds.
+		for i := 0; i < 3; i++ {
+			call("work", nil)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected traced calls to complete while the collector is stalled")
+	}
+	close(release)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	collected, err := runs.List(server.Dir())
+	if err != nil || len(collected) != 1 {
+		t.Fatalf("Expected one collected run, got %+v (%v)", collected, err)
+	}
+	records, err := tracer.ReadTraceFile(filepath.Join(collected[0].Dir, "trace.jsonl"))
+	if err != nil || len(records) != 3 {
+		t.Errorf("Expected the 3 records shipped once the collector resumed, got %d (%v)", len(records), err)
+	}
+}
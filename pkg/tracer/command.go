@@ -0,0 +1,240 @@
+package tracer
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CommandRecordPrefix starts the function name of the record a child process gets when the call
+// that started it has exited before it is waited for, e.g. "exec git".
+const CommandRecordPrefix = "exec "
+
+// ChildUsage sums the resources of the child processes a call ran, recorded by the command
+// wrappers such as RunCommand.
+type ChildUsage struct {
+	Processes  int           `json:"processes"`
+	Failed     int           `json:"failed,omitempty"` // Processes that could not start or exited unsuccessfully.
+	UserTime   time.Duration `json:"userTime,omitempty"`
+	SystemTime time.Duration `json:"systemTime,omitempty"`
+	MaxRSS     int64         `json:"maxRss,omitempty"` // The largest peak resident set size among them, in bytes (Unix).
+}
+
+// commandSpawn is the call a command was started in, identified by its unique ID: its record
+// belongs to the call only while the call is open, as a record may be persisted, or recycled in
+// light mode, once the call exits.
+type commandSpawn struct {
+	rec     *TraceRecord // The call, or nil outside a traced call.
+	id      int64        // The unique ID of rec.
+	name    string       // The function name of rec.
+	dropped bool         // Whether rec is excluded from recording.
+}
+
+// spawningSpans maps the commands started by StartCommand and not yet waited for to the call
+// that started them. Guarded by mu.
+var spawningSpans = make(map[*exec.Cmd]commandSpawn)
+
+// RunCommand runs cmd like cmd.Run and accounts for the child process in the traced call open on
+// the calling goroutine: a "process.start" and a "process.exit" event are added to the call, and
+// the child's CPU time and peak memory are added to its children field. Instrumentation rewrites
+// the Run, Output, CombinedOutput, Start and Wait calls of *exec.Cmd values into these wrappers,
+// unless instrumentation.skipCommandRewrite is set.
+//
+// Parameters:
+//   - cmd (*exec.Cmd): the command.
+//
+// Returns:
+//   - error: the error of cmd.Run.
+func RunCommand(cmd *exec.Cmd) error {
+	spawn := beginCommand(cmd)
+	err := cmd.Run()
+	finishCommand(spawn, cmd, err)
+	return err
+}
+
+// CommandOutput runs cmd like cmd.Output, accounting for the child as RunCommand does.
+//
+// Parameters:
+//   - cmd (*exec.Cmd): the command.
+//
+// Returns:
+//   - []byte: the standard output of the command.
+//   - error: the error of cmd.Output.
+func CommandOutput(cmd *exec.Cmd) ([]byte, error) {
+	spawn := beginCommand(cmd)
+	out, err := cmd.Output()
+	finishCommand(spawn, cmd, err)
+	return out, err
+}
+
+// CommandCombinedOutput runs cmd like cmd.CombinedOutput, accounting for the child as RunCommand
+// does.
+//
+// Parameters:
+//   - cmd (*exec.Cmd): the command.
+//
+// Returns:
+//   - []byte: the combined standard output and standard error of the command.
+//   - error: the error of cmd.CombinedOutput.
+func CommandCombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	spawn := beginCommand(cmd)
+	out, err := cmd.CombinedOutput()
+	finishCommand(spawn, cmd, err)
+	return out, err
+}
+
+// StartCommand starts cmd like cmd.Start. The child is accounted for in the call open on the
+// calling goroutine when WaitCommand returns, even if that happens in another call or goroutine.
+// If that call has exited by then, the child gets a record of its own (see finishCommand).
+//
+// Parameters:
+//   - cmd (*exec.Cmd): the command.
+//
+// Returns:
+//   - error: the error of cmd.Start.
+func StartCommand(cmd *exec.Cmd) error {
+	spawn := beginCommand(cmd)
+	err := cmd.Start()
+	if err != nil {
+		finishCommand(spawn, cmd, err)
+		return err
+	}
+	mu.Lock()
+	spawningSpans[cmd] = spawn
+	mu.Unlock()
+	return nil
+}
+
+// WaitCommand waits for cmd like cmd.Wait and accounts for the child in the call that started
+// it with StartCommand, or in the call open on the calling goroutine.
+//
+// Parameters:
+//   - cmd (*exec.Cmd): the command.
+//
+// Returns:
+//   - error: the error of cmd.Wait.
+func WaitCommand(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	gid := goroutineID()
+	mu.Lock()
+	spawn, ok := spawningSpans[cmd]
+	delete(spawningSpans, cmd)
+	if !ok {
+		spawn = spawnOf(openSpanOn(gid))
+	}
+	mu.Unlock()
+	finishCommand(spawn, cmd, err)
+	return err
+}
+
+// spawnOf returns rec as the call commands are started in. Callers must hold mu.
+func spawnOf(rec *TraceRecord) commandSpawn {
+	if rec == nil {
+		return commandSpawn{}
+	}
+	return commandSpawn{rec: rec, id: rec.UniqueID, name: rec.FunctionName, dropped: rec.dropped}
+}
+
+// open returns the record of the spawning call while the call is open, or nil. Callers must hold
+// mu.
+func (s commandSpawn) open() *TraceRecord {
+	if s.rec != nil && s.rec.UniqueID == s.id && !s.rec.exited {
+		return s.rec
+	}
+	return nil
+}
+
+// beginCommand adds a "process.start" event for cmd to the call open on the calling goroutine.
+//
+// Returns:
+//   - commandSpawn: the call, with no record outside a traced call.
+func beginCommand(cmd *exec.Cmd) commandSpawn {
+	ensureInitialized()
+	now, _ := clockNow()
+	gid := goroutineID()
+	mu.Lock()
+	defer mu.Unlock()
+	rec := openSpanOn(gid)
+	addEvent(rec, SpanEvent{Name: "process.start", Time: now, Attrs: map[string]interface{}{
+		"path": cmd.Path,
+		"args": strings.Join(cmd.Args, " "),
+	}})
+	return spawnOf(rec)
+}
+
+// finishCommand adds a "process.exit" event for cmd, which returned err, to the call spawn and
+// adds the child's resource usage to its Children. If the call has already exited, its record is
+// no longer written to: the child is retained as a record of its own instead, named
+// CommandRecordPrefix and the command's base name, with the call's unique ID as its CallerID, the
+// event and the usage. It starts a trace of its own, as calls on other goroutines do.
+func finishCommand(spawn commandSpawn, cmd *exec.Cmd, err error) {
+	now, nanos := clockNow()
+	attrs := map[string]interface{}{"path": cmd.Path}
+	usage := ChildUsage{Processes: 1}
+	if err != nil {
+		attrs["error"] = err.Error()
+		usage.Failed = 1
+	}
+	if ps := cmd.ProcessState; ps != nil {
+		usage.UserTime, usage.SystemTime = ps.UserTime(), ps.SystemTime()
+		usage.MaxRSS = childMaxRSS(ps)
+		attrs["pid"] = ps.Pid()
+		attrs["exitCode"] = ps.ExitCode()
+		attrs["cpuTime"] = (usage.UserTime + usage.SystemTime).String()
+		if usage.MaxRSS > 0 {
+			attrs["maxRss"] = usage.MaxRSS
+		}
+	}
+	ev := SpanEvent{Name: "process.exit", Time: now, Attrs: attrs}
+	mu.Lock()
+	defer mu.Unlock()
+	if spawn.rec == nil || spawn.dropped {
+		logger.Printf("[TRACEWRAP] Process %s exited outside a traced call%s", cmd.Path, formatAttrs(attrs))
+		return
+	}
+	rec := spawn.open()
+	if rec == nil {
+		id := atomic.AddInt64(&uniqueID, 1)
+		rec = &TraceRecord{
+			SchemaVersion: SchemaVersion,
+			UniqueID:      id,
+			FunctionName:  CommandRecordPrefix + filepath.Base(cmd.Path),
+			CallerID:      spawn.id,
+			EntryTime:     now,
+			EntryNanos:    nanos,
+			ExitTime:      now,
+			ExitNanos:     nanos,
+			callerName:    spawn.name,
+			rootID:        id,
+			exited:        true,
+		}
+		addEvent(rec, ev)
+		rec.Children = &usage
+		retainRecord(rec)
+		return
+	}
+	addEvent(rec, ev)
+	if rec.Children == nil {
+		rec.Children = &ChildUsage{}
+	}
+	c := rec.Children
+	c.Processes += usage.Processes
+	c.Failed += usage.Failed
+	c.UserTime += usage.UserTime
+	c.SystemTime += usage.SystemTime
+	c.MaxRSS = max(c.MaxRSS, usage.MaxRSS)
+}
+
+// forgetExitedSpawns drops the commands started by calls that have exited and not waited for
+// yet, which would otherwise be kept until they are, if ever. Called by Shutdown only, as a
+// command may be waited for long after its call exited, and is then recorded apart from it (see
+// finishCommand). Callers must hold mu.
+func forgetExitedSpawns() {
+	for cmd, spawn := range spawningSpans {
+		if spawn.open() == nil {
+			delete(spawningSpans, cmd)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package tracer
+
+import "os"
+
+// childMaxRSS is not available on this platform.
+func childMaxRSS(ps *os.ProcessState) int64 {
+	return 0
+}
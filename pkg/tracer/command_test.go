@@ -0,0 +1,88 @@
+package tracer_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestCommandWrappersAccountChildrenToTheSpawningCall(t *testing.T) {
+	withTracer(t, config.Config{})
+
+	call("spawn", func() {
+		// The test binary itself, running no tests, is a child that exits successfully.
+		if err := tracer.RunCommand(exec.Command(os.Args[0], "-test.run=^$")); err != nil {
+			t.Fatalf("RunCommand returned error: %v", err)
+		}
+		cmd := exec.Command(os.Args[0], "-test.run=^$")
+		if err := tracer.StartCommand(cmd); err != nil {
+			t.Fatalf("StartCommand returned error: %v", err)
+		}
+		if err := tracer.WaitCommand(cmd); err != nil {
+			t.Fatalf("WaitCommand returned error: %v", err)
+		}
+		if err := tracer.RunCommand(exec.Command("tracewrap-no-such-command")); err == nil {
+			t.Fatal("Expected RunCommand to fail for a missing command")
+		}
+	})
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Expected one record, got %d (%v)", len(records), err)
+	}
+	rec := records[0]
+	if rec.Children == nil || rec.Children.Processes != 3 || rec.Children.Failed != 1 {
+		t.Fatalf("Expected three children with one failure, got %+v", rec.Children)
+	}
+	if rec.Children.UserTime+rec.Children.SystemTime <= 0 {
+		t.Errorf("Expected the children's CPU time, got %+v", rec.Children)
+	}
+	counts := make(map[string]int)
+	for _, ev := range rec.Events {
+		counts[ev.Name]++
+	}
+	if counts["process.start"] != 3 || counts["process.exit"] != 3 {
+		t.Errorf("Expected three process.start and process.exit events, got %v", counts)
+	}
+}
+
+func TestWaitAfterTheSpawningCallExitedRecordsTheChildApart(t *testing.T) {
+	withTracer(t, config.Config{})
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	call("spawn", func() {
+		if err := tracer.StartCommand(cmd); err != nil {
+			t.Fatalf("StartCommand returned error: %v", err)
+		}
+	})
+	// A flush in between, such as a periodic one, keeps the command of the exited call.
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if err := tracer.WaitCommand(cmd); err != nil {
+		t.Fatalf("WaitCommand returned error: %v", err)
+	}
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Expected two records, got %d (%v)", len(records), err)
+	}
+	spawn, child := records[0], records[1]
+	if spawn.Children != nil || len(spawn.Events) != 1 {
+		t.Errorf("Expected the exited call to keep only its process.start event, got %+v, %+v", spawn.Children, spawn.Events)
+	}
+	if child.FunctionName != tracer.CommandRecordPrefix+filepath.Base(cmd.Path) || child.CallerID != spawn.UniqueID {
+		t.Errorf("Expected the child's record under the spawning call, got %s with caller %d", child.FunctionName, child.CallerID)
+	}
+	if child.Children == nil || child.Children.Processes != 1 || len(child.Events) != 1 || child.Events[0].Name != "process.exit" {
+		t.Errorf("Expected the child's usage and exit event on its record, got %+v, %+v", child.Children, child.Events)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package tracer
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// childMaxRSS returns the peak resident set size of the exited process ps in bytes, which the
+// kernel reports in kilobytes except on Apple platforms.
+func childMaxRSS(ps *os.ProcessState) int64 {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		return int64(ru.Maxrss)
+	}
+	return int64(ru.Maxrss) * 1024
+}
@@ -0,0 +1,104 @@
+package tracer
+
+import (
+	"bytes"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cyclesPerSecond converts the CPU tick counts of block and mutex profile records into time.
+// It is zero while contention profiling is disabled.
+var cyclesPerSecond float64
+
+// contentionCounts are cumulative contention figures for one function.
+type contentionCounts struct {
+	cycles int64
+	events int64
+}
+
+// initContentionProfiling enables the runtime block and mutex profiles configured with
+// tracing.blockProfileRate and tracing.mutexProfileFraction.
+func initContentionProfiling() {
+	t := activeConfig.Tracing
+	if t.BlockProfileRate <= 0 && t.MutexProfileFraction <= 0 {
+		cyclesPerSecond = 0
+		return
+	}
+	if t.BlockProfileRate > 0 {
+		runtime.SetBlockProfileRate(t.BlockProfileRate)
+	}
+	if t.MutexProfileFraction > 0 {
+		runtime.SetMutexProfileFraction(t.MutexProfileFraction)
+	}
+	cyclesPerSecond = readCyclesPerSecond()
+}
+
+// readCyclesPerSecond reads the tick rate the runtime uses for contention profiles from the
+// "cycles/second=" header of the text block profile, as it is not exported otherwise.
+func readCyclesPerSecond() float64 {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("block").WriteTo(&buf, 1); err == nil {
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if v, ok := strings.CutPrefix(line, "cycles/second="); ok {
+				if hz, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && hz > 0 {
+					return hz
+				}
+			}
+		}
+	}
+	// Fall back to nanoseconds, the tick unit on platforms without a cycle counter.
+	return 1e9
+}
+
+// blockingEnabled and mutexEnabled report whether the corresponding profile is being sampled.
+func blockingEnabled() bool { return activeConfig.Tracing.BlockProfileRate > 0 }
+func mutexEnabled() bool    { return activeConfig.Tracing.MutexProfileFraction > 0 }
+
+// contentionFor sums the delay and event counts of every profile record whose stack includes a
+// function named functionName. Instrumented functions are identified by their bare name, so the
+// last element of each frame's function name is compared ("main.(*T).Serve" matches "Serve").
+func contentionFor(functionName string, profile func([]runtime.BlockProfileRecord) (int, bool)) contentionCounts {
+	var records []runtime.BlockProfileRecord
+	n, _ := profile(nil)
+	for {
+		// Leave room for events recorded between the two calls.
+		records = make([]runtime.BlockProfileRecord, n+16)
+		var ok bool
+		n, ok = profile(records)
+		if ok {
+			records = records[:n]
+			break
+		}
+	}
+	var counts contentionCounts
+	for i := range records {
+		frames := runtime.CallersFrames(records[i].Stack())
+		for {
+			frame, more := frames.Next()
+			name := frame.Function
+			if dot := strings.LastIndexByte(name, '.'); dot >= 0 {
+				name = name[dot+1:]
+			}
+			if name == functionName {
+				counts.cycles += records[i].Cycles
+				counts.events += records[i].Count
+				break
+			}
+			if !more {
+				break
+			}
+		}
+	}
+	return counts
+}
+
+// cyclesToDuration converts profile ticks into a duration.
+func cyclesToDuration(cycles int64) time.Duration {
+	if cyclesPerSecond <= 0 {
+		return 0
+	}
+	return time.Duration(float64(cycles) / cyclesPerSecond * float64(time.Second))
+}
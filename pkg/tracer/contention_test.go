@@ -0,0 +1,50 @@
+package tracer_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// waitForResult mirrors the handlers of the httpserver example: it spends its time blocked on
+// a channel receive rather than on the CPU.
+func waitForResult() {
+	start := time.Now()
+	tracer.RecordEntry("waitForResult")
+	defer tracer.RecordExit("waitForResult", start)
+	result := make(chan struct{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(result)
+	}()
+	<-result
+}
+
+func TestBlockingIsAttributedToSpans(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{BlockProfileRate: 1}})
+	t.Cleanup(func() { runtime.SetBlockProfileRate(0) })
+
+	waitForResult()
+	call("idle", nil)
+
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("ReadTraceFile returned error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	waiter, idle := records[0], records[1]
+	if waiter.BlockEvents == 0 || waiter.BlockedTime < 10*time.Millisecond {
+		t.Errorf("Expected the channel receive to be attributed to waitForResult, got %v over %d events", waiter.BlockedTime, waiter.BlockEvents)
+	}
+	if idle.BlockEvents != 0 {
+		t.Errorf("Expected no blocking for idle, got %d events", idle.BlockEvents)
+	}
+}
@@ -0,0 +1,271 @@
+package tracer
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/httpauth"
+)
+
+// Recording state, adjustable at runtime through the control API.
+var (
+	recording      atomic.Bool   // Whether new root calls are recorded at all.
+	sampleRateBits atomic.Uint64 // math.Float64bits of the fraction of root calls recorded.
+	startedAt      time.Time     // When the tracer was initialized.
+	startedNanos   int64         // The monotonic clock reading at startedAt.
+)
+
+// initRecordingState applies the configured initial recording state.
+func initRecordingState() {
+	startedAt, startedNanos = clockNow()
+	recording.Store(true)
+	SetSampleRate(activeConfig.Tracing.SampleRate)
+}
+
+// shouldRecordRoot decides whether a call without an open parent is recorded.
+func shouldRecordRoot() bool {
+	if !recording.Load() {
+		return false
+	}
+	rate := SampleRate()
+	return rate >= 1 || rand.Float64() < rate
+}
+
+// StartRecording resumes recording of new calls.
+func StartRecording() {
+	ensureInitialized()
+	recording.Store(true)
+	logger.Println("[TRACEWRAP] Recording started")
+}
+
+// StopRecording stops recording new calls. Calls already in progress complete normally, and
+// execution counts and latency histograms keep being updated.
+func StopRecording() {
+	ensureInitialized()
+	recording.Store(false)
+	logger.Println("[TRACEWRAP] Recording stopped")
+}
+
+// IsRecording reports whether new calls are currently being recorded.
+//
+// Returns:
+//   - bool: true if recording is active.
+func IsRecording() bool {
+	ensureInitialized()
+	return recording.Load()
+}
+
+// SetSampleRate sets the fraction of root calls (calls without an open parent) that are recorded.
+// Nested calls follow their root's decision. Values outside (0, 1] are treated as 1.
+//
+// Parameters:
+//   - rate (float64): the fraction of root calls to record.
+func SetSampleRate(rate float64) {
+	if rate <= 0 || rate > 1 || math.IsNaN(rate) {
+		rate = 1
+	}
+	sampleRateBits.Store(math.Float64bits(rate))
+}
+
+// SampleRate returns the fraction of root calls currently recorded.
+//
+// Returns:
+//   - float64: the sample rate in (0, 1].
+func SampleRate() float64 {
+	return math.Float64frombits(sampleRateBits.Load())
+}
+
+// Status describes the tracer's state as reported by the control API.
+type Status struct {
+	Recording       bool          `json:"recording"`
+	SampleRate      float64       `json:"sampleRate"`
+	RecordsInMemory int           `json:"recordsInMemory"`
+	RecordsSpilled  int           `json:"recordsSpilled"`
+	ActiveSpans     int           `json:"activeSpans"`
+	TotalCalls      int           `json:"totalCalls"`
+	Uptime          time.Duration `json:"uptime"`
+	// Downgraded maps functions whose capture level was lowered by tracing.maxOverheadPercent
+	// to their current level.
+	Downgraded map[string]string `json:"downgraded,omitempty"`
+	// TracesKept and TracesDropped count the traces decided by tracing.tailSampling, and
+	// TracesPending those still buffered.
+	TracesKept    int `json:"tracesKept,omitempty"`
+	TracesDropped int `json:"tracesDropped,omitempty"`
+	TracesPending int `json:"tracesPending,omitempty"`
+}
+
+// CurrentStatus returns a snapshot of the tracer's state.
+//
+// Returns:
+//   - Status: the current status.
+func CurrentStatus() Status {
+	ensureInitialized()
+	mu.Lock()
+	defer mu.Unlock()
+	_, now := clockNow()
+	total := execFrequency.total()
+	return Status{
+		Recording:       recording.Load(),
+		SampleRate:      SampleRate(),
+		RecordsInMemory: len(traceRecords),
+		RecordsSpilled:  spilledCount,
+		ActiveSpans:     len(callStack),
+		TotalCalls:      total,
+		Uptime:          time.Duration(now - startedNanos),
+		Downgraded:      downgradedFunctions(),
+		TracesKept:      tailKept,
+		TracesDropped:   tailDropped,
+		TracesPending:   len(tailPending),
+	}
+}
+
+// FunctionStats summarizes the completed calls of one function.
+type FunctionStats struct {
+	Name  string        `json:"name"`
+	Calls int           `json:"calls"`
+	Total time.Duration `json:"total"`
+	Avg   time.Duration `json:"avg"`
+}
+
+// Stats extends Status with the live figures shown by the dashboard.
+type Stats struct {
+	Status
+	Goroutines   int             `json:"goroutines"`
+	TopFunctions []FunctionStats `json:"topFunctions"`
+}
+
+// CurrentStats returns the status together with the goroutine count and the functions with the
+// highest total time.
+//
+// Parameters:
+//   - top (int): the maximum number of functions to include.
+//
+// Returns:
+//   - Stats: the current statistics.
+func CurrentStats(top int) Stats {
+	stats := Stats{Status: CurrentStatus(), Goroutines: runtime.NumGoroutine()}
+	mu.Lock()
+	defer mu.Unlock()
+	for name, agg := range funcAggregates.functions() {
+		stats.TopFunctions = append(stats.TopFunctions, FunctionStats{
+			Name:  name,
+			Calls: agg.Calls,
+			Total: agg.Total,
+			Avg:   agg.Total / time.Duration(agg.Calls),
+		})
+	}
+	sort.Slice(stats.TopFunctions, func(i, j int) bool {
+		a, b := stats.TopFunctions[i], stats.TopFunctions[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Name < b.Name
+	})
+	if len(stats.TopFunctions) > top {
+		stats.TopFunctions = stats.TopFunctions[:top]
+	}
+	return stats
+}
+
+// ControlHandler returns the HTTP handler serving the control API:
+//
+//	GET  /status            tracer status
+//	GET  /stats?top=10      status plus goroutine count and top functions by total time
+//	POST /start             resume recording
+//	POST /stop              stop recording
+//	POST /sample?rate=0.25  change the sample rate
+//	POST /dump              write the call graph and flush the trace file
+//
+// Every other endpoint responds with the resulting Status as JSON.
+//
+// Returns:
+//   - http.Handler: the control API handler.
+func ControlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w)
+	})
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		top := 10
+		if v := r.URL.Query().Get("top"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "top must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+			top = n
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(CurrentStats(top))
+	})
+	mux.HandleFunc("POST /start", func(w http.ResponseWriter, r *http.Request) {
+		StartRecording()
+		writeStatus(w)
+	})
+	mux.HandleFunc("POST /stop", func(w http.ResponseWriter, r *http.Request) {
+		StopRecording()
+		writeStatus(w)
+	})
+	mux.HandleFunc("POST /sample", func(w http.ResponseWriter, r *http.Request) {
+		rate, err := strconv.ParseFloat(r.URL.Query().Get("rate"), 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			http.Error(w, "rate must be a number in (0, 1]", http.StatusBadRequest)
+			return
+		}
+		SetSampleRate(rate)
+		logger.Printf("[TRACEWRAP] Sample rate set to %g", rate)
+		writeStatus(w)
+	})
+	mux.HandleFunc("POST /dump", func(w http.ResponseWriter, r *http.Request) {
+		if err := DumpCallGraphDOT(artifactPath("callgraph.dot")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := Flush(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeStatus(w)
+	})
+	return mux
+}
+
+// writeStatus writes the current status as a JSON response.
+func writeStatus(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CurrentStatus())
+}
+
+// startControlServer serves the control API on addr in the background, requiring the configured
+// token and serving over TLS when tracing.control sets a certificate.
+func startControlServer(addr string) {
+	cfg := activeConfig.Tracing.Control
+	if err := httpauth.CheckTLSFiles(cfg.TLSCert, cfg.TLSKey); err != nil {
+		logger.Println("[TRACEWRAP] Error starting control endpoint:", err)
+		return
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Println("[TRACEWRAP] Error starting control endpoint:", err)
+		return
+	}
+	scheme := "http"
+	if cfg.TLSCert != "" {
+		scheme = "https"
+	}
+	token := httpauth.ResolveToken(cfg.Token, cfg.TokenEnv)
+	logger.Printf("[TRACEWRAP] Control endpoint listening on %s://%s (token required: %t)", scheme, listener.Addr(), token != "")
+	go func() {
+		if err := httpauth.Serve(listener, httpauth.Require(token, ControlHandler()), cfg.TLSCert, cfg.TLSKey); err != nil {
+			logger.Println("[TRACEWRAP] Control endpoint stopped:", err)
+		}
+	}()
+}
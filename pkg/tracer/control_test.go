@@ -0,0 +1,95 @@
+package tracer_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestControlHandlerStopsAndStartsRecording(t *testing.T) {
+	withTracer(t, config.Config{})
+	server := httptest.NewServer(tracer.ControlHandler())
+	defer server.Close()
+
+	post := func(path string) tracer.Status {
+		t.Helper()
+		resp, err := http.Post(server.URL+path, "", nil)
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST %s returned %s", path, resp.Status)
+		}
+		var status tracer.Status
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatalf("Failed to decode status: %v", err)
+		}
+		return status
+	}
+
+	if status := post("/stop"); status.Recording {
+		t.Fatalf("Expected recording to be stopped")
+	}
+	call("ignored", nil)
+	if status := post("/start"); !status.Recording {
+		t.Fatalf("Expected recording to be started")
+	}
+	call("kept", nil)
+
+	status := post("/sample?rate=0.5")
+	if status.SampleRate != 0.5 {
+		t.Errorf("Expected sample rate 0.5, got %v", status.SampleRate)
+	}
+	if status.RecordsInMemory != 1 {
+		t.Errorf("Expected only the call made while recording to be retained, got %d records", status.RecordsInMemory)
+	}
+	if status.TotalCalls != 0 {
+		// Execution frequency is recorded by injected code, not by RecordEntry/RecordExit.
+		t.Errorf("Expected no execution counts, got %d", status.TotalCalls)
+	}
+	if hist := tracer.Histograms(); hist["ignored"].Count != 1 {
+		t.Errorf("Expected unrecorded calls to still reach the histograms")
+	}
+
+	resp, err := http.Post(server.URL+"/sample?rate=2", "", nil)
+	if err != nil {
+		t.Fatalf("POST /sample failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid rate to be rejected, got %s", resp.Status)
+	}
+}
+
+func TestControlHandlerServesTopFunctions(t *testing.T) {
+	withTracer(t, config.Config{})
+	server := httptest.NewServer(tracer.ControlHandler())
+	defer server.Close()
+
+	call("outer", func() {
+		call("inner", nil)
+		call("inner", nil)
+	})
+
+	resp, err := http.Get(server.URL + "/stats?top=1")
+	if err != nil {
+		t.Fatalf("GET /stats failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var stats tracer.Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	// outer includes the time of both inner calls, so it has the highest total.
+	if len(stats.TopFunctions) != 1 || stats.TopFunctions[0].Name != "outer" {
+		t.Errorf("Expected outer as the only top function, got %+v", stats.TopFunctions)
+	}
+	if stats.Goroutines == 0 || stats.RecordsInMemory != 3 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,83 @@
+package tracer
+
+import (
+	"fmt"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// defaultEntryStackDepth is the number of frames an entry stack holds when
+// tracing.entryStackDepth is not set.
+const defaultEntryStackDepth = 8
+
+// StackFrame is one frame of a stack captured by the tracer.
+type StackFrame struct {
+	Function string `json:"function"` // The function, qualified by its import path, e.g. "net/http.HandlerFunc.ServeHTTP".
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// String returns the frame as "main.handle (/src/app/main.go:42)".
+func (f StackFrame) String() string {
+	return fmt.Sprintf("%s (%s:%d)", f.Function, f.File, f.Line)
+}
+
+// entryStacks reports whether tracing.entryStacks matches functionName.
+func entryStacks(functionName string) bool {
+	for _, pattern := range activeConfig.Tracing.EntryStacks {
+		if ok, _ := path.Match(pattern, functionName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// entryStackFor returns the stack a call of functionName was made from, if tracing.entryStacks
+// matches it: the frames above the called function, innermost first. The callers need not be
+// instrumented, so it shows who calls a function the trace has no caller for. The calls of other
+// functions read no frames here.
+func entryStackFor(functionName string) []StackFrame {
+	if len(activeConfig.Tracing.EntryStacks) == 0 || !entryStacks(functionName) {
+		return nil
+	}
+	frames := callFrames(entryStackDepth())
+	if len(frames) < 2 {
+		return nil
+	}
+	frames = frames[1:]
+	stack := make([]StackFrame, len(frames))
+	for i, frame := range frames {
+		stack[i] = StackFrame{Function: frame.Function, File: frame.File, Line: frame.Line}
+	}
+	return stack
+}
+
+// entryStackDepth returns the number of frames an entry stack holds, or 0 without tracing.entryStacks.
+func entryStackDepth() int {
+	if len(activeConfig.Tracing.EntryStacks) == 0 {
+		return 0
+	}
+	if depth := activeConfig.Tracing.EntryStackDepth; depth > 0 {
+		return depth
+	}
+	return defaultEntryStackDepth
+}
+
+// callFrames returns the frames of a call being entered, innermost first and without the tracer's
+// own frames: the instrumented function, then up to depth of its callers. Called by
+// entryStackFor, outside mu.
+func callFrames(depth int) []runtime.Frame {
+	// Room for the tracer's frames and the called function's.
+	pcs := make([]uintptr, depth+8)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	var out []runtime.Frame
+	for more := true; more && len(out) <= depth; {
+		var frame runtime.Frame
+		frame, more = frames.Next()
+		if !strings.HasPrefix(frame.Function, tracerPackage) {
+			out = append(out, frame)
+		}
+	}
+	return out
+}
@@ -0,0 +1,44 @@
+package tracer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// rareCall stands for an instrumented function: it enters its own call like the injected code does.
+func rareCall(name string) {
+	start := tracer.TakeSnapshot(tracer.MeasureTime)
+	span := tracer.RecordEntry(name)
+	defer span.RecordAll(start)
+}
+
+func TestEntryStacksRecordTheCallersOfMatchingFunctions(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{EntryStacks: []string{"rare*"}, EntryStackDepth: 2}})
+	rareCall("rareMigration")
+	rareCall("common")
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Expected two records, got %d (%v)", len(records), err)
+	}
+	stack := records[0].EntryStack
+	if len(stack) != 2 {
+		t.Fatalf("Expected a stack of two frames, got %v", stack)
+	}
+	if !strings.HasSuffix(stack[0].Function, ".TestEntryStacksRecordTheCallersOfMatchingFunctions") || !strings.HasSuffix(stack[0].File, "entrystack_test.go") {
+		t.Errorf("Expected the stack to start at the caller of rareCall, got %v", stack[0])
+	}
+	for _, frame := range stack {
+		if strings.Contains(frame.Function, "pkg/tracer.") || strings.HasSuffix(frame.Function, ".rareCall") {
+			t.Errorf("Expected no frame of the tracer or of the called function, got %v", frame)
+		}
+	}
+	if records[1].EntryStack != nil {
+		t.Errorf("Expected no stack for a function not listed, got %v", records[1].EntryStack)
+	}
+}
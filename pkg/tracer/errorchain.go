@@ -0,0 +1,74 @@
+package tracer
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// maxErrorChainLinks bounds the number of links recorded for one error, protecting against
+// cyclic or extremely deep chains.
+const maxErrorChainLinks = 32
+
+// ErrorLink is one error in the unwrap chain of a returned error.
+type ErrorLink struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	// Depth is the nesting level, 0 for the returned error. Errors joined with errors.Join
+	// appear as siblings at the same depth.
+	Depth int `json:"depth,omitempty"`
+	// Stack is the stack trace captured where the error was created, for errors that carry one
+	// (such as those from github.com/pkg/errors). Only the innermost such error records it.
+	Stack string `json:"stack,omitempty"`
+}
+
+// captureErrorChains reports whether returned errors have their chain recorded. Walking a chain
+// calls the errors' methods, so tracing.capture.raceSafe turns it off.
+func captureErrorChains() bool {
+	t := activeConfig.Tracing
+	return t.CaptureErrorChains && !t.Capture.RaceSafe
+}
+
+// errorChain walks the unwrap chain of err: Unwrap() error (fmt.Errorf with %w), Unwrap() []error
+// (errors.Join and multiple %w), and Cause() error (github.com/pkg/errors).
+func errorChain(err error) []ErrorLink {
+	var chain []ErrorLink
+	var innermostWithStack error
+	stackAt := -1
+	var walk func(err error, depth int)
+	walk = func(err error, depth int) {
+		for err != nil && len(chain) < maxErrorChainLinks {
+			chain = append(chain, ErrorLink{Type: fmt.Sprintf("%T", err), Message: err.Error(), Depth: depth})
+			if hasStackTrace(err) {
+				innermostWithStack, stackAt = err, len(chain)-1
+			}
+			switch e := err.(type) {
+			case interface{ Unwrap() []error }:
+				for _, inner := range e.Unwrap() {
+					walk(inner, depth+1)
+				}
+				return
+			case interface{ Unwrap() error }:
+				err = e.Unwrap()
+			case interface{ Cause() error }:
+				err = e.Cause()
+			default:
+				return
+			}
+			depth++
+		}
+	}
+	walk(err, 0)
+	if innermostWithStack != nil {
+		// The %+v verb of github.com/pkg/errors prints the message followed by the stack.
+		chain[stackAt].Stack = fmt.Sprintf("%+v", innermostWithStack)
+	}
+	return chain
+}
+
+// hasStackTrace reports whether err has a StackTrace method, as errors created by
+// github.com/pkg/errors do. The method's result type is not referenced so the tracer does not
+// depend on that package.
+func hasStackTrace(err error) bool {
+	_, ok := reflect.TypeOf(err).MethodByName("StackTrace")
+	return ok
+}
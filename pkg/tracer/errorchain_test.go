@@ -0,0 +1,74 @@
+package tracer_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// stackError imitates an error from github.com/pkg/errors: it has a cause and a stack trace.
+type stackError struct {
+	msg   string
+	cause error
+}
+
+func (e *stackError) Error() string { return e.msg + ": " + e.cause.Error() }
+func (e *stackError) Cause() error  { return e.cause }
+func (e *stackError) StackTrace() []uintptr {
+	return nil
+}
+func (e *stackError) Format(s fmt.State, verb rune) {
+	fmt.Fprintf(s, "%s\nmain.load\n\t/src/main.go:12", e.Error())
+}
+
+func returnError(t *testing.T, cfg config.Config, err error) tracer.TraceRecord {
+	t.Helper()
+	withTracer(t, cfg)
+	start := time.Now()
+	tracer.RecordEntry("load")
+	tracer.RecordReturn("load", nil, err)
+	tracer.RecordExit("load", start)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, readErr := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if readErr != nil || len(records) != 1 {
+		t.Fatalf("Expected one record, got %d (%v)", len(records), readErr)
+	}
+	return records[0]
+}
+
+func TestErrorChainFollowsWrapJoinAndCause(t *testing.T) {
+	root := errors.New("connection refused")
+	joined := errors.Join(&stackError{msg: "dial", cause: root}, errors.New("retry budget exhausted"))
+	err := fmt.Errorf("load config: %w", joined)
+
+	rec := returnError(t, config.Config{Tracing: config.TracingConfig{CaptureErrorChains: true}}, err)
+
+	var got []string
+	for _, link := range rec.ErrorChain {
+		got = append(got, fmt.Sprintf("%d:%s", link.Depth, link.Type))
+	}
+	want := "0:*fmt.wrapError,1:*errors.joinError,2:*tracer_test.stackError,3:*errors.errorString,2:*errors.errorString"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("Expected chain %s, got %s", want, strings.Join(got, ","))
+	}
+	if rec.ErrorChain[3].Message != "connection refused" {
+		t.Errorf("Expected the root cause message, got %q", rec.ErrorChain[3].Message)
+	}
+	if !strings.Contains(rec.ErrorChain[2].Stack, "main.go:12") {
+		t.Errorf("Expected the stack of the error carrying one, got %q", rec.ErrorChain[2].Stack)
+	}
+}
+
+func TestErrorChainIsOptIn(t *testing.T) {
+	rec := returnError(t, config.Config{}, fmt.Errorf("wrapped: %w", errors.New("inner")))
+	if rec.ErrorChain != nil {
+		t.Errorf("Expected no error chain without tracing.captureErrorChains, got %v", rec.ErrorChain)
+	}
+}
@@ -0,0 +1,92 @@
+package tracer
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// SpanEvent is a named, timestamped occurrence inside a span, added with Event.
+type SpanEvent struct {
+	Name  string                 `json:"name"`
+	Time  time.Time              `json:"time"`
+	Attrs map[string]interface{} `json:"attrs,omitempty"`
+}
+
+// Event attaches a timestamped event to the innermost traced call open on the calling goroutine,
+// for moments worth marking inside a span such as a cache miss or a retry. Attribute values of
+// basic types are stored as they are; other values are rendered according to tracing.capture.
+// Events outside a traced call, or in calls that are not recorded, are only logged.
+//
+// Parameters:
+//   - name (string): the event name, e.g. "cache.miss".
+//   - attrs (map[string]any): attributes of the event, or nil.
+func Event(name string, attrs map[string]any) {
+	ensureInitialized()
+	now, _ := clockNow()
+	ev := SpanEvent{Name: name, Time: now, Attrs: eventAttrs(attrs)}
+	gid := goroutineID()
+	mu.Lock()
+	defer mu.Unlock()
+	rec := openSpanOn(gid)
+	if rec == nil {
+		logger.Printf("[TRACEWRAP] Event %s outside a traced call%s", name, formatAttrs(ev.Attrs))
+		return
+	}
+	addEvent(rec, ev)
+}
+
+// addEvent appends ev to rec unless rec is nil or not recorded. Callers must hold mu.
+func addEvent(rec *TraceRecord, ev SpanEvent) {
+	if rec == nil || rec.dropped {
+		return
+	}
+	rec.Events = append(rec.Events, ev)
+	logf(rec.FunctionName, "[TRACEWRAP] Event %s in %s, ID: %d%s", ev.Name, rec.FunctionName, rec.UniqueID, formatAttrs(ev.Attrs))
+}
+
+// openSpanOn returns the innermost open call on goroutine gid, or nil. Callers must hold mu.
+func openSpanOn(gid int64) *TraceRecord {
+	if calls := goroutineCalls[gid]; len(calls) > 0 {
+		return calls[len(calls)-1]
+	}
+	return nil
+}
+
+// eventAttrs copies attrs, keeping values of basic types and rendering the others, so every event
+// can be encoded as JSON.
+func eventAttrs(attrs map[string]any) map[string]interface{} {
+	if len(attrs) == 0 {
+		return nil
+	}
+	out := make(map[string]interface{}, len(attrs))
+	for k, v := range attrs {
+		switch v.(type) {
+		case nil, string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			out[k] = v
+		default:
+			out[k] = formatValue(v)
+		}
+	}
+	return out
+}
+
+// formatAttrs renders attributes as " key=value ..." sorted by key, or "" for none.
+func formatAttrs(attrs map[string]interface{}) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(" ")
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(formatValue(attrs[k]))
+	}
+	return sb.String()
+}
@@ -0,0 +1,47 @@
+package tracer_test
+
+import (
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestEventsAttachToInnermostSpan(t *testing.T) {
+	withTracer(t, config.Config{})
+	tracer.Event("startup", nil)
+	call("handle", func() {
+		call("load", func() {
+			tracer.Event("cache.miss", map[string]any{"key": "user:1", "attempt": 2, "tags": []string{"a", "b"}})
+		})
+		tracer.Event("loaded", nil)
+	})
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Expected two records, got %d (%v)", len(records), err)
+	}
+	byName := make(map[string]tracer.TraceRecord)
+	for _, rec := range records {
+		byName[rec.FunctionName] = rec
+	}
+	load := byName["load"]
+	if len(load.Events) != 1 || load.Events[0].Name != "cache.miss" {
+		t.Fatalf("Expected one cache.miss event on load, got %+v", load.Events)
+	}
+	ev := load.Events[0]
+	if ev.Time.Before(load.EntryTime) || ev.Time.After(load.ExitTime) {
+		t.Errorf("Expected the event time inside the span, got %v", ev.Time)
+	}
+	if ev.Attrs["key"] != "user:1" || ev.Attrs["attempt"] != float64(2) {
+		t.Errorf("Expected basic attributes to be kept, got %v", ev.Attrs)
+	}
+	if _, ok := ev.Attrs["tags"].(string); !ok {
+		t.Errorf("Expected a composite attribute to be rendered as a string, got %T", ev.Attrs["tags"])
+	}
+	if handle := byName["handle"]; len(handle.Events) != 1 || handle.Events[0].Name != "loaded" {
+		t.Errorf("Expected one loaded event on handle, got %+v", handle.Events)
+	}
+}
@@ -0,0 +1,66 @@
+package tracer
+
+import (
+	"os/exec"
+	"runtime/pprof"
+	"sync"
+
+	"github.com/mwiater/tracewrap/config"
+)
+
+// ResetForTest clears all recorded state and arranges for the tracer to re-initialize with cfg
+// on its next use, as if it had been embedded into a freshly started instrumented binary.
+func ResetForTest(cfg config.Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	if persistStop != nil {
+		close(persistStop)
+		persistStop = nil
+	}
+	traceRecords = nil
+	callStack = nil
+	goroutineCalls = make(map[int64][]*TraceRecord)
+	currentSpans.Clear()
+	uniqueID = 0
+	execFrequency.reset()
+	spawningSpans = make(map[*exec.Cmd]commandSpawn)
+	histograms = make(map[string]*Histogram)
+	persistedCount = 0
+	spilledCount = 0
+	funcAggregates.reset()
+	runSymbols, symbolsDirty = SymbolTable{}, false
+	regions = nil
+	overheadStats = make(map[string]*functionOverhead)
+	tailPending = make(map[int64][]*TraceRecord)
+	tailPendingRecords = 0
+	tailDecided = make(map[int64]bool)
+	tailKept = 0
+	tailDropped = 0
+	logWindows = make(map[string]*logWindow)
+	counters = make(map[string]int64)
+	gauges = make(map[string]*GaugeValue)
+	panicsNotified = make(map[string]bool)
+	panicsBundled = make(map[string]bool)
+	recentRecords = nil
+	if cpuProfile != nil {
+		pprof.StopCPUProfile()
+		cpuProfile.Close()
+		cpuProfile = nil
+	}
+	profiles = nil
+	recentNext = 0
+	SetClock(nil)
+	activeConfig = config.Config{}
+	embeddedConfig, _ = EncodeConfig(cfg)
+	initOnce = sync.Once{}
+}
+
+// BuildInfoOf exposes buildInfoOf to tests.
+var BuildInfoOf = buildInfoOf
+
+// StampVCS sets the git state the instrumenter stamps into binaries and returns a function that
+// clears it.
+func StampVCS(revision, time, modified string) func() {
+	vcsRevision, vcsTime, vcsModified = revision, time, modified
+	return func() { vcsRevision, vcsTime, vcsModified = "", "", "" }
+}
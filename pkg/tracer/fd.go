@@ -0,0 +1,70 @@
+package tracer
+
+import (
+	"os"
+	"path"
+	"sync"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+// fdCount serializes the counts of countFDs, so no count includes the descriptor another one holds
+// open for reading /proc/self/fd.
+var fdCount sync.Mutex
+
+// fdsEnabled reports whether tracing.trackFileDescriptors is set.
+func fdsEnabled() bool {
+	return activeConfig.Tracing.TrackFileDescriptors
+}
+
+// fdsTracked reports whether the open descriptors are counted for the calls of functionName: with
+// tracing.trackFileDescriptors, for every function or those tracing.fileDescriptorFunctions
+// matches.
+func fdsTracked(functionName string) bool {
+	if !fdsEnabled() {
+		return false
+	}
+	patterns := activeConfig.Tracing.FileDescriptorFunctions
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, functionName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// countFDs counts the file descriptors the process has open, at a call's entry or exit. Called
+// by enterRecord and exitRecord, outside mu.
+//
+// Returns:
+//   - int: the number of open descriptors.
+//   - bool: false if they cannot be counted on this platform.
+func countFDs() (int, bool) {
+	fdCount.Lock()
+	defer fdCount.Unlock()
+	return openFDs()
+}
+
+// openFDs returns the number of file descriptors the process has open: the entries of
+// /proc/self/fd where it exists, as on Linux, and gopsutil's count elsewhere. The descriptor used
+// for reading /proc/self/fd is counted too, which cancels out in deltas.
+//
+// Returns:
+//   - int: the number of open descriptors.
+//   - bool: false if they cannot be counted on this platform.
+func openFDs() (int, bool) {
+	if dir, err := os.Open("/proc/self/fd"); err == nil {
+		defer dir.Close()
+		names, err := dir.Readdirnames(-1)
+		return len(names), err == nil
+	}
+	proc, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		return 0, false
+	}
+	n, err := proc.NumFDs()
+	return int(n), err == nil
+}
@@ -0,0 +1,62 @@
+package tracer_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// fdDeltas runs calls that leak a descriptor, in a short call of its own, and that close the one
+// they open, and returns the fdDelta of each function's record.
+func fdDeltas(t *testing.T, cfg config.Config) map[string]int {
+	withTracer(t, cfg)
+	var leaked *os.File
+	call("handle", func() {
+		call("leak", func() {
+			var err error
+			if leaked, err = os.Create("leaked.txt"); err != nil {
+				t.Fatalf("Failed to create file: %v", err)
+			}
+		})
+	})
+	defer leaked.Close()
+	call("tidy", func() {
+		f, err := os.Create("tidy.txt")
+		if err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		f.Close()
+	})
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Expected three records, got %d (%v)", len(records), err)
+	}
+	deltas := make(map[string]int)
+	for _, rec := range records {
+		deltas[rec.FunctionName] = rec.FDDelta
+	}
+	return deltas
+}
+
+func TestTrackFileDescriptorsRecordsOpenedDescriptors(t *testing.T) {
+	deltas := fdDeltas(t, config.Config{Tracing: config.TracingConfig{TrackFileDescriptors: true}})
+	for name, want := range map[string]int{"handle": 1, "leak": 1, "tidy": 0} {
+		if deltas[name] != want {
+			t.Errorf("Expected %s to change the open descriptors by %d, got %d", name, want, deltas[name])
+		}
+	}
+}
+
+func TestFileDescriptorFunctionsLimitTheCountedCalls(t *testing.T) {
+	deltas := fdDeltas(t, config.Config{Tracing: config.TracingConfig{TrackFileDescriptors: true, FileDescriptorFunctions: []string{"han*"}}})
+	for name, want := range map[string]int{"handle": 1, "leak": 0, "tidy": 0} {
+		if deltas[name] != want {
+			t.Errorf("Expected %s to change the open descriptors by %d, got %d", name, want, deltas[name])
+		}
+	}
+}
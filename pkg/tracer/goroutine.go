@@ -0,0 +1,151 @@
+package tracer
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+)
+
+// goroutineID returns the ID of the calling goroutine, parsed from the header of its stack
+// trace ("goroutine 42 [running]:"). The runtime does not expose the ID directly.
+func goroutineID() int64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	b := bytes.TrimPrefix(buf[:n], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
+
+// goroutineCalls holds the open calls of callStack by goroutine, innermost last, so a call finds
+// its caller among the calls of its own goroutine. The records of light mode, which carry no
+// goroutine ID, are left out. Guarded by mu.
+var goroutineCalls = make(map[int64][]*TraceRecord)
+
+// openSpanIDs are the IDs of the innermost open call of a goroutine and of the root of its trace,
+// as CurrentSpan returns them, and the runtime name of its function.
+type openSpanIDs struct {
+	span, root int64
+	funcName   string
+}
+
+// currentSpans mirrors the innermost calls of goroutineCalls by goroutine ID as openSpanIDs. It
+// is written with mu held but read without it, so CurrentSpan can be called from the
+// application's String and Error methods while the tracer renders values under mu, as from a
+// logger they log through, and enterRecord can compare the stack with the caller before it takes
+// mu.
+var currentSpans sync.Map
+
+// pushGoroutineCall adds rec, which has just been entered, to the open calls of its goroutine.
+// Callers must hold mu.
+func pushGoroutineCall(rec *TraceRecord) {
+	goroutineCalls[rec.GoroutineID] = append(goroutineCalls[rec.GoroutineID], rec)
+	currentSpans.Store(rec.GoroutineID, openSpanIDs{span: rec.UniqueID, root: rec.rootID, funcName: rec.funcName})
+}
+
+// popGoroutineCall removes rec, which is exiting, from the open calls of its goroutine, and
+// forgets a goroutine without open calls. Callers must hold mu.
+func popGoroutineCall(rec *TraceRecord) {
+	calls := goroutineCalls[rec.GoroutineID]
+	for i := len(calls) - 1; i >= 0; i-- {
+		if calls[i] == rec {
+			calls = append(calls[:i], calls[i+1:]...)
+			break
+		}
+	}
+	if len(calls) == 0 {
+		delete(goroutineCalls, rec.GoroutineID)
+		currentSpans.Delete(rec.GoroutineID)
+	} else {
+		goroutineCalls[rec.GoroutineID] = calls
+		top := calls[len(calls)-1]
+		currentSpans.Store(rec.GoroutineID, openSpanIDs{span: top.UniqueID, root: top.rootID, funcName: top.funcName})
+	}
+}
+
+// callerOn returns the caller of a call entered on goroutine gid: the innermost call open on gid,
+// or for the first traced call on a goroutine, the innermost call still open on the goroutine that
+// started it. Callers must hold mu.
+func callerOn(gid int64) *TraceRecord {
+	if rec := openSpanOn(gid); rec != nil {
+		return rec
+	}
+	if len(goroutineCalls) == 0 {
+		return nil
+	}
+	return openSpanOn(creatorGoroutineID())
+}
+
+// creatorGoroutineID returns the ID of the goroutine that started the calling goroutine, parsed
+// from the end of its stack trace ("created by main.main in goroutine 1"), or 0 for the main
+// goroutine. The whole stack trace is formatted, so it is only read for the first traced call on
+// a goroutine.
+func creatorGoroutineID() int64 {
+	buf := make([]byte, 1024)
+	for {
+		n := runtime.Stack(buf, false)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	i := bytes.LastIndex(buf, []byte("\ncreated by "))
+	if i < 0 {
+		return 0
+	}
+	line := buf[i+1:]
+	if j := bytes.IndexByte(line, '\n'); j >= 0 {
+		line = line[:j]
+	}
+	j := bytes.LastIndex(line, []byte(" in goroutine "))
+	if j < 0 {
+		return 0
+	}
+	id, _ := strconv.ParseInt(string(line[j+len(" in goroutine "):]), 10, 64)
+	return id
+}
+
+// RecordLabels records the pprof labels carried by ctx (set with pprof.Do or pprof.WithLabels)
+// on the current function call. The instrumenter calls it for functions that take a
+// context.Context parameter.
+//
+// Parameters:
+//   - ctx (context.Context): the function's context parameter; nil is ignored.
+func RecordLabels(ctx context.Context) {
+	ensureInitialized()
+	labels := contextLabels(ctx)
+	if labels == nil {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(callStack) > 0 {
+		top := callStack[len(callStack)-1]
+		if top.dropped {
+			return
+		}
+		top.Labels = labels
+	}
+}
+
+// contextLabels returns the pprof labels carried by ctx, or nil if it has none.
+func contextLabels(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+	labels := make(map[string]string)
+	pprof.ForLabels(ctx, func(key, value string) bool {
+		labels[key] = value
+		return true
+	})
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
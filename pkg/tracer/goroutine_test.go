@@ -0,0 +1,106 @@
+package tracer_test
+
+import (
+	"context"
+	"runtime/pprof"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestRecordsCarryGoroutineIDAndLabels(t *testing.T) {
+	withTracer(t, config.Config{})
+
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels("route", "/checkout", "tenant", "acme"))
+	start := time.Now()
+	tracer.RecordEntry("handler")
+	tracer.RecordLabels(ctx)
+	tracer.RecordExit("handler", start)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		call("worker", nil)
+	}()
+	wg.Wait()
+
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("ReadTraceFile returned error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	handler, worker := records[0], records[1]
+	if handler.GoroutineID == 0 || worker.GoroutineID == 0 || handler.GoroutineID == worker.GoroutineID {
+		t.Errorf("Expected distinct non-zero goroutine IDs, got %d and %d", handler.GoroutineID, worker.GoroutineID)
+	}
+	if handler.Labels["route"] != "/checkout" || handler.Labels["tenant"] != "acme" {
+		t.Errorf("Expected pprof labels on the record, got %v", handler.Labels)
+	}
+}
+
+func TestCallsFindTheirCallerOnTheirOwnGoroutine(t *testing.T) {
+	withTracer(t, config.Config{})
+	started, step, nestedIn, done, ended := make(chan struct{}), make(chan struct{}), make(chan struct{}), make(chan struct{}), make(chan struct{})
+	// other and nested run on another goroutine, interleaved with the calls of parent.
+	go func() {
+		other := tracer.RecordEntry("other")
+		close(started)
+		<-step
+		nested := tracer.RecordEntry("nested")
+		close(nestedIn)
+		<-done
+		nested.End()
+		other.End()
+		close(ended)
+	}()
+	<-started
+	parent := tracer.RecordEntry("parent")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		call("worker", nil)
+	}()
+	wg.Wait()
+	close(step)
+	<-nestedIn
+	child := tracer.RecordEntry("child")
+	child.End()
+	parent.End()
+	close(done)
+	<-ended
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("ReadTraceFile returned error: %v", err)
+	}
+	ids := make(map[string]tracer.TraceRecord)
+	for _, rec := range records {
+		ids[rec.FunctionName] = rec
+	}
+	if len(ids) != 5 {
+		t.Fatalf("Expected 5 records, got %d", len(records))
+	}
+	// nested is the innermost open call when child is entered, on another goroutine.
+	if ids["child"].CallerID != ids["parent"].UniqueID || ids["nested"].CallerID != ids["other"].UniqueID {
+		t.Errorf("Expected child under parent and nested under other, got callers %d and %d", ids["child"].CallerID, ids["nested"].CallerID)
+	}
+	// The worker's goroutine was started by parent's.
+	if ids["worker"].CallerID != ids["parent"].UniqueID {
+		t.Errorf("Expected worker under parent, got caller %d", ids["worker"].CallerID)
+	}
+	if ids["other"].CallerID != 0 || ids["parent"].CallerID != 0 {
+		t.Errorf("Expected other and parent to be root calls, got callers %d and %d", ids["other"].CallerID, ids["parent"].CallerID)
+	}
+}
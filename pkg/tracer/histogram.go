@@ -0,0 +1,153 @@
+package tracer
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultHistogramBuckets are the latency bucket upper bounds used when
+// tracing.histogramBuckets is not configured.
+var DefaultHistogramBuckets = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Histogram accumulates a latency distribution using fixed bucket boundaries.
+// Counts has one entry per boundary plus a final overflow bucket for observations
+// larger than the last boundary.
+type Histogram struct {
+	Bounds []time.Duration `json:"bounds"`
+	Counts []uint64        `json:"counts"`
+	Count  uint64          `json:"count"`
+	Sum    time.Duration   `json:"sum"`
+	Min    time.Duration   `json:"min"`
+	Max    time.Duration   `json:"max"`
+}
+
+// NewHistogram creates an empty histogram with the given bucket upper bounds.
+// Bounds are copied and sorted; DefaultHistogramBuckets is used when bounds is empty.
+//
+// Parameters:
+//   - bounds ([]time.Duration): the bucket upper bounds.
+//
+// Returns:
+//   - *Histogram: the new histogram.
+func NewHistogram(bounds []time.Duration) *Histogram {
+	if len(bounds) == 0 {
+		bounds = DefaultHistogramBuckets
+	}
+	sorted := append([]time.Duration(nil), bounds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return &Histogram{
+		Bounds: sorted,
+		Counts: make([]uint64, len(sorted)+1),
+	}
+}
+
+// Observe adds a single duration to the histogram.
+//
+// Parameters:
+//   - d (time.Duration): the observed duration.
+func (h *Histogram) Observe(d time.Duration) {
+	i := sort.Search(len(h.Bounds), func(i int) bool { return d <= h.Bounds[i] })
+	h.Counts[i]++
+	if h.Count == 0 || d < h.Min {
+		h.Min = d
+	}
+	if d > h.Max {
+		h.Max = d
+	}
+	h.Count++
+	h.Sum += d
+}
+
+// Quantile estimates the q-th quantile (0 <= q <= 1) by linear interpolation within the
+// bucket containing it. Estimates are clamped to the observed minimum and maximum.
+//
+// Parameters:
+//   - q (float64): the quantile to estimate, e.g. 0.99.
+//
+// Returns:
+//   - time.Duration: the estimated quantile, or 0 if the histogram is empty.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := q * float64(h.Count)
+	var cumulative float64
+	for i, c := range h.Counts {
+		if c == 0 {
+			continue
+		}
+		if cumulative+float64(c) >= rank {
+			lower := h.Min
+			if i > 0 && h.Bounds[i-1] > lower {
+				lower = h.Bounds[i-1]
+			}
+			upper := h.Max
+			if i < len(h.Bounds) && h.Bounds[i] < upper {
+				upper = h.Bounds[i]
+			}
+			fraction := (rank - cumulative) / float64(c)
+			return lower + time.Duration(fraction*float64(upper-lower))
+		}
+		cumulative += float64(c)
+	}
+	return h.Max
+}
+
+// HistogramSummary is the exported view of a function's latency distribution.
+type HistogramSummary struct {
+	Histogram
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+}
+
+// histograms holds one latency histogram per function name. Guarded by mu.
+var histograms = make(map[string]*Histogram)
+
+// observeDuration records d in the histogram for functionName. Callers must hold mu.
+func observeDuration(functionName string, d time.Duration) {
+	h, ok := histograms[functionName]
+	if !ok {
+		h = NewHistogram(activeConfig.Tracing.HistogramBuckets)
+		histograms[functionName] = h
+	}
+	h.Observe(d)
+}
+
+// Histograms returns a snapshot of the per-function latency distributions recorded so far.
+// Every completed call contributes, independently of which per-call records are retained.
+//
+// Returns:
+//   - map[string]HistogramSummary: latency summaries keyed by function name.
+func Histograms() map[string]HistogramSummary {
+	mu.Lock()
+	defer mu.Unlock()
+	return histogramSummaries()
+}
+
+// histogramSummaries builds the snapshot returned by Histograms. Callers must hold mu.
+func histogramSummaries() map[string]HistogramSummary {
+	out := make(map[string]HistogramSummary, len(histograms))
+	for name, h := range histograms {
+		cp := *h
+		cp.Bounds = append([]time.Duration(nil), h.Bounds...)
+		cp.Counts = append([]uint64(nil), h.Counts...)
+		out[name] = HistogramSummary{
+			Histogram: cp,
+			P50:       h.Quantile(0.50),
+			P90:       h.Quantile(0.90),
+			P99:       h.Quantile(0.99),
+		}
+	}
+	return out
+}
@@ -0,0 +1,55 @@
+package tracer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestHistogramObserveAndQuantile(t *testing.T) {
+	h := tracer.NewHistogram([]time.Duration{10 * time.Millisecond, time.Millisecond, 100 * time.Millisecond})
+
+	// Bounds are sorted on construction.
+	if h.Bounds[0] != time.Millisecond || h.Bounds[2] != 100*time.Millisecond {
+		t.Fatalf("Expected sorted bounds, got %v", h.Bounds)
+	}
+
+	for i := 0; i < 98; i++ {
+		h.Observe(500 * time.Microsecond)
+	}
+	h.Observe(50 * time.Millisecond)
+	h.Observe(2 * time.Second)
+
+	if h.Count != 100 {
+		t.Fatalf("Expected 100 observations, got %d", h.Count)
+	}
+	expectedCounts := []uint64{98, 0, 1, 1}
+	for i, c := range expectedCounts {
+		if h.Counts[i] != c {
+			t.Errorf("Bucket %d: expected %d observations, got %d", i, c, h.Counts[i])
+		}
+	}
+	if h.Min != 500*time.Microsecond || h.Max != 2*time.Second {
+		t.Errorf("Unexpected min/max: %v/%v", h.Min, h.Max)
+	}
+	if p50 := h.Quantile(0.5); p50 > time.Millisecond {
+		t.Errorf("Expected p50 within the first bucket, got %v", p50)
+	}
+	if p99 := h.Quantile(0.99); p99 < 10*time.Millisecond || p99 > 100*time.Millisecond {
+		t.Errorf("Expected p99 within the (10ms, 100ms] bucket, got %v", p99)
+	}
+	if p100 := h.Quantile(1); p100 != 2*time.Second {
+		t.Errorf("Expected p100 to equal the maximum, got %v", p100)
+	}
+}
+
+func TestHistogramDefaults(t *testing.T) {
+	h := tracer.NewHistogram(nil)
+	if len(h.Bounds) != len(tracer.DefaultHistogramBuckets) {
+		t.Fatalf("Expected default buckets, got %v", h.Bounds)
+	}
+	if q := h.Quantile(0.99); q != 0 {
+		t.Errorf("Expected zero quantile for an empty histogram, got %v", q)
+	}
+}
@@ -0,0 +1,55 @@
+// Code generated by TestBenchmarkCalls in pkg/instrument from
+// testdata/benchmark/injected_calls.input; DO NOT EDIT.
+
+package tracer_test
+
+import (
+	"github.com/mwiater/tracewrap/pkg/tracer"
+	"runtime/debug"
+)
+
+func injectedCall() {
+	__tracewrap_snapshot := tracer.TakeSnapshot(tracer.MeasureAll)
+	__tracewrap_span := tracer.RecordEntryID(1, "injectedCall")
+	defer __tracewrap_span.RecordAll(__tracewrap_snapshot)
+	defer func() {
+		r := recover()
+		if r != nil {
+			__tracewrap_span.RecordPanic(r, string(debug.Stack()))
+			panic(r)
+		}
+	}()
+}
+
+func injectedCallWithParams(g, i int) {
+	__tracewrap_snapshot := tracer.TakeSnapshot(tracer.MeasureAll)
+	__tracewrap_span := tracer.RecordEntryID(2, "injectedCallWithParams")
+	defer __tracewrap_span.RecordAll(__tracewrap_snapshot)
+	defer func() {
+		r := recover()
+		if r != nil {
+			__tracewrap_span.RecordPanic(r, string(debug.Stack()))
+			panic(r)
+		}
+	}()
+	__tracewrap_span.SetAttribute("g", g)
+	__tracewrap_span.SetAttribute("i", i)
+}
+
+func injectedCallWithReturns(i int) (int, error) {
+	__tracewrap_snapshot := tracer.TakeSnapshot(tracer.MeasureAll)
+	__tracewrap_span := tracer.RecordEntryID(3, "injectedCallWithReturns")
+	defer __tracewrap_span.RecordAll(__tracewrap_snapshot)
+	defer func() {
+		r := recover()
+		if r != nil {
+			__tracewrap_span.RecordPanic(r, string(debug.Stack()))
+			panic(r)
+		}
+	}()
+	__tracewrap_span.SetAttribute("i", i)
+	{
+		__tracewrap_span.RecordReturn(i, nil)
+		return i, nil
+	}
+}
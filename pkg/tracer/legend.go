@@ -0,0 +1,144 @@
+package tracer
+
+import (
+	"fmt"
+	"html"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/theme"
+)
+
+// Symbols of legend entries.
+const (
+	LegendSolid  = "──▶" // A solid edge.
+	LegendDashed = "╌╌▶" // A dashed edge.
+	LegendDotted = "┈┈▶" // A dotted edge.
+	LegendBold   = "━━▶" // A bold edge.
+	LegendFill   = "■"   // A node's fill color.
+)
+
+// LegendEntry is a line of the legend of a call graph: a symbol in a color, and what it stands for.
+type LegendEntry struct {
+	Symbol string // One of the Legend symbols.
+	Color  string // A color of the graph's palette, "" for the color of its text.
+	Text   string
+}
+
+// legendSet collects the entries of a legend in the order they are first added, once each.
+type legendSet struct {
+	entries []LegendEntry
+	seen    map[LegendEntry]bool
+}
+
+// add appends e unless it was added before.
+func (l *legendSet) add(e LegendEntry) {
+	if l.seen == nil {
+		l.seen = make(map[LegendEntry]bool)
+	}
+	if !l.seen[e] {
+		l.seen[e] = true
+		l.entries = append(l.entries, e)
+	}
+}
+
+// DOTLegend returns the statements of a legend node listing entries at the bottom of a DOT graph,
+// or "" without entries. The node has no "[label=" attribute list of its own, so it is not taken
+// for one of the graph's functions.
+//
+// Parameters:
+//   - entries ([]LegendEntry): the lines of the legend, in order.
+//
+// Returns:
+//   - string: the DOT statements, indented for the top level of the graph.
+func DOTLegend(entries []LegendEntry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(`<table border="0" cellborder="0" cellspacing="0" cellpadding="2"><tr><td colspan="2" align="left"><b>Legend</b></td></tr>`)
+	for _, e := range entries {
+		symbol := html.EscapeString(e.Symbol)
+		if e.Color != "" {
+			symbol = fmt.Sprintf(`<font color="%s">%s</font>`, html.EscapeString(e.Color), symbol)
+		}
+		fmt.Fprintf(&sb, `<tr><td>%s</td><td align="left">%s</td></tr>`, symbol, html.EscapeString(e.Text))
+	}
+	sb.WriteString("</table>")
+	return fmt.Sprintf("  { rank=sink; \"tracewrap:legend\" [shape=none, style=\"\", margin=0, label=<%s>]; }\n", sb.String())
+}
+
+// activePalette returns the palette of visualization.theme, or the default one for a theme that
+// does not validate.
+func activePalette() theme.Palette {
+	palette, err := theme.New(activeConfig.Visualization.Theme.Name, activeConfig.Visualization.Theme.Palette)
+	if err != nil {
+		return theme.Default()
+	}
+	return palette
+}
+
+// DOTFooter returns the graph attributes that write lines under a DOT graph, or "" without lines.
+// Subgraphs declared before it keep their own labels.
+//
+// Parameters:
+//   - lines ([]string): the lines of the footer, such as the Summary of the run the graph shows.
+//
+// Returns:
+//   - string: the DOT statements, indented for the top level of the graph.
+func DOTFooter(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	escaped := make([]string, len(lines))
+	for i, line := range lines {
+		escaped[i] = escapeDOT(line)
+	}
+	return fmt.Sprintf("  label=\"%s\\l\";\n  labelloc=\"b\";\n  labeljust=\"l\";\n  fontsize=10;\n", strings.Join(escaped, "\\l"))
+}
+
+// Summary describes the run in a line for the footers of graphs, e.g.
+// "server, run of 2026-10-14 09:30:00 UTC, 1.250s, 1234 calls, tracewrap v1.2.3". Calls counts
+// every call of an instrumented function, those whose records were not kept included.
+//
+// Returns:
+//   - string: the summary.
+func (m RunMetadata) Summary() string {
+	binary, calls, version := m.summaryFields()
+	var duration time.Duration
+	if m.EndedAt.After(m.StartedAt) {
+		duration = m.EndedAt.Sub(m.StartedAt).Round(time.Millisecond)
+	}
+	return fmt.Sprintf("%s, run of %s, %v, %d calls, tracewrap %s", binary, m.StartedAt.UTC().Format("2006-01-02 15:04:05 MST"), duration, calls, version)
+}
+
+// StableSummary is Summary without the time and duration of the run, e.g.
+// "server, 1234 calls, tracewrap v1.2.3", so that runs making the same calls share it.
+//
+// Returns:
+//   - string: the summary.
+func (m RunMetadata) StableSummary() string {
+	binary, calls, version := m.summaryFields()
+	return fmt.Sprintf("%s, %d calls, tracewrap %s", binary, calls, version)
+}
+
+// summaryFields returns the name of the binary, the number of calls and the tracewrap version
+// that Summary and StableSummary show.
+func (m RunMetadata) summaryFields() (binary string, calls int, version string) {
+	for _, n := range m.ExecFrequency {
+		calls += n
+	}
+	if calls < m.Records {
+		calls = m.Records
+	}
+	binary = "binary"
+	if len(m.Args) > 0 {
+		binary = filepath.Base(m.Args[0])
+	}
+	version = m.TracewrapVersion
+	if version == "" {
+		version = "dev"
+	}
+	return binary, calls, version
+}
@@ -0,0 +1,129 @@
+package tracer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// recordPool recycles the records of the calls of instrumented functions traced in light mode
+// (tracing.light), which are folded into the aggregates and not kept, so the entry and exit of a
+// call allocate nothing. No values are captured in light mode, as passing them would box them.
+var recordPool = sync.Pool{New: func() any { return new(TraceRecord) }}
+
+// lightMode reports whether tracing.light is set: calls only update the execution counts, the
+// latency histograms and the aggregates, and none of their records are kept.
+func lightMode() bool {
+	return activeConfig.Tracing.Light
+}
+
+// enterLight is enterRecord for light mode. The call is pushed onto the stack with only what
+// its exit and the aggregates need: its name, IDs, caller, recursion depth and one monotonic
+// clock reading. Nothing is logged and no value or metric is read. Without a parent, the caller
+// is the innermost open call of any goroutine: light mode does not read goroutine IDs, so the
+// callers of calls on concurrent goroutines in its aggregated call edges may be calls of another.
+//
+// Parameters:
+//   - functionID (int): the function's symbol ID, or 0.
+//   - functionName (string): the name of the function being entered.
+//   - parent (*Span): the caller, or nil for the innermost open call. A parent that has already
+//     ended is the caller by its ID and name.
+//   - pooled (bool): take the record from recordPool, to be recycled when the call exits. Only
+//     the records of instrumented functions are, as no span of theirs is used after its exit.
+//
+// Returns:
+//   - Span: the call's span, whose record is always dropped.
+func enterLight(functionID int, functionName string, parent *Span, pooled bool) Span {
+	var record *TraceRecord
+	if pooled {
+		record = recordPool.Get().(*TraceRecord)
+	} else {
+		record = new(TraceRecord)
+	}
+	entryNanos := clockNanos()
+	mu.Lock()
+	defer mu.Unlock()
+	record.UniqueID = atomic.AddInt64(&uniqueID, 1)
+	record.SchemaVersion = SchemaVersion
+	record.FunctionName = functionName
+	record.FunctionID = functionID
+	record.EntryNanos = entryNanos
+	record.dropped = true
+	record.pooled = pooled
+	var caller *TraceRecord
+	if parent != nil {
+		caller = parent.open()
+	} else if len(callStack) > 0 {
+		caller = callStack[len(callStack)-1]
+	}
+	if caller != nil {
+		record.CallerID = caller.UniqueID
+		record.callerName = caller.FunctionName
+		record.callerKey = caller.functionKey()
+		record.parent = caller
+		markRecursion(record)
+	} else if parent != nil {
+		record.CallerID = parent.id
+		record.callerName = parent.name
+		record.callerKey = parent.key
+	}
+	callStack = append(callStack, record)
+	return spanOf(record)
+}
+
+// exitLight is exitRecord for light mode: the call of span, or the innermost open call if span is
+// nil, is timed from its monotonic readings, folded into the latency histogram and the aggregates
+// of its function, and recycled if it is pooled. A span is ended in the same critical section as
+// its call exits.
+func exitLight(functionName string, span *Span, count bool) {
+	exitNanos := clockNanos()
+	mu.Lock()
+	var target *TraceRecord
+	if span != nil {
+		if target = span.open(); target == nil {
+			mu.Unlock()
+			return
+		}
+		span.ended = true
+	}
+	sample, ok := finishLight(target, exitNanos)
+	mu.Unlock()
+	if ok {
+		funcAggregates.add(sample)
+	}
+	if count {
+		execFrequency.add(functionName)
+	}
+}
+
+// finishLight pops target, or the innermost open call if target is nil, and completes it at
+// exitNanos. It returns the call's aggregate sample, to be added once mu is released, and false
+// if no call was open. Callers must hold mu.
+func finishLight(target *TraceRecord, exitNanos int64) (aggregateSample, bool) {
+	top := popRecord(target)
+	if top == nil {
+		return aggregateSample{}, false
+	}
+	top.ExitNanos = exitNanos
+	top.Duration = time.Duration(exitNanos - top.EntryNanos)
+	observeDuration(top.FunctionName, top.Duration)
+	addToCaller(top)
+	sample := sampleOf(top)
+	// Open calls may still read the record through their parent pointer, so it is recycled
+	// before mu is released.
+	if top.pooled {
+		*top = TraceRecord{}
+		recordPool.Put(top)
+	}
+	return sample, true
+}
+
+// openParent returns the caller of rec while its record still belongs to that call, or nil.
+// In light mode the record of a call is recycled when it exits, possibly before its callees on
+// other goroutines do. Callers must hold mu.
+func (rec *TraceRecord) openParent() *TraceRecord {
+	if p := rec.parent; p != nil && p.UniqueID == rec.CallerID {
+		return p
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package tracer_test
+
+import (
+	"runtime/debug"
+	"sync"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// lightCall calls lightOuter, whose code, like lightInner's, is what the instrumentation injects
+// in light mode: the snapshot, the entry, and the deferred exit and panic recovery. Parameters and
+// results are not captured in light mode.
+func lightCall() {
+	lightOuter(1)
+}
+
+//go:noinline
+func lightOuter(n int) (int, error) {
+	__tracewrap_snapshot := tracer.TakeSnapshot(tracer.MeasureAll)
+	__tracewrap_span := tracer.RecordEntryID(1, "outer")
+	defer __tracewrap_span.RecordAll(__tracewrap_snapshot)
+	defer func() {
+		r := recover()
+		if r != nil {
+			__tracewrap_span.RecordPanic(r, string(debug.Stack()))
+			panic(r)
+		}
+	}()
+	return lightInner(n), nil
+}
+
+//go:noinline
+func lightInner(n int) int {
+	__tracewrap_snapshot := tracer.TakeSnapshot(tracer.MeasureAll)
+	__tracewrap_span := tracer.RecordEntryID(2, "inner")
+	defer __tracewrap_span.RecordAll(__tracewrap_snapshot)
+	defer func() {
+		r := recover()
+		if r != nil {
+			__tracewrap_span.RecordPanic(r, string(debug.Stack()))
+			panic(r)
+		}
+	}()
+	return n + 1
+}
+
+func TestLightModeEntryExitDoesNotAllocate(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{Light: true}})
+	// The first calls create the counters, histograms and aggregates of the functions.
+	lightCall()
+	if allocs := testing.AllocsPerRun(1000, lightCall); allocs != 0 {
+		t.Errorf("Expected no allocations per call in light mode, got %v", allocs)
+	}
+}
+
+func TestLightModeKeepsOnlyAggregates(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{Light: true}})
+	const workers, calls = 8, 100
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < calls; i++ {
+				lightCall()
+			}
+		}()
+	}
+	wg.Wait()
+
+	status := tracer.CurrentStatus()
+	if status.RecordsInMemory != 0 || status.ActiveSpans != 0 {
+		t.Errorf("Expected no records kept or open, got %+v", status)
+	}
+	if status.TotalCalls != 2*workers*calls {
+		t.Errorf("Expected %d calls counted, got %d", 2*workers*calls, status.TotalCalls)
+	}
+	byName := make(map[string]int)
+	for _, fn := range tracer.CurrentStats(2).TopFunctions {
+		byName[fn.Name] = fn.Calls
+	}
+	if byName["outer"] != workers*calls || byName["inner"] != workers*calls {
+		t.Errorf("Unexpected aggregated calls: %v", byName)
+	}
+	if got := tracer.Histograms()["inner"].Count; got != workers*calls {
+		t.Errorf("Expected %d calls in the histogram of inner, got %d", workers*calls, got)
+	}
+}
@@ -0,0 +1,124 @@
+package tracer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"strconv"
+)
+
+// Attribute keys added to the application's log lines by NewLogHandler and NewLogWriter.
+const (
+	SpanIDKey  = "span_id"
+	TraceIDKey = "trace_id"
+)
+
+// CurrentSpan returns the innermost call still open on the calling goroutine and the root of its
+// trace (see tracing.tailSampling for what a trace is). It does not take the tracer's lock, so
+// it may be called from code the tracer runs while holding it, such as a String method of a
+// captured value that logs.
+//
+// Returns:
+//   - int64: the span's unique ID.
+//   - int64: the unique ID of the trace's root record.
+//   - bool: false if no traced call is open on the calling goroutine.
+func CurrentSpan() (int64, int64, bool) {
+	// No lazy initialization here: the log writer may be called from within it.
+	ids, ok := currentSpans.Load(goroutineID())
+	if !ok {
+		return 0, 0, false
+	}
+	span := ids.(openSpanIDs)
+	return span.span, span.root, true
+}
+
+// logHandler adds the current span and trace IDs to every record before passing it on.
+type logHandler struct {
+	next slog.Handler
+}
+
+// NewLogHandler wraps a slog handler so that records logged while a traced call is open carry
+// span_id and trace_id attributes. Instrumentation with instrumentation.correlateLogs wraps the
+// handlers passed to slog.New with it.
+//
+// Parameters:
+//   - next (slog.Handler): the application's handler.
+//
+// Returns:
+//   - slog.Handler: the wrapping handler.
+func NewLogHandler(next slog.Handler) slog.Handler {
+	if h, ok := next.(*logHandler); ok {
+		return h
+	}
+	return &logHandler{next: next}
+}
+
+func (h *logHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	if span, trace, ok := CurrentSpan(); ok {
+		r = r.Clone()
+		r.AddAttrs(slog.Int64(SpanIDKey, span), slog.Int64(TraceIDKey, trace))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logHandler{next: h.next.WithAttrs(attrs)}
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	return &logHandler{next: h.next.WithGroup(name)}
+}
+
+// logWriter appends the current span and trace IDs to each line written by a log.Logger.
+type logWriter struct {
+	w io.Writer
+}
+
+// NewLogWriter wraps the output of a log.Logger so that lines logged while a traced call is open
+// end with "span_id=<id> trace_id=<id>". Instrumentation with instrumentation.correlateLogs wraps
+// the writers passed to log.SetOutput with it.
+//
+// Parameters:
+//   - w (io.Writer): the logger's output.
+//
+// Returns:
+//   - io.Writer: the wrapping writer.
+func NewLogWriter(w io.Writer) io.Writer {
+	if lw, ok := w.(*logWriter); ok {
+		return lw
+	}
+	return &logWriter{w: w}
+}
+
+// Write relies on log.Logger writing each entry with a single call.
+func (lw *logWriter) Write(p []byte) (int, error) {
+	span, trace, ok := CurrentSpan()
+	if !ok {
+		return lw.w.Write(p)
+	}
+	line := bytes.TrimSuffix(p, []byte("\n"))
+	buf := make([]byte, 0, len(p)+40)
+	buf = append(buf, line...)
+	buf = append(buf, " "+SpanIDKey+"="...)
+	buf = strconv.AppendInt(buf, span, 10)
+	buf = append(buf, " "+TraceIDKey+"="...)
+	buf = strconv.AppendInt(buf, trace, 10)
+	buf = append(buf, '\n')
+	if _, err := lw.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// CorrelateLogs makes the standard logger, and with it slog's default logger, add the current
+// span and trace IDs to its lines. Instrumentation with instrumentation.correlateLogs calls it at
+// the start of func main.
+func CorrelateLogs() {
+	log.SetOutput(NewLogWriter(log.Writer()))
+}
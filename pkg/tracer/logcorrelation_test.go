@@ -0,0 +1,75 @@
+package tracer_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestLogsCarrySpanAndTraceIDs(t *testing.T) {
+	withTracer(t, config.Config{})
+	var slogOut, logOut bytes.Buffer
+	logger := slog.New(tracer.NewLogHandler(slog.NewJSONHandler(&slogOut, nil)))
+	std := log.New(tracer.NewLogWriter(&logOut), "", 0)
+
+	logger.Info("outside")
+	call("handle", func() {
+		call("load", func() {
+			logger.Info("loading", "key", "k1")
+			std.Println("loading k1")
+		})
+	})
+
+	lines := strings.Split(strings.TrimSpace(slogOut.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected two slog lines, got %q", slogOut.String())
+	}
+	if strings.Contains(lines[0], "span_id") {
+		t.Errorf("Expected no span outside traced calls, got %s", lines[0])
+	}
+	// handle is entered first (ID 1, the trace root), load second (ID 2).
+	if !strings.Contains(lines[1], `"span_id":2,"trace_id":1`) {
+		t.Errorf("Expected span and trace IDs on the slog line, got %s", lines[1])
+	}
+	if got, want := logOut.String(), fmt.Sprintf("loading k1 %s=2 %s=1\n", tracer.SpanIDKey, tracer.TraceIDKey); got != want {
+		t.Errorf("Expected log line %q, got %q", want, got)
+	}
+}
+
+// loggingStringer logs through a correlated logger when it is rendered.
+type loggingStringer struct {
+	logger *log.Logger
+}
+
+func (s loggingStringer) String() string {
+	s.logger.Println("rendering")
+	return "logged"
+}
+
+func TestStringerLoggingWhileCapturedDoesNotDeadlock(t *testing.T) {
+	withTracer(t, config.Config{})
+	var out bytes.Buffer
+	std := log.New(tracer.NewLogWriter(&out), "", 0)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		call("handle", func() {
+			tracer.RecordParam("value", loggingStringer{logger: std})
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Rendering a Stringer that logs deadlocked")
+	}
+	if got, want := out.String(), fmt.Sprintf("rendering %s=1 %s=1\n", tracer.SpanIDKey, tracer.TraceIDKey); got != want {
+		t.Errorf("Expected log line %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,83 @@
+package tracer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// logWindowLength is the period over which logging.maxLinesPerSecond is enforced.
+const logWindowLength = time.Second
+
+// logWindow counts one function's log lines in the current window.
+type logWindow struct {
+	start      time.Time
+	lines      int
+	suppressed int
+}
+
+var (
+	logLimitMu sync.Mutex
+	logWindows = make(map[string]*logWindow)
+)
+
+// allowLog reports whether another log line about functionName may be written under
+// logging.maxLinesPerSecond. When a window with suppressed lines ends, a summary line is
+// written first. Records and aggregates are unaffected by the limit.
+func allowLog(functionName string) bool {
+	limit := activeConfig.Logging.MaxLinesPerSecond
+	if limit <= 0 {
+		return true
+	}
+	logLimitMu.Lock()
+	defer logLimitMu.Unlock()
+	now := time.Now()
+	w, ok := logWindows[functionName]
+	if !ok {
+		w = &logWindow{start: now}
+		logWindows[functionName] = w
+	}
+	if now.Sub(w.start) >= logWindowLength {
+		reportSuppressed(functionName, w, now)
+		w.start, w.lines = now, 0
+	}
+	if w.lines < limit {
+		w.lines++
+		return true
+	}
+	w.suppressed++
+	return false
+}
+
+// logf writes a log line about functionName unless it is suppressed by allowLog.
+func logf(functionName, format string, args ...interface{}) {
+	if allowLog(functionName) {
+		logger.Printf(format, args...)
+	}
+}
+
+// reportSuppressed writes the summary for a window with suppressed lines and clears its count.
+// Callers must hold logLimitMu.
+func reportSuppressed(functionName string, w *logWindow, now time.Time) {
+	if w.suppressed == 0 {
+		return
+	}
+	logger.Printf("[TRACEWRAP] Suppressed %d log lines for %s in the last %v", w.suppressed, functionName, now.Sub(w.start).Round(time.Millisecond))
+	w.suppressed = 0
+}
+
+// flushSuppressedLogs writes the summaries of all windows with suppressed lines, so none are
+// lost when the program ends before the windows do.
+func flushSuppressedLogs() {
+	logLimitMu.Lock()
+	defer logLimitMu.Unlock()
+	names := make([]string, 0, len(logWindows))
+	for name := range logWindows {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now()
+	for _, name := range names {
+		reportSuppressed(name, logWindows[name], now)
+	}
+}
@@ -0,0 +1,39 @@
+package tracer_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestLogLinesAreRateLimitedPerFunction(t *testing.T) {
+	withTracer(t, config.Config{Logging: config.LoggingConfig{MaxLinesPerSecond: 2}})
+	for i := 0; i < 10; i++ {
+		call("hot", nil)
+	}
+	call("cold", nil)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+
+	data, err := os.ReadFile("tracewrap/latest/tracewrap.log")
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	log := string(data)
+	if n := strings.Count(log, "Entering hot"); n != 1 {
+		t.Errorf("Expected one entry line for hot within the window, got %d", n)
+	}
+	if !strings.Contains(log, "Suppressed 18 log lines for hot") {
+		t.Errorf("Expected a suppression summary for hot:\n%s", log)
+	}
+	if !strings.Contains(log, "Entering cold") || strings.Contains(log, "for cold") {
+		t.Errorf("Expected cold to be logged without suppression:\n%s", log)
+	}
+	if records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl"); err != nil || len(records) != 11 {
+		t.Errorf("Expected every call to be recorded, got %d (%v)", len(records), err)
+	}
+}
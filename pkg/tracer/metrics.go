@@ -0,0 +1,78 @@
+package tracer
+
+import (
+	"math"
+	"time"
+)
+
+// GaugeValue summarizes the values a gauge was set to during a run.
+type GaugeValue struct {
+	Last      float64   `json:"last"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Updates   int       `json:"updates"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// counters and gauges hold the application metrics of the current run, keyed by name.
+var (
+	counters = make(map[string]int64)
+	gauges   = make(map[string]*GaugeValue)
+)
+
+// Count adds delta to the named counter, e.g. tracer.Count("jobs.processed", 1). Counters are
+// aggregated over the whole run and written to run.json next to the trace. The instrumenter emits
+// calls to Count for `//tracewrap:count name="..."` comments.
+//
+// Parameters:
+//   - name (string): the counter name.
+//   - delta (int64): the amount to add; may be negative.
+func Count(name string, delta int64) {
+	ensureInitialized()
+	mu.Lock()
+	counters[name] += delta
+	mu.Unlock()
+}
+
+// Gauge sets the named gauge to value, e.g. tracer.Gauge("queue.depth", float64(len(queue))).
+// run.json records the last value together with the minimum, maximum and number of updates.
+//
+// Parameters:
+//   - name (string): the gauge name.
+//   - value (float64): the current value.
+func Gauge(name string, value float64) {
+	ensureInitialized()
+	now, _ := clockNow()
+	mu.Lock()
+	defer mu.Unlock()
+	g, ok := gauges[name]
+	if !ok {
+		g = &GaugeValue{Min: value, Max: value}
+		gauges[name] = g
+	}
+	g.Last = value
+	g.Min = math.Min(g.Min, value)
+	g.Max = math.Max(g.Max, value)
+	g.Updates++
+	g.UpdatedAt = now
+}
+
+// metricsSnapshot copies the counters and gauges of the run, or returns nil maps when there are
+// none. Callers must hold mu.
+func metricsSnapshot() (map[string]int64, map[string]GaugeValue) {
+	var c map[string]int64
+	if len(counters) > 0 {
+		c = make(map[string]int64, len(counters))
+		for name, v := range counters {
+			c[name] = v
+		}
+	}
+	var g map[string]GaugeValue
+	if len(gauges) > 0 {
+		g = make(map[string]GaugeValue, len(gauges))
+		for name, v := range gauges {
+			g[name] = *v
+		}
+	}
+	return c, g
+}
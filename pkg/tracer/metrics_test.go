@@ -0,0 +1,41 @@
+package tracer_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestCountersAndGaugesAreWrittenToRunMetadata(t *testing.T) {
+	withTracer(t, config.Config{})
+	call("worker", func() {
+		tracer.Count("jobs.processed", 1)
+		tracer.Count("jobs.processed", 2)
+		tracer.Count("jobs.failed", 1)
+		tracer.Count("jobs.failed", -1)
+		tracer.Gauge("queue.depth", 4)
+		tracer.Gauge("queue.depth", 9)
+		tracer.Gauge("queue.depth", 2)
+	})
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	data, err := os.ReadFile("tracewrap/latest/run.json")
+	if err != nil {
+		t.Fatalf("Failed to read run metadata: %v", err)
+	}
+	var meta tracer.RunMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("Failed to parse run metadata: %v", err)
+	}
+	if meta.Counters["jobs.processed"] != 3 || meta.Counters["jobs.failed"] != 0 {
+		t.Errorf("Unexpected counters: %v", meta.Counters)
+	}
+	g := meta.Gauges["queue.depth"]
+	if g.Last != 2 || g.Min != 2 || g.Max != 9 || g.Updates != 3 || g.UpdatedAt.IsZero() {
+		t.Errorf("Unexpected gauge: %+v", g)
+	}
+}
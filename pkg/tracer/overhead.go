@@ -0,0 +1,103 @@
+package tracer
+
+import "time"
+
+// captureLevel is how much the tracer records for a function. Functions start at levelFull and
+// are downgraded when their instrumentation cost exceeds tracing.maxOverheadPercent.
+type captureLevel int
+
+const (
+	levelFull    captureLevel = iota // Everything is captured.
+	levelReduced                     // Parameters, return values and system metrics are skipped.
+	levelSampled                     // As levelReduced, and only one in overheadSampleEvery calls is recorded.
+)
+
+// String returns the name used for the level in log lines and status output.
+func (l captureLevel) String() string {
+	switch l {
+	case levelReduced:
+		return "reduced"
+	case levelSampled:
+		return "sampled"
+	default:
+		return "full"
+	}
+}
+
+const (
+	// overheadWindow is the number of calls over which a function's overhead is evaluated.
+	overheadWindow = 50
+	// overheadSampleEvery is the sampling interval for functions at levelSampled.
+	overheadSampleEvery = 10
+)
+
+// functionOverhead tracks the tracer's own cost for one function over the current window.
+type functionOverhead struct {
+	level    captureLevel
+	calls    int           // Calls seen in total, used for sampling.
+	window   int           // Calls in the current evaluation window.
+	overhead time.Duration // Time spent in tracer hooks during the window.
+	duration time.Duration // Time spent in the function during the window.
+}
+
+// overheadStats holds the per-function overhead tracking. Guarded by mu.
+var overheadStats = make(map[string]*functionOverhead)
+
+// captureLevelFor returns the current capture level for functionName. Callers must hold mu.
+func captureLevelFor(functionName string) captureLevel {
+	if stats, ok := overheadStats[functionName]; ok {
+		return stats.level
+	}
+	return levelFull
+}
+
+// sampledOut counts a call of functionName and reports whether it is skipped because the function
+// was downgraded to levelSampled. Callers must hold mu.
+func sampledOut(functionName string) bool {
+	stats, ok := overheadStats[functionName]
+	if !ok {
+		return false
+	}
+	stats.calls++
+	return stats.level == levelSampled && stats.calls%overheadSampleEvery != 0
+}
+
+// accountOverhead adds the cost of a finished call's tracer hooks to its function's window and
+// downgrades the function when the window exceeds tracing.maxOverheadPercent. Callers must
+// hold mu.
+func accountOverhead(rec *TraceRecord) {
+	budget := activeConfig.Tracing.MaxOverheadPercent
+	if budget <= 0 {
+		return
+	}
+	stats, ok := overheadStats[rec.FunctionName]
+	if !ok {
+		stats = &functionOverhead{}
+		overheadStats[rec.FunctionName] = stats
+	}
+	stats.window++
+	stats.overhead += rec.overhead
+	stats.duration += rec.Duration
+	if stats.window < overheadWindow {
+		return
+	}
+	if stats.level < levelSampled && stats.duration > 0 {
+		percent := float64(stats.overhead) / float64(stats.duration) * 100
+		if percent > budget {
+			stats.level++
+			logger.Printf("[TRACEWRAP] Overhead for %s is %.1f%% (budget %.1f%%): capture downgraded to %s", rec.FunctionName, percent, budget, stats.level)
+		}
+	}
+	stats.window, stats.overhead, stats.duration = 0, 0, 0
+}
+
+// downgradedFunctions returns the capture level of every downgraded function. Callers must hold mu.
+func downgradedFunctions() map[string]string {
+	downgraded := make(map[string]string)
+	for name, stats := range overheadStats {
+		if stats.level != levelFull {
+			downgraded[name] = stats.level.String()
+		}
+	}
+	return downgraded
+}
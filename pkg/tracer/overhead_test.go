@@ -0,0 +1,127 @@
+package tracer_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestOverheadBudgetDowngradesCapture(t *testing.T) {
+	// A budget this small is exceeded by any call, so the function is downgraded at the end of
+	// each evaluation window: full, then reduced, then sampled.
+	withTracer(t, config.Config{Tracing: config.TracingConfig{MaxOverheadPercent: 0.0001}})
+
+	const calls = 200
+	for i := 0; i < calls; i++ {
+		start := time.Now()
+		tracer.RecordEntry("tiny")
+		tracer.RecordParam("i", i)
+		tracer.RecordExit("tiny", start)
+	}
+
+	status := tracer.CurrentStatus()
+	if got := status.Downgraded["tiny"]; got != "sampled" {
+		t.Fatalf("Expected tiny to be downgraded to sampled, got %q", got)
+	}
+	if status.RecordsInMemory >= calls {
+		t.Errorf("Expected sampling to drop records, got %d of %d", status.RecordsInMemory, calls)
+	}
+}
+
+func TestOverheadBudgetDisabledByDefault(t *testing.T) {
+	withTracer(t, config.Config{})
+	for i := 0; i < 200; i++ {
+		call("tiny", nil)
+	}
+	if downgraded := tracer.CurrentStatus().Downgraded; len(downgraded) != 0 {
+		t.Errorf("Expected no downgrades without a budget, got %v", downgraded)
+	}
+}
+
+func TestTracerOverheadRecorded(t *testing.T) {
+	withTracer(t, config.Config{})
+	call("outer", func() { call("inner", nil) })
+
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("ReadTraceFile returned error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	for _, rec := range records {
+		if rec.TracerOverhead <= 0 {
+			t.Errorf("Expected the overhead of %s to be recorded, got %v", rec.FunctionName, rec.TracerOverhead)
+		}
+	}
+}
+
+// benchmarkGoroutines are the numbers of goroutines the hot-path benchmarks spread their calls over.
+var benchmarkGoroutines = []int{1, 8, 64}
+
+// benchmarkConfig bounds the records the benchmarks keep in memory; the others are spilled.
+var benchmarkConfig = config.Config{Tracing: config.TracingConfig{MaxRecordsInMemory: 10000}}
+
+// benchmarkParallel runs b.N calls of fn spread over each number of goroutines, one sub-benchmark
+// each, with a fresh tracer configured with cfg. The time per op is the cost of one call.
+func benchmarkParallel(b *testing.B, cfg config.Config, fn func(g, i int)) {
+	for _, goroutines := range benchmarkGoroutines {
+		b.Run(fmt.Sprintf("goroutines=%d", goroutines), func(b *testing.B) {
+			withTracer(b, cfg)
+			b.ReportAllocs()
+			b.ResetTimer()
+			var wg sync.WaitGroup
+			for g := 0; g < goroutines; g++ {
+				n := b.N / goroutines
+				if g < b.N%goroutines {
+					n++
+				}
+				wg.Add(1)
+				go func(g, n int) {
+					defer wg.Done()
+					for i := 0; i < n; i++ {
+						fn(g, i)
+					}
+				}(g, n)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+// The benchmarks call the functions of injected_calls_test.go, which TestBenchmarkCalls in
+// pkg/instrument generates with the code the instrumentation injects: a snapshot, the entry, the
+// deferred exit and panic recovery, and the captures. Their defers keep them from being inlined,
+// so the deferred calls run as they do in instrumented functions.
+
+func BenchmarkRecordEntryExit(b *testing.B) {
+	benchmarkParallel(b, benchmarkConfig, func(g, i int) {
+		injectedCall()
+	})
+}
+
+func BenchmarkRecordParam(b *testing.B) {
+	benchmarkParallel(b, benchmarkConfig, func(g, i int) {
+		injectedCallWithParams(g, i)
+	})
+}
+
+func BenchmarkRecordReturn(b *testing.B) {
+	benchmarkParallel(b, benchmarkConfig, func(g, i int) {
+		injectedCallWithReturns(i)
+	})
+}
+
+func BenchmarkRecordEntryExitLight(b *testing.B) {
+	// Parameters and results are not captured in light mode.
+	benchmarkParallel(b, config.Config{Tracing: config.TracingConfig{Light: true}}, func(g, i int) {
+		injectedCall()
+	})
+}
@@ -0,0 +1,143 @@
+package tracer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// recentCalls is the number of completed calls kept for panic bundles.
+const recentCalls = 100
+
+// maxPanicBundles bounds the panic bundles written per run.
+const maxPanicBundles = 10
+
+// PanicBundle is the post-mortem state written to panic-<time>.json in the run directory when a
+// panic is recorded with tracing.panicBundle set.
+type PanicBundle struct {
+	SchemaVersion int              `json:"schemaVersion"`
+	RunID         string           `json:"runId"`
+	Time          time.Time        `json:"time"`
+	FunctionName  string           `json:"functionName"` // The function the panic was recovered in.
+	PanicValue    string           `json:"panicValue"`
+	StackTrace    string           `json:"stackTrace"`  // The stack of the panicking goroutine.
+	Goroutines    string           `json:"goroutines"`  // The stacks of all goroutines, as printed by runtime.Stack.
+	OpenCalls     []GoroutineCalls `json:"openCalls"`   // The traced calls still running, per goroutine.
+	RecentCalls   []TraceRecord    `json:"recentCalls"` // The calls completed last, oldest first.
+}
+
+// GoroutineCalls lists the traced calls open on a goroutine, outermost first.
+type GoroutineCalls struct {
+	GoroutineID int64      `json:"goroutineId"`
+	Calls       []OpenCall `json:"calls"`
+}
+
+// OpenCall is a traced call that had not returned when the panic was recorded.
+type OpenCall struct {
+	UniqueID     int64             `json:"uniqueId"`
+	FunctionName string            `json:"functionName"`
+	EntryTime    time.Time         `json:"entryTime"`
+	Params       map[string]string `json:"params,omitempty"`
+}
+
+var (
+	recentRecords []*TraceRecord          // Ring buffer of the recentCalls calls completed last.
+	recentNext    int                     // Index of the slot overwritten next once recentRecords is full.
+	panicsBundled = make(map[string]bool) // Panic values bundled, formatted with %v.
+)
+
+// rememberRecent adds a completed record to the ring buffer read by panic bundles. Callers must
+// hold mu.
+func rememberRecent(rec *TraceRecord) {
+	if !activeConfig.Tracing.PanicBundle {
+		return
+	}
+	if len(recentRecords) < recentCalls {
+		recentRecords = append(recentRecords, rec)
+		return
+	}
+	recentRecords[recentNext] = rec
+	recentNext = (recentNext + 1) % recentCalls
+}
+
+// renderedParams returns the parameters of rec, rendering those kept by tracing.capture.lazy
+// without consuming them.
+func renderedParams(rec *TraceRecord) map[string]string {
+	if len(rec.Params) == 0 && len(rec.rawParams) == 0 {
+		return nil
+	}
+	params := make(map[string]string, len(rec.Params)+len(rec.rawParams))
+	for name, v := range rec.Params {
+		params[name] = v
+	}
+	for name, v := range rec.rawParams {
+		params[name] = formatValue(v)
+	}
+	return params
+}
+
+// allStacks returns the stacks of all goroutines.
+func allStacks() string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// writePanicBundle writes the PanicBundle of a panic recovered in functionName, once per panic
+// value (a panic re-panicked by every traced frame is bundled by the innermost) and for at most
+// maxPanicBundles panics per run. Callers must hold mu.
+func writePanicBundle(functionName string, panicValue interface{}, stack string) {
+	key := fmt.Sprintf("%v", panicValue)
+	if !activeConfig.Tracing.PanicBundle || panicsBundled[key] || len(panicsBundled) >= maxPanicBundles {
+		return
+	}
+	panicsBundled[key] = true
+	now, _ := clockNow()
+	bundle := PanicBundle{
+		SchemaVersion: SchemaVersion,
+		RunID:         runID,
+		Time:          now,
+		FunctionName:  functionName,
+		PanicValue:    key,
+		StackTrace:    stack,
+		Goroutines:    allStacks(),
+	}
+	byGoroutine := make(map[int64]*GoroutineCalls)
+	for _, rec := range callStack {
+		g, ok := byGoroutine[rec.GoroutineID]
+		if !ok {
+			g = &GoroutineCalls{GoroutineID: rec.GoroutineID}
+			byGoroutine[rec.GoroutineID] = g
+		}
+		g.Calls = append(g.Calls, OpenCall{UniqueID: rec.UniqueID, FunctionName: rec.FunctionName, EntryTime: rec.EntryTime, Params: renderedParams(rec)})
+	}
+	for _, g := range byGoroutine {
+		bundle.OpenCalls = append(bundle.OpenCalls, *g)
+	}
+	sort.Slice(bundle.OpenCalls, func(i, j int) bool { return bundle.OpenCalls[i].GoroutineID < bundle.OpenCalls[j].GoroutineID })
+	for i := range recentRecords {
+		rec := *recentRecords[(recentNext+i)%len(recentRecords)]
+		rec.Params = renderedParams(&rec)
+		bundle.RecentCalls = append(bundle.RecentCalls, rec)
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		logger.Printf("[TRACEWRAP] Error encoding panic bundle: %v", err)
+		return
+	}
+	path := artifactPath("panic-" + now.UTC().Format("20060102-150405.000000") + ".json")
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		logger.Printf("[TRACEWRAP] Error writing panic bundle: %v", err)
+		return
+	}
+	logger.Printf("[TRACEWRAP] Panic bundle written to %s", path)
+}
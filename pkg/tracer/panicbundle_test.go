@@ -0,0 +1,76 @@
+package tracer_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestRecordPanicWritesBundle(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{PanicBundle: true}})
+
+	start := time.Now()
+	tracer.RecordEntry("main")
+	tracer.RecordParam("job", "nightly")
+	call("load", nil)
+	tracer.RecordEntry("divide")
+	tracer.RecordExit("divide", start)
+	tracer.RecordPanic("divide", "integer divide by zero", "goroutine 1 [running]:\nmain.divide(...)")
+	tracer.RecordPanic("main", "integer divide by zero", "goroutine 1 [running]:\nmain.main()")
+
+	bundles, _ := filepath.Glob(filepath.Join("tracewrap", "latest", "panic-*.json"))
+	if len(bundles) != 1 {
+		t.Fatalf("Expected one panic bundle, got %v", bundles)
+	}
+	data, err := os.ReadFile(bundles[0])
+	if err != nil {
+		t.Fatalf("Failed to read bundle: %v", err)
+	}
+	var bundle tracer.PanicBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		t.Fatalf("Invalid bundle: %v", err)
+	}
+	if bundle.FunctionName != "divide" || bundle.PanicValue != "integer divide by zero" || bundle.RunID != tracer.RunID() {
+		t.Errorf("Unexpected bundle header %+v", bundle)
+	}
+	if !strings.Contains(bundle.Goroutines, "goroutine ") {
+		t.Errorf("Expected the stacks of all goroutines, got %q", bundle.Goroutines)
+	}
+	if len(bundle.OpenCalls) != 1 || len(bundle.OpenCalls[0].Calls) != 1 {
+		t.Fatalf("Expected main open on one goroutine, got %+v", bundle.OpenCalls)
+	}
+	if open := bundle.OpenCalls[0].Calls[0]; open.FunctionName != "main" || open.Params["job"] != "nightly" {
+		t.Errorf("Unexpected open call %+v", open)
+	}
+	if len(bundle.RecentCalls) != 2 || bundle.RecentCalls[0].FunctionName != "load" || bundle.RecentCalls[1].FunctionName != "divide" {
+		t.Errorf("Expected load and divide as the recent calls, got %+v", bundle.RecentCalls)
+	}
+}
+
+func TestPanicBundleKeepsLatestCalls(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{PanicBundle: true}})
+	for i := 0; i < 150; i++ {
+		call("work", nil)
+	}
+	tracer.RecordPanic("work", "boom", "")
+
+	bundles, _ := filepath.Glob(filepath.Join("tracewrap", "latest", "panic-*.json"))
+	if len(bundles) != 1 {
+		t.Fatalf("Expected one panic bundle, got %v", bundles)
+	}
+	data, _ := os.ReadFile(bundles[0])
+	var bundle tracer.PanicBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		t.Fatalf("Invalid bundle: %v", err)
+	}
+	recent := bundle.RecentCalls
+	if len(recent) != 100 || recent[0].UniqueID != 51 || recent[99].UniqueID != 150 {
+		t.Errorf("Expected calls 51 to 150, got %d calls from %d", len(recent), recent[0].UniqueID)
+	}
+}
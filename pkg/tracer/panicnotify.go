@@ -0,0 +1,108 @@
+package tracer
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mwiater/tracewrap/pkg/notify"
+)
+
+// maxPanicNotifications bounds the panics posted to tracing.panicWebhook per run, so a panic in a
+// request handler that recovers and serves on does not flood the channel.
+const maxPanicNotifications = 10
+
+// panicNotifyTimeout bounds a panic notification, which delays the crash it reports.
+const panicNotifyTimeout = 5 * time.Second
+
+// maxNotifiedStack is the number of stack trace bytes included in a panic notification.
+const maxNotifiedStack = 4000
+
+// panicsNotified holds the panic values posted to tracing.panicWebhook, formatted with %v.
+var panicsNotified = make(map[string]bool)
+
+// PanicMessage returns the notification for a panic.
+//
+// Parameters:
+//   - functionName (string): the function the panic was recovered in.
+//   - panicValue (interface{}): the panic value.
+//   - stack (string): the stack trace; long traces are cut.
+//   - params (map[string]string): the parameters of the call, if known.
+//   - source (string): where the panic happened, e.g. the run ID.
+//
+// Returns:
+//   - notify.Message: the notification.
+func PanicMessage(functionName string, panicValue interface{}, stack string, params map[string]string, source string) notify.Message {
+	fields := []notify.Field{{Name: "Panic value", Value: fmt.Sprintf("%+v", panicValue)}}
+	if len(params) > 0 {
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var list string
+		for _, name := range names {
+			list += fmt.Sprintf("%s = %s\n", name, params[name])
+		}
+		fields = append(fields, notify.Field{Name: "Parameters", Value: list})
+	}
+	if len(stack) > maxNotifiedStack {
+		stack = stack[:maxNotifiedStack] + "\n..."
+	}
+	if stack != "" {
+		fields = append(fields, notify.Field{Name: "Stack", Value: stack})
+	}
+	return notify.Message{Title: "panic in " + functionName, Text: source, Fields: fields}
+}
+
+// panicNotification returns the notification for a panic recovered in functionName, and whether
+// to send it: only with tracing.panicWebhook set, for the first occurrence of each panic value (a
+// panic recovered and re-panicked by every traced frame is reported once, by the innermost), and
+// for at most maxPanicNotifications panics per run. Callers must hold mu.
+func panicNotification(functionName string, panicValue interface{}, stack string) (notify.Message, bool) {
+	if activeConfig.Tracing.PanicWebhook == "" || len(panicsNotified) >= maxPanicNotifications {
+		return notify.Message{}, false
+	}
+	key := fmt.Sprintf("%v", panicValue)
+	if panicsNotified[key] {
+		return notify.Message{}, false
+	}
+	panicsNotified[key] = true
+	var params map[string]string
+	if rec := panickedRecord(functionName); rec != nil {
+		params = make(map[string]string, len(rec.Params)+len(rec.rawParams))
+		for name, v := range rec.Params {
+			params[name] = v
+		}
+		for name, v := range rec.rawParams {
+			params[name] = formatValue(v)
+		}
+	}
+	return PanicMessage(functionName, panicValue, stack, params, "run "+runID), true
+}
+
+// panickedRecord returns the record of the call of functionName that panicked: the innermost
+// open call, or, as the recovering defer runs after RecordExit, the call completed last. Callers
+// must hold mu.
+func panickedRecord(functionName string) *TraceRecord {
+	if n := len(callStack); n > 0 && callStack[n-1].FunctionName == functionName {
+		return callStack[n-1]
+	}
+	for i := len(traceRecords) - 1; i >= 0 && i >= len(traceRecords)-16; i-- {
+		if traceRecords[i].FunctionName == functionName {
+			return traceRecords[i]
+		}
+	}
+	return nil
+}
+
+// sendPanicNotification posts msg to tracing.panicWebhook, logging failures.
+func sendPanicNotification(msg notify.Message) {
+	client := &http.Client{Timeout: panicNotifyTimeout}
+	if err := notify.Send(client, activeConfig.Tracing.PanicWebhook, msg); err != nil {
+		mu.Lock()
+		logger.Printf("[TRACEWRAP] Error posting panic to tracing.panicWebhook: %v", err)
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,64 @@
+package tracer_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/notify"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestRecordPanicPostsToWebhookOnce(t *testing.T) {
+	var mu sync.Mutex
+	var posted []notify.Message
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg notify.Message
+		json.NewDecoder(r.Body).Decode(&msg)
+		mu.Lock()
+		posted = append(posted, msg)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+	withTracer(t, config.Config{Tracing: config.TracingConfig{PanicWebhook: ts.URL}})
+
+	// The sequence of an instrumented divide called from main: the recovering defer of each frame
+	// runs after its RecordExit and re-panics.
+	start := time.Now()
+	tracer.RecordEntry("main")
+	tracer.RecordEntry("divide")
+	tracer.RecordParam("b", 0)
+	tracer.RecordExit("divide", start)
+	tracer.RecordPanic("divide", "integer divide by zero", "goroutine 1 [running]:\nmain.divide(...)")
+	tracer.RecordExit("main", start)
+	tracer.RecordPanic("main", "integer divide by zero", "goroutine 1 [running]:\nmain.main()")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(posted) != 1 {
+		t.Fatalf("Expected one notification, got %+v", posted)
+	}
+	msg := posted[0]
+	if msg.Title != "panic in divide" || !strings.HasPrefix(msg.Text, "run ") {
+		t.Errorf("Unexpected notification %+v", msg)
+	}
+	fields := make(map[string]string)
+	for _, f := range msg.Fields {
+		fields[f.Name] = f.Value
+	}
+	if fields["Panic value"] != "integer divide by zero" || fields["Parameters"] != "b = 0\n" || !strings.Contains(fields["Stack"], "main.divide") {
+		t.Errorf("Unexpected notification fields %+v", fields)
+	}
+}
+
+func TestPanicMessageCutsLongStacks(t *testing.T) {
+	msg := tracer.PanicMessage("work", "boom", strings.Repeat("x", 10000), nil, "trace.jsonl")
+	if len(msg.Fields) != 2 || len(msg.Fields[1].Value) > 4100 {
+		t.Errorf("Expected the value and a shortened stack, got %d fields", len(msg.Fields))
+	}
+}
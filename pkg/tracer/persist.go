@@ -0,0 +1,247 @@
+package tracer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// traceFilePath returns the JSON Lines file completed trace records are written to,
+// one record per line.
+func traceFilePath() string {
+	return artifactPath("trace.jsonl")
+}
+
+// Persistence state. All fields are guarded by mu.
+var (
+	persistedCount int // Records at the front of traceRecords already written to the trace file.
+	spilledCount   int // Records written to the trace file and dropped from memory.
+)
+
+// funcAggregates holds the per-function aggregates and caller -> callee call counts kept for call
+// graph generation. It is guarded by its own shard locks rather than mu.
+var funcAggregates aggregateTable
+
+// functionAggregate summarizes all completed calls of a single function.
+type functionAggregate struct {
+	Calls             int
+	Total             time.Duration
+	MemDiff           uint64
+	MaxRecursionDepth int
+	Errors            int // Calls with an error status.
+
+	name string // The name of the function.
+}
+
+// callEdge identifies a caller -> callee relationship by function name.
+type callEdge struct {
+	Caller string
+	Callee string
+}
+
+// enforceMemoryCap spills the in-memory records to the trace file once their number exceeds
+// tracing.maxRecordsInMemory. Spilled records are dropped from memory; only the aggregates
+// remain available for call graph generation. Callers must hold mu.
+func enforceMemoryCap() {
+	limit := activeConfig.Tracing.MaxRecordsInMemory
+	if limit <= 0 || len(traceRecords) <= limit {
+		return
+	}
+	n := len(traceRecords)
+	if err := persistRecords(); err != nil {
+		logger.Println("[TRACEWRAP] Error spilling trace records:", err)
+		return
+	}
+	traceRecords = nil
+	persistedCount = 0
+	spilledCount += n
+	logger.Printf("[TRACEWRAP] DEBUG: Spilled %d trace records to %s (%d total)", n, traceFilePath(), spilledCount)
+}
+
+// persistRecords appends the records not yet written to the trace file, or queues them for the
+// collector with tracing.collector.endpoint set. Records the collector does not accept are written
+// to the trace file instead. Callers must hold mu.
+func persistRecords() error {
+	pending := traceRecords[persistedCount:]
+	if len(pending) == 0 {
+		return nil
+	}
+	materializeRecords(pending)
+	if collectorEnabled() {
+		var buf bytes.Buffer
+		if err := encodeRecords(&buf, pending, false); err != nil {
+			return err
+		}
+		what := fmt.Sprintf("%d trace records", len(pending))
+		queueUpload(http.MethodPost, CollectorRecordsPath, "application/x-ndjson", buf.Bytes(), what, func() error {
+			return appendTraceFile(pending)
+		})
+		persistedCount = len(traceRecords)
+		return nil
+	}
+	if err := appendTraceFile(pending); err != nil {
+		return err
+	}
+	persistedCount = len(traceRecords)
+	return nil
+}
+
+// appendTraceFile appends records to the trace file. Callers must hold mu.
+func appendTraceFile(records []*TraceRecord) error {
+	// The symbol table is written first, so every record in the file can be resolved. The batch
+	// is appended with a single write and synced, so a process killed at any point leaves whole
+	// lines behind.
+	if err := writeRunSymbols(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := encodeRecords(&buf, records, true); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(traceFilePath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open trace file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write trace file: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync trace file: %v", err)
+	}
+	return nil
+}
+
+// persistStop stops the background writer started by startPersister; nil when none runs.
+var persistStop chan struct{}
+
+// startPersister writes the completed records and the run metadata every
+// tracing.persistInterval until persistStop is closed. It is started during initialization.
+func startPersister(interval time.Duration) {
+	stop := make(chan struct{})
+	persistStop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				checkpoint()
+			}
+		}
+	}()
+}
+
+// checkpoint writes the records completed since the last write and the run metadata. Unlike
+// Flush, it leaves tail sampling and suppressed log lines alone.
+func checkpoint() {
+	mu.Lock()
+	defer mu.Unlock()
+	if err := persistRecords(); err != nil {
+		logger.Println("[TRACEWRAP] Error persisting trace records:", err)
+	}
+	if err := writeRunMetadata(); err != nil {
+		logger.Println("[TRACEWRAP] Error writing run metadata:", err)
+	}
+}
+
+// encodeRecords writes records to w as JSON Lines. With interned, the records whose function ID
+// resolves to their name in the run's symbol table are written without the name. Callers must
+// hold mu.
+func encodeRecords(w io.Writer, records []*TraceRecord, interned bool) error {
+	enc := json.NewEncoder(w)
+	for _, rec := range records {
+		if interned && internedName(rec) {
+			short := *rec
+			short.FunctionName = ""
+			rec = &short
+		}
+		if err := enc.Encode(rec); err != nil {
+			return fmt.Errorf("failed to encode trace record %d: %v", rec.UniqueID, err)
+		}
+	}
+	return nil
+}
+
+// Flush writes every completed record that has not been persisted yet to the trace file
+// trace.jsonl in the run's artifact directory. Records stay in memory for call graph generation;
+// calling Flush repeatedly only appends records completed since the previous call. It also writes
+// the run metadata (tracewrap version, configuration hash, timing) to run.json next to it. With
+// tracing.collector.endpoint set, both are shipped to the collector instead.
+//
+// Returns:
+//   - error: an error if the trace file cannot be written, or nil on success.
+func Flush() error {
+	ensureInitialized()
+	finishProfiles()
+	mu.Lock()
+	err := flushLocked()
+	mu.Unlock()
+	waitForUploads()
+	return err
+}
+
+// flushLocked is Flush up to the uploads it queues. Callers must hold mu.
+func flushLocked() error {
+	settleTailSampling()
+	flushSuppressedLogs()
+	if err := persistRecords(); err != nil {
+		return err
+	}
+	if collectorEnabled() {
+		logger.Printf("[TRACEWRAP] Trace records shipped to: %s\n", activeConfig.Tracing.Collector.Endpoint)
+	} else {
+		logger.Printf("[TRACEWRAP] Trace records written to: %s\n", traceFilePath())
+	}
+	if err := writeRunMetadata(); err != nil {
+		return fmt.Errorf("failed to write run metadata: %v", err)
+	}
+	return nil
+}
+
+// ReadTraceFile reads the trace records stored in a JSON Lines trace file such as
+// "tracewrap/latest/trace.jsonl". Records written by older tracewrap versions are migrated to the
+// current SchemaVersion, and function IDs are resolved to names with the SymbolsFile next to it.
+//
+// Parameters:
+//   - path (string): the path to the trace file.
+//
+// Returns:
+//   - []TraceRecord: the records in file order.
+//   - error: an error if the file cannot be read or a line is not a valid record.
+func ReadTraceFile(path string) ([]TraceRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var records []TraceRecord
+	symbols := SymbolsFor(path)
+	scanner := bufio.NewScanner(file)
+	// Records with captured stack traces can exceed the default 64 KiB line limit.
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		rec, err := DecodeRecord(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid trace record: %v", path, line, err)
+		}
+		symbols.Resolve(&rec)
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trace file: %v", err)
+	}
+	return records, nil
+}
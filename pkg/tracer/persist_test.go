@@ -0,0 +1,40 @@
+package tracer_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestPersistIntervalWritesWithoutFlush(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{PersistInterval: 10 * time.Millisecond}})
+
+	call("work", nil)
+	call("work", nil)
+	trace := filepath.Join("tracewrap", "latest", "trace.jsonl")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		records, err := tracer.ReadTraceFile(trace)
+		_, metaErr := os.Stat(filepath.Join("tracewrap", "latest", "run.json"))
+		if err == nil && len(records) == 2 && metaErr == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected both records and run metadata to be persisted without Flush, got %d records (%v, %v)", len(records), err, metaErr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A later flush only appends what the background writer has not written yet.
+	call("work", nil)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if records, err := tracer.ReadTraceFile(trace); err != nil || len(records) != 3 {
+		t.Errorf("Expected three records after Flush, got %d (%v)", len(records), err)
+	}
+}
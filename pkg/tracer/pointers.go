@@ -0,0 +1,43 @@
+package tracer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"reflect"
+)
+
+// pointerSeed seeds the hash of the pointer IDs. It is drawn once per process, so an ID names the
+// same object throughout a run but reveals nothing about its address.
+var pointerSeed = maphash.MakeSeed()
+
+// pointerID returns the ID of the object v points to, a hash of its address and type such as
+// "3f2a9c1b04d7e865", and true if v is a non-nil pointer. The type is hashed too, so a struct and
+// its first field, which share an address, get different IDs.
+func pointerID(v interface{}) (string, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return "", false
+	}
+	var h maphash.Hash
+	h.SetSeed(pointerSeed)
+	var addr [8]byte
+	binary.LittleEndian.PutUint64(addr[:], uint64(rv.Pointer()))
+	h.Write(addr[:])
+	h.WriteString(rv.Type().String())
+	return fmt.Sprintf("%016x", h.Sum64()), true
+}
+
+// recordPointerID stores the pointer ID of the parameter value on rec, if it is a pointer and
+// tracing.capture.pointerIds is set. Callers must hold mu.
+func recordPointerID(rec *TraceRecord, name string, v interface{}) {
+	if !activeConfig.Tracing.Capture.PointerIDs {
+		return
+	}
+	if id, ok := pointerID(v); ok {
+		if rec.PointerIDs == nil {
+			rec.PointerIDs = make(map[string]string)
+		}
+		rec.PointerIDs[name] = id
+	}
+}
@@ -0,0 +1,150 @@
+package tracer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Primitive kinds stored in TraceRecord.TypedParams and TraceRecord.TypedReturns.
+const (
+	KindInt    = "int"
+	KindUint   = "uint"
+	KindFloat  = "float"
+	KindString = "string"
+	KindBool   = "bool"
+)
+
+// Primitive is a parameter or return value of a basic kind kept with its type, so trace
+// consumers can compare arguments numerically instead of parsing their string form.
+// Value holds an int64, uint64, float64, string or bool according to Kind.
+type Primitive struct {
+	Kind  string      `json:"kind"`
+	Value interface{} `json:"value"`
+}
+
+// primitiveOf returns the typed form of v, or nil if v is not of a basic kind. Named types
+// (type JobID int) are included; their underlying value is stored. Opaque type names are not
+// values and are excluded.
+func primitiveOf(v interface{}) *Primitive {
+	if _, ok := v.(Opaque); ok {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Primitive{Kind: KindInt, Value: rv.Int()}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Primitive{Kind: KindUint, Value: rv.Uint()}
+	case reflect.Float32, reflect.Float64:
+		return &Primitive{Kind: KindFloat, Value: rv.Float()}
+	case reflect.String:
+		return &Primitive{Kind: KindString, Value: rv.String()}
+	case reflect.Bool:
+		return &Primitive{Kind: KindBool, Value: rv.Bool()}
+	}
+	return nil
+}
+
+// capturedPrimitive is primitiveOf for the typed values recorded on trace records. Strings longer
+// than tracing.capture.maxValueBytes are left out, as only their cut rendered form is kept, and so
+// are all strings with tracing.capture.sizesOnly, which records only their length.
+func capturedPrimitive(v interface{}) *Primitive {
+	p := primitiveOf(v)
+	if p == nil || p.Kind != KindString {
+		return p
+	}
+	if c := activeConfig.Tracing.Capture; c.SizesOnly || c.MaxValueBytes > 0 && len(p.Value.(string)) > c.MaxValueBytes {
+		return nil
+	}
+	return p
+}
+
+// Float64 returns the value as a float64 for numeric comparisons.
+//
+// Returns:
+//   - float64: the numeric value.
+//   - bool: false if the value is not numeric.
+func (p Primitive) Float64() (float64, bool) {
+	switch x := p.Value.(type) {
+	case int64:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+// String returns the value formatted like fmt's %v.
+func (p Primitive) String() string {
+	return fmt.Sprint(p.Value)
+}
+
+// UnmarshalJSON restores Value with the Go type matching Kind; integers keep their full
+// precision instead of being decoded as float64.
+func (p *Primitive) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Kind  string          `json:"kind"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	p.Kind = raw.Kind
+	text := string(bytes.TrimSpace(raw.Value))
+	var err error
+	switch raw.Kind {
+	case KindInt:
+		p.Value, err = strconv.ParseInt(text, 10, 64)
+	case KindUint:
+		p.Value, err = strconv.ParseUint(text, 10, 64)
+	case KindFloat:
+		p.Value, err = strconv.ParseFloat(text, 64)
+	case KindString:
+		var s string
+		err = json.Unmarshal(raw.Value, &s)
+		p.Value = s
+	case KindBool:
+		p.Value, err = strconv.ParseBool(text)
+	default:
+		return fmt.Errorf("unknown primitive kind %q", raw.Kind)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s value %s: %v", raw.Kind, text, err)
+	}
+	return nil
+}
+
+// recordTypedParam stores the typed form of a parameter value on rec.
+func recordTypedParam(rec *TraceRecord, name string, v interface{}) {
+	if p := capturedPrimitive(v); p != nil {
+		if rec.TypedParams == nil {
+			rec.TypedParams = make(map[string]Primitive)
+		}
+		rec.TypedParams[name] = *p
+	}
+}
+
+// recordTypedReturns appends the typed forms of return values to rec. Positions match
+// ReturnValues, with nil for values that are not of a basic kind; the slice is only kept
+// when at least one value is.
+func recordTypedReturns(rec *TraceRecord, values []interface{}) {
+	typed := make([]*Primitive, len(values))
+	found := rec.TypedReturns != nil
+	for i, v := range values {
+		typed[i] = capturedPrimitive(v)
+		found = found || typed[i] != nil
+	}
+	if !found {
+		return
+	}
+	if rec.TypedReturns == nil {
+		// Earlier returns of this record had no primitives; pad them to keep positions aligned.
+		rec.TypedReturns = make([]*Primitive, len(rec.ReturnValues)-len(values))
+	}
+	rec.TypedReturns = append(rec.TypedReturns, typed...)
+}
@@ -0,0 +1,59 @@
+package tracer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+type jobID uint32
+
+func TestTypedPrimitivesSurviveTheTraceFile(t *testing.T) {
+	withTracer(t, config.Config{})
+	start := time.Now()
+	tracer.RecordEntry("processJob")
+	tracer.RecordParam("id", jobID(101))
+	tracer.RecordParam("offset", int64(1<<62+1))
+	tracer.RecordParam("ratio", 0.25)
+	tracer.RecordParam("name", "resize")
+	tracer.RecordParam("retry", true)
+	tracer.RecordParam("opts", customer{Name: "Ada"})
+	tracer.RecordReturn("processJob", customer{}, 7)
+	tracer.RecordExit("processJob", start)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Expected one record, got %d (%v)", len(records), err)
+	}
+	rec := records[0]
+
+	want := map[string]tracer.Primitive{
+		"id":     {Kind: tracer.KindUint, Value: uint64(101)},
+		"offset": {Kind: tracer.KindInt, Value: int64(1<<62 + 1)},
+		"ratio":  {Kind: tracer.KindFloat, Value: 0.25},
+		"name":   {Kind: tracer.KindString, Value: "resize"},
+		"retry":  {Kind: tracer.KindBool, Value: true},
+	}
+	if len(rec.TypedParams) != len(want) {
+		t.Fatalf("Expected %d typed params, got %v", len(want), rec.TypedParams)
+	}
+	for name, w := range want {
+		if got := rec.TypedParams[name]; got != w {
+			t.Errorf("TypedParams[%q] = %#v, want %#v", name, got, w)
+		}
+	}
+	if id, ok := rec.TypedParams["id"].Float64(); !ok || id <= 100 {
+		t.Errorf("Expected id to compare numerically above 100, got %v (%v)", id, ok)
+	}
+	if _, ok := rec.TypedParams["name"].Float64(); ok {
+		t.Error("Expected a string not to be numeric")
+	}
+
+	if len(rec.TypedReturns) != 2 || rec.TypedReturns[0] != nil || rec.TypedReturns[1] == nil || rec.TypedReturns[1].Value != int64(7) {
+		t.Errorf("Unexpected typed returns: %v", rec.TypedReturns)
+	}
+}
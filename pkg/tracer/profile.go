@@ -0,0 +1,126 @@
+package tracer
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// Types of ProfileRef.
+const (
+	ProfileCPU  = "cpu"
+	ProfileHeap = "heap"
+)
+
+// ProfileRef points at a pprof profile captured during the run, written to the run directory.
+// The offsets are relative to RunMetadata.StartedAt, so the profile can be aligned with the
+// records of the trace: the CPU profile covers Start to End, and a heap profile is a snapshot
+// taken at End.
+type ProfileRef struct {
+	Type  string        `json:"type"` // ProfileCPU or ProfileHeap.
+	File  string        `json:"file"` // The file name in the run directory, e.g. "cpu.pprof".
+	Start time.Duration `json:"startOffset"`
+	End   time.Duration `json:"endOffset"`
+}
+
+// Profiles captured during the run, set up by initProfiling before tracing starts. profiles is
+// guarded by mu. cpuProfile is open while the CPU profile is running, and guarded by profileMu,
+// which serializes finishProfiles.
+var (
+	profiles   []ProfileRef
+	cpuProfile *os.File
+	profileMu  sync.Mutex
+)
+
+// sinceStart returns the offset of the current time from startedAt.
+func sinceStart() time.Duration {
+	_, now := clockNow()
+	return time.Duration(now - startedNanos)
+}
+
+// initProfiling starts the CPU profile configured with tracing.cpuProfile. A profile the
+// application runs itself takes precedence; the error is logged and the run continues without one.
+func initProfiling() {
+	profiles = nil
+	cpuProfile = nil
+	if !activeConfig.Tracing.CPUProfile {
+		return
+	}
+	file, err := os.Create(artifactPath("cpu.pprof"))
+	if err != nil {
+		logger.Println("[TRACEWRAP] Error creating CPU profile:", err)
+		return
+	}
+	if err := pprof.StartCPUProfile(file); err != nil {
+		logger.Println("[TRACEWRAP] Error starting CPU profile:", err)
+		file.Close()
+		os.Remove(file.Name())
+		return
+	}
+	cpuProfile = file
+	profiles = append(profiles, ProfileRef{Type: ProfileCPU, File: "cpu.pprof", Start: sinceStart()})
+}
+
+// finishProfiles stops the CPU profile and writes the heap profile configured with
+// tracing.heapProfile. The CPU profile ends at the first flush; each flush replaces the heap
+// profile with a new snapshot. Flush calls it before taking mu, which is only held to update
+// profiles: the collection and the writes would otherwise stall every traced call.
+func finishProfiles() {
+	profileMu.Lock()
+	defer profileMu.Unlock()
+	if cpuProfile != nil {
+		pprof.StopCPUProfile()
+		if err := cpuProfile.Close(); err != nil {
+			logger.Println("[TRACEWRAP] Error writing CPU profile:", err)
+		}
+		cpuProfile = nil
+		end := sinceStart()
+		mu.Lock()
+		for i := range profiles {
+			if profiles[i].Type == ProfileCPU {
+				profiles[i].End = end
+			}
+		}
+		mu.Unlock()
+		logger.Printf("[TRACEWRAP] CPU profile written to: %s\n", artifactPath("cpu.pprof"))
+	}
+	if !activeConfig.Tracing.HeapProfile {
+		return
+	}
+	// A collection first makes the in-use figures current, as pprof's own heap endpoint does.
+	runtime.GC()
+	data, err := os.Create(artifactPath("heap.pprof"))
+	if err != nil {
+		logger.Println("[TRACEWRAP] Error creating heap profile:", err)
+		return
+	}
+	err = pprof.Lookup("heap").WriteTo(data, 0)
+	if cerr := data.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		logger.Println("[TRACEWRAP] Error writing heap profile:", err)
+		return
+	}
+	now := sinceStart()
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range profiles {
+		if profiles[i].Type == ProfileHeap {
+			profiles[i].Start, profiles[i].End = now, now
+			return
+		}
+	}
+	profiles = append(profiles, ProfileRef{Type: ProfileHeap, File: "heap.pprof", Start: now, End: now})
+}
+
+// profilesSnapshot returns a copy of the profile references for the run metadata. Callers must
+// hold mu.
+func profilesSnapshot() []ProfileRef {
+	if len(profiles) == 0 {
+		return nil
+	}
+	return append([]ProfileRef(nil), profiles...)
+}
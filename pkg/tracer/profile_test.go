@@ -0,0 +1,42 @@
+package tracer_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/analysis"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestProfilesAreReferencedInRunMetadata(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{CPUProfile: true, HeapProfile: true}})
+	call("worker", nil)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	data, err := os.ReadFile("tracewrap/latest/run.json")
+	if err != nil {
+		t.Fatalf("Failed to read run metadata: %v", err)
+	}
+	var meta tracer.RunMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("Failed to parse run metadata: %v", err)
+	}
+	if len(meta.Profiles) != 2 {
+		t.Fatalf("Expected a CPU and a heap profile, got %+v", meta.Profiles)
+	}
+	for _, ref := range meta.Profiles {
+		if ref.End < ref.Start || ref.End == 0 {
+			t.Errorf("Unexpected offsets for the %s profile: %+v", ref.Type, ref)
+		}
+		if _, err := analysis.ReadProfile(filepath.Join("tracewrap", "latest", ref.File), ""); err != nil {
+			t.Errorf("Failed to read the %s profile: %v", ref.Type, err)
+		}
+	}
+	if meta.Profiles[0].Type != tracer.ProfileCPU || meta.Profiles[1].Type != tracer.ProfileHeap {
+		t.Errorf("Unexpected profile types: %+v", meta.Profiles)
+	}
+}
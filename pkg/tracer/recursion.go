@@ -0,0 +1,79 @@
+package tracer
+
+import "fmt"
+
+// Values of TraceRecord.Recursion.
+const (
+	RecursionDirect = "direct" // The caller is the same function.
+	RecursionMutual = "mutual" // The function is further up the stack, e.g. a -> b -> a.
+)
+
+// markRecursion sets rec.RecursionDepth to the number of open callers of rec that run the same
+// function, and rec.Recursion to how the function recursed. rec.parent must be set. Callers must
+// hold mu.
+func markRecursion(rec *TraceRecord) {
+	for p := rec.parent; p != nil; p = p.openParent() {
+		if p.FunctionName == rec.FunctionName {
+			rec.RecursionDepth++
+		}
+	}
+	switch {
+	case rec.RecursionDepth == 0:
+	case rec.parent.FunctionName == rec.FunctionName:
+		rec.Recursion = RecursionDirect
+	default:
+		rec.Recursion = RecursionMutual
+	}
+}
+
+// recursionChain describes the frames folded into the outermost frame of a recursive chain.
+type recursionChain struct {
+	folded   int // Number of recursive frames folded into the outermost one.
+	maxDepth int // Largest number of nested frames of the function.
+}
+
+// label returns the node label line for the chain, e.g. "fibonacci ×177 calls, max depth 10".
+func (c recursionChain) label(functionName string) string {
+	return fmt.Sprintf("%s ×%d calls, max depth %d", functionName, c.folded+1, c.maxDepth)
+}
+
+// collapseRecursion maps every record to the node that represents it in a collapsed call graph:
+// recursive frames are represented by the outermost frame of the same function above them, all
+// other records by themselves. Chains are returned for the outermost frames that represent
+// others.
+//
+// Parameters:
+//   - records ([]*TraceRecord): the records to draw.
+//
+// Returns:
+//   - map[int64]int64: the representative unique ID of every record.
+//   - map[int64]recursionChain: the folded chains, keyed by the outermost frame's unique ID.
+func collapseRecursion(records []*TraceRecord) (map[int64]int64, map[int64]recursionChain) {
+	byID := make(map[int64]*TraceRecord, len(records))
+	for _, rec := range records {
+		byID[rec.UniqueID] = rec
+	}
+	reps := make(map[int64]int64, len(records))
+	chains := make(map[int64]recursionChain)
+	for _, rec := range records {
+		rep := rec.UniqueID
+		if rec.RecursionDepth > 0 {
+			for p := byID[rec.CallerID]; p != nil; p = byID[p.CallerID] {
+				if p.FunctionName == rec.FunctionName && p.RecursionDepth == 0 {
+					rep = p.UniqueID
+					break
+				}
+			}
+		}
+		reps[rec.UniqueID] = rep
+		if rep != rec.UniqueID {
+			c := chains[rep]
+			c.folded++
+			if depth := rec.RecursionDepth + 1; depth > c.maxDepth {
+				c.maxDepth = depth
+			}
+			chains[rep] = c
+		}
+	}
+	return reps, chains
+}
@@ -0,0 +1,73 @@
+package tracer_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// fib traces a recursive Fibonacci computation of n.
+func fib(n int) {
+	call("fib", func() {
+		if n > 1 {
+			fib(n - 1)
+			fib(n - 2)
+		}
+	})
+}
+
+func TestRecursiveFramesAreMarked(t *testing.T) {
+	withTracer(t, config.Config{})
+	call("a", func() { call("b", func() { call("a", nil) }) })
+	fib(3)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("Failed to read trace: %v", err)
+	}
+	byID := make(map[int64]tracer.TraceRecord)
+	for _, rec := range records {
+		byID[rec.UniqueID] = rec
+	}
+	// IDs follow entry order: a=1, b=2, inner a=3, fib(3)=4, fib(2)=5, fib(1)=6, fib(0)=7, fib(1)=8.
+	if inner := byID[3]; inner.Recursion != tracer.RecursionMutual || inner.RecursionDepth != 1 {
+		t.Errorf("Expected mutual recursion for the inner a, got %q depth %d", inner.Recursion, inner.RecursionDepth)
+	}
+	if b := byID[2]; b.Recursion != "" || b.RecursionDepth != 0 {
+		t.Errorf("Expected b not to be recursive, got %q depth %d", b.Recursion, b.RecursionDepth)
+	}
+	if deepest := byID[6]; deepest.Recursion != tracer.RecursionDirect || deepest.RecursionDepth != 2 {
+		t.Errorf("Expected direct recursion at depth 2, got %q depth %d", deepest.Recursion, deepest.RecursionDepth)
+	}
+}
+
+func TestCallGraphCollapsesRecursion(t *testing.T) {
+	withTracer(t, config.Config{Visualization: config.VisualizationConfig{CollapseRecursion: true}})
+	call("main", func() {
+		fib(5)
+		call("a", func() { call("b", func() { call("a", nil) }) })
+	})
+	if err := tracer.DumpCallGraphDOT("graph.dot"); err != nil {
+		t.Fatalf("DumpCallGraphDOT returned error: %v", err)
+	}
+	data, err := os.ReadFile("graph.dot")
+	if err != nil {
+		t.Fatalf("Failed to read DOT file: %v", err)
+	}
+	dot := string(data)
+	if !strings.Contains(dot, "fib ×15 calls, max depth 5") {
+		t.Errorf("Expected a collapsed fib node, got:\n%s", dot)
+	}
+	// The inner a is folded into the outer one, leaving main, fib, a and b.
+	if n := strings.Count(dot, "[label="); n != 4 {
+		t.Errorf("Expected four nodes, got %d:\n%s", n, dot)
+	}
+	if !strings.Contains(dot, "a ×2 calls, max depth 2") || !strings.Contains(dot, "[style=dashed]") {
+		t.Errorf("Expected a dashed edge closing the a -> b -> a cycle, got:\n%s", dot)
+	}
+}
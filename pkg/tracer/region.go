@@ -0,0 +1,67 @@
+package tracer
+
+var (
+	regions         []string // The stack of currently open region names. Guarded by mu.
+	recordingBefore bool     // Recording state before the outermost open region started. Guarded by mu.
+)
+
+// initRegionState applies tracing.recordOnlyRegions: when set, nothing is recorded until the
+// first region starts.
+func initRegionState() {
+	if activeConfig.Tracing.RecordOnlyRegions {
+		recording.Store(false)
+	}
+}
+
+// StartRegion marks the beginning of a named region of the program's execution. Records created
+// while a region is open carry the innermost region's name. With tracing.recordOnlyRegions set,
+// recording is turned on while at least one region is open, and back to its previous state when
+// the last one ends; otherwise regions do not change whether recording is on. The instrumenter
+// emits calls to StartRegion for `//tracewrap:region start name="..."` comments.
+//
+// Parameters:
+//   - name (string): the region name.
+func StartRegion(name string) {
+	ensureInitialized()
+	mu.Lock()
+	if len(regions) == 0 && activeConfig.Tracing.RecordOnlyRegions {
+		recordingBefore = recording.Load()
+		recording.Store(true)
+	}
+	regions = append(regions, name)
+	mu.Unlock()
+	logger.Printf("[TRACEWRAP] Region %q started", name)
+}
+
+// EndRegion marks the end of the innermost open region with the given name (or of the innermost
+// region when name is empty). The instrumenter emits calls to EndRegion for `//tracewrap:region end`
+// comments.
+//
+// Parameters:
+//   - name (string): the region name, or "" for the innermost region.
+func EndRegion(name string) {
+	ensureInitialized()
+	mu.Lock()
+	for i := len(regions) - 1; i >= 0; i-- {
+		if name == "" || regions[i] == name {
+			if name == "" {
+				name = regions[i]
+			}
+			regions = append(regions[:i], regions[i+1:]...)
+			if len(regions) == 0 && activeConfig.Tracing.RecordOnlyRegions {
+				recording.Store(recordingBefore)
+			}
+			break
+		}
+	}
+	mu.Unlock()
+	logger.Printf("[TRACEWRAP] Region %q ended", name)
+}
+
+// currentRegion returns the innermost open region name, or "". Callers must hold mu.
+func currentRegion() string {
+	if len(regions) == 0 {
+		return ""
+	}
+	return regions[len(regions)-1]
+}
@@ -0,0 +1,43 @@
+package tracer_test
+
+import (
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestRecordOnlyRegions(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{RecordOnlyRegions: true}})
+
+	call("warmup", nil)
+	tracer.StartRegion("checkout")
+	call("pay", nil)
+	tracer.EndRegion("")
+	call("cooldown", nil)
+
+	status := tracer.CurrentStatus()
+	if status.RecordsInMemory != 1 {
+		t.Fatalf("Expected only the call inside the region to be recorded, got %d records", status.RecordsInMemory)
+	}
+	if status.Recording {
+		t.Errorf("Expected recording to stop once the last region ended")
+	}
+}
+
+func TestRegionDoesNotResumeStoppedTracer(t *testing.T) {
+	withTracer(t, config.Config{})
+
+	tracer.StopRecording()
+	tracer.StartRegion("checkout")
+	call("pay", nil)
+	tracer.EndRegion("checkout")
+
+	status := tracer.CurrentStatus()
+	if status.Recording {
+		t.Errorf("Expected a region to leave a stopped tracer stopped")
+	}
+	if status.RecordsInMemory != 0 {
+		t.Errorf("Expected no records while recording is stopped, got %d", status.RecordsInMemory)
+	}
+}
@@ -0,0 +1,101 @@
+package tracer
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+)
+
+// runFilePath returns where the run metadata is written.
+func runFilePath() string {
+	return artifactPath("run.json")
+}
+
+// tracewrapVersion and configHash identify what produced the trace. The instrumenter stamps
+// them into instrumented binaries alongside embeddedConfig.
+var (
+	tracewrapVersion string
+	configHash       string
+)
+
+// RunMetadata describes one execution of an instrumented binary.
+type RunMetadata struct {
+	SchemaVersion    int      `json:"schemaVersion"`
+	RunID            string   `json:"runId"`
+	TracewrapVersion string   `json:"tracewrapVersion"`
+	ConfigHash       string   `json:"configHash"`
+	GoVersion        string   `json:"goVersion"`
+	Args             []string `json:"args"`
+	PID              int      `json:"pid"`
+	Hostname         string   `json:"hostname,omitempty"`
+	// Build identifies the instrumented project's build: its module, version and VCS revision.
+	Build          *BuildInfo `json:"build,omitempty"`
+	StartedAt      time.Time  `json:"startedAt"`
+	EndedAt        time.Time  `json:"endedAt"`
+	Records        int        `json:"records"`
+	RecordsSpilled int        `json:"recordsSpilled"`
+	// Counters and Gauges are the application metrics reported with Count and Gauge.
+	Counters map[string]int64      `json:"counters,omitempty"`
+	Gauges   map[string]GaugeValue `json:"gauges,omitempty"`
+	// ExecFrequency counts the calls of each instrumented function. Calls are counted even when
+	// sampling, tail sampling or a stopped recording dropped their records.
+	ExecFrequency map[string]int `json:"execFrequency,omitempty"`
+	// Profiles lists the pprof profiles captured with tracing.cpuProfile and tracing.heapProfile.
+	Profiles []ProfileRef `json:"profiles,omitempty"`
+}
+
+// currentRunMetadata returns the metadata of the current run. Callers must hold mu.
+func currentRunMetadata() RunMetadata {
+	counters, gauges := metricsSnapshot()
+	hostname, _ := os.Hostname()
+	endedAt, _ := clockNow()
+	return RunMetadata{
+		SchemaVersion:    SchemaVersion,
+		RunID:            runID,
+		TracewrapVersion: tracewrapVersion,
+		ConfigHash:       configHash,
+		GoVersion:        runtime.Version(),
+		Args:             os.Args,
+		PID:              os.Getpid(),
+		Hostname:         hostname,
+		Build:            CurrentBuild(),
+		StartedAt:        startedAt,
+		EndedAt:          endedAt,
+		Records:          spilledCount + len(traceRecords),
+		RecordsSpilled:   spilledCount,
+		Counters:         counters,
+		Gauges:           gauges,
+		ExecFrequency:    frequencySnapshot(),
+		Profiles:         profilesSnapshot(),
+	}
+}
+
+// writeRunMetadata writes the run metadata to runFilePath, or queues it for the collector with
+// tracing.collector.endpoint set. Callers must hold mu.
+func writeRunMetadata() error {
+	data, err := json.MarshalIndent(currentRunMetadata(), "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if collectorEnabled() {
+		path := runFilePath()
+		queueUpload(http.MethodPut, CollectorMetadataPath, "application/json", data, "run metadata", func() error {
+			return writeFileAtomic(path, data)
+		})
+		return nil
+	}
+	return writeFileAtomic(runFilePath(), data)
+}
+
+// writeFileAtomic replaces the file at path with data through a temporary file, so readers and
+// crashes never see it half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,142 @@
+package tracer
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SchemaVersion is the version of the trace record and run metadata formats written by this
+// tracer, stored in their schemaVersion field. It is increased whenever a field is renamed or its
+// meaning changes, and a migration from the previous version is added; new fields alone do not
+// need a new version. Records and metadata without a schemaVersion are version 1, written before
+// the field was introduced.
+//
+// Version history:
+//
+//	1  Unversioned.
+//	2  Records carry the monotonic entryNanos and exitNanos, from which duration is computed.
+//	3  Records of instrumented functions carry a functionId and, in trace files, omit the
+//	   functionName, which the symbols.json next to the trace resolves (see SymbolsFile).
+const SchemaVersion = 3
+
+// migration upgrades a document, decoded as its JSON fields, by one schema version.
+type migration func(fields map[string]json.RawMessage) error
+
+// recordMigrations[v] upgrades a version v trace record to version v+1.
+var recordMigrations = map[int]migration{
+	1: migrateRecordV1,
+	2: func(map[string]json.RawMessage) error { return nil }, // Version 2 records all carry their name.
+}
+
+// metadataMigrations[v] upgrades version v run metadata to version v+1.
+var metadataMigrations = map[int]migration{
+	1: func(map[string]json.RawMessage) error { return nil }, // Version 2 only changed records.
+	2: func(map[string]json.RawMessage) error { return nil }, // Version 3 only changed records.
+}
+
+// migrateRecordV1 derives the monotonic readings of version 2 from the wall clock entry time and
+// the duration, so orderings and durations computed from them agree with the version 1 fields.
+func migrateRecordV1(fields map[string]json.RawMessage) error {
+	if _, ok := fields["entryNanos"]; ok {
+		return nil
+	}
+	var entry time.Time
+	var duration time.Duration
+	if raw, ok := fields["entryTime"]; ok {
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return fmt.Errorf("entryTime: %v", err)
+		}
+	}
+	if raw, ok := fields["duration"]; ok {
+		if err := json.Unmarshal(raw, &duration); err != nil {
+			return fmt.Errorf("duration: %v", err)
+		}
+	}
+	if entry.IsZero() {
+		return nil
+	}
+	nanos := entry.UnixNano()
+	fields["entryNanos"], _ = json.Marshal(nanos)
+	fields["exitNanos"], _ = json.Marshal(nanos + int64(duration))
+	return nil
+}
+
+// upgrade migrates the JSON document data to SchemaVersion.
+//
+// Parameters:
+//   - data ([]byte): the document.
+//   - migrations (map[int]migration): the migrations for the kind of document.
+//
+// Returns:
+//   - []byte: the document in the current version.
+//   - error: an error if data is not a JSON object, is from a newer version, or cannot be migrated.
+func upgrade(data []byte, migrations map[int]migration) ([]byte, error) {
+	var probe struct {
+		SchemaVersion int `json:"schemaVersion"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+	version := max(probe.SchemaVersion, 1)
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("schema version %d is newer than version %d read by this tracewrap; upgrade tracewrap to read it", version, SchemaVersion)
+	}
+	if version == SchemaVersion {
+		return data, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for ; version < SchemaVersion; version++ {
+		if err := migrations[version](fields); err != nil {
+			return nil, fmt.Errorf("migrating from schema version %d: %v", version, err)
+		}
+	}
+	fields["schemaVersion"], _ = json.Marshal(SchemaVersion)
+	return json.Marshal(fields)
+}
+
+// DecodeRecord decodes a JSON trace record of any schema version, migrating it to SchemaVersion.
+//
+// Parameters:
+//   - data ([]byte): the record, e.g. one line of a trace file.
+//
+// Returns:
+//   - TraceRecord: the record.
+//   - error: an error if data is not a valid record or comes from a newer tracewrap.
+func DecodeRecord(data []byte) (TraceRecord, error) {
+	var rec TraceRecord
+	data, err := upgrade(data, recordMigrations)
+	if err != nil {
+		return rec, err
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, err
+	}
+	rec.SchemaVersion = SchemaVersion
+	return rec, nil
+}
+
+// DecodeRunMetadata decodes run metadata (run.json) of any schema version, migrating it to
+// SchemaVersion.
+//
+// Parameters:
+//   - data ([]byte): the metadata.
+//
+// Returns:
+//   - RunMetadata: the metadata.
+//   - error: an error if data is not valid metadata or comes from a newer tracewrap.
+func DecodeRunMetadata(data []byte) (RunMetadata, error) {
+	var meta RunMetadata
+	data, err := upgrade(data, metadataMigrations)
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, err
+	}
+	meta.SchemaVersion = SchemaVersion
+	return meta, nil
+}
@@ -0,0 +1,72 @@
+package tracer_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestDecodeRecordMigratesVersion1(t *testing.T) {
+	line := `{"uniqueId":1,"functionName":"work","entryTime":"2024-05-01T10:00:00Z","duration":1500}`
+	rec, err := tracer.DecodeRecord([]byte(line))
+	if err != nil {
+		t.Fatalf("DecodeRecord returned error: %v", err)
+	}
+	entry := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).UnixNano()
+	if rec.SchemaVersion != tracer.SchemaVersion || rec.FunctionName != "work" {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+	if rec.EntryNanos != entry || rec.ExitNanos != entry+1500 {
+		t.Errorf("Expected monotonic readings %d and %d, got %d and %d", entry, entry+1500, rec.EntryNanos, rec.ExitNanos)
+	}
+}
+
+func TestDecodeRecordKeepsCurrentVersion(t *testing.T) {
+	line := `{"schemaVersion":3,"uniqueId":1,"functionName":"work","entryNanos":10,"exitNanos":25,"duration":15}`
+	rec, err := tracer.DecodeRecord([]byte(line))
+	if err != nil {
+		t.Fatalf("DecodeRecord returned error: %v", err)
+	}
+	if rec.EntryNanos != 10 || rec.ExitNanos != 25 {
+		t.Errorf("Expected the recorded readings to be kept, got %+v", rec)
+	}
+}
+
+func TestDecodeRecordRejectsNewerVersion(t *testing.T) {
+	_, err := tracer.DecodeRecord([]byte(`{"schemaVersion":99,"functionName":"work"}`))
+	if err == nil || !strings.Contains(err.Error(), "upgrade tracewrap") {
+		t.Errorf("Expected an error asking to upgrade, got %v", err)
+	}
+}
+
+func TestDecodeRunMetadataMigratesVersion1(t *testing.T) {
+	meta, err := tracer.DecodeRunMetadata([]byte(`{"runId":"20240501-100000-42","pid":42,"records":3}`))
+	if err != nil {
+		t.Fatalf("DecodeRunMetadata returned error: %v", err)
+	}
+	if meta.SchemaVersion != tracer.SchemaVersion || meta.PID != 42 || meta.Records != 3 {
+		t.Errorf("Unexpected metadata %+v", meta)
+	}
+}
+
+func TestFlushWritesSchemaVersion(t *testing.T) {
+	withTracer(t, config.Config{})
+	call("work", nil)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	for _, name := range []string{"trace.jsonl", "run.json"} {
+		data, err := os.ReadFile(filepath.Join("tracewrap", "latest", name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if !strings.Contains(string(data), `"schemaVersion":3`) && !strings.Contains(string(data), `"schemaVersion": 3`) {
+			t.Errorf("Expected %s to record the schema version, got %s", name, data)
+		}
+	}
+}
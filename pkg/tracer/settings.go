@@ -0,0 +1,68 @@
+package tracer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/mwiater/tracewrap/config"
+)
+
+// embeddedConfig holds the base64-encoded JSON form of the tracewrap configuration.
+// It is stamped into instrumented binaries at link time with
+// "-ldflags -X github.com/mwiater/tracewrap/pkg/tracer.embeddedConfig=...", so the
+// configuration is available before any init function runs.
+var embeddedConfig string
+
+// activeConfig is the configuration the tracer operates with. It is populated by
+// loadSettings during lazy initialization.
+var activeConfig config.Config
+
+// loadSettings decodes embeddedConfig into activeConfig. An empty or malformed value
+// leaves the zero configuration in place, which preserves the default tracing behavior.
+func loadSettings() {
+	cfg, err := decodeEmbeddedConfig()
+	if err != nil {
+		log.Println("[TRACEWRAP] Error", err)
+		return
+	}
+	activeConfig = cfg
+}
+
+// decodeEmbeddedConfig decodes embeddedConfig.
+//
+// Returns:
+//   - config.Config: the configuration, the zero one if none is embedded.
+//   - error: an error if the value is malformed.
+func decodeEmbeddedConfig() (config.Config, error) {
+	var cfg config.Config
+	if embeddedConfig == "" {
+		return cfg, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(embeddedConfig)
+	if err != nil {
+		return cfg, fmt.Errorf("decoding embedded configuration: %v", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return config.Config{}, fmt.Errorf("parsing embedded configuration: %v", err)
+	}
+	return cfg, nil
+}
+
+// EncodeConfig returns the value to assign to embeddedConfig for the given configuration.
+// It is used by the instrumenter when building the linker flags of instrumented binaries.
+//
+// Parameters:
+//   - cfg (config.Config): the configuration to embed.
+//
+// Returns:
+//   - string: the base64-encoded JSON configuration.
+//   - error: an error if the configuration cannot be marshalled.
+func EncodeConfig(cfg config.Config) (string, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,320 @@
+package tracer
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shardCount is the number of shards of the execution counts and the aggregates. It is a power of
+// two, so the shard of a name is the low bits of its hash.
+const shardCount = 32
+
+// shardSeed seeds the hash of the names; it is fixed for the life of the process.
+var shardSeed = maphash.MakeSeed()
+
+// shardOf returns the shard of the function name.
+func shardOf(name string) int {
+	return int(maphash.String(shardSeed, name) & (shardCount - 1))
+}
+
+// callCounts counts the calls of each function. The counters are spread over shards by function
+// name and guarded by the shard's lock rather than mu, so calls of different functions on
+// different goroutines are counted without waiting on each other; the counter of a function seen
+// before is incremented atomically under a read lock. The zero value is ready to use.
+type callCounts struct {
+	shards [shardCount]countShard
+}
+
+// countShard is one shard of a callCounts.
+type countShard struct {
+	sync.RWMutex
+	counts map[string]*atomic.Int64
+}
+
+// add counts a call of name and returns the number of calls counted so far.
+func (c *callCounts) add(name string) int {
+	shard := &c.shards[shardOf(name)]
+	shard.RLock()
+	n, ok := shard.counts[name]
+	shard.RUnlock()
+	if !ok {
+		shard.Lock()
+		if n, ok = shard.counts[name]; !ok {
+			if shard.counts == nil {
+				shard.counts = make(map[string]*atomic.Int64)
+			}
+			n = new(atomic.Int64)
+			shard.counts[name] = n
+		}
+		shard.Unlock()
+	}
+	return int(n.Add(1))
+}
+
+// total returns the number of calls counted, of all functions.
+func (c *callCounts) total() int {
+	total := 0
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.RLock()
+		for _, n := range shard.counts {
+			total += int(n.Load())
+		}
+		shard.RUnlock()
+	}
+	return total
+}
+
+// snapshot copies the counters, or returns nil if no call was counted.
+func (c *callCounts) snapshot() map[string]int {
+	var freq map[string]int
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.RLock()
+		for name, n := range shard.counts {
+			if freq == nil {
+				freq = make(map[string]int)
+			}
+			freq[name] = int(n.Load())
+		}
+		shard.RUnlock()
+	}
+	return freq
+}
+
+// reset forgets the counters.
+func (c *callCounts) reset() {
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.Lock()
+		shard.counts = nil
+		shard.Unlock()
+	}
+}
+
+// aggregateTable holds the per-function aggregates and the caller -> callee call counts. The
+// aggregates of a function with an ID are keyed and sharded by the ID, so adding a call neither
+// hashes nor compares its name; those of other functions are keyed by name and sharded as the
+// execution counts are. The edges into a function are kept in its shard, keyed by the IDs of both
+// functions when they have one. The calls of a function from code that is not instrumented, with
+// no caller recorded, are kept as an edge from the caller "". The zero value is ready to use.
+type aggregateTable struct {
+	shards [shardCount]aggregateShard
+}
+
+// aggregateShard is one shard of an aggregateTable.
+type aggregateShard struct {
+	sync.Mutex
+	ids      map[int]*functionAggregate
+	funcs    map[string]*functionAggregate
+	edges    map[callEdge]*edgeCalls
+	keyEdges map[keyEdge]*edgeCount
+}
+
+// keyEdge identifies a caller -> callee relationship by function ID.
+type keyEdge struct {
+	caller, callee int
+}
+
+// edgeCount counts the calls along a keyEdge, with the names of its functions.
+type edgeCount struct {
+	edge callEdge
+	edgeCalls
+}
+
+// edgeCalls counts the calls along a call edge, and those of them made through code that is not
+// instrumented (see TraceRecord.ViaUninstrumented).
+type edgeCalls struct {
+	calls, uninstrumented int
+}
+
+// add counts the call s along the edge.
+func (c *edgeCalls) add(s aggregateSample) {
+	c.calls++
+	if s.uninstrumented {
+		c.uninstrumented++
+	}
+}
+
+// functionKey returns the key of the aggregates of the call rec: its function ID, or 0 if it has
+// none or its span was renamed, when its aggregates are keyed by its name. Callers must hold mu.
+func (rec *TraceRecord) functionKey() int {
+	if rec.renamed {
+		return 0
+	}
+	return rec.FunctionID
+}
+
+// aggregateSample is what the aggregates take of a completed call. It is copied from the call's
+// record while mu is held, so the record is not read once mu is released, when it may be spilled
+// or recycled.
+type aggregateSample struct {
+	key            int // See functionKey.
+	name           string
+	callerKey      int
+	caller         string // The caller's name, or "" without a recorded caller.
+	duration       time.Duration
+	memDiff        uint64
+	recursionDepth int
+	errored        bool // The call has an error status.
+	uninstrumented bool // The call was made through code that is not instrumented.
+}
+
+// sampleOf returns the aggregate sample of the completed record rec. Callers must hold mu.
+func sampleOf(rec *TraceRecord) aggregateSample {
+	return aggregateSample{
+		key:            rec.functionKey(),
+		name:           rec.FunctionName,
+		callerKey:      rec.callerKey,
+		caller:         rec.callerName,
+		duration:       rec.Duration,
+		memDiff:        rec.MemDiff,
+		recursionDepth: rec.RecursionDepth,
+		errored:        rec.Status == StatusError,
+		uninstrumented: rec.ViaUninstrumented,
+	}
+}
+
+// add folds the completed call s into the aggregates of its function.
+func (t *aggregateTable) add(s aggregateSample) {
+	var shard *aggregateShard
+	if s.key > 0 {
+		shard = &t.shards[s.key&(shardCount-1)]
+	} else {
+		shard = &t.shards[shardOf(s.name)]
+	}
+	shard.Lock()
+	defer shard.Unlock()
+	shard.aggregate(s).add(s)
+	if s.caller == "" && !s.uninstrumented {
+		return
+	}
+	if s.key > 0 && s.callerKey > 0 {
+		key := keyEdge{caller: s.callerKey, callee: s.key}
+		edge, ok := shard.keyEdges[key]
+		if !ok {
+			if shard.keyEdges == nil {
+				shard.keyEdges = make(map[keyEdge]*edgeCount)
+			}
+			edge = &edgeCount{edge: callEdge{Caller: s.caller, Callee: s.name}}
+			shard.keyEdges[key] = edge
+		}
+		edge.add(s)
+		return
+	}
+	key := callEdge{Caller: s.caller, Callee: s.name}
+	edge, ok := shard.edges[key]
+	if !ok {
+		if shard.edges == nil {
+			shard.edges = make(map[callEdge]*edgeCalls)
+		}
+		edge = new(edgeCalls)
+		shard.edges[key] = edge
+	}
+	edge.add(s)
+}
+
+// aggregate returns the aggregates of the function of s, creating them on its first call.
+// Callers must hold the shard's lock.
+func (shard *aggregateShard) aggregate(s aggregateSample) *functionAggregate {
+	if s.key > 0 {
+		agg, ok := shard.ids[s.key]
+		if !ok {
+			if shard.ids == nil {
+				shard.ids = make(map[int]*functionAggregate)
+			}
+			agg = &functionAggregate{name: s.name}
+			shard.ids[s.key] = agg
+		}
+		return agg
+	}
+	agg, ok := shard.funcs[s.name]
+	if !ok {
+		if shard.funcs == nil {
+			shard.funcs = make(map[string]*functionAggregate)
+		}
+		agg = &functionAggregate{name: s.name}
+		shard.funcs[s.name] = agg
+	}
+	return agg
+}
+
+// add folds the completed call s into agg.
+func (agg *functionAggregate) add(s aggregateSample) {
+	agg.Calls++
+	agg.Total += s.duration
+	agg.MemDiff += s.memDiff
+	agg.MaxRecursionDepth = max(agg.MaxRecursionDepth, s.recursionDepth)
+	if s.errored {
+		agg.Errors++
+	}
+}
+
+// merge folds the aggregates of other, of a function of the same name, into agg.
+func (agg *functionAggregate) merge(other *functionAggregate) {
+	agg.Calls += other.Calls
+	agg.Total += other.Total
+	agg.MemDiff += other.MemDiff
+	agg.MaxRecursionDepth = max(agg.MaxRecursionDepth, other.MaxRecursionDepth)
+	agg.Errors += other.Errors
+}
+
+// functions copies the aggregates of the functions with a completed call, by name. The aggregates
+// of calls of a function under its ID and under its name, as when a span was renamed to the name
+// of a function, are merged.
+func (t *aggregateTable) functions() map[string]functionAggregate {
+	funcs := make(map[string]functionAggregate)
+	add := func(agg *functionAggregate) {
+		sum := funcs[agg.name]
+		sum.name = agg.name
+		sum.merge(agg)
+		funcs[agg.name] = sum
+	}
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.Lock()
+		for _, agg := range shard.ids {
+			add(agg)
+		}
+		for _, agg := range shard.funcs {
+			add(agg)
+		}
+		shard.Unlock()
+	}
+	return funcs
+}
+
+// callEdges copies the caller -> callee call counts, by name.
+func (t *aggregateTable) callEdges() map[callEdge]edgeCalls {
+	edges := make(map[callEdge]edgeCalls)
+	add := func(edge callEdge, c edgeCalls) {
+		sum := edges[edge]
+		sum.calls += c.calls
+		sum.uninstrumented += c.uninstrumented
+		edges[edge] = sum
+	}
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.Lock()
+		for _, edge := range shard.keyEdges {
+			add(edge.edge, edge.edgeCalls)
+		}
+		for edge, c := range shard.edges {
+			add(edge, *c)
+		}
+		shard.Unlock()
+	}
+	return edges
+}
+
+// reset forgets the aggregates.
+func (t *aggregateTable) reset() {
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.Lock()
+		shard.ids, shard.funcs, shard.edges, shard.keyEdges = nil, nil, nil, nil
+		shard.Unlock()
+	}
+}
@@ -0,0 +1,42 @@
+package tracer_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestParallelCallsAreCountedAndAggregated(t *testing.T) {
+	withTracer(t, config.Config{})
+	const workers, calls = 16, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// Half the workers share a function, so the counts contend within a shard.
+			name := "shared"
+			if w%2 == 1 {
+				name = fmt.Sprintf("worker%d", w)
+			}
+			for i := 0; i < calls; i++ {
+				tracer.StartSpan(nil, name).End()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if got := tracer.CurrentStatus().TotalCalls; got != workers*calls {
+		t.Errorf("Expected %d calls counted, got %d", workers*calls, got)
+	}
+	byName := make(map[string]int)
+	for _, fn := range tracer.CurrentStats(workers).TopFunctions {
+		byName[fn.Name] = fn.Calls
+	}
+	if byName["shared"] != workers/2*calls || byName["worker1"] != calls || len(byName) != workers/2+1 {
+		t.Errorf("Unexpected aggregated calls: %v", byName)
+	}
+}
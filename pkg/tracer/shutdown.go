@@ -0,0 +1,33 @@
+package tracer
+
+// Shutdown completes the run for programs that exit without returning from main, e.g. through
+// os.Exit, which skips deferred calls: the calls still open are recorded as exiting now, the
+// commands their calls started and never waited for are forgotten, and the call graph, trace file
+// and run metadata are written as they are at the end of main. The
+// instrumentation inserts a call before every os.Exit unless instrumentation.skipExitRewrite is
+// set.
+func Shutdown() {
+	ensureInitialized()
+	for {
+		mu.Lock()
+		if len(callStack) == 0 {
+			mu.Unlock()
+			break
+		}
+		top := callStack[len(callStack)-1]
+		mu.Unlock()
+		RecordExit(top.FunctionName, top.EntryTime)
+	}
+	mu.Lock()
+	forgetExitedSpawns()
+	mu.Unlock()
+	if err := DumpCallGraphDOT(ArtifactPath("callgraph.dot")); err != nil {
+		logger.Printf("[TRACEWRAP] Failed to write call graph on shutdown: %v", err)
+	}
+	if err := Flush(); err != nil {
+		logger.Printf("[TRACEWRAP] Failed to flush trace on shutdown: %v", err)
+	}
+	if activeConfig.Tracing.DumpOnExit {
+		DumpTrace()
+	}
+}
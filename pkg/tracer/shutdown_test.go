@@ -0,0 +1,36 @@
+package tracer_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestShutdownClosesOpenCallsAndWritesArtifacts(t *testing.T) {
+	withTracer(t, config.Config{})
+
+	// Simulates os.Exit from run, called by main: neither call returns.
+	tracer.RecordEntry("main")
+	call("setup", nil)
+	tracer.RecordEntry("run")
+	tracer.Shutdown()
+
+	records, err := tracer.ReadTraceFile(filepath.Join("tracewrap", "latest", "trace.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to read trace file: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+	for _, rec := range records {
+		if rec.ExitTime.IsZero() {
+			t.Errorf("Expected %s to have an exit time", rec.FunctionName)
+		}
+	}
+	if _, err := os.Stat(filepath.Join("tracewrap", "latest", "callgraph.dot")); err != nil {
+		t.Errorf("Expected the call graph to be written: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package tracer
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Size is the length of a slice, map or string parameter or return value, and the capacity of a
+// slice, stored in TraceRecord.ParamSizes and TraceRecord.ReturnSizes with tracing.capture.sizes.
+type Size struct {
+	Len int `json:"len"`
+	Cap int `json:"cap,omitempty"` // Slices only.
+}
+
+// String returns the size as "len=3 cap=4", or "len=3" without a capacity.
+func (s Size) String() string {
+	if s.Cap > 0 {
+		return fmt.Sprintf("len=%d cap=%d", s.Len, s.Cap)
+	}
+	return fmt.Sprintf("len=%d", s.Len)
+}
+
+// sizesEnabled reports whether tracing.capture records the sizes of collections.
+func sizesEnabled() bool {
+	return activeConfig.Tracing.Capture.Sizes || activeConfig.Tracing.Capture.SizesOnly
+}
+
+// sizeOf returns the size of v, or nil if v is not a slice, map or string. Opaque type names are
+// not values and are excluded. With tracing.capture.raceSafe set maps are excluded too, as
+// reading their length races with the goroutines writing them; the length and capacity of a
+// slice are copied when it is passed.
+func sizeOf(v interface{}) *Size {
+	if _, ok := v.(Opaque); ok {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice:
+		return &Size{Len: rv.Len(), Cap: rv.Cap()}
+	case reflect.String:
+		return &Size{Len: rv.Len()}
+	case reflect.Map:
+		if !activeConfig.Tracing.Capture.RaceSafe {
+			return &Size{Len: rv.Len()}
+		}
+	}
+	return nil
+}
+
+// sizeOnlyValue returns the rendering of v with tracing.capture.sizesOnly set, its type and size
+// such as "<[]string len=3 cap=4>", and true if v is a slice, map or string.
+func sizeOnlyValue(v interface{}) (string, bool) {
+	if !activeConfig.Tracing.Capture.SizesOnly {
+		return "", false
+	}
+	size := sizeOf(v)
+	if size == nil {
+		return "", false
+	}
+	s := fmt.Sprintf("<%s %s>", reflect.TypeOf(v), size)
+	if activeConfig.Tracing.Capture.Encoding == EncodingJSON {
+		return strconv.Quote(s), true
+	}
+	return s, true
+}
+
+// recordParamSize stores the size of the parameter value on rec, if it is a collection and
+// tracing.capture records sizes. Callers must hold mu.
+func recordParamSize(rec *TraceRecord, name string, v interface{}) {
+	if !sizesEnabled() {
+		return
+	}
+	if size := sizeOf(v); size != nil {
+		if rec.ParamSizes == nil {
+			rec.ParamSizes = make(map[string]Size)
+		}
+		rec.ParamSizes[name] = *size
+	}
+}
+
+// recordReturnSizes appends the sizes of return values to rec, which recorded prior return
+// values before them. Positions match ReturnValues, with nil for values that are not collections;
+// the slice is only kept when at least one value is. Callers must hold mu.
+func recordReturnSizes(rec *TraceRecord, prior int, values []interface{}) {
+	if !sizesEnabled() {
+		return
+	}
+	sizes := make([]*Size, len(values))
+	found := rec.ReturnSizes != nil
+	for i, v := range values {
+		sizes[i] = sizeOf(v)
+		found = found || sizes[i] != nil
+	}
+	if !found {
+		return
+	}
+	if rec.ReturnSizes == nil {
+		// Earlier returns of this record had no collections; pad them to keep positions aligned.
+		rec.ReturnSizes = make([]*Size, prior)
+	}
+	rec.ReturnSizes = append(rec.ReturnSizes, sizes...)
+}
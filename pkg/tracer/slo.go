@@ -0,0 +1,59 @@
+package tracer
+
+import (
+	"net/http"
+	"path"
+	"time"
+)
+
+// requestRoute returns the route served by req: its http.ServeMux pattern when the request was
+// routed by one, otherwise its method and path.
+func requestRoute(req *http.Request) string {
+	if req.Pattern != "" {
+		return req.Pattern
+	}
+	if req.URL == nil {
+		return req.Method
+	}
+	return req.Method + " " + req.URL.Path
+}
+
+// sloFor returns the first tracing.slos entry matching rec's route or function name.
+//
+// Parameters:
+//   - rec (*TraceRecord): the completed record.
+//
+// Returns:
+//   - string: the matched target, "route <pattern>" or the function name pattern.
+//   - time.Duration: the latency budget, or 0 if no entry matches.
+func sloFor(rec *TraceRecord) (string, time.Duration) {
+	for _, slo := range activeConfig.Tracing.SLOs {
+		if slo.Route != "" {
+			if rec.Route == "" {
+				continue
+			}
+			if ok, _ := path.Match(slo.Route, rec.Route); ok {
+				return "route " + slo.Route, slo.Latency
+			}
+			continue
+		}
+		if ok, _ := path.Match(slo.Function, rec.FunctionName); ok {
+			return slo.Function, slo.Latency
+		}
+	}
+	return "", 0
+}
+
+// checkSLO marks rec with its latency budget and whether the call exceeded it. Callers must hold mu.
+func checkSLO(rec *TraceRecord) {
+	target, budget := sloFor(rec)
+	if budget <= 0 {
+		return
+	}
+	rec.SLOTarget = target
+	rec.SLOBudget = budget
+	if rec.Duration > budget {
+		rec.SLOViolated = true
+		logger.Printf("[TRACEWRAP] SLO violation: %s (ID: %d) took %v, budget %v (%s)", rec.FunctionName, rec.UniqueID, rec.Duration, budget, target)
+	}
+}
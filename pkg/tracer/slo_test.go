@@ -0,0 +1,46 @@
+package tracer_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestSLOViolationsAreMarked(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{SLOs: []config.SLOConfig{
+		{Route: "GET /orders/*", Latency: time.Millisecond},
+		{Function: "fast*", Latency: time.Hour},
+	}}})
+
+	start := time.Now()
+	tracer.RecordEntry("handleOrder")
+	tracer.RecordParam("r", httptest.NewRequest("GET", "/orders/42", nil))
+	time.Sleep(5 * time.Millisecond)
+	tracer.RecordExit("handleOrder", start)
+	call("fastPath", nil)
+	call("unbudgeted", nil)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Expected three records, got %d (%v)", len(records), err)
+	}
+	byName := make(map[string]tracer.TraceRecord)
+	for _, rec := range records {
+		byName[rec.FunctionName] = rec
+	}
+	order := byName["handleOrder"]
+	if order.Route != "GET /orders/42" || order.SLOTarget != "route GET /orders/*" || !order.SLOViolated {
+		t.Errorf("Expected the route SLO to be violated, got route=%q target=%q violated=%v", order.Route, order.SLOTarget, order.SLOViolated)
+	}
+	if fast := byName["fastPath"]; fast.SLOTarget != "fast*" || fast.SLOBudget != time.Hour || fast.SLOViolated {
+		t.Errorf("Expected the function SLO to be met, got %+v", fast)
+	}
+	if other := byName["unbudgeted"]; other.SLOTarget != "" || other.SLOViolated {
+		t.Errorf("Expected no SLO on unbudgeted, got target %q", other.SLOTarget)
+	}
+}
@@ -0,0 +1,207 @@
+package tracer
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Metrics a Snapshot measures, combined with |; instrumentation.metrics picks them for the
+// injected code.
+const (
+	MeasureTime       = 1 << iota // Process CPU time.
+	MeasureMem                    // Heap allocation and GC cycles, from runtime.ReadMemStats.
+	MeasureGoroutines             // Goroutines and cgo calls.
+	MeasureIO                     // Network and disk usage.
+
+	MeasureAll = MeasureTime | MeasureMem | MeasureGoroutines | MeasureIO
+)
+
+// Snapshot is the resource usage of the process at the entry of a call, taken by TakeSnapshot and
+// passed to Span.RecordAll at its exit, which records the difference. Only the fields of its Metrics
+// are set.
+type Snapshot struct {
+	Metrics    int           // The Measure* flags measured.
+	CPUTime    time.Duration // Process CPU time, with MeasureTime.
+	HeapAlloc  uint64        // Heap bytes allocated, with MeasureMem.
+	NumGC      uint32        // Completed GC cycles, with MeasureMem.
+	Goroutines int           // Goroutines running, with MeasureGoroutines.
+	CgoCalls   int64         // Cgo calls made, with MeasureGoroutines.
+	NetUsage   int64         // Network bytes, with MeasureIO.
+	DiskUsage  int64         // Disk bytes of the process, with MeasureIO.
+}
+
+// TakeSnapshot measures the resource usage of the process for the metrics selected, reading the
+// memory stats at most once. With tracing.light set nothing is measured, as no record keeps it.
+//
+// Parameters:
+//   - metrics (int): the Measure* flags to measure.
+//
+// Returns:
+//   - Snapshot: the measurements.
+func TakeSnapshot(metrics int) Snapshot {
+	ensureInitialized()
+	if lightMode() {
+		return Snapshot{}
+	}
+	s := Snapshot{Metrics: metrics}
+	if metrics&MeasureTime != 0 {
+		s.CPUTime = GetProcessCPUTime()
+	}
+	if metrics&MeasureMem != 0 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		s.HeapAlloc, s.NumGC = m.HeapAlloc, m.NumGC
+	}
+	if metrics&MeasureGoroutines != 0 {
+		s.Goroutines = runtime.NumGoroutine()
+		s.CgoCalls = runtime.NumCgoCall()
+	}
+	if metrics&MeasureIO != 0 {
+		s.NetUsage = GetNetworkUsage()
+		s.DiskUsage = GetDiskUsage()
+	}
+	return s
+}
+
+// Metrics is the resource usage of a call, recorded on its record by EndSpan. Only the fields of
+// the Measure* flags in Measured are recorded; the record keeps the others as they are.
+type Metrics struct {
+	Measured   int           // The Measure* flags of the fields set.
+	CPUTime    time.Duration // Process CPU time used, with MeasureTime.
+	HeapAlloc  int64         // Change in heap bytes allocated, with MeasureMem.
+	HeapFreed  int64         // Change in heap bytes freed, with MeasureMem.
+	GCCycles   uint32        // GC cycles completed, with MeasureMem.
+	Goroutines int           // Change in running goroutines, with MeasureGoroutines.
+	CgoCalls   int64         // Cgo calls made, with MeasureGoroutines.
+	NetBytes   int64         // Network bytes transferred, with MeasureIO.
+	DiskBytes  int64         // Disk bytes read and written by the process, with MeasureIO.
+}
+
+// MetricsSince measures the metrics of start again and returns the usage since it was taken.
+//
+// Parameters:
+//   - start (Snapshot): the snapshot taken at the start of the call.
+//
+// Returns:
+//   - Metrics: the differences, measuring the metrics of start.
+func MetricsSince(start Snapshot) Metrics {
+	end := TakeSnapshot(start.Metrics)
+	return Metrics{
+		Measured:   start.Metrics,
+		CPUTime:    end.CPUTime - start.CPUTime,
+		HeapAlloc:  int64(end.HeapAlloc) - int64(start.HeapAlloc),
+		GCCycles:   end.NumGC - start.NumGC,
+		Goroutines: end.Goroutines - start.Goroutines,
+		CgoCalls:   end.CgoCalls - start.CgoCalls,
+		NetBytes:   end.NetUsage - start.NetUsage,
+		DiskBytes:  end.DiskUsage - start.DiskUsage,
+	}
+}
+
+// EndSpan completes a call with its resource usage: the metrics are recorded on its record and it
+// exits as RecordExit or Span.End do, writing one log line for the metrics. The tracer's lock is
+// taken once for a span, and twice for the innermost open call, whose name is read first. It
+// replaces the Record*Usage calls, RecordGCActivity and RecordExit of a call.
+//
+// Parameters:
+//   - span (*Span): the span to end, or nil for the innermost open call. Calls for a span that
+//     has already ended have no effect.
+//   - m (Metrics): the resource usage of the call.
+func EndSpan(span *Span, m Metrics) {
+	ensureInitialized()
+	if span == nil {
+		mu.Lock()
+		name := ""
+		if len(callStack) > 0 {
+			name = callStack[len(callStack)-1].FunctionName
+		}
+		mu.Unlock()
+		exitRecord(name, nil, m, false)
+		return
+	}
+	exitRecord(span.name, span, m, false)
+}
+
+// RecordAll completes the current call: it measures the metrics of start again, counts the call
+// in the execution counts and ends it with the differences, as EndSpan does.
+//
+// Parameters:
+//   - functionName (string): the name of the function exiting.
+//   - start (Snapshot): the snapshot taken at the entry of the call.
+func RecordAll(functionName string, start Snapshot) {
+	ensureInitialized()
+	exitRecord(functionName, nil, MetricsSince(start), true)
+}
+
+// applyMetrics records the measured fields of m on rec. Callers must hold mu.
+func applyMetrics(rec *TraceRecord, m Metrics) {
+	if m.Measured&MeasureTime != 0 {
+		rec.CPUTime = m.CPUTime
+	}
+	if m.Measured&MeasureMem != 0 {
+		rec.HeapAllocDelta = m.HeapAlloc
+		rec.HeapFreeDelta = m.HeapFreed
+		rec.GCCountDelta = m.GCCycles
+	}
+	if m.Measured&MeasureGoroutines != 0 {
+		rec.GoroutinesDelta = m.Goroutines
+		rec.ThreadsDelta = m.CgoCalls
+	}
+	if m.Measured&MeasureIO != 0 {
+		rec.NetUsageDelta = m.NetBytes
+		rec.DiskUsageDelta = m.DiskBytes
+	}
+}
+
+// metricsOf returns the metrics recorded on rec. Callers must hold mu.
+func metricsOf(rec *TraceRecord) Metrics {
+	return Metrics{
+		Measured:   MeasureAll,
+		CPUTime:    rec.CPUTime,
+		HeapAlloc:  rec.HeapAllocDelta,
+		HeapFreed:  rec.HeapFreeDelta,
+		GCCycles:   rec.GCCountDelta,
+		Goroutines: rec.GoroutinesDelta,
+		CgoCalls:   rec.ThreadsDelta,
+		NetBytes:   rec.NetUsageDelta,
+		DiskBytes:  rec.DiskUsageDelta,
+	}
+}
+
+// formatMetrics renders the measured fields of m for the log, e.g.
+// "CPU Time: 1ms, Goroutines Spawned: 2, Cgo Calls: 0", or "" if none are.
+func formatMetrics(m Metrics) string {
+	var parts []string
+	if m.Measured&MeasureTime != 0 {
+		parts = append(parts, fmt.Sprintf("CPU Time: %v", m.CPUTime))
+	}
+	if m.Measured&MeasureMem != 0 {
+		parts = append(parts, fmt.Sprintf("Heap Allocated Delta: %d, Heap Freed Delta: %d, GC Runs: %d", m.HeapAlloc, m.HeapFreed, m.GCCycles))
+	}
+	if m.Measured&MeasureGoroutines != 0 {
+		parts = append(parts, fmt.Sprintf("Goroutines Spawned: %d, Cgo Calls: %d", m.Goroutines, m.CgoCalls))
+	}
+	if m.Measured&MeasureIO != 0 {
+		parts = append(parts, fmt.Sprintf("Network Usage Delta: %d, Disk I/O Delta: %d", m.NetBytes, m.DiskBytes))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// updateMetrics applies update to the metrics of the innermost open call, for the deprecated
+// functions recording one metric at a time, and logs the result.
+func updateMetrics(functionName string, update func(m *Metrics)) {
+	ensureInitialized()
+	mu.Lock()
+	if len(callStack) == 0 || callStack[len(callStack)-1].dropped {
+		mu.Unlock()
+		return
+	}
+	top := callStack[len(callStack)-1]
+	m := metricsOf(top)
+	update(&m)
+	applyMetrics(top, m)
+	mu.Unlock()
+	logf(functionName, "[TRACEWRAP] Function %s Metrics - %s", functionName, formatMetrics(m))
+}
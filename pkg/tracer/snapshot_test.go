@@ -0,0 +1,110 @@
+package tracer_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestRecordAllRecordsSnapshotDifferences(t *testing.T) {
+	withTracer(t, config.Config{})
+
+	snapshot := tracer.TakeSnapshot(tracer.MeasureMem | tracer.MeasureGoroutines)
+	if snapshot.HeapAlloc == 0 || snapshot.Goroutines == 0 || snapshot.CPUTime != 0 || snapshot.NetUsage != 0 {
+		t.Errorf("Expected only the memory and goroutine fields to be measured, got %+v", snapshot)
+	}
+	// The goroutine outlives the call, so the call counts it.
+	stop := make(chan struct{})
+	defer close(stop)
+	tracer.RecordEntry("spawn")
+	go func() { <-stop }()
+	tracer.RecordAll("spawn", snapshot)
+	tracer.RecordAll("unmatched", tracer.TakeSnapshot(0))
+
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("ReadTraceFile returned error: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	if rec := records[0]; rec.FunctionName != "spawn" || rec.GoroutinesDelta != 1 || rec.Duration <= 0 {
+		t.Errorf("Expected the exited call with one goroutine spawned, got %+v", rec)
+	}
+	data, err := os.ReadFile("tracewrap/latest/run.json")
+	if err != nil {
+		t.Fatalf("Failed to read run metadata: %v", err)
+	}
+	var meta tracer.RunMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("Failed to parse run metadata: %v", err)
+	}
+	if meta.ExecFrequency["spawn"] != 1 || meta.ExecFrequency["unmatched"] != 1 {
+		t.Errorf("Expected each RecordAll to count its call, got %v", meta.ExecFrequency)
+	}
+}
+
+func TestEndSpanRecordsOnlyMeasuredMetrics(t *testing.T) {
+	withTracer(t, config.Config{})
+
+	span := tracer.StartSpan(nil, "span")
+	tracer.EndSpan(span, tracer.Metrics{Measured: tracer.MeasureIO, NetBytes: 10, DiskBytes: 20, Goroutines: 5})
+	// Ending it again has no effect.
+	tracer.EndSpan(span, tracer.Metrics{Measured: tracer.MeasureIO, NetBytes: 30})
+	tracer.RecordEntry("call")
+	tracer.EndSpan(nil, tracer.Metrics{Measured: tracer.MeasureTime | tracer.MeasureMem, CPUTime: 7, HeapAlloc: 64, GCCycles: 2})
+
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("ReadTraceFile returned error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	byName := map[string]tracer.TraceRecord{}
+	for _, rec := range records {
+		byName[rec.FunctionName] = rec
+	}
+	if rec := byName["span"]; rec.NetUsageDelta != 10 || rec.DiskUsageDelta != 20 || rec.GoroutinesDelta != 0 {
+		t.Errorf("Expected only the I/O metrics of the first EndSpan, got %+v", rec)
+	}
+	if rec := byName["call"]; rec.CPUTime != 7 || rec.HeapAllocDelta != 64 || rec.GCCountDelta != 2 || rec.NetUsageDelta != 0 {
+		t.Errorf("Expected the time and memory metrics on the innermost call, got %+v", rec)
+	}
+}
+
+func TestDeprecatedUsageCallsRecordMetrics(t *testing.T) {
+	withTracer(t, config.Config{})
+
+	tracer.RecordEntry("legacy")
+	tracer.RecordGoroutineUsage("legacy", 2)
+	tracer.RecordHeapUsage("legacy", 128, 32)
+	tracer.RecordIOUsage("legacy", 4, 8)
+	tracer.RecordResourceUsage("legacy", 3, 256)
+	tracer.RecordExit("legacy", time.Now())
+
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("ReadTraceFile returned error: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	rec := records[0]
+	if rec.GoroutinesDelta != 2 || rec.HeapAllocDelta != 256 || rec.HeapFreeDelta != 32 || rec.NetUsageDelta != 4 || rec.DiskUsageDelta != 8 || rec.CPUTime != 3 {
+		t.Errorf("Expected each deprecated call to keep the others' metrics, got %+v", rec)
+	}
+}
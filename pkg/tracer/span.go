@@ -0,0 +1,312 @@
+package tracer
+
+import (
+	"context"
+	"time"
+)
+
+// Span is a traced call: the call of an instrumented function opened by RecordEntry, or one
+// started by hand with StartSpan, for code that marks its own units of work, such as the
+// OpenTelemetry bridge (pkg/otelbridge). Its record is completed by End or RecordAll, in whatever
+// order spans end; the methods may be called from any goroutine. A nil *Span is valid and records
+// nothing.
+type Span struct {
+	rec    *TraceRecord
+	id     int64  // The unique ID of rec, copied when the span starts.
+	rootID int64  // The unique ID of the root record of its trace, copied when the span starts.
+	name   string // The name the span started with, which its exit counts and logs under.
+	key    int    // The aggregate key of rec under name (see functionKey), copied when the span starts.
+	// dropped is whether the span's record is dropped, copied when the span starts, for the calls
+	// of the span started after it ends.
+	dropped bool
+	// traceID and spanID are the W3C trace context of the span, copied from its record when it
+	// starts and whenever the span changes it. Guarded by mu.
+	traceID, spanID string
+	ended           bool // Guarded by mu.
+}
+
+// spanOf returns the span of the call rec, which has just been entered. Callers must hold mu.
+func spanOf(rec *TraceRecord) Span {
+	return Span{rec: rec, id: rec.UniqueID, rootID: rec.rootID, name: rec.FunctionName, key: rec.functionKey(), dropped: rec.dropped, traceID: rec.ExternalTraceID, spanID: rec.ExternalSpanID}
+}
+
+// open returns the span's record while its call is open, or nil once the span has ended or the
+// call has exited otherwise, e.g. with RecordExit. The record of an exited call is not read or
+// written through the span: it may be persisted by then, or recycled in light mode. Callers must
+// hold mu.
+func (s *Span) open() *TraceRecord {
+	if s.ended || s.rec == nil || s.rec.UniqueID != s.id || s.rec.exited {
+		return nil
+	}
+	return s.rec
+}
+
+// StartSpan opens a span named name as a call of parent, or, without one, of the caller the
+// call of an instrumented function would have (see RecordEntry). The span counts as a call of
+// name in the run's execution counts and aggregates.
+//
+// Parameters:
+//   - parent (*Span): the enclosing span, or nil. A parent that has already ended is still the
+//     span's caller, in a trace of the span's own.
+//   - name (string): the name the record carries as its function name.
+//
+// Returns:
+//   - *Span: the open span.
+func StartSpan(parent *Span, name string) *Span {
+	ensureInitialized()
+	var span Span
+	if lightMode() {
+		execFrequency.add(name)
+		span = enterLight(0, name, parent, false)
+	} else {
+		RecordExecutionFrequency(name)
+		span = enterRecord(0, name, parent, false)
+	}
+	return &span
+}
+
+// ID returns the unique ID of the span's record, and the unique ID of the root record of its
+// trace. Both are kept by the span, so they can be read after it ends.
+func (s *Span) ID() (span, root int64) {
+	if s == nil {
+		return 0, 0
+	}
+	return s.id, s.rootID
+}
+
+// TraceContext returns the W3C trace ID and parent span ID the span inherited from the request it
+// serves or was given with SetTraceContext, or empty strings if it has none.
+func (s *Span) TraceContext() (traceID, spanID string) {
+	if s == nil {
+		return "", ""
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return s.traceID, s.spanID
+}
+
+// SetTraceContext sets the W3C trace ID and parent span ID of the remote caller of the span, as
+// the traceparent header of an HTTP request does for instrumented handlers. Calls the span makes
+// after it inherit them.
+//
+// Parameters:
+//   - traceID (string): 32 lowercase hexadecimal digits.
+//   - spanID (string): 16 lowercase hexadecimal digits.
+func (s *Span) SetTraceContext(traceID, spanID string) {
+	if s == nil {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	s.traceID, s.spanID = traceID, spanID
+	if rec := s.open(); rec != nil {
+		rec.ExternalTraceID, rec.ExternalSpanID = traceID, spanID
+	}
+}
+
+// SetExportedSpanID sets the span ID another tracer exported the span with, such as an
+// OpenTelemetry SDK the bridge wraps. Unlike the trace context, calls made after do not inherit it.
+//
+// Parameters:
+//   - spanID (string): 16 lowercase hexadecimal digits.
+func (s *Span) SetExportedSpanID(spanID string) {
+	if s == nil {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if rec := s.open(); rec != nil {
+		rec.ExportedSpanID = spanID
+	}
+}
+
+// Recording reports whether the span is recorded: it has not ended, recording was on and its
+// trace was not sampled out.
+func (s *Span) Recording() bool {
+	if s == nil {
+		return false
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	rec := s.open()
+	return rec != nil && !rec.dropped
+}
+
+// SetName renames the span. The name it started with remains in the log and execution counts.
+//
+// Parameters:
+//   - name (string): the new name.
+func (s *Span) SetName(name string) {
+	if s == nil || name == "" {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if rec := s.open(); rec != nil {
+		rec.FunctionName = name
+		rec.renamed = true
+	}
+}
+
+// SetAttribute records an attribute of the span as a parameter of its record, rendered as
+// parameters are (see tracing.capture).
+//
+// Parameters:
+//   - key (string): the attribute name.
+//   - value (any): the value.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	hookStart := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	if rec := s.open(); rec != nil {
+		recordParam(rec, key, value, hookStart)
+		// An *http.Request value carries the trace context of the request.
+		s.traceID, s.spanID = rec.ExternalTraceID, rec.ExternalSpanID
+	}
+}
+
+// SetVariadic records the arguments passed to a variadic parameter of the span's call: their
+// number in the record's Variadic, and each of them as the parameter key[0], key[1], and so on,
+// rendered as SetAttribute renders values. At most tracing.capture.maxVariadicArgs of them are
+// recorded, and the parameter counts once against tracing.capture.maxParams. A value that is not a
+// slice is recorded as SetAttribute records it.
+//
+// Parameters:
+//   - key (string): the parameter name.
+//   - values (any): the slice of arguments, e.g. args of args ...any.
+func (s *Span) SetVariadic(key string, values any) {
+	if s == nil {
+		return
+	}
+	hookStart := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	if rec := s.open(); rec != nil {
+		recordVariadic(rec, key, values, hookStart)
+	}
+}
+
+// Event attaches a timestamped event to the span, as Event does for the innermost call.
+//
+// Parameters:
+//   - name (string): the event name.
+//   - at (time.Time): when it happened, or the zero time for now.
+//   - attrs (map[string]any): attributes of the event, or nil.
+func (s *Span) Event(name string, at time.Time, attrs map[string]any) {
+	if s == nil {
+		return
+	}
+	if at.IsZero() {
+		at, _ = clockNow()
+	}
+	ev := SpanEvent{Name: name, Time: at, Attrs: eventAttrs(attrs)}
+	mu.Lock()
+	defer mu.Unlock()
+	addEvent(s.open(), ev)
+}
+
+// SetStatus sets the status of the span to StatusOK or StatusError. As for HTTP and gRPC calls,
+// an error status is not reset to ok. An error status also makes tail sampling keep the trace,
+// as a returned error does.
+//
+// Parameters:
+//   - status (string): StatusOK or StatusError.
+func (s *Span) SetStatus(status string) {
+	if s == nil || status != StatusOK && status != StatusError {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	rec := s.open()
+	if rec == nil {
+		return
+	}
+	setStatus(rec, status)
+	if status == StatusError {
+		rec.returnedError = true
+	}
+}
+
+// End completes the span's record, with the calls it started and that are still open left
+// running. Calls after the first have no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	EndSpan(s, Metrics{})
+}
+
+// RecordLabels records the pprof labels carried by ctx on the span's record, as RecordLabels does
+// for the innermost call.
+//
+// Parameters:
+//   - ctx (context.Context): the call's context; nil is ignored.
+func (s *Span) RecordLabels(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	labels := contextLabels(ctx)
+	if labels == nil {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if rec := s.open(); rec != nil && !rec.dropped {
+		rec.Labels = labels
+	}
+}
+
+// RecordReturn records the return values of the span's call, as RecordReturn does for the
+// innermost call.
+//
+// Parameters:
+//   - returns (...interface{}): the values returned.
+func (s *Span) RecordReturn(returns ...interface{}) {
+	if s == nil {
+		return
+	}
+	hookStart := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	if rec := s.open(); rec != nil {
+		recordReturn(rec, rec.FunctionName, returns, hookStart)
+	}
+}
+
+// RecordPanic records a panic of the span's call, as RecordPanic does for the innermost call.
+//
+// Parameters:
+//   - panicValue (interface{}): the value recovered from the panic.
+//   - stack (string): the stack trace captured at the time of panic.
+func (s *Span) RecordPanic(panicValue interface{}, stack string) {
+	if s == nil {
+		return
+	}
+	mu.Lock()
+	rec := s.open()
+	if rec == nil {
+		mu.Unlock()
+		return
+	}
+	msg, notify := recordPanic(rec, rec.FunctionName, panicValue, stack)
+	mu.Unlock()
+	if notify {
+		sendPanicNotification(msg)
+	}
+}
+
+// RecordAll ends the span as RecordAll does the innermost call: it measures the metrics of start
+// again, counts the call in the execution counts and records the differences. It is the deferred
+// call the instrumentation injects to close a call.
+//
+// Parameters:
+//   - start (Snapshot): the snapshot taken at the entry of the call.
+func (s *Span) RecordAll(start Snapshot) {
+	if s == nil {
+		return
+	}
+	exitRecord(s.name, s, MetricsSince(start), true)
+}
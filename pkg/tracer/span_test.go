@@ -0,0 +1,139 @@
+package tracer_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestSpansEndOutOfOrder(t *testing.T) {
+	withTracer(t, config.Config{})
+	outer := tracer.StartSpan(nil, "outer")
+	inner := tracer.StartSpan(outer, "inner")
+	outer.End()
+	// Calls made after outer ended nest under the span still open.
+	call("work", nil)
+	sibling := tracer.StartSpan(outer, "sibling")
+	inner.SetAttribute("attempt", 2)
+	inner.End()
+	sibling.End()
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("Failed to read trace file: %v", err)
+	}
+	ids := make(map[string]tracer.TraceRecord)
+	for _, rec := range records {
+		ids[rec.FunctionName] = rec
+	}
+	if len(records) != 4 {
+		t.Fatalf("Expected 4 records, got %d", len(records))
+	}
+	if ids["inner"].CallerID != ids["outer"].UniqueID || ids["work"].CallerID != ids["inner"].UniqueID {
+		t.Errorf("Unexpected nesting: %+v", ids)
+	}
+	// A parent that has ended is still the caller of the spans started as its calls.
+	if ids["sibling"].CallerID != ids["outer"].UniqueID {
+		t.Errorf("Expected sibling under outer, got caller %d", ids["sibling"].CallerID)
+	}
+	if ids["inner"].Params["attempt"] != "2" {
+		t.Errorf("Unexpected params: %v", ids["inner"].Params)
+	}
+}
+
+func TestEntrySpanHooksReachTheirCall(t *testing.T) {
+	withTracer(t, config.Config{})
+	a := tracer.RecordEntry("a")
+	// b is opened above a, as a call on another goroutine would be.
+	b := tracer.RecordEntry("b")
+	a.SetAttribute("n", 1)
+	a.RecordLabels(pprof.WithLabels(context.Background(), pprof.Labels("job", "sync")))
+	a.RecordReturn("a-result")
+	a.RecordPanic("boom", "stack")
+	a.RecordAll(tracer.TakeSnapshot(0))
+	// Hooks called after the call ended have no effect.
+	a.RecordReturn("late")
+	b.RecordReturn("b-result")
+	b.RecordAll(tracer.TakeSnapshot(0))
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("Failed to read trace file: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	ids := make(map[string]tracer.TraceRecord)
+	for _, rec := range records {
+		ids[rec.FunctionName] = rec
+	}
+	if rec := ids["a"]; rec.Params["n"] != "1" || rec.Labels["job"] != "sync" || len(rec.ReturnValues) != 1 || rec.PanicValue != "boom" || rec.StackTrace != "stack" {
+		t.Errorf("Expected a's hooks on a's record, got %+v", rec)
+	}
+	if rec := ids["b"]; len(rec.ReturnValues) != 1 || rec.PanicValue != nil || rec.CallerID != ids["a"].UniqueID {
+		t.Errorf("Expected only b's return on b's record, got %+v", rec)
+	}
+}
+
+func TestSpanKeepsItsIDsAfterItsCallExits(t *testing.T) {
+	withTracer(t, config.Config{})
+	const traceID, spanID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	span := tracer.StartSpan(nil, "job")
+	span.SetTraceContext(traceID, spanID)
+	id, root := span.ID()
+	// The call exits without the span, which then no longer touches its record.
+	tracer.RecordExit("job", time.Now())
+	span.SetAttribute("late", 1)
+	if span.Recording() {
+		t.Errorf("Expected a span whose call exited not to be recording")
+	}
+	if gotID, gotRoot := span.ID(); gotID != id || gotRoot != root || id == 0 {
+		t.Errorf("Expected the IDs %d/%d after the exit, got %d/%d", id, root, gotID, gotRoot)
+	}
+	if gotTrace, gotSpan := span.TraceContext(); gotTrace != traceID || gotSpan != spanID {
+		t.Errorf("Expected the trace context after the exit, got %s/%s", gotTrace, gotSpan)
+	}
+	span.End()
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Expected one record, got %d (%v)", len(records), err)
+	}
+	if _, ok := records[0].Params["late"]; ok || records[0].ExternalTraceID != traceID {
+		t.Errorf("Expected the record as it was when the call exited, got %+v", records[0])
+	}
+}
+
+func TestSpanEndedTwiceCountsOnce(t *testing.T) {
+	withTracer(t, config.Config{})
+	span := tracer.RecordEntry("job")
+	span.SetName("renamed")
+	span.RecordAll(tracer.TakeSnapshot(0))
+	span.RecordAll(tracer.TakeSnapshot(0))
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	data, err := os.ReadFile("tracewrap/latest/run.json")
+	if err != nil {
+		t.Fatalf("Failed to read run metadata: %v", err)
+	}
+	var meta tracer.RunMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("Failed to parse run metadata: %v", err)
+	}
+	if meta.ExecFrequency["job"] != 1 || meta.ExecFrequency["renamed"] != 0 {
+		t.Errorf("Expected one call counted under the name the span started with, got %v", meta.ExecFrequency)
+	}
+}
@@ -0,0 +1,185 @@
+package tracer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+// Span statuses of TraceRecord.Status.
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// grpcCodes are the names of the gRPC status codes, indexed by code.
+var grpcCodes = []string{
+	"OK", "Canceled", "Unknown", "InvalidArgument", "DeadlineExceeded", "NotFound", "AlreadyExists",
+	"PermissionDenied", "ResourceExhausted", "FailedPrecondition", "Aborted", "OutOfRange",
+	"Unimplemented", "Internal", "Unavailable", "DataLoss", "Unauthenticated",
+}
+
+// setStatus sets the status of rec. An error status is kept once set, so a failed request stays
+// failed whatever the call does afterwards. Callers must hold mu.
+func setStatus(rec *TraceRecord, status string) {
+	if rec.Status != StatusError {
+		rec.Status = status
+	}
+}
+
+// recordHTTPStatus records the HTTP status code of a response on rec. Server responses are errors
+// from 500 on, client responses from 400 on, following the OpenTelemetry conventions: a 404
+// served is the client's mistake, a 404 received is a failed request. Callers must hold mu.
+func recordHTTPStatus(rec *TraceRecord, code int, server bool) {
+	rec.HTTPStatus = code
+	if code >= 500 || !server && code >= 400 {
+		setStatus(rec, StatusError)
+		return
+	}
+	setStatus(rec, StatusOK)
+}
+
+// recordReturnStatus records the status of a returned value on rec: the status code of an
+// *http.Response, or the code of a gRPC status error. Callers must hold mu.
+func recordReturnStatus(rec *TraceRecord, ret interface{}) {
+	switch v := ret.(type) {
+	case *http.Response:
+		if v != nil {
+			recordHTTPStatus(rec, v.StatusCode, false)
+		}
+	case error:
+		if code, ok := grpcCode(v); ok {
+			rec.GRPCCode = code
+			if code == grpcCodes[0] {
+				setStatus(rec, StatusOK)
+			} else {
+				setStatus(rec, StatusError)
+			}
+		}
+	}
+}
+
+// statusLabel returns the status of rec with the code it was derived from, e.g. "error (HTTP 503)".
+func statusLabel(rec *TraceRecord) string {
+	switch {
+	case rec.HTTPStatus != 0:
+		return fmt.Sprintf("%s (HTTP %d)", rec.Status, rec.HTTPStatus)
+	case rec.GRPCCode != "":
+		return fmt.Sprintf("%s (gRPC %s)", rec.Status, rec.GRPCCode)
+	}
+	return rec.Status
+}
+
+// grpcCode returns the name of the gRPC status code carried by err or an error it wraps. gRPC
+// status errors have a GRPCStatus method returning a *status.Status with a Code method; they are
+// recognized by these methods, so the tracer does not depend on the gRPC module.
+//
+// Parameters:
+//   - err (error): a returned error.
+//
+// Returns:
+//   - string: the code name, e.g. "Unavailable", or "Code(<n>)" for unknown codes.
+//   - bool: false if err carries no gRPC status.
+func grpcCode(err error) (string, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		method := reflect.ValueOf(err).MethodByName("GRPCStatus")
+		if !method.IsValid() || method.Type().NumIn() != 0 || method.Type().NumOut() != 1 {
+			continue
+		}
+		status := method.Call(nil)[0]
+		code := status.MethodByName("Code")
+		if !code.IsValid() || code.Type().NumIn() != 0 || code.Type().NumOut() != 1 || code.Type().Out(0).Kind() != reflect.Uint32 {
+			continue
+		}
+		if status.Kind() == reflect.Pointer && status.IsNil() {
+			return grpcCodes[0], true // A nil *status.Status means OK.
+		}
+		n := code.Call(nil)[0].Uint()
+		if n < uint64(len(grpcCodes)) {
+			return grpcCodes[n], true
+		}
+		return "Code(" + strconv.FormatUint(n, 10) + ")", true
+	}
+	return "", false
+}
+
+// statusWriter is the http.ResponseWriter returned by TrackResponse.
+type statusWriter struct {
+	http.ResponseWriter
+	rec   *TraceRecord
+	wrote bool
+}
+
+// TrackResponse returns a ResponseWriter that records the status code written through w on the
+// traced call open on the calling goroutine, setting its status to error for 5xx responses.
+// Instrumentation wraps the http.ResponseWriter parameters of instrumented functions with it,
+// unless instrumentation.skipResponseRewrite is set. The writer supports Flush and Hijack when
+// w does, and http.ResponseController through Unwrap.
+//
+// Parameters:
+//   - w (http.ResponseWriter): the writer of a handler.
+//
+// Returns:
+//   - http.ResponseWriter: the tracking writer, or w outside a traced call.
+func TrackResponse(w http.ResponseWriter) http.ResponseWriter {
+	ensureInitialized()
+	if w == nil {
+		return nil
+	}
+	gid := goroutineID()
+	mu.Lock()
+	rec := openSpanOn(gid)
+	mu.Unlock()
+	if rec == nil || rec.dropped {
+		return w
+	}
+	return &statusWriter{ResponseWriter: w, rec: rec}
+}
+
+// record records code on the call as long as it has not returned.
+func (w *statusWriter) record(code int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	mu.Lock()
+	defer mu.Unlock()
+	if !w.rec.exited {
+		recordHTTPStatus(w.rec, code, true)
+	}
+}
+
+// WriteHeader records the status code and writes it. Informational 1xx codes other than 101 are
+// not final and are not recorded.
+func (w *statusWriter) WriteHeader(code int) {
+	if code >= 200 || code == http.StatusSwitchingProtocols {
+		w.record(code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write records the implicit 200 status of a response written without WriteHeader.
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.record(http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush flushes the underlying writer if it supports flushing.
+func (w *statusWriter) Flush() {
+	w.record(http.StatusOK)
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack hijacks the connection of the underlying writer if it supports hijacking.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
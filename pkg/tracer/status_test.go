@@ -0,0 +1,73 @@
+package tracer_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// grpcStatus and grpcError mimic *status.Status and the errors of the gRPC status package.
+type grpcStatus struct{ code uint32 }
+
+func (s *grpcStatus) Code() uint32 { return s.code }
+
+type grpcError struct{ status *grpcStatus }
+
+func (e grpcError) Error() string           { return fmt.Sprintf("rpc error: code = %d", e.status.code) }
+func (e grpcError) GRPCStatus() *grpcStatus { return e.status }
+
+func TestStatusFromHTTPAndGRPCCodes(t *testing.T) {
+	withTracer(t, config.Config{})
+
+	call("serveOK", func() {
+		w := tracer.TrackResponse(httptest.NewRecorder())
+		fmt.Fprintln(w, "hello")
+	})
+	call("serveUnavailable", func() {
+		w := tracer.TrackResponse(httptest.NewRecorder())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("Expected the tracking writer to support flushing")
+		}
+	})
+	call("serveNotFound", func() {
+		http.NotFound(tracer.TrackResponse(httptest.NewRecorder()), httptest.NewRequest("GET", "/", nil))
+	})
+	call("fetchNotFound", func() {
+		tracer.RecordReturn("fetchNotFound", &http.Response{StatusCode: http.StatusNotFound}, nil)
+	})
+	call("rpcUnavailable", func() {
+		tracer.RecordReturn("rpcUnavailable", nil, fmt.Errorf("calling backend: %w", grpcError{&grpcStatus{14}}))
+	})
+	call("plain", nil)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 6 {
+		t.Fatalf("Expected six records, got %d (%v)", len(records), err)
+	}
+	want := map[string]struct {
+		status string
+		http   int
+		grpc   string
+	}{
+		"serveOK":          {tracer.StatusOK, 200, ""},
+		"serveUnavailable": {tracer.StatusError, 503, ""},
+		"serveNotFound":    {tracer.StatusOK, 404, ""},
+		"fetchNotFound":    {tracer.StatusError, 404, ""},
+		"rpcUnavailable":   {tracer.StatusError, 0, "Unavailable"},
+		"plain":            {"", 0, ""},
+	}
+	for _, rec := range records {
+		w := want[rec.FunctionName]
+		if rec.Status != w.status || rec.HTTPStatus != w.http || rec.GRPCCode != w.grpc {
+			t.Errorf("Expected %s to have status %q (HTTP %d, gRPC %q), got %q (HTTP %d, gRPC %q)",
+				rec.FunctionName, w.status, w.http, w.grpc, rec.Status, rec.HTTPStatus, rec.GRPCCode)
+		}
+	}
+}
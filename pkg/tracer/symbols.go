@@ -0,0 +1,128 @@
+package tracer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SymbolsFile is the name of the symbol table written next to trace.jsonl. The records of
+// instrumented functions carry the function's ID and omit its name, which the table resolves.
+const SymbolsFile = "symbols.json"
+
+// SymbolTable maps the function IDs the instrumentation assigns to function names: ID n is the
+// function Names[n-1], and 0 is no ID.
+type SymbolTable struct {
+	Names []string `json:"names"`
+}
+
+// Name returns the name of the function id, or "" if the table has none.
+func (t SymbolTable) Name(id int) string {
+	if id < 1 || id > len(t.Names) {
+		return ""
+	}
+	return t.Names[id-1]
+}
+
+// Resolve sets the function name of rec from its function ID, if the record omits it.
+//
+// Parameters:
+//   - rec (*TraceRecord): the record, as read from a trace file.
+func (t SymbolTable) Resolve(rec *TraceRecord) {
+	if rec.FunctionName == "" {
+		rec.FunctionName = t.Name(rec.FunctionID)
+	}
+}
+
+// ReadSymbols reads a symbol table written by WriteSymbols.
+//
+// Parameters:
+//   - path (string): the file, e.g. "tracewrap/latest/symbols.json".
+//
+// Returns:
+//   - SymbolTable: the table.
+//   - error: an error if the file cannot be read or parsed.
+func ReadSymbols(path string) (SymbolTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SymbolTable{}, err
+	}
+	var t SymbolTable
+	if err := json.Unmarshal(data, &t); err != nil {
+		return SymbolTable{}, fmt.Errorf("invalid symbol table %s: %v", path, err)
+	}
+	return t, nil
+}
+
+// WriteSymbols writes t as JSON to path.
+//
+// Parameters:
+//   - path (string): the output file.
+//   - t (SymbolTable): the table.
+//
+// Returns:
+//   - error: an error if the file cannot be written.
+func WriteSymbols(path string, t SymbolTable) error {
+	if t.Names == nil {
+		t.Names = []string{}
+	}
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// SymbolsFor returns the symbol table next to the trace file at tracePath, or an empty table if
+// there is none, as for traces written before function IDs were introduced.
+//
+// Parameters:
+//   - tracePath (string): the trace file, e.g. "tracewrap/latest/trace.jsonl".
+//
+// Returns:
+//   - SymbolTable: the table.
+func SymbolsFor(tracePath string) SymbolTable {
+	t, _ := ReadSymbols(filepath.Join(filepath.Dir(tracePath), SymbolsFile))
+	return t
+}
+
+// runSymbols holds the names of the function IDs entered during the run, written to the run
+// directory with the records that carry them. Guarded by mu.
+var (
+	runSymbols   SymbolTable
+	symbolsDirty bool // runSymbols has names that were not written yet.
+)
+
+// internSymbol records that id names the function functionName. Callers must hold mu.
+func internSymbol(id int, functionName string) {
+	if id < 1 {
+		return
+	}
+	for len(runSymbols.Names) < id {
+		runSymbols.Names = append(runSymbols.Names, "")
+	}
+	if runSymbols.Names[id-1] != functionName {
+		runSymbols.Names[id-1] = functionName
+		symbolsDirty = true
+	}
+}
+
+// internedName reports whether the record rec may be written without its function name, as its
+// function ID resolves to it in runSymbols. Callers must hold mu.
+func internedName(rec *TraceRecord) bool {
+	return rec.FunctionID > 0 && runSymbols.Name(rec.FunctionID) == rec.FunctionName
+}
+
+// writeRunSymbols writes runSymbols to the run directory if function IDs were entered since it
+// was last written. Callers must hold mu.
+func writeRunSymbols() error {
+	if !symbolsDirty {
+		return nil
+	}
+	if err := WriteSymbols(artifactPath(SymbolsFile), runSymbols); err != nil {
+		return fmt.Errorf("failed to write symbol table: %v", err)
+	}
+	symbolsDirty = false
+	return nil
+}
@@ -0,0 +1,90 @@
+package tracer_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestRecordEntryIDWritesIDsAndSymbols(t *testing.T) {
+	withTracer(t, config.Config{})
+	start := tracer.TakeSnapshot(tracer.MeasureTime)
+	work := tracer.RecordEntryID(2, "work")
+	work.RecordAll(start)
+	renamed := tracer.RecordEntryID(1, "handler")
+	renamed.SetName("GET /users")
+	renamed.RecordAll(start)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+
+	path := filepath.Join("tracewrap", "latest", "trace.jsonl")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read trace: %v", err)
+	}
+	if strings.Contains(string(data), `"functionName":"work"`) || !strings.Contains(string(data), `"functionId":2`) {
+		t.Errorf("Expected the record of work to carry its ID instead of its name, got %s", data)
+	}
+	if !strings.Contains(string(data), `"functionName":"GET /users"`) {
+		t.Errorf("Expected the renamed record to keep its name, got %s", data)
+	}
+	symbols, err := tracer.ReadSymbols(filepath.Join("tracewrap", "latest", tracer.SymbolsFile))
+	if err != nil {
+		t.Fatalf("ReadSymbols returned error: %v", err)
+	}
+	if !reflect.DeepEqual(symbols.Names, []string{"handler", "work"}) {
+		t.Errorf("Expected the symbols of the IDs entered, got %v", symbols.Names)
+	}
+
+	records, err := tracer.ReadTraceFile(path)
+	if err != nil {
+		t.Fatalf("ReadTraceFile returned error: %v", err)
+	}
+	if len(records) != 2 || records[0].FunctionName != "work" || records[1].FunctionName != "GET /users" {
+		t.Errorf("Expected the names to be resolved, got %+v", records)
+	}
+}
+
+func TestSymbolTableResolve(t *testing.T) {
+	table := tracer.SymbolTable{Names: []string{"a", "b"}}
+	for _, tc := range []struct {
+		rec  tracer.TraceRecord
+		want string
+	}{
+		{tracer.TraceRecord{FunctionID: 2}, "b"},
+		{tracer.TraceRecord{FunctionID: 2, FunctionName: "renamed"}, "renamed"},
+		{tracer.TraceRecord{FunctionID: 3}, ""},
+		{tracer.TraceRecord{FunctionName: "plain"}, "plain"},
+	} {
+		table.Resolve(&tc.rec)
+		if tc.rec.FunctionName != tc.want {
+			t.Errorf("Resolve(%+v): got %q, want %q", tc.rec, tc.rec.FunctionName, tc.want)
+		}
+	}
+}
+
+func TestAggregatesKeyedByIDKeepRenamedSpansApart(t *testing.T) {
+	withTracer(t, config.Config{})
+	start := tracer.TakeSnapshot(tracer.MeasureTime)
+	for _, name := range []string{"", "GET /users", ""} {
+		span := tracer.RecordEntryID(1, "handler")
+		span.SetName(name)
+		span.RecordAll(start)
+	}
+	plain := tracer.RecordEntry("handler")
+	plain.RecordAll(start)
+
+	calls := make(map[string]int)
+	for _, fn := range tracer.CurrentStats(10).TopFunctions {
+		calls[fn.Name] = fn.Calls
+	}
+	if !reflect.DeepEqual(calls, map[string]int{"handler": 3, "GET /users": 1}) {
+		t.Errorf("Expected the calls of handler under its ID and name together and the renamed span apart, got %v", calls)
+	}
+}
@@ -0,0 +1,138 @@
+package tracer
+
+import (
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SyscallStats are the system calls and I/O of a call, recorded with tracing.syscallStats. The
+// kernel counts them per thread, and the call's goroutine is locked to its thread while the call
+// runs, so they are the call's own and those of the calls it makes on the same goroutine. I/O done
+// by goroutines the call starts is not included.
+type SyscallStats struct {
+	ReadCalls         int64         `json:"readCalls,omitempty"`         // read(2)-like system calls.
+	WriteCalls        int64         `json:"writeCalls,omitempty"`        // write(2)-like system calls.
+	ReadBytes         int64         `json:"readBytes,omitempty"`         // Bytes read, including from caches, pipes and sockets.
+	WriteBytes        int64         `json:"writeBytes,omitempty"`        // Bytes written, including to pipes and sockets.
+	StorageReadBytes  int64         `json:"storageReadBytes,omitempty"`  // Bytes fetched from storage.
+	StorageWriteBytes int64         `json:"storageWriteBytes,omitempty"` // Bytes sent to storage.
+	SyscallTime       time.Duration `json:"syscallTime,omitempty"`       // CPU time in the kernel, mostly in system calls.
+	BlockIOWait       time.Duration `json:"blockIoWait,omitempty"`       // Time waiting for block I/O, with delay accounting.
+}
+
+// ioCounters are the cumulative I/O counters of a thread.
+type ioCounters struct {
+	thread                int // The thread ID.
+	readCalls, writeCalls int64
+	readChars, writeChars int64
+	readBytes, writeBytes int64
+	systemTime            time.Duration
+	blockIOWait           time.Duration
+}
+
+// syscallsEnabled reports whether tracing.syscallStats is set and supported on this platform.
+func syscallsEnabled() bool {
+	return activeConfig.Tracing.SyscallStats && syscallStatsSupported
+}
+
+// syscallDelta returns the stats between the counters start and end, or nil if nothing changed.
+func syscallDelta(start, end ioCounters) *SyscallStats {
+	stats := SyscallStats{
+		ReadCalls:         end.readCalls - start.readCalls,
+		WriteCalls:        end.writeCalls - start.writeCalls,
+		ReadBytes:         end.readChars - start.readChars,
+		WriteBytes:        end.writeChars - start.writeChars,
+		StorageReadBytes:  end.readBytes - start.readBytes,
+		StorageWriteBytes: end.writeBytes - start.writeBytes,
+		SyscallTime:       end.systemTime - start.systemTime,
+		BlockIOWait:       end.blockIOWait - start.blockIOWait,
+	}
+	if stats == (SyscallStats{}) {
+		return nil
+	}
+	return &stats
+}
+
+// threadState is what the tracer keeps of a thread, by thread ID in threads. Only code running on
+// the thread uses it.
+type threadState struct {
+	locks atomic.Int32 // Open calls that locked the thread with startThreadIO.
+	// The tracer's own system calls on the thread, left out of its counters: the reads of
+	// readThreadCounters and the writes of ownWriter.
+	readCalls, readChars   atomic.Int64
+	writeCalls, writeChars atomic.Int64
+}
+
+var threads sync.Map
+
+// threadStateOf returns the state of the thread with ID thread, creating it on first use.
+func threadStateOf(thread int) *threadState {
+	if st, ok := threads.Load(thread); ok {
+		return st.(*threadState)
+	}
+	st, _ := threads.LoadOrStore(thread, new(threadState))
+	return st.(*threadState)
+}
+
+// startThreadIO locks the goroutine of a call entered with tracing.syscallStats to its thread,
+// which keeps other goroutines off the thread until the call exits, and reads the thread's
+// counters. Nested calls lock it again, as runtime.LockOSThread counts. Called by enterRecord,
+// outside mu.
+//
+// Returns:
+//   - ioCounters: the counters.
+//   - bool: false if they cannot be read, and the thread is not locked.
+func startThreadIO() (ioCounters, bool) {
+	runtime.LockOSThread()
+	c, ok := readThreadCounters()
+	if !ok {
+		runtime.UnlockOSThread()
+		return ioCounters{}, false
+	}
+	threadStateOf(c.thread).locks.Add(1)
+	return c, true
+}
+
+// endThreadIO reads the counters of the calling thread if an open call locked it, for exitRecord,
+// which calls it outside mu before it knows which call exits.
+//
+// Parameters:
+//   - thread (int): the ID of the calling thread.
+//
+// Returns:
+//   - ioCounters: the counters.
+//   - bool: false if no open call locked the thread or the counters cannot be read.
+func endThreadIO(thread int) (ioCounters, bool) {
+	if st, ok := threads.Load(thread); !ok || st.(*threadState).locks.Load() == 0 {
+		return ioCounters{}, false
+	}
+	return readThreadCounters()
+}
+
+// ownWriter writes the tracer's own output, such as its log, and counts the write(2) call as the
+// tracer's with tracing.syscallStats, so the log lines of a call are not its I/O.
+type ownWriter struct {
+	w io.Writer
+}
+
+// Write writes p to the underlying writer.
+func (o ownWriter) Write(p []byte) (int, error) {
+	n, err := o.w.Write(p)
+	if syscallsEnabled() {
+		st := threadStateOf(currentThread())
+		st.writeCalls.Add(1)
+		st.writeChars.Add(int64(n))
+	}
+	return n, err
+}
+
+// releaseThread undoes startThreadIO for a call that exits on the thread it locked. A call that
+// exits on another goroutine, such as a span ended elsewhere, leaves its thread locked until the
+// goroutine that entered it exits.
+func releaseThread(thread int) {
+	threadStateOf(thread).locks.Add(-1)
+	runtime.UnlockOSThread()
+}
@@ -0,0 +1,117 @@
+//go:build linux
+
+package tracer
+
+import (
+	"bytes"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// syscallStatsSupported is true where readThreadCounters can read the counters of a thread.
+const syscallStatsSupported = true
+
+// clockTicksPerSecond is USER_HZ, the unit of the delay accounting fields of /proc/<pid>/stat,
+// which is 100 on every Linux architecture Go supports.
+const clockTicksPerSecond = 100
+
+// rusageThread is RUSAGE_THREAD, which the syscall package does not define.
+const rusageThread = 1
+
+// currentThread returns the ID of the calling thread.
+func currentThread() int {
+	return syscall.Gettid()
+}
+
+// readThreadCounters reads the I/O counters of the calling thread from /proc/thread-self/io, its
+// block I/O delay, field 42 of /proc/thread-self/stat, and its CPU time in the kernel from
+// getrusage(RUSAGE_THREAD). The delay stays zero unless the kernel has delay accounting enabled
+// (delayacct on the kernel command line, or kernel.task_delayacct). The kernel counts the reads of
+// those files as the thread's, so the counters leave out every read made here, and the tracer's
+// log writes (see ownWriter).
+//
+// Returns:
+//   - ioCounters: the counters.
+//   - bool: false if /proc/thread-self/io cannot be read.
+func readThreadCounters() (ioCounters, bool) {
+	c := ioCounters{thread: currentThread()}
+	st := threadStateOf(c.thread)
+	var buf [1024]byte
+	var reads, chars int64
+	data, ok := readProc("/proc/thread-self/io", buf[:], &reads, &chars)
+	if !ok {
+		st.readCalls.Add(reads)
+		st.readChars.Add(chars)
+		return ioCounters{}, false
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		key, value, ok := bytes.Cut(line, []byte(": "))
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(string(bytes.TrimSpace(value)), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch string(key) {
+		case "syscr":
+			c.readCalls = n
+		case "syscw":
+			c.writeCalls = n
+		case "rchar":
+			c.readChars = n
+		case "wchar":
+			c.writeChars = n
+		case "read_bytes":
+			c.readBytes = n
+		case "write_bytes":
+			c.writeBytes = n
+		}
+	}
+	// The counters were read before this function's own reads were counted.
+	c.readCalls -= st.readCalls.Load()
+	c.readChars -= st.readChars.Load()
+	c.writeCalls -= st.writeCalls.Load()
+	c.writeChars -= st.writeChars.Load()
+	if stat, ok := readProc("/proc/thread-self/stat", buf[:], &reads, &chars); ok {
+		// The command name in parentheses may contain spaces; fields are counted after it.
+		if i := bytes.LastIndexByte(stat, ')'); i >= 0 {
+			fields := bytes.Fields(stat[i+1:])
+			// fields[0] is field 3 (state), so field 42 is fields[39].
+			if len(fields) > 39 {
+				if ticks, err := strconv.ParseInt(string(fields[39]), 10, 64); err == nil {
+					c.blockIOWait = time.Duration(ticks) * time.Second / clockTicksPerSecond
+				}
+			}
+		}
+	}
+	st.readCalls.Add(reads)
+	st.readChars.Add(chars)
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(rusageThread, &usage); err == nil {
+		c.systemTime = time.Duration(usage.Stime.Nano())
+	}
+	return c, true
+}
+
+// readProc reads the file at path, which fits in buf, with a single read(2) and adds that call and
+// the bytes it read to reads and chars.
+//
+// Returns:
+//   - []byte: the contents, in buf.
+//   - bool: false if the file cannot be read.
+func readProc(path string, buf []byte, reads, chars *int64) ([]byte, bool) {
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, false
+	}
+	defer syscall.Close(fd)
+	n, err := syscall.Read(fd, buf)
+	*reads++
+	if err != nil || n <= 0 {
+		return nil, false
+	}
+	*chars += int64(n)
+	return buf[:n], true
+}
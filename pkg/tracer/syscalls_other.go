@@ -0,0 +1,16 @@
+//go:build !linux
+
+package tracer
+
+// syscallStatsSupported is false: the per-thread I/O counters are read from Linux's /proc.
+const syscallStatsSupported = false
+
+// currentThread is not supported on this platform.
+func currentThread() int {
+	return 0
+}
+
+// readThreadCounters is not supported on this platform.
+func readThreadCounters() (ioCounters, bool) {
+	return ioCounters{}, false
+}
@@ -0,0 +1,59 @@
+package tracer_test
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestSyscallStatsAttributeIOToTheCall(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("syscall stats are read from /proc on Linux")
+	}
+	if _, err := os.ReadFile("/proc/thread-self/io"); err != nil {
+		t.Skipf("/proc/thread-self/io is not readable: %v", err)
+	}
+	withTracer(t, config.Config{Tracing: config.TracingConfig{SyscallStats: true}})
+
+	data := make([]byte, 64<<10)
+	call("writeFile", func() {
+		if err := os.WriteFile("out.bin", data, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	})
+	call("idle", nil)
+	call("wait", func() {
+		// Another goroutine runs on another thread, so its I/O is not the call's.
+		done := make(chan error)
+		go func() { done <- os.WriteFile("other.bin", data, 0644) }()
+		if err := <-done; err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	})
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Expected three records, got %d (%v)", len(records), err)
+	}
+	for _, rec := range records {
+		switch s := rec.Syscalls; rec.FunctionName {
+		case "writeFile":
+			if s == nil || s.WriteCalls < 1 || s.WriteBytes < int64(len(data)) {
+				t.Errorf("Expected the file's write to be attributed to writeFile, got %+v", s)
+			}
+		case "idle":
+			if s != nil && (s.ReadCalls != 0 || s.WriteCalls != 0) {
+				t.Errorf("Expected no system calls for idle, got %+v", s)
+			}
+		case "wait":
+			if s != nil && s.WriteBytes >= int64(len(data)) {
+				t.Errorf("Expected the other goroutine's write not to be attributed to wait, got %+v", s)
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package tracer
+
+import (
+	"path"
+	"time"
+)
+
+// defaultTailMaxPending is the number of buffered records at which the traces in progress are
+// settled when tracing.tailSampling.maxPending is not set.
+const defaultTailMaxPending = 10000
+
+// Tail sampling state. Records of traces still in progress wait in tailPending, keyed by the ID
+// of the trace's root record, tailPendingRecords of them in all. Traces settled before their root
+// returned keep their decision in tailDecided so their remaining records follow it.
+var (
+	tailPending        = make(map[int64][]*TraceRecord)
+	tailPendingRecords int
+	tailDecided        = make(map[int64]bool)
+	tailKept           int
+	tailDropped        int
+)
+
+// tailSamplingEnabled reports whether tracing.tailSampling is on.
+func tailSamplingEnabled() bool {
+	return activeConfig.Tracing.TailSampling.Enable
+}
+
+// tailMaxPending returns the number of buffered records at which the traces in progress are
+// settled.
+func tailMaxPending() int {
+	if n := activeConfig.Tracing.TailSampling.MaxPending; n > 0 {
+		return n
+	}
+	return defaultTailMaxPending
+}
+
+// traceRootFor returns the root ID of a record entered with the given parent (nil for none): a
+// new trace starts at root calls and at the first traced call on another goroutine.
+func traceRootFor(rec, parent *TraceRecord) int64 {
+	if parent == nil || parent.GoroutineID != rec.GoroutineID {
+		return rec.UniqueID
+	}
+	return parent.rootID
+}
+
+// retainRecord keeps a completed record, or buffers it until its trace can be judged when tail
+// sampling is enabled. Buffering the record that reaches tailMaxPending settles every trace in
+// progress. Callers must hold mu.
+func retainRecord(rec *TraceRecord) {
+	if !tailSamplingEnabled() {
+		traceRecords = append(traceRecords, rec)
+		return
+	}
+	complete := rec.UniqueID == rec.rootID
+	if keep, decided := tailDecided[rec.rootID]; decided {
+		if keep {
+			traceRecords = append(traceRecords, rec)
+		}
+		if complete {
+			delete(tailDecided, rec.rootID)
+		}
+		return
+	}
+	pending := append(tailPending[rec.rootID], rec)
+	if !complete {
+		tailPending[rec.rootID] = pending
+		if tailPendingRecords++; tailPendingRecords >= tailMaxPending() {
+			settleTailSampling()
+		}
+		return
+	}
+	delete(tailPending, rec.rootID)
+	tailPendingRecords -= len(pending) - 1
+	decideTrace(rec.rootID, pending, rec.Duration)
+}
+
+// decideTrace keeps or discards the buffered records of a trace whose root took elapsed.
+// Callers must hold mu.
+func decideTrace(rootID int64, records []*TraceRecord, elapsed time.Duration) bool {
+	keep := keepTrace(records, elapsed)
+	if keep {
+		traceRecords = append(traceRecords, records...)
+		tailKept++
+	} else {
+		tailDropped++
+		logger.Printf("[TRACEWRAP] DEBUG: Tail sampling discarded trace %d (%d records)", rootID, len(records))
+	}
+	return keep
+}
+
+// keepTrace applies the tail sampling policy to the records of one trace.
+func keepTrace(records []*TraceRecord, elapsed time.Duration) bool {
+	ts := activeConfig.Tracing.TailSampling
+	if ts.Latency > 0 && elapsed >= ts.Latency {
+		return true
+	}
+	for _, rec := range records {
+		if rec.returnedError || rec.PanicValue != nil || rec.SLOViolated {
+			return true
+		}
+		for _, rule := range ts.Rules {
+			if ok, _ := path.Match(rule.Function, rec.FunctionName); ok && rec.Duration >= rule.MinDuration {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// settleTailSampling decides the traces still in progress with the records completed so far, so
+// flushes and dumps include them and the buffer stays bounded; the trace's later records follow
+// the same decision. The latency check uses the time elapsed since the root call was entered.
+// Callers must hold mu.
+func settleTailSampling() {
+	if !tailSamplingEnabled() {
+		return
+	}
+	_, now := clockNow()
+	for rootID, records := range tailPending {
+		elapsed := time.Duration(0)
+		for _, open := range callStack {
+			if open.UniqueID == rootID {
+				elapsed = time.Duration(now - open.EntryNanos)
+			}
+		}
+		tailDecided[rootID] = decideTrace(rootID, records, elapsed)
+		delete(tailPending, rootID)
+	}
+	tailPendingRecords = 0
+}
@@ -0,0 +1,100 @@
+package tracer_test
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// failingCall records a call of functionName that returns an error.
+func failingCall(functionName string, nested func()) {
+	start := time.Now()
+	tracer.RecordEntry(functionName)
+	if nested != nil {
+		nested()
+	}
+	tracer.RecordReturn(functionName, errors.New("boom"))
+	tracer.RecordExit(functionName, start)
+}
+
+func TestTailSamplingKeepsOnlyInterestingTraces(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{TailSampling: config.TailSamplingConfig{
+		Enable: true,
+		Rules:  []config.TailRule{{Function: "slow*"}},
+	}}})
+
+	call("boring", func() { call("boringChild", nil) })
+	failingCall("failing", func() { call("failingChild", nil) })
+	call("slowExport", nil)
+
+	// A long-running root: the handler runs on its own goroutine and forms its own trace, and
+	// the root is still open when the trace is flushed.
+	start := time.Now()
+	tracer.RecordEntry("serve")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		failingCall("handler", nil)
+	}()
+	wg.Wait()
+	call("housekeeping", nil)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	tracer.RecordExit("serve", start)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("ReadTraceFile returned error: %v", err)
+	}
+	var got []string
+	for _, rec := range records {
+		got = append(got, rec.FunctionName)
+	}
+	sort.Strings(got)
+	want := []string{"failing", "failingChild", "handler", "slowExport"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected records %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected records %v, got %v", want, got)
+		}
+	}
+	if st := tracer.CurrentStatus(); st.TracesKept != 3 || st.TracesDropped != 2 || st.TracesPending != 0 {
+		t.Errorf("Unexpected trace counts: kept %d, dropped %d, pending %d", st.TracesKept, st.TracesDropped, st.TracesPending)
+	}
+}
+
+func TestTailSamplingSettlesTracesAtMaxPending(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{TailSampling: config.TailSamplingConfig{
+		Enable:     true,
+		MaxPending: 3,
+	}}})
+
+	// A root that never returns: its calls are decided once three of them are buffered.
+	tracer.RecordEntry("serve")
+	call("housekeeping", nil)
+	failingCall("failing", nil)
+	if st := tracer.CurrentStatus(); st.TracesPending != 1 || st.TracesKept != 0 {
+		t.Fatalf("Expected the trace to be buffered, got kept %d, pending %d", st.TracesKept, st.TracesPending)
+	}
+	call("housekeeping", nil)
+	if st := tracer.CurrentStatus(); st.TracesPending != 0 || st.TracesKept != 1 {
+		t.Fatalf("Expected the trace to be settled at three records, got kept %d, pending %d", st.TracesKept, st.TracesPending)
+	}
+	// The trace's later records follow the decision without being buffered.
+	call("housekeeping", nil)
+	if st := tracer.CurrentStatus(); st.TracesPending != 0 {
+		t.Errorf("Expected the settled trace's records not to be buffered, got %d pending", st.TracesPending)
+	}
+}
@@ -0,0 +1,67 @@
+package tracer
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader is the W3C Trace Context header carrying the trace and span of the caller, as
+// sent by OpenTelemetry and most APM agents.
+const TraceparentHeader = "traceparent"
+
+// parseTraceparent returns the trace ID and parent span ID of a W3C traceparent header value,
+// "<version>-<trace-id>-<parent-id>-<flags>", or empty strings if it is not valid. All-zero IDs
+// are invalid.
+func parseTraceparent(value string) (traceID, spanID string) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", ""
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", ""
+	}
+	for _, part := range parts[:4] {
+		if !isLowerHex(part) {
+			return "", ""
+		}
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", ""
+	}
+	return parts[1], parts[2]
+}
+
+// SpanIDOf returns the W3C span ID of the record with the given unique ID, as the OpenTelemetry
+// bridge sends it to the services the call makes requests to.
+func SpanIDOf(uniqueID int64) string {
+	return fmt.Sprintf("%016x", uint64(uniqueID))
+}
+
+// DerivedTraceID returns the W3C trace ID of a trace without an external trace context, as the
+// OpenTelemetry bridge derives it: a hash of the run ID followed by the unique ID of the trace's
+// root record, so IDs are distinct across runs.
+func DerivedTraceID(runID string, root int64) string {
+	sum := sha256.Sum256([]byte(runID))
+	return fmt.Sprintf("%x%016x", sum[:8], uint64(root))
+}
+
+// isLowerHex reports whether s consists of lowercase hexadecimal digits only.
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// adoptTraceContext sets the external trace and span IDs of rec from the traceparent header of
+// req, so the call can be found in the APM holding the caller's trace. In-process callees inherit
+// them when they are entered.
+func adoptTraceContext(rec *TraceRecord, req *http.Request) {
+	if traceID, spanID := parseTraceparent(req.Header.Get(TraceparentHeader)); traceID != "" {
+		rec.ExternalTraceID, rec.ExternalSpanID = traceID, spanID
+	}
+}
@@ -0,0 +1,45 @@
+package tracer_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+func TestRequestsAdoptTheCallersTraceContext(t *testing.T) {
+	withTracer(t, config.Config{})
+
+	serve := func(name, traceparent string) {
+		req := httptest.NewRequest("GET", "/orders", nil)
+		if traceparent != "" {
+			req.Header.Set(tracer.TraceparentHeader, traceparent)
+		}
+		start := time.Now()
+		tracer.RecordEntry(name)
+		tracer.RecordParam("r", req)
+		call(name+"Load", nil)
+		tracer.RecordExit(name, start)
+	}
+	serve("traced", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	serve("untraced", "")
+	serve("invalid", "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil || len(records) != 6 {
+		t.Fatalf("Expected six records, got %d (%v)", len(records), err)
+	}
+	for _, rec := range records {
+		traced := rec.FunctionName == "traced" || rec.FunctionName == "tracedLoad"
+		if traced && (rec.ExternalTraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || rec.ExternalSpanID != "00f067aa0ba902b7") {
+			t.Errorf("Expected %s to carry the caller's trace context, got %q/%q", rec.FunctionName, rec.ExternalTraceID, rec.ExternalSpanID)
+		}
+		if !traced && rec.ExternalTraceID != "" {
+			t.Errorf("Expected no external trace on %s, got %q", rec.FunctionName, rec.ExternalTraceID)
+		}
+	}
+}
@@ -5,14 +5,19 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
+	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/k0kubun/pp"
+	"github.com/mwiater/tracewrap/pkg/notify"
+	"github.com/mwiater/tracewrap/pkg/theme"
 	"github.com/shirou/gopsutil/load"
 	"github.com/shirou/gopsutil/mem"
 	"github.com/shirou/gopsutil/net"
@@ -22,13 +27,17 @@ import (
 // TraceRecord holds detailed trace information for a function call.
 // Fields:
 //
+//	SchemaVersion: Version of the record format (see SchemaVersion).
 //	UniqueID: Unique identifier for the trace record.
-//	FunctionName: Name of the function being traced.
+//	FunctionName: Name of the function being traced; omitted in trace files for records with a FunctionID (see SymbolsFile).
+//	FunctionID: ID of the function in the symbol table of the instrumentation, if it has one.
 //	CallerID: Unique identifier of the caller function, if any.
 //	EntryTime: Timestamp when the function was entered.
 //	ExitTime: Timestamp when the function exited.
-//	Duration: Total execution duration of the function.
+//	EntryNanos, ExitNanos: Monotonic clock readings at entry and exit, in nanoseconds (see Clock).
+//	Duration: Total execution duration of the function, from the monotonic readings.
 //	Params: Map of function parameters and their string representations.
+//	Variadic: Number of arguments passed to each variadic parameter, whose elements are in Params as name[0], name[1], ...
 //	ReturnValues: Slice of string representations of the function's return values.
 //	MemBefore: Memory allocated (in bytes) before function execution.
 //	MemAfter: Memory allocated (in bytes) after function execution.
@@ -42,56 +51,194 @@ import (
 //	HeapFreeDelta: Difference in heap free memory (in bytes).
 //	NetUsageDelta: Difference in network usage (in bytes).
 //	DiskUsageDelta: Difference in disk I/O usage (in bytes).
+//	CPUTime: Process CPU time used during execution.
 //	SystemCPULoad: System CPU load at the time of function exit.
 //	SystemMemUsage: System memory usage at the time of function exit.
+//	Region: Name of the innermost code region open when the function was entered, if any.
+//	GoroutineID: ID of the goroutine the function ran on.
+//	Labels: pprof labels carried by the function's context.Context parameter, if any.
+//	BlockedTime, BlockEvents: Time spent blocked (channels, select, sync.Cond, ...) while the function was running, from the block profile.
+//	MutexWaitTime, MutexEvents: Time spent waiting for contended mutexes while the function was running, from the mutex profile.
+//	ErrorChain: Unwrap chain of the first non-nil error returned, when tracing.captureErrorChains is set.
+//	TypedParams: Parameters of basic kinds (numbers, strings, bools) with their types preserved.
+//	TypedReturns: Return values of basic kinds, aligned with ReturnValues (nil for other values).
+//	ParamSizes, ReturnSizes: Length, and capacity of slices, of slice, map and string values, with tracing.capture.sizes.
+//	PointerIDs: Hashed identity of the object each pointer parameter points to, with tracing.capture.pointerIds.
+//	EntryStack: Stack the call was made from, innermost frame first, for the functions in tracing.entryStacks.
+//	ViaUninstrumented: True when code that is not instrumented lies between the caller (CallerID) and the call, or calls it without a caller.
+//	Route: Route served by the call, for functions with an *http.Request parameter.
+//	SLOTarget, SLOBudget: The tracing.slos entry that applies to the call and its latency budget.
+//	SLOViolated: True when the call took longer than its budget.
+//	Events: Timestamped events added inside the call with Event.
+//	ChildCount: Number of direct calls made by the call that returned before it did.
+//	Callees: Calls and total time of those direct calls, per callee function.
+//	RecursionDepth: Number of enclosing calls of the same function still running when the call started.
+//	Recursion: "direct" or "mutual" for recursive calls (see RecursionDepth), empty otherwise.
+//	ExternalTraceID, ExternalSpanID: W3C trace context of the request served, from its traceparent header.
+//	ExportedSpanID: Span ID of the call in a wrapped OpenTelemetry SDK (see pkg/otelbridge).
+//	Syscalls: The read and write system calls of the call's thread, their bytes, kernel time and block I/O wait (Linux).
+//	FDDelta: Change in the number of open file descriptors of the process during the call.
+//	Children: CPU time and peak memory of the child processes the call ran (see RunCommand).
+//	Status: "ok" or "error" for calls that served or made an HTTP request or returned a gRPC status (see TrackResponse).
+//	HTTPStatus, GRPCCode: The HTTP status code or gRPC code the status was derived from.
 type TraceRecord struct {
-	UniqueID        int64             `json:"uniqueId"`
-	FunctionName    string            `json:"functionName"`
-	CallerID        int64             `json:"callerId,omitempty"`
-	EntryTime       time.Time         `json:"entryTime"`
-	ExitTime        time.Time         `json:"exitTime"`
-	Duration        time.Duration     `json:"duration"`
-	Params          map[string]string `json:"params,omitempty"`
-	ReturnValues    []string          `json:"returnValues,omitempty"`
-	MemBefore       uint64            `json:"memBefore"`
-	MemAfter        uint64            `json:"memAfter"`
-	MemDiff         uint64            `json:"memDiff"`
-	PanicValue      interface{}       `json:"panicValue,omitempty"`
-	StackTrace      string            `json:"stackTrace,omitempty"`
-	GoroutinesDelta int               `json:"goroutinesDelta,omitempty"`
-	ThreadsDelta    int64             `json:"threadsDelta,omitempty"`
-	GCCountDelta    uint32            `json:"gcCountDelta,omitempty"`
-	HeapAllocDelta  int64             `json:"heapAllocDelta,omitempty"`
-	HeapFreeDelta   int64             `json:"heapFreeDelta,omitempty"`
-	NetUsageDelta   int64             `json:"netUsageDelta,omitempty"`
-	DiskUsageDelta  int64             `json:"diskUsageDelta,omitempty"`
-	SystemCPULoad   float64           `json:"systemCpuLoad,omitempty"`
-	SystemMemUsage  uint64            `json:"systemMemUsage,omitempty"`
+	SchemaVersion     int                    `json:"schemaVersion"`
+	UniqueID          int64                  `json:"uniqueId"`
+	FunctionName      string                 `json:"functionName,omitempty"`
+	FunctionID        int                    `json:"functionId,omitempty"`
+	CallerID          int64                  `json:"callerId,omitempty"`
+	EntryTime         time.Time              `json:"entryTime"`
+	ExitTime          time.Time              `json:"exitTime"`
+	EntryNanos        int64                  `json:"entryNanos,omitempty"`
+	ExitNanos         int64                  `json:"exitNanos,omitempty"`
+	Duration          time.Duration          `json:"duration"`
+	Params            map[string]string      `json:"params,omitempty"`
+	Variadic          map[string]int         `json:"variadic,omitempty"`
+	ReturnValues      []string               `json:"returnValues,omitempty"`
+	MemBefore         uint64                 `json:"memBefore"`
+	MemAfter          uint64                 `json:"memAfter"`
+	MemDiff           uint64                 `json:"memDiff"`
+	PanicValue        interface{}            `json:"panicValue,omitempty"`
+	StackTrace        string                 `json:"stackTrace,omitempty"`
+	GoroutinesDelta   int                    `json:"goroutinesDelta,omitempty"`
+	ThreadsDelta      int64                  `json:"threadsDelta,omitempty"`
+	GCCountDelta      uint32                 `json:"gcCountDelta,omitempty"`
+	HeapAllocDelta    int64                  `json:"heapAllocDelta,omitempty"`
+	HeapFreeDelta     int64                  `json:"heapFreeDelta,omitempty"`
+	NetUsageDelta     int64                  `json:"netUsageDelta,omitempty"`
+	DiskUsageDelta    int64                  `json:"diskUsageDelta,omitempty"`
+	CPUTime           time.Duration          `json:"cpuTime,omitempty"`
+	SystemCPULoad     float64                `json:"systemCpuLoad,omitempty"`
+	SystemMemUsage    uint64                 `json:"systemMemUsage,omitempty"`
+	Region            string                 `json:"region,omitempty"`
+	GoroutineID       int64                  `json:"goroutineId,omitempty"`
+	Labels            map[string]string      `json:"labels,omitempty"`
+	BlockedTime       time.Duration          `json:"blockedTime,omitempty"`
+	BlockEvents       int64                  `json:"blockEvents,omitempty"`
+	MutexWaitTime     time.Duration          `json:"mutexWaitTime,omitempty"`
+	MutexEvents       int64                  `json:"mutexEvents,omitempty"`
+	ErrorChain        []ErrorLink            `json:"errorChain,omitempty"`
+	TypedParams       map[string]Primitive   `json:"typedParams,omitempty"`
+	TypedReturns      []*Primitive           `json:"typedReturns,omitempty"`
+	ParamSizes        map[string]Size        `json:"paramSizes,omitempty"`
+	ReturnSizes       []*Size                `json:"returnSizes,omitempty"`
+	PointerIDs        map[string]string      `json:"pointerIds,omitempty"`
+	EntryStack        []StackFrame           `json:"entryStack,omitempty"`
+	ViaUninstrumented bool                   `json:"viaUninstrumented,omitempty"`
+	Route             string                 `json:"route,omitempty"`
+	SLOTarget         string                 `json:"sloTarget,omitempty"`
+	SLOBudget         time.Duration          `json:"sloBudget,omitempty"`
+	SLOViolated       bool                   `json:"sloViolated,omitempty"`
+	Events            []SpanEvent            `json:"events,omitempty"`
+	ChildCount        int                    `json:"childCount,omitempty"`
+	Callees           map[string]CalleeStats `json:"callees,omitempty"`
+	RecursionDepth    int                    `json:"recursionDepth,omitempty"`
+	Recursion         string                 `json:"recursion,omitempty"`
+	// ExternalTraceID and ExternalSpanID are the W3C trace ID and parent span ID of the request
+	// the call served, from its traceparent header, inherited by the calls it made.
+	ExternalTraceID string `json:"externalTraceId,omitempty"`
+	ExternalSpanID  string `json:"externalSpanId,omitempty"`
+	// ExportedSpanID is the span ID a wrapped OpenTelemetry SDK exported the call with, which the
+	// services it calls receive as their parent span ID. Empty unless the bridge wraps an SDK.
+	ExportedSpanID string `json:"exportedSpanId,omitempty"`
+	// Syscalls are the system calls and I/O of the call's thread during the call, with
+	// tracing.syscallStats.
+	Syscalls *SyscallStats `json:"syscalls,omitempty"`
+	// FDDelta is the change in open file descriptors of the process between the call's entry and
+	// exit, with tracing.trackFileDescriptors.
+	FDDelta int `json:"fdDelta,omitempty"`
+	// Children sums the child processes the call ran through the command wrappers.
+	Children *ChildUsage `json:"children,omitempty"`
+	// Status is StatusOK or StatusError, from HTTPStatus or GRPCCode, and empty for other calls.
+	Status     string `json:"status,omitempty"`
+	HTTPStatus int    `json:"httpStatus,omitempty"`
+	GRPCCode   string `json:"grpcCode,omitempty"`
+	// TracerOverhead is the time spent in the tracer's hooks for the call: its entry, parameters,
+	// results and exit.
+	TracerOverhead time.Duration `json:"tracerOverhead,omitempty"`
+
+	callerName    string        // Function name of the caller, used for aggregated call graph edges.
+	callerKey     int           // Aggregate key of the caller (see functionKey), with callerName.
+	renamed       bool          // The span of the call was renamed with SetName.
+	variadicArgs  int           // Params holding arguments of variadic parameters, e.g. "args[0]".
+	funcName      string        // Runtime name of the function, e.g. "main.(*Server).handle", for callBoundary.
+	dropped       bool          // True when the call is excluded from recording (recording stopped or sampled out).
+	level         captureLevel  // Capture level of the function when the call was entered.
+	overhead      time.Duration // Time spent in tracer hooks for this call.
+	blockStart    contentionCounts
+	mutexStart    contentionCounts
+	ioStart       ioCounters
+	ioStartOK     bool                   // ioStart was read.
+	fdStart       int                    // Open file descriptors at entry, -1 if not counted.
+	rawParams     map[string]interface{} // Parameter values awaiting rendering, with tracing.capture.lazy.
+	rawReturns    []interface{}          // Return values awaiting rendering, with tracing.capture.lazy.
+	rootID        int64                  // Unique ID of the record that started this call's trace (see tracing.tailSampling).
+	returnedError bool                   // True when the call returned a non-nil error.
+	parent        *TraceRecord           // The open caller, until this call returns.
+	pooled        bool                   // The record is from recordPool and recycled when the call exits (see tracing.light).
+	exited        bool                   // The call has exited; the record is no longer written to.
+}
+
+// CalleeStats summarizes the direct calls from one record to one callee function.
+type CalleeStats struct {
+	Calls    int           `json:"calls"`
+	Duration time.Duration `json:"duration"`
 }
 
 // Global variables used for tracing and logging.
 var (
-	traceRecords  []*TraceRecord         // Aggregated trace records.
-	callStack     []*TraceRecord         // Stack of active trace records.
-	uniqueID      int64                  // Atomic counter for generating unique IDs.
-	mu            sync.Mutex             // Mutex for synchronizing access to global variables.
-	logger        *log.Logger            // Logger for trace messages.
-	execFrequency = make(map[string]int) // Map tracking execution frequency of functions.
+	traceRecords  []*TraceRecord // Aggregated trace records.
+	callStack     []*TraceRecord // Stack of active trace records.
+	uniqueID      int64          // Atomic counter for generating unique IDs.
+	mu            sync.Mutex     // Mutex for synchronizing access to global variables.
+	logger        *log.Logger    // Logger for trace messages.
+	execFrequency callCounts     // Execution counts of functions, guarded by their own shard locks rather than mu.
+	initOnce      sync.Once      // Guards the lazy initialization performed by initialize.
 )
 
-// init initializes the tracer package by creating necessary directories and setting up the logger.
-// It creates the "tracewrap" directory and opens the log file "tracewrap/tracewrap.log" for logging.
-func init() {
-	if err := os.MkdirAll("tracewrap", 0755); err != nil {
+// ensureInitialized performs the tracer's one-time setup on first use.
+// Setup is deferred until the first record (rather than running in package init) so that
+// instrumented functions invoked from other packages' init functions, which may run before
+// or concurrently with any tracer-side initialization, always find a usable logger.
+func ensureInitialized() {
+	initOnce.Do(initialize)
+}
+
+// initialize loads the embedded configuration, creates the necessary directories and sets up the logger.
+// It creates the run's artifact directory (see ArtifactRoot) and opens its log file tracewrap.log for logging,
+// mirrored to stdout only with logging.mirrorStdout set.
+func initialize() {
+	loadSettings()
+	initRecordingState()
+	initRegionState()
+	initContentionProfiling()
+	if err := initRunDirectory(); err != nil {
 		log.Println("Error creating log directory:", err)
 	}
-	logFile, err := os.OpenFile("tracewrap/tracewrap.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_TRUNC, 0644)
-	if err != nil {
+	logFile, err := os.OpenFile(artifactPath("tracewrap.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_TRUNC, 0644)
+	// The application's stdout is left alone unless logging.mirrorStdout asks for the tracer's
+	// lines there too; without a log file they go to stderr instead.
+	switch {
+	case err != nil && activeConfig.Logging.MirrorStdout:
 		log.Println("Error opening log file:", err)
-		logger = log.New(os.Stdout, "", log.LstdFlags)
-	} else {
-		mw := io.MultiWriter(os.Stdout, logFile)
-		logger = log.New(mw, "", log.LstdFlags)
+		logger = newLogger(ownWriter{os.Stdout})
+	case err != nil:
+		log.Println("Error opening log file:", err)
+		logger = newLogger(ownWriter{os.Stderr})
+	case activeConfig.Logging.MirrorStdout:
+		logger = newLogger(io.MultiWriter(ownWriter{os.Stdout}, ownWriter{logFile}))
+	default:
+		logger = newLogger(ownWriter{logFile})
+	}
+	initProfiling()
+	if err := os.Remove(traceFilePath()); err != nil && !os.IsNotExist(err) {
+		logger.Println("[TRACEWRAP] Error removing previous trace file:", err)
+	}
+	if addr := activeConfig.Tracing.Control.Listen; addr != "" {
+		startControlServer(addr)
+	}
+	if interval := activeConfig.Tracing.PersistInterval; interval > 0 {
+		startPersister(interval)
 	}
 }
 
@@ -103,103 +250,515 @@ func readMem() uint64 {
 }
 
 // RecordEntry creates a new TraceRecord for a function call and pushes it onto the call stack.
-// It records the function name, entry time, initial memory usage, and assigns a unique ID.
+// It records the function name, entry time, initial memory usage, and assigns a unique ID. The
+// call is a call of the innermost call open on its goroutine, or, for the first traced call on a
+// goroutine, of the innermost call still open on the goroutine that started it.
+// Calls made while recording is stopped, or whose root call was sampled out, are tracked on the
+// stack but produce no log lines or retained records. The time spent in the tracer's hooks is
+// counted towards the call's overhead (see tracing.maxOverheadPercent). With tracing.light set
+// the call takes the fast path of enterLight and only reaches the aggregates.
 // Parameters:
 //   - functionName (string): the name of the function being entered.
-func RecordEntry(functionName string) {
+//
+// Returns:
+//   - Span: the call, which the hooks of its return, panic and exit are called on, so they reach
+//     its record even when other calls are open above it on the stack, as they are when defers
+//     run out of order or calls on other goroutines interleave. It is returned by value, so the
+//     instrumented function keeps it in a variable of its own and nothing is allocated for it.
+func RecordEntry(functionName string) Span {
+	return enterCall(0, functionName)
+}
+
+// RecordEntryID is RecordEntry for a function the instrumentation assigned an ID in its symbol
+// table. The record carries the ID, and trace files store it instead of the name.
+// Parameters:
+//   - functionID (int): the ID of the function, from 1.
+//   - functionName (string): the name of the function being entered, which the ID stands for.
+//
+// Returns:
+//   - Span: the call, as for RecordEntry.
+func RecordEntryID(functionID int, functionName string) Span {
+	return enterCall(functionID, functionName)
+}
+
+// enterCall enters a call of an instrumented function and returns its span.
+func enterCall(functionID int, functionName string) Span {
+	ensureInitialized()
+	if lightMode() {
+		return enterLight(functionID, functionName, nil, true)
+	}
+	return enterRecord(functionID, functionName, nil, syscallsEnabled())
+}
+
+// enterRecord is RecordEntry for a call of parent, or of the caller found as RecordEntry finds it
+// if parent is nil, and returns the call's span. functionID is the function's symbol ID, or 0. A
+// parent that has already ended is the call's caller by the IDs it keeps, and the call starts a
+// trace of its own, as calls that outlive their caller on other goroutines do. With threadIO, for
+// tracing.syscallStats, the goroutine stays locked to its thread until the call exits.
+func enterRecord(functionID int, functionName string, parent *Span, threadIO bool) Span {
+	ensureInitialized()
+	hookStart := time.Now()
+	var blockStart, mutexStart contentionCounts
+	if blockingEnabled() {
+		blockStart = contentionFor(functionName, runtime.BlockProfile)
+	}
+	if mutexEnabled() {
+		mutexStart = contentionFor(functionName, runtime.MutexProfile)
+	}
+	var ioStart ioCounters
+	ioStartOK := false
+	if threadIO {
+		ioStart, ioStartOK = startThreadIO()
+	}
+	fdStart := -1
+	if fdsTracked(functionName) {
+		if n, ok := countFDs(); ok {
+			fdStart = n
+		}
+	}
+	entryStack := entryStackFor(functionName)
+	gid := goroutineID()
+	// The stack is compared with the innermost open call of the goroutine, which enterRecord checks
+	// is still the call's caller once it holds mu.
+	var open openSpanIDs
+	ids, hasOpen := currentSpans.Load(gid)
+	if hasOpen {
+		open = ids.(openSpanIDs)
+	}
+	var funcName string
+	var via bool
+	switch {
+	case hasOpen && (parent == nil || parent.id == open.span):
+		funcName, via = callBoundary(open.funcName, false)
+	case hasOpen || parent != nil:
+		funcName, _ = callBoundary("", false)
+	default:
+		funcName, via = callBoundary("", true)
+	}
+	entryTime, entryNanos := clockNow()
 	mu.Lock()
 	defer mu.Unlock()
 	id := atomic.AddInt64(&uniqueID, 1)
 	record := &TraceRecord{
-		UniqueID:     id,
-		FunctionName: functionName,
-		EntryTime:    time.Now(),
-		MemBefore:    readMem(),
-		Params:       make(map[string]string),
+		SchemaVersion: SchemaVersion,
+		UniqueID:      id,
+		FunctionName:  functionName,
+		FunctionID:    functionID,
+		EntryTime:     entryTime,
+		EntryNanos:    entryNanos,
+		MemBefore:     readMem(),
+		Region:        currentRegion(),
+		GoroutineID:   gid,
+		EntryStack:    entryStack,
+		level:         captureLevelFor(functionName),
+		blockStart:    blockStart,
+		mutexStart:    mutexStart,
+		ioStart:       ioStart,
+		ioStartOK:     ioStartOK,
+		fdStart:       fdStart,
+		funcName:      funcName,
 	}
-	if len(callStack) > 0 {
-		record.CallerID = callStack[len(callStack)-1].UniqueID
+	internSymbol(functionID, functionName)
+	var caller *TraceRecord
+	if parent != nil {
+		caller = parent.open()
+	} else {
+		caller = callerOn(gid)
+	}
+	if caller != nil {
+		record.CallerID = caller.UniqueID
+		record.callerName = caller.FunctionName
+		record.callerKey = caller.functionKey()
+		record.parent = caller
+		record.ExternalTraceID, record.ExternalSpanID = caller.ExternalTraceID, caller.ExternalSpanID
+		record.ViaUninstrumented = via && hasOpen && caller.UniqueID == open.span
+		markRecursion(record)
+		// Children follow their parent's decision so retained traces stay complete.
+		record.dropped = caller.dropped || !recording.Load()
+		record.rootID = traceRootFor(record, caller)
+	} else if parent != nil {
+		record.CallerID = parent.id
+		record.callerName = parent.name
+		record.callerKey = parent.key
+		record.ExternalTraceID, record.ExternalSpanID = parent.traceID, parent.spanID
+		record.dropped = parent.dropped || !recording.Load()
+		record.rootID = record.UniqueID
+	} else {
+		record.ViaUninstrumented = via && !hasOpen
+		record.dropped = !shouldRecordRoot()
+		record.rootID = traceRootFor(record, nil)
+	}
+	if sampledOut(functionName) {
+		record.dropped = true
 	}
 	callStack = append(callStack, record)
-	logger.Println("[TRACEWRAP] Entering", functionName, "ID:", id)
+	pushGoroutineCall(record)
+	defer func() { record.overhead += time.Since(hookStart) }()
+	if !record.dropped {
+		logf(functionName, "[TRACEWRAP] Entering %s ID: %d", functionName, id)
+	}
+	return spanOf(record)
 }
 
 // RecordParam records a parameter value for the current function call.
-// It logs the parameter and stores its string representation in the current TraceRecord.
+// It logs the parameter and stores its string representation, rendered according to
+// tracing.capture, in the current TraceRecord. With tracing.capture.lazy set the raw value is
+// kept instead and rendered when the record is flushed or dumped; nothing is logged.
 // Parameters:
 //   - paramName (string): the name of the parameter.
 //   - value (interface{}): the value of the parameter.
 func RecordParam(paramName string, value interface{}) {
+	ensureInitialized()
+	hookStart := time.Now()
 	mu.Lock()
 	defer mu.Unlock()
 	if len(callStack) > 0 {
-		top := callStack[len(callStack)-1]
-		top.Params[paramName] = fmt.Sprintf("%+v", value)
+		recordParam(callStack[len(callStack)-1], paramName, value, hookStart)
+		return
+	}
+	logger.Printf("[TRACEWRAP] Parameter %s = %s", paramName, formatValue(value))
+}
+
+// recordParam is RecordParam for the call rec, whose hook started at hookStart. Callers must
+// hold mu.
+func recordParam(rec *TraceRecord, paramName string, value interface{}, hookStart time.Time) {
+	defer func() { rec.overhead += time.Since(hookStart) }()
+	if rec.dropped {
+		return
+	}
+	if req, ok := value.(*http.Request); ok && req != nil {
+		rec.Route = requestRoute(req)
+		adoptTraceContext(rec, req)
+	}
+	if rec.level >= levelReduced || paramCapped(rec, paramName) {
+		return
+	}
+	storeParam(rec, paramName, value)
+}
+
+// recordVariadic records the arguments values passed to the variadic parameter paramName of the
+// call rec, whose hook started at hookStart: their number in rec.Variadic, and the first
+// tracing.capture.maxVariadicArgs of them as the parameters paramName[0], paramName[1], and so on.
+// Elements of function, channel and unsafe pointer types are recorded by type name, as Opaque
+// values are. With tracing.capture.raceSafe set the elements are not read, and values is
+// recorded as one parameter. Callers must hold mu.
+func recordVariadic(rec *TraceRecord, paramName string, values interface{}, hookStart time.Time) {
+	v := reflect.ValueOf(values)
+	if v.Kind() != reflect.Slice || activeConfig.Tracing.Capture.RaceSafe {
+		recordParam(rec, paramName, values, hookStart)
+		if v.Kind() == reflect.Slice && !rec.dropped {
+			setVariadicCount(rec, paramName, v.Len())
+		}
+		return
+	}
+	defer func() { rec.overhead += time.Since(hookStart) }()
+	if rec.dropped || rec.level >= levelReduced || paramCapped(rec, paramName) {
+		return
+	}
+	setVariadicCount(rec, paramName, v.Len())
+	var opaque Opaque
+	switch v.Type().Elem().Kind() {
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		opaque = Opaque(v.Type().Elem().String())
+	}
+	for i := range variadicArgLimit(v.Len(), &activeConfig.Tracing.Capture) {
+		var elem interface{} = opaque
+		if opaque == "" {
+			elem = v.Index(i).Interface()
+		}
+		key := fmt.Sprintf("%s[%d]", paramName, i)
+		if !hasParam(rec, key) {
+			rec.variadicArgs++
+		}
+		storeParam(rec, key, elem)
 	}
-	logger.Printf("[TRACEWRAP] Parameter %s = %+v", paramName, value)
+}
+
+// setVariadicCount records that n arguments were passed to the variadic parameter paramName of
+// rec. Callers must hold mu.
+func setVariadicCount(rec *TraceRecord, paramName string, n int) {
+	if rec.Variadic == nil {
+		rec.Variadic = make(map[string]int)
+	}
+	rec.Variadic[paramName] = n
+}
+
+// storeParam stores the value of the parameter paramName on rec: rendered and logged, or raw with
+// tracing.capture.lazy set, its size with tracing.capture.sizes and its pointer ID with
+// tracing.capture.pointerIds. Callers must hold mu.
+func storeParam(rec *TraceRecord, paramName string, value interface{}) {
+	recordParamSize(rec, paramName, value)
+	recordPointerID(rec, paramName, value)
+	if activeConfig.Tracing.Capture.Lazy {
+		if rec.rawParams == nil {
+			rec.rawParams = make(map[string]interface{})
+		}
+		rec.rawParams[paramName] = value
+		return
+	}
+	formatted := formatValue(value)
+	if rec.Params == nil {
+		rec.Params = make(map[string]string)
+	}
+	rec.Params[paramName] = formatted
+	recordTypedParam(rec, paramName, value)
+	logf(rec.FunctionName, "[TRACEWRAP] Parameter %s = %s", paramName, formatted)
 }
 
 // RecordReturn logs and records return values for the current function call.
-// It appends the string representations of the return values to the current TraceRecord.
+// It appends the string representations of the return values to the current TraceRecord and,
+// with tracing.captureErrorChains set, the unwrap chain of the first non-nil error returned.
+// With tracing.capture.lazy set the raw values are kept and rendered later, as in RecordParam.
+// A returned *http.Response or gRPC status error also sets the status of the call.
 // Parameters:
 //   - functionName (string): the name of the function returning.
 //   - returns (...interface{}): variadic return values.
 func RecordReturn(functionName string, returns ...interface{}) {
+	ensureInitialized()
+	hookStart := time.Now()
 	mu.Lock()
 	defer mu.Unlock()
+	var top *TraceRecord
 	if len(callStack) > 0 {
-		top := callStack[len(callStack)-1]
+		top = callStack[len(callStack)-1]
+	}
+	recordReturn(top, functionName, returns, hookStart)
+}
+
+// recordReturn is RecordReturn for the call rec, or for no call if rec is nil, whose hook started
+// at hookStart. Callers must hold mu.
+func recordReturn(rec *TraceRecord, functionName string, returns []interface{}, hookStart time.Time) {
+	if rec != nil {
+		defer func() { rec.overhead += time.Since(hookStart) }()
+		if rec.dropped {
+			return
+		}
 		for _, ret := range returns {
-			top.ReturnValues = append(top.ReturnValues, fmt.Sprintf("%+v", ret))
+			recordReturnStatus(rec, ret)
+		}
+		if rec.level >= levelReduced {
+			return
+		}
+		// Errors returned past tracing.capture.maxReturns still mark the call as failed.
+		for _, ret := range returns {
+			if err, ok := ret.(error); ok && err != nil {
+				rec.returnedError = true
+				if rec.ErrorChain == nil && captureErrorChains() {
+					rec.ErrorChain = errorChain(err)
+				}
+			}
+		}
+		returns = cappedReturns(rec, returns)
+		recordReturnSizes(rec, len(rec.ReturnValues)+len(rec.rawReturns), returns)
+		if activeConfig.Tracing.Capture.Lazy {
+			rec.rawReturns = append(rec.rawReturns, returns...)
+			return
 		}
 	}
-	logger.Printf("[TRACEWRAP] Function %s returning %+v", functionName, returns)
+	formatted := make([]string, len(returns))
+	for i, ret := range returns {
+		formatted[i] = formatValue(ret)
+	}
+	if rec != nil {
+		rec.ReturnValues = append(rec.ReturnValues, formatted...)
+		recordTypedReturns(rec, returns)
+	}
+	logf(functionName, "[TRACEWRAP] Function %s returning [%s]", functionName, strings.Join(formatted, " "))
 }
 
 // RecordExit finalizes the current TraceRecord by capturing the exit time, computing the duration,
 // measuring memory usage difference, and capturing system-level metrics.
-// It then logs the function exit and aggregates the record.
+// It then logs the function exit and aggregates the record. System metrics are skipped for
+// functions downgraded by tracing.maxOverheadPercent.
 // Parameters:
 //   - functionName (string): the name of the function exiting.
 //   - startTime (time.Time): the start time of the function call.
 func RecordExit(functionName string, startTime time.Time) {
+	exitRecord(functionName, nil, Metrics{}, false)
+}
+
+// popRecord removes target from the call stack, or the innermost call if target is nil, and
+// returns it; nil if the stack is empty or target has already exited. Callers must hold mu.
+func popRecord(target *TraceRecord) *TraceRecord {
+	for i := len(callStack) - 1; i >= 0; i-- {
+		if rec := callStack[i]; target == nil || rec == target {
+			callStack = append(callStack[:i], callStack[i+1:]...)
+			popGoroutineCall(rec)
+			rec.exited = true
+			return rec
+		}
+	}
+	return nil
+}
+
+// exitRecord is RecordExit for the call of span, or the innermost open call if span is nil,
+// recording the metrics m on its record (see EndSpan). With count, the call is counted in the
+// execution counts, as RecordExecutionFrequency does. A span is ended in the same critical section
+// as its call exits, and has no effect if it has already ended.
+func exitRecord(functionName string, span *Span, m Metrics, count bool) {
+	ensureInitialized()
+	if lightMode() {
+		exitLight(functionName, span, count)
+		return
+	}
+	hookStart := time.Now()
+	var blockEnd, mutexEnd contentionCounts
+	if blockingEnabled() {
+		blockEnd = contentionFor(functionName, runtime.BlockProfile)
+	}
+	if mutexEnabled() {
+		mutexEnd = contentionFor(functionName, runtime.MutexProfile)
+	}
+	var ioEnd ioCounters
+	ioEndOK, thread := false, -1
+	if syscallsEnabled() {
+		thread = currentThread()
+		ioEnd, ioEndOK = endThreadIO(thread)
+	}
+	fdEnd, fdEndOK := 0, false
+	if fdsTracked(functionName) {
+		fdEnd, fdEndOK = countFDs()
+	}
+	calls := 0
+	if count && span == nil {
+		calls = execFrequency.add(functionName)
+	}
 	mu.Lock()
-	defer mu.Unlock()
-	if len(callStack) > 0 {
-		top := callStack[len(callStack)-1]
-		callStack = callStack[:len(callStack)-1]
-		top.ExitTime = time.Now()
-		top.Duration = top.ExitTime.Sub(top.EntryTime)
-		top.MemAfter = readMem()
-		if top.MemAfter > top.MemBefore {
-			top.MemDiff = top.MemAfter - top.MemBefore
-		} else {
-			top.MemDiff = 0
+	var target *TraceRecord
+	if span != nil {
+		if target = span.open(); target == nil {
+			mu.Unlock()
+			return
+		}
+		span.ended = true
+		// Counted only once it is known to end here, so a span ended twice counts once.
+		if count {
+			calls = execFrequency.add(functionName)
 		}
-		top.SystemCPULoad = GetSystemCPULoad()
-		top.SystemMemUsage = GetSystemMemUsage()
-		traceRecords = append(traceRecords, top)
-		logger.Printf("[TRACEWRAP] Exiting %s, ID: %d, Duration: %v, MemDiff: %d bytes", functionName, top.UniqueID, top.Duration, top.MemDiff)
-		logger.Printf("[TRACEWRAP] DEBUG: Total trace records now: %d", len(traceRecords))
-		logger.Printf("[TRACEWRAP] DEBUG: System CPU Load: %f, System Mem Usage: %d bytes", top.SystemCPULoad, top.SystemMemUsage)
 	}
+	top := popRecord(target)
+	if top == nil {
+		mu.Unlock()
+		return
+	}
+	top.ExitTime, top.ExitNanos = clockNow()
+	top.Duration = time.Duration(top.ExitNanos - top.EntryNanos)
+	applyMetrics(top, m)
+	if blockingEnabled() {
+		top.BlockedTime = cyclesToDuration(blockEnd.cycles - top.blockStart.cycles)
+		top.BlockEvents = blockEnd.events - top.blockStart.events
+	}
+	if mutexEnabled() {
+		top.MutexWaitTime = cyclesToDuration(mutexEnd.cycles - top.mutexStart.cycles)
+		top.MutexEvents = mutexEnd.events - top.mutexStart.events
+	}
+	if top.ioStartOK && top.ioStart.thread == thread {
+		if ioEndOK {
+			top.Syscalls = syscallDelta(top.ioStart, ioEnd)
+		}
+		releaseThread(thread)
+	}
+	if top.fdStart >= 0 && fdEndOK {
+		top.FDDelta = fdEnd - top.fdStart
+	}
+	top.MemAfter = readMem()
+	if top.MemAfter > top.MemBefore {
+		top.MemDiff = top.MemAfter - top.MemBefore
+	} else {
+		top.MemDiff = 0
+	}
+	observeDuration(top.FunctionName, top.Duration)
+	addToCaller(top)
+	if !top.dropped {
+		checkSLO(top)
+		rememberRecent(top)
+		if top.level == levelFull {
+			top.SystemCPULoad = GetSystemCPULoad()
+			top.SystemMemUsage = GetSystemMemUsage()
+		}
+		retainRecord(top)
+		if allowLog(functionName) {
+			if metrics := formatMetrics(m); count && metrics != "" {
+				logger.Printf("[TRACEWRAP] Function %s Calls: %d, %s", functionName, calls, metrics)
+			} else if count {
+				logger.Printf("[TRACEWRAP] Function %s Calls: %d", functionName, calls)
+			} else if metrics != "" {
+				logger.Printf("[TRACEWRAP] Function %s Metrics - %s", functionName, metrics)
+			}
+			logger.Printf("[TRACEWRAP] Exiting %s, ID: %d, Duration: %v, MemDiff: %d bytes", functionName, top.UniqueID, top.Duration, top.MemDiff)
+			logger.Printf("[TRACEWRAP] DEBUG: Total trace records now: %d", len(traceRecords))
+			logger.Printf("[TRACEWRAP] DEBUG: System CPU Load: %f, System Mem Usage: %d bytes", top.SystemCPULoad, top.SystemMemUsage)
+		}
+	}
+	top.overhead += time.Since(hookStart)
+	top.TracerOverhead = top.overhead
+	accountOverhead(top)
+	// The record is complete, so it may be spilled.
+	sample := sampleOf(top)
+	if !top.dropped {
+		enforceMemoryCap()
+	}
+	mu.Unlock()
+	// The aggregates have locks of their own, so the call is folded into them once mu is
+	// released, from the copy taken while it was held.
+	funcAggregates.add(sample)
+}
+
+// addToCaller counts rec in its caller's ChildCount and Callees, if the caller is still running.
+// Records that outlive their caller, such as calls on goroutines the caller started, and the
+// calls of callers that are not recorded are not counted. Callers must hold mu.
+func addToCaller(rec *TraceRecord) {
+	parent := rec.openParent()
+	rec.parent = nil
+	if parent == nil || parent.dropped || !parent.ExitTime.IsZero() {
+		return
+	}
+	if parent.Callees == nil {
+		parent.Callees = make(map[string]CalleeStats)
+	}
+	stats := parent.Callees[rec.FunctionName]
+	stats.Calls++
+	stats.Duration += rec.Duration
+	parent.Callees[rec.FunctionName] = stats
+	parent.ChildCount++
 }
 
 // RecordPanic records panic information for the current function call.
 // It updates the current TraceRecord with the panic value and the associated stack trace, and logs the panic.
+// With tracing.panicBundle set, a post-mortem bundle is written (see PanicBundle), and with
+// tracing.panicWebhook set, the panic is posted to the webhook (see panicNotification).
 // Parameters:
 //   - functionName (string): the name of the function where a panic occurred.
 //   - panicValue (interface{}): the value recovered from the panic.
 //   - stack (string): the stack trace captured at the time of panic.
 func RecordPanic(functionName string, panicValue interface{}, stack string) {
+	ensureInitialized()
 	mu.Lock()
-	defer mu.Unlock()
+	var top *TraceRecord
 	if len(callStack) > 0 {
-		top := callStack[len(callStack)-1]
-		top.PanicValue = panicValue
-		top.StackTrace = stack
+		top = callStack[len(callStack)-1]
+	}
+	msg, notify := recordPanic(top, functionName, panicValue, stack)
+	mu.Unlock()
+	// Sent before the panic resumes, as it usually ends the process.
+	if notify {
+		sendPanicNotification(msg)
+	}
+}
+
+// recordPanic is RecordPanic for the call rec, or for no call if rec is nil, and returns the
+// webhook notification to send once mu is released, if any. Callers must hold mu.
+func recordPanic(rec *TraceRecord, functionName string, panicValue interface{}, stack string) (notify.Message, bool) {
+	if rec != nil {
+		if rec.dropped {
+			return notify.Message{}, false
+		}
+		rec.PanicValue = panicValue
+		rec.StackTrace = stack
 	}
 	logger.Printf("[TRACEWRAP] Panic in %s: %+v\nStackTrace:\n%s", functionName, panicValue, stack)
+	writePanicBundle(functionName, panicValue, stack)
+	return panicNotification(functionName, panicValue, stack)
 }
 
 // RecordGoroutineUsage records the change in goroutine count for the current function call.
@@ -207,14 +766,10 @@ func RecordPanic(functionName string, panicValue interface{}, stack string) {
 // Parameters:
 //   - functionName (string): the name of the function.
 //   - delta (int): the change in the number of goroutines.
+//
+// Deprecated: record the call's resource usage with EndSpan.
 func RecordGoroutineUsage(functionName string, delta int) {
-	mu.Lock()
-	defer mu.Unlock()
-	if len(callStack) > 0 {
-		top := callStack[len(callStack)-1]
-		top.GoroutinesDelta = delta
-	}
-	logger.Printf("[TRACEWRAP] Function %s Goroutines Spawned: %d", functionName, delta)
+	updateMetrics(functionName, func(m *Metrics) { m.Goroutines = delta })
 }
 
 // RecordThreadUsage records the change in OS thread usage (using cgo call count as a proxy) for the current function call.
@@ -222,14 +777,10 @@ func RecordGoroutineUsage(functionName string, delta int) {
 // Parameters:
 //   - functionName (string): the name of the function.
 //   - delta (int64): the change in thread usage.
+//
+// Deprecated: record the call's resource usage with EndSpan.
 func RecordThreadUsage(functionName string, delta int64) {
-	mu.Lock()
-	defer mu.Unlock()
-	if len(callStack) > 0 {
-		top := callStack[len(callStack)-1]
-		top.ThreadsDelta = delta
-	}
-	logger.Printf("[TRACEWRAP] Function %s Additional OS Threads Used: %d", functionName, delta)
+	updateMetrics(functionName, func(m *Metrics) { m.CgoCalls = delta })
 }
 
 // RecordGCActivity records the change in garbage collection cycles during the function execution.
@@ -237,14 +788,10 @@ func RecordThreadUsage(functionName string, delta int64) {
 // Parameters:
 //   - functionName (string): the name of the function.
 //   - delta (uint32): the change in the number of GC cycles.
+//
+// Deprecated: record the call's resource usage with EndSpan.
 func RecordGCActivity(functionName string, delta uint32) {
-	mu.Lock()
-	defer mu.Unlock()
-	if len(callStack) > 0 {
-		top := callStack[len(callStack)-1]
-		top.GCCountDelta = delta
-	}
-	logger.Printf("[TRACEWRAP] Function %s GC Runs: %d", functionName, delta)
+	updateMetrics(functionName, func(m *Metrics) { m.GCCycles = delta })
 }
 
 // RecordHeapUsage records the change in heap allocation for the current function call.
@@ -253,15 +800,10 @@ func RecordGCActivity(functionName string, delta uint32) {
 //   - functionName (string): the name of the function.
 //   - heapAllocDelta (int64): the difference in heap allocation (in bytes).
 //   - heapFreeDelta (int64): the difference in heap free memory (in bytes).
+//
+// Deprecated: record the call's resource usage with EndSpan.
 func RecordHeapUsage(functionName string, heapAllocDelta, heapFreeDelta int64) {
-	mu.Lock()
-	defer mu.Unlock()
-	if len(callStack) > 0 {
-		top := callStack[len(callStack)-1]
-		top.HeapAllocDelta = heapAllocDelta
-		top.HeapFreeDelta = heapFreeDelta
-	}
-	logger.Printf("[TRACEWRAP] Function %s Heap Allocated Delta: %d, Heap Freed Delta: %d", functionName, heapAllocDelta, heapFreeDelta)
+	updateMetrics(functionName, func(m *Metrics) { m.HeapAlloc, m.HeapFreed = heapAllocDelta, heapFreeDelta })
 }
 
 // RecordIOUsage records the changes in network and disk I/O usage for the current function call.
@@ -270,64 +812,163 @@ func RecordHeapUsage(functionName string, heapAllocDelta, heapFreeDelta int64) {
 //   - functionName (string): the name of the function.
 //   - netUsageDelta (int64): the change in network usage (in bytes).
 //   - diskUsageDelta (int64): the change in disk I/O usage (in bytes).
+//
+// Deprecated: record the call's resource usage with EndSpan.
 func RecordIOUsage(functionName string, netUsageDelta, diskUsageDelta int64) {
-	mu.Lock()
-	defer mu.Unlock()
-	if len(callStack) > 0 {
-		top := callStack[len(callStack)-1]
-		top.NetUsageDelta = netUsageDelta
-		top.DiskUsageDelta = diskUsageDelta
-	}
-	logger.Printf("[TRACEWRAP] Function %s Network Usage Delta: %d, Disk I/O Delta: %d", functionName, netUsageDelta, diskUsageDelta)
+	updateMetrics(functionName, func(m *Metrics) { m.NetBytes, m.DiskBytes = netUsageDelta, diskUsageDelta })
+}
+
+// currentCallDropped reports whether the innermost open call is excluded from recording.
+// Callers must hold mu.
+func currentCallDropped() bool {
+	return len(callStack) > 0 && callStack[len(callStack)-1].dropped
 }
 
 // RecordExecutionFrequency increments and logs the execution counter for a function.
 // Parameters:
 //   - functionName (string): the name of the function.
 func RecordExecutionFrequency(functionName string) {
+	ensureInitialized()
+	count := execFrequency.add(functionName)
 	mu.Lock()
-	execFrequency[functionName]++
-	count := execFrequency[functionName]
+	dropped := currentCallDropped()
 	mu.Unlock()
-	logger.Printf("[TRACEWRAP] Function %s Calls: %d", functionName, count)
+	if dropped {
+		return
+	}
+	logf(functionName, "[TRACEWRAP] Function %s Calls: %d", functionName, count)
+}
+
+// frequencySnapshot copies the execution counters, or returns nil if no call was counted.
+func frequencySnapshot() map[string]int {
+	return execFrequency.snapshot()
 }
 
-// RecordResourceUsage logs the CPU time difference and heap allocation difference for a function execution.
+// RecordResourceUsage records the CPU time difference and heap allocation difference for the current function call.
 // Parameters:
 //   - functionName (string): the name of the function.
 //   - cpuTimeDiff (time.Duration): the difference in CPU time.
 //   - heapAllocDiff (int64): the difference in heap allocation (in bytes).
+//
+// Deprecated: record the call's resource usage with EndSpan.
 func RecordResourceUsage(functionName string, cpuTimeDiff time.Duration, heapAllocDiff int64) {
-	logger.Printf("[TRACEWRAP] Function %s Resource Usage - CPU Time: %v, HeapAlloc Diff: %d", functionName, cpuTimeDiff, heapAllocDiff)
+	updateMetrics(functionName, func(m *Metrics) { m.CPUTime, m.HeapAlloc = cpuTimeDiff, heapAllocDiff })
 }
 
 // DumpCallGraphDOT generates a DOT graph representation of the call graph using the collected trace records,
-// and writes it to the specified output file.
+// and writes it to the specified output file. Once records have been spilled to disk because of
+// tracing.maxRecordsInMemory, the graph is built from the per-function aggregates instead, with one
+// node per function and edges labelled with call counts. Calls made through code that is not
+// instrumented are drawn dashed, with a "via uninstrumented code" tooltip. Colors come from
+// visualization.theme. A legend of the styles used and a footer with the RunMetadata.StableSummary
+// of the run close the graph; the footer leaves out the time and duration of the run, so the same
+// calls give the same file. Nodes appear in unique-ID order, parameters sorted by name, and
+// aggregated nodes and edges sorted by function name.
+//
 // Parameters:
 //   - outputFile (string): the path to the output DOT file.
 //
 // Returns:
 //   - error: an error if file writing fails, or nil on success.
 func DumpCallGraphDOT(outputFile string) error {
+	ensureInitialized()
 	mu.Lock()
 	defer mu.Unlock()
 
+	palette := activePalette()
 	var sb strings.Builder
 	sb.WriteString("digraph CallGraph {\n")
-	sb.WriteString("  node [shape=box, style=filled, color=\"lightblue\"];\n")
+	sb.WriteString(palette.DOTDefaults())
+
+	if spilledCount > 0 {
+		writeAggregatedDOT(&sb, palette)
+		return writeDOTFile(outputFile, sb.String())
+	}
 
 	logger.Printf("[TRACEWRAP] DEBUG: Generating DOT with %d trace records", len(traceRecords))
 	maxlabelLength := 40
 
-	for _, rec := range traceRecords {
+	// Nodes are written in ID order (IDs follow call entry order) and parameters by name, so
+	// the same execution always produces the same file.
+	settleTailSampling()
+	materializeRecords(traceRecords)
+	records := make([]*TraceRecord, len(traceRecords))
+	copy(records, traceRecords)
+	sort.Slice(records, func(i, j int) bool { return records[i].UniqueID < records[j].UniqueID })
+
+	// Without collapsing, every record represents itself.
+	reps := make(map[int64]int64, len(records))
+	for _, rec := range records {
+		reps[rec.UniqueID] = rec.UniqueID
+	}
+	var chains map[int64]recursionChain
+	if activeConfig.Visualization.CollapseRecursion {
+		reps, chains = collapseRecursion(records)
+	}
+
+	var legend legendSet
+	legend.add(LegendEntry{Symbol: LegendSolid, Text: "call"})
+	for _, rec := range records {
+		if reps[rec.UniqueID] != rec.UniqueID {
+			continue
+		}
+		title := rec.FunctionName
+		if chain, ok := chains[rec.UniqueID]; ok {
+			title = chain.label(rec.FunctionName)
+		}
 		var labelBuilder strings.Builder
-		fmt.Fprintf(&labelBuilder, "%s\\nID: %d\\nDuration: %v\\nMemDiff: %d bytes", rec.FunctionName, rec.UniqueID, rec.Duration, rec.MemDiff)
+		fmt.Fprintf(&labelBuilder, "%s\\nID: %d\\nDuration: %v\\nMemDiff: %d bytes", title, rec.UniqueID, rec.Duration, rec.MemDiff)
+		if rec.GoroutineID != 0 {
+			fmt.Fprintf(&labelBuilder, "\\nGoroutine: %d", rec.GoroutineID)
+		}
+		if rec.Route != "" {
+			fmt.Fprintf(&labelBuilder, "\\nRoute: %s", escapeDOT(rec.Route))
+		}
+		if rec.SLOViolated {
+			fmt.Fprintf(&labelBuilder, "\\nSLO exceeded: budget %v", rec.SLOBudget)
+		}
+		if rec.Status != "" {
+			fmt.Fprintf(&labelBuilder, "\\nStatus: %s", statusLabel(rec))
+		}
+		if rec.BlockEvents > 0 {
+			fmt.Fprintf(&labelBuilder, "\\nBlocked: %v (%d events)", rec.BlockedTime, rec.BlockEvents)
+		}
+		if rec.MutexEvents > 0 {
+			fmt.Fprintf(&labelBuilder, "\\nMutexWait: %v (%d events)", rec.MutexWaitTime, rec.MutexEvents)
+		}
+		if len(rec.ErrorChain) > 1 {
+			labelBuilder.WriteString("\\nError chain:")
+			for _, link := range rec.ErrorChain {
+				fmt.Fprintf(&labelBuilder, "\\n  %s%s", strings.Repeat("  ", link.Depth), escapeDOT(link.Type))
+			}
+		}
+		if len(rec.Events) > 0 {
+			names := make([]string, len(rec.Events))
+			for i, ev := range rec.Events {
+				names[i] = ev.Name
+			}
+			joined := strings.Join(names, ", ")
+			fmt.Fprintf(&labelBuilder, "\\nEvents: %s", escapeDOT(joined[:min(len(joined), maxlabelLength)]))
+		}
+		if rec.ChildCount > 0 {
+			fmt.Fprintf(&labelBuilder, "\\nChildren: %d", rec.ChildCount)
+		}
+		if len(rec.Labels) > 0 {
+			labelBuilder.WriteString("\\nLabels: ")
+			labelBuilder.WriteString(escapeDOT(sortedPairs(rec.Labels)))
+		}
 		if rec.SystemCPULoad != 0 || rec.SystemMemUsage != 0 {
 			fmt.Fprintf(&labelBuilder, "\\nSysLoad: %.2f, SysMem: %d bytes", rec.SystemCPULoad, rec.SystemMemUsage)
 		}
 		if len(rec.Params) > 0 {
 			labelBuilder.WriteString("\\nParams:")
-			for k, v := range rec.Params {
+			keys := make([]string, 0, len(rec.Params))
+			for k := range rec.Params {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				v := rec.Params[k]
 				escapedValue := strings.ReplaceAll(v, "\\", "\\\\")
 				escapedValue = strings.ReplaceAll(escapedValue, "\"", "\\\"")
 				fmt.Fprintf(&labelBuilder, "\\n  %s = %s...", k, escapedValue[:min(len(escapedValue), maxlabelLength)])
@@ -342,17 +983,167 @@ func DumpCallGraphDOT(outputFile string) error {
 			}
 		}
 		nodeLabel := labelBuilder.String()
+		if rec.SLOViolated {
+			legend.add(LegendEntry{Symbol: LegendFill, Color: palette.SLO, Text: "call over its SLO budget"})
+			sb.WriteString(fmt.Sprintf("  %d [label=\"%s\", color=%q];\n", rec.UniqueID, nodeLabel, palette.SLO))
+			continue
+		}
+		if rec.Status == StatusError {
+			legend.add(LegendEntry{Symbol: LegendFill, Color: palette.Error, Text: "failed call"})
+			sb.WriteString(fmt.Sprintf("  %d [label=\"%s\", color=%q];\n", rec.UniqueID, nodeLabel, palette.Error))
+			continue
+		}
 		sb.WriteString(fmt.Sprintf("  %d [label=\"%s\"];\n", rec.UniqueID, nodeLabel))
 	}
 
-	for _, rec := range traceRecords {
-		if rec.CallerID != 0 {
-			sb.WriteString(fmt.Sprintf("  %d -> %d;\n", rec.CallerID, rec.UniqueID))
+	drawn := make(map[[2]int64]bool)
+	for _, rec := range records {
+		if rec.CallerID == 0 {
+			continue
+		}
+		from, to := rec.CallerID, reps[rec.UniqueID]
+		if rep, ok := reps[from]; ok {
+			from = rep
+		}
+		edge := [2]int64{from, to}
+		if from == to || drawn[edge] {
+			continue
 		}
+		drawn[edge] = true
+		if rec.Recursion == RecursionMutual && to != rec.UniqueID {
+			// The call closes a cycle back to the outermost frame of its function.
+			legend.add(LegendEntry{Symbol: LegendDashed, Text: "call closing a recursion cycle"})
+			sb.WriteString(fmt.Sprintf("  %d -> %d [style=dashed];\n", from, to))
+			continue
+		}
+		if rec.ViaUninstrumented {
+			legend.add(LegendEntry{Symbol: LegendDashed, Color: palette.Uninstrumented, Text: "call via uninstrumented code"})
+			sb.WriteString(fmt.Sprintf("  %d -> %d [style=dashed, color=%q, tooltip=\"via uninstrumented code\"];\n", from, to, palette.Uninstrumented))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("  %d -> %d;\n", from, to))
 	}
 
+	sb.WriteString(DOTLegend(legend.entries))
+	sb.WriteString(DOTFooter([]string{currentRunMetadata().StableSummary()}))
+	sb.WriteString("}\n")
+	return writeDOTFile(outputFile, sb.String())
+}
+
+// sortedPairs formats m as "k1=v1, k2=v2" with keys in sorted order.
+func sortedPairs(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+m[k])
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// escapeDOT escapes backslashes and double quotes for use inside a quoted DOT label.
+func escapeDOT(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	return strings.ReplaceAll(s, "\"", "\\\"")
+}
+
+// writeAggregatedDOT writes one node per function and one edge per caller/callee pair from the
+// in-memory aggregates in the colors of palette, closing the graph. Calls made through code that
+// is not instrumented are drawn dashed, and those of functions without a caller from such code
+// come from a node of its own. Callers must hold mu.
+func writeAggregatedDOT(sb *strings.Builder, palette theme.Palette) {
+	funcAggregates, edgeAggregates := funcAggregates.functions(), funcAggregates.callEdges()
+	logger.Printf("[TRACEWRAP] DEBUG: Generating aggregated DOT for %d functions (%d records spilled)", len(funcAggregates), spilledCount)
+	ids := make(map[string]int, len(funcAggregates))
+	names := make([]string, 0, len(funcAggregates))
+	addName := func(name string) {
+		if _, ok := ids[name]; !ok {
+			ids[name] = 0
+			names = append(names, name)
+		}
+	}
+	for name := range funcAggregates {
+		addName(name)
+	}
+	edges := make([]callEdge, 0, len(edgeAggregates))
+	fromUninstrumented := false
+	for edge := range edgeAggregates {
+		// Callers still running (such as main) have no aggregate yet but keep their edges.
+		if edge.Caller != "" {
+			addName(edge.Caller)
+		} else {
+			fromUninstrumented = true
+		}
+		edges = append(edges, edge)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		ids[name] = i + 1
+		agg, ok := funcAggregates[name]
+		if !ok {
+			fmt.Fprintf(sb, "  %d [label=\"%s\"];\n", i+1, name)
+			continue
+		}
+		avg := agg.Total / time.Duration(agg.Calls)
+		if agg.MaxRecursionDepth > 0 {
+			// Recursive functions show their recursion in the label instead of a self edge.
+			chain := recursionChain{folded: agg.Calls - 1, maxDepth: agg.MaxRecursionDepth + 1}
+			fmt.Fprintf(sb, "  %d [label=\"%s\\nTotal: %v\\nAvg: %v\\nMemDiff: %d bytes\"];\n", i+1, chain.label(name), agg.Total, avg, agg.MemDiff)
+			continue
+		}
+		if agg.Errors > 0 {
+			fmt.Fprintf(sb, "  %d [label=\"%s\\nCalls: %d\\nErrors: %d\\nTotal: %v\\nAvg: %v\\nMemDiff: %d bytes\", color=%q];\n", i+1, name, agg.Calls, agg.Errors, agg.Total, avg, agg.MemDiff, palette.Error)
+			continue
+		}
+		fmt.Fprintf(sb, "  %d [label=\"%s\\nCalls: %d\\nTotal: %v\\nAvg: %v\\nMemDiff: %d bytes\"];\n", i+1, name, agg.Calls, agg.Total, avg, agg.MemDiff)
+	}
+	if fromUninstrumented {
+		// Calls without a caller made from code that is not instrumented come from a node of their own.
+		fmt.Fprintf(sb, "  0 [label=\"uninstrumented code\", style=dashed, color=%q];\n", palette.Uninstrumented)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Caller != edges[j].Caller {
+			return edges[i].Caller < edges[j].Caller
+		}
+		return edges[i].Callee < edges[j].Callee
+	})
+	uninstrumented := false
+	for _, edge := range edges {
+		if edge.Caller == edge.Callee {
+			continue
+		}
+		from, to, c := ids[edge.Caller], ids[edge.Callee], edgeAggregates[edge]
+		switch {
+		case c.uninstrumented == c.calls:
+			uninstrumented = true
+			fmt.Fprintf(sb, "  %d -> %d [label=\"%d via uninstrumented code\", style=dashed, color=%q];\n", from, to, c.calls, palette.Uninstrumented)
+		case c.uninstrumented > 0:
+			fmt.Fprintf(sb, "  %d -> %d [label=\"%d calls (%d via uninstrumented code)\"];\n", from, to, c.calls, c.uninstrumented)
+		default:
+			fmt.Fprintf(sb, "  %d -> %d [label=\"%d calls\"];\n", from, to, c.calls)
+		}
+	}
+	legend := []LegendEntry{{Symbol: LegendSolid, Text: "calls between two functions"}}
+	if uninstrumented {
+		legend = append(legend, LegendEntry{Symbol: LegendDashed, Color: palette.Uninstrumented, Text: "calls via uninstrumented code"})
+	}
+	for _, agg := range funcAggregates {
+		if agg.Errors > 0 {
+			legend = append(legend, LegendEntry{Symbol: LegendFill, Color: palette.Error, Text: "function with failed calls"})
+			break
+		}
+	}
+	sb.WriteString(DOTLegend(legend))
+	sb.WriteString(DOTFooter([]string{currentRunMetadata().StableSummary()}))
 	sb.WriteString("}\n")
-	err := os.WriteFile(outputFile, []byte(sb.String()), 0644)
+}
+
+// writeDOTFile writes the DOT source to outputFile and logs its location.
+func writeDOTFile(outputFile, dot string) error {
+	err := os.WriteFile(outputFile, []byte(dot), 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write DOT file: %v", err)
 	}
@@ -360,21 +1151,45 @@ func DumpCallGraphDOT(outputFile string) error {
 	return nil
 }
 
-// DumpTrace marshals the aggregated trace records into JSON format and logs the output.
+// DumpTrace marshals the aggregated trace records, the per-function latency histograms and the
+// execution frequency of each function into JSON format and logs the output.
 func DumpTrace() {
+	ensureInitialized()
 	mu.Lock()
 	defer mu.Unlock()
+	settleTailSampling()
+	materializeRecords(traceRecords)
 	jsonBytes, err := json.MarshalIndent(traceRecords, "", "  ")
 	if err != nil {
 		logger.Println("[TRACEWRAP] Error marshalling trace records:", err)
 		return
 	}
 	logger.Println("[TRACEWRAP] Aggregated Trace Data:")
+	if spilledCount > 0 {
+		logger.Printf("[TRACEWRAP] %d earlier records were spilled to %s", spilledCount, traceFilePath())
+	}
 	logger.Println(string(jsonBytes))
+	histBytes, err := json.MarshalIndent(histogramSummaries(), "", "  ")
+	if err != nil {
+		logger.Println("[TRACEWRAP] Error marshalling latency histograms:", err)
+		return
+	}
+	logger.Println("[TRACEWRAP] Latency Histograms:")
+	logger.Println(string(histBytes))
+	freqBytes, err := json.MarshalIndent(frequencySnapshot(), "", "  ")
+	if err != nil {
+		logger.Println("[TRACEWRAP] Error marshalling execution frequencies:", err)
+		return
+	}
+	logger.Println("[TRACEWRAP] Execution Frequency:")
+	logger.Println(string(freqBytes))
 }
 
 // DumpTracePretty prints the aggregated trace records in a human-readable format using pretty-printing.
 func DumpTracePretty() {
+	mu.Lock()
+	materializeRecords(traceRecords)
+	mu.Unlock()
 	pp.Println(traceRecords)
 }
 
@@ -397,6 +1212,7 @@ func min(a, b int) int {
 // Returns:
 //   - int64: the total network usage in bytes.
 func GetNetworkUsage() int64 {
+	ensureInitialized()
 	counters, err := net.IOCounters(false)
 	if err != nil {
 		logger.Println("[TRACEWRAP] Error retrieving network counters:", err)
@@ -414,6 +1230,7 @@ func GetNetworkUsage() int64 {
 // Returns:
 //   - int64: the total disk I/O usage in bytes.
 func GetDiskUsage() int64 {
+	ensureInitialized()
 	proc, err := process.NewProcess(int32(os.Getpid()))
 	if err != nil {
 		logger.Println("[TRACEWRAP] Error getting current process:", err)
@@ -432,6 +1249,7 @@ func GetDiskUsage() int64 {
 // Returns:
 //   - float64: the 1‑minute load average, or 0.0 if an error occurs.
 func GetSystemCPULoad() float64 {
+	ensureInitialized()
 	avg, err := load.Avg()
 	if err != nil {
 		logger.Println("[TRACEWRAP] Error retrieving system load average:", err)
@@ -445,6 +1263,7 @@ func GetSystemCPULoad() float64 {
 // Returns:
 //   - uint64: the used system memory in bytes.
 func GetSystemMemUsage() uint64 {
+	ensureInitialized()
 	vm, err := mem.VirtualMemory()
 	if err != nil {
 		logger.Println("[TRACEWRAP] Error retrieving virtual memory info:", err)
@@ -458,6 +1277,7 @@ func GetSystemMemUsage() uint64 {
 // Returns:
 //   - time.Duration: the total CPU time used, or 0 if an error occurs.
 func GetProcessCPUTime() time.Duration {
+	ensureInitialized()
 	proc, err := process.NewProcess(int32(os.Getpid()))
 	if err != nil {
 		logger.Println("[TRACEWRAP] Error getting current process:", err)
@@ -0,0 +1,297 @@
+package tracer_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwiater/tracewrap/config"
+	"github.com/mwiater/tracewrap/pkg/tracer"
+)
+
+// withTracer runs the test from a temporary working directory, where the tracer writes its
+// artifacts, with the tracer reset to the given configuration.
+func withTracer(t testing.TB, cfg config.Config) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "tracertest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	})
+	tracer.ResetForTest(cfg)
+	return dir
+}
+
+// call records a complete call of functionName nested under whatever call is currently open.
+func call(functionName string, nested func()) {
+	start := time.Now()
+	tracer.RecordEntry(functionName)
+	if nested != nil {
+		nested()
+	}
+	tracer.RecordExit(functionName, start)
+}
+
+// countLines returns the number of lines in the file at path.
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+	n := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		n++
+	}
+	return n
+}
+
+func TestSpillToDiskKeepsAggregatedCallGraph(t *testing.T) {
+	withTracer(t, config.Config{Tracing: config.TracingConfig{MaxRecordsInMemory: 3}})
+
+	call("main", func() {
+		for i := 0; i < 10; i++ {
+			call("worker", nil)
+		}
+	})
+
+	if err := tracer.DumpCallGraphDOT("tracewrap/latest/callgraph.dot"); err != nil {
+		t.Fatalf("DumpCallGraphDOT failed: %v", err)
+	}
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	if n := countLines(t, "tracewrap/latest/trace.jsonl"); n != 11 {
+		t.Errorf("Expected all 11 records in the trace file, got %d", n)
+	}
+	dot, err := os.ReadFile("tracewrap/latest/callgraph.dot")
+	if err != nil {
+		t.Fatalf("Failed to read DOT file: %v", err)
+	}
+	if !strings.Contains(string(dot), "worker\\nCalls: 10") {
+		t.Errorf("Expected aggregated worker node; DOT: %s", dot)
+	}
+	// The helper's function literal lies between the calls, so they are via uninstrumented code.
+	if !strings.Contains(string(dot), `1 -> 2 [label="10 via uninstrumented code"`) {
+		t.Errorf("Expected aggregated main -> worker edge; DOT: %s", dot)
+	}
+}
+
+func TestFlushWritesEachRecordOnce(t *testing.T) {
+	withTracer(t, config.Config{})
+
+	call("first", nil)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	call("second", nil)
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	if n := countLines(t, "tracewrap/latest/trace.jsonl"); n != 2 {
+		t.Errorf("Expected 2 records in the trace file, got %d", n)
+	}
+}
+
+func TestDumpCallGraphDOTIsOrdered(t *testing.T) {
+	withTracer(t, config.Config{})
+
+	call("parent", func() {
+		start := time.Now()
+		tracer.RecordEntry("child")
+		tracer.RecordParam("zeta", 1)
+		tracer.RecordParam("alpha", 2)
+		tracer.RecordParam("mid", 3)
+		tracer.RecordExit("child", start)
+	})
+	if err := tracer.DumpCallGraphDOT("callgraph.dot"); err != nil {
+		t.Fatalf("DumpCallGraphDOT returned error: %v", err)
+	}
+	data, err := os.ReadFile("callgraph.dot")
+	if err != nil {
+		t.Fatalf("Failed to read DOT file: %v", err)
+	}
+	dot := string(data)
+
+	// The parent exits last but was entered first, so its node comes first.
+	if strings.Index(dot, `1 [label="parent`) > strings.Index(dot, `2 [label="child`) {
+		t.Errorf("Expected nodes in ID order; got:\n%s", dot)
+	}
+	alpha, mid, zeta := strings.Index(dot, "alpha ="), strings.Index(dot, "mid ="), strings.Index(dot, "zeta =")
+	if alpha < 0 || !(alpha < mid && mid < zeta) {
+		t.Errorf("Expected parameters sorted by name; got:\n%s", dot)
+	}
+}
+
+func TestDumpCallGraphDOTHasLegendAndStableFooter(t *testing.T) {
+	withTracer(t, config.Config{})
+	call("parent", func() { call("child", nil) })
+	if err := tracer.DumpCallGraphDOT("callgraph.dot"); err != nil {
+		t.Fatalf("DumpCallGraphDOT returned error: %v", err)
+	}
+	data, err := os.ReadFile("callgraph.dot")
+	if err != nil {
+		t.Fatalf("Failed to read DOT file: %v", err)
+	}
+	dot := string(data)
+	if !strings.Contains(dot, `"tracewrap:legend"`) || !strings.Contains(dot, ">call</td>") || strings.Contains(dot, "failed call") {
+		t.Errorf("Expected a legend of the plain call edges only, got:\n%s", dot)
+	}
+	if !strings.Contains(dot, `label="tracer.test, 2 calls, tracewrap `) || !strings.Contains(dot, `labelloc="b"`) || strings.Contains(dot, "run of") {
+		t.Errorf("Expected a footer without the time of the run, got:\n%s", dot)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := tracer.DumpCallGraphDOT("again.dot"); err != nil {
+		t.Fatalf("DumpCallGraphDOT returned error: %v", err)
+	}
+	if again, err := os.ReadFile("again.dot"); err != nil || string(again) != dot {
+		t.Errorf("Expected the same file for the same calls, got %v:\n%s", err, again)
+	}
+}
+
+func TestRunMetadataSummary(t *testing.T) {
+	started := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+	meta := tracer.RunMetadata{
+		TracewrapVersion: "v1.2.3",
+		Args:             []string{"/srv/bin/server", "-port", "8080"},
+		StartedAt:        started,
+		EndedAt:          started.Add(1250 * time.Millisecond),
+		Records:          3,
+		ExecFrequency:    map[string]int{"handle": 5, "main": 1},
+	}
+	if got, want := meta.Summary(), "server, run of 2026-10-14 09:30:00 UTC, 1.25s, 6 calls, tracewrap v1.2.3"; got != want {
+		t.Errorf("Summary:\ngot  %q\nwant %q", got, want)
+	}
+	if got, want := meta.StableSummary(), "server, 6 calls, tracewrap v1.2.3"; got != want {
+		t.Errorf("StableSummary:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestFlushWritesRunMetadata(t *testing.T) {
+	withTracer(t, config.Config{})
+	call("parent", func() { call("child", nil) })
+	tracer.RecordExecutionFrequency("child")
+	tracer.RecordExecutionFrequency("child")
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	data, err := os.ReadFile("tracewrap/latest/run.json")
+	if err != nil {
+		t.Fatalf("Failed to read run metadata: %v", err)
+	}
+	var meta tracer.RunMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("Failed to parse run metadata: %v", err)
+	}
+	if meta.Records != 2 || meta.GoVersion == "" || meta.PID != os.Getpid() {
+		t.Errorf("Unexpected run metadata: %+v", meta)
+	}
+	if meta.ExecFrequency["child"] != 2 {
+		t.Errorf("Expected the execution frequency in the metadata, got %v", meta.ExecFrequency)
+	}
+	if meta.RunID == "" || meta.RunID != tracer.RunID() {
+		t.Errorf("Expected run ID %q in the metadata, got %q", tracer.RunID(), meta.RunID)
+	}
+	if _, err := os.Stat(filepath.Join("tracewrap", meta.RunID, "trace.jsonl")); err != nil {
+		t.Errorf("Expected the trace in the run directory: %v", err)
+	}
+}
+
+func TestRunIDCanBeSetByEnvironment(t *testing.T) {
+	t.Setenv(tracer.RunIDEnv, "fixed-run")
+	withTracer(t, config.Config{})
+	call("work", nil)
+	if got := tracer.ArtifactPath("callgraph.dot"); got != filepath.Join("tracewrap", "fixed-run", "callgraph.dot") {
+		t.Errorf("Unexpected artifact path %q", got)
+	}
+	if target, err := os.Readlink(filepath.Join("tracewrap", "latest")); err != nil || target != "fixed-run" {
+		t.Errorf("Expected latest to link to fixed-run, got %q (%v)", target, err)
+	}
+}
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = orig }()
+	fn()
+	w.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read captured stdout: %v", err)
+	}
+	return string(data)
+}
+
+func TestLogMirrorsStdoutOnlyWhenConfigured(t *testing.T) {
+	for _, mirror := range []bool{false, true} {
+		withTracer(t, config.Config{Logging: config.LoggingConfig{MirrorStdout: mirror}})
+		out := captureStdout(t, func() { call("quiet", nil) })
+		if mirrored := strings.Contains(out, "Entering quiet"); mirrored != mirror {
+			t.Errorf("mirrorStdout=%v: unexpected stdout %q", mirror, out)
+		}
+		data, err := os.ReadFile("tracewrap/latest/tracewrap.log")
+		if err != nil || !strings.Contains(string(data), "Entering quiet") {
+			t.Errorf("mirrorStdout=%v: expected the log file to have the entry line (%v)", mirror, err)
+		}
+	}
+}
+
+func TestRecordsCountDirectCallees(t *testing.T) {
+	withTracer(t, config.Config{})
+	call("parent", func() {
+		call("load", func() { call("decode", nil) })
+		call("load", nil)
+		call("save", nil)
+	})
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	records, err := tracer.ReadTraceFile("tracewrap/latest/trace.jsonl")
+	if err != nil {
+		t.Fatalf("Failed to read trace: %v", err)
+	}
+	byName := make(map[string]tracer.TraceRecord)
+	for _, rec := range records {
+		if _, ok := byName[rec.FunctionName]; !ok {
+			byName[rec.FunctionName] = rec
+		}
+	}
+	parent := byName["parent"]
+	if parent.ChildCount != 3 || len(parent.Callees) != 2 {
+		t.Fatalf("Expected three children of two callees, got %d %v", parent.ChildCount, parent.Callees)
+	}
+	if load := parent.Callees["load"]; load.Calls != 2 || load.Duration <= 0 {
+		t.Errorf("Expected two timed calls to load, got %+v", load)
+	}
+	if first := byName["load"]; first.ChildCount != 1 || first.Callees["decode"].Calls != 1 {
+		t.Errorf("Expected the first load to have called decode, got %+v", first.Callees)
+	}
+	if save := byName["save"]; save.ChildCount != 0 || save.Callees != nil {
+		t.Errorf("Expected no callees for save, got %+v", save.Callees)
+	}
+}
@@ -0,0 +1,55 @@
+// Package version reports the version of tracewrap itself.
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version and Commit can be stamped at build time with
+//
+//	go build -ldflags "-X github.com/mwiater/tracewrap/pkg/version.Version=v1.2.3 -X github.com/mwiater/tracewrap/pkg/version.Commit=abc1234"
+//
+// When they are left empty, Get falls back to the module and VCS information recorded by the Go toolchain.
+var (
+	Version string
+	Commit  string
+)
+
+// Info describes a tracewrap build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the version information of the running tracewrap binary.
+//
+// Returns:
+//   - Info: the version, commit, and Go version. Version is "dev" for untagged local builds.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		if info.Commit == "" {
+			modified := false
+			for _, setting := range bi.Settings {
+				switch setting.Key {
+				case "vcs.revision":
+					info.Commit = setting.Value
+				case "vcs.modified":
+					modified = setting.Value == "true"
+				}
+			}
+			if info.Commit != "" && modified {
+				info.Commit += "-dirty"
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	return info
+}
@@ -0,0 +1,29 @@
+package version_test
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/mwiater/tracewrap/pkg/version"
+)
+
+func TestGetPrefersStampedValues(t *testing.T) {
+	oldVersion, oldCommit := version.Version, version.Commit
+	t.Cleanup(func() { version.Version, version.Commit = oldVersion, oldCommit })
+
+	version.Version, version.Commit = "v1.2.3", "abc1234"
+	info := version.Get()
+	if info.Version != "v1.2.3" || info.Commit != "abc1234" || info.GoVersion != runtime.Version() {
+		t.Errorf("Unexpected version info: %+v", info)
+	}
+}
+
+func TestGetDefaultsToDev(t *testing.T) {
+	oldVersion := version.Version
+	t.Cleanup(func() { version.Version = oldVersion })
+
+	version.Version = ""
+	if got := version.Get().Version; got == "" {
+		t.Error("Expected a non-empty version for unstamped builds")
+	}
+}
@@ -82,12 +82,12 @@ func TestSimpleExampleEndToEnd(t *testing.T) {
 	}
 
 	// Verify that the expected output files exist in the instrumented project.
-	logFilePath := filepath.Join(dstDir, "tracewrap", "tracewrap.log")
+	logFilePath := filepath.Join(dstDir, "tracewrap", "latest", "tracewrap.log")
 	if _, err := os.Stat(logFilePath); os.IsNotExist(err) {
 		t.Fatal("Expected log file does not exist:", logFilePath)
 	}
 
-	callGraphPath := filepath.Join(dstDir, "tracewrap", "callgraph.dot")
+	callGraphPath := filepath.Join(dstDir, "tracewrap", "latest", "callgraph.dot")
 	if _, err := os.Stat(callGraphPath); os.IsNotExist(err) {
 		t.Fatal("Expected call graph file does not exist:", callGraphPath)
 	}